@@ -1,30 +1,32 @@
 package elasticsearch
 
 import (
+	"chat/src/platform/validation"
+	"chat/src/util"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"math"
 	"net"
 	"net/http"
 	"time"
 
+	"github.com/creasty/defaults"
 	"github.com/elastic/go-elasticsearch/v9"
 	"github.com/rs/zerolog"
 )
 
 // @FIXME When a message is deleted, your application performs an update in Elasticsearch to add a deleted_at timestamp to the document.
 //   All search queries from your application must be modified to filter out these documents (e.g., must_not: { exists: { field: "deleted_at" } }).
-// @FIXME When storing messages, use ?_source_excludes=content parameter in the URL to avoid leaking cleartext content in _source field.
-//   Also, use message_id "https://es-coordinating-1:9200/chat-messages/_doc/${message_id}"
 
 var ErrAlreadyStarted = errors.New("elasticsearch client already started")
 
 type Client struct {
-	logger zerolog.Logger
-	config elasticsearch.Config
-	Driver *elasticsearch.Client
+	logger     zerolog.Logger
+	config     elasticsearch.Config
+	transport  *http.Transport
+	thresholds HealthThresholds
+	Driver     *elasticsearch.Client
 }
 
 type ClientLoggerOptions struct {
@@ -33,16 +35,24 @@ type ClientLoggerOptions struct {
 }
 
 type ClientOptions struct {
-	Logger       ClientLoggerOptions
-	TLSConfig    *tls.Config
-	Username     string
-	Password     string
-	Addresses    []string
-	ShouldLogReq bool
-	ShouldLogRes bool
+	Logger           ClientLoggerOptions
+	TLSConfig        *tls.Config
+	Username         string
+	Password         string
+	Addresses        []string
+	ShouldLogReq     bool
+	ShouldLogRes     bool
+	HealthThresholds HealthThresholds
 }
 
-func NewClient(options *ClientOptions) *Client {
+func NewClient(options *ClientOptions) (*Client, error) {
+	if err := defaults.Set(&options.HealthThresholds); err != nil {
+		return nil, fmt.Errorf("failed to set health thresholds defaults: %w", err)
+	}
+	if err := validation.Instance.Struct(&options.HealthThresholds); err != nil {
+		return nil, fmt.Errorf("failed to validate health thresholds: %w", validation.Aggregate(err))
+	}
+
 	// 1. Performance: Tune the underlying HTTP Transport
 	transport := &http.Transport{
 		TLSClientConfig:     options.TLSConfig,
@@ -61,6 +71,8 @@ func NewClient(options *ClientOptions) *Client {
 		shouldLogRequests:  options.ShouldLogReq,
 		shouldLogResponses: options.ShouldLogRes,
 	}
+	// a cluster blip fails many requests at once, so their retries are sampled to avoid a warn storm
+	retryLogger := options.Logger.Driver.Sample(&zerolog.BurstSampler{Burst: 5, Period: 10 * time.Second})
 
 	config := elasticsearch.Config{
 		// --- Connection ---
@@ -73,8 +85,9 @@ func NewClient(options *ClientOptions) *Client {
 		MaxRetries:    5,
 		RetryOnStatus: []int{429, 502, 503, 504}, // Add 429 for "Too Many Requests"
 		RetryBackoff: func(attempt int) time.Duration {
-			duration := time.Duration(math.Pow(2, float64(attempt))) * time.Second
-			options.Logger.Driver.Warn().Int("attempt", attempt).Dur("backoff_duration", duration).Msg("Elasticsearch request failed, backing off")
+			// Start at 500ms and double up to a max of 8s, so the 5 retries back off for at most ~20s in total
+			duration := util.ExponentialBackoff(attempt-1, 500*time.Millisecond, 8*time.Second)
+			retryLogger.Warn().Int("attempt", attempt).Dur("backoff_duration", duration).Msg("Elasticsearch request failed, backing off")
 			return duration
 		},
 
@@ -87,10 +100,12 @@ func NewClient(options *ClientOptions) *Client {
 	}
 
 	return &Client{
-		logger: options.Logger.Client,
-		config: config,
-		Driver: nil,
-	}
+		logger:     options.Logger.Client,
+		config:     config,
+		transport:  transport,
+		thresholds: options.HealthThresholds,
+		Driver:     nil,
+	}, nil
 }
 
 func (c *Client) Start(_ context.Context) error {
@@ -113,5 +128,7 @@ func (c *Client) Stop(_ context.Context) {
 		return
 	}
 
+	// the driver has no Close, the pooled connections of the transport it shares across restarts are closed here
+	c.transport.CloseIdleConnections()
 	c.Driver = nil
 }
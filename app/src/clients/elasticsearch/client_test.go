@@ -0,0 +1,104 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newConnCountingServer answers every request as a node would, counting the connections it holds open.
+func newConnCountingServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+
+	var open atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{}`)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			open.Add(-1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, &open
+}
+
+func waitForOpenConns(t *testing.T, open *atomic.Int64, want int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for open.Load() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("server holds %d open connections, want %d", open.Load(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStartStopCyclesDoNotLeakConnections(t *testing.T) {
+	server, open := newConnCountingServer(t)
+	client, err := NewClient(&ClientOptions{
+		Logger:    ClientLoggerOptions{Client: zerolog.Nop(), Driver: zerolog.Nop()},
+		Addresses: []string{server.URL},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	for cycle := range 5 {
+		if err := client.Start(context.Background()); err != nil {
+			t.Fatalf("cycle %d: Start() error = %v", cycle, err)
+		}
+		res, err := client.Driver.Info()
+		if err != nil {
+			t.Fatalf("cycle %d: Info() error = %v", cycle, err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		closeBody(res.Body)
+		if open.Load() == 0 {
+			t.Fatalf("cycle %d: expected the pooled connection to stay open until Stop", cycle)
+		}
+
+		client.Stop(context.Background())
+		if client.Driver != nil {
+			t.Fatalf("cycle %d: Driver is set after Stop", cycle)
+		}
+		waitForOpenConns(t, open, 0)
+	}
+}
+
+func TestStopBeforeStartIsNoop(t *testing.T) {
+	client, err := NewClient(&ClientOptions{Logger: ClientLoggerOptions{Client: zerolog.Nop(), Driver: zerolog.Nop()}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	client.Stop(context.Background())
+	client.Stop(context.Background())
+	if client.Driver != nil {
+		t.Fatal("Driver is set after Stop")
+	}
+}
+
+func TestStartTwiceFails(t *testing.T) {
+	server, _ := newConnCountingServer(t)
+	client := newStartedClient(t, server.URL)
+
+	if err := client.Start(context.Background()); !errors.Is(err, ErrAlreadyStarted) {
+		t.Fatalf("Start() error = %v, want %v", err, ErrAlreadyStarted)
+	}
+}
@@ -1,6 +1,7 @@
 package elasticsearch
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/health"
 	"context"
 	"encoding/json"
@@ -10,14 +11,18 @@ import (
 )
 
 const (
-	PingTargetName                      = "elasticsearch"
-	pingShallowAcceptableLatency        = 50 * time.Millisecond
-	pingDeepAcceptableLatency           = 150 * time.Millisecond
-	degradedNumberOfPendingTasks        = 100
-	degradedNumberOfInFlightFetch       = 50
-	degradedTaskMaxWaitingInQueueMillis = 2000
+	PingTargetName               = components.Elasticsearch
+	pingShallowAcceptableLatency = 50 * time.Millisecond
+	pingDeepAcceptableLatency    = 150 * time.Millisecond
 )
 
+// HealthThresholds are the values of the cluster health above which the deep ping reports the cluster as degraded.
+type HealthThresholds struct {
+	PendingTasks          int           `default:"100" validate:"min=1,max=100000"`
+	InFlightFetches       int           `default:"50" validate:"min=1,max=100000"`
+	TaskMaxWaitingInQueue time.Duration `default:"2s" validate:"min=100000000,max=600000000000"` // 100ms to 10min
+}
+
 type clusterHealthResponse struct {
 	ClusterName                 string  `json:"cluster_name"`
 	Status                      string  `json:"status"` // "green", "yellow", "red"
@@ -106,7 +111,14 @@ func (c *Client) PingDeep(ctx context.Context) health.PingResult {
 		return pingResult
 	}
 
-	pingCause := clusterHealthToPingCause(&clusterHealth)
+	pingResult.
+		WithDetail("status", clusterHealth.Status).
+		WithDetail("active_shards_percent", clusterHealth.ActiveShardsPercent).
+		WithDetail("unassigned_shards", clusterHealth.UnassignedShards).
+		WithDetail("relocating_shards", clusterHealth.RelocatingShards).
+		WithDetail("pending_tasks", clusterHealth.NumberOfPendingTasks)
+
+	pingCause := clusterHealthToPingCause(&clusterHealth, &c.thresholds)
 	if pingCause != health.PingCauseOk {
 		pingResult.SetPingOutput(
 			pingCause,
@@ -118,7 +130,7 @@ func (c *Client) PingDeep(ctx context.Context) health.PingResult {
 	return pingResult
 }
 
-func clusterHealthToPingCause(ch *clusterHealthResponse) health.PingCause {
+func clusterHealthToPingCause(ch *clusterHealthResponse, thresholds *HealthThresholds) health.PingCause {
 	if ch.TimedOut {
 		return health.PingCauseTimeout
 	}
@@ -131,9 +143,9 @@ func clusterHealthToPingCause(ch *clusterHealthResponse) health.PingCause {
 		ch.RelocatingShards > 0 ||
 		ch.InitializingShards > 0 ||
 		ch.DelayedUnassignedShards > 0 ||
-		ch.NumberOfPendingTasks > degradedNumberOfPendingTasks ||
-		ch.NumberOfInFlightFetch > degradedNumberOfInFlightFetch ||
-		ch.TaskMaxWaitingInQueueMillis > degradedTaskMaxWaitingInQueueMillis ||
+		ch.NumberOfPendingTasks > thresholds.PendingTasks ||
+		ch.NumberOfInFlightFetch > thresholds.InFlightFetches ||
+		ch.TaskMaxWaitingInQueueMillis > thresholds.TaskMaxWaitingInQueue.Milliseconds() ||
 		ch.ActiveShardsPercent < 100 ||
 		ch.UnassignedShards > 0 {
 
@@ -0,0 +1,121 @@
+package elasticsearch
+
+import (
+	"chat/src/platform/health"
+	"context"
+	"maps"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestPingDeepEmitsClusterHealthDetails(t *testing.T) {
+	server, _ := newCapturingServer(t, http.StatusOK, `{
+		"cluster_name": "chat",
+		"status": "yellow",
+		"active_shards_percent_as_number": 87.5,
+		"unassigned_shards": 2,
+		"relocating_shards": 1,
+		"number_of_pending_tasks": 3
+	}`)
+	client := newStartedClient(t, server.URL)
+
+	result := client.PingDeep(context.Background())
+
+	want := map[string]any{
+		"status":                "yellow",
+		"active_shards_percent": 87.5,
+		"unassigned_shards":     2,
+		"relocating_shards":     1,
+		"pending_tasks":         3,
+	}
+	if !maps.Equal(result.Data, want) {
+		t.Fatalf("PingDeep() details = %v, want %v", result.Data, want)
+	}
+	if result.Status != health.PingStatusDegraded {
+		t.Fatalf("PingDeep() status = %s, want %s for a yellow cluster: %s", result.Status, health.PingStatusDegraded, result.Details)
+	}
+}
+
+func TestCustomHealthThresholdsChangeClassification(t *testing.T) {
+	busy := clusterHealthResponse{
+		Status:                      "green",
+		ActiveShardsPercent:         100,
+		NumberOfPendingTasks:        150,
+		NumberOfInFlightFetch:       10,
+		TaskMaxWaitingInQueueMillis: 1000,
+	}
+	tests := []struct {
+		name       string
+		thresholds HealthThresholds
+		health     clusterHealthResponse
+		want       health.PingCause
+	}{
+		{name: "pending tasks over default threshold", health: busy, want: health.PingCauseOverloaded},
+		{
+			name:       "pending tasks under raised threshold",
+			thresholds: HealthThresholds{PendingTasks: 500},
+			health:     busy,
+			want:       health.PingCauseOk,
+		},
+		{
+			name:       "in flight fetches over lowered threshold",
+			thresholds: HealthThresholds{PendingTasks: 500, InFlightFetches: 5},
+			health:     busy,
+			want:       health.PingCauseOverloaded,
+		},
+		{
+			name:       "queue wait over lowered threshold",
+			thresholds: HealthThresholds{PendingTasks: 500, TaskMaxWaitingInQueue: 500 * time.Millisecond},
+			health:     busy,
+			want:       health.PingCauseOverloaded,
+		},
+		{
+			name:       "red cluster regardless of thresholds",
+			thresholds: HealthThresholds{PendingTasks: 100000},
+			health:     clusterHealthResponse{Status: "red", ActiveShardsPercent: 100},
+			want:       health.PingCauseBadState,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(&ClientOptions{
+				Logger:           ClientLoggerOptions{Client: zerolog.Nop(), Driver: zerolog.Nop()},
+				Addresses:        []string{"http://127.0.0.1:1"},
+				HealthThresholds: tt.thresholds,
+			})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			if got := clusterHealthToPingCause(&tt.health, &client.thresholds); got != tt.want {
+				t.Fatalf("clusterHealthToPingCause() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClientRejectsInvalidHealthThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds HealthThresholds
+	}{
+		{name: "too many pending tasks", thresholds: HealthThresholds{PendingTasks: 1_000_000}},
+		{name: "too many in flight fetches", thresholds: HealthThresholds{InFlightFetches: 1_000_000}},
+		{name: "queue wait too short", thresholds: HealthThresholds{TaskMaxWaitingInQueue: time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(&ClientOptions{
+				Logger:           ClientLoggerOptions{Client: zerolog.Nop(), Driver: zerolog.Nop()},
+				Addresses:        []string{"http://127.0.0.1:1"},
+				HealthThresholds: tt.thresholds,
+			})
+			if err == nil {
+				t.Fatalf("NewClient() error = nil, want thresholds %+v to be rejected", tt.thresholds)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MessagesIndex          = "chat-messages"
+	MaxMessageContentBytes = 64 * 1024
+
+	messageContentField = "content"
+)
+
+var (
+	ErrMessageTooLarge  = errors.New("message content exceeds the maximum size")
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrInvalidMessageID = errors.New("message id must not be empty")
+)
+
+type MessageDocument struct {
+	MessageID      string    `json:"message_id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	Content        string    `json:"content,omitempty"`
+	SentAt         time.Time `json:"sent_at"`
+}
+
+// messagesIndexBody excludes the content from the stored _source, while it is still analyzed and indexed,
+// so messages can be searched by their content without the cleartext being returned by any API.
+const messagesIndexBody = `{
+  "mappings": {
+    "_source": { "excludes": ["content"] },
+    "properties": {
+      "message_id":      { "type": "keyword" },
+      "conversation_id": { "type": "keyword" },
+      "sender_id":       { "type": "keyword" },
+      "content":         { "type": "text" },
+      "sent_at":         { "type": "date" },
+      "deleted_at":      { "type": "date" }
+    }
+  }
+}`
+
+// MessageDocumentPath returns the path of a message document, with the content excluded from the returned _source.
+// The exclusion is enforced by the index mapping too, in the URL it also covers documents indexed before it.
+func MessageDocumentPath(index, messageID string) string {
+	query := url.Values{"_source_excludes": []string{messageContentField}}
+	return "/" + url.PathEscape(index) + "/_doc/" + url.PathEscape(messageID) + "?" + query.Encode()
+}
+
+// EnsureMessagesIndex creates the messages index with the mapping excluding the content from _source, if missing.
+func (c *Client) EnsureMessagesIndex(ctx context.Context) error {
+	res, err := c.Driver.Indices.Exists([]string{MessagesIndex}, c.Driver.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to check existence of index '%s': %w", MessagesIndex, err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	res, err = c.Driver.Indices.Create(
+		MessagesIndex,
+		c.Driver.Indices.Create.WithContext(ctx),
+		c.Driver.Indices.Create.WithBody(strings.NewReader(messagesIndexBody)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create index '%s': %w", MessagesIndex, err)
+	}
+	defer closeBody(res.Body)
+	if res.IsError() {
+		return fmt.Errorf("failed to create index '%s': %s", MessagesIndex, res.String())
+	}
+	return nil
+}
+
+// IndexMessage stores the message under its id, so redeliveries overwrite the same document.
+func (c *Client) IndexMessage(ctx context.Context, message *MessageDocument) error {
+	if message.MessageID == "" {
+		return ErrInvalidMessageID
+	}
+	if len(message.Content) > MaxMessageContentBytes {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed", ErrMessageTooLarge, len(message.Content), MaxMessageContentBytes)
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message '%s': %w", message.MessageID, err)
+	}
+
+	res, err := c.Driver.Index(
+		MessagesIndex,
+		bytes.NewReader(body),
+		c.Driver.Index.WithContext(ctx),
+		c.Driver.Index.WithDocumentID(message.MessageID),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to index message '%s': %w", message.MessageID, err)
+	}
+	defer closeBody(res.Body)
+	if res.IsError() {
+		return fmt.Errorf("failed to index message '%s': %s", message.MessageID, res.String())
+	}
+	return nil
+}
+
+// GetMessage returns the stored message, its content is never part of the response.
+func (c *Client) GetMessage(ctx context.Context, messageID string) (*MessageDocument, error) {
+	if messageID == "" {
+		return nil, ErrInvalidMessageID
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, MessageDocumentPath(MessagesIndex, messageID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request for message '%s': %w", messageID, err)
+	}
+	res, err := c.Driver.Perform(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message '%s': %w", messageID, err)
+	}
+	defer closeBody(res.Body)
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: '%s'", ErrMessageNotFound, messageID)
+	case res.StatusCode >= http.StatusBadRequest:
+		raw, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("failed to get message '%s': status %d: %s", messageID, res.StatusCode, raw)
+	}
+
+	var document struct {
+		Source MessageDocument `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to decode message '%s': %w", messageID, err)
+	}
+	return &document.Source, nil
+}
+
+func closeBody(body io.ReadCloser) {
+	if body != nil {
+		_ = body.Close()
+	}
+}
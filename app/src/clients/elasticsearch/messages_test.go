@@ -0,0 +1,240 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// addressEnv points the tests needing a real node to it, i.e. http://127.0.0.1:9200. They are skipped without it.
+const addressEnv = "CHAT_TEST_ELASTICSEARCH_URL"
+
+func newStartedClient(t *testing.T, address string) *Client {
+	t.Helper()
+
+	client, err := NewClient(&ClientOptions{
+		Logger:    ClientLoggerOptions{Client: zerolog.Nop(), Driver: zerolog.Nop()},
+		Addresses: []string{address},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { client.Stop(context.Background()) })
+	return client
+}
+
+// capturedRequest is a request received by the server standing in for the node.
+type capturedRequest struct {
+	method string
+	uri    string
+	body   []byte
+}
+
+// newCapturingServer answers every request with the body, recording the requests it receives.
+func newCapturingServer(t *testing.T, status int, body string) (*httptest.Server, func() []capturedRequest) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var requests []capturedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, capturedRequest{method: r.Method, uri: r.URL.RequestURI(), body: raw})
+		mutex.Unlock()
+
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []capturedRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]capturedRequest(nil), requests...)
+	}
+}
+
+func TestMessageDocumentPathExcludesContent(t *testing.T) {
+	got := MessageDocumentPath("chat messages", "a/b")
+	if want := "/chat%20messages/_doc/a%2Fb?_source_excludes=content"; got != want {
+		t.Fatalf("MessageDocumentPath() = %q, want %q", got, want)
+	}
+}
+
+func TestMessagesIndexMappingExcludesContentFromSource(t *testing.T) {
+	var body struct {
+		Mappings struct {
+			Source struct {
+				Excludes []string `json:"excludes"`
+			} `json:"_source"`
+			Properties map[string]struct {
+				Type string `json:"type"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(messagesIndexBody), &body); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if excludes := body.Mappings.Source.Excludes; len(excludes) != 1 || excludes[0] != messageContentField {
+		t.Errorf("_source excludes = %v, want [%s]", excludes, messageContentField)
+	}
+	if typ := body.Mappings.Properties[messageContentField].Type; typ != "text" {
+		t.Errorf("content mapped as %q, want it to be indexed as text", typ)
+	}
+}
+
+func TestIndexMessageRejectsInvalidMessage(t *testing.T) {
+	client := &Client{} // the message is rejected before the node is reached
+
+	tests := []struct {
+		name    string
+		message MessageDocument
+		want    error
+	}{
+		{name: "missing id", message: MessageDocument{Content: "hello"}, want: ErrInvalidMessageID},
+		{
+			name:    "content too large",
+			message: MessageDocument{MessageID: "m1", Content: strings.Repeat("a", MaxMessageContentBytes+1)},
+			want:    ErrMessageTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := client.IndexMessage(context.Background(), &tt.message); !errors.Is(err, tt.want) {
+				t.Fatalf("IndexMessage() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexMessageSendsContentToBeIndexed(t *testing.T) {
+	server, requests := newCapturingServer(t, http.StatusCreated, `{"result":"created"}`)
+	client := newStartedClient(t, server.URL)
+
+	message := &MessageDocument{MessageID: "m1", ConversationID: "c1", SenderID: "alice", Content: "hello there"}
+	if err := client.IndexMessage(context.Background(), message); err != nil {
+		t.Fatalf("IndexMessage() error = %v", err)
+	}
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("server received %d requests, want 1", len(got))
+	}
+	if got[0].method != http.MethodPut || !strings.HasPrefix(got[0].uri, "/"+MessagesIndex+"/_doc/m1") {
+		t.Errorf("request = %s %s, want the document to be put under its id", got[0].method, got[0].uri)
+	}
+}
+
+func TestGetMessageRequestsSourceWithoutContent(t *testing.T) {
+	server, requests := newCapturingServer(t, http.StatusOK,
+		`{"_id":"m1","found":true,"_source":{"message_id":"m1","conversation_id":"c1","sender_id":"alice"}}`)
+	client := newStartedClient(t, server.URL)
+
+	message, err := client.GetMessage(context.Background(), "m1")
+	if err != nil {
+		t.Fatalf("GetMessage() error = %v", err)
+	}
+	if message.MessageID != "m1" || message.SenderID != "alice" || message.Content != "" {
+		t.Errorf("GetMessage() = %+v, want the stored message without content", message)
+	}
+	if got := requests(); len(got) != 1 || got[0].uri != MessageDocumentPath(MessagesIndex, "m1") {
+		t.Errorf("requests = %+v, want the document requested with the content excluded", got)
+	}
+}
+
+func TestGetMessageReportsMissingMessage(t *testing.T) {
+	server, _ := newCapturingServer(t, http.StatusNotFound, `{"_id":"m1","found":false}`)
+	client := newStartedClient(t, server.URL)
+
+	if _, err := client.GetMessage(context.Background(), "m1"); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("GetMessage() error = %v, want %v", err, ErrMessageNotFound)
+	}
+}
+
+func TestStoredMessageOmitsContentYetIsSearchable(t *testing.T) {
+	address := os.Getenv(addressEnv)
+	if address == "" {
+		t.Skipf("%s isn't set", addressEnv)
+	}
+	client := newStartedClient(t, address)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := client.EnsureMessagesIndex(ctx); err != nil {
+		t.Fatalf("EnsureMessagesIndex() error = %v", err)
+	}
+	term := "needle" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	message := &MessageDocument{
+		MessageID:      "test-" + term,
+		ConversationID: "c1",
+		SenderID:       "alice",
+		Content:        "a message with the " + term + " inside",
+		SentAt:         time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := client.IndexMessage(ctx, message); err != nil {
+		t.Fatalf("IndexMessage() error = %v", err)
+	}
+	res, err := client.Driver.Indices.Refresh(client.Driver.Indices.Refresh.WithIndex(MessagesIndex))
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	closeBody(res.Body)
+
+	stored, err := client.GetMessage(ctx, message.MessageID)
+	if err != nil {
+		t.Fatalf("GetMessage() error = %v", err)
+	}
+	if stored.MessageID != message.MessageID || stored.Content != "" {
+		t.Fatalf("GetMessage() = %+v, want the stored message without content", stored)
+	}
+
+	// the _source stored by the mapping lacks the content even when it isn't excluded by the request
+	res, err = client.Driver.Get(MessagesIndex, message.MessageID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	raw, _ := io.ReadAll(res.Body)
+	closeBody(res.Body)
+	if strings.Contains(string(raw), term) {
+		t.Fatalf("stored _source contains the content: %s", raw)
+	}
+
+	query := `{"query":{"match":{"content":"` + term + `"}}}`
+	res, err = client.Driver.Search(
+		client.Driver.Search.WithIndex(MessagesIndex),
+		client.Driver.Search.WithBody(strings.NewReader(query)),
+	)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	defer closeBody(res.Body)
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode search response: %v", err)
+	}
+	if hits := result.Hits.Hits; len(hits) != 1 || hits[0].ID != message.MessageID {
+		t.Fatalf("search hits = %+v, want the message to be found by its content", hits)
+	}
+}
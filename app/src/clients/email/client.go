@@ -1,6 +1,7 @@
 package email
 
 import (
+	"chat/src/platform/health"
 	"context"
 	"fmt"
 )
@@ -13,10 +14,12 @@ type ClientOptions struct {
 	WorkerPoolOptions WorkerPoolOptions
 }
 
-func NewClient(options *ClientOptions) *Client {
-	return &Client{
-		pool: newWorkerPool(options.WorkerPoolOptions),
+func NewClient(options *ClientOptions) (*Client, error) {
+	pool, err := newWorkerPool(options.WorkerPoolOptions)
+	if err != nil {
+		return nil, fmt.Errorf("creating worker pool failed: %w", err)
 	}
+	return &Client{pool: pool}, nil
 }
 
 func (c *Client) Start(ctx context.Context) error {
@@ -30,9 +33,46 @@ func (c *Client) Stop(_ context.Context) {
 	c.pool.Stop()
 }
 
+func (c *Client) Stats() PoolStats {
+	return c.pool.Stats()
+}
+
+// Healthy checks that every worker of the pool holds a working SMTP session.
+func (c *Client) Healthy(ctx context.Context) error {
+	return c.pool.Healthy(ctx)
+}
+
+// SendErrors returns the rate of the retriable send failures of the pool.
+func (c *Client) SendErrors() *health.ErrorRateTracker {
+	return c.pool.sendErrors
+}
+
 func (c *Client) Send(request Request) error {
 	if err := c.pool.Submit(request); err != nil {
 		return fmt.Errorf("submitting email request to worker pool failed: %w", err)
 	}
 	return nil
 }
+
+// TrySendBatch behaves like TrySend, but the emails are sent by a single worker over its SMTP session, with their
+// envelope pipelined when the server supports PIPELINING. The outcome of each email is sent in order on the
+// returned channel once the batch is sent.
+func (c *Client) TrySendBatch(emails []SendEmailOptions) (<-chan []error, error) {
+	batch := &batchRequest{
+		emails:   emails,
+		response: make(chan []error, 1),
+	}
+	if err := c.pool.TrySubmit(Request{batch: batch}); err != nil {
+		return nil, fmt.Errorf("submitting email batch to worker pool without blocking failed: %w", err)
+	}
+	return batch.response, nil
+}
+
+// TrySend queues the request without blocking, ErrQueueFull is returned when the queue of the pool is full.
+// The outcome of the send is reported on the Response of the request, when set.
+func (c *Client) TrySend(request Request) error {
+	if err := c.pool.TrySubmit(request); err != nil {
+		return fmt.Errorf("submitting email request to worker pool without blocking failed: %w", err)
+	}
+	return nil
+}
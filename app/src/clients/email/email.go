@@ -1,6 +1,7 @@
 package email
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"net"
 	netmail "net/mail"
+	"net/textproto"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +22,8 @@ import (
 
 var ErrSendEmailInvalidSenderCount = errors.New("email can't be sent because it has invalid sender count")
 var ErrSendEmailInvalidReceiverCount = errors.New("email can't be sent because it has no receivers")
+var ErrSendEmailTooLarge = errors.New("email can't be sent because it exceeds the max message size")
+var ErrSendEmail8BitUnsupported = errors.New("email can't be sent because it has 8bit parts and server lacks 8BITMIME")
 
 var smtpExtensions = []string{
 	"PIPELINING",
@@ -37,6 +41,8 @@ var smtpCapabilitiesLogged sync.Map // map[string]struct{}
 
 type smtpClient struct {
 	driver *smtp.Client
+	conn   *sessionConn    // connection of driver, used for commands driver doesn't implement
+	text   *textproto.Conn // writes commands directly on conn and reads their replies (i.e. BDAT)
 	opts   *SMTPClientOptions
 }
 
@@ -49,6 +55,9 @@ type SMTPClientOptions struct {
 	CommandTimeout    time.Duration
 	SubmissionTimeout time.Duration
 	SendTimeout       time.Duration
+	MaxMessageSize    int // bytes, the lower of this and server advertised SIZE applies
+	ChunkingThreshold int // bytes, messages this large are sent with BDAT when server supports CHUNKING, 0 disables BDAT
+	ChunkSize         int // bytes, size of each BDAT chunk
 	Logger            *zerolog.Logger
 }
 
@@ -87,11 +96,21 @@ func (c *smtpClient) Connect(ctx context.Context) error {
 		)
 	}
 
-	client := smtp.NewClient(tlsConn)
+	// the driver doesn't take a context, so AUTH and the capabilities probe are bounded by closing the connection
+	// when the context is done, which unblocks the pending read or write
+	stopWatchdog := context.AfterFunc(ctx, func() {
+		_ = tcpConn.Close()
+	})
+
+	conn := newSessionConn(tlsConn)
+	client := smtp.NewClient(conn)
 	client.SubmissionTimeout = c.opts.SubmissionTimeout
 	client.CommandTimeout = c.opts.CommandTimeout
 
 	if err := client.Auth(c.opts.Auth); err != nil {
+		if !stopWatchdog() {
+			err = fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
 		tlsStatus := rollback(tlsConn)
 		tcpStatus := rollback(tcpConn)
 		return fmt.Errorf(
@@ -122,7 +141,14 @@ func (c *smtpClient) Connect(ctx context.Context) error {
 		}
 	}
 
+	if !stopWatchdog() {
+		_ = client.Close()
+		return fmt.Errorf("failed to connect to '%s', context done while probing capabilities: %w", address, ctx.Err())
+	}
+
 	c.driver = client
+	c.conn = conn
+	c.text = textproto.NewConn(conn)
 	return nil
 }
 
@@ -133,6 +159,8 @@ func (c *smtpClient) Disconnect() error {
 
 	driver := c.driver
 	c.driver = nil
+	c.conn = nil
+	c.text = nil
 
 	if err := driver.Close(); err != nil {
 		return fmt.Errorf("failed to close SMTP client: %w", err)
@@ -141,12 +169,23 @@ func (c *smtpClient) Disconnect() error {
 }
 
 func (c *smtpClient) SendEmail(ctx context.Context, opts SendEmailOptions) error {
+	if err := c.ensureConnected(); err != nil {
+		return err
+	}
+
+	// Envelope
+	mailOptions, body, err := c.prepareEnvelope(opts)
+	if err != nil {
+		return err
+	}
+
 	// From
 	senders := opts.Email.GetFrom()
 	if len(senders) != 1 {
 		return fmt.Errorf("expected exactly one sender, got %d: %w", len(senders), ErrSendEmailInvalidSenderCount)
 	}
-	if err := c.driver.Mail(senders[0].Address, opts.SendOptions); err != nil {
+	if err := c.driver.Mail(senders[0].Address, mailOptions); err != nil {
+		c.recoverSession(err, "MAIL FROM '"+senders[0].Address+"'")
 		return fmt.Errorf("MAIL FROM '%s' failed: %w", senders[0].Address, err)
 	}
 
@@ -180,13 +219,17 @@ func (c *smtpClient) SendEmail(ctx context.Context, opts SendEmailOptions) error
 	}
 
 	// Body
+	if c.shouldUseChunking(body.Len()) {
+		return c.bdat(ctx, body.Bytes())
+	}
+
 	dataCommand, err := c.driver.Data()
 	if err != nil {
 		c.reconnect() // connection state may be invalid, try to reconnect
 		return fmt.Errorf("DATA failed: %w", err)
 	}
 
-	if _, err := opts.Email.WriteTo(dataCommand); err != nil {
+	if _, err := body.WriteTo(dataCommand); err != nil {
 		status := rollback(dataCommand)
 		c.reconnect() // connection state may be invalid, try to reconnect
 		return fmt.Errorf("failed to write email body (rollback DATA command status '%s'): %w", status, err)
@@ -201,12 +244,125 @@ func (c *smtpClient) SendEmail(ctx context.Context, opts SendEmailOptions) error
 	return nil
 }
 
+// prepareEnvelope adapts the message to server capabilities and renders it, so its size is known upfront.
+func (c *smtpClient) prepareEnvelope(opts SendEmailOptions) (*smtp.MailOptions, *bytes.Buffer, error) {
+	if c.driver == nil {
+		return nil, nil, ErrSMTPNotConnected
+	}
+
+	var mailOptions smtp.MailOptions
+	if opts.SendOptions != nil {
+		mailOptions = *opts.SendOptions
+	}
+
+	if hasInternationalAddresses(opts.Email) {
+		if supported, _ := c.driver.Extension("SMTPUTF8"); supported {
+			mailOptions.UTF8 = true
+		} else if err := asciiEncodeAddresses(opts.Email); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var body bytes.Buffer
+	if _, err := opts.Email.WriteTo(&body); err != nil {
+		return nil, nil, fmt.Errorf("failed to render email body: %w", err)
+	}
+
+	maxSize := c.opts.MaxMessageSize
+	if serverMaxSize, ok := c.driver.MaxMessageSize(); ok && serverMaxSize > 0 && (maxSize <= 0 || serverMaxSize < maxSize) {
+		maxSize = serverMaxSize
+	}
+	if maxSize > 0 && body.Len() > maxSize {
+		return nil, nil, fmt.Errorf("email has %d bytes, max allowed is %d: %w", body.Len(), maxSize, ErrSendEmailTooLarge)
+	}
+	mailOptions.Size = int64(body.Len())
+
+	// parts are 8bit only when configured so, the server must accept them (RFC 6152)
+	if has8BitParts(opts.Email) {
+		if supported, _ := c.driver.Extension("8BITMIME"); !supported {
+			return nil, nil, ErrSendEmail8BitUnsupported
+		}
+		mailOptions.Body = smtp.Body8BitMIME
+	}
+
+	return &mailOptions, &body, nil
+}
+
+// has8BitParts reports whether any part of the message was built with the 8bit transfer encoding, attachments
+// and embeds being always base64 encoded.
+func has8BitParts(message *mail.Msg) bool {
+	for _, part := range message.GetParts() {
+		if part.GetEncoding() == mail.NoEncoding {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *smtpClient) shouldUseChunking(size int) bool {
+	if c.opts.ChunkingThreshold <= 0 || size < c.opts.ChunkingThreshold {
+		return false
+	}
+	supported, _ := c.driver.Extension("CHUNKING")
+	return supported
+}
+
+// bdat sends the body with BDAT commands (RFC 3030), which don't need dot-stuffing and are safe for binary content.
+// The driver doesn't implement BDAT, so the commands are written on the session connection. This is safe because
+// driver has no outstanding replies after RCPT TO, all BDAT replies are read before handing the session back, and
+// the session connection keeps driver from buffering any of them.
+func (c *smtpClient) bdat(ctx context.Context, body []byte) error {
+	chunkSize := c.opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(body)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			c.reconnect() // connection state may be invalid, try to reconnect
+			return fmt.Errorf("failed to set BDAT deadline: %w", err)
+		}
+		defer c.conn.SetDeadline(time.Time{}) //nolint:errcheck // connection is reconnected on the next failure anyway
+	}
+
+	for offset := 0; ; offset += chunkSize {
+		chunk := body[offset:min(offset+chunkSize, len(body))]
+		last := offset+chunkSize >= len(body)
+
+		command := fmt.Sprintf("BDAT %d\r\n", len(chunk))
+		if last {
+			command = fmt.Sprintf("BDAT %d LAST\r\n", len(chunk))
+		}
+
+		if _, err := c.text.W.WriteString(command); err != nil {
+			c.reconnect() // connection state may be invalid, try to reconnect
+			return fmt.Errorf("BDAT at offset %d failed: %w", offset, err)
+		}
+		if _, err := c.text.W.Write(chunk); err != nil {
+			c.reconnect() // connection state may be invalid, try to reconnect
+			return fmt.Errorf("failed to write BDAT chunk at offset %d: %w", offset, err)
+		}
+		if err := c.text.W.Flush(); err != nil {
+			c.reconnect() // connection state may be invalid, try to reconnect
+			return fmt.Errorf("failed to flush BDAT chunk at offset %d: %w", offset, err)
+		}
+		if _, _, err := c.text.ReadResponse(250); err != nil {
+			c.reconnect() // connection state may be invalid, try to reconnect
+			return fmt.Errorf("BDAT chunk at offset %d rejected: %w", offset, err)
+		}
+
+		if last {
+			break
+		}
+	}
+
+	return nil
+}
+
 func (c *smtpClient) rcpt(ctx context.Context, addresses []*netmail.Address, opts *smtp.RcptOptions) (int, error) {
 	for _, address := range addresses {
 		if err := c.driver.Rcpt(address.Address, opts); err != nil {
-			if err := c.driver.Reset(); err != nil {
-				c.opts.Logger.Warn().Err(err).Msgf("failed to reset SMTP client after RCPT TO '%s' failure", address)
-			}
+			c.recoverSession(err, "RCPT TO '"+address.Address+"'")
 			return 0, fmt.Errorf("RCPT TO '%s' failed: %w", address.Address, err)
 		}
 
@@ -224,6 +380,61 @@ func (c *smtpClient) rcpt(ctx context.Context, addresses []*netmail.Address, opt
 	return len(addresses), nil
 }
 
+// recoverSession prepares the connection for the next send after a failed envelope command. The transaction is
+// reset, unless the server is closing the session (421) or the connection broke, in which case it is reconnected,
+// as resetting it would only fail and leave the next send with a dead connection.
+func (c *smtpClient) recoverSession(err error, command string) {
+	if isSessionTerminated(err) {
+		c.opts.Logger.Warn().Err(err).Msgf("SMTP session terminated during %s, reconnecting", command)
+		c.reconnect()
+		return
+	}
+	if err := c.driver.Reset(); err != nil {
+		c.opts.Logger.Warn().Err(err).Msgf("failed to reset SMTP client after %s failure, reconnecting", command)
+		c.reconnect()
+	}
+}
+
+// isSessionTerminated reports whether the server closed the session (421) or the connection failed.
+func isSessionTerminated(err error) bool {
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code == 421
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed)
+}
+
+// IsRetriable reports whether sending the email can succeed later: transient negative replies (4xx, including 421)
+// and connection failures are retriable, permanent ones (5xx) and invalid emails are not.
+func IsRetriable(err error) bool {
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Temporary()
+	}
+	return isSessionTerminated(err) || errors.Is(err, ErrSMTPNotConnected) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// ensureConnected reconnects the client when a previous reconnect failed, which left it without a driver.
+// ErrSMTPNotConnected is returned when it fails again, so the send is retried later instead of being lost.
+func (c *smtpClient) ensureConnected() error {
+	if c.driver == nil {
+		c.reconnect()
+		if c.driver == nil {
+			return ErrSMTPNotConnected
+		}
+	}
+	return nil
+}
+
+// noop checks the session of the client, reconnecting it first when a previous reconnect failed.
+func (c *smtpClient) noop() error {
+	if err := c.ensureConnected(); err != nil {
+		return err
+	}
+	return c.driver.Noop() //nolint:wrapcheck // upper layer will handle wrapping
+}
+
 func (c *smtpClient) reconnect() {
 	if err := c.Disconnect(); err != nil {
 		c.opts.Logger.Error().Err(err).Msg("failed to disconnect SMTP client during reconnect")
@@ -0,0 +1,231 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"chat/src/clients/email/emailtest"
+	"chat/src/platform/security/securitytest"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/rs/zerolog"
+	"github.com/wneessen/go-mail"
+)
+
+var errServiceClosing = &smtp.SMTPError{Code: 421, EnhancedCode: smtp.EnhancedCode{4, 3, 2}, Message: "Service closing"}
+
+func TestSendEmailReconnectsAfterServiceClosing(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	var rejected atomic.Bool
+	fake.OnMail = func(string) error {
+		if rejected.CompareAndSwap(false, true) {
+			return errServiceClosing
+		}
+		return nil
+	}
+	client := newConnectedClient(t, fake)
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+
+	err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, "alice@example.com")})
+	if err == nil || !IsRetriable(err) {
+		t.Fatalf("SendEmail() error = %v, want a retriable 421", err)
+	}
+	if sessions := fake.SessionCount(); sessions != 2 {
+		t.Fatalf("sessions = %d, want the client to reconnect", sessions)
+	}
+
+	if err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, "alice@example.com")}); err != nil {
+		t.Fatalf("SendEmail() after reconnect error = %v", err)
+	}
+	if received := fake.Received(); len(received) != 1 || received[0].Recipients[0] != "alice@example.com" {
+		t.Errorf("received = %+v, want the email sent over the new session", received)
+	}
+}
+
+func TestSendEmailAfterFailedReconnectReturnsRetriableError(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	fake.OnMail = func(string) error {
+		fake.StopListening() // the reconnect following the 421 is refused
+		return errServiceClosing
+	}
+	client := newConnectedClient(t, fake)
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+
+	if err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, "alice@example.com")}); !IsRetriable(err) {
+		t.Fatalf("SendEmail() error = %v, want a retriable 421", err)
+	}
+	if client.driver != nil {
+		t.Fatal("driver is set, want the failed reconnect to leave the client disconnected")
+	}
+
+	err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, "alice@example.com")})
+	if !errors.Is(err, ErrSMTPNotConnected) || !IsRetriable(err) {
+		t.Errorf("SendEmail() while disconnected error = %v, want retriable %v", err, ErrSMTPNotConnected)
+	}
+	if err := client.sendBatched(ctx, SendEmailOptions{Email: newTestEmail(t, "alice@example.com")}); !errors.Is(err, ErrSMTPNotConnected) {
+		t.Errorf("sendBatched() while disconnected error = %v, want %v", err, ErrSMTPNotConnected)
+	}
+	if err := client.noop(); !errors.Is(err, ErrSMTPNotConnected) {
+		t.Errorf("noop() while disconnected error = %v, want %v", err, ErrSMTPNotConnected)
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "service closing", err: errServiceClosing, want: true},
+		{name: "mailbox busy", err: &smtp.SMTPError{Code: 450}, want: true},
+		{name: "unknown recipient", err: &smtp.SMTPError{Code: 550}, want: false},
+		{name: "not connected", err: ErrSMTPNotConnected, want: true},
+		{name: "too large", err: ErrSendEmailTooLarge, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetriable(tt.err); got != tt.want {
+				t.Errorf("IsRetriable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareEnvelopeDerives8BitMIMEFromPartEncodings(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	client := newConnectedClient(t, fake)
+
+	eightBit := newTestEmail(t, "alice@example.com")
+	eightBit.GetParts()[0].SetEncoding(mail.NoEncoding)
+	quoted := newTestEmail(t, "alice@example.com")
+	// a body quoting the header of an 8bit part doesn't make the message 8bit
+	quoted.SetBodyString(mail.TypeTextPlain, "Content-Transfer-Encoding: 8bit", mail.WithPartEncoding(mail.EncodingQP))
+
+	tests := []struct {
+		name    string
+		message *mail.Msg
+		want    smtp.BodyType
+	}{
+		{name: "8bit part", message: eightBit, want: smtp.Body8BitMIME},
+		{name: "quoted-printable part quoting 8bit header", message: quoted, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, _, err := client.prepareEnvelope(SendEmailOptions{Email: tt.message})
+			if err != nil {
+				t.Fatalf("prepareEnvelope() error = %v", err)
+			}
+			if options.Body != tt.want {
+				t.Errorf("BODY = %q, want %q", options.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendEmailDeclares8BitBody(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	client := newConnectedClient(t, fake)
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+
+	message := newTestEmail(t, "alice@example.com")
+	message.SetBodyString(mail.TypeTextPlain, "Héllo there", mail.WithPartEncoding(mail.NoEncoding))
+	if err := client.SendEmail(ctx, SendEmailOptions{Email: message}); err != nil {
+		t.Fatalf("SendEmail() error = %v", err)
+	}
+	received := fake.Received()
+	if len(received) != 1 || received[0].Options.Body != smtp.Body8BitMIME {
+		t.Fatalf("received = %+v, want one message declared BODY=8BITMIME", received)
+	}
+	if !bytes.Contains(received[0].Data, []byte("Héllo there")) {
+		t.Errorf("received body lost its 8bit content:\n%s", received[0].Data)
+	}
+}
+
+// newStallingRelay returns the options of a client of a relay which greets and answers EHLO, then never answers
+// AUTH. The relay connections are closed on cleanup.
+func newStallingRelay(t *testing.T) *SMTPClientOptions {
+	t.Helper()
+
+	serverTLS, clientTLS := securitytest.NewTLSConfigs(t, "127.0.0.1")
+	clientTLS.ServerName = "127.0.0.1"
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	stalled := make(chan struct{})
+	t.Cleanup(func() {
+		close(stalled)
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				reader := bufio.NewReader(conn)
+				_, _ = io.WriteString(conn, "220 relay.test ESMTP\r\n")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.HasPrefix(strings.ToUpper(line), "EHLO") {
+						_, _ = io.WriteString(conn, "250-relay.test\r\n250 AUTH PLAIN\r\n")
+						continue
+					}
+					<-stalled // AUTH is never answered
+					return
+				}
+			}()
+		}
+	}()
+
+	logger := zerolog.Nop()
+	return &SMTPClientOptions{
+		Host:              "127.0.0.1",
+		Port:              listener.Addr().(*net.TCPAddr).AddrPort().Port(),
+		TLSConfig:         clientTLS,
+		Auth:              sasl.NewPlainClient("", "user", "password"),
+		ReconnectTimeout:  time.Minute,
+		CommandTimeout:    time.Minute, // longer than the test, so only the context bounds AUTH
+		SubmissionTimeout: time.Minute,
+		SendTimeout:       time.Minute,
+		Logger:            &logger,
+	}
+}
+
+func TestConnectHonorsDeadlineWhenRelayStallsDuringAuth(t *testing.T) {
+	client := newSMTPClient(newStallingRelay(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.Connect(ctx)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Connect() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Fatalf("Connect() returned after %v, want it bounded by the context deadline", elapsed)
+	}
+	if client.driver != nil {
+		t.Fatal("Connect() kept the session of the failed connection")
+	}
+}
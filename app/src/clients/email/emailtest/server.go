@@ -0,0 +1,193 @@
+// Package emailtest provides the SMTP server of the tests of the clients and services sending emails.
+package emailtest
+
+import (
+	"bytes"
+	"chat/src/platform/security/securitytest"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+// Server is an SMTP server over TLS which records the sessions clients open, the messages it accepts
+// and the commands clients write. It accepts any AUTH PLAIN credentials.
+type Server struct {
+	Host      string      // #readonly
+	Port      uint16      // #readonly
+	ClientTLS *tls.Config // #readonly, of clients, trusting the certificate of the server
+	// OnMail and OnRcpt are optional, their errors are replied to MAIL FROM and RCPT TO. They must be set before
+	// clients connect.
+	OnMail func(from string) error
+	OnRcpt func(to string) error
+
+	listener   net.Listener
+	server     *smtp.Server
+	mu         sync.Mutex
+	sessions   int
+	messages   []Message
+	transcript bytes.Buffer
+}
+
+type Message struct {
+	From       string
+	Recipients []string
+	Options    smtp.MailOptions
+	Data       []byte
+}
+
+// NewServer starts a server listening on 127.0.0.1, which is closed once the test completes. The configure
+// function is optional, it's called before the server starts serving.
+func NewServer(tb testing.TB, configure func(server *smtp.Server)) *Server {
+	tb.Helper()
+
+	serverTLS, clientTLS := securitytest.NewTLSConfigs(tb)
+	clientTLS.ServerName = "127.0.0.1"
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		tb.Fatalf("failed to listen: %v", err)
+	}
+
+	fake := &Server{ClientTLS: clientTLS}
+	fake.listener = &recordingListener{Listener: listener, fake: fake}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	parsedPort, _ := strconv.ParseUint(port, 10, 16)
+	fake.Host, fake.Port = host, uint16(parsedPort)
+
+	fake.server = smtp.NewServer(smtp.BackendFunc(func(*smtp.Conn) (smtp.Session, error) {
+		fake.mu.Lock()
+		fake.sessions++
+		fake.mu.Unlock()
+		return &session{fake: fake}, nil
+	}))
+	fake.server.Domain = "localhost"
+	fake.server.AllowInsecureAuth = true
+	fake.server.ReadTimeout = 5 * time.Second
+	fake.server.WriteTimeout = 5 * time.Second
+	if configure != nil {
+		configure(fake.server)
+	}
+
+	go fake.server.Serve(fake.listener) //nolint:errcheck // Serve returns once closed
+	tb.Cleanup(func() { _ = fake.server.Close() })
+	return fake
+}
+
+// SessionCount returns the number of sessions clients opened so far.
+func (f *Server) SessionCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sessions
+}
+
+// Received returns the messages accepted so far, in the order their data was received.
+func (f *Server) Received() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.messages...)
+}
+
+// Commands returns what clients wrote to the server, commands and bodies.
+func (f *Server) Commands() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.transcript.String()
+}
+
+// StopListening refuses new connections, while the open ones keep being served.
+func (f *Server) StopListening() {
+	_ = f.listener.Close()
+}
+
+type recordingListener struct {
+	net.Listener
+	fake *Server
+}
+
+func (l *recordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err //nolint:wrapcheck // returned to the server as is
+	}
+	return &recordingConn{Conn: conn, fake: l.fake}, nil
+}
+
+type recordingConn struct {
+	net.Conn
+	fake *Server
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.fake.mu.Lock()
+	c.fake.transcript.Write(p[:n])
+	c.fake.mu.Unlock()
+	return n, err //nolint:wrapcheck // returned to the server as is
+}
+
+type session struct {
+	fake       *Server
+	from       string
+	recipients []string
+	options    smtp.MailOptions
+}
+
+var _ smtp.AuthSession = (*session)(nil)
+
+func (s *session) AuthMechanisms() []string {
+	return []string{sasl.Plain}
+}
+
+func (s *session) Auth(string) (sasl.Server, error) {
+	return sasl.NewPlainServer(func(string, string, string) error { return nil }), nil
+}
+
+func (s *session) Mail(from string, opts *smtp.MailOptions) error {
+	if s.fake.OnMail != nil {
+		if err := s.fake.OnMail(from); err != nil {
+			return err
+		}
+	}
+	s.from = from
+	if opts != nil {
+		s.options = *opts
+	}
+	return nil
+}
+
+func (s *session) Rcpt(to string, _ *smtp.RcptOptions) error {
+	if s.fake.OnRcpt != nil {
+		if err := s.fake.OnRcpt(to); err != nil {
+			return err
+		}
+	}
+	s.recipients = append(s.recipients, to)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err //nolint:wrapcheck // replied to the client
+	}
+	s.fake.mu.Lock()
+	s.fake.messages = append(s.fake.messages, Message{
+		From: s.from, Recipients: s.recipients, Options: s.options, Data: data,
+	})
+	s.fake.mu.Unlock()
+	return nil
+}
+
+func (s *session) Reset() {
+	s.from, s.recipients, s.options = "", nil, smtp.MailOptions{}
+}
+
+func (s *session) Logout() error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package email
+
+import (
+	"chat/src/clients/email/emailtest"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/rs/zerolog"
+	"github.com/wneessen/go-mail"
+)
+
+// fakeServerOptions returns the options of a client connecting to the server.
+func fakeServerOptions(fake *emailtest.Server) *SMTPClientOptions {
+	logger := zerolog.Nop()
+	return &SMTPClientOptions{
+		Host:              fake.Host,
+		Port:              fake.Port,
+		TLSConfig:         fake.ClientTLS.Clone(),
+		Auth:              sasl.NewPlainClient("", "user", "password"),
+		ReconnectTimeout:  time.Second,
+		CommandTimeout:    time.Second,
+		SubmissionTimeout: time.Second,
+		SendTimeout:       2 * time.Second,
+		Logger:            &logger,
+	}
+}
+
+func newConnectedClient(tb testing.TB, fake *emailtest.Server) *smtpClient {
+	tb.Helper()
+
+	client := newSMTPClient(fakeServerOptions(fake))
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		tb.Fatalf("Connect() error = %v", err)
+	}
+	tb.Cleanup(func() { _ = client.Disconnect() })
+	return client
+}
+
+func newTestEmail(tb testing.TB, to ...string) *mail.Msg {
+	tb.Helper()
+
+	message := mail.NewMsg()
+	if err := message.From("sender@example.com"); err != nil {
+		tb.Fatalf("From() error = %v", err)
+	}
+	if err := message.To(to...); err != nil {
+		tb.Fatalf("To() error = %v", err)
+	}
+	message.Subject("Hello")
+	message.SetBodyString(mail.TypeTextPlain, "Hello there")
+	return message
+}
+
+func contextWithTestTimeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
@@ -1,6 +1,7 @@
 package email
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/health"
 	"context"
 	"fmt"
@@ -8,7 +9,7 @@ import (
 )
 
 const (
-	PingTargetName               = "email"
+	PingTargetName               = components.Email
 	pingShallowAcceptableLatency = 100 * time.Millisecond
 )
 
@@ -24,6 +25,7 @@ func (c *Client) PingShallow(ctx context.Context) health.PingResult {
 			fmt.Sprintf("failed to shallow ping: %v", err),
 		)
 	}
+	c.pool.sendErrors.Degrade(&pingResult, "SMTP sends")
 
 	return pingResult
 }
@@ -0,0 +1,89 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wneessen/go-mail"
+	"golang.org/x/net/idna"
+)
+
+var ErrSendEmailSMTPUTF8Required = errors.New("email can't be sent because it has international addresses and server lacks SMTPUTF8")
+
+var internationalAddrHeaders = []mail.AddrHeader{
+	mail.HeaderFrom,
+	mail.HeaderTo,
+	mail.HeaderCc,
+	mail.HeaderBcc,
+	mail.HeaderReplyTo,
+}
+
+// hasInternationalAddresses reports whether any address of the message contains non-ASCII characters.
+// Display names are not checked, they are RFC 2047 encoded by the message writer anyway.
+func hasInternationalAddresses(message *mail.Msg) bool {
+	for _, header := range internationalAddrHeaders {
+		for _, address := range message.GetAddrHeader(header) {
+			if !isASCII(address.Address) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// asciiEncodeAddresses rewrites message addresses, so they can be delivered to a server without SMTPUTF8.
+// Domains are IDNA encoded, while addresses having non-ASCII local part can't be delivered at all.
+func asciiEncodeAddresses(message *mail.Msg) error {
+	for _, header := range internationalAddrHeaders {
+		addresses := message.GetAddrHeader(header)
+		if len(addresses) == 0 {
+			continue
+		}
+
+		encoded := make([]*netmail.Address, 0, len(addresses))
+		for _, address := range addresses {
+			asciiAddress, err := asciiEncodeAddress(address.Address)
+			if err != nil {
+				return fmt.Errorf("can't encode '%s' header address '%s': %w", header, address.Address, err)
+			}
+			encoded = append(encoded, &netmail.Address{Name: address.Name, Address: asciiAddress})
+		}
+		message.SetAddrHeaderFromMailAddress(header, encoded...)
+	}
+	return nil
+}
+
+func asciiEncodeAddress(address string) (string, error) {
+	if isASCII(address) {
+		return address, nil
+	}
+
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return "", fmt.Errorf("address has no domain: %w", ErrSendEmailSMTPUTF8Required)
+	}
+
+	local, domain := address[:at], address[at+1:]
+	if !isASCII(local) {
+		return "", fmt.Errorf("address has non-ASCII local part: %w", ErrSendEmailSMTPUTF8Required)
+	}
+
+	asciiDomain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("failed to IDNA encode domain '%s': %w", domain, err)
+	}
+
+	return local + "@" + asciiDomain, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
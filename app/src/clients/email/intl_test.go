@@ -0,0 +1,109 @@
+package email
+
+import (
+	"bytes"
+	"chat/src/clients/email/emailtest"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestSendEmailToInternationalRecipientWithServerSupport(t *testing.T) {
+	fake := emailtest.NewServer(t, func(server *smtp.Server) { server.EnableSMTPUTF8 = true })
+	client := newConnectedClient(t, fake)
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+
+	if err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, "josé@bücher.example")}); err != nil {
+		t.Fatalf("SendEmail() error = %v", err)
+	}
+
+	received := fake.Received()
+	if len(received) != 1 {
+		t.Fatalf("expected one message to be received, got %d", len(received))
+	}
+	if !received[0].Options.UTF8 {
+		t.Error("expected MAIL FROM to declare SMTPUTF8")
+	}
+	if !slices.Equal(received[0].Recipients, []string{"josé@bücher.example"}) {
+		t.Errorf("expected the address to be delivered as is, got recipients %v", received[0].Recipients)
+	}
+}
+
+func TestSendEmailToInternationalDomainWithoutServerSupport(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	client := newConnectedClient(t, fake)
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+
+	if err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, "bob@bücher.example")}); err != nil {
+		t.Fatalf("SendEmail() error = %v", err)
+	}
+
+	received := fake.Received()
+	if len(received) != 1 {
+		t.Fatalf("expected one message to be received, got %d", len(received))
+	}
+	if received[0].Options.UTF8 {
+		t.Error("expected MAIL FROM not to declare SMTPUTF8")
+	}
+	if !slices.Equal(received[0].Recipients, []string{"bob@xn--bcher-kva.example"}) {
+		t.Errorf("expected the domain to be IDNA encoded, got recipients %v", received[0].Recipients)
+	}
+	if !bytes.Contains(received[0].Data, []byte("bob@xn--bcher-kva.example")) {
+		t.Errorf("expected the To header to be IDNA encoded:\n%s", received[0].Data)
+	}
+}
+
+func TestSendEmailToInternationalLocalPartWithoutServerSupport(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	client := newConnectedClient(t, fake)
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+
+	err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, "josé@example.com")})
+	if !errors.Is(err, ErrSendEmailSMTPUTF8Required) {
+		t.Fatalf("expected ErrSendEmailSMTPUTF8Required, got: %v", err)
+	}
+	if received := fake.Received(); len(received) != 0 {
+		t.Fatalf("expected nothing to be sent, got %d messages", len(received))
+	}
+}
+
+func TestSendEmailEnforcesLowestMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name           string
+		clientMaxSize  int
+		serverMaxBytes int64
+		wantTooLarge   bool
+	}{
+		{name: "no limits", wantTooLarge: false},
+		{name: "client limit", clientMaxSize: 100, wantTooLarge: true},
+		{name: "server limit lower than client limit", clientMaxSize: 1 << 20, serverMaxBytes: 100, wantTooLarge: true},
+		{name: "limits above size", clientMaxSize: 1 << 20, serverMaxBytes: 1 << 20, wantTooLarge: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := emailtest.NewServer(t, func(server *smtp.Server) { server.MaxMessageBytes = tt.serverMaxBytes })
+			options := fakeServerOptions(fake)
+			options.MaxMessageSize = tt.clientMaxSize
+			client := newSMTPClient(options)
+			ctx, cancel := contextWithTestTimeout()
+			defer cancel()
+			if err := client.Connect(ctx); err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+			t.Cleanup(func() { _ = client.Disconnect() })
+
+			err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, "alice@example.com")})
+			if tt.wantTooLarge != errors.Is(err, ErrSendEmailTooLarge) {
+				t.Fatalf("SendEmail() error = %v, want too large: %t", err, tt.wantTooLarge)
+			}
+			if !tt.wantTooLarge && err != nil {
+				t.Fatalf("SendEmail() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var ErrSMTPNotConnected = errors.New("SMTP client is not connected")
+
+type batchRequest struct {
+	emails   []SendEmailOptions
+	response chan []error
+}
+
+// sendBatched sends the email over the current session, pipelining its envelope when the server supports it.
+func (c *smtpClient) sendBatched(ctx context.Context, opts SendEmailOptions) error {
+	if err := c.ensureConnected(); err != nil {
+		return err
+	}
+	if supported, _ := c.driver.Extension("PIPELINING"); !supported || !isPipelinable(opts) {
+		return c.SendEmail(ctx, opts)
+	}
+	return c.sendPipelined(ctx, opts)
+}
+
+// Relay returns the domain the email is relayed to, i.e. the one of its first recipient, lowercased. It's empty
+// when the email has no recipients.
+func Relay(opts SendEmailOptions) string {
+	if opts.Email == nil {
+		return ""
+	}
+	recipients := slices.Concat(opts.Email.GetTo(), opts.Email.GetCc(), opts.Email.GetBcc())
+	if len(recipients) == 0 {
+		return ""
+	}
+	address := recipients[0].Address
+	return strings.ToLower(address[strings.LastIndexByte(address, '@')+1:])
+}
+
+// groupByRelay returns the indexes of the emails grouped by relay, so the emails of a relay are sent one after the
+// other and the server hands them to the same destination in a row. Groups, and the emails within them, keep the
+// order they first appear in.
+func groupByRelay(emails []SendEmailOptions) [][]int {
+	groups := make([][]int, 0, 1)
+	groupOf := make(map[string]int, 1)
+	for idx, opts := range emails {
+		relay := Relay(opts)
+		group, ok := groupOf[relay]
+		if !ok {
+			group = len(groups)
+			groupOf[relay] = group
+			groups = append(groups, nil)
+		}
+		groups[group] = append(groups[group], idx)
+	}
+	return groups
+}
+
+// isPipelinable reports whether the envelope uses only the parameters sendPipelined knows how to write,
+// the other ones are left to the driver.
+func isPipelinable(opts SendEmailOptions) bool {
+	if mailOpts := opts.SendOptions; mailOpts != nil {
+		if mailOpts.RequireTLS || mailOpts.Auth != nil || !isPrintableASCII(mailOpts.EnvelopeID) {
+			return false
+		}
+	}
+	if rcptOpts := opts.ReceiveOptions; rcptOpts != nil {
+		if rcptOpts.OriginalRecipient != "" || !rcptOpts.RequireRecipientValidSince.IsZero() ||
+			rcptOpts.DeliverBy != nil || rcptOpts.MTPriority != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sendPipelined writes MAIL FROM and all RCPT TO commands in one round-trip (RFC 2920), then sends the body.
+// Like bdat, the commands are written on the session connection and all of their replies are read before
+// handing it back to the driver.
+func (c *smtpClient) sendPipelined(ctx context.Context, opts SendEmailOptions) error {
+	mailOptions, body, err := c.prepareEnvelope(opts)
+	if err != nil {
+		return err
+	}
+
+	senders := opts.Email.GetFrom()
+	if len(senders) != 1 {
+		return fmt.Errorf("expected exactly one sender, got %d: %w", len(senders), ErrSendEmailInvalidSenderCount)
+	}
+	recipients := slices.Concat(opts.Email.GetTo(), opts.Email.GetCc(), opts.Email.GetBcc())
+	if len(recipients) == 0 {
+		return ErrSendEmailInvalidReceiverCount
+	}
+
+	commands := make([]string, 0, len(recipients)+1)
+	command, err := c.mailCommand(senders[0].Address, mailOptions)
+	if err != nil {
+		return err
+	}
+	commands = append(commands, command)
+	for _, recipient := range recipients {
+		command, err := c.rcptCommand(recipient, opts.ReceiveOptions)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, command)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			c.reconnect() // connection state may be invalid, try to reconnect
+			return fmt.Errorf("failed to set pipelined envelope deadline: %w", err)
+		}
+		defer c.conn.SetDeadline(time.Time{}) //nolint:errcheck // connection is reconnected on the next failure anyway
+	}
+
+	for _, command := range commands {
+		if _, err := c.text.W.WriteString(command + "\r\n"); err != nil {
+			c.reconnect() // connection state may be invalid, try to reconnect
+			return fmt.Errorf("failed to write pipelined envelope: %w", err)
+		}
+	}
+	if err := c.text.W.Flush(); err != nil {
+		c.reconnect() // connection state may be invalid, try to reconnect
+		return fmt.Errorf("failed to flush pipelined envelope: %w", err)
+	}
+
+	// every command gets a reply, all of them are read even after a rejection to keep the session in sync
+	var envelopeErr error
+	for idx := range commands {
+		expectCode := 25
+		if idx == 0 {
+			expectCode = 250
+		}
+		if _, _, err := c.text.ReadResponse(expectCode); err != nil {
+			err = replyError(err)
+			if isSessionTerminated(err) {
+				c.opts.Logger.Warn().Err(err).Msg("SMTP session terminated during pipelined envelope, reconnecting")
+				c.reconnect()
+				return fmt.Errorf("%s failed: %w", describeCommand(idx, senders[0], recipients), err)
+			}
+			if envelopeErr == nil {
+				envelopeErr = fmt.Errorf("%s failed: %w", describeCommand(idx, senders[0], recipients), err)
+			}
+		}
+	}
+	if envelopeErr != nil {
+		if err := c.exchange("RSET", 250); err != nil {
+			c.opts.Logger.Warn().Err(err).Msg("failed to reset SMTP client after pipelined envelope failure, reconnecting")
+			c.reconnect()
+		}
+		return envelopeErr
+	}
+
+	if c.shouldUseChunking(body.Len()) {
+		return c.bdat(ctx, body.Bytes())
+	}
+
+	if err := c.exchange("DATA", 354); err != nil {
+		c.reconnect() // connection state may be invalid, try to reconnect
+		return fmt.Errorf("DATA failed: %w", err)
+	}
+	dataWriter := c.text.DotWriter()
+	if _, err := body.WriteTo(dataWriter); err != nil {
+		c.reconnect() // connection state may be invalid, try to reconnect
+		return fmt.Errorf("failed to write email body: %w", err)
+	}
+	if err := dataWriter.Close(); err != nil {
+		c.reconnect() // connection state may be invalid, try to reconnect
+		return fmt.Errorf("failed to close DATA command: %w", err)
+	}
+	if _, _, err := c.text.ReadResponse(250); err != nil {
+		err = replyError(err)
+		c.recoverSession(err, "DATA")
+		return fmt.Errorf("email body rejected: %w", err)
+	}
+
+	return nil
+}
+
+// mailCommand formats MAIL FROM with the same parameters the driver would add for the advertised extensions.
+func (c *smtpClient) mailCommand(from string, opts *smtp.MailOptions) (string, error) {
+	if strings.ContainsAny(from, "\r\n") {
+		return "", fmt.Errorf("MAIL FROM '%s' contains line breaks", from)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "MAIL FROM:<%s>", from)
+	if opts.Body == smtp.Body8BitMIME {
+		sb.WriteString(" BODY=8BITMIME")
+	}
+	if supported, _ := c.driver.Extension("SIZE"); supported && opts.Size != 0 {
+		fmt.Fprintf(&sb, " SIZE=%d", opts.Size)
+	}
+	if opts.UTF8 {
+		if supported, _ := c.driver.Extension("SMTPUTF8"); !supported {
+			return "", fmt.Errorf("MAIL FROM '%s' requires SMTPUTF8, which server doesn't support", from)
+		}
+		sb.WriteString(" SMTPUTF8")
+	}
+	if supported, _ := c.driver.Extension("DSN"); supported {
+		switch opts.Return {
+		case smtp.DSNReturnFull, smtp.DSNReturnHeaders:
+			fmt.Fprintf(&sb, " RET=%s", opts.Return)
+		case "":
+			// no RET parameter
+		default:
+			return "", fmt.Errorf("MAIL FROM '%s' has unknown RET parameter value '%s'", from, opts.Return)
+		}
+		if opts.EnvelopeID != "" {
+			fmt.Fprintf(&sb, " ENVID=%s", encodeXtext(opts.EnvelopeID))
+		}
+	}
+	return sb.String(), nil
+}
+
+func (c *smtpClient) rcptCommand(to *netmail.Address, opts *smtp.RcptOptions) (string, error) {
+	if strings.ContainsAny(to.Address, "\r\n") {
+		return "", fmt.Errorf("RCPT TO '%s' contains line breaks", to.Address)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "RCPT TO:<%s>", to.Address)
+	if supported, _ := c.driver.Extension("DSN"); supported && opts != nil && len(opts.Notify) != 0 {
+		notify := make([]string, 0, len(opts.Notify))
+		for _, value := range opts.Notify {
+			notify = append(notify, string(value))
+		}
+		fmt.Fprintf(&sb, " NOTIFY=%s", strings.Join(notify, ","))
+	}
+	return sb.String(), nil
+}
+
+// exchange writes a command on the underlying connection and reads its reply.
+func (c *smtpClient) exchange(command string, expectCode int) error {
+	if err := c.text.PrintfLine("%s", command); err != nil {
+		return fmt.Errorf("failed to write %s: %w", command, err)
+	}
+	if _, _, err := c.text.ReadResponse(expectCode); err != nil {
+		return replyError(err)
+	}
+	return nil
+}
+
+func describeCommand(idx int, sender *netmail.Address, recipients []*netmail.Address) string {
+	if idx == 0 {
+		return "MAIL FROM '" + sender.Address + "'"
+	}
+	return "RCPT TO '" + recipients[idx-1].Address + "'"
+}
+
+// replyError converts negative replies read on the underlying connection to the errors returned by the driver,
+// so IsRetriable classifies them the same way.
+func replyError(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return &smtp.SMTPError{Code: protoErr.Code, Message: protoErr.Msg}
+	}
+	return err
+}
+
+// encodeXtext encodes an ESMTP parameter value as xtext (RFC 3461).
+func encodeXtext(raw string) string {
+	var sb strings.Builder
+	for idx := 0; idx < len(raw); idx++ {
+		ch := raw[idx]
+		if ch >= '!' && ch <= '~' && ch != '+' && ch != '=' {
+			sb.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&sb, "+%02X", ch)
+		}
+	}
+	return sb.String()
+}
+
+func isPrintableASCII(s string) bool {
+	for idx := 0; idx < len(s); idx++ {
+		if s[idx] < ' ' || s[idx] > '~' {
+			return false
+		}
+	}
+	return true
+}
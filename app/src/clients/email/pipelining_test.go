@@ -0,0 +1,142 @@
+package email
+
+import (
+	"chat/src/clients/email/emailtest"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/rs/zerolog"
+)
+
+var errMailboxUnavailable = &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "Mailbox unavailable"}
+
+func TestRelay(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SendEmailOptions
+		want string
+	}{
+		{name: "first recipient domain", opts: SendEmailOptions{Email: newTestEmail(t, "alice@Example.COM", "bob@other.org")}, want: "example.com"},
+		{name: "no email", opts: SendEmailOptions{}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Relay(tt.opts); got != tt.want {
+				t.Errorf("Relay() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupByRelayKeepsOrderOfAppearance(t *testing.T) {
+	emails := make([]SendEmailOptions, 0, 5)
+	for _, to := range []string{"a@one.com", "b@two.com", "c@ONE.com", "d@three.com", "e@two.com"} {
+		emails = append(emails, SendEmailOptions{Email: newTestEmail(t, to)})
+	}
+
+	groups := groupByRelay(emails)
+
+	want := [][]int{{0, 2}, {1, 4}, {3}}
+	if !slices.EqualFunc(groups, want, slices.Equal[[]int]) {
+		t.Fatalf("groupByRelay() = %v, want %v", groups, want)
+	}
+}
+
+func TestTrySendBatchReusesSessionAndReportsEachOutcome(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	fake.OnRcpt = func(to string) error {
+		if strings.HasPrefix(to, "rejected@") {
+			return errMailboxUnavailable
+		}
+		return nil
+	}
+	logger := zerolog.Nop()
+	client, err := NewClient(&ClientOptions{WorkerPoolOptions: WorkerPoolOptions{
+		SMTPClientOptions: fakeServerOptions(fake),
+		Logger:            &logger,
+		NumWorkers:        1,
+		QueueSize:         1,
+	}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+	if err := client.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { client.Stop(context.Background()) })
+
+	recipients := []string{"alice@one.com", "bob@two.com", "rejected@one.com", "carol@two.com"}
+	emails := make([]SendEmailOptions, 0, len(recipients))
+	for _, to := range recipients {
+		emails = append(emails, SendEmailOptions{Email: newTestEmail(t, to)})
+	}
+	response, err := client.TrySendBatch(emails)
+	if err != nil {
+		t.Fatalf("TrySendBatch() error = %v", err)
+	}
+
+	errs := <-response
+	for idx, err := range errs {
+		if rejected := idx == 2; (err != nil) != rejected {
+			t.Errorf("outcome of email %d to '%s' = %v, want an error only for the rejected recipient", idx, recipients[idx], err)
+		}
+	}
+	if sessions := fake.SessionCount(); sessions != 1 {
+		t.Errorf("sessions = %d, want the batch sent over one session", sessions)
+	}
+	var received []string
+	for _, message := range fake.Received() {
+		received = append(received, message.Recipients...)
+	}
+	if want := []string{"alice@one.com", "bob@two.com", "carol@two.com"}; !slices.Equal(received, want) {
+		t.Errorf("received recipients = %v, want %v in relay order", received, want)
+	}
+}
+
+func benchmarkEmails(b *testing.B, count int) []SendEmailOptions {
+	b.Helper()
+
+	emails := make([]SendEmailOptions, 0, count)
+	for idx := range count {
+		emails = append(emails, SendEmailOptions{Email: newTestEmail(b, fmt.Sprintf("user-%d@relay-%d.com", idx, idx%3))})
+	}
+	return emails
+}
+
+// BenchmarkSendEmail and BenchmarkSendBatched compare sending a batch of emails one transaction after the other
+// with sending it pipelined and grouped by relay, over one session of the fake server.
+func BenchmarkSendEmail(b *testing.B) {
+	client := newConnectedClient(b, emailtest.NewServer(b, nil))
+	emails := benchmarkEmails(b, 10)
+
+	b.ResetTimer()
+	for range b.N {
+		for _, opts := range emails {
+			if err := client.SendEmail(context.Background(), opts); err != nil {
+				b.Fatalf("SendEmail() error = %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkSendBatched(b *testing.B) {
+	client := newConnectedClient(b, emailtest.NewServer(b, nil))
+	emails := benchmarkEmails(b, 10)
+
+	b.ResetTimer()
+	for range b.N {
+		for _, group := range groupByRelay(emails) {
+			for _, idx := range group {
+				if err := client.sendBatched(context.Background(), emails[idx]); err != nil {
+					b.Fatalf("sendBatched() error = %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+)
+
+// sessionConn is the connection of a session, shared by the driver and the commands written on it directly
+// (i.e. BDAT, pipelined envelopes). Both read replies from the same buffered reader, and each read stops at the end
+// of a line, so the reader of one of them never buffers the replies meant for the other one.
+type sessionConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newSessionConn(conn net.Conn) *sessionConn {
+	return &sessionConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *sessionConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if c.reader.Buffered() == 0 {
+		if _, err := c.reader.Peek(1); err != nil {
+			return 0, err //nolint:wrapcheck // returned to the readers as is
+		}
+	}
+
+	buffered, _ := c.reader.Peek(c.reader.Buffered())
+	if end := bytes.IndexByte(buffered, '\n'); end >= 0 {
+		buffered = buffered[:end+1]
+	}
+	return c.reader.Read(p[:min(len(p), len(buffered))]) //nolint:wrapcheck // returned to the readers as is
+}
@@ -0,0 +1,94 @@
+package email
+
+import (
+	"bytes"
+	"chat/src/clients/email/emailtest"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type bufferConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *bufferConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func TestSessionConnReadStopsAtEndOfLine(t *testing.T) {
+	conn := newSessionConn(&bufferConn{reader: strings.NewReader("250 first\r\n354 second\r\nrest")})
+
+	var reads []string
+	buf := make([]byte, 64)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			reads = append(reads, string(buf[:n]))
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	want := []string{"250 first\r\n", "354 second\r\n", "rest"}
+	if strings.Join(reads, "|") != strings.Join(want, "|") {
+		t.Fatalf("reads = %q, want %q", reads, want)
+	}
+}
+
+func TestSessionConnReadFillsSmallBuffers(t *testing.T) {
+	conn := newSessionConn(&bufferConn{reader: strings.NewReader("250 OK\r\n")})
+
+	var read bytes.Buffer
+	buf := make([]byte, 3)
+	for read.Len() < len("250 OK\r\n") {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		read.Write(buf[:n])
+	}
+	if read.String() != "250 OK\r\n" {
+		t.Fatalf("read %q, want the whole line", read.String())
+	}
+}
+
+func TestSendEmailWithBDATKeepsSessionInSync(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	options := fakeServerOptions(fake)
+	options.ChunkingThreshold = 1
+	options.ChunkSize = 64
+	client := newSMTPClient(options)
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect() })
+
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		if err := client.SendEmail(ctx, SendEmailOptions{Email: newTestEmail(t, to)}); err != nil {
+			t.Fatalf("SendEmail() to '%s' error = %v", to, err)
+		}
+	}
+	// the driver reads the next reply right after the BDAT ones
+	if err := client.noop(); err != nil {
+		t.Fatalf("NOOP after BDAT error = %v", err)
+	}
+
+	if commands := fake.Commands(); !strings.Contains(commands, "BDAT 64\r\n") || !strings.Contains(commands, " LAST\r\n") {
+		t.Fatalf("commands don't send the body in BDAT chunks:\n%s", commands)
+	}
+	received := fake.Received()
+	if len(received) != 2 || fake.SessionCount() != 1 {
+		t.Fatalf("received %d messages over %d sessions, want 2 over one session", len(received), fake.SessionCount())
+	}
+	for idx, message := range received {
+		if !bytes.Contains(message.Data, []byte("Hello there")) {
+			t.Errorf("message #%d body is incomplete:\n%s", idx, message.Data)
+		}
+	}
+}
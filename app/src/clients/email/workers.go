@@ -1,28 +1,39 @@
 package email
 
 import (
+	"chat/src/platform/health"
+	"chat/src/platform/validation"
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/creasty/defaults"
 	"github.com/rs/zerolog"
 )
 
 var ErrWorkerPoolNotRunning = errors.New("worker pool is not running")
+var ErrQueueFull = errors.New("worker pool requests queue is full")
+var ErrNoWorkers = errors.New("worker pool has no workers")
+
+const defaultWorkerIdleTimeout = 1 * time.Minute
 
 type Request struct {
 	SendOptions SendEmailOptions
 	Response    chan error
+	batch       *batchRequest // set by Client.TrySendBatch instead of SendOptions
 }
 
 type worker struct {
-	id     uint8
-	health chan healthRequest
-	client *smtpClient
-	logger *zerolog.Logger
+	id         uint32
+	health     chan healthRequest
+	client     *smtpClient
+	sendErrors *health.ErrorRateTracker
+	logger     *zerolog.Logger
 }
 
 type healthRequest struct {
@@ -31,39 +42,123 @@ type healthRequest struct {
 
 type workerPool struct {
 	requestsQueue chan Request
+	queueMutex    sync.RWMutex       // held for reading by the sends on requestsQueue, for writing by its close
+	smtpOptions   *SMTPClientOptions // #readonly
+	minWorkers    int                // #readonly
+	maxWorkers    int                // #readonly
+	idleTimeout   time.Duration      // #readonly
+	mutex         sync.Mutex         // guards workers, reserved and nextWorkerID
 	workers       []*worker
+	reserved      int // workers being connected, counted against maxWorkers
+	nextWorkerID  uint32
+	scalingUp     atomic.Bool
+	stats         poolCounters
+	sendErrors    *health.ErrorRateTracker // transient send failures, which degrade the pool health
 	logger        *zerolog.Logger
 	running       atomic.Bool
 	runningWg     sync.WaitGroup
 }
 
+type poolCounters struct {
+	busyWorkers       atomic.Int64
+	connectionsOpened atomic.Uint64
+	connectionsClosed atomic.Uint64
+	connectFailures   atomic.Uint64
+}
+
+// PoolStats is a point-in-time snapshot of the worker pool, each worker holding one SMTP connection.
+type PoolStats struct {
+	Workers           int     `json:"workers"`
+	BusyWorkers       int64   `json:"busy_workers"`
+	QueuedRequests    int     `json:"queued_requests"`
+	QueueCapacity     int     `json:"queue_capacity"`
+	ConnectionsOpened uint64  `json:"connections_opened"`
+	ConnectionsClosed uint64  `json:"connections_closed"`
+	ConnectFailures   uint64  `json:"connect_failures"`
+	SendErrorRate     float64 `json:"send_error_rate"`
+}
+
 type WorkerPoolOptions struct {
-	SMTPClientOptions *SMTPClientOptions
-	Logger            *zerolog.Logger
-	NumWorkers        uint8
-	QueueSize         uint16
+	SMTPClientOptions *SMTPClientOptions `validate:"required"`
+	Logger            *zerolog.Logger    `validate:"required"`
+	NumWorkers        uint8              `validate:"required,min=1,max=100"` // no default, a pool without workers never sends
+	QueueSize         uint16             `validate:"required,min=1,max=10000" default:"100"`
+	// MaxWorkers enables the dynamic mode when greater than NumWorkers: workers are added on demand, while requests
+	// are queued and all workers are busy, up to MaxWorkers, and the ones beyond NumWorkers are retired after being
+	// idle for IdleTimeout. By default, the pool has a fixed number of workers.
+	MaxWorkers  uint8         `validate:"omitempty,gtefield=NumWorkers,max=100"`
+	IdleTimeout time.Duration // defaults to 1m
+	ErrorRate   health.ErrorRateOptions
 }
 
-func newWorkerPool(opts WorkerPoolOptions) *workerPool {
+func newWorkerPool(opts WorkerPoolOptions) (*workerPool, error) {
+	if err := defaults.Set(&opts); err != nil {
+		return nil, fmt.Errorf("failed to set config defaults: %w", err)
+	}
+	if err := validation.Instance.Struct(&opts); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", validation.Aggregate(err))
+	}
+
+	sendErrors, err := health.NewErrorRateTracker(&opts.ErrorRate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create send error rate tracker: %w", err)
+	}
+
 	opts.SMTPClientOptions.Logger = opts.Logger
 	opts.SMTPClientOptions.TLSConfig.ServerName = opts.SMTPClientOptions.Host
 
 	pool := &workerPool{
 		requestsQueue: make(chan Request, opts.QueueSize),
-		workers:       make([]*worker, opts.NumWorkers),
+		smtpOptions:   opts.SMTPClientOptions,
+		minWorkers:    int(opts.NumWorkers),
+		maxWorkers:    max(int(opts.NumWorkers), int(opts.MaxWorkers)),
+		idleTimeout:   opts.IdleTimeout,
+		sendErrors:    sendErrors,
+		workers:       make([]*worker, 0, max(opts.NumWorkers, opts.MaxWorkers)),
 		logger:        opts.Logger,
 	}
+	if pool.idleTimeout <= 0 {
+		pool.idleTimeout = defaultWorkerIdleTimeout
+	}
 
-	for i := uint8(0); i < opts.NumWorkers; i++ { //nolint:intrange // uint8 is sufficient for number of workers
-		pool.workers[i] = &worker{
-			id:     i,
-			health: make(chan healthRequest),
-			client: newSMTPClient(opts.SMTPClientOptions),
-			logger: opts.Logger,
-		}
+	for range opts.NumWorkers {
+		pool.workers = append(pool.workers, pool.newWorker())
+	}
+
+	return pool, nil
+}
+
+func (p *workerPool) dynamic() bool {
+	return p.maxWorkers > p.minWorkers
+}
+
+func (p *workerPool) newWorker() *worker {
+	w := &worker{
+		id:         p.nextWorkerID,
+		health:     make(chan healthRequest),
+		client:     newSMTPClient(p.smtpOptions),
+		sendErrors: p.sendErrors,
+		logger:     p.logger,
 	}
+	p.nextWorkerID++
+	return w
+}
 
-	return pool
+func (p *workerPool) Stats() PoolStats {
+	p.mutex.Lock()
+	workers := len(p.workers)
+	p.mutex.Unlock()
+
+	return PoolStats{
+		Workers:           workers,
+		BusyWorkers:       p.stats.busyWorkers.Load(),
+		QueuedRequests:    len(p.requestsQueue),
+		QueueCapacity:     cap(p.requestsQueue),
+		ConnectionsOpened: p.stats.connectionsOpened.Load(),
+		ConnectionsClosed: p.stats.connectionsClosed.Load(),
+		ConnectFailures:   p.stats.connectFailures.Load(),
+		SendErrorRate:     p.sendErrors.Rate(),
+	}
 }
 
 func (p *workerPool) Start(ctx context.Context) error {
@@ -71,25 +166,31 @@ func (p *workerPool) Start(ctx context.Context) error {
 		p.logger.Warn().Msg("worker pool is already started")
 		return nil
 	}
+	if len(p.workers) == 0 {
+		return ErrNoWorkers
+	}
 
 	// Initialization: establish SMTP connections for all workers
 	for i, worker := range p.workers {
 		if err := worker.client.Connect(ctx); err != nil {
+			p.stats.connectFailures.Add(1)
 			// rollback
 			for j := i - 1; j >= 0; j-- {
 				if err := p.workers[j].client.Disconnect(); err != nil {
 					p.logger.Error().Err(err).Msgf("failed to close SMTP client for worker '%d' during cleanup", p.workers[j].id)
 				}
+				p.stats.connectionsClosed.Add(1)
 			}
 			// return error
 			return fmt.Errorf("failed to connect SMTP client for worker '%d': %w", worker.id, err)
 		}
+		p.stats.connectionsOpened.Add(1)
 	}
 
 	// Assign job processing goroutines
 	for _, worker := range p.workers {
 		p.runningWg.Go(func() {
-			worker.drainRequestsQueue(p.requestsQueue)
+			worker.drainRequestsQueue(p)
 		})
 	}
 
@@ -98,20 +199,95 @@ func (p *workerPool) Start(ctx context.Context) error {
 }
 
 func (p *workerPool) Stop() {
-	if !p.running.Swap(false) {
+	// the pool stops running with no send in progress, so the queue is closed once no one can send on it, and under
+	// the workers mutex, so scaleUp doesn't add a worker to runningWg once it's waited for
+	p.queueMutex.Lock()
+	p.mutex.Lock()
+	wasRunning := p.running.Swap(false)
+	p.mutex.Unlock()
+	if wasRunning {
+		close(p.requestsQueue)
+	}
+	p.queueMutex.Unlock()
+
+	if !wasRunning {
 		p.logger.Warn().Msg("worker pool is already stopped")
 		return
 	}
-	close(p.requestsQueue)
 	p.runningWg.Wait()
 }
 
+// scaleUp adds a worker when requests are queued while all workers are busy, one worker at a time.
+func (p *workerPool) scaleUp() {
+	if !p.dynamic() || len(p.requestsQueue) == 0 || !p.scalingUp.CompareAndSwap(false, true) {
+		return
+	}
+
+	p.mutex.Lock()
+	if !p.running.Load() || len(p.workers)+p.reserved >= p.maxWorkers ||
+		p.stats.busyWorkers.Load() < int64(len(p.workers)) {
+		p.mutex.Unlock()
+		p.scalingUp.Store(false)
+		return
+	}
+	worker := p.newWorker()
+	p.reserved++
+	p.runningWg.Add(1)
+	p.mutex.Unlock()
+
+	go func() {
+		defer p.runningWg.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), p.smtpOptions.ReconnectTimeout)
+		err := worker.client.Connect(ctx)
+		cancel()
+
+		p.mutex.Lock()
+		p.reserved--
+		if err == nil {
+			p.workers = append(p.workers, worker)
+		}
+		p.mutex.Unlock()
+		p.scalingUp.Store(false)
+
+		if err != nil {
+			p.stats.connectFailures.Add(1)
+			p.logger.Error().Err(err).Msgf("failed to connect SMTP client of on demand worker '%d'", worker.id)
+			return
+		}
+		p.stats.connectionsOpened.Add(1)
+		p.logger.Debug().Msgf("worker '%d' added on demand", worker.id)
+
+		worker.drainRequestsQueue(p)
+	}()
+}
+
+// retire removes an idle worker from the pool, unless the pool is at its minimum size.
+func (p *workerPool) retire(w *worker) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.workers) <= p.minWorkers {
+		return false
+	}
+	for i, candidate := range p.workers {
+		if candidate == w {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 func (p *workerPool) Submit(request Request) error {
+	p.queueMutex.RLock()
 	if !p.running.Load() {
+		p.queueMutex.RUnlock()
 		return ErrWorkerPoolNotRunning
 	}
-
 	p.requestsQueue <- request
+	p.queueMutex.RUnlock()
+	p.scaleUp()
 
 	if request.Response == nil {
 		return nil
@@ -119,15 +295,38 @@ func (p *workerPool) Submit(request Request) error {
 	return <-request.Response
 }
 
+// TrySubmit queues the request without blocking, failing fast with ErrQueueFull when the requests queue is full.
+// Unlike Submit, it returns once the request is queued, its outcome being sent on Response to callers waiting for it.
+func (p *workerPool) TrySubmit(request Request) error {
+	p.queueMutex.RLock()
+	if !p.running.Load() {
+		p.queueMutex.RUnlock()
+		return ErrWorkerPoolNotRunning
+	}
+	select {
+	case p.requestsQueue <- request:
+		p.queueMutex.RUnlock()
+		p.scaleUp()
+		return nil
+	default:
+		p.queueMutex.RUnlock()
+		return ErrQueueFull
+	}
+}
+
 func (p *workerPool) Healthy(ctx context.Context) error {
 	if !p.running.Load() {
 		return ErrWorkerPoolNotRunning
 	}
 
-	herrors := make([]error, len(p.workers)) //nolint:makezero // concurrently write safely to different indexes
+	p.mutex.Lock()
+	workers := slices.Clone(p.workers)
+	p.mutex.Unlock()
+
+	herrors := make([]error, len(workers)) //nolint:makezero // concurrently write safely to different indexes
 
 	var wg sync.WaitGroup
-	for i, worker := range p.workers {
+	for i, worker := range workers {
 		wg.Go(func() {
 			herrors[i] = worker.healthy(ctx)
 		})
@@ -140,7 +339,7 @@ func (p *workerPool) Healthy(ctx context.Context) error {
 			continue
 		}
 
-		msg := fmt.Sprintf("failed healthcheck of worker '%d': %v; ", i, err)
+		msg := fmt.Sprintf("failed healthcheck of worker '%d': %v; ", workers[i].id, err)
 		if _, err := builder.WriteString(msg); err != nil {
 			p.logger.Error().Err(err).Msgf("failed to write message into error message string builder: '%s'", msg)
 		}
@@ -153,35 +352,90 @@ func (p *workerPool) Healthy(ctx context.Context) error {
 	return errors.New(builder.String()) //nolint:err113 // we are good here
 }
 
-func (w *worker) drainRequestsQueue(requests <-chan Request) {
+func (w *worker) drainRequestsQueue(pool *workerPool) {
+	var idle *time.Timer
+	var idleC <-chan time.Time
+	if pool.dynamic() {
+		idle = time.NewTimer(pool.idleTimeout)
+		defer idle.Stop()
+		idleC = idle.C
+	}
+
 	for {
 		select {
 		case request := <-w.health:
-			request.response <- w.client.driver.Noop()
+			request.response <- w.client.noop()
 			close(request.response)
 
-		case request, ok := <-requests:
+		case <-idleC:
+			if pool.retire(w) {
+				w.logger.Debug().Msgf("worker '%d' retired after being idle for %v", w.id, pool.idleTimeout)
+				w.shutdown(pool)
+				return
+			}
+			idle.Reset(pool.idleTimeout)
+
+		case request, ok := <-pool.requestsQueue:
 			if !ok {
-				w.shutdown() // Job channel closed and drained → clean shutdown
+				w.shutdown(pool) // Job channel closed and drained → clean shutdown
 				return
 			}
 
+			pool.stats.busyWorkers.Add(1)
+			if request.batch != nil {
+				w.sendBatch(request.batch)
+			} else {
+				w.send(request)
+			}
+			pool.stats.busyWorkers.Add(-1)
+			if idle != nil {
+				idle.Reset(pool.idleTimeout)
+			}
+		}
+	}
+}
+
+func (w *worker) send(request Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.client.opts.SendTimeout)
+	err := w.client.SendEmail(ctx, request.SendOptions)
+	cancel()
+	w.recordOutcome(err)
+
+	if err != nil {
+		err = fmt.Errorf("worker '%d' failed to send email: %w", w.id, err)
+	}
+
+	if request.Response != nil {
+		request.Response <- err
+		close(request.Response)
+	} else if err != nil {
+		w.logger.Error().Err(err).Msgf("failed to send email")
+	}
+}
+
+// sendBatch sends the emails one after the other over the session of the worker, grouped by relay, each within its
+// own send timeout. Outcomes are reported at the index of their email in the batch.
+func (w *worker) sendBatch(batch *batchRequest) {
+	errs := make([]error, len(batch.emails))
+	for _, group := range groupByRelay(batch.emails) {
+		for _, idx := range group {
 			ctx, cancel := context.WithTimeout(context.Background(), w.client.opts.SendTimeout)
-			err := w.client.SendEmail(ctx, request.SendOptions)
+			err := w.client.sendBatched(ctx, batch.emails[idx])
 			cancel()
-
+			w.recordOutcome(err)
 			if err != nil {
-				err = fmt.Errorf("worker '%d' failed to send email: %w", w.id, err)
-			}
-
-			if request.Response != nil {
-				request.Response <- err
-				close(request.Response)
-			} else if err != nil {
-				w.logger.Error().Err(err).Msgf("failed to send email")
+				errs[idx] = fmt.Errorf("worker '%d' failed to send email %d of batch: %w", w.id, idx, err)
 			}
 		}
 	}
+	batch.response <- errs
+	close(batch.response)
+}
+
+// recordOutcome counts only transient failures, permanent ones are caused by the email (i.e. unknown recipient)
+// rather than by the state of the relay.
+func (w *worker) recordOutcome(err error) {
+	w.sendErrors.Record(err == nil || !IsRetriable(err))
 }
 
 func (w *worker) healthy(ctx context.Context) error {
@@ -206,8 +460,9 @@ func (w *worker) healthy(ctx context.Context) error {
 	}
 }
 
-func (w *worker) shutdown() {
+func (w *worker) shutdown(pool *workerPool) {
 	if err := w.client.Disconnect(); err != nil {
 		w.logger.Error().Err(err).Msgf("failed to close SMTP client of worker '%d' during shutdown", w.id)
 	}
+	pool.stats.connectionsClosed.Add(1)
 }
@@ -0,0 +1,359 @@
+package email
+
+import (
+	"chat/src/clients/email/emailtest"
+	"chat/src/platform/health"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/rs/zerolog"
+)
+
+func newQueueOnlyPool(queueSize int) *workerPool {
+	logger := zerolog.Nop()
+	pool := &workerPool{
+		requestsQueue: make(chan Request, queueSize),
+		logger:        &logger,
+	}
+	pool.running.Store(true)
+	return pool
+}
+
+func TestTrySubmitReturnsErrQueueFullInsteadOfBlocking(t *testing.T) {
+	pool := newQueueOnlyPool(2)
+
+	for idx := range 2 {
+		// no worker reads the queue, so returning proves TrySubmit doesn't wait for the response
+		if err := pool.TrySubmit(Request{Response: make(chan error, 1)}); err != nil {
+			t.Fatalf("TrySubmit() #%d error = %v, want the request to be queued", idx, err)
+		}
+	}
+	if err := pool.TrySubmit(Request{Response: make(chan error, 1)}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("TrySubmit() on full queue error = %v, want %v", err, ErrQueueFull)
+	}
+	if queued := len(pool.requestsQueue); queued != 2 {
+		t.Errorf("queued requests = %d, want 2", queued)
+	}
+}
+
+func TestTrySubmitFailsWhenPoolIsNotRunning(t *testing.T) {
+	pool := newQueueOnlyPool(1)
+	pool.running.Store(false)
+
+	if err := pool.TrySubmit(Request{}); !errors.Is(err, ErrWorkerPoolNotRunning) {
+		t.Errorf("TrySubmit() error = %v, want %v", err, ErrWorkerPoolNotRunning)
+	}
+}
+
+func TestTrySubmitRacingStopDoesntPanic(t *testing.T) {
+	for range 10 {
+		pool := newQueueOnlyPool(4)
+		var submitters sync.WaitGroup
+		for range 8 {
+			submitters.Go(func() {
+				for {
+					err := pool.TrySubmit(Request{})
+					if errors.Is(err, ErrWorkerPoolNotRunning) {
+						return
+					}
+					if errors.Is(err, ErrQueueFull) {
+						<-pool.requestsQueue // as a worker would
+					}
+				}
+			})
+		}
+		time.Sleep(time.Millisecond)
+		pool.Stop() // the queue is closed while requests are submitted, which must not send on it
+		submitters.Wait()
+	}
+}
+
+func TestTrySendBatchReturnsErrQueueFull(t *testing.T) {
+	client := &Client{pool: newQueueOnlyPool(1)}
+
+	response, err := client.TrySendBatch([]SendEmailOptions{{}})
+	if err != nil || response == nil {
+		t.Fatalf("TrySendBatch() = (%v, %v), want the response channel", response, err)
+	}
+	if _, err := client.TrySendBatch([]SendEmailOptions{{}}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("TrySendBatch() on full queue error = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+// newBlockingServer returns a server holding each send at RCPT TO until release is closed.
+func newBlockingServer(t *testing.T) (*emailtest.Server, chan struct{}) {
+	t.Helper()
+
+	release := make(chan struct{})
+	fake := emailtest.NewServer(t, nil)
+	fake.OnRcpt = func(string) error {
+		<-release
+		return nil
+	}
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	return fake, release
+}
+
+func newStartedPool(t *testing.T, fake *emailtest.Server, configure func(options *WorkerPoolOptions)) *workerPool {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	options := WorkerPoolOptions{
+		SMTPClientOptions: fakeServerOptions(fake),
+		Logger:            &logger,
+		NumWorkers:        1,
+		QueueSize:         10,
+	}
+	if configure != nil {
+		configure(&options)
+	}
+	pool, err := newWorkerPool(options)
+	if err != nil {
+		t.Fatalf("newWorkerPool() error = %v", err)
+	}
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+	if err := pool.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(pool.Stop)
+	return pool
+}
+
+// waitForStats waits for the stats of the pool to satisfy the condition, failing the test on timeout.
+func waitForStats(t *testing.T, pool *workerPool, condition func(stats PoolStats) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition(pool.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool stats %+v didn't reach the expected state", pool.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func submitTestEmail(t *testing.T, pool *workerPool) chan error {
+	t.Helper()
+
+	response := make(chan error, 1)
+	request := Request{SendOptions: SendEmailOptions{Email: newTestEmail(t, "alice@example.com")}, Response: response}
+	if err := pool.TrySubmit(request); err != nil {
+		t.Fatalf("TrySubmit() error = %v", err)
+	}
+	return response
+}
+
+func TestDynamicPoolScalesUpUnderLoadAndDownAfterIdle(t *testing.T) {
+	fake, release := newBlockingServer(t)
+	pool := newStartedPool(t, fake, func(options *WorkerPoolOptions) {
+		options.MaxWorkers = 3
+		options.IdleTimeout = 100 * time.Millisecond
+	})
+
+	// each request is submitted once all workers are busy, so a worker is added for it until the pool is full
+	var responses []chan error
+	for workers := 1; workers <= 3; workers++ {
+		responses = append(responses, submitTestEmail(t, pool))
+		waitForStats(t, pool, func(stats PoolStats) bool {
+			return stats.Workers == workers && stats.BusyWorkers == int64(workers)
+		})
+	}
+	responses = append(responses, submitTestEmail(t, pool))
+	time.Sleep(50 * time.Millisecond) // a worker beyond the maximum would connect meanwhile
+
+	stats := pool.Stats()
+	if stats.Workers != 3 || stats.QueuedRequests != 1 || stats.ConnectionsOpened != 3 {
+		t.Fatalf("stats under load = %+v, want 3 workers, 1 queued request and 3 connections opened", stats)
+	}
+	if sessions := fake.SessionCount(); sessions != 3 {
+		t.Fatalf("server sessions = %d, want 3", sessions)
+	}
+
+	close(release)
+	for idx, response := range responses {
+		if err := <-response; err != nil {
+			t.Fatalf("request #%d error = %v", idx, err)
+		}
+	}
+
+	waitForStats(t, pool, func(stats PoolStats) bool { return stats.Workers == 1 && stats.ConnectionsClosed == 2 })
+	time.Sleep(200 * time.Millisecond) // the last worker is idle as well, but the pool is at its minimum
+	if stats := pool.Stats(); stats.Workers != 1 || stats.ConnectionsClosed != 2 {
+		t.Fatalf("stats after idle = %+v, want the minimum of 1 worker kept", stats)
+	}
+
+	// the pool scales up again on demand
+	if err := <-submitTestEmail(t, pool); err != nil {
+		t.Fatalf("request after scale down error = %v", err)
+	}
+}
+
+func TestStopWhileScalingUpClosesEveryConnection(t *testing.T) {
+	for range 5 {
+		fake, release := newBlockingServer(t)
+		pool := newStartedPool(t, fake, func(options *WorkerPoolOptions) { options.MaxWorkers = 3 })
+
+		responses := []chan error{submitTestEmail(t, pool)}
+		waitForStats(t, pool, func(stats PoolStats) bool { return stats.BusyWorkers == 1 })
+		responses = append(responses, submitTestEmail(t, pool)) // a worker is being added for it
+		close(release)
+		pool.Stop()
+
+		for idx, response := range responses {
+			select {
+			case <-response: // sent, or failed by the worker stopping
+			default:
+				t.Fatalf("request #%d got no response, want every queued request to be handled before Stop returns", idx)
+			}
+		}
+		if stats := pool.Stats(); stats.ConnectionsOpened != stats.ConnectionsClosed {
+			t.Fatalf("stats after stop = %+v, want the connection of the worker added on demand closed as well", stats)
+		}
+	}
+}
+
+func TestFixedPoolDoesNotScale(t *testing.T) {
+	fake, release := newBlockingServer(t)
+	pool := newStartedPool(t, fake, nil)
+
+	first := submitTestEmail(t, pool)
+	waitForStats(t, pool, func(stats PoolStats) bool { return stats.BusyWorkers == 1 })
+	second := submitTestEmail(t, pool)
+	time.Sleep(50 * time.Millisecond)
+
+	if stats := pool.Stats(); stats.Workers != 1 || stats.QueuedRequests != 1 || stats.ConnectionsOpened != 1 {
+		t.Fatalf("stats under load = %+v, want 1 worker, 1 queued request and 1 connection opened", stats)
+	}
+
+	close(release)
+	for _, response := range []chan error{first, second} {
+		if err := <-response; err != nil {
+			t.Fatalf("request error = %v", err)
+		}
+	}
+}
+
+func TestPoolStatsCountConnectionsClosedOnStop(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	pool := newStartedPool(t, fake, func(options *WorkerPoolOptions) { options.NumWorkers = 2 })
+
+	pool.Stop()
+
+	if stats := pool.Stats(); stats.ConnectionsOpened != 2 || stats.ConnectionsClosed != 2 {
+		t.Fatalf("stats after stop = %+v, want 2 connections opened and closed", stats)
+	}
+	if err := pool.TrySubmit(Request{}); !errors.Is(err, ErrWorkerPoolNotRunning) {
+		t.Fatalf("TrySubmit() after stop error = %v, want %v", err, ErrWorkerPoolNotRunning)
+	}
+}
+
+func newValidPoolOptions() WorkerPoolOptions {
+	logger := zerolog.Nop()
+	return WorkerPoolOptions{
+		SMTPClientOptions: &SMTPClientOptions{Host: "smtp.test", Port: 465, TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}},
+		Logger:            &logger,
+		NumWorkers:        2,
+		QueueSize:         10,
+	}
+}
+
+func TestNewClientRejectsInvalidPoolOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(options *WorkerPoolOptions)
+	}{
+		{name: "zero workers", configure: func(options *WorkerPoolOptions) { options.NumWorkers = 0 }},
+		{name: "too many workers", configure: func(options *WorkerPoolOptions) { options.NumWorkers = 101 }},
+		{name: "queue too large", configure: func(options *WorkerPoolOptions) { options.QueueSize = 10001 }},
+		{name: "max workers below workers", configure: func(options *WorkerPoolOptions) { options.MaxWorkers = 1 }},
+		{name: "without SMTP options", configure: func(options *WorkerPoolOptions) { options.SMTPClientOptions = nil }},
+		{name: "without logger", configure: func(options *WorkerPoolOptions) { options.Logger = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := newValidPoolOptions()
+			tt.configure(&options)
+
+			client, err := NewClient(&ClientOptions{WorkerPoolOptions: options})
+			if err == nil || client != nil {
+				t.Fatalf("NewClient() = (%v, %v), want the options to be rejected", client, err)
+			}
+		})
+	}
+}
+
+func TestNewWorkerPoolDefaultsQueueSize(t *testing.T) {
+	options := newValidPoolOptions()
+	options.QueueSize = 0
+
+	pool, err := newWorkerPool(options)
+	if err != nil {
+		t.Fatalf("newWorkerPool() error = %v", err)
+	}
+	if stats := pool.Stats(); stats.QueueCapacity != 100 || stats.Workers != 2 {
+		t.Fatalf("pool stats = %+v, want 2 workers and the default queue capacity of 100", stats)
+	}
+}
+
+func TestStartWithoutWorkersFails(t *testing.T) {
+	logger := zerolog.Nop()
+	pool := &workerPool{requestsQueue: make(chan Request, 1), logger: &logger}
+
+	ctx, cancel := contextWithTestTimeout()
+	defer cancel()
+	if err := pool.Start(ctx); !errors.Is(err, ErrNoWorkers) {
+		t.Fatalf("Start() error = %v, want %v", err, ErrNoWorkers)
+	}
+	if err := pool.TrySubmit(Request{}); !errors.Is(err, ErrWorkerPoolNotRunning) {
+		t.Fatalf("TrySubmit() error = %v, want the pool not to run", err)
+	}
+}
+
+func TestSendFailuresDegradePoolHealth(t *testing.T) {
+	tests := []struct {
+		name      string
+		reject    *smtp.SMTPError
+		wantCause health.PingCause
+		wantRate  float64
+	}{
+		{name: "temporary rejections", reject: &smtp.SMTPError{Code: 451, Message: "try again later"}, wantCause: health.PingCauseOverloaded, wantRate: 1},
+		{name: "permanent rejections", reject: &smtp.SMTPError{Code: 550, Message: "no such user"}, wantCause: health.PingCauseOk},
+		{name: "no rejections", wantCause: health.PingCauseOk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := emailtest.NewServer(t, nil)
+			fake.OnRcpt = func(string) error {
+				if tt.reject != nil {
+					return tt.reject
+				}
+				return nil
+			}
+			pool := newStartedPool(t, fake, func(options *WorkerPoolOptions) { options.ErrorRate.MinSamples = 3 })
+			client := &Client{pool: pool}
+
+			for range 3 {
+				<-submitTestEmail(t, pool)
+			}
+			ctx, cancel := contextWithTestTimeout()
+			defer cancel()
+			if result := client.PingShallow(ctx); result.Cause != tt.wantCause {
+				t.Fatalf("PingShallow() cause = %s (%s), want %s", result.Cause, result.Details, tt.wantCause)
+			}
+			if rate := pool.Stats().SendErrorRate; rate != tt.wantRate {
+				t.Fatalf("SendErrorRate = %v, want %v", rate, tt.wantRate)
+			}
+		})
+	}
+}
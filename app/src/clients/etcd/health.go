@@ -1,6 +1,7 @@
 package etcd
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/health"
 	"context"
 	"fmt"
@@ -11,7 +12,7 @@ import (
 )
 
 const (
-	PingTargetName            = "etcd"
+	PingTargetName            = components.Etcd
 	pingDeepAcceptableLatency = 150 * time.Millisecond
 	acceptableDBUsageRatio    = 0.8
 )
@@ -0,0 +1,112 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	instanceKeyFormat    = "/%s/instances/%s"
+	instanceLeaseTTL     = 10 * time.Second
+	instanceRetryBackoff = time.Second
+)
+
+var ErrInstanceNameTaken = errors.New("instance name is registered by another process")
+
+// InstanceRegistration holds an instance name for as long as the process is alive, the name being released
+// when the lease keeping it expires, i.e. within instanceLeaseTTL after a crash.
+type InstanceRegistration struct {
+	client          *Client
+	key             string
+	lease           clientv3.LeaseID
+	cancelKeepAlive context.CancelFunc
+}
+
+// RegisterInstance claims the instance name within the scope, so two replicas started with the same name, which
+// would be treated by Kafka as the same static group member and fence each other, are detected at startup.
+// A name still held by the lease of a crashed process is retried until that lease expires.
+func (c *Client) RegisterInstance(ctx context.Context, scope, name string) (*InstanceRegistration, error) {
+	key := fmt.Sprintf(instanceKeyFormat, scope, name)
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s/%d", hostname, os.Getpid())
+
+	deadline := time.Now().Add(instanceLeaseTTL + instanceRetryBackoff)
+	for {
+		registration, current, err := c.tryRegisterInstance(ctx, key, holder)
+		if err != nil || registration != nil {
+			return registration, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: '%s' is held by '%s'", ErrInstanceNameTaken, key, current)
+		}
+
+		c.logger.Warn().Msgf("Instance name '%s' is held by '%s', retrying until its lease expires", key, current)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to register instance name '%s': %w", key, ctx.Err())
+		case <-time.After(instanceRetryBackoff):
+		}
+	}
+}
+
+// tryRegisterInstance returns the registration, or the current holder of the name when it is taken.
+func (c *Client) tryRegisterInstance(ctx context.Context, key, holder string) (*InstanceRegistration, string, error) {
+	lease, err := c.Driver.Grant(ctx, int64(instanceLeaseTTL/time.Second))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to grant lease for instance name '%s': %w", key, err)
+	}
+
+	response, err := c.Driver.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, holder, clientv3.WithLease(lease.ID))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil || !response.Succeeded {
+		if _, revokeErr := c.Driver.Revoke(context.WithoutCancel(ctx), lease.ID); revokeErr != nil {
+			c.logger.Warn().Err(revokeErr).Msgf("Failed to revoke unused lease of instance name '%s'", key)
+		}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to register instance name '%s': %w", key, err)
+	}
+	if !response.Succeeded {
+		current := ""
+		if kvs := response.Responses[0].GetResponseRange().GetKvs(); len(kvs) > 0 {
+			current = string(kvs[0].Value)
+		}
+		return nil, current, nil
+	}
+
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	keepAlive, err := c.Driver.KeepAlive(keepAliveCtx, lease.ID)
+	if err != nil {
+		cancel()
+		return nil, "", fmt.Errorf("failed to keep alive lease of instance name '%s': %w", key, err)
+	}
+	go func() {
+		for range keepAlive { //nolint:revive // responses only need to be drained
+		}
+		if keepAliveCtx.Err() == nil {
+			c.logger.Error().Msgf("Lease of instance name '%s' was lost, another replica may claim the name", key)
+		}
+	}()
+
+	c.logger.Info().Msgf("Registered instance name '%s'", key)
+	return &InstanceRegistration{client: c, key: key, lease: lease.ID, cancelKeepAlive: cancel}, "", nil
+}
+
+// Release frees the instance name right away, so a replacement replica doesn't have to wait for the lease to expire.
+func (r *InstanceRegistration) Release(ctx context.Context) {
+	r.cancelKeepAlive()
+	if r.client.Driver == nil {
+		return // the lease expires on its own
+	}
+	if _, err := r.client.Driver.Revoke(ctx, r.lease); err != nil {
+		r.client.logger.Warn().Err(err).Msgf("Failed to release instance name '%s'", r.key)
+	}
+}
@@ -0,0 +1,109 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// endpointsEnv holds the comma separated endpoints of the etcd cluster the tests run against,
+// i.e. "127.0.0.1:2379". The tests are skipped when it isn't set.
+const endpointsEnv = "CHAT_TEST_ETCD_ENDPOINTS"
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	endpoints := os.Getenv(endpointsEnv)
+	if endpoints == "" {
+		t.Skipf("%s is not set, skipping test depending on etcd", endpointsEnv)
+	}
+	client := NewClient(&ClientOptions{
+		Endpoints: strings.Split(endpoints, ","),
+		Logger:    ClientLoggerOptions{Client: zerolog.Nop(), Driver: zerolog.Nop()},
+	})
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { client.Stop(context.Background()) })
+	return client
+}
+
+// testScope returns a scope of its own to each test run, so the names registered by previous runs don't collide.
+func testScope(t *testing.T) string {
+	return "chat-test-" + strings.ReplaceAll(t.Name(), "/", "-") + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+func TestRegisterInstanceRejectsTakenName(t *testing.T) {
+	client := newTestClient(t)
+	scope := testScope(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*instanceLeaseTTL)
+	defer cancel()
+
+	first, err := client.RegisterInstance(ctx, scope, "replica-1")
+	if err != nil {
+		t.Fatalf("RegisterInstance() error = %v", err)
+	}
+	t.Cleanup(func() { first.Release(context.Background()) })
+
+	// the lease of the first registration is kept alive, so the name is never freed while waiting for it
+	second, err := client.RegisterInstance(ctx, scope, "replica-1")
+	if !errors.Is(err, ErrInstanceNameTaken) {
+		t.Fatalf("RegisterInstance() = %v, error = %v, want %v", second, err, ErrInstanceNameTaken)
+	}
+}
+
+func TestRegisterInstanceAcceptsDistinctNames(t *testing.T) {
+	client := newTestClient(t)
+	scope := testScope(t)
+
+	for _, name := range []string{"replica-1", "replica-2"} {
+		registration, err := client.RegisterInstance(context.Background(), scope, name)
+		if err != nil {
+			t.Fatalf("RegisterInstance(%q) error = %v", name, err)
+		}
+		t.Cleanup(func() { registration.Release(context.Background()) })
+	}
+}
+
+func TestReleasedInstanceNameCanBeRegisteredRightAway(t *testing.T) {
+	client := newTestClient(t)
+	scope := testScope(t)
+
+	first, err := client.RegisterInstance(context.Background(), scope, "replica-1")
+	if err != nil {
+		t.Fatalf("RegisterInstance() error = %v", err)
+	}
+	first.Release(context.Background())
+
+	// without retrying, as the name was released before its lease expired
+	ctx, cancel := context.WithTimeout(context.Background(), instanceRetryBackoff/2)
+	defer cancel()
+	second, err := client.RegisterInstance(ctx, scope, "replica-1")
+	if err != nil {
+		t.Fatalf("RegisterInstance() after Release() error = %v", err)
+	}
+	second.Release(context.Background())
+}
+
+func TestRegisterInstanceGivesUpWhenContextIsDone(t *testing.T) {
+	client := newTestClient(t)
+	scope := testScope(t)
+
+	first, err := client.RegisterInstance(context.Background(), scope, "replica-1")
+	if err != nil {
+		t.Fatalf("RegisterInstance() error = %v", err)
+	}
+	t.Cleanup(func() { first.Release(context.Background()) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.RegisterInstance(ctx, scope, "replica-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RegisterInstance() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
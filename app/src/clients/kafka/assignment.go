@@ -0,0 +1,95 @@
+package kafka
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type rebalanceCallback = func(context.Context, *kgo.Client, map[string][]int32)
+
+// assignment keeps the partitions assigned to the group member, shared by the builder and the client.
+type assignment struct {
+	mu         sync.RWMutex
+	partitions map[string]map[int32]struct{}
+}
+
+// track wraps the rebalance callbacks of the config, so the assignment is updated whatever they are. Revoked
+// partitions are removed before the callback runs, consumers stop processing them while it waits for the in-flight
+// handlers. A nil OnPartitionsRevoked is left as is, as it tells the driver to commit on revoke by itself.
+func (a *assignment) track(config *ConsumerGroupConfig) {
+	onAssigned := config.OnPartitionsAssigned
+	config.OnPartitionsAssigned = func(ctx context.Context, cl *kgo.Client, assigned map[string][]int32) {
+		a.assign(assigned)
+		if onAssigned != nil {
+			onAssigned(ctx, cl, assigned)
+		}
+	}
+	config.OnPartitionsRevoked = a.removing(config.OnPartitionsRevoked)
+	config.OnPartitionsLost = a.removing(config.OnPartitionsLost)
+}
+
+func (a *assignment) removing(callback rebalanceCallback) rebalanceCallback {
+	if callback == nil {
+		return nil
+	}
+	return func(ctx context.Context, cl *kgo.Client, removed map[string][]int32) {
+		a.remove(removed)
+		callback(ctx, cl, removed)
+	}
+}
+
+func (a *assignment) assign(assigned map[string][]int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.partitions == nil {
+		a.partitions = make(map[string]map[int32]struct{}, len(assigned))
+	}
+	for topic, partitions := range assigned {
+		owned, ok := a.partitions[topic]
+		if !ok {
+			owned = make(map[int32]struct{}, len(partitions))
+			a.partitions[topic] = owned
+		}
+		for _, partition := range partitions {
+			owned[partition] = struct{}{}
+		}
+	}
+}
+
+func (a *assignment) remove(removed map[string][]int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for topic, partitions := range removed {
+		for _, partition := range partitions {
+			delete(a.partitions[topic], partition)
+		}
+		if len(a.partitions[topic]) == 0 {
+			delete(a.partitions, topic)
+		}
+	}
+}
+
+func (a *assignment) topic(topic string) []int32 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	partitions := make([]int32, 0, len(a.partitions[topic]))
+	for partition := range a.partitions[topic] {
+		partitions = append(partitions, partition)
+	}
+	slices.Sort(partitions)
+	return partitions
+}
+
+func (a *assignment) owns(topic string, partition int32) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	_, owned := a.partitions[topic][partition]
+	return owned
+}
@@ -0,0 +1,39 @@
+package kafka
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestAssignmentTracksRebalanceCallbacks(t *testing.T) {
+	var revokeSawOwned bool
+	var a assignment
+	config := &ConsumerGroupConfig{
+		OnPartitionsRevoked: func(context.Context, *kgo.Client, map[string][]int32) {
+			revokeSawOwned = a.owns("emails", 1)
+		},
+	}
+	a.track(config)
+
+	config.OnPartitionsAssigned(context.Background(), nil, map[string][]int32{"emails": {2, 0, 1}, "other": {0}})
+	if got := a.topic("emails"); !slices.Equal(got, []int32{0, 1, 2}) {
+		t.Fatalf("assigned partitions %v, want [0 1 2]", got)
+	}
+
+	config.OnPartitionsRevoked(context.Background(), nil, map[string][]int32{"emails": {1}, "other": {0}})
+	if revokeSawOwned {
+		t.Fatal("revoked partition was still owned while the revoke callback ran")
+	}
+	if got := a.topic("emails"); !slices.Equal(got, []int32{0, 2}) {
+		t.Fatalf("assigned partitions %v after revoke, want [0 2]", got)
+	}
+	if a.owns("other", 0) || len(a.topic("other")) != 0 {
+		t.Fatal("partition of topic 'other' is still owned after being revoked")
+	}
+	if config.OnPartitionsLost != nil {
+		t.Fatal("nil lost callback was replaced, the driver would no longer fall back to the revoke one")
+	}
+}
@@ -1,10 +1,12 @@
 package kafka
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/perr"
 	"chat/src/util"
 	"context"
 	"errors"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/samber/oops"
@@ -14,14 +16,36 @@ import (
 var ErrAlreadyStarted = errors.New("kafka client already started")
 
 const (
-	AdminClientName = "kafka.admin"
-	DataClientName  = "kafka.data"
+	AdminClientName = components.KafkaAdmin
+	DataClientName  = components.KafkaData
 )
 
 type Client struct {
-	logger  zerolog.Logger
-	options []kgo.Opt
-	Driver  *kgo.Client
+	logger      zerolog.Logger
+	options     []kgo.Opt
+	revokeHooks *revokeHooks
+	dataLoss    *dataLoss
+	assignment  *assignment
+	Driver      *kgo.Client
+}
+
+// PartitionsRevokedHook runs in the default OnPartitionsRevoked callback, before the offsets marked so far are
+// committed. The rebalance is delayed until all hooks return, so they must bound their own duration.
+type PartitionsRevokedHook func(ctx context.Context, revoked map[string][]int32)
+
+type revokeHooks struct {
+	mu    sync.Mutex
+	hooks []PartitionsRevokedHook
+}
+
+func (h *revokeHooks) run(ctx context.Context, revoked map[string][]int32) {
+	h.mu.Lock()
+	hooks := append([]PartitionsRevokedHook(nil), h.hooks...)
+	h.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(ctx, revoked)
+	}
 }
 
 func NewClient(config ConfigurationBuilder) (*Client, error) {
@@ -34,12 +58,33 @@ func NewClient(config ConfigurationBuilder) (*Client, error) {
 	}
 
 	return &Client{
-		logger:  config.logger.Client,
-		options: options,
-		Driver:  nil,
+		logger:      config.logger.Client,
+		options:     options,
+		revokeHooks: config.revokeHooks,
+		dataLoss:    config.dataLoss,
+		assignment:  config.assignment,
+		Driver:      nil,
 	}, nil
 }
 
+// OnPartitionsRevoked registers a hook run when partitions are revoked, see PartitionsRevokedHook.
+// Hooks are not run when the consumer group config has its own OnPartitionsRevoked or blocks rebalances on poll.
+func (c *Client) OnPartitionsRevoked(hook PartitionsRevokedHook) {
+	c.revokeHooks.mu.Lock()
+	defer c.revokeHooks.mu.Unlock()
+	c.revokeHooks.hooks = append(c.revokeHooks.hooks, hook)
+}
+
+// AssignedPartitions returns the partitions of the topic currently assigned to the consumer group member, sorted.
+func (c *Client) AssignedPartitions(topic string) []int32 {
+	return c.assignment.topic(topic)
+}
+
+// OwnsPartition reports whether the partition is currently assigned to the consumer group member.
+func (c *Client) OwnsPartition(topic string, partition int32) bool {
+	return c.assignment.owns(topic, partition)
+}
+
 func (c *Client) Start(_ context.Context) error {
 	if c.Driver != nil {
 		return ErrAlreadyStarted
@@ -7,8 +7,6 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"math"
-	"math/rand"
 	"reflect"
 	"strings"
 	"time"
@@ -22,15 +20,38 @@ import (
 	"github.com/twmb/franz-go/plugin/kzerolog"
 )
 
+// Consume reset policies, applied when the group has no committed offset for a partition or the committed
+// offset is out of range.
+const (
+	ResetPolicyEarliest = "earliest" // replay the partition from its start
+	ResetPolicyLatest   = "latest"   // consume only the records produced from now on
+)
+
+// ResetOffset returns the consume reset offset of a reset policy, unknown policies reset to the start.
+func ResetOffset(policy string) kgo.Offset {
+	if policy == ResetPolicyLatest {
+		return kgo.NewOffset().AtEnd()
+	}
+	return kgo.NewOffset().AtStart()
+}
+
 type GeneralConfig struct {
-	ClientID               string        `validate:"required,printascii,min=10,max=50"`
-	ServiceName            string        `validate:"required,printascii,min=5,max=50"`
-	ServiceVersion         string        `validate:"required,min=1,max=30"`
-	SeedBrokers            []string      `validate:"required,min=1,max=10,unique,dive,required,hostname_port"`
-	TLSConfig              *tls.Config   `validate:"required"`
-	Username               string        `validate:"required_with=Password,required,min=5,max=50"`
-	Password               string        `validate:"required_with=Username,required,min=5,max=50"`
-	RequestTimeoutOverhead time.Duration `validate:"min=1000000000,max=15000000000" default:"5s"` // [1s, 15s], default 5s
+	ClientID               string            `validate:"required,printascii,min=10,max=50"`
+	ServiceName            string            `validate:"required,printascii,min=5,max=50"`
+	ServiceVersion         string            `validate:"required,min=1,max=30"`
+	SeedBrokers            []string          `validate:"required,min=1,max=10,unique,dive,required,hostname_port"`
+	TLSConfig              *tls.Config       `validate:"required"`
+	Username               string            `validate:"required_with=Password,required,min=5,max=50"`
+	Password               string            `validate:"required_with=Username,required,min=5,max=50"`
+	RequestTimeoutOverhead time.Duration     `validate:"min=1000000000,max=15000000000" default:"5s"` // [1s, 15s], default 5s
+	AddressTranslator      AddressTranslator // optional, rewrites broker addresses before dialing
+	Metrics                MetricsRegistry   // optional, receives the metrics of the requests made to the brokers
+}
+
+// AddressTranslator rewrites a "host:port" broker address, i.e. when brokers advertise addresses
+// which are not resolvable from where the client runs.
+type AddressTranslator interface {
+	TranslateHostPort(address string) string
 }
 
 type ProducerConfig struct {
@@ -46,6 +67,8 @@ type ProducerConfig struct {
 	UnknownTopicRetries   int                    `validate:"gte=0,lte=5" default:"1"`                        // [0, 5], default 1
 	ProducerLinger        time.Duration          `validate:"gte=-1,lte=10000000000" default:"50ms"`          // [-1, 10s], default 50ms (-1 = disabled)
 	RecordDeliveryTimeout time.Duration          `validate:"gte=10000000000,lte=300000000000" default:"30s"` // [10s, 5min], default 30s
+	DataLossPolicy        DataLossPolicy         `validate:"lte=2"`                                          // detected data loss is always logged
+	OnDataLoss            DataLossFunc           `validate:"required_if=DataLossPolicy 1"`                   // called under DataLossPolicyCallback
 }
 
 type TransactionConfig struct {
@@ -89,6 +112,9 @@ type ConsumerGroupConfig struct {
 	AutoCommitMarks      bool
 	AutoCommitInterval   time.Duration `validate:"gte=100000000,lte=10000000000" default:"5s"` // [100ms, 10s], default 5s
 	AutoCommitCallback   func(*kgo.Client, *kmsg.OffsetCommitRequest, *kmsg.OffsetCommitResponse, error)
+	// TopicResetOffsets overrides, per topic, ConsumeResetOffset for partitions the group has no committed offset for,
+	// i.e. a topic which must not be replayed when the group is created. Partitions with a committed one resume from it.
+	TopicResetOffsets map[string]kgo.Offset
 }
 
 type ConfigurationLoggers struct {
@@ -97,18 +123,24 @@ type ConfigurationLoggers struct {
 }
 
 type ConfigurationBuilder struct {
-	options  map[string]kgo.Opt
-	required []string
-	err      error
-	logger   *ConfigurationLoggers
+	options     map[string]kgo.Opt
+	required    []string
+	err         error
+	logger      *ConfigurationLoggers
+	revokeHooks *revokeHooks
+	dataLoss    *dataLoss
+	assignment  *assignment
 }
 
 func NewConfigurationBuilder(loggers *ConfigurationLoggers) ConfigurationBuilder {
 	return ConfigurationBuilder{
-		options:  make(map[string]kgo.Opt),
-		required: []string{"ClientID"},
-		err:      nil,
-		logger:   loggers,
+		options:     make(map[string]kgo.Opt),
+		required:    []string{"ClientID"},
+		err:         nil,
+		logger:      loggers,
+		revokeHooks: &revokeHooks{},
+		dataLoss:    &dataLoss{},
+		assignment:  &assignment{},
 	}
 }
 
@@ -119,25 +151,17 @@ func (b *ConfigurationBuilder) SetGeneralConfig(config *GeneralConfig) bool {
 
 	return b.setOption("ClientID", kgo.ClientID(config.ClientID)) &&
 		b.setOption("DialTimeout", kgo.DialTimeout(5*time.Second)) &&
-		((config.TLSConfig != nil && b.setOption("DialTLSConfig", kgo.DialTLSConfig(config.TLSConfig))) || true) &&
+		((config.TLSConfig != nil && config.AddressTranslator == nil && b.setOption("DialTLSConfig", kgo.DialTLSConfig(config.TLSConfig))) || true) &&
+		((config.AddressTranslator != nil && b.setOption("Dialer", kgo.Dialer(newTranslatingDialer(config.TLSConfig, config.AddressTranslator)))) || true) &&
 		b.setOption("RequestTimeoutOverhead", kgo.RequestTimeoutOverhead(config.RequestTimeoutOverhead)) &&
 		b.setOption("ConnIdleTimeout", kgo.ConnIdleTimeout(10*time.Minute)) &&
 		b.setOption("SoftwareNameAndVersion", kgo.SoftwareNameAndVersion(config.ServiceName, config.ServiceVersion)) &&
 		b.setOption("WithLogger", kgo.WithLogger(kzerolog.New(&b.logger.Driver))) &&
 		b.setOption("SeedBrokers", kgo.SeedBrokers(config.SeedBrokers...)) &&
+		((config.Metrics != nil && b.setOption("WithHooks", kgo.WithHooks(&metricsHook{registry: config.Metrics}))) || true) &&
 		b.setOption("RetryBackoffFn", kgo.RetryBackoffFn(func(attempts int) time.Duration {
 			// Start at 100ms and double up to a max of 5s
-			baseDelay := 100 * time.Millisecond
-			maxDelay := 5 * time.Second
-
-			// Calculate 2^attempts (clamped)
-			delay := min(time.Duration(baseDelay.Nanoseconds()*int64(math.Pow(2, float64(attempts)))), maxDelay)
-
-			// Add jitter (e.g., +/- 20% randomness)
-			jitter := time.Duration(rand.Float64() * float64(delay.Nanoseconds()) * 0.4) //nolint:gosec    // 40% range
-			delay = delay - (delay / 5) + jitter                                         // Apply -20% offset and add jitter up to +20%
-
-			return delay
+			return util.ExponentialBackoff(attempts, 100*time.Millisecond, 5*time.Second)
 		})) &&
 		b.setOption("RetryTimeout", kgo.RetryTimeout(30*time.Second)) &&
 		b.setOption("RetryTimeoutFn", kgo.RetryTimeoutFn(func(req int16) time.Duration {
@@ -256,12 +280,9 @@ func (b *ConfigurationBuilder) SetProducerConfig(config *ProducerConfig) bool {
 		b.setOption("RecordDeliveryTimeout", kgo.RecordDeliveryTimeout(config.RecordDeliveryTimeout)) &&
 		b.setOption("ConsiderMissingTopicDeletedAfter", kgo.ConsiderMissingTopicDeletedAfter(20*time.Second)) &&
 		b.setOption("RecordPartitioner", kgo.RecordPartitioner(*config.RecordPartitioner)) &&
-		b.setOption("ProducerOnDataLossDetected", kgo.ProducerOnDataLossDetected(func(topic string, partition int32) {
-			// CRITICAL: Log this event and send an alert (e.g., to PagerDuty or Slack)
-			b.logger.Client.Error().Msgf("!!! CRITICAL KAFKA PRODUCER DATA LOSS DETECTED !!! Topic: %s, Partition: %d. Producer is CONTINUING.", topic, partition)
-			// We would also need call an alerting service here:
-			// AlertService.Trigger("Kafka Data Loss", fmt.Sprintf("Topic: %s, Partition: %d", topic, partition))
-		}))
+		b.setOption("ProducerOnDataLossDetected", kgo.ProducerOnDataLossDetected(
+			b.dataLoss.handler(config.DataLossPolicy, config.OnDataLoss, &b.logger.Client),
+		))
 }
 
 func (b *ConfigurationBuilder) SetTransactionConfig(config *TransactionConfig) bool {
@@ -341,7 +362,15 @@ func (b *ConfigurationBuilder) SetConsumerGroupConfig(config *ConsumerGroupConfi
 		config.OnPartitionsRevoked = func(ctx context.Context, cl *kgo.Client, revoked map[string][]int32) {
 			b.logger.Client.Warn().Msgf("Partitions revoked: %v", revoked)
 
-			if err := cl.CommitUncommittedOffsets(ctx); err != nil {
+			// hooks let the consumers finish the in-flight processing of revoked partitions, so it gets committed
+			b.revokeHooks.run(ctx, revoked)
+
+			// with AutoCommitMarks only the marked offsets must be committed, the consumed ones might still be processed
+			commit := cl.CommitUncommittedOffsets
+			if config.AutoCommitMarks {
+				commit = cl.CommitMarkedOffsets
+			}
+			if err := commit(ctx); err != nil {
 				b.logger.Client.Error().Err(err).Msg("Blocking commit in OnPartitionsRevoked failed.")
 			} else {
 				b.logger.Client.Info().Msg("Successfully committed uncommitted offsets before revocation.")
@@ -423,6 +452,7 @@ func (b *ConfigurationBuilder) SetConsumerGroupConfig(config *ConsumerGroupConfi
 			return nil
 		}
 	}
+	b.assignment.track(config)
 
 	return b.setOption("ConsumerGroup", kgo.ConsumerGroup(config.GroupID)) &&
 		((config.InstanceID != "" && b.setOption("InstanceID", kgo.InstanceID(config.InstanceID))) || true) &&
@@ -439,7 +469,36 @@ func (b *ConfigurationBuilder) SetConsumerGroupConfig(config *ConsumerGroupConfi
 		((config.GreedyAutoCommit && b.setOption("GreedyAutoCommit", kgo.GreedyAutoCommit())) || true) &&
 		((config.AutoCommitMarks && b.setOption("AutoCommitMarks", kgo.AutoCommitMarks())) || true) &&
 		b.setOption("AutoCommitInterval", kgo.AutoCommitInterval(config.AutoCommitInterval)) &&
-		((config.AutoCommitCallback != nil && b.setOption("AutoCommitCallback", kgo.AutoCommitCallback(config.AutoCommitCallback))) || true)
+		((config.AutoCommitCallback != nil && b.setOption("AutoCommitCallback", kgo.AutoCommitCallback(config.AutoCommitCallback))) || true) &&
+		((len(config.TopicResetOffsets) > 0 && b.setOption("AdjustFetchOffsetsFn", kgo.AdjustFetchOffsetsFn(
+			b.topicResetOffsetsAdjuster(config.TopicResetOffsets),
+		))) || true)
+}
+
+// topicResetOffsetsAdjuster returns the AdjustFetchOffsetsFn applying the reset offsets of the topics. The client
+// hands partitions without a committed offset with the relative ConsumeResetOffset, while committed ones are exact.
+func (b *ConfigurationBuilder) topicResetOffsetsAdjuster(
+	resets map[string]kgo.Offset,
+) func(context.Context, map[string]map[int32]kgo.Offset) (map[string]map[int32]kgo.Offset, error) {
+	return func(_ context.Context, offsets map[string]map[int32]kgo.Offset) (map[string]map[int32]kgo.Offset, error) {
+		for topic, partitions := range offsets {
+			reset, ok := resets[topic]
+			if !ok {
+				continue
+			}
+			for partition, offset := range partitions {
+				if offset.EpochOffset().Offset >= 0 {
+					continue
+				}
+				partitions[partition] = reset
+				b.logger.Client.Info().Msgf(
+					"Group has no committed offset for topic-partition %s-%d, applying the reset offset of the topic",
+					topic, partition,
+				)
+			}
+		}
+		return offsets, nil
+	}
 }
 
 func (b *ConfigurationBuilder) applyDefaultsAndValidate(config any) bool {
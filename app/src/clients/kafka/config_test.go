@@ -0,0 +1,119 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// newConfigTestBuilder returns a builder of a consumer group client of an unreachable broker.
+func newConfigTestBuilder() ConfigurationBuilder {
+	builder := NewConfigurationBuilder(&ConfigurationLoggers{Client: zerolog.Nop(), Driver: zerolog.Nop()})
+	builder.SetGeneralConfig(&GeneralConfig{
+		ClientID:       "config-test-client",
+		ServiceName:    "config-test",
+		ServiceVersion: "test",
+		SeedBrokers:    []string{"127.0.0.1:1"},
+		TLSConfig:      &tls.Config{MinVersion: tls.VersionTLS12},
+		Username:       "config-test",
+		Password:       "config-test",
+	})
+	builder.SetConsumerGroupConfig(&ConsumerGroupConfig{GroupID: "config-test-group"})
+	return builder
+}
+
+func TestConsumeResetPolicyIsApplied(t *testing.T) {
+	tests := []struct {
+		policy     string
+		wantOffset int64 // of the AtStart and AtEnd relative offsets
+	}{
+		{policy: ResetPolicyEarliest, wantOffset: -2},
+		{policy: ResetPolicyLatest, wantOffset: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			builder := newConfigTestBuilder()
+			resetOffset := ResetOffset(tt.policy)
+			builder.SetConsumerConfig(&ConsumerConfig{ConsumeResetOffset: &resetOffset})
+
+			client, err := NewClient(builder)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			if err := client.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			t.Cleanup(func() { client.Stop(context.Background()) })
+
+			applied, ok := client.Driver.OptValue(kgo.ConsumeResetOffset).(kgo.Offset)
+			if !ok {
+				t.Fatalf("ConsumeResetOffset option = %v, want a kgo.Offset", client.Driver.OptValue(kgo.ConsumeResetOffset))
+			}
+			if got := applied.EpochOffset().Offset; got != tt.wantOffset {
+				t.Fatalf("applied reset offset = %d, want %d", got, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestTopicResetOffsetsApplyOnlyWithoutCommittedOffset(t *testing.T) {
+	builder := NewConfigurationBuilder(&ConfigurationLoggers{Client: zerolog.Nop(), Driver: zerolog.Nop()})
+	adjust := builder.topicResetOffsetsAdjuster(map[string]kgo.Offset{"email-delivery": ResetOffset(ResetPolicyLatest)})
+
+	// the client hands the partitions of a fresh group with the consume reset offset, the committed ones exactly
+	groupReset := ResetOffset(ResetPolicyEarliest)
+	committed := kgo.NewOffset().At(42)
+	offsets, err := adjust(context.Background(), map[string]map[int32]kgo.Offset{
+		"email-delivery": {0: groupReset, 1: committed},
+		"chat-messages":  {0: groupReset, 1: committed},
+	})
+	if err != nil {
+		t.Fatalf("adjust() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		topic     string
+		partition int32
+		want      int64
+	}{
+		{name: "fresh group starts email at end", topic: "email-delivery", partition: 0, want: -1},
+		{name: "existing group resumes email from committed", topic: "email-delivery", partition: 1, want: 42},
+		{name: "fresh group starts other topics from consume reset offset", topic: "chat-messages", partition: 0, want: -2},
+		{name: "existing group resumes other topics from committed", topic: "chat-messages", partition: 1, want: 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := offsets[tt.topic][tt.partition].EpochOffset().Offset; got != tt.want {
+				t.Fatalf("offset of %s-%d = %d, want %d", tt.topic, tt.partition, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopicResetOffsetsAreInstalledOnlyWhenConfigured(t *testing.T) {
+	tests := []struct {
+		name   string
+		resets map[string]kgo.Offset
+		want   bool
+	}{
+		{name: "no resets"},
+		{name: "email resets", resets: map[string]kgo.Offset{"email-delivery": ResetOffset(ResetPolicyLatest)}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := NewConfigurationBuilder(&ConfigurationLoggers{Client: zerolog.Nop(), Driver: zerolog.Nop()})
+			builder.SetConsumerGroupConfig(&ConsumerGroupConfig{GroupID: "config-test-group", TopicResetOffsets: tt.resets})
+			if builder.err != nil {
+				t.Fatalf("SetConsumerGroupConfig() error = %v", builder.err)
+			}
+			if _, installed := builder.options["AdjustFetchOffsetsFn"]; installed != tt.want {
+				t.Fatalf("AdjustFetchOffsetsFn installed = %t, want %t", installed, tt.want)
+			}
+		})
+	}
+}
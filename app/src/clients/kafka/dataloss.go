@@ -0,0 +1,75 @@
+package kafka
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DataLossPolicy tells what happens, besides logging, when the producer detects data loss, i.e. the broker lost
+// records of a partition after acknowledging them. The producer always continues.
+type DataLossPolicy uint8
+
+const (
+	DataLossPolicyContinue DataLossPolicy = iota
+	// DataLossPolicyCallback hands the topic-partition to OnDataLoss, i.e. to trip a circuit breaker.
+	DataLossPolicyCallback
+	// DataLossPolicyUnhealthy reports the client as being in a bad state to the health controller, until restarted.
+	DataLossPolicyUnhealthy
+)
+
+// ParseDataLossPolicy returns the policy named "continue" or "unhealthy" in config, unknown names only log the
+// data loss. The callback policy can't be configured, as it requires OnDataLoss.
+func ParseDataLossPolicy(name string) DataLossPolicy {
+	switch name {
+	case "unhealthy":
+		return DataLossPolicyUnhealthy
+	default:
+		return DataLossPolicyContinue
+	}
+}
+
+// DataLossFunc receives the topic-partition on which the producer detected data loss.
+type DataLossFunc func(topic string, partition int32)
+
+// dataLoss keeps the data losses detected under DataLossPolicyUnhealthy, shared by the builder and the client.
+type dataLoss struct {
+	mu         sync.Mutex
+	detections uint64
+	topic      string // of the last detection
+	partition  int32  // of the last detection
+	detectedAt time.Time
+}
+
+func (d *dataLoss) handler(policy DataLossPolicy, onDataLoss DataLossFunc, logger *zerolog.Logger) DataLossFunc {
+	return func(topic string, partition int32) {
+		logger.Error().Msgf("!!! CRITICAL KAFKA PRODUCER DATA LOSS DETECTED !!! Topic: %s, Partition: %d. Producer is CONTINUING.", topic, partition)
+
+		switch policy {
+		case DataLossPolicyCallback:
+			onDataLoss(topic, partition)
+		case DataLossPolicyUnhealthy:
+			d.mu.Lock()
+			d.detections++
+			d.topic, d.partition, d.detectedAt = topic, partition, time.Now()
+			d.mu.Unlock()
+		case DataLossPolicyContinue:
+		}
+	}
+}
+
+// detected describes the data losses recorded so far, empty when there were none.
+func (d *dataLoss) detected() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.detections == 0 {
+		return ""
+	}
+	return fmt.Sprintf(
+		"producer detected data loss %d times, last on topic-partition %s-%d at %s",
+		d.detections, d.topic, d.partition, d.detectedAt.Format(time.RFC3339),
+	)
+}
@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParseDataLossPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		want DataLossPolicy
+	}{
+		{name: "continue", want: DataLossPolicyContinue},
+		{name: "unhealthy", want: DataLossPolicyUnhealthy},
+		{name: "callback", want: DataLossPolicyContinue},
+		{name: "", want: DataLossPolicyContinue},
+	}
+	for _, tt := range tests {
+		if got := ParseDataLossPolicy(tt.name); got != tt.want {
+			t.Errorf("ParseDataLossPolicy(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDataLossPolicyFiresOnDetection(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       DataLossPolicy
+		wantCallback bool
+		wantDetected bool
+	}{
+		{name: "continue", policy: DataLossPolicyContinue},
+		{name: "callback", policy: DataLossPolicyCallback, wantCallback: true},
+		{name: "unhealthy", policy: DataLossPolicyUnhealthy, wantDetected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := zerolog.New(&logs)
+			var called []string
+			onDataLoss := func(topic string, partition int32) {
+				called = append(called, fmt.Sprintf("%s-%d", topic, partition))
+			}
+			var loss dataLoss
+
+			handler := loss.handler(tt.policy, onDataLoss, &logger)
+			handler("emails", 3)
+			handler("emails", 5)
+
+			if !strings.Contains(logs.String(), "DATA LOSS DETECTED !!! Topic: emails, Partition: 3") {
+				t.Errorf("logs = %s, want the data loss to be logged under every policy", logs.String())
+			}
+			var wantCalled []string
+			if tt.wantCallback {
+				wantCalled = []string{"emails-3", "emails-5"}
+			}
+			if !slices.Equal(called, wantCalled) {
+				t.Errorf("OnDataLoss calls = %v, want %v", called, wantCalled)
+			}
+			detected := loss.detected()
+			if tt.wantDetected != (detected != "") {
+				t.Fatalf("detected() = %q, want detected: %t", detected, tt.wantDetected)
+			}
+			if tt.wantDetected && !strings.Contains(detected, "data loss 2 times, last on topic-partition emails-5") {
+				t.Errorf("detected() = %q, want the count and the last topic-partition", detected)
+			}
+		})
+	}
+}
+
+func TestCallbackDataLossPolicyRequiresOnDataLoss(t *testing.T) {
+	builder := newConfigTestBuilder()
+	if builder.SetProducerConfig(&ProducerConfig{DataLossPolicy: DataLossPolicyCallback}) {
+		t.Fatal("SetProducerConfig() = true, want the callback policy without OnDataLoss to be rejected")
+	}
+
+	builder = newConfigTestBuilder()
+	if !builder.SetProducerConfig(&ProducerConfig{DataLossPolicy: DataLossPolicyCallback, OnDataLoss: func(string, int32) {}}) {
+		t.Fatalf("SetProducerConfig() = false, want the callback policy with OnDataLoss: %v", builder.err)
+	}
+}
+
+func TestClientSeesDataLossDetectedByItsProducer(t *testing.T) {
+	builder := newConfigTestBuilder()
+	if !builder.SetProducerConfig(&ProducerConfig{DataLossPolicy: DataLossPolicyUnhealthy}) {
+		t.Fatalf("SetProducerConfig() error = %v", builder.err)
+	}
+	client, err := NewClient(builder)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if detected := client.dataLoss.detected(); detected != "" {
+		t.Fatalf("detected() = %q before any data loss, want none", detected)
+	}
+
+	// as the producer hook installed by SetProducerConfig does
+	logger := zerolog.Nop()
+	builder.dataLoss.handler(DataLossPolicyUnhealthy, nil, &logger)("emails", 1)
+	if detected := client.dataLoss.detected(); !strings.Contains(detected, "topic-partition emails-1") {
+		t.Fatalf("detected() = %q, want the data loss of the producer to make the client unhealthy", detected)
+	}
+}
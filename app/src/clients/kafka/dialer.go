@@ -0,0 +1,46 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+const dialTimeout = 5 * time.Second
+
+// newTranslatingDialer returns a dial function which connects to the translated broker address, while TLS
+// is verified against the broker hostname as advertised, so certificates don't need to cover translated addresses.
+func newTranslatingDialer(tlsConfig *tls.Config, translator AddressTranslator) func(ctx context.Context, network, host string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	return func(ctx context.Context, network, host string) (net.Conn, error) {
+		translated := translator.TranslateHostPort(host)
+
+		conn, err := dialer.DialContext(ctx, network, translated)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial broker '%s' translated to '%s': %w", host, translated, err)
+		}
+		if tlsConfig == nil {
+			return conn, nil
+		}
+
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			serverName, _, err := net.SplitHostPort(host)
+			if err != nil {
+				_ = conn.Close()
+				return nil, fmt.Errorf("failed to extract server name from broker address '%s': %w", host, err)
+			}
+			config.ServerName = serverName
+		}
+
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed tls handshake with broker '%s' translated to '%s': %w", host, translated, err)
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,115 @@
+package kafka
+
+import (
+	"chat/src/platform/security/securitytest"
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeTranslator struct {
+	mapping    map[string]string
+	translated []string
+}
+
+func (f *fakeTranslator) TranslateHostPort(address string) string {
+	f.translated = append(f.translated, address)
+	if translation, ok := f.mapping[address]; ok {
+		return translation
+	}
+	return address
+}
+
+// newTestBroker listens on 127.0.0.1, accepting connections until the test ends. When serverTLS is set, it
+// completes the handshake of the connections it accepts.
+func newTestBroker(t *testing.T, serverTLS *tls.Config) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	var mutex sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		_ = listener.Close()
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			conns = append(conns, conn)
+			mutex.Unlock()
+			if serverTLS != nil {
+				tlsConn := tls.Server(conn, serverTLS)
+				_ = tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+				_ = tlsConn.Handshake()
+			}
+		}
+	}()
+	return listener
+}
+
+func TestTranslatingDialerDialsTranslatedAddress(t *testing.T) {
+	broker := newTestBroker(t, nil)
+	translator := &fakeTranslator{mapping: map[string]string{"broker-1.internal:9092": broker.Addr().String()}}
+	dial := newTranslatingDialer(nil, translator)
+
+	conn, err := dial(context.Background(), "tcp", "broker-1.internal:9092")
+	if err != nil {
+		t.Fatalf("dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if conn.RemoteAddr().String() != broker.Addr().String() {
+		t.Fatalf("expected to dial the translated address '%s', dialed '%s'", broker.Addr(), conn.RemoteAddr())
+	}
+	if len(translator.translated) != 1 || translator.translated[0] != "broker-1.internal:9092" {
+		t.Fatalf("expected the advertised address to be translated, translated %v", translator.translated)
+	}
+}
+
+func TestTranslatingDialerVerifiesAdvertisedHostname(t *testing.T) {
+	serverTLS, clientTLS := securitytest.NewTLSConfigs(t, "broker-1.internal")
+	broker := newTestBroker(t, serverTLS)
+	translator := &fakeTranslator{mapping: map[string]string{"broker-1.internal:9093": broker.Addr().String()}}
+	dial := newTranslatingDialer(clientTLS, translator)
+
+	conn, err := dial(context.Background(), "tcp", "broker-1.internal:9093")
+	if err != nil {
+		t.Fatalf("expected the certificate to be verified against the advertised hostname, dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("expected a TLS connection, got %T", conn)
+	}
+	if clientTLS.ServerName != "" {
+		t.Fatal("expected the TLS config of the client not to be modified")
+	}
+}
+
+func TestTranslatingDialerReportsBothAddressesOnFailure(t *testing.T) {
+	broker := newTestBroker(t, nil)
+	unreachable := broker.Addr().String()
+	_ = broker.Close()
+	translator := &fakeTranslator{mapping: map[string]string{"broker-1.internal:9092": unreachable}}
+	dial := newTranslatingDialer(nil, translator)
+
+	_, err := dial(context.Background(), "tcp", "broker-1.internal:9092")
+	if err == nil || !strings.Contains(err.Error(), "broker-1.internal:9092") || !strings.Contains(err.Error(), unreachable) {
+		t.Fatalf("expected error naming the advertised and the translated address, got: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package kafka
+
+import (
+	envelopev1 "chat/src/gen/proto/envelope/v1"
+	"errors"
+	"fmt"
+
+	"buf.build/go/protovalidate"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const ContentTypeProtobuf = "application/x-protobuf"
+
+var (
+	ErrMalformedEnvelope      = errors.New("malformed envelope")
+	ErrUnexpectedSchema       = errors.New("envelope carries an unexpected schema")
+	ErrUnknownSchemaVersion   = errors.New("envelope carries an unknown schema version")
+	ErrUnsupportedContentType = errors.New("envelope carries an unsupported content type")
+)
+
+// Schema identifies the payload of an envelope. Version is the one producers write, while consumers accept
+// the versions from 1 up to it, the newer ones being unknown to them.
+type Schema struct {
+	ID      string
+	Version uint32
+}
+
+// SchemaOf returns the schema of the message at the given version, identified by the full name of the message.
+func SchemaOf(message proto.Message, version uint32) Schema {
+	return Schema{ID: string(message.ProtoReflect().Descriptor().FullName()), Version: version}
+}
+
+// Seal encodes the message and wraps it in an envelope of the schema, the trace id being optional.
+func (s Schema) Seal(message proto.Message, traceID string) ([]byte, error) {
+	payload, err := proto.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload of schema '%s': %w", s.ID, err)
+	}
+
+	value, err := proto.Marshal(&envelopev1.Envelope{
+		SchemaId:      s.ID,
+		SchemaVersion: s.Version,
+		ContentType:   ContentTypeProtobuf,
+		ProducedAt:    timestamppb.Now(),
+		TraceId:       traceID,
+		Payload:       payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope of schema '%s': %w", s.ID, err)
+	}
+	return value, nil
+}
+
+// Open unwraps the envelope of a record value into the message. Envelopes of other schemas, of versions newer
+// than the one of the schema or of other content types are rejected, so they can be dead lettered instead of
+// being misread.
+func (s Schema) Open(value []byte, message proto.Message) (*envelopev1.Envelope, error) {
+	var envelope envelopev1.Envelope
+	if err := proto.Unmarshal(value, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedEnvelope, err)
+	}
+	if err := protovalidate.Validate(&envelope); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedEnvelope, err)
+	}
+
+	if envelope.GetSchemaId() != s.ID {
+		return nil, fmt.Errorf("%w: expected '%s', got '%s'", ErrUnexpectedSchema, s.ID, envelope.GetSchemaId())
+	}
+	if envelope.GetSchemaVersion() > s.Version {
+		return nil, fmt.Errorf(
+			"%w: schema '%s' is known up to version %d, got %d",
+			ErrUnknownSchemaVersion, s.ID, s.Version, envelope.GetSchemaVersion(),
+		)
+	}
+	if envelope.GetContentType() != ContentTypeProtobuf {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedContentType, envelope.GetContentType())
+	}
+
+	if err := proto.Unmarshal(envelope.GetPayload(), message); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload of schema '%s' version %d: %w", s.ID, envelope.GetSchemaVersion(), err)
+	}
+	return &envelope, nil
+}
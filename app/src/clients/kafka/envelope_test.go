@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	envelopev1 "chat/src/gen/proto/envelope/v1"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	schema := SchemaOf(&durationpb.Duration{}, 2)
+	if schema.ID != "google.protobuf.Duration" {
+		t.Fatalf("SchemaOf() id = '%s', want the full name of the message", schema.ID)
+	}
+
+	sealedAt := time.Now()
+	value, err := schema.Seal(durationpb.New(3*time.Second), "trace-1")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	var message durationpb.Duration
+	envelope, err := schema.Open(value, &message)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if message.AsDuration() != 3*time.Second {
+		t.Errorf("Open() payload = %v, want 3s", message.AsDuration())
+	}
+	if envelope.GetSchemaId() != schema.ID || envelope.GetSchemaVersion() != 2 {
+		t.Errorf("Open() schema = '%s' version %d, want '%s' version 2", envelope.GetSchemaId(), envelope.GetSchemaVersion(), schema.ID)
+	}
+	if envelope.GetContentType() != ContentTypeProtobuf {
+		t.Errorf("Open() content type = '%s', want '%s'", envelope.GetContentType(), ContentTypeProtobuf)
+	}
+	if envelope.GetTraceId() != "trace-1" {
+		t.Errorf("Open() trace id = '%s', want 'trace-1'", envelope.GetTraceId())
+	}
+	if producedAt := envelope.GetProducedAt().AsTime(); producedAt.Before(sealedAt.Add(-time.Second)) || producedAt.After(time.Now()) {
+		t.Errorf("Open() produced at = %v, want the time of Seal", producedAt)
+	}
+}
+
+func TestEnvelopeOpenAcceptsOlderVersions(t *testing.T) {
+	value, err := SchemaOf(&durationpb.Duration{}, 1).Seal(durationpb.New(time.Second), "")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	var message durationpb.Duration
+	envelope, err := SchemaOf(&durationpb.Duration{}, 3).Open(value, &message)
+	if err != nil {
+		t.Fatalf("Open() error = %v, want envelopes of older versions to be accepted", err)
+	}
+	if envelope.GetSchemaVersion() != 1 || message.AsDuration() != time.Second {
+		t.Fatalf("Open() = version %d payload %v, want version 1 payload 1s", envelope.GetSchemaVersion(), message.AsDuration())
+	}
+}
+
+func TestEnvelopeOpenRejectsUnreadableEnvelopes(t *testing.T) {
+	schema := SchemaOf(&durationpb.Duration{}, 1)
+	sealed := func(t *testing.T, schema Schema) []byte {
+		t.Helper()
+		value, err := schema.Seal(durationpb.New(time.Second), "")
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		return value
+	}
+	envelope := func(t *testing.T, mutate func(envelope *envelopev1.Envelope)) []byte {
+		t.Helper()
+		payload, err := proto.Marshal(durationpb.New(time.Second))
+		if err != nil {
+			t.Fatalf("failed to marshal payload: %v", err)
+		}
+		envelope := &envelopev1.Envelope{
+			SchemaId:      schema.ID,
+			SchemaVersion: 1,
+			ContentType:   ContentTypeProtobuf,
+			ProducedAt:    timestamppb.Now(),
+			Payload:       payload,
+		}
+		mutate(envelope)
+		value, err := proto.Marshal(envelope)
+		if err != nil {
+			t.Fatalf("failed to marshal envelope: %v", err)
+		}
+		return value
+	}
+
+	tests := []struct {
+		name    string
+		value   func(t *testing.T) []byte
+		wantErr error
+	}{
+		{
+			name:    "unknown version",
+			value:   func(t *testing.T) []byte { return sealed(t, SchemaOf(&durationpb.Duration{}, 2)) },
+			wantErr: ErrUnknownSchemaVersion,
+		},
+		{
+			name:    "other schema",
+			value:   func(t *testing.T) []byte { return sealed(t, SchemaOf(&timestamppb.Timestamp{}, 1)) },
+			wantErr: ErrUnexpectedSchema,
+		},
+		{
+			name: "other content type",
+			value: func(t *testing.T) []byte {
+				return envelope(t, func(envelope *envelopev1.Envelope) { envelope.ContentType = "application/json" })
+			},
+			wantErr: ErrUnsupportedContentType,
+		},
+		{
+			name:    "bare payload",
+			value:   func(t *testing.T) []byte { return []byte("not an envelope") },
+			wantErr: ErrMalformedEnvelope,
+		},
+		{
+			name: "zero version",
+			value: func(t *testing.T) []byte {
+				return envelope(t, func(envelope *envelopev1.Envelope) { envelope.SchemaVersion = 0 })
+			},
+			wantErr: ErrMalformedEnvelope,
+		},
+		{
+			name: "missing produced at",
+			value: func(t *testing.T) []byte {
+				return envelope(t, func(envelope *envelopev1.Envelope) { envelope.ProducedAt = nil })
+			},
+			wantErr: ErrMalformedEnvelope,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var message durationpb.Duration
+			envelope, err := schema.Open(tt.value(t), &message)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if envelope != nil {
+				t.Fatalf("Open() envelope = %v, want none on error", envelope)
+			}
+		})
+	}
+}
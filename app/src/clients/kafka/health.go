@@ -29,6 +29,11 @@ func (c *Client) PingShallow(ctx context.Context) health.PingResult {
 		return pingResult
 	}
 
+	if detected := c.dataLoss.detected(); detected != "" {
+		pingResult.SetPingOutput(health.PingCauseBadState, detected)
+		return pingResult
+	}
+
 	return pingResult
 }
 
@@ -73,5 +78,10 @@ func (c *Client) PingDeep(ctx context.Context) health.PingResult {
 		return pingResult
 	}
 
+	if detected := c.dataLoss.detected(); detected != "" {
+		pingResult.SetPingOutput(health.PingCauseBadState, detected)
+		return pingResult
+	}
+
 	return pingResult
 }
@@ -0,0 +1,52 @@
+// Package kafkatest provides the Kafka client of the tests of the consumers, it never reaches a broker.
+package kafkatest
+
+import (
+	"chat/src/clients/kafka"
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// UnreachableBroker is the seed broker of the client, nothing listens on it.
+const UnreachableBroker = "127.0.0.1:1"
+
+// NewClient returns a started consumer group client built as the ones of the application are, whose seed broker is
+// unreachable: topics can be added and paused, and records marked, while fetches never return. It commits marks,
+// as routers require. The configure function is optional, it's called on the group config before the client is built.
+func NewClient(tb testing.TB, configure func(config *kafka.ConsumerGroupConfig)) *kafka.Client {
+	tb.Helper()
+
+	builder := kafka.NewConfigurationBuilder(&kafka.ConfigurationLoggers{Client: zerolog.Nop(), Driver: zerolog.Nop()})
+	groupConfig := &kafka.ConsumerGroupConfig{
+		GroupID:         "kafkatest-group",
+		Balancers:       []kgo.GroupBalancer{kgo.CooperativeStickyBalancer()},
+		AutoCommitMarks: true,
+	}
+	if configure != nil {
+		configure(groupConfig)
+	}
+	builder.SetGeneralConfig(&kafka.GeneralConfig{
+		ClientID:       "kafkatest-client",
+		ServiceName:    "kafkatest",
+		ServiceVersion: "test",
+		SeedBrokers:    []string{UnreachableBroker},
+		TLSConfig:      &tls.Config{MinVersion: tls.VersionTLS12},
+		Username:       "kafkatest",
+		Password:       "kafkatest",
+	})
+	builder.SetConsumerGroupConfig(groupConfig)
+
+	client, err := kafka.NewClient(builder)
+	if err != nil {
+		tb.Fatalf("failed to create Kafka client: %v", err)
+	}
+	if err := client.Start(context.Background()); err != nil {
+		tb.Fatalf("failed to start Kafka client: %v", err)
+	}
+	tb.Cleanup(func() { client.Stop(context.Background()) })
+	return client
+}
@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var ErrNoConsumerGroup = errors.New("kafka client is not configured with a consumer group")
+
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+// offsetsAdmin is the subset of the admin client the lag is computed with, replaced by tests.
+type offsetsAdmin interface {
+	FetchOffsets(ctx context.Context, group string) (kadm.OffsetResponses, error)
+	ListEndOffsets(ctx context.Context, topics ...string) (kadm.ListedOffsets, error)
+	ListStartOffsets(ctx context.Context, topics ...string) (kadm.ListedOffsets, error)
+}
+
+// GroupID returns the id of the consumer group the client is configured with, empty when it isn't a group consumer.
+// Unlike the member id reported by the driver, it's known before the client joins the group.
+func (c *Client) GroupID() string {
+	group, _ := c.Driver.OptValue(kgo.ConsumerGroup).(string)
+	return group
+}
+
+// GroupLag returns, for each partition of the topics consumed by the client, how many records the group of
+// the client is behind the end of the partition. Partitions without a committed offset are reported with the lag
+// the group would have after applying the consume reset offset, i.e. the whole partition when resetting to start.
+func (c *Client) GroupLag(ctx context.Context) (map[TopicPartition]int64, error) {
+	return c.groupLag(ctx, kadm.NewClient(c.Driver))
+}
+
+func (c *Client) groupLag(ctx context.Context, admin offsetsAdmin) (map[TopicPartition]int64, error) {
+	group := c.GroupID()
+	if group == "" {
+		return nil, ErrNoConsumerGroup
+	}
+
+	topics := c.Driver.GetConsumeTopics()
+	if len(topics) == 0 {
+		return make(map[TopicPartition]int64), nil
+	}
+
+	committed, err := admin.FetchOffsets(ctx, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets of group '%s': %w", group, err)
+	}
+	ends, err := admin.ListEndOffsets(ctx, topics...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list end offsets of topics %v: %w", topics, err)
+	}
+
+	resetToEnd := false
+	if resetOffset, ok := c.Driver.OptValue(kgo.ConsumeResetOffset).(kgo.Offset); ok {
+		resetToEnd = resetOffset.EpochOffset().Offset == -1
+	}
+
+	var starts kadm.ListedOffsets
+	if !resetToEnd {
+		if starts, err = admin.ListStartOffsets(ctx, topics...); err != nil {
+			return nil, fmt.Errorf("failed to list start offsets of topics %v: %w", topics, err)
+		}
+	}
+
+	lag := make(map[TopicPartition]int64)
+	var lagErr error
+	ends.Each(func(end kadm.ListedOffset) {
+		if end.Err != nil {
+			lagErr = errors.Join(lagErr, fmt.Errorf("failed to list end offset of %s-%d: %w", end.Topic, end.Partition, end.Err))
+			return
+		}
+
+		partition := TopicPartition{Topic: end.Topic, Partition: end.Partition}
+		if commit, found := committed.Lookup(end.Topic, end.Partition); found && commit.Err == nil && commit.At >= 0 {
+			lag[partition] = max(end.Offset-commit.At, 0)
+			return
+		}
+
+		if resetToEnd {
+			lag[partition] = 0
+			return
+		}
+		start, found := starts.Lookup(end.Topic, end.Partition)
+		if !found || start.Err != nil {
+			lagErr = errors.Join(lagErr, fmt.Errorf("failed to list start offset of %s-%d", end.Topic, end.Partition))
+			return
+		}
+		lag[partition] = max(end.Offset-start.Offset, 0)
+	})
+
+	return lag, lagErr
+}
@@ -0,0 +1,157 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// fakeOffsetsAdmin answers with known offsets, the committed ones being those of the group.
+type fakeOffsetsAdmin struct {
+	group     string
+	committed kadm.OffsetResponses
+	ends      kadm.ListedOffsets
+	starts    kadm.ListedOffsets
+}
+
+func (a *fakeOffsetsAdmin) FetchOffsets(_ context.Context, group string) (kadm.OffsetResponses, error) {
+	if group != a.group {
+		return nil, errors.New("unknown group " + group)
+	}
+	return a.committed, nil
+}
+
+func (a *fakeOffsetsAdmin) ListEndOffsets(_ context.Context, topics ...string) (kadm.ListedOffsets, error) {
+	return listedOf(a.ends, topics), nil
+}
+
+func (a *fakeOffsetsAdmin) ListStartOffsets(_ context.Context, topics ...string) (kadm.ListedOffsets, error) {
+	if a.starts == nil {
+		return nil, errors.New("start offsets weren't expected to be listed")
+	}
+	return listedOf(a.starts, topics), nil
+}
+
+func listedOf(offsets kadm.ListedOffsets, topics []string) kadm.ListedOffsets {
+	listed := make(kadm.ListedOffsets)
+	for _, topic := range topics {
+		if partitions, found := offsets[topic]; found {
+			listed[topic] = partitions
+		}
+	}
+	return listed
+}
+
+func committedOffset(topic string, partition int32, at int64) kadm.OffsetResponse {
+	return kadm.OffsetResponse{Offset: kadm.Offset{Topic: topic, Partition: partition, At: at}}
+}
+
+func listedOffset(topic string, partition int32, offset int64) kadm.ListedOffset {
+	return kadm.ListedOffset{Topic: topic, Partition: partition, Offset: offset}
+}
+
+// newLagTestClient returns a client of the group consuming the topics, which never reaches a broker.
+func newLagTestClient(t *testing.T, opts ...kgo.Opt) *Client {
+	t.Helper()
+
+	driver, err := kgo.NewClient(append([]kgo.Opt{kgo.SeedBrokers("127.0.0.1:1")}, opts...)...)
+	if err != nil {
+		t.Fatalf("failed to create Kafka client: %v", err)
+	}
+	t.Cleanup(driver.Close)
+	return &Client{Driver: driver}
+}
+
+func TestGroupLagComputesLagFromCommittedOffsets(t *testing.T) {
+	client := newLagTestClient(t,
+		kgo.ConsumerGroup("fanout"),
+		kgo.ConsumeTopics("events"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	admin := &fakeOffsetsAdmin{
+		group: "fanout",
+		committed: kadm.OffsetResponses{
+			"events": {0: committedOffset("events", 0, 40), 1: committedOffset("events", 1, 100)},
+			"audit":  {0: committedOffset("audit", 0, 1)}, // not consumed by the client
+		},
+		ends: kadm.ListedOffsets{
+			"events": {
+				0: listedOffset("events", 0, 50),
+				1: listedOffset("events", 1, 100),
+				2: listedOffset("events", 2, 30),
+			},
+			"audit": {0: listedOffset("audit", 0, 10)},
+		},
+		starts: kadm.ListedOffsets{
+			"events": {2: listedOffset("events", 2, 5)},
+		},
+	}
+
+	lag, err := client.groupLag(context.Background(), admin)
+	if err != nil {
+		t.Fatalf("groupLag() error = %v", err)
+	}
+	want := map[TopicPartition]int64{
+		{Topic: "events", Partition: 0}: 10,
+		{Topic: "events", Partition: 1}: 0,
+		{Topic: "events", Partition: 2}: 25, // not committed yet, consumed from the start
+	}
+	if !maps.Equal(lag, want) {
+		t.Fatalf("groupLag() = %v, want %v", lag, want)
+	}
+}
+
+func TestGroupLagOfUncommittedPartitionWhenResettingToEnd(t *testing.T) {
+	client := newLagTestClient(t,
+		kgo.ConsumerGroup("fanout"),
+		kgo.ConsumeTopics("events"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()),
+	)
+	admin := &fakeOffsetsAdmin{
+		group:     "fanout",
+		committed: kadm.OffsetResponses{},
+		ends:      kadm.ListedOffsets{"events": {0: listedOffset("events", 0, 30)}},
+	}
+
+	lag, err := client.groupLag(context.Background(), admin)
+	if err != nil {
+		t.Fatalf("groupLag() error = %v", err)
+	}
+	if want := map[TopicPartition]int64{{Topic: "events", Partition: 0}: 0}; !maps.Equal(lag, want) {
+		t.Fatalf("groupLag() = %v, want %v", lag, want)
+	}
+}
+
+func TestGroupLagReportsPartitionErrors(t *testing.T) {
+	client := newLagTestClient(t, kgo.ConsumerGroup("fanout"), kgo.ConsumeTopics("events"))
+	failed := listedOffset("events", 1, 0)
+	failed.Err = errors.New("not leader")
+	admin := &fakeOffsetsAdmin{
+		group:     "fanout",
+		committed: kadm.OffsetResponses{"events": {0: committedOffset("events", 0, 5)}},
+		ends:      kadm.ListedOffsets{"events": {0: listedOffset("events", 0, 8), 1: failed}},
+		starts:    kadm.ListedOffsets{"events": {}},
+	}
+
+	lag, err := client.groupLag(context.Background(), admin)
+	if err == nil {
+		t.Fatal("groupLag() error = nil, want the failed partition to be reported")
+	}
+	if want := map[TopicPartition]int64{{Topic: "events", Partition: 0}: 3}; !maps.Equal(lag, want) {
+		t.Fatalf("groupLag() = %v, want the lag of the other partitions %v", lag, want)
+	}
+}
+
+func TestGroupLagRequiresConsumerGroup(t *testing.T) {
+	client := newLagTestClient(t, kgo.ConsumeTopics("events"))
+	if _, err := client.groupLag(context.Background(), &fakeOffsetsAdmin{}); !errors.Is(err, ErrNoConsumerGroup) {
+		t.Fatalf("groupLag() error = %v, want %v", err, ErrNoConsumerGroup)
+	}
+	if group := client.GroupID(); group != "" {
+		t.Fatalf("GroupID() = '%s', want none", group)
+	}
+}
@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// MetricsRegistry receives the metrics of the requests a client makes to the brokers.
+type MetricsRegistry interface {
+	// ObserveRequest is called once per request, failed tells whether writing it or reading its response failed.
+	ObserveRequest(broker, request string, latency time.Duration, bytesWritten, bytesRead int, failed bool)
+	// AddBatchBytes is called per batch produced to or fetched from a topic, with its compressed size.
+	AddBatchBytes(broker, topic string, produced bool, bytes int)
+}
+
+// metricsHook forwards the broker and batch hooks of the driver to a MetricsRegistry.
+type metricsHook struct {
+	registry MetricsRegistry
+}
+
+var (
+	_ kgo.HookBrokerE2E           = (*metricsHook)(nil)
+	_ kgo.HookProduceBatchWritten = (*metricsHook)(nil)
+	_ kgo.HookFetchBatchRead      = (*metricsHook)(nil)
+)
+
+func (h *metricsHook) OnBrokerE2E(meta kgo.BrokerMetadata, key int16, e2e kgo.BrokerE2E) {
+	h.registry.ObserveRequest(
+		brokerName(meta), kmsg.NameForKey(key), e2e.DurationE2E(), e2e.BytesWritten, e2e.BytesRead, e2e.Err() != nil,
+	)
+}
+
+func (h *metricsHook) OnProduceBatchWritten(meta kgo.BrokerMetadata, topic string, _ int32, metrics kgo.ProduceBatchMetrics) {
+	h.registry.AddBatchBytes(brokerName(meta), topic, true, metrics.CompressedBytes)
+}
+
+func (h *metricsHook) OnFetchBatchRead(meta kgo.BrokerMetadata, topic string, _ int32, metrics kgo.FetchBatchMetrics) {
+	h.registry.AddBatchBytes(brokerName(meta), topic, false, metrics.CompressedBytes)
+}
+
+func brokerName(meta kgo.BrokerMetadata) string {
+	return strconv.FormatInt(int64(meta.NodeID), 10)
+}
+
+// RequestMetrics is an in-memory MetricsRegistry, its snapshot is meant to be exposed on the admin server.
+type RequestMetrics struct {
+	mu      sync.Mutex
+	brokers map[string]*BrokerRequestStats
+	topics  map[string]*TopicThroughput
+}
+
+type BrokerRequestStats struct {
+	Requests     uint64                  `json:"requests"`
+	Errors       uint64                  `json:"errors"`
+	BytesWritten uint64                  `json:"bytes_written"`
+	BytesRead    uint64                  `json:"bytes_read"`
+	Latency      map[string]LatencyStats `json:"latency"` // by request name
+}
+
+type LatencyStats struct {
+	Count uint64        `json:"count"`
+	Total time.Duration `json:"total"`
+	Max   time.Duration `json:"max"`
+}
+
+type TopicThroughput struct {
+	ProducedBytes uint64 `json:"produced_bytes"`
+	FetchedBytes  uint64 `json:"fetched_bytes"`
+}
+
+type RequestMetricsSnapshot struct {
+	Brokers map[string]BrokerRequestStats `json:"brokers"`
+	Topics  map[string]TopicThroughput    `json:"topics"`
+}
+
+func NewRequestMetrics() *RequestMetrics {
+	return &RequestMetrics{
+		brokers: make(map[string]*BrokerRequestStats),
+		topics:  make(map[string]*TopicThroughput),
+	}
+}
+
+func (m *RequestMetrics) ObserveRequest(broker, request string, latency time.Duration, bytesWritten, bytesRead int, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.brokers[broker]
+	if !ok {
+		stats = &BrokerRequestStats{Latency: make(map[string]LatencyStats)}
+		m.brokers[broker] = stats
+	}
+	stats.Requests++
+	if failed {
+		stats.Errors++
+	}
+	stats.BytesWritten += uint64(max(bytesWritten, 0))
+	stats.BytesRead += uint64(max(bytesRead, 0))
+
+	latencyStats := stats.Latency[request]
+	latencyStats.Count++
+	latencyStats.Total += latency
+	latencyStats.Max = max(latencyStats.Max, latency)
+	stats.Latency[request] = latencyStats
+}
+
+func (m *RequestMetrics) AddBatchBytes(_, topic string, produced bool, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	throughput, ok := m.topics[topic]
+	if !ok {
+		throughput = &TopicThroughput{}
+		m.topics[topic] = throughput
+	}
+	if produced {
+		throughput.ProducedBytes += uint64(max(bytes, 0))
+	} else {
+		throughput.FetchedBytes += uint64(max(bytes, 0))
+	}
+}
+
+func (m *RequestMetrics) Snapshot() RequestMetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := RequestMetricsSnapshot{
+		Brokers: make(map[string]BrokerRequestStats, len(m.brokers)),
+		Topics:  make(map[string]TopicThroughput, len(m.topics)),
+	}
+	for broker, stats := range m.brokers {
+		copied := *stats
+		copied.Latency = maps.Clone(stats.Latency)
+		snapshot.Brokers[broker] = copied
+	}
+	for topic, throughput := range m.topics {
+		snapshot.Topics[topic] = *throughput
+	}
+	return snapshot
+}
@@ -0,0 +1,158 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// observedRequest is a request reported to the fakeRegistry.
+type observedRequest struct {
+	broker       string
+	request      string
+	latency      time.Duration
+	bytesWritten int
+	bytesRead    int
+	failed       bool
+}
+
+// observedBatch is a batch reported to the fakeRegistry.
+type observedBatch struct {
+	broker   string
+	topic    string
+	produced bool
+	bytes    int
+}
+
+// fakeRegistry records what the hook reports.
+type fakeRegistry struct {
+	requests []observedRequest
+	batches  []observedBatch
+}
+
+func (r *fakeRegistry) ObserveRequest(broker, request string, latency time.Duration, bytesWritten, bytesRead int, failed bool) {
+	r.requests = append(r.requests, observedRequest{broker, request, latency, bytesWritten, bytesRead, failed})
+}
+
+func (r *fakeRegistry) AddBatchBytes(broker, topic string, produced bool, bytes int) {
+	r.batches = append(r.batches, observedBatch{broker, topic, produced, bytes})
+}
+
+func TestMetricsHookRecordsRequests(t *testing.T) {
+	registry := &fakeRegistry{}
+	hook := &metricsHook{registry: registry}
+	broker := kgo.BrokerMetadata{NodeID: 2, Host: "kafka-2", Port: 9092}
+
+	hook.OnBrokerE2E(broker, kmsg.Produce.Int16(), kgo.BrokerE2E{
+		BytesWritten: 120, BytesRead: 40,
+		WriteWait: time.Millisecond, TimeToWrite: 2 * time.Millisecond, ReadWait: 3 * time.Millisecond, TimeToRead: 4 * time.Millisecond,
+	})
+	hook.OnBrokerE2E(broker, kmsg.Fetch.Int16(), kgo.BrokerE2E{
+		BytesWritten: 60, TimeToWrite: time.Millisecond, ReadErr: errors.New("connection reset"),
+	})
+	hook.OnProduceBatchWritten(broker, "emails", 0, kgo.ProduceBatchMetrics{CompressedBytes: 100, UncompressedBytes: 300})
+	hook.OnFetchBatchRead(broker, "emails", 1, kgo.FetchBatchMetrics{CompressedBytes: 80, UncompressedBytes: 200})
+
+	wantRequests := []observedRequest{
+		{broker: "2", request: "Produce", latency: 9 * time.Millisecond, bytesWritten: 120, bytesRead: 40}, // without the wait to write
+		{broker: "2", request: "Fetch", latency: time.Millisecond, bytesWritten: 60, failed: true},
+	}
+	if !slices.Equal(registry.requests, wantRequests) {
+		t.Errorf("observed requests = %+v, want %+v", registry.requests, wantRequests)
+	}
+	wantBatches := []observedBatch{
+		{broker: "2", topic: "emails", produced: true, bytes: 100},
+		{broker: "2", topic: "emails", produced: false, bytes: 80},
+	}
+	if !slices.Equal(registry.batches, wantBatches) {
+		t.Errorf("observed batches = %+v, want %+v", registry.batches, wantBatches)
+	}
+}
+
+func TestRequestMetricsAggregatesPerBrokerAndTopic(t *testing.T) {
+	metrics := NewRequestMetrics()
+	metrics.ObserveRequest("1", "Produce", 10*time.Millisecond, 100, 20, false)
+	metrics.ObserveRequest("1", "Produce", 30*time.Millisecond, 50, 10, true)
+	metrics.ObserveRequest("1", "Fetch", 5*time.Millisecond, 10, 500, false)
+	metrics.ObserveRequest("2", "Metadata", time.Millisecond, 8, 64, false)
+	metrics.AddBatchBytes("1", "emails", true, 100)
+	metrics.AddBatchBytes("1", "emails", true, 50)
+	metrics.AddBatchBytes("1", "emails", false, 70)
+
+	snapshot := metrics.Snapshot()
+
+	first := snapshot.Brokers["1"]
+	if first.Requests != 3 || first.Errors != 1 || first.BytesWritten != 160 || first.BytesRead != 530 {
+		t.Errorf("broker 1 stats = %+v, want 3 requests, 1 error, 160 bytes written and 530 read", first)
+	}
+	if produce := first.Latency["Produce"]; produce != (LatencyStats{Count: 2, Total: 40 * time.Millisecond, Max: 30 * time.Millisecond}) {
+		t.Errorf("broker 1 produce latency = %+v, want 2 requests totalling 40ms, at most 30ms", produce)
+	}
+	if second := snapshot.Brokers["2"]; second.Requests != 1 || second.Latency["Metadata"].Count != 1 {
+		t.Errorf("broker 2 stats = %+v, want a single metadata request", second)
+	}
+	if throughput := snapshot.Topics["emails"]; throughput != (TopicThroughput{ProducedBytes: 150, FetchedBytes: 70}) {
+		t.Errorf("emails throughput = %+v, want 150 bytes produced and 70 fetched", throughput)
+	}
+
+	// the snapshot is a copy
+	metrics.ObserveRequest("1", "Produce", time.Millisecond, 1, 1, false)
+	if snapshot.Brokers["1"].Latency["Produce"].Count != 2 {
+		t.Error("snapshot changed with the metrics recorded after it")
+	}
+}
+
+func TestMetricsHookIsInstalledOnlyWithRegistry(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry MetricsRegistry
+		want     bool
+	}{
+		{name: "with registry", registry: NewRequestMetrics(), want: true},
+		{name: "without registry", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := NewConfigurationBuilder(&ConfigurationLoggers{Client: zerolog.Nop(), Driver: zerolog.Nop()})
+			if !builder.SetGeneralConfig(&GeneralConfig{
+				ClientID:       "metrics-test-client",
+				ServiceName:    "metrics-test",
+				ServiceVersion: "test",
+				SeedBrokers:    []string{"127.0.0.1:1"},
+				TLSConfig:      &tls.Config{MinVersion: tls.VersionTLS12},
+				Username:       "metrics-test",
+				Password:       "metrics-test",
+				Metrics:        tt.registry,
+			}) {
+				t.Fatalf("SetGeneralConfig() failed: %v", builder.err)
+			}
+			client, err := NewClient(builder)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			if err := client.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			t.Cleanup(func() { client.Stop(context.Background()) })
+
+			// the hooks are of an unexported slice type of the driver
+			hooks := reflect.ValueOf(client.Driver.OptValue(kgo.WithHooks))
+			installed := false
+			for idx := range hooks.Len() {
+				hook, ok := hooks.Index(idx).Interface().(*metricsHook)
+				installed = installed || (ok && hook.registry == tt.registry)
+			}
+			if installed != tt.want {
+				t.Fatalf("metrics hook installed = %t, want %t", installed, tt.want)
+			}
+		})
+	}
+}
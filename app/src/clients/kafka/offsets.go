@@ -0,0 +1,110 @@
+package kafka
+
+import (
+	"chat/src/platform/perr"
+	"chat/src/util"
+	"context"
+	"errors"
+
+	"github.com/samber/oops"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+)
+
+// OffsetReport describes where the consumer group of the client is on each of the partitions it has offsets for.
+type OffsetReport struct {
+	Group      string             `json:"group"`
+	State      string             `json:"state"` // Empty when no member is consuming, the committed offsets are still reported
+	Partitions []PartitionOffsets `json:"partitions"`
+}
+
+type PartitionOffsets struct {
+	Topic      string `json:"topic"`
+	Partition  int32  `json:"partition"`
+	Committed  int64  `json:"committed"` // -1 when the group didn't commit on the partition
+	End        int64  `json:"end"`
+	Lag        int64  `json:"lag"` // -1 when the committed or end offset couldn't be fetched
+	MemberID   string `json:"member_id,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+	ClientHost string `json:"client_host,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type groupLagsAdmin interface {
+	Lag(ctx context.Context, groups ...string) (kadm.DescribedGroupLags, error)
+}
+
+// DescribeGroupOffsets reports the committed offset, end offset, lag and consuming member of each partition the
+// group of the client has offsets for, for incident triage. Unknown groups are reported with perr.ENOENT and
+// missing ACLs with perr.EACCES.
+func (c *Client) DescribeGroupOffsets(ctx context.Context) (OffsetReport, error) {
+	return c.describeGroupOffsets(ctx, kadm.NewClient(c.Driver))
+}
+
+func (c *Client) describeGroupOffsets(ctx context.Context, admin groupLagsAdmin) (OffsetReport, error) {
+	errorb := oops.In(util.GetFunctionName())
+
+	group := c.GroupID()
+	if group == "" {
+		return OffsetReport{}, errorb.Code(perr.EINVAL).Wrap(ErrNoConsumerGroup)
+	}
+
+	lags, err := admin.Lag(ctx, group)
+	if err != nil {
+		return OffsetReport{}, errorb.Code(offsetsErrorCode(err)).Wrapf(err, "failed to describe offsets of group '%s'", group)
+	}
+	described, found := lags[group]
+	if !found {
+		return OffsetReport{}, errorb.Code(perr.ENOENT).Errorf("group '%s' wasn't described", group)
+	}
+	if err := described.Error(); err != nil {
+		return OffsetReport{}, errorb.Code(offsetsErrorCode(err)).Wrapf(err, "failed to describe offsets of group '%s'", group)
+	}
+	if described.State == "Dead" && len(described.Lag) == 0 {
+		return OffsetReport{}, errorb.Code(perr.ENOENT).Errorf("group '%s' doesn't exist", group)
+	}
+
+	report := OffsetReport{
+		Group:      group,
+		State:      described.State,
+		Partitions: make([]PartitionOffsets, 0, len(described.Lag)),
+	}
+	for _, lag := range described.Lag.Sorted() {
+		partition := PartitionOffsets{
+			Topic:     lag.Topic,
+			Partition: lag.Partition,
+			Committed: lag.Commit.At,
+			End:       lag.End.Offset,
+			Lag:       lag.Lag,
+		}
+		if !lag.IsEmpty() {
+			partition.MemberID = lag.Member.MemberID
+			partition.ClientHost = lag.Member.ClientHost
+			if lag.Member.InstanceID != nil {
+				partition.InstanceID = *lag.Member.InstanceID
+			}
+		}
+		if lag.Err != nil {
+			partition.Error = lag.Err.Error()
+		}
+		report.Partitions = append(report.Partitions, partition)
+	}
+	return report, nil
+}
+
+func offsetsErrorCode(err error) string {
+	var authErr *kadm.AuthError
+	switch {
+	case errors.As(err, &authErr),
+		errors.Is(err, kerr.GroupAuthorizationFailed),
+		errors.Is(err, kerr.TopicAuthorizationFailed),
+		errors.Is(err, kerr.ClusterAuthorizationFailed):
+		return perr.EACCES
+	case errors.Is(err, kerr.GroupIDNotFound):
+		return perr.ENOENT
+	case errors.Is(err, context.DeadlineExceeded):
+		return perr.ETIMEDOUT
+	default:
+		return perr.EIO
+	}
+}
@@ -0,0 +1,178 @@
+package kafka
+
+import (
+	"chat/src/platform/perr"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/samber/oops"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// fakeLagsAdmin describes the groups with known lags.
+type fakeLagsAdmin struct {
+	lags kadm.DescribedGroupLags
+	err  error
+}
+
+func (a *fakeLagsAdmin) Lag(_ context.Context, groups ...string) (kadm.DescribedGroupLags, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	lags := make(kadm.DescribedGroupLags)
+	for _, group := range groups {
+		if lag, found := a.lags[group]; found {
+			lags[group] = lag
+		}
+	}
+	return lags, nil
+}
+
+func memberLag(member *kadm.DescribedGroupMember, topic string, partition int32, committed, end int64) kadm.GroupMemberLag {
+	return kadm.GroupMemberLag{
+		Member:    member,
+		Topic:     topic,
+		Partition: partition,
+		Commit:    kadm.Offset{Topic: topic, Partition: partition, At: committed},
+		End:       listedOffset(topic, partition, end),
+		Lag:       end - committed,
+	}
+}
+
+func offsetsErrorCodeOf(t *testing.T, err error) string {
+	t.Helper()
+
+	oopsErr, ok := oops.AsOops(err)
+	if !ok {
+		t.Fatalf("error %v isn't an oops error", err)
+	}
+	return oopsErr.Code()
+}
+
+func TestDescribeGroupOffsetsReportsEachPartition(t *testing.T) {
+	client := newLagTestClient(t, kgo.ConsumerGroup("fanout"), kgo.ConsumeTopics("events"))
+	instanceID := "replica-1"
+	member := &kadm.DescribedGroupMember{MemberID: "member-1", InstanceID: &instanceID, ClientHost: "/10.0.0.1"}
+	failed := memberLag(member, "events", 2, -1, 30)
+	failed.Lag = -1
+	failed.Err = kerr.UnknownTopicOrPartition
+	admin := &fakeLagsAdmin{lags: kadm.DescribedGroupLags{
+		"fanout": {
+			Group: "fanout",
+			State: "Stable",
+			Lag: kadm.GroupLag{
+				"events": {
+					1: memberLag(member, "events", 1, 90, 100),
+					0: memberLag(member, "events", 0, 40, 50),
+					2: failed,
+				},
+				"audit": {0: memberLag(member, "audit", 0, 7, 7)},
+			},
+		},
+	}}
+
+	report, err := client.describeGroupOffsets(context.Background(), admin)
+	if err != nil {
+		t.Fatalf("describeGroupOffsets() error = %v", err)
+	}
+	want := OffsetReport{
+		Group: "fanout",
+		State: "Stable",
+		Partitions: []PartitionOffsets{
+			{Topic: "audit", Partition: 0, Committed: 7, End: 7, Lag: 0, MemberID: "member-1", InstanceID: "replica-1", ClientHost: "/10.0.0.1"},
+			{Topic: "events", Partition: 0, Committed: 40, End: 50, Lag: 10, MemberID: "member-1", InstanceID: "replica-1", ClientHost: "/10.0.0.1"},
+			{Topic: "events", Partition: 1, Committed: 90, End: 100, Lag: 10, MemberID: "member-1", InstanceID: "replica-1", ClientHost: "/10.0.0.1"},
+			{
+				Topic: "events", Partition: 2, Committed: -1, End: 30, Lag: -1,
+				MemberID: "member-1", InstanceID: "replica-1", ClientHost: "/10.0.0.1",
+				Error: kerr.UnknownTopicOrPartition.Error(),
+			},
+		},
+	}
+	if report.Group != want.Group || report.State != want.State || !slices.Equal(report.Partitions, want.Partitions) {
+		t.Fatalf("describeGroupOffsets() = %+v, want %+v", report, want)
+	}
+}
+
+func TestDescribeGroupOffsetsOfEmptyGroup(t *testing.T) {
+	client := newLagTestClient(t, kgo.ConsumerGroup("fanout"), kgo.ConsumeTopics("events"))
+	admin := &fakeLagsAdmin{lags: kadm.DescribedGroupLags{
+		"fanout": {Group: "fanout", State: "Empty", Lag: kadm.GroupLag{"events": {0: memberLag(nil, "events", 0, 5, 8)}}},
+	}}
+
+	report, err := client.describeGroupOffsets(context.Background(), admin)
+	if err != nil {
+		t.Fatalf("describeGroupOffsets() error = %v", err)
+	}
+	want := []PartitionOffsets{{Topic: "events", Partition: 0, Committed: 5, End: 8, Lag: 3}}
+	if report.State != "Empty" || !slices.Equal(report.Partitions, want) {
+		t.Fatalf("describeGroupOffsets() = %+v, want the committed offsets without members %+v", report, want)
+	}
+}
+
+func TestDescribeGroupOffsetsErrorCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []kgo.Opt
+		admin *fakeLagsAdmin
+		want  string
+	}{
+		{
+			name:  "not a group consumer",
+			opts:  []kgo.Opt{kgo.ConsumeTopics("events")},
+			admin: &fakeLagsAdmin{},
+			want:  perr.EINVAL,
+		},
+		{
+			name:  "dead group",
+			admin: &fakeLagsAdmin{lags: kadm.DescribedGroupLags{"fanout": {Group: "fanout", State: "Dead"}}},
+			want:  perr.ENOENT,
+		},
+		{
+			name:  "group not described",
+			admin: &fakeLagsAdmin{lags: kadm.DescribedGroupLags{}},
+			want:  perr.ENOENT,
+		},
+		{
+			name:  "unauthorized group",
+			admin: &fakeLagsAdmin{lags: kadm.DescribedGroupLags{"fanout": {Group: "fanout", DescribeErr: kerr.GroupAuthorizationFailed}}},
+			want:  perr.EACCES,
+		},
+		{
+			name:  "unauthorized cluster",
+			admin: &fakeLagsAdmin{err: kerr.ClusterAuthorizationFailed},
+			want:  perr.EACCES,
+		},
+		{
+			name:  "timed out",
+			admin: &fakeLagsAdmin{err: context.DeadlineExceeded},
+			want:  perr.ETIMEDOUT,
+		},
+		{
+			name:  "broker failure",
+			admin: &fakeLagsAdmin{err: errors.New("connection reset")},
+			want:  perr.EIO,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			if opts == nil {
+				opts = []kgo.Opt{kgo.ConsumerGroup("fanout"), kgo.ConsumeTopics("events")}
+			}
+			client := newLagTestClient(t, opts...)
+
+			_, err := client.describeGroupOffsets(context.Background(), tt.admin)
+			if err == nil {
+				t.Fatal("describeGroupOffsets() error = nil, want a failure")
+			}
+			if code := offsetsErrorCodeOf(t, err); code != tt.want {
+				t.Fatalf("describeGroupOffsets() error code = %s, want %s: %v", code, tt.want, err)
+			}
+		})
+	}
+}
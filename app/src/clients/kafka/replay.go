@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var ErrUnknownTopic = errors.New("topic doesn't exist")
+
+// timestampOffsetsAdmin is the subset of the admin client the offsets of a timestamp are listed with, replaced by tests.
+type timestampOffsetsAdmin interface {
+	ListOffsetsAfterMilli(ctx context.Context, millisecond int64, topics ...string) (kadm.ListedOffsets, error)
+}
+
+// OffsetsForTimestamp returns, for each partition of the topic, the offset of the first record produced at or
+// after the timestamp. Partitions without such a record get their end offset, so nothing is replayed from them.
+func (c *Client) OffsetsForTimestamp(ctx context.Context, topic string, timestamp time.Time) (map[int32]kgo.Offset, error) {
+	return offsetsForTimestamp(ctx, kadm.NewClient(c.Driver), topic, timestamp)
+}
+
+func offsetsForTimestamp(
+	ctx context.Context, admin timestampOffsetsAdmin, topic string, timestamp time.Time,
+) (map[int32]kgo.Offset, error) {
+	listed, err := admin.ListOffsetsAfterMilli(ctx, timestamp.UnixMilli(), topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets of topic '%s' after %v: %w", topic, timestamp, err)
+	}
+
+	offsets := make(map[int32]kgo.Offset)
+	var listErr error
+	listed.Each(func(listedOffset kadm.ListedOffset) {
+		if errors.Is(listedOffset.Err, kerr.UnknownTopicOrPartition) && listedOffset.Partition == -1 {
+			listErr = errors.Join(listErr, fmt.Errorf("%w: '%s'", ErrUnknownTopic, topic))
+			return
+		}
+		if listedOffset.Err != nil {
+			listErr = errors.Join(listErr, fmt.Errorf(
+				"failed to list offset of %s-%d after %v: %w", topic, listedOffset.Partition, timestamp, listedOffset.Err,
+			))
+			return
+		}
+		offsets[listedOffset.Partition] = kgo.NewOffset().At(listedOffset.Offset).WithEpoch(listedOffset.LeaderEpoch)
+	})
+	if listErr != nil {
+		return nil, listErr
+	}
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownTopic, topic)
+	}
+	return offsets, nil
+}
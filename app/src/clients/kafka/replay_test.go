@@ -0,0 +1,120 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// fakeTimestampAdmin maps timestamps to the offsets of the first records produced at or after them.
+type fakeTimestampAdmin struct {
+	offsets map[int64]kadm.ListedOffsets
+	err     error
+}
+
+func (a *fakeTimestampAdmin) ListOffsetsAfterMilli(_ context.Context, millisecond int64, topics ...string) (kadm.ListedOffsets, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	return listedOf(a.offsets[millisecond], topics), nil
+}
+
+func TestOffsetsForTimestampSeeksEachPartition(t *testing.T) {
+	before := time.UnixMilli(1_700_000_000_000)
+	after := before.Add(time.Hour)
+	admin := &fakeTimestampAdmin{offsets: map[int64]kadm.ListedOffsets{
+		before.UnixMilli(): {"emails": {
+			0: {Topic: "emails", Partition: 0, Offset: 12, LeaderEpoch: 3},
+			1: {Topic: "emails", Partition: 1, Offset: 40, LeaderEpoch: 5},
+		}},
+		after.UnixMilli(): {"emails": {
+			0: {Topic: "emails", Partition: 0, Offset: 20, LeaderEpoch: 3},
+			1: {Topic: "emails", Partition: 1, Offset: 41, LeaderEpoch: 5}, // end offset, nothing produced since
+		}},
+	}}
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		want      map[int32]kgo.EpochOffset
+	}{
+		{
+			name:      "earlier timestamp",
+			timestamp: before,
+			want:      map[int32]kgo.EpochOffset{0: {Epoch: 3, Offset: 12}, 1: {Epoch: 5, Offset: 40}},
+		},
+		{
+			name:      "later timestamp",
+			timestamp: after,
+			want:      map[int32]kgo.EpochOffset{0: {Epoch: 3, Offset: 20}, 1: {Epoch: 5, Offset: 41}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offsets, err := offsetsForTimestamp(context.Background(), admin, "emails", tt.timestamp)
+			if err != nil {
+				t.Fatalf("offsetsForTimestamp() error = %v", err)
+			}
+			got := make(map[int32]kgo.EpochOffset, len(offsets))
+			for partition, offset := range offsets {
+				got[partition] = offset.EpochOffset()
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("offsetsForTimestamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffsetsForTimestampFailures(t *testing.T) {
+	timestamp := time.UnixMilli(1_700_000_000_000)
+	listFailure := errors.New("connection reset")
+	tests := []struct {
+		name  string
+		admin *fakeTimestampAdmin
+		want  error
+	}{
+		{
+			name:  "topic not listed",
+			admin: &fakeTimestampAdmin{},
+			want:  ErrUnknownTopic,
+		},
+		{
+			name: "unknown topic",
+			admin: &fakeTimestampAdmin{offsets: map[int64]kadm.ListedOffsets{timestamp.UnixMilli(): {"emails": {
+				-1: {Topic: "emails", Partition: -1, Err: kerr.UnknownTopicOrPartition},
+			}}}},
+			want: ErrUnknownTopic,
+		},
+		{
+			name: "partition failure",
+			admin: &fakeTimestampAdmin{offsets: map[int64]kadm.ListedOffsets{timestamp.UnixMilli(): {"emails": {
+				0: {Topic: "emails", Partition: 0, Offset: 12},
+				1: {Topic: "emails", Partition: 1, Err: kerr.NotLeaderForPartition},
+			}}}},
+			want: kerr.NotLeaderForPartition,
+		},
+		{
+			name:  "list failure",
+			admin: &fakeTimestampAdmin{err: listFailure},
+			want:  listFailure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offsets, err := offsetsForTimestamp(context.Background(), tt.admin, "emails", timestamp)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("offsetsForTimestamp() error = %v, want %v", err, tt.want)
+			}
+			if offsets != nil {
+				t.Fatalf("offsetsForTimestamp() = %v, want no offsets to seek to", offsets)
+			}
+		})
+	}
+}
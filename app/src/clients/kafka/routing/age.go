@@ -0,0 +1,135 @@
+package routing
+
+import (
+	"chat/src/platform/health"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const messageAgeWindow = time.Minute
+
+// messageAges tracks, per topic, the age of the oldest record handed to a handler, so a consumer processing stale
+// records is noticed even under low throughput, where the offset lag stays small. Ages are kept for the current and
+// the previous window, the maximum of both being reported, so it doesn't drop to zero as soon as a window starts.
+type messageAges struct {
+	threshold time.Duration    // #readonly, 0 disables the alert
+	now       func() time.Time // #readonly, replaced by tests
+	mu        sync.Mutex
+	topics    map[string]*topicMessageAge
+}
+
+type topicMessageAge struct {
+	windowStart time.Time
+	maxAge      time.Duration // within the current window
+	previousMax time.Duration // within the previous window
+	alerted     bool          // the threshold was exceeded and logged within the current window
+}
+
+func (a *messageAges) observe(topic string, records []*kgo.Record, logger *zerolog.Logger) {
+	now := a.now()
+
+	var oldest time.Duration
+	for _, record := range records {
+		if record.Timestamp.IsZero() {
+			continue
+		}
+		oldest = max(oldest, now.Sub(record.Timestamp))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	age, tracked := a.topics[topic]
+	if !tracked {
+		age = &topicMessageAge{windowStart: now}
+		a.topics[topic] = age
+	}
+	age.slide(now)
+	age.maxAge = max(age.maxAge, oldest)
+
+	if a.threshold > 0 && oldest > a.threshold && !age.alerted {
+		age.alerted = true
+		logger.Warn().Msgf(
+			"Processing records of topic '%s' produced %v ago, exceeding the message age threshold %v.",
+			topic, oldest.Round(time.Millisecond), a.threshold,
+		)
+	}
+}
+
+func (a *messageAges) max(topic string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	age, tracked := a.topics[topic]
+	if !tracked {
+		return 0
+	}
+	age.slide(a.now())
+	return max(age.maxAge, age.previousMax)
+}
+
+func (a *messageAges) snapshot() map[string]time.Duration {
+	a.mu.Lock()
+	topics := make([]string, 0, len(a.topics))
+	for topic := range a.topics {
+		topics = append(topics, topic)
+	}
+	a.mu.Unlock()
+
+	snapshot := make(map[string]time.Duration, len(topics))
+	for _, topic := range topics {
+		snapshot[topic] = a.max(topic)
+	}
+	return snapshot
+}
+
+func (t *topicMessageAge) slide(now time.Time) {
+	elapsed := now.Sub(t.windowStart)
+	if elapsed < messageAgeWindow {
+		return
+	}
+	if elapsed < 2*messageAgeWindow {
+		t.previousMax = t.maxAge
+	} else {
+		t.previousMax = 0 // nothing was processed within the previous window
+	}
+	t.windowStart = now
+	t.maxAge = 0
+	t.alerted = false
+}
+
+type messageAgeCheck struct {
+	router *ConsumerRouter
+	topic  string
+}
+
+// MessageAgeCheck returns a health check reporting the topic as overloaded while the age of the records processed
+// within the last windows exceeds MessageAgeThreshold. It always reports healthy when no threshold is configured.
+func (r *ConsumerRouter) MessageAgeCheck(topic string) health.Pingable {
+	return &messageAgeCheck{router: r, topic: topic}
+}
+
+func (c *messageAgeCheck) PingShallow(_ context.Context) health.PingResult {
+	result := health.NewHealthyPingResult("router."+c.topic+".message_age", health.PingDepthShallow)
+
+	ages := &c.router.messageAges
+	age := ages.max(c.topic)
+	result.WithDetail("max_message_age", age.String())
+
+	if ages.threshold > 0 && age > ages.threshold {
+		result.SetPingOutput(
+			health.PingCauseOverloaded,
+			fmt.Sprintf("processed records produced %v ago, exceeding threshold %v", age.Round(time.Millisecond), ages.threshold),
+		)
+	}
+	return result
+}
+
+func (c *messageAgeCheck) PingDeep(ctx context.Context) health.PingResult {
+	return c.PingShallow(ctx)
+}
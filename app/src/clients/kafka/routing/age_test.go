@@ -0,0 +1,144 @@
+package routing
+
+import (
+	"bytes"
+	"chat/src/platform/health"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// newTestMessageAges returns ages tracked with a clock reading the returned time, which tests advance.
+func newTestMessageAges(threshold time.Duration) (*messageAges, *time.Time) {
+	now := time.Now()
+	return &messageAges{
+		threshold: threshold,
+		now:       func() time.Time { return now },
+		topics:    make(map[string]*topicMessageAge),
+	}, &now
+}
+
+// recordsProducedAgo returns records whose timestamps are the ages before now, a zero age leaving the timestamp unset.
+func recordsProducedAgo(now time.Time, ages ...time.Duration) []*kgo.Record {
+	records := make([]*kgo.Record, 0, len(ages))
+	for _, age := range ages {
+		record := &kgo.Record{}
+		if age > 0 {
+			record.Timestamp = now.Add(-age)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestMessageAgesTrackOldestRecordOfLastWindows(t *testing.T) {
+	ages, now := newTestMessageAges(0)
+	logger := zerolog.Nop()
+
+	ages.observe("events", recordsProducedAgo(*now, 2*time.Second, 5*time.Second, 0), &logger)
+	ages.observe("events", recordsProducedAgo(*now, time.Second), &logger)
+	ages.observe("audit", recordsProducedAgo(*now, 0), &logger)
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		want    time.Duration
+	}{
+		{name: "current window", want: 5 * time.Second},
+		{name: "previous window", advance: messageAgeWindow, want: 5 * time.Second},
+		{name: "expired windows", advance: messageAgeWindow, want: 0},
+	}
+	for _, step := range steps {
+		*now = now.Add(step.advance)
+		if got := ages.max("events"); got != step.want {
+			t.Fatalf("%s: max(events) = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	if got := ages.max("audit"); got != 0 {
+		t.Errorf("max(audit) = %v, want records without timestamp to be ignored", got)
+	}
+	if got := ages.max("unknown"); got != 0 {
+		t.Errorf("max(unknown) = %v, want 0 for a topic never processed", got)
+	}
+	snapshot := ages.snapshot()
+	if len(snapshot) != 2 || snapshot["events"] != 0 || snapshot["audit"] != 0 {
+		t.Errorf("snapshot() = %v, want both topics with no age left", snapshot)
+	}
+}
+
+func TestMessageAgeAlertIsLoggedOncePerWindow(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	ages, now := newTestMessageAges(3 * time.Second)
+	alerts := func() int { return strings.Count(logs.String(), "exceeding the message age threshold") }
+
+	ages.observe("events", recordsProducedAgo(*now, 2*time.Second), &logger)
+	if alerts() != 0 {
+		t.Fatalf("logged %d alerts for a record below the threshold, want none:\n%s", alerts(), logs.String())
+	}
+	ages.observe("events", recordsProducedAgo(*now, 10*time.Second), &logger)
+	ages.observe("events", recordsProducedAgo(*now, 20*time.Second), &logger)
+	if alerts() != 1 {
+		t.Fatalf("logged %d alerts within a window, want 1:\n%s", alerts(), logs.String())
+	}
+	if !strings.Contains(logs.String(), "topic 'events' produced 10s ago") {
+		t.Fatalf("alert = %s, want the topic and the age of the record", logs.String())
+	}
+
+	*now = now.Add(messageAgeWindow)
+	ages.observe("events", recordsProducedAgo(*now, 10*time.Second), &logger)
+	if alerts() != 2 {
+		t.Fatalf("logged %d alerts once the window passed, want 2:\n%s", alerts(), logs.String())
+	}
+}
+
+func TestMessageAgeCheckReportsStaleTopicsAsOverloaded(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		wantCause health.PingCause
+	}{
+		{name: "stale records", threshold: time.Minute, wantCause: health.PingCauseOverloaded},
+		{name: "no threshold", threshold: 0, wantCause: health.PingCauseOk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, broker := newTestRouter(t, func(options *ConsumerRouterOptions) {
+				options.MessageAgeThreshold = tt.threshold
+			})
+			handler, received := forwardingHandler()
+			router.OnRecordsFrom("events", handler)
+			router.OnRecordsFrom("audit", handler)
+			startTestRouter(t, router)
+
+			broker.produceAt(time.Now().Add(-10*time.Minute), "events", 0, "a")
+			receiveRecords(t, received, "a")
+			broker.produce("audit", 0, "b")
+			receiveRecords(t, received, "b")
+
+			stats := router.Stats()
+			if age := stats.MaxMessageAges["events"]; age < 10*time.Minute || age > 11*time.Minute {
+				t.Errorf("Stats().MaxMessageAges[events] = %v, want the age of the record", age)
+			}
+			if age := stats.MaxMessageAges["audit"]; age >= time.Minute {
+				t.Errorf("Stats().MaxMessageAges[audit] = %v, want a fresh record", age)
+			}
+
+			result := router.MessageAgeCheck("events").PingShallow(context.Background())
+			if result.Cause != tt.wantCause {
+				t.Fatalf("MessageAgeCheck(events) = %s %q, want %s", result.Cause, result.Details, tt.wantCause)
+			}
+			if _, found := result.Data["max_message_age"]; !found {
+				t.Errorf("MessageAgeCheck(events) details = %v, want the max message age", result.Data)
+			}
+			if result := router.MessageAgeCheck("audit").PingDeep(context.Background()); !result.Healthy() {
+				t.Errorf("MessageAgeCheck(audit) = %s %q, want healthy", result.Cause, result.Details)
+			}
+		})
+	}
+}
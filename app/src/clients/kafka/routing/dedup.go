@@ -0,0 +1,342 @@
+package routing
+
+import (
+	"chat/src/clients/redis"
+	"chat/src/platform/validation"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/creasty/defaults"
+	redis2 "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const dedupBootstrapTimeout = 5 * time.Second
+
+const (
+	claimValuePending = "pending"
+	claimValueDone    = "done"
+)
+
+var ErrRecordInProgress = errors.New("a record with the same idempotency key is being processed")
+
+// IdempotencyKeyFunc extracts the idempotency key of a record, records with an empty key are never deduplicated.
+type IdempotencyKeyFunc func(record *kgo.Record) string
+
+// Deduplicator skips records already processed within the deduplication window, so at-least-once topics are
+// processed effectively once. A record is claimed in two phases: it's claimed as pending for ClaimTTL before being
+// handed to the handler, and the claim is turned into a done one, held for the window, once the handler processed
+// it. The pending claim is released when the handler fails, panics or applies backpressure before processing the
+// record, and expires when the process crashes meanwhile, so the redelivery of the record is processed again.
+// Done claims are what redeliveries after a failed offset commit need in order to be skipped.
+type Deduplicator struct {
+	claims    claimStore
+	keyPrefix string
+	window    time.Duration
+	claimTTL  time.Duration
+	timeout   time.Duration
+	logger    *zerolog.Logger
+}
+
+type DeduplicatorOptions struct {
+	Redis     *redis.Client   `validate:"required" default:"-"` // skipped by defaults, which would read the started driver
+	KeyPrefix string          `validate:"required,min=1,max=64,printascii"`
+	Window    time.Duration   `validate:"required,min=60000000000,max=604800000000000" default:"24h"` // 1min to 7 days
+	Timeout   time.Duration   `validate:"required,min=10000000,max=5000000000" default:"500ms"`       // 10ms to 5s
+	Logger    *zerolog.Logger `validate:"required"`
+	// ClaimTTL bounds how long a record being processed is claimed, it must exceed the handler timeout of the router,
+	// otherwise a redelivery of a record which is still being processed will be processed concurrently.
+	ClaimTTL time.Duration `validate:"required,min=1000000000,max=3600000000000" default:"2m"` // 1s to 1h
+}
+
+func NewDeduplicator(options *DeduplicatorOptions) (*Deduplicator, error) {
+	if err := defaults.Set(options); err != nil {
+		return nil, fmt.Errorf("failed to set config defaults: %w", err)
+	}
+	if err := validation.Instance.Struct(options); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dedupBootstrapTimeout)
+	defer cancel()
+
+	claims, err := newRedisClaims(ctx, options.Redis.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Deduplicator{
+		claims:    claims,
+		keyPrefix: options.KeyPrefix,
+		window:    options.Window,
+		claimTTL:  options.ClaimTTL,
+		timeout:   options.Timeout,
+		logger:    options.Logger,
+	}, nil
+}
+
+// Wrap returns a handler which hands to the given one only the records not seen within the deduplication window.
+// When a record is claimed by another consumer, i.e. one which is still processing it after a rebalance, the
+// records preceding it are handed to the handler and backpressure is applied from it on. When Redis can't be
+// reached, records are handed to the handler, preferring duplicates over losses.
+func (d *Deduplicator) Wrap(keyOf IdempotencyKeyFunc, handler ConsumerHandler) ConsumerHandler {
+	return func(records []*kgo.Record) error {
+		unseen, deferred, claims := d.claim(records, keyOf)
+		if len(unseen) == 0 {
+			if len(deferred) > 0 {
+				return NewBackpressureError(deferred, ErrRecordInProgress)
+			}
+			return nil
+		}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				d.release(unseen, claims)
+				panic(recovered)
+			}
+		}()
+
+		err := handler(unseen)
+
+		var backpressure *BackpressureError
+		switch {
+		case err == nil:
+			d.complete(unseen, claims)
+			if len(deferred) > 0 {
+				return NewBackpressureError(deferred, ErrRecordInProgress)
+			}
+		case errors.As(err, &backpressure):
+			unprocessed := make(map[*kgo.Record]struct{}, len(backpressure.Unprocessed))
+			for _, record := range backpressure.Unprocessed {
+				unprocessed[record] = struct{}{}
+			}
+			processed := make([]*kgo.Record, 0, len(unseen))
+			for _, record := range unseen {
+				if _, ok := unprocessed[record]; !ok {
+					processed = append(processed, record)
+				}
+			}
+			d.complete(processed, claims)
+			d.release(backpressure.Unprocessed, claims)
+		default:
+			d.release(unseen, claims)
+		}
+		return err
+	}
+}
+
+// claim claims the idempotency keys of the records and splits them into the ones to hand to the handler and the
+// ones deferred from the first record claimed by another consumer on, whose claims are released right away.
+func (d *Deduplicator) claim(
+	records []*kgo.Record, keyOf IdempotencyKeyFunc,
+) (unseen, deferred []*kgo.Record, claims map[*kgo.Record]string) {
+	claims = make(map[*kgo.Record]string, len(records))
+	keyed := make([]*kgo.Record, 0, len(records))
+	keys := make([]string, 0, len(records))
+	for _, record := range records {
+		if key := keyOf(record); key != "" {
+			claims[record] = d.keyPrefix + key
+			keyed = append(keyed, record)
+			keys = append(keys, d.keyPrefix+key)
+		}
+	}
+	if len(keys) == 0 {
+		return records, nil, claims
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	states, err := d.claims.acquire(ctx, keys, d.claimTTL)
+	if err != nil {
+		d.logger.Warn().Err(err).Msgf("Failed to claim idempotency keys of %d records, processing them anyway", len(records))
+		return records, nil, make(map[*kgo.Record]string)
+	}
+	stateOf := make(map[*kgo.Record]claimState, len(keyed))
+	for idx, record := range keyed {
+		stateOf[record] = states[idx]
+	}
+
+	unseen = make([]*kgo.Record, 0, len(records))
+	for idx, record := range records {
+		state, keyed := stateOf[record]
+		switch {
+		case !keyed || state == claimAcquired:
+			unseen = append(unseen, record)
+		case state == claimDone:
+			d.logger.Debug().Msgf(
+				"Skipping duplicate record %s-%d@%d with idempotency key '%s'",
+				record.Topic, record.Partition, record.Offset, claims[record],
+			)
+			delete(claims, record)
+		default:
+			d.logger.Debug().Msgf(
+				"Deferring record %s-%d@%d with idempotency key '%s', it's being processed by another consumer",
+				record.Topic, record.Partition, record.Offset, claims[record],
+			)
+			delete(claims, record)
+			deferred = records[idx:]
+			d.release(deferred, claims)
+			return unseen, deferred, claims
+		}
+	}
+	return unseen, nil, claims
+}
+
+// complete turns the pending claims of the processed records into done ones, held for the window.
+func (d *Deduplicator) complete(processed []*kgo.Record, claims map[*kgo.Record]string) {
+	keys := claimedKeys(processed, claims)
+	if len(keys) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	if err := d.claims.complete(ctx, keys, d.window); err != nil {
+		d.logger.Error().Err(err).Msgf(
+			"Failed to complete %d idempotency keys of processed records, their redeliveries will be processed again", len(keys),
+		)
+	}
+}
+
+func (d *Deduplicator) release(unprocessed []*kgo.Record, claims map[*kgo.Record]string) {
+	keys := claimedKeys(unprocessed, claims)
+	if len(keys) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	if err := d.claims.release(ctx, keys); err != nil {
+		d.logger.Error().Err(err).Msgf(
+			"Failed to release %d idempotency keys of unprocessed records, their redeliveries will be deferred until the claims expire",
+			len(keys),
+		)
+	}
+}
+
+func claimedKeys(records []*kgo.Record, claims map[*kgo.Record]string) []string {
+	keys := make([]string, 0, len(records))
+	for _, record := range records {
+		if key, claimed := claims[record]; claimed {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// claimState is the state an idempotency key was found in when claiming it.
+type claimState uint8
+
+const (
+	claimAcquired claimState = iota // the key was free, it's now claimed as pending
+	claimDone                       // a record with the key was processed within the window
+	claimPending                    // a record with the key is being processed
+)
+
+// claimStore keeps the claims of the idempotency keys, the deduplicator depends on it instead of Redis, so its
+// handling of handler outcomes can be tested in isolation.
+type claimStore interface {
+	acquire(ctx context.Context, keys []string, ttl time.Duration) ([]claimState, error)
+	complete(ctx context.Context, keys []string, window time.Duration) error
+	release(ctx context.Context, keys []string) error
+}
+
+// acquireClaimScript claims the key as pending when it's free, and tells the state of the claim otherwise.
+//
+//	-- KEYS[1] = idempotency key
+//	-- ARGV[1] = pending claim expiration in millis
+//	-- returns 0 when acquired, 1 when done, 2 when pending
+const acquireClaimScript = `
+if redis.call("SET", KEYS[1], "` + claimValuePending + `", "NX", "PX", ARGV[1]) then
+    return 0
+end
+if redis.call("GET", KEYS[1]) == "` + claimValueDone + `" then
+    return 1
+end
+return 2
+`
+
+// releaseClaimScript deletes the key only while it's claimed as pending, so a done claim is never released.
+//
+//	-- KEYS[1] = idempotency key
+//	-- returns 1 when released, 0 otherwise
+const releaseClaimScript = `
+if redis.call("GET", KEYS[1]) == "` + claimValuePending + `" then
+    return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+type redisClaims struct {
+	driver     redis.Driver // #readonly
+	acquireSha string       // #readonly
+	releaseSha string       // #readonly
+}
+
+func newRedisClaims(ctx context.Context, driver redis.Driver) (*redisClaims, error) {
+	acquireSha, err := driver.ScriptLoad(ctx, acquireClaimScript).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Lua script responsible for claiming idempotency keys: %w", err)
+	}
+	releaseSha, err := driver.ScriptLoad(ctx, releaseClaimScript).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Lua script responsible for releasing idempotency keys: %w", err)
+	}
+	return &redisClaims{driver: driver, acquireSha: acquireSha, releaseSha: releaseSha}, nil
+}
+
+func (c *redisClaims) acquire(ctx context.Context, keys []string, ttl time.Duration) ([]claimState, error) {
+	// keys are claimed, completed and released one by one, they don't necessarily hash to the same slot
+	commands := make([]*redis2.Cmd, len(keys))
+	_, err := c.driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		for idx, key := range keys {
+			commands[idx] = pipe.EvalSha(ctx, c.acquireSha, []string{key}, ttl.Milliseconds())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim %d idempotency keys: %w", len(keys), err)
+	}
+
+	states := make([]claimState, len(keys))
+	for idx, command := range commands {
+		state, err := command.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim idempotency key '%s': %w", keys[idx], err)
+		}
+		states[idx] = claimState(state) //nolint:gosec // the script returns 0 to 2
+	}
+	return states, nil
+}
+
+func (c *redisClaims) complete(ctx context.Context, keys []string, window time.Duration) error {
+	_, err := c.driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Set(ctx, key, claimValueDone, window)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete %d idempotency keys: %w", len(keys), err)
+	}
+	return nil
+}
+
+func (c *redisClaims) release(ctx context.Context, keys []string) error {
+	_, err := c.driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		for _, key := range keys {
+			pipe.EvalSha(ctx, c.releaseSha, []string{key})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release %d idempotency keys: %w", len(keys), err)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package routing
+
+import (
+	"chat/src/clients/redis/redistest"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	redis2 "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var errHandlerFailed = errors.New("handler failed")
+
+type fakeClaims struct {
+	mu     sync.Mutex
+	values map[string]string
+	ttls   map[string]time.Duration
+	err    error
+}
+
+func newFakeClaims() *fakeClaims {
+	return &fakeClaims{values: make(map[string]string), ttls: make(map[string]time.Duration)}
+}
+
+func (c *fakeClaims) acquire(_ context.Context, keys []string, ttl time.Duration) ([]claimState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	states := make([]claimState, len(keys))
+	for idx, key := range keys {
+		switch c.values[key] {
+		case "":
+			c.values[key], c.ttls[key] = claimValuePending, ttl
+			states[idx] = claimAcquired
+		case claimValueDone:
+			states[idx] = claimDone
+		default:
+			states[idx] = claimPending
+		}
+	}
+	return states, nil
+}
+
+func (c *fakeClaims) complete(_ context.Context, keys []string, window time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		c.values[key], c.ttls[key] = claimValueDone, window
+	}
+	return c.err
+}
+
+func (c *fakeClaims) release(_ context.Context, keys []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if c.values[key] == claimValuePending {
+			delete(c.values, key)
+			delete(c.ttls, key)
+		}
+	}
+	return c.err
+}
+
+func (c *fakeClaims) value(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func newTestDeduplicator(claims claimStore) *Deduplicator {
+	logger := zerolog.Nop()
+	return &Deduplicator{
+		claims:    claims,
+		keyPrefix: "dedup:",
+		window:    time.Hour,
+		claimTTL:  time.Minute,
+		timeout:   time.Second,
+		logger:    &logger,
+	}
+}
+
+func keyedRecords(keys ...string) []*kgo.Record {
+	records := make([]*kgo.Record, len(keys))
+	for idx, key := range keys {
+		records[idx] = &kgo.Record{Topic: "events", Offset: int64(idx), Key: []byte(key)}
+	}
+	return records
+}
+
+func keyOfRecord(record *kgo.Record) string {
+	return string(record.Key)
+}
+
+// recordingHandler records the keys of the records it's handed and returns the result of outcome.
+type recordingHandler struct {
+	handled [][]string
+	outcome func(records []*kgo.Record) error
+}
+
+func (h *recordingHandler) handle(records []*kgo.Record) error {
+	keys := make([]string, len(records))
+	for idx, record := range records {
+		keys[idx] = keyOfRecord(record)
+	}
+	h.handled = append(h.handled, keys)
+	if h.outcome == nil {
+		return nil
+	}
+	return h.outcome(records)
+}
+
+func TestDeduplicatorSkipsRecordsProcessedWithinWindow(t *testing.T) {
+	claims := newFakeClaims()
+	dedup := newTestDeduplicator(claims)
+	handler := &recordingHandler{}
+	wrapped := dedup.Wrap(keyOfRecord, handler.handle)
+
+	if err := wrapped(keyedRecords("a", "b")); err != nil {
+		t.Fatalf("first delivery error = %v", err)
+	}
+	if err := wrapped(keyedRecords("a", "b", "c")); err != nil {
+		t.Fatalf("redelivery error = %v", err)
+	}
+
+	want := [][]string{{"a", "b"}, {"c"}}
+	if !slices.EqualFunc(handler.handled, want, slices.Equal[[]string]) {
+		t.Errorf("handled = %v, want %v", handler.handled, want)
+	}
+	for _, key := range []string{"dedup:a", "dedup:b", "dedup:c"} {
+		if claims.value(key) != claimValueDone || claims.ttls[key] != time.Hour {
+			t.Errorf("claim of %s = (%s, %v), want done for the window", key, claims.value(key), claims.ttls[key])
+		}
+	}
+}
+
+func TestDeduplicatorHandsRecordsWithoutKey(t *testing.T) {
+	dedup := newTestDeduplicator(newFakeClaims())
+	handler := &recordingHandler{}
+	wrapped := dedup.Wrap(keyOfRecord, handler.handle)
+
+	for range 2 {
+		if err := wrapped(keyedRecords("", "")); err != nil {
+			t.Fatalf("delivery error = %v", err)
+		}
+	}
+	if len(handler.handled) != 2 {
+		t.Errorf("handled %d batches, want both deliveries", len(handler.handled))
+	}
+}
+
+func TestDeduplicatorProcessesRedeliveryAfterHandlerFailure(t *testing.T) {
+	claims := newFakeClaims()
+	dedup := newTestDeduplicator(claims)
+	handler := &recordingHandler{outcome: func([]*kgo.Record) error { return errHandlerFailed }}
+	wrapped := dedup.Wrap(keyOfRecord, handler.handle)
+
+	if err := wrapped(keyedRecords("a")); !errors.Is(err, errHandlerFailed) {
+		t.Fatalf("failed delivery error = %v, want %v", err, errHandlerFailed)
+	}
+	if value := claims.value("dedup:a"); value != "" {
+		t.Fatalf("claim after failure = %q, want released", value)
+	}
+
+	handler.outcome = nil
+	if err := wrapped(keyedRecords("a")); err != nil {
+		t.Fatalf("redelivery error = %v", err)
+	}
+	if len(handler.handled) != 2 {
+		t.Errorf("handled %d batches, want the redelivery to be handled", len(handler.handled))
+	}
+}
+
+func TestDeduplicatorProcessesRedeliveryAfterHandlerPanic(t *testing.T) {
+	claims := newFakeClaims()
+	dedup := newTestDeduplicator(claims)
+	handler := &recordingHandler{outcome: func([]*kgo.Record) error { panic("boom") }}
+	wrapped := dedup.Wrap(keyOfRecord, handler.handle)
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != "boom" {
+				t.Errorf("recovered %v, want the handler panic to propagate", recovered)
+			}
+		}()
+		_ = wrapped(keyedRecords("a"))
+	}()
+	if value := claims.value("dedup:a"); value != "" {
+		t.Fatalf("claim after panic = %q, want released", value)
+	}
+
+	handler.outcome = nil
+	if err := wrapped(keyedRecords("a")); err != nil {
+		t.Fatalf("redelivery error = %v", err)
+	}
+	if len(handler.handled) != 2 {
+		t.Errorf("handled %d batches, want the redelivery to be handled", len(handler.handled))
+	}
+}
+
+func TestDeduplicatorReleasesOnlyUnprocessedRecordsOnBackpressure(t *testing.T) {
+	claims := newFakeClaims()
+	dedup := newTestDeduplicator(claims)
+	handler := &recordingHandler{outcome: func(records []*kgo.Record) error {
+		return NewBackpressureError(records[1:], errHandlerFailed)
+	}}
+	wrapped := dedup.Wrap(keyOfRecord, handler.handle)
+
+	var backpressure *BackpressureError
+	if err := wrapped(keyedRecords("a", "b", "c")); !errors.As(err, &backpressure) {
+		t.Fatalf("error = %v, want backpressure", err)
+	}
+	if claims.value("dedup:a") != claimValueDone {
+		t.Errorf("claim of processed record = %q, want done", claims.value("dedup:a"))
+	}
+	for _, key := range []string{"dedup:b", "dedup:c"} {
+		if value := claims.value(key); value != "" {
+			t.Errorf("claim of unprocessed %s = %q, want released", key, value)
+		}
+	}
+}
+
+func TestDeduplicatorDefersRecordsClaimedByAnotherConsumer(t *testing.T) {
+	claims := newFakeClaims()
+	claims.values["dedup:b"] = claimValuePending
+	dedup := newTestDeduplicator(claims)
+	handler := &recordingHandler{}
+	wrapped := dedup.Wrap(keyOfRecord, handler.handle)
+
+	records := keyedRecords("a", "b", "c")
+	err := wrapped(records)
+
+	var backpressure *BackpressureError
+	if !errors.As(err, &backpressure) || !errors.Is(err, ErrRecordInProgress) {
+		t.Fatalf("error = %v, want backpressure caused by %v", err, ErrRecordInProgress)
+	}
+	if !slices.Equal(backpressure.Unprocessed, records[1:]) {
+		t.Errorf("unprocessed = %v, want the records from the pending one on", backpressure.Unprocessed)
+	}
+	if want := [][]string{{"a"}}; !slices.EqualFunc(handler.handled, want, slices.Equal[[]string]) {
+		t.Errorf("handled = %v, want %v", handler.handled, want)
+	}
+	if claims.value("dedup:b") != claimValuePending {
+		t.Errorf("claim of the other consumer = %q, want it kept", claims.value("dedup:b"))
+	}
+	if value := claims.value("dedup:c"); value != "" {
+		t.Errorf("claim of deferred record = %q, want released", value)
+	}
+}
+
+func TestDeduplicatorProcessesRecordsWhenClaimsFail(t *testing.T) {
+	claims := newFakeClaims()
+	claims.err = errHandlerFailed
+	dedup := newTestDeduplicator(claims)
+	handler := &recordingHandler{}
+	wrapped := dedup.Wrap(keyOfRecord, handler.handle)
+
+	for range 2 {
+		if err := wrapped(keyedRecords("a")); err != nil {
+			t.Fatalf("delivery error = %v", err)
+		}
+	}
+	if len(handler.handled) != 2 {
+		t.Errorf("handled %d batches, want duplicates over losses", len(handler.handled))
+	}
+}
+
+func TestRedisClaimsLifecycle(t *testing.T) {
+	client := redistest.NewClient(t)
+	ctx := context.Background()
+
+	claims, err := newRedisClaims(ctx, client.Driver)
+	if err != nil {
+		t.Fatalf("newRedisClaims() error = %v", err)
+	}
+
+	keys := []string{"dedup:a", "dedup:b"}
+	if states, err := claims.acquire(ctx, keys, time.Minute); err != nil ||
+		!slices.Equal(states, []claimState{claimAcquired, claimAcquired}) {
+		t.Fatalf("acquire() = (%v, %v), want both acquired", states, err)
+	}
+	if states, err := claims.acquire(ctx, keys, time.Minute); err != nil ||
+		!slices.Equal(states, []claimState{claimPending, claimPending}) {
+		t.Fatalf("acquire() of claimed keys = (%v, %v), want both pending", states, err)
+	}
+
+	if err := claims.complete(ctx, keys[:1], time.Hour); err != nil {
+		t.Fatalf("complete() error = %v", err)
+	}
+	if err := claims.release(ctx, keys); err != nil {
+		t.Fatalf("release() error = %v", err)
+	}
+	var window *redis2.DurationCmd
+	if _, err := client.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		window = pipe.PTTL(ctx, keys[0])
+		return nil
+	}); err != nil || window.Val() <= time.Minute {
+		t.Errorf("expiration of completed claim = (%v, %v), want the window", window.Val(), err)
+	}
+
+	if states, err := claims.acquire(ctx, keys, time.Minute); err != nil ||
+		!slices.Equal(states, []claimState{claimDone, claimAcquired}) {
+		t.Fatalf("acquire() after complete and release = (%v, %v), want done and acquired", states, err)
+	}
+}
@@ -90,7 +90,7 @@ func runDynamicSubscriptionTest(ctx context.Context, logger *zerolog.Logger, cha
 	}
 
 	var consumedCount atomic.Int64
-	router.OnRecordsFrom(topicName, func(records []*kgo.Record) {
+	router.OnRecordsFrom(topicName, func(records []*kgo.Record) error {
 		for _, r := range records {
 			logger.Info().Msgf("  <- Consumed: '%s' from T%s P%d (Offset: %d)", string(r.Value), r.Topic, r.Partition, r.Offset)
 		}
@@ -99,6 +99,7 @@ func runDynamicSubscriptionTest(ctx context.Context, logger *zerolog.Logger, cha
 		timer := time.NewTimer(4000 * time.Millisecond)
 		defer timer.Stop() // emulate long processing
 		<-timer.C
+		return nil
 	})
 	if err := router.Start(); err != nil {
 		panic(err)
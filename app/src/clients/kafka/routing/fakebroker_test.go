@@ -0,0 +1,205 @@
+package routing
+
+import (
+	"chat/src/clients/kafka/kafkatest"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// fakeBroker serves the records produced by the tests to the polls of a router, in place of the client.
+// As the client would, it skips the topics and partitions paused on the client and serves each record once,
+// unless the partition is rewound.
+type fakeBroker struct {
+	driver   *kgo.Client
+	mutex    sync.Mutex
+	logs     map[topicPartition][]*kgo.Record
+	fetched  map[topicPartition]int // offset of the next record to serve per partition
+	order    []topicPartition       // partitions in order of their first record
+	produced chan struct{}
+}
+
+func newFakeBroker(driver *kgo.Client) *fakeBroker {
+	return &fakeBroker{
+		driver:   driver,
+		logs:     make(map[topicPartition][]*kgo.Record),
+		fetched:  make(map[topicPartition]int),
+		produced: make(chan struct{}, 1),
+	}
+}
+
+// produce appends records with the values to the partition, assigning them the next offsets.
+func (b *fakeBroker) produce(topic string, partition int32, values ...string) []*kgo.Record {
+	return b.produceAt(time.Now(), topic, partition, values...)
+}
+
+// produceAt appends records with the values to the partition as produce, their timestamp being the given one.
+func (b *fakeBroker) produceAt(timestamp time.Time, topic string, partition int32, values ...string) []*kgo.Record {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	tp := topicPartition{topic: topic, partition: partition}
+	if _, found := b.logs[tp]; !found {
+		b.order = append(b.order, tp)
+	}
+	records := make([]*kgo.Record, 0, len(values))
+	for _, value := range values {
+		record := &kgo.Record{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    int64(len(b.logs[tp])),
+			Value:     []byte(value),
+			Timestamp: timestamp,
+		}
+		b.logs[tp] = append(b.logs[tp], record)
+		records = append(records, record)
+	}
+
+	select {
+	case b.produced <- struct{}{}:
+	default:
+	}
+	return records
+}
+
+// rewind makes the partition to be served again from the offset.
+func (b *fakeBroker) rewind(topic string, partition int32, offset int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.fetched[topicPartition{topic: topic, partition: partition}] = int(offset)
+}
+
+func (b *fakeBroker) poll(ctx context.Context, maxPollRecords int) kgo.Fetches {
+	for {
+		if fetches := b.take(maxPollRecords); !fetches.Empty() {
+			return fetches
+		}
+		select {
+		case <-ctx.Done():
+			return kgo.NewErrFetch(ctx.Err())
+		case <-b.produced:
+		case <-time.After(5 * time.Millisecond): // pauses and rewinds don't signal
+		}
+	}
+}
+
+func (b *fakeBroker) take(maxPollRecords int) kgo.Fetches {
+	pausedTopics := b.driver.PauseFetchTopics()
+	pausedPartitions := b.driver.PauseFetchPartitions(nil)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var fetch kgo.Fetch
+	for _, tp := range b.order {
+		if maxPollRecords == 0 {
+			break
+		}
+		if slices.Contains(pausedTopics, tp.topic) || slices.Contains(pausedPartitions[tp.topic], tp.partition) {
+			continue
+		}
+		log := b.logs[tp]
+		from := b.fetched[tp]
+		if from >= len(log) {
+			continue
+		}
+		to := min(len(log), from+maxPollRecords)
+		b.fetched[tp] = to
+		maxPollRecords -= to - from
+
+		partition := kgo.FetchPartition{Partition: tp.partition, Records: slices.Clone(log[from:to])}
+		idx := slices.IndexFunc(fetch.Topics, func(topic kgo.FetchTopic) bool { return topic.Topic == tp.topic })
+		if idx < 0 {
+			fetch.Topics = append(fetch.Topics, kgo.FetchTopic{Topic: tp.topic})
+			idx = len(fetch.Topics) - 1
+		}
+		fetch.Topics[idx].Partitions = append(fetch.Topics[idx].Partitions, partition)
+	}
+	if len(fetch.Topics) == 0 {
+		return nil
+	}
+	return kgo.Fetches{fetch}
+}
+
+// newTestRouter returns a router polling the fake broker, whose client never reaches a real one. The configure
+// function is optional, it's called on the options before the router is created and may replace their client.
+func newTestRouter(t *testing.T, configure func(options *ConsumerRouterOptions)) (*ConsumerRouter, *fakeBroker) {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	options := &ConsumerRouterOptions{Client: kafkatest.NewClient(t, nil), Logger: &logger}
+	if configure != nil {
+		configure(options)
+	}
+	router, err := NewConsumerRouter(options)
+	if err != nil {
+		t.Fatalf("NewConsumerRouter() error = %v", err)
+	}
+
+	broker := newFakeBroker(options.Client.Driver)
+	router.pollRecords = broker.poll
+	return router, broker
+}
+
+// startTestRouter starts the router, which is stopped on cleanup.
+func startTestRouter(t *testing.T, router *ConsumerRouter) {
+	t.Helper()
+
+	if err := router.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(router.Stop)
+}
+
+// recordValues returns the values of the records, in order.
+func recordValues(records []*kgo.Record) []string {
+	values := make([]string, 0, len(records))
+	for _, record := range records {
+		values = append(values, string(record.Value))
+	}
+	return values
+}
+
+// receiveRecords waits for the handler to hand over the records with the values, failing the test on timeout.
+func receiveRecords(t *testing.T, received <-chan []*kgo.Record, values ...string) {
+	t.Helper()
+
+	var got []string
+	deadline := time.After(2 * time.Second)
+	for len(got) < len(values) {
+		select {
+		case records := <-received:
+			got = append(got, recordValues(records)...)
+		case <-deadline:
+			t.Fatalf("received records %v, want %v", got, values)
+		}
+	}
+	if !slices.Equal(got, values) {
+		t.Fatalf("received records %v, want %v", got, values)
+	}
+}
+
+// expectNoRecords fails the test if the handler is handed records within the wait.
+func expectNoRecords(t *testing.T, received <-chan []*kgo.Record, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case records := <-received:
+		t.Fatalf("received records %v, want none", recordValues(records))
+	case <-time.After(wait):
+	}
+}
+
+// forwardingHandler hands the records to the returned channel.
+func forwardingHandler() (ConsumerHandler, <-chan []*kgo.Record) {
+	received := make(chan []*kgo.Record, 100)
+	return func(records []*kgo.Record) error {
+		received <- records
+		return nil
+	}, received
+}
@@ -0,0 +1,55 @@
+package routing
+
+import (
+	"chat/src/clients/kafka"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrClientAlreadyRouted = errors.New("kafka client is already used by another consumer router")
+	ErrSharedConsumerGroup = errors.New("consumer routers share a consumer group")
+	ErrSharedTopic         = errors.New("consumer routers consume the same topic")
+)
+
+// routedClients holds the clients used by routers, a client has a single consumer which can't be in two groups,
+// so isolated workloads (i.e. fanout and email) need routers on distinct clients with distinct group ids.
+var routedClients sync.Map // map[*kafka.Client]struct{}
+
+func claimClient(client *kafka.Client) error {
+	if _, loaded := routedClients.LoadOrStore(client, struct{}{}); loaded {
+		return ErrClientAlreadyRouted
+	}
+	return nil
+}
+
+func releaseClient(client *kafka.Client) {
+	routedClients.Delete(client)
+}
+
+// ValidateRouters checks, once the clients are started and the handlers are registered, that the routers consume
+// in distinct consumer groups and from disjoint topics, so a record is never handled by two of them.
+func ValidateRouters(routers map[string]*ConsumerRouter) error {
+	groups := make(map[string]string, len(routers))
+	topics := make(map[string]string)
+
+	for name, router := range routers {
+		group := router.kafkaClient.GroupID()
+		if group == "" {
+			return fmt.Errorf("consumer router '%s': %w", name, kafka.ErrNoConsumerGroup)
+		}
+		if other, shared := groups[group]; shared {
+			return fmt.Errorf("%w: routers '%s' and '%s' are both in group '%s'", ErrSharedConsumerGroup, other, name, group)
+		}
+		groups[group] = name
+
+		for _, topic := range router.topics() {
+			if other, shared := topics[topic]; shared {
+				return fmt.Errorf("%w: routers '%s' and '%s' both consume topic '%s'", ErrSharedTopic, other, name, topic)
+			}
+			topics[topic] = name
+		}
+	}
+	return nil
+}
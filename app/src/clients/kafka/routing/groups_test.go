@@ -0,0 +1,101 @@
+package routing
+
+import (
+	"chat/src/clients/kafka"
+	"chat/src/clients/kafka/kafkatest"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// withGroup makes the router consume on its own client, in the consumer group.
+func withGroup(t *testing.T, group string) func(options *ConsumerRouterOptions) {
+	return func(options *ConsumerRouterOptions) {
+		options.Client = kafkatest.NewClient(t, func(config *kafka.ConsumerGroupConfig) { config.GroupID = group })
+	}
+}
+
+func noopHandler([]*kgo.Record) error { return nil }
+
+func TestNewConsumerRouterRejectsRoutedClient(t *testing.T) {
+	client := kafkatest.NewClient(t, nil)
+	logger := zerolog.Nop()
+	options := func() *ConsumerRouterOptions { return &ConsumerRouterOptions{Client: client, Logger: &logger} }
+
+	router, err := NewConsumerRouter(options())
+	if err != nil {
+		t.Fatalf("NewConsumerRouter() error = %v", err)
+	}
+	if _, err := NewConsumerRouter(options()); !errors.Is(err, ErrClientAlreadyRouted) {
+		t.Fatalf("NewConsumerRouter() error = %v, want %v", err, ErrClientAlreadyRouted)
+	}
+
+	router.OnRecordsFrom("emails", noopHandler)
+	if err := router.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	router.Stop()
+	if _, err := NewConsumerRouter(options()); err != nil {
+		t.Fatalf("NewConsumerRouter() error = %v once the previous router stopped, want the client to be released", err)
+	}
+}
+
+func TestValidateRouters(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  [2]string
+		topics  [2][]string
+		wantErr error
+	}{
+		{name: "distinct groups and topics", groups: [2]string{"fanout-group", "email-group"}, topics: [2][]string{{"fanout"}, {"emails"}}},
+		{name: "shared group", groups: [2]string{"shared-group", "shared-group"}, topics: [2][]string{{"fanout"}, {"emails"}}, wantErr: ErrSharedConsumerGroup},
+		{name: "shared topic", groups: [2]string{"fanout-group", "email-group"}, topics: [2][]string{{"fanout", "emails"}, {"emails"}}, wantErr: ErrSharedTopic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routers := make(map[string]*ConsumerRouter, len(tt.groups))
+			for i, group := range tt.groups {
+				router, _ := newTestRouter(t, withGroup(t, group))
+				for _, topic := range tt.topics[i] {
+					router.OnRecordsFrom(topic, noopHandler)
+				}
+				routers[group+"-"+tt.topics[i][0]] = router
+			}
+
+			if err := ValidateRouters(routers); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateRouters() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoutersOnDistinctClientsConsumeDisjointTopics(t *testing.T) {
+	fanoutRouter, fanoutBroker := newTestRouter(t, withGroup(t, "fanout-group"))
+	fanoutHandler, fanoutReceived := forwardingHandler()
+	fanoutRouter.OnRecordsFrom("fanout", fanoutHandler)
+
+	emailRouter, emailBroker := newTestRouter(t, withGroup(t, "email-group"))
+	emailHandler, emailReceived := forwardingHandler()
+	emailRouter.OnRecordsFrom("emails", emailHandler)
+
+	if err := ValidateRouters(map[string]*ConsumerRouter{"fanout": fanoutRouter, "email": emailRouter}); err != nil {
+		t.Fatalf("ValidateRouters() error = %v", err)
+	}
+	startTestRouter(t, fanoutRouter)
+	startTestRouter(t, emailRouter)
+
+	fanoutBroker.produce("fanout", 0, "message-1", "message-2")
+	emailBroker.produce("emails", 0, "welcome")
+	receiveRecords(t, fanoutReceived, "message-1", "message-2")
+	receiveRecords(t, emailReceived, "welcome")
+
+	// pausing one workload leaves the other consuming
+	fanoutRouter.Pause()
+	fanoutBroker.produce("fanout", 0, "message-3")
+	emailBroker.produce("emails", 0, "reset-password")
+	receiveRecords(t, emailReceived, "reset-password")
+	expectNoRecords(t, fanoutReceived, 50*time.Millisecond)
+}
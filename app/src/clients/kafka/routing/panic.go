@@ -0,0 +1,138 @@
+package routing
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// PanicPolicy tells what happens with the offsets of a batch whose handler panicked.
+type PanicPolicy uint8
+
+const (
+	// PanicPolicyRetry doesn't mark the batch, the partition is rewound and paused like on backpressure.
+	// After PanicRetries consecutive panics on the same offset, the batch is dead lettered when a DeadLetter
+	// is configured, otherwise it is skipped, so a poison record doesn't crash-loop the partition forever.
+	PanicPolicyRetry PanicPolicy = iota
+	// PanicPolicySkip logs the panic and marks the batch.
+	PanicPolicySkip
+	// PanicPolicyDeadLetter hands the batch to the DeadLetter and marks it once accepted.
+	PanicPolicyDeadLetter
+)
+
+// DeadLetterFunc receives the records of a batch whose handler panicked. Returning an error keeps the batch
+// unmarked, the partition being rewound and paused until the dead letter accepts it.
+type DeadLetterFunc func(records []*kgo.Record, cause error) error
+
+// HandlerPanicError is the error a panicking handler is converted to.
+type HandlerPanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *HandlerPanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type panicAttempts struct {
+	offset int64
+	count  int
+}
+
+type panicTracker struct {
+	policy     PanicPolicy
+	retries    int
+	deadLetter DeadLetterFunc
+
+	mu       sync.Mutex
+	attempts map[topicPartition]panicAttempts
+}
+
+// invokeHandler calls the handler, converting a panic into the error the panic policy resolves it to.
+func (r *ConsumerRouter) invokeHandler(topic string, partition int32, handler ConsumerHandler, records []*kgo.Record) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			cause := &HandlerPanicError{Value: recovered, Stack: debug.Stack()}
+			err = r.panics.resolve(topicPartition{topic: topic, partition: partition}, records, cause, r.logger)
+		}
+	}()
+
+	err = handler(records)
+	r.panics.reset(topicPartition{topic: topic, partition: partition})
+	return err
+}
+
+func (t *panicTracker) resolve(
+	tp topicPartition, records []*kgo.Record, cause *HandlerPanicError, logger *zerolog.Logger,
+) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	policy := t.policy
+	if policy == PanicPolicyRetry {
+		attempt := t.track(tp, records[0].Offset)
+		if attempt <= t.retries {
+			logger.Error().Str("stack", string(cause.Stack)).Msgf(
+				"Handler panicked on topic-partition %s-%d at offset %d (attempt %d of %d), retrying: %v",
+				tp.topic, tp.partition, records[0].Offset, attempt, t.retries+1, cause.Value,
+			)
+			return NewBackpressureError(records, cause)
+		}
+
+		t.reset(tp)
+		policy = PanicPolicySkip
+		if t.deadLetter != nil {
+			policy = PanicPolicyDeadLetter
+		}
+	}
+
+	if policy == PanicPolicyDeadLetter {
+		if err := t.deadLetter(records, cause); err != nil {
+			logger.Error().Err(err).Str("stack", string(cause.Stack)).Msgf(
+				"Handler panicked on topic-partition %s-%d at offset %d and dead lettering %d records failed, retrying: %v",
+				tp.topic, tp.partition, records[0].Offset, len(records), cause.Value,
+			)
+			return NewBackpressureError(records, cause)
+		}
+		logger.Error().Str("stack", string(cause.Stack)).Msgf(
+			"Handler panicked on topic-partition %s-%d at offset %d, dead lettered %d records: %v",
+			tp.topic, tp.partition, records[0].Offset, len(records), cause.Value,
+		)
+		return nil
+	}
+
+	logger.Error().Str("stack", string(cause.Stack)).Msgf(
+		"Handler panicked on topic-partition %s-%d at offset %d, skipping %d records: %v",
+		tp.topic, tp.partition, records[0].Offset, len(records), cause.Value,
+	)
+	return nil
+}
+
+// track counts the consecutive panics of the handler on the batch starting at the given offset.
+func (t *panicTracker) track(tp topicPartition, offset int64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	attempts := t.attempts[tp]
+	if attempts.offset != offset {
+		attempts = panicAttempts{offset: offset}
+	}
+	attempts.count++
+	t.attempts[tp] = attempts
+	return attempts.count
+}
+
+func (t *panicTracker) reset(tp topicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, tp)
+}
@@ -0,0 +1,215 @@
+package routing
+
+import (
+	"chat/src/clients/kafka/kafkatest"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// poisonHandler panics on the batches containing the poison record, counting the batches it was handed.
+func poisonHandler(poison string) (ConsumerHandler, func() int) {
+	var mutex sync.Mutex
+	var calls int
+	return func(records []*kgo.Record) error {
+			mutex.Lock()
+			calls++
+			mutex.Unlock()
+			if slices.Contains(recordValues(records), poison) {
+				panic("poison record " + poison)
+			}
+			return nil
+		}, func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return calls
+		}
+}
+
+// recordingDeadLetter hands the records it receives to the returned channel, failing with the error when set.
+func recordingDeadLetter(err error) (DeadLetterFunc, <-chan []*kgo.Record) {
+	received := make(chan []*kgo.Record, 10)
+	return func(records []*kgo.Record, cause error) error {
+		var panicErr *HandlerPanicError
+		if !errors.As(cause, &panicErr) || len(panicErr.Stack) == 0 {
+			panic("dead letter handed a cause which isn't a handler panic")
+		}
+		received <- records
+		return err
+	}, received
+}
+
+func waitForMarkedOffset(t *testing.T, router *ConsumerRouter, topic string, want int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		offset, marked := router.kafkaClient.Driver.MarkedOffsets()[topic][0]
+		if marked && offset.Offset == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("MarkedOffsets() = %d (marked %t), want %d", offset.Offset, marked, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitForCalls(t *testing.T, calls func() int, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for calls() < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("handler called %d times, want %d", calls(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitForPausedPartition(t *testing.T, router *ConsumerRouter, topic string, partition int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !slices.Contains(router.kafkaClient.Driver.PauseFetchPartitions(nil)[topic], partition) {
+		if time.Now().After(deadline) {
+			t.Fatalf("partition %s-%d isn't paused", topic, partition)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func expectNotMarked(t *testing.T, router *ConsumerRouter, topic string) {
+	t.Helper()
+
+	if offset, marked := router.kafkaClient.Driver.MarkedOffsets()[topic][0]; marked {
+		t.Fatalf("MarkedOffsets() = %d, want the batch of the panicking handler not to be marked", offset.Offset)
+	}
+}
+
+func TestPanicPolicySkipMarksPanickingBatch(t *testing.T) {
+	router, broker := newTestRouter(t, func(options *ConsumerRouterOptions) { options.PanicPolicy = PanicPolicySkip })
+	handler, calls := poisonHandler("poison")
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	broker.produce("events", 0, "a", "poison", "b")
+	waitForMarkedOffset(t, router, "events", 3)
+
+	broker.produce("events", 0, "c")
+	waitForMarkedOffset(t, router, "events", 4)
+	if got := calls(); got != 2 {
+		t.Fatalf("handler called %d times, want the panicking batch not to be retried", got)
+	}
+}
+
+func TestPanicPolicyDeadLetterHandsOffPanickingBatch(t *testing.T) {
+	deadLetter, deadLettered := recordingDeadLetter(nil)
+	router, broker := newTestRouter(t, func(options *ConsumerRouterOptions) {
+		options.PanicPolicy = PanicPolicyDeadLetter
+		options.DeadLetter = deadLetter
+	})
+	handler, _ := poisonHandler("poison")
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	broker.produce("events", 0, "a", "poison")
+	receiveRecords(t, deadLettered, "a", "poison")
+	waitForMarkedOffset(t, router, "events", 2)
+}
+
+func TestPanicPolicyDeadLetterKeepsBatchWhenDeadLetterFails(t *testing.T) {
+	deadLetter, deadLettered := recordingDeadLetter(errors.New("dead letter unavailable"))
+	router, broker := newTestRouter(t, func(options *ConsumerRouterOptions) {
+		options.PanicPolicy = PanicPolicyDeadLetter
+		options.DeadLetter = deadLetter
+		options.BackpressurePause = 30 * time.Second
+	})
+	handler, _ := poisonHandler("poison")
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	broker.produce("events", 0, "poison")
+	receiveRecords(t, deadLettered, "poison")
+	waitForPausedPartition(t, router, "events", 0)
+	expectNotMarked(t, router, "events")
+}
+
+func TestPanicPolicyRetryRetriesThenDeadLetters(t *testing.T) {
+	deadLetter, deadLettered := recordingDeadLetter(nil)
+	router, broker := newTestRouter(t, func(options *ConsumerRouterOptions) {
+		options.PanicRetries = 2
+		options.DeadLetter = deadLetter
+		options.BackpressurePause = 100 * time.Millisecond
+	})
+	handler, calls := poisonHandler("poison")
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	broker.produce("events", 0, "a", "poison")
+	for attempt := 1; attempt <= 2; attempt++ {
+		waitForCalls(t, calls, attempt)
+		waitForPausedPartition(t, router, "events", 0)
+		expectNotMarked(t, router, "events")
+		broker.rewind("events", 0, 0) // as the client does once the router sets the offset back
+	}
+
+	receiveRecords(t, deadLettered, "a", "poison")
+	waitForMarkedOffset(t, router, "events", 2)
+	if got := calls(); got != 3 {
+		t.Fatalf("handler called %d times, want %d", got, 3)
+	}
+}
+
+func TestPanicPolicyRetrySkipsWithoutDeadLetter(t *testing.T) {
+	router, broker := newTestRouter(t, func(options *ConsumerRouterOptions) {
+		options.PanicRetries = 1
+		options.BackpressurePause = 100 * time.Millisecond
+	})
+	handler, calls := poisonHandler("poison")
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	broker.produce("events", 0, "poison")
+	waitForPausedPartition(t, router, "events", 0)
+	expectNotMarked(t, router, "events")
+	broker.rewind("events", 0, 0)
+
+	waitForMarkedOffset(t, router, "events", 1)
+	if got := calls(); got != 2 {
+		t.Fatalf("handler called %d times, want %d", got, 2)
+	}
+}
+
+func TestPanicTrackerCountsConsecutivePanicsPerOffset(t *testing.T) {
+	tracker := panicTracker{retries: 2, attempts: make(map[topicPartition]panicAttempts)}
+	tp := topicPartition{topic: "events", partition: 0}
+
+	if got := tracker.track(tp, 5); got != 1 {
+		t.Fatalf("track() = %d, want %d", got, 1)
+	}
+	if got := tracker.track(tp, 5); got != 2 {
+		t.Fatalf("track() = %d, want %d", got, 2)
+	}
+	if got := tracker.track(tp, 6); got != 1 {
+		t.Fatalf("track() on another offset = %d, want the count to restart", got)
+	}
+	tracker.reset(tp)
+	if got := tracker.track(tp, 6); got != 1 {
+		t.Fatalf("track() after reset = %d, want the count to restart", got)
+	}
+}
+
+func TestNewConsumerRouterRequiresDeadLetterForDeadLetterPolicy(t *testing.T) {
+	logger := zerolog.Nop()
+	options := &ConsumerRouterOptions{Client: kafkatest.NewClient(t, nil), Logger: &logger, PanicPolicy: PanicPolicyDeadLetter}
+	_, err := NewConsumerRouter(options)
+	if err == nil {
+		t.Fatal("NewConsumerRouter() error = nil, want the missing dead letter to be reported")
+	}
+}
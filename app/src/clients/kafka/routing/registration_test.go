@@ -0,0 +1,156 @@
+package routing
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// registrationTopics is the number of topics registered concurrently by the tests.
+const registrationTopics = 20
+
+// registerConcurrently registers a forwarding handler for each of the topics from its own goroutine, returning
+// the channels receiving the records of each topic.
+func registerConcurrently(router *ConsumerRouter, topics []string) map[string]<-chan []*kgo.Record {
+	received := make(map[string]<-chan []*kgo.Record, len(topics))
+	handlers := make(map[string]ConsumerHandler, len(topics))
+	for _, topic := range topics {
+		handlers[topic], received[topic] = forwardingHandler()
+	}
+
+	var wg sync.WaitGroup
+	for _, topic := range topics {
+		wg.Go(func() { router.OnRecordsFrom(topic, handlers[topic]) })
+	}
+	wg.Wait()
+	return received
+}
+
+func registrationTopicNames() []string {
+	topics := make([]string, 0, registrationTopics)
+	for i := range registrationTopics {
+		topics = append(topics, fmt.Sprintf("topic-%d", i))
+	}
+	return topics
+}
+
+func TestConcurrentRegistrationBeforeStart(t *testing.T) {
+	router, broker := newTestRouter(t, nil)
+	topics := registrationTopicNames()
+	received := registerConcurrently(router, topics)
+
+	registered := router.topics()
+	slices.Sort(registered)
+	slices.Sort(topics)
+	if !slices.Equal(registered, topics) {
+		t.Fatalf("topics() = %v, want %v", registered, topics)
+	}
+
+	startTestRouter(t, router)
+	for _, topic := range topics {
+		broker.produce(topic, 0, topic)
+	}
+	for _, topic := range topics {
+		receiveRecords(t, received[topic], topic)
+	}
+}
+
+func TestConcurrentRegistrationWhilePolling(t *testing.T) {
+	router, broker := newTestRouter(t, nil)
+	handler, events := forwardingHandler()
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	// records keep being polled and routed while the topics are registered
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Go(func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				broker.produce("events", 0, fmt.Sprint(i))
+				router.Stats()
+			}
+			if i%10 == 0 {
+				// don't let the records pile up in the channel of the handler
+				for len(events) > 0 {
+					<-events
+				}
+			}
+		}
+	})
+	topics := registrationTopicNames()
+	received := registerConcurrently(router, topics)
+	close(stop)
+	wg.Wait()
+
+	for _, topic := range topics {
+		broker.produce(topic, 0, topic)
+	}
+	for _, topic := range topics {
+		receiveRecords(t, received[topic], topic)
+	}
+}
+
+func TestConcurrentRegistrationAndPause(t *testing.T) {
+	router, broker := newTestRouter(t, nil)
+	handler, _ := forwardingHandler()
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	var wg sync.WaitGroup
+	wg.Go(router.Pause)
+	topics := registrationTopicNames()
+	received := registerConcurrently(router, topics)
+	wg.Wait()
+
+	// whichever ran first, the topics registered concurrently with Pause are paused
+	want := append(slices.Clone(topics), "events")
+	paused := router.Stats().PausedTopics
+	slices.Sort(paused)
+	slices.Sort(want)
+	if !slices.Equal(paused, want) {
+		t.Fatalf("Stats().PausedTopics = %v, want %v", paused, want)
+	}
+	for _, topic := range topics {
+		broker.produce(topic, 0, topic)
+	}
+	expectNoRecords(t, received[topics[0]], 30*time.Millisecond)
+
+	wg.Go(router.Resume)
+	more := registerConcurrently(router, []string{"late-0", "late-1"})
+	wg.Wait()
+
+	if paused := router.Stats().PausedTopics; len(paused) != 0 {
+		t.Fatalf("Stats().PausedTopics = %v after resume, want none", paused)
+	}
+	for _, topic := range topics {
+		receiveRecords(t, received[topic], topic)
+	}
+	for topic, channel := range more {
+		broker.produce(topic, 0, topic)
+		receiveRecords(t, channel, topic)
+	}
+}
+
+func TestRegistrationReplacesHandlerOfRoutedTopic(t *testing.T) {
+	router, broker := newTestRouter(t, nil)
+	first, firstReceived := forwardingHandler()
+	router.OnRecordsFrom("events", first)
+	startTestRouter(t, router)
+
+	broker.produce("events", 0, "a")
+	receiveRecords(t, firstReceived, "a")
+
+	second, secondReceived := forwardingHandler()
+	router.OnRecordsFrom("events", second)
+	broker.produce("events", 0, "b")
+	receiveRecords(t, secondReceived, "b")
+	expectNoRecords(t, firstReceived, 20*time.Millisecond)
+}
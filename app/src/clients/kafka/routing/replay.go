@@ -0,0 +1,37 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var ErrReplayOfUnroutedTopic = errors.New("topic has no handler")
+
+// ReplayFrom seeks the partitions of the topic assigned to this consumer to the first records produced at or after
+// the timestamp, i.e. to reprocess records after a fix. Partitions assigned to other members of the group are not
+// moved, the replay must be triggered on each of them. Offsets of replayed records are committed again as they are
+// processed. Handlers with side effects must be wrapped with a Deduplicator, so records processed within its
+// window are skipped instead of repeating their side effects.
+func (r *ConsumerRouter) ReplayFrom(ctx context.Context, topic string, timestamp time.Time) (map[int32]kgo.Offset, error) {
+	if _, routed := r.handler(topic); !routed {
+		return nil, fmt.Errorf("%w: '%s'", ErrReplayOfUnroutedTopic, topic)
+	}
+
+	offsets, err := r.offsetsForTimestamp(ctx, topic, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay topic '%s' from %v: %w", topic, timestamp, err)
+	}
+
+	seeks := make(map[int32]kgo.EpochOffset, len(offsets))
+	for partition, offset := range offsets {
+		seeks[partition] = offset.EpochOffset()
+	}
+	r.setOffsets(map[string]map[int32]kgo.EpochOffset{topic: seeks})
+
+	r.logger.Warn().Msgf("Replaying topic '%s' from %v on %d partitions", topic, timestamp, len(seeks))
+	return offsets, nil
+}
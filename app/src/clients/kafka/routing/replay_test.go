@@ -0,0 +1,94 @@
+package routing
+
+import (
+	"chat/src/clients/kafka/kafkatest"
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestReplayFromSeeksToTheOffsetsOfTheTimestamp(t *testing.T) {
+	router, _ := newTestRouter(t, nil)
+	router.OnRecordsFrom("emails", func([]*kgo.Record) error { return nil })
+	timestamp := time.UnixMilli(1_700_000_000_000)
+	router.offsetsForTimestamp = func(_ context.Context, topic string, at time.Time) (map[int32]kgo.Offset, error) {
+		if topic != "emails" || !at.Equal(timestamp) {
+			t.Errorf("offsets listed for %s at %v, want emails at %v", topic, at, timestamp)
+		}
+		return map[int32]kgo.Offset{
+			0: kgo.NewOffset().At(12).WithEpoch(3),
+			1: kgo.NewOffset().At(40).WithEpoch(5),
+		}, nil
+	}
+	var seeks []map[string]map[int32]kgo.EpochOffset
+	router.setOffsets = func(offsets map[string]map[int32]kgo.EpochOffset) { seeks = append(seeks, offsets) }
+
+	if _, err := router.ReplayFrom(context.Background(), "emails", timestamp); err != nil {
+		t.Fatalf("ReplayFrom() error = %v", err)
+	}
+	if len(seeks) != 1 {
+		t.Fatalf("client seeked %d times, want once", len(seeks))
+	}
+	want := map[int32]kgo.EpochOffset{0: {Epoch: 3, Offset: 12}, 1: {Epoch: 5, Offset: 40}}
+	if len(seeks[0]) != 1 || !maps.Equal(seeks[0]["emails"], want) {
+		t.Fatalf("client seeked to %v, want emails at %v", seeks[0], want)
+	}
+}
+
+func TestReplayFromDoesNotSeek(t *testing.T) {
+	listFailure := errors.New("connection reset")
+	tests := []struct {
+		name     string
+		register bool
+		listErr  error
+		want     error
+	}{
+		{name: "unrouted topic", want: ErrReplayOfUnroutedTopic},
+		{name: "offsets not listed", register: true, listErr: listFailure, want: listFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newTestRouter(t, nil)
+			if tt.register {
+				router.OnRecordsFrom("emails", func([]*kgo.Record) error { return nil })
+			}
+			router.offsetsForTimestamp = func(context.Context, string, time.Time) (map[int32]kgo.Offset, error) {
+				return nil, tt.listErr
+			}
+			router.setOffsets = func(offsets map[string]map[int32]kgo.EpochOffset) {
+				t.Errorf("client seeked to %v, want no seek", offsets)
+			}
+
+			if _, err := router.ReplayFrom(context.Background(), "emails", time.Now()); !errors.Is(err, tt.want) {
+				t.Fatalf("ReplayFrom() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayFromSeeksTheDriverOfTheRestartedClient(t *testing.T) {
+	client := kafkatest.NewClient(t, nil)
+	client.Stop(context.Background()) // the router is built while the driver of the client is down
+	logger := zerolog.Nop()
+	router, err := NewConsumerRouter(&ConsumerRouterOptions{Client: client, Logger: &logger})
+	if err != nil {
+		t.Fatalf("NewConsumerRouter() error = %v", err)
+	}
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start() of the client error = %v", err)
+	}
+	router.OnRecordsFrom("emails", func([]*kgo.Record) error { return nil })
+	router.offsetsForTimestamp = func(context.Context, string, time.Time) (map[int32]kgo.Offset, error) {
+		return map[int32]kgo.Offset{0: kgo.NewOffset().At(12)}, nil
+	}
+
+	// seeking the driver the client had when the router was built would dereference a nil driver
+	if _, err := router.ReplayFrom(context.Background(), "emails", time.Now()); err != nil {
+		t.Fatalf("ReplayFrom() error = %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package routing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// partitionHandlers tracks the handler running for each topic-partition, so the records of a partition revoked
+// while its handler runs are marked only when the handler completes before the revoke commit. Handlers are told
+// apart by their done channel, as the handler of a partition assigned again may start before the revoked one
+// completes.
+type partitionHandlers struct {
+	mu       sync.Mutex
+	inFlight map[topicPartition]chan struct{}
+	revoked  map[chan struct{}]struct{} // handlers which didn't complete within the revoke timeout
+}
+
+func (p *partitionHandlers) track(tp topicPartition, done chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight[tp] = done
+}
+
+// onPartitionsRevoked waits for the in-flight handlers of the revoked partitions, so the marks of the ones completing
+// within the revoke timeout are part of the commit done on revoke. Handlers still running after it
+// won't mark their records, the new owner of the partition consuming them again from the last committed offset.
+// Partitions paused by backpressure are resumed right away, pauses outlive revokes, so a partition assigned again
+// later would stay paused otherwise.
+func (r *ConsumerRouter) onPartitionsRevoked(ctx context.Context, revoked map[string][]int32) {
+	ctx, cancel := context.WithTimeout(ctx, r.revokeTimeout)
+	defer cancel()
+
+	for topic, partitions := range revoked {
+		for _, partition := range partitions {
+			tp := topicPartition{topic: topic, partition: partition}
+			if r.resumes.cancel(tp) {
+				r.kafkaClient.Driver.ResumeFetchPartitions(map[string][]int32{topic: {partition}})
+			}
+
+			r.partitions.mu.Lock()
+			done, running := r.partitions.inFlight[tp]
+			r.partitions.mu.Unlock()
+			if !running {
+				continue
+			}
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+				r.partitions.mu.Lock()
+				if r.partitions.inFlight[tp] == done {
+					r.partitions.revoked[done] = struct{}{}
+					r.logger.Warn().Msgf(
+						"Handler of revoked topic-partition %s-%d didn't complete in time, its records won't be marked.",
+						topic, partition,
+					)
+				}
+				r.partitions.mu.Unlock()
+			}
+		}
+	}
+}
+
+// completeHandler marks the records of a completed handler unless its partition was revoked meanwhile.
+// Marking is done while holding the lock, so marks land either before the revoke commit or not at all.
+// The handler is untracked only while it's still the one tracked for its partition, done being its done channel.
+func (r *ConsumerRouter) completeHandler(
+	topic string, partition int32, done chan struct{}, records []*kgo.Record, err error,
+) bool {
+	tp := topicPartition{topic: topic, partition: partition}
+
+	r.partitions.mu.Lock()
+	defer r.partitions.mu.Unlock()
+
+	if r.partitions.inFlight[tp] == done {
+		delete(r.partitions.inFlight, tp)
+	}
+	if _, revoked := r.partitions.revoked[done]; revoked {
+		delete(r.partitions.revoked, done)
+		r.logger.Warn().Err(err).Msgf(
+			"Handler of revoked topic-partition %s-%d completed, discarding marks of its %d records.",
+			topic, partition, len(records),
+		)
+		return false
+	}
+	return r.markProcessedRecords(topic, partition, records, err)
+}
@@ -0,0 +1,117 @@
+package routing
+
+import (
+	"chat/src/clients/kafka"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const revokeTestTopic = "events"
+
+var revokeTestPartition = topicPartition{topic: revokeTestTopic, partition: 0}
+
+// newRevokeTestRouter returns a router whose client marks records without ever reaching a broker.
+func newRevokeTestRouter(t *testing.T, revokeTimeout time.Duration) *ConsumerRouter {
+	t.Helper()
+
+	driver, err := kgo.NewClient(
+		kgo.SeedBrokers("127.0.0.1:1"),
+		kgo.ConsumerGroup("revoke-test"),
+		kgo.ConsumeTopics(revokeTestTopic),
+		kgo.AutoCommitMarks(),
+	)
+	if err != nil {
+		t.Fatalf("failed to create Kafka client: %v", err)
+	}
+	t.Cleanup(driver.Close)
+
+	logger := zerolog.Nop()
+	return &ConsumerRouter{
+		kafkaClient:   &kafka.Client{Driver: driver},
+		revokeTimeout: revokeTimeout,
+		partitions: partitionHandlers{
+			inFlight: make(map[topicPartition]chan struct{}),
+			revoked:  make(map[chan struct{}]struct{}),
+		},
+		resumes: resumeTimers{timers: make(map[topicPartition]*time.Timer)},
+		logger:  &logger,
+	}
+}
+
+func markedOffset(router *ConsumerRouter) (int64, bool) {
+	offset, marked := router.kafkaClient.Driver.MarkedOffsets()[revokeTestTopic][0]
+	return offset.Offset, marked
+}
+
+func revokeTestRecords(offset int64) []*kgo.Record {
+	return []*kgo.Record{{Topic: revokeTestTopic, Partition: 0, Offset: offset}}
+}
+
+func TestRevokeMarksRecordsOfHandlerCompletingInTime(t *testing.T) {
+	router := newRevokeTestRouter(t, time.Second)
+	done := make(chan struct{})
+	router.partitions.track(revokeTestPartition, done)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		router.completeHandler(revokeTestTopic, 0, done, revokeTestRecords(4), nil)
+		close(done)
+	}()
+	router.onPartitionsRevoked(context.Background(), map[string][]int32{revokeTestTopic: {0}})
+
+	if offset, marked := markedOffset(router); !marked || offset != 5 {
+		t.Fatalf("marked offset = %d (marked %v), want 5 before the revoke commit", offset, marked)
+	}
+	if len(router.partitions.revoked) != 0 {
+		t.Fatal("handler completing within the revoke timeout was recorded as revoked")
+	}
+}
+
+func TestRevokeDiscardsMarksOfHandlerNotCompletedInTime(t *testing.T) {
+	router := newRevokeTestRouter(t, 10*time.Millisecond)
+	done := make(chan struct{})
+	router.partitions.track(revokeTestPartition, done)
+
+	router.onPartitionsRevoked(context.Background(), map[string][]int32{revokeTestTopic: {0}})
+	if backpressured := router.completeHandler(revokeTestTopic, 0, done, revokeTestRecords(4), nil); backpressured {
+		t.Fatal("completion of revoked handler reported backpressure")
+	}
+
+	if offset, marked := markedOffset(router); marked {
+		t.Fatalf("records of the revoked handler were marked up to offset %d", offset)
+	}
+	if len(router.partitions.inFlight) != 0 || len(router.partitions.revoked) != 0 {
+		t.Fatal("revoked handler is still tracked after completing")
+	}
+}
+
+func TestLateCompletionOfRevokedHandlerKeepsNewerHandler(t *testing.T) {
+	router := newRevokeTestRouter(t, 10*time.Millisecond)
+	revokedDone := make(chan struct{})
+	router.partitions.track(revokeTestPartition, revokedDone)
+	router.onPartitionsRevoked(context.Background(), map[string][]int32{revokeTestTopic: {0}})
+
+	// the partition is assigned again and its next handler starts before the revoked one completes
+	newerDone := make(chan struct{})
+	router.partitions.track(revokeTestPartition, newerDone)
+	router.completeHandler(revokeTestTopic, 0, revokedDone, revokeTestRecords(4), nil)
+
+	if router.partitions.inFlight[revokeTestPartition] != newerDone {
+		t.Fatal("completion of the revoked handler untracked the newer handler of the partition")
+	}
+	if _, marked := markedOffset(router); marked {
+		t.Fatal("records of the revoked handler were marked")
+	}
+
+	router.completeHandler(revokeTestTopic, 0, newerDone, revokeTestRecords(9), nil)
+	if offset, marked := markedOffset(router); !marked || offset != 10 {
+		t.Fatalf("marked offset = %d (marked %v), want the records of the newer handler marked up to 10", offset, marked)
+	}
+	if len(router.partitions.inFlight) != 0 {
+		t.Fatal("newer handler is still tracked after completing")
+	}
+}
@@ -3,10 +3,12 @@ package routing
 import (
 	"chat/src/clients/kafka"
 	"chat/src/platform/validation"
+	"chat/src/util"
 	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/creasty/defaults"
@@ -26,28 +28,82 @@ const (
 
 var ErrNoTopicHandler = errors.New("no topic handlers defined")
 
-type ConsumerHandler func(records []*kgo.Record)
+// ConsumerHandler processes a batch of records fetched from a single topic-partition.
+// Returning nil marks the whole batch for commit. Returning a *BackpressureError marks only the records
+// preceding the unprocessed ones, after which the partition is rewound and paused for a while.
+// Any other error is logged and the batch is still marked, the handler being responsible for its own failures.
+type ConsumerHandler func(records []*kgo.Record) error
+
+// BackpressureError signals that a handler can't accept the remaining records of a batch at the moment.
+type BackpressureError struct {
+	Unprocessed []*kgo.Record
+	Cause       error
+}
+
+func NewBackpressureError(unprocessed []*kgo.Record, cause error) *BackpressureError {
+	return &BackpressureError{Unprocessed: unprocessed, Cause: cause}
+}
+
+func (e *BackpressureError) Error() string {
+	return fmt.Sprintf("handler applied backpressure with %d unprocessed records: %v", len(e.Unprocessed), e.Cause)
+}
+
+func (e *BackpressureError) Unwrap() error {
+	return e.Cause
+}
 
 // ConsumerRouter routes Kafka records fetched from different topics to their respective handlers.
 // It requires Cooperative Sticky rebalancing strategy and AutoCommitMarks to be enabled in the Kafka client configuration.
+// Several routers can run side by side, each on its own client and consumer group, see ValidateRouters.
 type ConsumerRouter struct {
 	// @fixme test rebalances
 	kafkaClient             *kafka.Client
 	topicHandlers           map[string]ConsumerHandler
+	topicHandlersMu         sync.RWMutex // guards topicHandlers, handlers may be registered while fetches are polled
 	runningHandlersWg       sync.WaitGroup
 	handlerConcurrencySem   *semaphore.Weighted
 	handlerTimeoutEstimator *timeoutEstimator
+	backpressurePause       time.Duration
+	revokeTimeout           time.Duration
+	maxPollRecords          int
+	paused                  atomic.Bool
+	inFlightHandlers        atomic.Int64
+	messageAges             messageAges
+	panics                  panicTracker
+	partitions              partitionHandlers
+	resumes                 resumeTimers
+	pollRecords             func(ctx context.Context, maxPollRecords int) kgo.Fetches                                  // #readonly, polls the client, replaced by tests
+	offsetsForTimestamp     func(ctx context.Context, topic string, timestamp time.Time) (map[int32]kgo.Offset, error) // #readonly, lists the offsets to replay from, replaced by tests
+	setOffsets              func(offsets map[string]map[int32]kgo.EpochOffset)                                         // #readonly, seeks the client, replaced by tests
 	stopPollFetches         context.CancelFunc
 	pollFetchesStopped      chan struct{}
 	logger                  *zerolog.Logger
 }
 
 type ConsumerRouterOptions struct {
-	Client             *kafka.Client   `validate:"required"`
+	// Client is skipped by defaults, which would read its driver while started.
+	Client             *kafka.Client   `validate:"required" default:"-"`
 	MinHandlerTimeout  time.Duration   `validate:"required,min=100000000,max=1000000000" default:"500ms"`                              // 100ms to 1s
 	MaxHandlerTimeout  time.Duration   `validate:"required,min=1000000000,max=10000000000,gtfield=MinHandlerTimeout" default:"5000ms"` // 1s to 10s
 	HandlerConcurrency int64           `validate:"required,min=1,max=1000" default:"100"`
+	MaxPollRecords     int             `validate:"required,min=1,max=100000" default:"500"`               // bounds the records handed to handlers per poll
+	BackpressurePause  time.Duration   `validate:"required,min=100000000,max=30000000000" default:"1s"`   // 100ms to 30s
+	RevokeTimeout      time.Duration   `validate:"required,min=1000000000,max=50000000000" default:"10s"` // 1s to 50s
+	PanicPolicy        PanicPolicy     `validate:"lte=2"`
+	PanicRetries       int             `validate:"min=0,max=100" default:"3"`
+	DeadLetter         DeadLetterFunc  `validate:"required_if=PanicPolicy 2"`
 	Logger             *zerolog.Logger `validate:"required"`
+	// MessageAgeThreshold is the age of processed records above which the router logs and reports them as stale,
+	// see MessageAgeCheck. By default, ages are only tracked.
+	MessageAgeThreshold time.Duration `validate:"omitempty,min=1000000000,max=86400000000000"` // 1s to 24h
+}
+
+// Stats is a point-in-time snapshot of router state.
+type Stats struct {
+	Paused           bool     // consumption of all topics is paused via Pause
+	PausedTopics     []string // topics paused on the client
+	InFlightHandlers int64
+	MaxMessageAges   map[string]time.Duration // age of the oldest record processed per topic within the last minutes
 }
 
 func NewConsumerRouter(options *ConsumerRouterOptions) (*ConsumerRouter, error) {
@@ -65,24 +121,71 @@ func NewConsumerRouter(options *ConsumerRouterOptions) (*ConsumerRouter, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create timeout estimator: %w", err)
 	}
+	if err := claimClient(options.Client); err != nil {
+		return nil, err
+	}
 
-	return &ConsumerRouter{
+	router := &ConsumerRouter{
 		kafkaClient:             options.Client,
 		topicHandlers:           make(map[string]ConsumerHandler),
 		handlerConcurrencySem:   semaphore.NewWeighted(options.HandlerConcurrency),
 		handlerTimeoutEstimator: timeoutEstimator,
-		pollFetchesStopped:      make(chan struct{}),
-		logger:                  options.Logger,
-	}, nil
+		backpressurePause:       options.BackpressurePause,
+		revokeTimeout:           options.RevokeTimeout,
+		maxPollRecords:          options.MaxPollRecords,
+		messageAges: messageAges{
+			threshold: options.MessageAgeThreshold,
+			now:       time.Now,
+			topics:    make(map[string]*topicMessageAge),
+		},
+		panics: panicTracker{
+			policy:     options.PanicPolicy,
+			retries:    options.PanicRetries,
+			deadLetter: options.DeadLetter,
+			attempts:   make(map[topicPartition]panicAttempts),
+		},
+		partitions: partitionHandlers{
+			inFlight: make(map[topicPartition]chan struct{}),
+			revoked:  make(map[chan struct{}]struct{}),
+		},
+		resumes: resumeTimers{
+			timers: make(map[topicPartition]*time.Timer),
+		},
+		pollRecords: func(ctx context.Context, maxPollRecords int) kgo.Fetches {
+			return options.Client.Driver.PollRecords(ctx, maxPollRecords)
+		},
+		offsetsForTimestamp: options.Client.OffsetsForTimestamp,
+		setOffsets: func(offsets map[string]map[int32]kgo.EpochOffset) {
+			options.Client.Driver.SetOffsets(offsets)
+		},
+		pollFetchesStopped: make(chan struct{}),
+		logger:             options.Logger,
+	}
+	options.Client.OnPartitionsRevoked(router.onPartitionsRevoked)
+	return router, nil
 }
 
+// OnRecordsFrom routes the records of the topic to the handler, replacing the handler previously registered for it.
+// It's safe to call concurrently, before or after Start. Records of a topic registered after Start are routed
+// from the first poll following the registration, while the topic stays paused when the router is paused.
 func (r *ConsumerRouter) OnRecordsFrom(topic string, handler ConsumerHandler) {
+	r.topicHandlersMu.Lock()
+	defer r.topicHandlersMu.Unlock()
+
+	_, replaced := r.topicHandlers[topic]
 	r.topicHandlers[topic] = handler
+	if replaced {
+		return
+	}
+
 	r.kafkaClient.Driver.AddConsumeTopics(topic)
+	if r.paused.Load() {
+		r.kafkaClient.Driver.PauseFetchTopics(topic)
+	}
 }
 
 func (r *ConsumerRouter) Start() error {
-	if len(r.topicHandlers) == 0 {
+	if len(r.topics()) == 0 {
 		return ErrNoTopicHandler
 	}
 
@@ -95,6 +198,75 @@ func (r *ConsumerRouter) Start() error {
 func (r *ConsumerRouter) Stop() {
 	r.stopPollFetches()
 	<-r.pollFetchesStopped
+	r.resumes.cancelAll()
+	releaseClient(r.kafkaClient)
+}
+
+// Pause stops fetching from all subscribed topics without closing the client, i.e. while a downstream
+// dependency is unavailable. In-flight handlers are left to complete and records already buffered by the client
+// are re-fetched after Resume, so nothing is lost. Calling it while already paused is a no-op.
+func (r *ConsumerRouter) Pause() {
+	if !r.paused.CompareAndSwap(false, true) {
+		return
+	}
+
+	r.kafkaClient.Driver.PauseFetchTopics(r.topics()...)
+	r.logger.Info().Msg("Consumption of all topics paused.")
+}
+
+// Resume undoes Pause. Partitions paused individually (handler timeout, backpressure) stay paused until
+// their own resume. Calling it while not paused is a no-op.
+func (r *ConsumerRouter) Resume() {
+	if !r.paused.CompareAndSwap(true, false) {
+		return
+	}
+
+	r.kafkaClient.Driver.ResumeFetchTopics(r.topics()...)
+	r.logger.Info().Msg("Consumption of all topics resumed.")
+}
+
+func (r *ConsumerRouter) Stats() Stats {
+	return Stats{
+		Paused:           r.paused.Load(),
+		PausedTopics:     r.kafkaClient.Driver.PauseFetchTopics(),
+		InFlightHandlers: r.inFlightHandlers.Load(),
+		MaxMessageAges:   r.messageAges.snapshot(),
+	}
+}
+
+// AssignedPartitions returns the partitions of the topic currently assigned to the router, i.e. for handlers
+// keeping per-partition state outside Kafka.
+func (r *ConsumerRouter) AssignedPartitions(topic string) []int32 {
+	return r.kafkaClient.AssignedPartitions(topic)
+}
+
+// OwnsPartition reports whether the partition of the topic is currently assigned to the router.
+func (r *ConsumerRouter) OwnsPartition(topic string, partition int32) bool {
+	return r.kafkaClient.OwnsPartition(topic, partition)
+}
+
+// EstimatorSnapshot returns a copy of the state of the estimator computing handler timeouts.
+func (r *ConsumerRouter) EstimatorSnapshot() EstimatorSnapshot {
+	return r.handlerTimeoutEstimator.Snapshot()
+}
+
+func (r *ConsumerRouter) handler(topic string) (ConsumerHandler, bool) {
+	r.topicHandlersMu.RLock()
+	defer r.topicHandlersMu.RUnlock()
+
+	handler, found := r.topicHandlers[topic]
+	return handler, found
+}
+
+func (r *ConsumerRouter) topics() []string {
+	r.topicHandlersMu.RLock()
+	defer r.topicHandlersMu.RUnlock()
+
+	topics := make([]string, 0, len(r.topicHandlers))
+	for topic := range r.topicHandlers {
+		topics = append(topics, topic)
+	}
+	return topics
 }
 
 func (r *ConsumerRouter) pollFetches(ctx context.Context) {
@@ -111,7 +283,7 @@ func (r *ConsumerRouter) pollFetches(ctx context.Context) {
 
 	for {
 		// Fetch records
-		fetches := r.kafkaClient.Driver.PollFetches(ctx)
+		fetches := r.pollRecords(ctx, r.maxPollRecords)
 
 		// Stop condition
 		if err := fetches.Err0(); err != nil {
@@ -154,7 +326,7 @@ func (r *ConsumerRouter) pollFetches(ctx context.Context) {
 
 		var iterationWg sync.WaitGroup
 		fetches.EachTopic(func(fetchTopic kgo.FetchTopic) {
-			handler, found := r.topicHandlers[fetchTopic.Topic]
+			handler, found := r.handler(fetchTopic.Topic)
 			if !found {
 				r.logger.Warn().Msgf("There is no registered handler for topic '%s'.", fetchTopic.Topic)
 				return
@@ -184,18 +356,25 @@ func (r *ConsumerRouter) pollFetches(ctx context.Context) {
 				iterationWg.Add(1) //nolint:revive // we need the old version of wg.Add here
 				go func(topic string, partition int32, records []*kgo.Record) {
 					handlerDoneCh := make(chan struct{})
+					backpressured := false
+					r.partitions.track(topicPartition{topic: topic, partition: partition}, handlerDoneCh)
 					r.runningHandlersWg.Add(1)
-					go func() {
+					util.Go(r.logger, "router.handler."+topic, func() {
 						defer close(handlerDoneCh)
 						defer r.runningHandlersWg.Done()
 						defer r.handlerConcurrencySem.Release(1)
 
+						r.inFlightHandlers.Add(1)
+						defer r.inFlightHandlers.Add(-1)
+
+						r.messageAges.observe(topic, records, r.logger)
+
 						start := time.Now()
-						handler(records)
+						err := r.invokeHandler(topic, partition, handler, records)
 						r.handlerTimeoutEstimator.AddSample(time.Since(start))
 
-						r.kafkaClient.Driver.MarkCommitRecords(records...)
-					}()
+						backpressured = r.completeHandler(topic, partition, handlerDoneCh, records, err)
+					})
 
 					select {
 					case <-handlerDoneCh:
@@ -213,6 +392,9 @@ func (r *ConsumerRouter) pollFetches(ctx context.Context) {
 
 						<-handlerDoneCh
 
+						if backpressured {
+							return // resume is scheduled by backpressure handling
+						}
 						r.logger.Info().Msgf("Resuming partition %s-%d.", topic, partition)
 						r.kafkaClient.Driver.ResumeFetchPartitions(partitionToPause)
 					}
@@ -225,6 +407,106 @@ func (r *ConsumerRouter) pollFetches(ctx context.Context) {
 	}
 }
 
+// markProcessedRecords marks for commit the records the handler is done with and reports whether
+// the handler applied backpressure on the partition.
+func (r *ConsumerRouter) markProcessedRecords(topic string, partition int32, records []*kgo.Record, err error) bool {
+	var backpressure *BackpressureError
+	if !errors.As(err, &backpressure) || len(backpressure.Unprocessed) == 0 {
+		if err != nil {
+			r.logger.Error().Err(err).Msgf("Handler failed for topic-partition %s-%d.", topic, partition)
+		}
+		r.kafkaClient.Driver.MarkCommitRecords(records...)
+		return false
+	}
+
+	firstUnprocessed := backpressure.Unprocessed[0]
+	processed := make([]*kgo.Record, 0, len(records))
+	for _, record := range records {
+		if record.Offset < firstUnprocessed.Offset {
+			processed = append(processed, record)
+		}
+	}
+	r.kafkaClient.Driver.MarkCommitRecords(processed...)
+
+	r.applyBackpressure(topic, partition, firstUnprocessed)
+	r.logger.Warn().Err(backpressure.Cause).Msgf(
+		"Handler applied backpressure on topic-partition %s-%d, pausing it for %v and rewinding to offset %d.",
+		topic, partition, r.backpressurePause, firstUnprocessed.Offset,
+	)
+	return true
+}
+
+// applyBackpressure pauses the partition, rewinds it to the first unprocessed record, so it will be re-fetched,
+// and schedules its resume after the backpressure pause, replacing the resume already scheduled for it.
+func (r *ConsumerRouter) applyBackpressure(topic string, partition int32, firstUnprocessed *kgo.Record) {
+	partitionToPause := map[string][]int32{
+		topic: {partition},
+	}
+
+	r.kafkaClient.Driver.PauseFetchPartitions(partitionToPause)
+	r.kafkaClient.Driver.SetOffsets(map[string]map[int32]kgo.EpochOffset{
+		topic: {partition: {Epoch: firstUnprocessed.LeaderEpoch, Offset: firstUnprocessed.Offset}},
+	})
+
+	r.resumes.schedule(topicPartition{topic: topic, partition: partition}, r.backpressurePause, func() {
+		r.logger.Info().Msgf("Resuming partition %s-%d after backpressure pause.", topic, partition)
+		r.kafkaClient.Driver.ResumeFetchPartitions(partitionToPause)
+	})
+}
+
+// resumeTimers tracks the timers resuming the partitions paused by backpressure, so they are stopped when their
+// partition is revoked or the router stops.
+type resumeTimers struct {
+	mu     sync.Mutex
+	timers map[topicPartition]*time.Timer
+}
+
+func (t *resumeTimers) schedule(tp topicPartition, after time.Duration, resume func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pending, scheduled := t.timers[tp]; scheduled {
+		pending.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(after, func() {
+		t.mu.Lock()
+		current := t.timers[tp] == timer // a timer which fired while being replaced or cancelled doesn't resume
+		if current {
+			delete(t.timers, tp)
+		}
+		t.mu.Unlock()
+
+		if current {
+			resume()
+		}
+	})
+	t.timers[tp] = timer
+}
+
+// cancel stops the resume scheduled for the partition and reports whether there was one.
+func (t *resumeTimers) cancel(tp topicPartition) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timer, scheduled := t.timers[tp]
+	if scheduled {
+		timer.Stop()
+		delete(t.timers, tp)
+	}
+	return scheduled
+}
+
+func (t *resumeTimers) cancelAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for tp, timer := range t.timers {
+		timer.Stop()
+		delete(t.timers, tp)
+	}
+}
+
 func classifyFetchError(err error) fetchErrorSeverity {
 	var ke *kerr.Error
 	if errors.As(err, &ke) {
@@ -0,0 +1,248 @@
+package routing
+
+import (
+	"chat/src/clients/kafka/kafkatest"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestResumeTimersResumeAfterPause(t *testing.T) {
+	timers := resumeTimers{timers: make(map[topicPartition]*time.Timer)}
+	resumed := make(chan struct{})
+
+	timers.schedule(topicPartition{topic: "events", partition: 0}, time.Millisecond, func() { close(resumed) })
+
+	select {
+	case <-resumed:
+	case <-time.After(time.Second):
+		t.Fatal("partition wasn't resumed after the pause")
+	}
+	if pending := len(timers.timers); pending != 0 {
+		t.Errorf("%d timers left after resume, want none", pending)
+	}
+}
+
+func TestResumeTimersReplaceScheduledResume(t *testing.T) {
+	timers := resumeTimers{timers: make(map[topicPartition]*time.Timer)}
+	tp := topicPartition{topic: "events", partition: 0}
+	var first, second atomic.Int32
+	resumed := make(chan struct{})
+
+	timers.schedule(tp, 20*time.Millisecond, func() { first.Add(1) })
+	timers.schedule(tp, 40*time.Millisecond, func() { second.Add(1); close(resumed) })
+
+	<-resumed
+	time.Sleep(30 * time.Millisecond)
+	if first.Load() != 0 || second.Load() != 1 {
+		t.Errorf("resumes = (%d, %d), want only the replacing one", first.Load(), second.Load())
+	}
+}
+
+func TestResumeTimersCancelOnRevoke(t *testing.T) {
+	timers := resumeTimers{timers: make(map[topicPartition]*time.Timer)}
+	revoked := topicPartition{topic: "events", partition: 0}
+	kept := topicPartition{topic: "events", partition: 1}
+	var resumes atomic.Int32
+
+	timers.schedule(revoked, 20*time.Millisecond, func() { resumes.Add(1) })
+	timers.schedule(kept, 20*time.Millisecond, func() { resumes.Add(10) })
+
+	if !timers.cancel(revoked) {
+		t.Error("cancel() = false, want the scheduled resume to be reported")
+	}
+	if timers.cancel(revoked) {
+		t.Error("second cancel() = true, want nothing left to cancel")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got := resumes.Load(); got != 10 {
+		t.Errorf("resumes = %d, want only the partition which wasn't revoked", got)
+	}
+}
+
+func TestResumeTimersCancelAllOnStop(t *testing.T) {
+	timers := resumeTimers{timers: make(map[topicPartition]*time.Timer)}
+	var resumes atomic.Int32
+
+	for partition := range int32(3) {
+		timers.schedule(topicPartition{topic: "events", partition: partition}, 20*time.Millisecond, func() { resumes.Add(1) })
+	}
+	timers.cancelAll()
+
+	time.Sleep(60 * time.Millisecond)
+	if got := resumes.Load(); got != 0 || len(timers.timers) != 0 {
+		t.Errorf("resumes = %d with %d timers left after stop, want none", got, len(timers.timers))
+	}
+}
+
+func TestPausedRouterProcessesNoRecordsUntilResumed(t *testing.T) {
+	router, broker := newTestRouter(t, nil)
+	handler, received := forwardingHandler()
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	router.Pause()
+	stats := router.Stats()
+	if !stats.Paused || !slices.Equal(stats.PausedTopics, []string{"events"}) {
+		t.Fatalf("Stats() = paused %t with topics %v, want the topic paused", stats.Paused, stats.PausedTopics)
+	}
+
+	broker.produce("events", 0, "a", "b")
+	broker.produce("events", 0, "c")
+	expectNoRecords(t, received, 50*time.Millisecond)
+
+	router.Resume()
+	if stats := router.Stats(); stats.Paused || len(stats.PausedTopics) != 0 {
+		t.Fatalf("Stats() = paused %t with topics %v, want nothing paused", stats.Paused, stats.PausedTopics)
+	}
+	receiveRecords(t, received, "a", "b", "c")
+	expectNoRecords(t, received, 20*time.Millisecond)
+}
+
+func TestPauseAndResumeAreIdempotent(t *testing.T) {
+	router, broker := newTestRouter(t, nil)
+	handler, received := forwardingHandler()
+	router.OnRecordsFrom("events", handler)
+	startTestRouter(t, router)
+
+	router.Pause()
+	router.Pause()
+	broker.produce("events", 0, "a")
+	expectNoRecords(t, received, 30*time.Millisecond)
+
+	router.Resume()
+	router.Resume()
+	if router.Stats().Paused {
+		t.Fatal("Stats().Paused = true after resume, want false")
+	}
+	receiveRecords(t, received, "a")
+}
+
+func TestTopicRegisteredWhilePausedStaysPaused(t *testing.T) {
+	router, broker := newTestRouter(t, nil)
+	handler, received := forwardingHandler()
+	router.OnRecordsFrom("events", handler)
+	router.Pause()
+	router.OnRecordsFrom("audit", handler)
+	startTestRouter(t, router)
+
+	broker.produce("audit", 0, "a")
+	expectNoRecords(t, received, 30*time.Millisecond)
+
+	router.Resume()
+	receiveRecords(t, received, "a")
+}
+
+func TestPauseLetsInFlightHandlerComplete(t *testing.T) {
+	router, broker := newTestRouter(t, nil)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router.OnRecordsFrom("events", func(records []*kgo.Record) error {
+		close(started)
+		<-release
+		return nil
+	})
+	startTestRouter(t, router)
+
+	produced := broker.produce("events", 0, "a")
+	<-started
+	router.Pause()
+	if inFlight := router.Stats().InFlightHandlers; inFlight != 1 {
+		t.Fatalf("Stats().InFlightHandlers = %d, want the handler to be in flight while paused", inFlight)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for router.Stats().InFlightHandlers != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if inFlight := router.Stats().InFlightHandlers; inFlight != 0 {
+		t.Fatalf("Stats().InFlightHandlers = %d, want the handler to complete while paused", inFlight)
+	}
+	offset, marked := router.kafkaClient.Driver.MarkedOffsets()["events"][0]
+	if !marked || offset.Offset != produced[0].Offset+1 {
+		t.Fatalf("MarkedOffsets() = %d (marked %t), want the record of the handler to be marked", offset.Offset, marked)
+	}
+}
+
+func TestHandlersAreHandedAtMostMaxPollRecordsPerPoll(t *testing.T) {
+	const maxPollRecords = 3
+	router, broker := newTestRouter(t, func(options *ConsumerRouterOptions) {
+		options.MaxPollRecords = maxPollRecords
+	})
+	var polledSizes []int
+	var polledMu sync.Mutex
+	router.pollRecords = func(ctx context.Context, max int) kgo.Fetches {
+		if max != maxPollRecords {
+			t.Errorf("polled %d records at most, want MaxPollRecords %d", max, maxPollRecords)
+		}
+		fetches := broker.poll(ctx, max)
+		polledMu.Lock()
+		polledSizes = append(polledSizes, fetches.NumRecords())
+		polledMu.Unlock()
+		return fetches
+	}
+
+	var batchSizes []int
+	var batchesMu sync.Mutex
+	handler, received := forwardingHandler()
+	router.OnRecordsFrom("events", func(records []*kgo.Record) error {
+		batchesMu.Lock()
+		batchSizes = append(batchSizes, len(records))
+		batchesMu.Unlock()
+		return handler(records)
+	})
+	broker.produce("events", 0, "a", "b", "c", "d", "e", "f", "g")
+	broker.produce("events", 1, "h", "i")
+	startTestRouter(t, router)
+
+	var got []string
+	for len(got) < 9 {
+		select {
+		case records := <-received:
+			got = append(got, recordValues(records)...)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received records %v, want all 9 of them", got)
+		}
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}) {
+		t.Fatalf("received records %v, want each record once", got)
+	}
+
+	polledMu.Lock()
+	defer polledMu.Unlock()
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+	for _, size := range polledSizes {
+		if size > maxPollRecords {
+			t.Fatalf("polls returned %v records, want at most %d per poll", polledSizes, maxPollRecords)
+		}
+	}
+	for _, size := range batchSizes {
+		if size > maxPollRecords {
+			t.Fatalf("handlers were handed batches of %v records, want at most %d per batch", batchSizes, maxPollRecords)
+		}
+	}
+}
+
+func TestMaxPollRecordsIsDefaultedAndValidated(t *testing.T) {
+	router, _ := newTestRouter(t, nil)
+	if router.maxPollRecords != 500 {
+		t.Fatalf("maxPollRecords = %d, want the default 500", router.maxPollRecords)
+	}
+
+	logger := zerolog.Nop()
+	_, err := NewConsumerRouter(&ConsumerRouterOptions{Client: kafkatest.NewClient(t, nil), MaxPollRecords: 100_001, Logger: &logger})
+	if err == nil || !strings.Contains(err.Error(), "MaxPollRecords") {
+		t.Fatalf("NewConsumerRouter() error = %v, want MaxPollRecords to be out of range", err)
+	}
+}
@@ -17,6 +17,7 @@ type timeoutEstimator struct {
 	mtx sync.Mutex
 
 	durationsHead           int
+	durationsCount          int
 	durationsCircularBuffer []time.Duration
 
 	smoothingFactor     float64
@@ -26,6 +27,16 @@ type timeoutEstimator struct {
 	maxTimeout time.Duration
 }
 
+// EstimatorSnapshot is a copy of the handler timeout estimator state.
+type EstimatorSnapshot struct {
+	Capacity        int           `json:"capacity"`
+	Samples         int           `json:"samples"` // number of filled buffer slots, equals capacity once wrapped around
+	SmoothedTimeout time.Duration `json:"smoothed_timeout"`
+	MinTimeout      time.Duration `json:"min_timeout"`
+	MaxTimeout      time.Duration `json:"max_timeout"`
+	P90             time.Duration `json:"p90"` // raw percentile of the buffer, before clamping and smoothing
+}
+
 type timeoutEstimatorOptions struct {
 	Capacity        int           `validate:"required,min=100,max=1000" default:"500"`
 	SmoothingFactor float64       `validate:"required,gt=0,lt=1" default:"0.7"`
@@ -60,6 +71,7 @@ func (t *timeoutEstimator) AddSample(duration time.Duration) {
 
 	t.durationsCircularBuffer[t.durationsHead] = duration
 	t.durationsHead = (t.durationsHead + 1) % cap(t.durationsCircularBuffer)
+	t.durationsCount = min(t.durationsCount+1, len(t.durationsCircularBuffer))
 }
 
 func (t *timeoutEstimator) EstimateTimeout(percentile percentile) time.Duration {
@@ -87,6 +99,25 @@ func (t *timeoutEstimator) EstimateTimeout(percentile percentile) time.Duration
 	return smoothed
 }
 
+// Snapshot returns the estimator state without advancing the smoothed timeout.
+func (t *timeoutEstimator) Snapshot() EstimatorSnapshot {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	durations := make([]time.Duration, len(t.durationsCircularBuffer))
+	copy(durations, t.durationsCircularBuffer)
+	slices.Sort(durations)
+
+	return EstimatorSnapshot{
+		Capacity:        len(t.durationsCircularBuffer),
+		Samples:         t.durationsCount,
+		SmoothedTimeout: t.smoothedPrevTimeout,
+		MinTimeout:      t.minTimeout,
+		MaxTimeout:      t.maxTimeout,
+		P90:             durations[t.percentileIndex(percentile(90))],
+	}
+}
+
 func (t *timeoutEstimator) percentileIndex(percentile percentile) int {
 	if percentile <= 0 {
 		return 0
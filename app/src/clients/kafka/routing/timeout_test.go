@@ -0,0 +1,72 @@
+package routing
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestEstimator(t *testing.T) *timeoutEstimator {
+	t.Helper()
+
+	estimator, err := newTimeoutEstimator(&timeoutEstimatorOptions{
+		Capacity:   100,
+		MinTimeout: 100 * time.Millisecond,
+		MaxTimeout: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("newTimeoutEstimator() error = %v", err)
+	}
+	return estimator
+}
+
+func TestEstimatorSnapshotReflectsAddedSamples(t *testing.T) {
+	estimator := newTestEstimator(t)
+
+	initial := estimator.Snapshot()
+	want := EstimatorSnapshot{
+		Capacity:        100,
+		SmoothedTimeout: 1050 * time.Millisecond, // halfway between min and max
+		MinTimeout:      100 * time.Millisecond,
+		MaxTimeout:      2 * time.Second,
+	}
+	if initial != want {
+		t.Fatalf("Snapshot() of empty estimator = %+v, want %+v", initial, want)
+	}
+
+	for range 100 {
+		estimator.AddSample(time.Second)
+	}
+	snapshot := estimator.Snapshot()
+	if snapshot.Samples != 100 || snapshot.P90 != time.Second {
+		t.Fatalf("Snapshot() = %+v, want 100 samples with a p90 of 1s", snapshot)
+	}
+	if snapshot.SmoothedTimeout != initial.SmoothedTimeout {
+		t.Errorf("Snapshot() advanced the smoothed timeout to %v", snapshot.SmoothedTimeout)
+	}
+
+	// the buffer wraps around while the number of samples is bounded by its capacity
+	for range 50 {
+		estimator.AddSample(3 * time.Second)
+	}
+	if snapshot := estimator.Snapshot(); snapshot.Samples != 100 || snapshot.P90 != 3*time.Second {
+		t.Fatalf("Snapshot() after wrap around = %+v, want 100 samples with a p90 of 3s", snapshot)
+	}
+}
+
+func TestEstimatorSnapshotFollowsEstimatedTimeout(t *testing.T) {
+	estimator := newTestEstimator(t)
+	for range 100 {
+		estimator.AddSample(3 * time.Second)
+	}
+
+	estimated := estimator.EstimateTimeout(percentile(90))
+	before := estimator.Snapshot()
+	if before.SmoothedTimeout != estimated {
+		t.Fatalf("Snapshot().SmoothedTimeout = %v, want the last estimated timeout %v", before.SmoothedTimeout, estimated)
+	}
+
+	estimator.AddSample(time.Millisecond)
+	if before.Samples != 100 || before.P90 != 3*time.Second {
+		t.Fatalf("snapshot changed after a sample was added: %+v", before)
+	}
+}
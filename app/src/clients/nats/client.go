@@ -5,7 +5,12 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
@@ -18,24 +23,65 @@ type clientConfig struct {
 	options []nats.Option
 }
 
+const (
+	reconnectStormWindow    = 1 * time.Minute
+	reconnectStormThreshold = 3
+)
+
+// connectionEvents tracks connection level events, so that health pings can spot reconnect storms
+// which look healthy when sampled at a single point in time.
+type connectionEvents struct {
+	disconnects      atomic.Uint64
+	reconnects       atomic.Uint64
+	mutex            sync.Mutex
+	recentReconnects []time.Time
+	reconnectHooks   []reconnectHook
+	nextHookID       uint64
+}
+
+type reconnectHook struct {
+	id  uint64
+	run func()
+}
+
 type Client struct {
 	logger zerolog.Logger
 	config *clientConfig
+	events *connectionEvents
 	Driver *nats.Conn
 }
 
 type ClientOptions struct {
-	Servers    []string
-	TLSConfig  *tls.Config
-	ClientName string
-	Username   string
-	Password   string
-	Logger     zerolog.Logger
+	Servers           []string
+	TLSConfig         *tls.Config
+	ClientName        string
+	Username          string
+	Password          string
+	AddressTranslator AddressTranslator // optional, rewrites server addresses before dialing
+	Logger            zerolog.Logger
+}
+
+// AddressTranslator rewrites a "host:port" server address, i.e. when servers advertise cluster addresses
+// which are not resolvable from where the client runs.
+type AddressTranslator interface {
+	TranslateHostPort(address string) string
+}
+
+// translatingDialer dials the translated address, TLS is still negotiated by the driver against the original hostname.
+type translatingDialer struct {
+	dialer     net.Dialer
+	translator AddressTranslator
+}
+
+func (d *translatingDialer) Dial(network, address string) (net.Conn, error) {
+	return d.dialer.Dial(network, d.translator.TranslateHostPort(address)) //nolint:wrapcheck // driver wraps it
 }
 
 func NewClient(options *ClientOptions) *Client {
-	return &Client{
+	events := &connectionEvents{}
+	client := &Client{
 		logger: options.Logger,
+		events: events,
 		config: &clientConfig{
 			servers: strings.Join(options.Servers, ", "),
 			options: []nats.Option{
@@ -43,12 +89,18 @@ func NewClient(options *ClientOptions) *Client {
 				nats.Secure(options.TLSConfig),
 				nats.UserInfo(options.Username, options.Password),
 				nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+					events.disconnects.Add(1)
 					if err != nil {
 						options.Logger.Err(err).Msgf("Connection disconnected with error from NATS server: %s", conn.ConnectedUrlRedacted())
 					}
 				}),
 				nats.ReconnectHandler(func(conn *nats.Conn) {
-					options.Logger.Info().Msgf("Successfully reconnected to NATS server: %s", conn.ConnectedUrlRedacted())
+					events.recordReconnect(time.Now())
+					events.runReconnectHooks()
+					// Core NATS subscriptions are replayed by the driver on reconnect, no need to re-subscribe.
+					options.Logger.Info().Msgf("Successfully reconnected to NATS server: %s (re-established %d subscriptions)",
+						conn.ConnectedUrlRedacted(), conn.NumSubscriptions(),
+					)
 				}),
 				nats.ReconnectErrHandler(func(conn *nats.Conn, err error) {
 					options.Logger.Err(err).Msgf("Reconnect failed to NATS server: %s", conn.ConnectedUrlRedacted())
@@ -58,6 +110,13 @@ func NewClient(options *ClientOptions) *Client {
 						conn.ConnectedUrlRedacted(), sub.Subject,
 					)
 				}),
+				nats.ClosedHandler(func(conn *nats.Conn) {
+					if err := conn.LastError(); err != nil {
+						options.Logger.Err(err).Msg("Connection to NATS servers closed")
+						return
+					}
+					options.Logger.Info().Msg("Connection to NATS servers closed")
+				}),
 				nats.LameDuckModeHandler(func(conn *nats.Conn) {
 					options.Logger.Warn().Msgf("NATS server is in lame duck mode: %s", conn.ConnectedUrlRedacted())
 				}),
@@ -68,6 +127,13 @@ func NewClient(options *ClientOptions) *Client {
 		},
 		Driver: nil,
 	}
+	if options.AddressTranslator != nil {
+		client.config.options = append(client.config.options, nats.SetCustomDialer(&translatingDialer{
+			dialer:     net.Dialer{Timeout: nats.DefaultTimeout},
+			translator: options.AddressTranslator,
+		}))
+	}
+	return client
 }
 
 func (c *Client) Start(_ context.Context) error {
@@ -93,3 +159,68 @@ func (c *Client) Stop(_ context.Context) {
 	c.Driver.Close()
 	c.Driver = nil
 }
+
+// Reconnects returns the total number of reconnects since client creation.
+func (c *Client) Reconnects() uint64 {
+	return c.events.reconnects.Load()
+}
+
+// OnReconnect registers a hook called after each successful reconnect, i.e. to repair state built from messages
+// which were missed while disconnected. Hooks run on the callback goroutine of the driver and must not block. The
+// returned func unregisters the hook, i.e. on Stop of the service which registered it.
+func (c *Client) OnReconnect(hook func()) (unregister func()) {
+	c.events.mutex.Lock()
+	id := c.events.nextHookID
+	c.events.nextHookID++
+	c.events.reconnectHooks = append(c.events.reconnectHooks, reconnectHook{id: id, run: hook})
+	c.events.mutex.Unlock()
+
+	return func() {
+		c.events.mutex.Lock()
+		c.events.reconnectHooks = slices.DeleteFunc(c.events.reconnectHooks, func(hook reconnectHook) bool {
+			return hook.id == id
+		})
+		c.events.mutex.Unlock()
+	}
+}
+
+// Disconnects returns the total number of disconnects since client creation.
+func (c *Client) Disconnects() uint64 {
+	return c.events.disconnects.Load()
+}
+
+func (e *connectionEvents) recordReconnect(at time.Time) {
+	e.reconnects.Add(1)
+
+	e.mutex.Lock()
+	e.recentReconnects = append(e.pruneRecentReconnects(at), at)
+	e.mutex.Unlock()
+}
+
+func (e *connectionEvents) runReconnectHooks() {
+	e.mutex.Lock()
+	hooks := slices.Clone(e.reconnectHooks)
+	e.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook.run()
+	}
+}
+
+func (e *connectionEvents) reconnectsWithinWindow(now time.Time) int {
+	e.mutex.Lock()
+	e.recentReconnects = e.pruneRecentReconnects(now)
+	count := len(e.recentReconnects)
+	e.mutex.Unlock()
+	return count
+}
+
+// pruneRecentReconnects must be called with mutex held.
+func (e *connectionEvents) pruneRecentReconnects(now time.Time) []time.Time {
+	cutoff := now.Add(-reconnectStormWindow)
+	idx := 0
+	for idx < len(e.recentReconnects) && e.recentReconnects[idx].Before(cutoff) {
+		idx++
+	}
+	return e.recentReconnects[idx:]
+}
@@ -0,0 +1,213 @@
+package nats
+
+import (
+	"chat/src/platform/health"
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startTestClient(t *testing.T, client *Client) {
+	t.Helper()
+
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { client.Stop(context.Background()) })
+}
+
+// disconnect drops the connections of the server and waits for the client to reconnect.
+func disconnect(t *testing.T, server *fakeServer, reconnected <-chan struct{}) {
+	t.Helper()
+
+	server.dropConnections()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't reconnect")
+	}
+}
+
+func TestConnectionEventsAreCountedOnSimulatedDisconnect(t *testing.T) {
+	server := newFakeServer(t)
+	client := newTestClient(t, server)
+	reconnected := make(chan struct{}, 8)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+	startTestClient(t, client)
+
+	if client.Disconnects() != 0 || client.Reconnects() != 0 {
+		t.Fatalf("expected no events before the disconnect, got %d disconnects and %d reconnects",
+			client.Disconnects(), client.Reconnects())
+	}
+	disconnect(t, server, reconnected)
+
+	if client.Disconnects() != 1 || client.Reconnects() != 1 {
+		t.Fatalf("expected 1 disconnect and 1 reconnect, got %d and %d", client.Disconnects(), client.Reconnects())
+	}
+	if server.accepted() != 2 {
+		t.Fatalf("expected the client to open a second connection, the server accepted %d", server.accepted())
+	}
+}
+
+func TestSubscriptionsAreReestablishedAfterReconnect(t *testing.T) {
+	server := newFakeServer(t)
+	client := newTestClient(t, server)
+	reconnected := make(chan struct{}, 8)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+	startTestClient(t, client)
+
+	if _, err := client.Driver.SubscribeSync("user.presence.updates"); err != nil {
+		t.Fatalf("SubscribeSync() error = %v", err)
+	}
+	if err := client.Driver.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	disconnect(t, server, reconnected)
+	if err := client.Driver.Flush(); err != nil {
+		t.Fatalf("Flush() after reconnect error = %v", err)
+	}
+
+	if subjects := server.subscriptionsOf(1); !slices.Contains(subjects, "user.presence.updates") {
+		t.Fatalf("expected the subscription to be replayed on the new connection, it subscribed to %v", subjects)
+	}
+}
+
+func TestReconnectHooksRunOnEachReconnect(t *testing.T) {
+	server := newFakeServer(t)
+	client := newTestClient(t, server)
+	reconnected := make(chan struct{}, 8)
+	var mutex sync.Mutex
+	var calls []string
+	client.OnReconnect(func() {
+		mutex.Lock()
+		calls = append(calls, "first")
+		mutex.Unlock()
+	})
+	client.OnReconnect(func() {
+		mutex.Lock()
+		calls = append(calls, "second")
+		mutex.Unlock()
+		reconnected <- struct{}{}
+	})
+	startTestClient(t, client)
+
+	disconnect(t, server, reconnected)
+	disconnect(t, server, reconnected)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if want := []string{"first", "second", "first", "second"}; !slices.Equal(calls, want) {
+		t.Fatalf("reconnect hooks called %v, want %v", calls, want)
+	}
+}
+
+func TestUnregisteredReconnectHooksAreNotCalled(t *testing.T) {
+	server := newFakeServer(t)
+	client := newTestClient(t, server)
+	reconnected := make(chan struct{}, 8)
+	var mutex sync.Mutex
+	var calls []string
+	record := func(name string) func() {
+		return func() {
+			mutex.Lock()
+			calls = append(calls, name)
+			mutex.Unlock()
+		}
+	}
+	unregisterFirst := client.OnReconnect(record("first"))
+	unregisterSecond := client.OnReconnect(record("second"))
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+	startTestClient(t, client)
+
+	disconnect(t, server, reconnected)
+	unregisterFirst()
+	disconnect(t, server, reconnected)
+	unregisterSecond()
+	unregisterSecond() // unregistering twice is harmless
+	disconnect(t, server, reconnected)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if want := []string{"first", "second", "second"}; !slices.Equal(calls, want) {
+		t.Fatalf("reconnect hooks called %v, want %v", calls, want)
+	}
+}
+
+func TestPingDeepReportsReconnectStormAsUnstable(t *testing.T) {
+	server := newFakeServer(t)
+	client := newTestClient(t, server)
+	reconnected := make(chan struct{}, 8)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+	startTestClient(t, client)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for range reconnectStormThreshold - 1 {
+		disconnect(t, server, reconnected)
+	}
+	if result := client.PingDeep(ctx); result.Cause != health.PingCauseOk {
+		t.Fatalf("expected %d reconnects to be tolerated, ping reported %s: %s",
+			reconnectStormThreshold-1, result.Cause, result.Details)
+	}
+
+	disconnect(t, server, reconnected)
+	if result := client.PingDeep(ctx); result.Cause != health.PingCauseUnstable {
+		t.Fatalf("expected a reconnect storm to be reported as unstable, ping reported %s: %s", result.Cause, result.Details)
+	}
+}
+
+func TestReconnectsOutsideWindowAreForgotten(t *testing.T) {
+	events := &connectionEvents{}
+	now := time.Now()
+	for idx := range reconnectStormThreshold {
+		events.recordReconnect(now.Add(-reconnectStormWindow - time.Duration(idx+1)*time.Second))
+	}
+	events.recordReconnect(now)
+
+	if count := events.reconnectsWithinWindow(now); count != 1 {
+		t.Fatalf("expected only the recent reconnect to be within the window, got %d", count)
+	}
+	if events.reconnects.Load() != uint64(reconnectStormThreshold+1) {
+		t.Fatalf("expected the total to keep all reconnects, got %d", events.reconnects.Load())
+	}
+}
+
+// fakeTranslator translates the addresses it maps, and records the ones it was asked to translate.
+type fakeTranslator struct {
+	mutex      sync.Mutex
+	mapping    map[string]string
+	translated []string
+}
+
+func (f *fakeTranslator) TranslateHostPort(address string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.translated = append(f.translated, address)
+	if translation, ok := f.mapping[address]; ok {
+		return translation
+	}
+	return address
+}
+
+func TestClientDialsTranslatedServerAddress(t *testing.T) {
+	server := newFakeServer(t)
+	advertised := net.JoinHostPort(fakeServerHost, "4222")
+	translator := &fakeTranslator{mapping: map[string]string{advertised: server.listener.Addr().String()}}
+	client := newTestClient(t, server, func(options *ClientOptions) {
+		options.Servers = []string{"nats://" + advertised}
+		options.AddressTranslator = translator
+	})
+	startTestClient(t, client)
+
+	if server.accepted() != 1 {
+		t.Fatalf("expected the client to connect to the translated address, the server accepted %d connections", server.accepted())
+	}
+	translator.mutex.Lock()
+	defer translator.mutex.Unlock()
+	if !slices.Contains(translator.translated, advertised) {
+		t.Fatalf("expected '%s' to be translated, translated addresses: %v", advertised, translator.translated)
+	}
+}
@@ -0,0 +1,163 @@
+package nats
+
+import (
+	"bufio"
+	"chat/src/platform/security/securitytest"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+// fakeServerHost is the hostname of the server to be translated to its listening address, it isn't resolvable.
+const fakeServerHost = "nats.test"
+
+// fakeServer speaks the subset of the NATS client protocol the client relies on: INFO, CONNECT, PING/PONG, SUB and
+// PUB. Connections are upgraded to TLS, as the client always requires it. The certificate of the server covers
+// 127.0.0.1 and fakeServerHost.
+type fakeServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	clientTLS *tls.Config // trusts the certificate of the server
+
+	mutex         sync.Mutex
+	conns         []net.Conn
+	subscriptions [][]string // subjects subscribed on each accepted connection, in order of acceptance
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+
+	serverTLS, clientTLS := securitytest.NewTLSConfigs(t, "127.0.0.1", fakeServerHost)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &fakeServer{
+		listener:  listener,
+		tlsConfig: serverTLS,
+		clientTLS: clientTLS,
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		server.dropConnections()
+	})
+
+	go server.accept()
+	return server
+}
+
+func (s *fakeServer) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *fakeServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns = append(s.conns, conn)
+		s.subscriptions = append(s.subscriptions, nil)
+		connIdx := len(s.subscriptions) - 1
+		s.mutex.Unlock()
+
+		go s.serve(conn, connIdx)
+	}
+}
+
+func (s *fakeServer) serve(conn net.Conn, connIdx int) {
+	defer func() { _ = conn.Close() }()
+
+	info := `INFO {"server_id":"fake","server_name":"fake","version":"2.10.0","proto":1,"headers":true,` +
+		`"max_payload":1048576,"tls_required":true}` + "\r\n"
+	if _, err := io.WriteString(conn, info); err != nil {
+		return
+	}
+	tlsConn := tls.Server(conn, s.tlsConfig)
+	reader := bufio.NewReader(tlsConn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			if _, err := io.WriteString(tlsConn, "PONG\r\n"); err != nil {
+				return
+			}
+		case "SUB":
+			s.mutex.Lock()
+			s.subscriptions[connIdx] = append(s.subscriptions[connIdx], fields[1])
+			s.mutex.Unlock()
+		case "PUB", "HPUB":
+			// the payload follows the line, its size being the last field
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, reader, int64(size)+2); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// dropConnections closes the accepted connections, as a server restart would.
+func (s *fakeServer) dropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) subscriptionsOf(connIdx int) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if connIdx >= len(s.subscriptions) {
+		return nil
+	}
+	return append([]string(nil), s.subscriptions[connIdx]...)
+}
+
+func (s *fakeServer) accepted() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.subscriptions)
+}
+
+// newTestClient returns a client of the server which reconnects without waiting.
+func newTestClient(t *testing.T, server *fakeServer, configure ...func(*ClientOptions)) *Client {
+	t.Helper()
+
+	options := &ClientOptions{
+		Servers:    []string{server.url()},
+		TLSConfig:  server.clientTLS.Clone(),
+		ClientName: t.Name(),
+		Logger:     zerolog.Nop(),
+	}
+	for _, fn := range configure {
+		fn(options)
+	}
+	client := NewClient(options)
+	client.config.options = append(client.config.options, testReconnectOptions()...)
+	return client
+}
+
+func testReconnectOptions() []nats.Option {
+	return []nats.Option{nats.ReconnectWait(10 * time.Millisecond), nats.ReconnectJitter(0, 0)}
+}
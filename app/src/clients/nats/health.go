@@ -1,6 +1,7 @@
 package nats
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/health"
 	"context"
 	"fmt"
@@ -10,7 +11,7 @@ import (
 )
 
 const (
-	PingTargetName            = "nats"
+	PingTargetName            = components.Nats
 	pingDeepAcceptableLatency = 150 * time.Millisecond
 )
 
@@ -60,5 +61,13 @@ func (c *Client) PingDeep(ctx context.Context) health.PingResult {
 		return pingResult
 	}
 
+	if reconnects := c.events.reconnectsWithinWindow(time.Now()); reconnects >= reconnectStormThreshold {
+		pingResult.SetPingOutput(
+			health.PingCauseUnstable,
+			fmt.Sprintf("NATS client reconnected %d times within the last %s", reconnects, reconnectStormWindow),
+		)
+		return pingResult
+	}
+
 	return pingResult
 }
@@ -0,0 +1,33 @@
+// Package natstest provides the NATS client of the tests of the services depending on NATS.
+package natstest
+
+import (
+	"chat/src/clients/nats"
+	"os"
+	"testing"
+
+	nats2 "github.com/nats-io/nats.go"
+)
+
+// URLEnv names the environment variable holding the URL of the NATS server the tests run against, i.e.
+// "nats://localhost:4222". The server must have JetStream enabled. Tests depending on NATS are skipped when
+// it isn't set.
+const URLEnv = "CHAT_TEST_NATS_URL"
+
+// NewClient returns a client whose driver is connected to the NATS server at URLEnv, without TLS. The connection
+// is closed at the end of the test. Connection events aren't tracked, so reconnect hooks can't be registered on it.
+func NewClient(tb testing.TB) *nats.Client {
+	tb.Helper()
+
+	url := os.Getenv(URLEnv)
+	if url == "" {
+		tb.Skipf("%s is not set, skipping test depending on NATS", URLEnv)
+	}
+
+	conn, err := nats2.Connect(url, nats2.Name(tb.Name()))
+	if err != nil {
+		tb.Fatalf("failed to connect to NATS server at '%s': %v", url, err)
+	}
+	tb.Cleanup(conn.Close)
+	return &nats.Client{Driver: conn}
+}
@@ -1,6 +1,7 @@
 package neo4j
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/health"
 	"context"
 	"fmt"
@@ -10,7 +11,7 @@ import (
 )
 
 const (
-	PingTargetName               = "neo4j"
+	PingTargetName               = components.Neo4j
 	pingShallowAcceptableLatency = 50 * time.Millisecond
 )
 
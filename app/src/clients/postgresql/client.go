@@ -1,12 +1,14 @@
 package postgresql
 
 import (
+	"chat/src/platform/validation"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/creasty/defaults"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
@@ -22,9 +24,10 @@ import (
 // -- @FIXME: make sure generated code by sqlc uses pgx.CollectRows https://youtu.be/sXMSWhcHCf8?si=mSZk_pq9MIG6GGR0&t=1014
 
 type Client struct {
-	logger zerolog.Logger
-	config *pgxpool.Config
-	Driver *pgxpool.Pool
+	logger     zerolog.Logger
+	config     *pgxpool.Config
+	thresholds HealthThresholds
+	Driver     *pgxpool.Pool
 }
 
 type ClientOptions struct {
@@ -32,6 +35,7 @@ type ClientOptions struct {
 	ApplicationInstanceName string
 	PreparedStatements      *map[string]string
 	TLSConfig               *tls.Config
+	HealthThresholds        HealthThresholds
 	Logger                  zerolog.Logger
 }
 
@@ -40,6 +44,13 @@ func NewClient(options *ClientOptions) (*Client, error) {
 		In("postgresql client").
 		Tags("constructor")
 
+	if err := defaults.Set(&options.HealthThresholds); err != nil {
+		return nil, errorb.Wrapf(err, "failed to set health thresholds defaults")
+	}
+	if err := validation.Instance.Struct(&options.HealthThresholds); err != nil {
+		return nil, errorb.Wrapf(validation.Aggregate(err), "failed to validate health thresholds")
+	}
+
 	config, err := pgxpool.ParseConfig(options.URL)
 	if err != nil {
 		return nil, errorb.Wrapf(err, "failed to parse database url")
@@ -82,9 +93,10 @@ func NewClient(options *ClientOptions) (*Client, error) {
 	}
 
 	return &Client{
-		logger: options.Logger,
-		config: config,
-		Driver: nil,
+		logger:     options.Logger,
+		config:     config,
+		thresholds: options.HealthThresholds,
+		Driver:     nil,
 	}, nil
 }
 
@@ -1,6 +1,7 @@
 package postgresql
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/health"
 	"context"
 	"fmt"
@@ -8,13 +9,26 @@ import (
 )
 
 const (
-	PingTargetName                       = "postgresql"
-	pingShallowAcceptableLatency         = 50 * time.Millisecond
-	pingDeepAcceptableLatency            = 150 * time.Millisecond
-	replicationLagAcceptedSeconds        = 10.0
-	numberOfConnectionsAcceptedThreshold = 200
+	PingTargetName               = components.PostgreSQL
+	pingShallowAcceptableLatency = 50 * time.Millisecond
+	pingDeepAcceptableLatency    = 150 * time.Millisecond
 )
 
+// HealthThresholds are the values above which the deep ping reports the database as degraded.
+type HealthThresholds struct {
+	ReplicationLag time.Duration `default:"10s" validate:"min=1000000000,max=3600000000000"` // 1s to 1h
+	Connections    int           `default:"200" validate:"min=1,max=100000"`
+}
+
+// databaseStats are queried by the deep ping, replicationLag is nil on a primary.
+type databaseStats struct {
+	inRecovery     bool
+	connections    int
+	xactCommit     int64
+	xactRollback   int64
+	replicationLag *float64 // seconds
+}
+
 func (c *Client) PingShallow(ctx context.Context) health.PingResult {
 	pingResult := health.NewHealthyPingResult(PingTargetName, health.PingDepthShallow)
 
@@ -36,12 +50,7 @@ func (c *Client) PingDeep(ctx context.Context) health.PingResult {
 	pingResult := health.NewHealthyPingResult(PingTargetName, health.PingDepthDeep)
 
 	// # Run checks
-	var (
-		inRecovery     bool
-		numConnections int
-		xactCommit     int64
-		xactRollback   int64
-	)
+	var stats databaseStats
 	conn, err := c.Driver.Acquire(ctx)
 	if err != nil {
 		pingResult.SetPingOutput(
@@ -52,7 +61,7 @@ func (c *Client) PingDeep(ctx context.Context) health.PingResult {
 	}
 	defer conn.Release()
 
-	err = conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	err = conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&stats.inRecovery)
 	if err != nil {
 		pingResult.SetPingOutput(
 			health.PingCauseFromRequestError(err),
@@ -65,7 +74,7 @@ func (c *Client) PingDeep(ctx context.Context) health.PingResult {
 		SELECT numbackends, xact_commit, xact_rollback 
 		FROM pg_stat_database
 		WHERE datname = current_database()
-	`).Scan(&numConnections, &xactCommit, &xactRollback)
+	`).Scan(&stats.connections, &stats.xactCommit, &stats.xactRollback)
 	if err != nil {
 		pingResult.SetPingOutput(
 			health.PingCauseFromRequestError(err),
@@ -74,43 +83,55 @@ func (c *Client) PingDeep(ctx context.Context) health.PingResult {
 		return pingResult
 	}
 
-	var lagSeconds *float64
 	err = conn.QueryRow(ctx, `
 		SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) AS replication_lag
 		FROM pg_stat_replication
 		LIMIT 1
-	`).Scan(&lagSeconds)
+	`).Scan(&stats.replicationLag)
 	if err != nil {
 		// ignore error on primary (no replication)
-		lagSeconds = nil
+		stats.replicationLag = nil
 	}
 
 	pingResult.StoreComputedLatency(pingDeepAcceptableLatency)
+	c.evaluateDatabaseStats(&pingResult, &stats)
+	return pingResult
+}
+
+// evaluateDatabaseStats records the stats queried by the deep ping as details of the result, and degrades it when
+// they exceed the thresholds.
+func (c *Client) evaluateDatabaseStats(pingResult *health.PingResult, stats *databaseStats) {
+	pingResult.
+		WithDetail("connections", stats.connections).
+		WithDetail("in_recovery", stats.inRecovery).
+		WithDetail("xact_commit", stats.xactCommit).
+		WithDetail("xact_rollback", stats.xactRollback)
+	if stats.replicationLag != nil {
+		pingResult.WithDetail("replication_lag", *stats.replicationLag)
+	}
 
 	// # Evaluate results
-	if lagSeconds != nil && *lagSeconds > replicationLagAcceptedSeconds {
+	if stats.replicationLag != nil && *stats.replicationLag > c.thresholds.ReplicationLag.Seconds() {
 		pingResult.SetPingOutput(
 			health.PingCauseUnstable,
-			fmt.Sprintf("replication lag '%f' exceeds 10s", *lagSeconds),
+			fmt.Sprintf("replication lag '%f' exceeds %v", *stats.replicationLag, c.thresholds.ReplicationLag),
 		)
-		return pingResult
+		return
 	}
 
-	if numConnections > numberOfConnectionsAcceptedThreshold {
+	if stats.connections > c.thresholds.Connections {
 		pingResult.SetPingOutput(
 			health.PingCauseOverloaded,
-			fmt.Sprintf("too many active connections: %d", numConnections),
+			fmt.Sprintf("too many active connections: %d, threshold is %d", stats.connections, c.thresholds.Connections),
 		)
-		return pingResult
+		return
 	}
 
-	if inRecovery {
+	if stats.inRecovery {
 		pingResult.SetPingOutput(
 			health.PingCauseBadState,
 			"node is read-only (in recovery mode)",
 		)
-		return pingResult
+		return
 	}
-
-	return pingResult
 }
@@ -0,0 +1,162 @@
+package postgresql
+
+import (
+	"chat/src/platform/health"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateDatabaseStatsEmitsDetails(t *testing.T) {
+	lag := func(seconds float64) *float64 { return &seconds }
+	tests := []struct {
+		name      string
+		stats     databaseStats
+		wantCause health.PingCause
+		wantLag   any // nil when the detail must be absent
+	}{
+		{
+			name:      "replica",
+			stats:     databaseStats{inRecovery: false, connections: 12, xactCommit: 900, xactRollback: 3, replicationLag: lag(2.5)},
+			wantCause: health.PingCauseOk,
+			wantLag:   2.5,
+		},
+		{
+			name:      "primary without replication",
+			stats:     databaseStats{connections: 12, xactCommit: 900, xactRollback: 3},
+			wantCause: health.PingCauseOk,
+		},
+		{
+			name:      "lagging replica",
+			stats:     databaseStats{connections: 12, xactCommit: 900, xactRollback: 3, replicationLag: lag(42)},
+			wantCause: health.PingCauseUnstable,
+			wantLag:   42.0,
+		},
+		{
+			name:      "too many connections",
+			stats:     databaseStats{connections: 250, xactCommit: 900, xactRollback: 3},
+			wantCause: health.PingCauseOverloaded,
+		},
+		{
+			name:      "in recovery",
+			stats:     databaseStats{inRecovery: true, connections: 12, xactCommit: 900, xactRollback: 3},
+			wantCause: health.PingCauseBadState,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{thresholds: HealthThresholds{ReplicationLag: 10 * time.Second, Connections: 200}}
+			result := health.NewHealthyPingResult(PingTargetName, health.PingDepthDeep)
+
+			client.evaluateDatabaseStats(&result, &tt.stats)
+
+			if result.Cause != tt.wantCause {
+				t.Fatalf("Cause = %s, want %s: %s", result.Cause, tt.wantCause, result.Details)
+			}
+			if result.Data["connections"] != tt.stats.connections {
+				t.Errorf("connections detail = %v, want %d", result.Data["connections"], tt.stats.connections)
+			}
+			if result.Data["in_recovery"] != tt.stats.inRecovery {
+				t.Errorf("in_recovery detail = %v, want %t", result.Data["in_recovery"], tt.stats.inRecovery)
+			}
+			if result.Data["xact_commit"] != tt.stats.xactCommit || result.Data["xact_rollback"] != tt.stats.xactRollback {
+				t.Errorf("transaction details = (%v, %v), want (%d, %d)",
+					result.Data["xact_commit"], result.Data["xact_rollback"], tt.stats.xactCommit, tt.stats.xactRollback)
+			}
+			replicationLag, found := result.Data["replication_lag"]
+			if tt.wantLag == nil && found {
+				t.Errorf("replication_lag detail = %v, want none", replicationLag)
+			}
+			if tt.wantLag != nil && replicationLag != tt.wantLag {
+				t.Errorf("replication_lag detail = %v, want %v", replicationLag, tt.wantLag)
+			}
+		})
+	}
+}
+
+func TestDatabaseStatsDetailsAreSerialized(t *testing.T) {
+	client := &Client{thresholds: HealthThresholds{ReplicationLag: 10 * time.Second, Connections: 200}}
+	result := health.NewHealthyPingResult(PingTargetName, health.PingDepthDeep)
+	seconds := 1.5
+	client.evaluateDatabaseStats(&result, &databaseStats{connections: 7, replicationLag: &seconds})
+
+	var decoded struct {
+		Data map[string]any `json:"data"`
+	}
+	output := result.PrettyJSON()
+	if err := json.Unmarshal([]byte(output), &decoded); err != nil {
+		t.Fatalf("PrettyJSON() isn't valid JSON: %v\n%s", err, output)
+	}
+	if decoded.Data["replication_lag"] != 1.5 || decoded.Data["connections"] != 7.0 {
+		t.Fatalf("serialized details = %v, want replication_lag 1.5 and connections 7", decoded.Data)
+	}
+	if !strings.Contains(output, `"replication_lag": 1.5`) {
+		t.Errorf("PrettyJSON() = %s, want the replication lag to be queryable", output)
+	}
+}
+
+func TestCustomHealthThresholdsChangeClassification(t *testing.T) {
+	seconds := 30.0
+	tests := []struct {
+		name       string
+		thresholds HealthThresholds
+		stats      databaseStats
+		want       health.PingCause
+	}{
+		{
+			name:  "lag over default threshold",
+			stats: databaseStats{connections: 12, replicationLag: &seconds},
+			want:  health.PingCauseUnstable,
+		},
+		{
+			name:       "lag under raised threshold",
+			thresholds: HealthThresholds{ReplicationLag: time.Minute},
+			stats:      databaseStats{connections: 12, replicationLag: &seconds},
+			want:       health.PingCauseOk,
+		},
+		{
+			name:  "connections under default threshold",
+			stats: databaseStats{connections: 150},
+			want:  health.PingCauseOk,
+		},
+		{
+			name:       "connections over lowered threshold",
+			thresholds: HealthThresholds{Connections: 100},
+			stats:      databaseStats{connections: 150},
+			want:       health.PingCauseOverloaded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(&ClientOptions{URL: "postgres://chat@127.0.0.1:1/chat", HealthThresholds: tt.thresholds})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			result := health.NewHealthyPingResult(PingTargetName, health.PingDepthDeep)
+
+			client.evaluateDatabaseStats(&result, &tt.stats)
+			if result.Cause != tt.want {
+				t.Fatalf("Cause = %s, want %s: %s", result.Cause, tt.want, result.Details)
+			}
+		})
+	}
+}
+
+func TestNewClientRejectsInvalidHealthThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds HealthThresholds
+	}{
+		{name: "replication lag too short", thresholds: HealthThresholds{ReplicationLag: time.Millisecond}},
+		{name: "replication lag too long", thresholds: HealthThresholds{ReplicationLag: 2 * time.Hour}},
+		{name: "too many connections", thresholds: HealthThresholds{Connections: 1_000_000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClient(&ClientOptions{URL: "postgres://chat@127.0.0.1:1/chat", HealthThresholds: tt.thresholds}); err == nil {
+				t.Fatalf("NewClient() error = nil, want thresholds %+v to be rejected", tt.thresholds)
+			}
+		})
+	}
+}
@@ -12,10 +12,42 @@ import (
 
 var ErrAlreadyStarted = errors.New("redis client already started")
 
+// Driver is the subset of the cluster client commands used by the services and the health checks. Services depend
+// on it instead of *redis.ClusterClient, so they can be tested with a client created by redistest.NewClient.
+type Driver interface {
+	Ping(ctx context.Context) *redis.StatusCmd
+	Info(ctx context.Context, section ...string) *redis.StringCmd
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+	HExists(ctx context.Context, key, field string) *redis.BoolCmd
+	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
+	SAdd(ctx context.Context, key string, members ...any) *redis.IntCmd
+	LPush(ctx context.Context, key string, values ...any) *redis.IntCmd
+	LPop(ctx context.Context, key string) *redis.StringCmd
+	LPopCount(ctx context.Context, key string, count int) *redis.StringSliceCmd
+	LLen(ctx context.Context, key string) *redis.IntCmd
+	LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
+
+	ScriptLoad(ctx context.Context, script string) *redis.StringCmd
+	EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd
+
+	Pipeline() redis.Pipeliner
+	Pipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
+	TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
+	Watch(ctx context.Context, fn func(*redis.Tx) error, keys ...string) error
+
+	ForEachMaster(ctx context.Context, fn func(ctx context.Context, client *redis.Client) error) error
+	Close() error
+}
+
+var _ Driver = (*redis.ClusterClient)(nil)
+
 type Client struct {
 	logger  zerolog.Logger
 	options *redis.ClusterOptions
-	Driver  *redis.ClusterClient
+	Driver  Driver
 }
 
 type ClientOptions struct {
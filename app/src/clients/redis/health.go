@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/health"
 	"context"
 	"fmt"
@@ -12,7 +13,7 @@ import (
 )
 
 const (
-	PingTargetName               = "redis"
+	PingTargetName               = components.Redis
 	pingShallowAcceptableLatency = 25 * time.Millisecond
 	pingDeepAcceptableLatency    = 50 * time.Millisecond
 )
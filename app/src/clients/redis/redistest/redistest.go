@@ -0,0 +1,44 @@
+// Package redistest provides the Redis client of the tests of the services depending on Redis.
+package redistest
+
+import (
+	"chat/src/clients/redis"
+	"context"
+	"os"
+	"testing"
+
+	redis2 "github.com/redis/go-redis/v9"
+)
+
+// AddressEnv names the environment variable holding the address of the Redis node the tests run against,
+// i.e. "localhost:6379". Tests depending on Redis are skipped when it isn't set.
+const AddressEnv = "CHAT_TEST_REDIS_ADDR"
+
+// NewClient returns a started client whose driver is connected to the Redis node at AddressEnv, so services can be
+// tested without a Redis cluster. The node acts as the only cluster master and is flushed before the test.
+func NewClient(tb testing.TB) *redis.Client {
+	tb.Helper()
+
+	address := os.Getenv(AddressEnv)
+	if address == "" {
+		tb.Skipf("%s is not set, skipping test depending on Redis", AddressEnv)
+	}
+
+	node := redis2.NewClient(&redis2.Options{Addr: address})
+	tb.Cleanup(func() { _ = node.Close() })
+
+	if err := node.FlushDB(context.Background()).Err(); err != nil {
+		tb.Fatalf("failed to flush Redis node at '%s': %v", address, err)
+	}
+	return &redis.Client{Driver: singleNodeDriver{Client: node}}
+}
+
+var _ redis.Driver = singleNodeDriver{}
+
+type singleNodeDriver struct {
+	*redis2.Client
+}
+
+func (d singleNodeDriver) ForEachMaster(ctx context.Context, fn func(ctx context.Context, client *redis2.Client) error) error {
+	return fn(ctx, d.Client)
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"chat/src/util"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	maxTxAttempts  = 5
+	txBackoffBase  = 5 * time.Millisecond
+	txBackoffLimit = 100 * time.Millisecond
+)
+
+var ErrTxConflict = errors.New("transaction aborted by concurrent modifications of watched keys")
+
+// WithPipeline executes the commands queued by fn in a single round trip. The commands are returned even when
+// the pipeline fails, so the result of each one can be inspected.
+func (c *Client) WithPipeline(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("pipeline not executed: %w", err)
+	}
+
+	commands, err := c.Driver.Pipelined(ctx, fn)
+	if err != nil {
+		return commands, fmt.Errorf("pipeline of %d commands failed: %w", len(commands), err)
+	}
+	return commands, nil
+}
+
+// WithTxWatch runs fn in an optimistic transaction watching the keys. When the watched keys are modified before
+// the transaction is executed, fn is run again with backoff, at most maxTxAttempts times.
+func (c *Client) WithTxWatch(ctx context.Context, keys []string, fn func(*redis.Tx) error) error {
+	for attempt := range maxTxAttempts {
+		err := c.Driver.Watch(ctx, fn, keys...)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, redis.TxFailedErr) {
+			return fmt.Errorf("transaction on keys %v failed: %w", keys, err)
+		}
+		if attempt == maxTxAttempts-1 {
+			break // no backoff, the conflict is reported at once
+		}
+
+		timer := time.NewTimer(util.ExponentialBackoff(attempt, txBackoffBase, txBackoffLimit))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w on keys %v: %w", ErrTxConflict, keys, ctx.Err())
+		case <-timer.C:
+		}
+	}
+	return fmt.Errorf("%w on keys %v after %d attempts: %w", ErrTxConflict, keys, maxTxAttempts, redis.TxFailedErr)
+}
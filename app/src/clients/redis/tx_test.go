@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// scriptedDriver answers watch transactions and pipelines with the scripted errors.
+type scriptedDriver struct {
+	Driver
+	watchErrs   []error // returned by the successive watches, the last one is repeated
+	watches     int
+	pipelineErr error
+	pipelined   int
+}
+
+func (d *scriptedDriver) Watch(_ context.Context, _ func(*redis.Tx) error, _ ...string) error {
+	err := d.watchErrs[min(d.watches, len(d.watchErrs)-1)]
+	d.watches++
+	return err
+}
+
+func (d *scriptedDriver) Pipelined(ctx context.Context, _ func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	d.pipelined++
+	return []redis.Cmder{redis.NewStatusCmd(ctx, "set", "key", "value")}, d.pipelineErr
+}
+
+func TestWithTxWatchBoundsConflictRetries(t *testing.T) {
+	driver := &scriptedDriver{watchErrs: []error{redis.TxFailedErr}}
+	client := &Client{Driver: driver}
+
+	err := client.WithTxWatch(context.Background(), []string{"key"}, nil)
+	if !errors.Is(err, ErrTxConflict) || !errors.Is(err, redis.TxFailedErr) {
+		t.Fatalf("WithTxWatch() error = %v, want %v", err, ErrTxConflict)
+	}
+	if driver.watches != maxTxAttempts {
+		t.Fatalf("transaction attempted %d times, want %d", driver.watches, maxTxAttempts)
+	}
+}
+
+func TestWithTxWatchDoesNotBackOffAfterLastAttempt(t *testing.T) {
+	driver := &scriptedDriver{watchErrs: []error{redis.TxFailedErr}}
+	client := &Client{Driver: driver}
+
+	// the backoffs between the attempts take 60ms to 90ms, one after the last attempt would add at least 64ms
+	startedAt := time.Now()
+	if err := client.WithTxWatch(context.Background(), []string{"key"}, nil); !errors.Is(err, ErrTxConflict) {
+		t.Fatalf("WithTxWatch() error = %v, want %v", err, ErrTxConflict)
+	}
+	if elapsed := time.Since(startedAt); elapsed >= 110*time.Millisecond {
+		t.Fatalf("WithTxWatch() returned after %v, want no backoff after the last attempt", elapsed)
+	}
+}
+
+func TestWithTxWatchRetriesUntilNoConflict(t *testing.T) {
+	driver := &scriptedDriver{watchErrs: []error{redis.TxFailedErr, redis.TxFailedErr, nil}}
+	client := &Client{Driver: driver}
+
+	if err := client.WithTxWatch(context.Background(), []string{"key"}, nil); err != nil {
+		t.Fatalf("WithTxWatch() error = %v", err)
+	}
+	if driver.watches != 3 {
+		t.Fatalf("transaction attempted %d times, want %d", driver.watches, 3)
+	}
+}
+
+func TestWithTxWatchDoesNotRetryOtherErrors(t *testing.T) {
+	failure := errors.New("connection reset")
+	driver := &scriptedDriver{watchErrs: []error{failure}}
+	client := &Client{Driver: driver}
+
+	err := client.WithTxWatch(context.Background(), []string{"key"}, nil)
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "transaction on keys [key] failed") {
+		t.Fatalf("WithTxWatch() error = %v, want %v wrapped with the keys", err, failure)
+	}
+	if driver.watches != 1 {
+		t.Fatalf("transaction attempted %d times, want %d", driver.watches, 1)
+	}
+}
+
+func TestWithTxWatchStopsBackingOffWhenContextIsDone(t *testing.T) {
+	driver := &scriptedDriver{watchErrs: []error{redis.TxFailedErr}}
+	client := &Client{Driver: driver}
+	ctx, cancel := context.WithTimeout(context.Background(), txBackoffBase/2)
+	defer cancel()
+
+	err := client.WithTxWatch(ctx, []string{"key"}, nil)
+	if !errors.Is(err, ErrTxConflict) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WithTxWatch() error = %v, want a conflict with the deadline exceeded", err)
+	}
+	if driver.watches != 1 {
+		t.Fatalf("transaction attempted %d times, want %d", driver.watches, 1)
+	}
+}
+
+func TestWithPipelinePropagatesErrors(t *testing.T) {
+	failure := errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	driver := &scriptedDriver{pipelineErr: failure}
+	client := &Client{Driver: driver}
+
+	commands, err := client.WithPipeline(context.Background(), func(redis.Pipeliner) error { return nil })
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithPipeline() error = %v, want %v", err, failure)
+	}
+	if len(commands) != 1 {
+		t.Fatalf("WithPipeline() returned %d commands, want the commands of the failed pipeline", len(commands))
+	}
+}
+
+func TestWithPipelineSkipsDoneContext(t *testing.T) {
+	driver := &scriptedDriver{}
+	client := &Client{Driver: driver}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.WithPipeline(ctx, func(redis.Pipeliner) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("WithPipeline() error = %v, want %v", err, context.Canceled)
+	}
+	if driver.pipelined != 0 {
+		t.Fatal("WithPipeline() executed the pipeline, want it skipped on a done context")
+	}
+}
@@ -27,9 +27,10 @@ import (
 var ErrAlreadyStarted = errors.New("scylla client already started")
 
 type Client struct {
-	logger zerolog.Logger
-	config *gocql.ClusterConfig
-	Driver *gocql.Session
+	logger      zerolog.Logger
+	config      *gocql.ClusterConfig
+	speculative gocql.SpeculativeExecutionPolicy // #readonly
+	Driver      *gocql.Session
 }
 
 type ClientLoggerOptions struct {
@@ -46,9 +47,30 @@ type ClientOptions struct {
 	Username          string
 	Password          string
 	AddressTranslator gocql.AddressTranslator
+	Compression       Compression     // defaults to snappy
+	Metrics           MetricsRegistry // optional, receives the metrics of the queries
+	SlowQuery         time.Duration   // optional, successful query attempts slower than it are logged
+	Retry             RetryOptions
+	Speculative       SpeculativeOptions
 	Logger            ClientLoggerOptions
 }
 
+// RetryOptions configures the retries of failed queries with exponential backoff. By default, the driver retries
+// a query 3 times without delay.
+type RetryOptions struct {
+	NumRetries int // 0 keeps the default of the driver
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// SpeculativeOptions configures the speculative execution of the queries made with Client.Query: when a replica
+// doesn't answer within Delay (i.e. the p99 latency of the queries), the query is sent to the next replica as well,
+// so a single slow node doesn't add its latency to every query. It relies on queries being idempotent by default.
+type SpeculativeOptions struct {
+	Attempts int // additional replicas a query is sent to, 0 disables speculative execution
+	Delay    time.Duration
+}
+
 func NewClient(options *ClientOptions) *Client {
 	clusterConfig := gocql.NewCluster(options.Hosts...)
 
@@ -84,7 +106,7 @@ func NewClient(options *ClientOptions) *Client {
 	clusterConfig.Keyspace = options.Keyspace
 
 	// Enable compression to reduce bandwidth usage.
-	clusterConfig.Compressor = &gocql.SnappyCompressor{}
+	clusterConfig.Compressor = options.Compression.compressor()
 
 	// Set the authenticator if provided.
 	clusterConfig.Authenticator = gocql.PasswordAuthenticator{
@@ -95,6 +117,20 @@ func NewClient(options *ClientOptions) *Client {
 
 	// Resiliency
 	clusterConfig.DefaultIdempotence = true
+	if options.Retry.NumRetries > 0 {
+		clusterConfig.RetryPolicy = &gocql.ExponentialBackoffRetryPolicy{
+			NumRetries: options.Retry.NumRetries,
+			Min:        options.Retry.MinBackoff,
+			Max:        options.Retry.MaxBackoff,
+		}
+	}
+	var speculative gocql.SpeculativeExecutionPolicy = &gocql.NonSpeculativeExecution{}
+	if options.Speculative.Attempts > 0 {
+		speculative = &gocql.SimpleSpeculativeExecution{
+			NumAttempts:  options.Speculative.Attempts,
+			TimeoutDelay: options.Speculative.Delay,
+		}
+	}
 	clusterConfig.Timeout = 3 * time.Second
 	clusterConfig.WriteTimeout = 3 * time.Second
 	clusterConfig.ReadTimeout = 4 * time.Second
@@ -104,13 +140,30 @@ func NewClient(options *ClientOptions) *Client {
 	// Set up logging
 	clusterConfig.Logger = &zerologAdapter{logger: options.Logger.Driver}
 
+	// Set up query observability
+	if options.Metrics != nil || options.SlowQuery > 0 {
+		observer := &queryObserver{
+			registry:  options.Metrics,
+			slowQuery: options.SlowQuery,
+			logger:    options.Logger.Client,
+		}
+		clusterConfig.QueryObserver = observer
+		clusterConfig.BatchObserver = observer
+	}
+
 	return &Client{
-		logger: options.Logger.Client,
-		config: clusterConfig,
-		Driver: nil,
+		logger:      options.Logger.Client,
+		config:      clusterConfig,
+		speculative: speculative,
+		Driver:      nil,
 	}
 }
 
+// Query creates a query of the statement which is speculatively executed when configured, see SpeculativeOptions.
+func (c *Client) Query(statement string, values ...any) *gocql.Query {
+	return c.Driver.Query(statement, values...).SetSpeculativeExecutionPolicy(c.speculative)
+}
+
 func (c *Client) Start(_ context.Context) error {
 	if c.Driver != nil {
 		return ErrAlreadyStarted
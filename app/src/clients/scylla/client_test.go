@@ -0,0 +1,52 @@
+package scylla
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestNewClientAppliesRetryPolicy(t *testing.T) {
+	client := NewClient(&ClientOptions{
+		TLSConfig: &tls.Config{},
+		Retry:     RetryOptions{NumRetries: 5, MinBackoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second},
+	})
+
+	policy, ok := client.config.RetryPolicy.(*gocql.ExponentialBackoffRetryPolicy)
+	if !ok {
+		t.Fatalf("cluster config retry policy = %T, want exponential backoff", client.config.RetryPolicy)
+	}
+	if policy.NumRetries != 5 || policy.Min != 100*time.Millisecond || policy.Max != 2*time.Second {
+		t.Errorf("retry policy = %+v, want 5 retries backing off from 100ms to 2s", policy)
+	}
+	if !client.config.DefaultIdempotence {
+		t.Error("cluster config default idempotence = false, want queries to be retried as idempotent")
+	}
+
+	client = NewClient(&ClientOptions{TLSConfig: &tls.Config{}})
+	if client.config.RetryPolicy != nil { // the driver falls back to its simple retries
+		t.Errorf("cluster config retry policy = %T without retry options, want the default of the driver", client.config.RetryPolicy)
+	}
+}
+
+func TestNewClientAppliesSpeculativeExecution(t *testing.T) {
+	client := NewClient(&ClientOptions{
+		TLSConfig:   &tls.Config{},
+		Speculative: SpeculativeOptions{Attempts: 2, Delay: 50 * time.Millisecond},
+	})
+
+	policy, ok := client.speculative.(*gocql.SimpleSpeculativeExecution)
+	if !ok {
+		t.Fatalf("speculative execution policy = %T, want simple speculative execution", client.speculative)
+	}
+	if policy.Attempts() != 2 || policy.Delay() != 50*time.Millisecond {
+		t.Errorf("speculative execution = %d attempts after %v, want 2 after 50ms", policy.Attempts(), policy.Delay())
+	}
+
+	client = NewClient(&ClientOptions{TLSConfig: &tls.Config{}})
+	if attempts := client.speculative.Attempts(); attempts != 0 {
+		t.Errorf("speculative execution attempts = %d without speculative options, want it disabled", attempts)
+	}
+}
@@ -0,0 +1,80 @@
+package scylla
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gocql/gocql"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compression is the algorithm the frames exchanged with the nodes are compressed with, negotiated on connect.
+type Compression uint8
+
+const (
+	CompressionSnappy Compression = iota
+	CompressionLZ4
+	CompressionNone // i.e. for workloads made of tiny queries, where compression costs more than it saves
+)
+
+// ParseCompression returns the compression named "snappy", "lz4" or "none" in config, unknown names use snappy.
+func ParseCompression(name string) Compression {
+	switch name {
+	case "lz4":
+		return CompressionLZ4
+	case "none":
+		return CompressionNone
+	default:
+		return CompressionSnappy
+	}
+}
+
+func (c Compression) compressor() gocql.Compressor {
+	switch c {
+	case CompressionLZ4:
+		return lz4Compressor{}
+	case CompressionNone:
+		return nil
+	default:
+		return &gocql.SnappyCompressor{}
+	}
+}
+
+var errShortLZ4Frame = errors.New("lz4 frame is shorter than its length prefix")
+
+// lz4Compressor compresses frames as the CQL native protocol expects: the length of the uncompressed data as a
+// big endian uint32, followed by the LZ4 block. The scylla driver only bundles snappy.
+type lz4Compressor struct{}
+
+func (lz4Compressor) Name() string {
+	return "lz4"
+}
+
+func (lz4Compressor) Encode(data []byte) ([]byte, error) {
+	buf := make([]byte, 4+lz4.CompressBlockBound(len(data)))
+	var compressor lz4.Compressor
+	n, err := compressor.CompressBlock(data, buf[4:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress lz4 block: %w", err)
+	}
+	binary.BigEndian.PutUint32(buf, uint32(len(data))) //nolint:gosec // frames are far below 4GiB
+	return buf[:4+n], nil
+}
+
+func (lz4Compressor) Decode(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errShortLZ4Frame
+	}
+	uncompressedLength := binary.BigEndian.Uint32(data)
+	if uncompressedLength == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, uncompressedLength)
+	n, err := lz4.UncompressBlock(data[4:], buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to uncompress lz4 block: %w", err)
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,102 @@
+package scylla
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		name string
+		want Compression
+	}{
+		{name: "snappy", want: CompressionSnappy},
+		{name: "lz4", want: CompressionLZ4},
+		{name: "none", want: CompressionNone},
+		{name: "zstd", want: CompressionSnappy},
+		{name: "", want: CompressionSnappy},
+	}
+	for _, tt := range tests {
+		if got := ParseCompression(tt.name); got != tt.want {
+			t.Errorf("ParseCompression(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewClientAppliesCompression(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression Compression
+		want        string // name of the compressor negotiated with the nodes, empty for none
+	}{
+		{name: "default", want: "snappy"},
+		{name: "snappy", compression: CompressionSnappy, want: "snappy"},
+		{name: "lz4", compression: CompressionLZ4, want: "lz4"},
+		{name: "none", compression: CompressionNone, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(&ClientOptions{Hosts: []string{"localhost"}, TLSConfig: &tls.Config{}, Compression: tt.compression})
+
+			var got string
+			if client.config.Compressor != nil {
+				got = client.config.Compressor.Name()
+			}
+			if got != tt.want {
+				t.Fatalf("cluster config compressor = %q, want %q", got, tt.want)
+			}
+			if tt.want == "snappy" {
+				if _, ok := client.config.Compressor.(*gocql.SnappyCompressor); !ok {
+					t.Errorf("cluster config compressor = %T, want the snappy compressor of the driver", client.config.Compressor)
+				}
+			}
+		})
+	}
+}
+
+func TestLZ4CompressorRoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("rand.Read() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "tiny", data: []byte("a")},
+		{name: "repetitive", data: bytes.Repeat([]byte("SELECT * FROM messages; "), 200)},
+		{name: "incompressible", data: random},
+	}
+	var compressor lz4Compressor
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := compressor.Encode(tt.data)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if length := binary.BigEndian.Uint32(frame); int(length) != len(tt.data) {
+				t.Fatalf("Encode() length prefix = %d, want the %d uncompressed bytes", length, len(tt.data))
+			}
+			decoded, err := compressor.Decode(frame)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !bytes.Equal(decoded, tt.data) {
+				t.Fatalf("Decode() = %d bytes, want the %d encoded ones", len(decoded), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestLZ4CompressorRejectsShortFrames(t *testing.T) {
+	if _, err := (lz4Compressor{}).Decode([]byte{0, 0, 1}); !errors.Is(err, errShortLZ4Frame) {
+		t.Fatalf("Decode() error = %v, want %v", err, errShortLZ4Frame)
+	}
+}
@@ -1,6 +1,7 @@
 package scylla
 
 import (
+	"chat/src/platform/components"
 	"chat/src/platform/health"
 	"context"
 	"fmt"
@@ -8,7 +9,7 @@ import (
 )
 
 const (
-	PingTargetName               = "scylla"
+	PingTargetName               = components.ScyllaDB
 	pingShallowAcceptableLatency = 50 * time.Millisecond
 	pingDeepAcceptableLatency    = 150 * time.Millisecond
 )
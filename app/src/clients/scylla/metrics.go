@@ -0,0 +1,121 @@
+package scylla
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/rs/zerolog"
+)
+
+const maxStatementNameLength = 120
+
+// MetricsRegistry receives the metrics of the queries a client runs against the nodes.
+type MetricsRegistry interface {
+	// ObserveQuery is called once per attempt of a query or batch, attempt being 0 for the first one and failed
+	// telling whether the node reported an error.
+	ObserveQuery(statement, host string, latency time.Duration, attempt int, failed bool)
+}
+
+// queryObserver forwards the query and batch observations of the driver to a MetricsRegistry and logs the failed
+// and the slow attempts.
+type queryObserver struct {
+	registry  MetricsRegistry // optional
+	slowQuery time.Duration   // 0 disables logging of slow queries
+	logger    zerolog.Logger
+}
+
+var (
+	_ gocql.QueryObserver = (*queryObserver)(nil)
+	_ gocql.BatchObserver = (*queryObserver)(nil)
+)
+
+func (o *queryObserver) ObserveQuery(_ context.Context, query gocql.ObservedQuery) {
+	o.observe(statementName(query.Statement), query.Host, query.End.Sub(query.Start), query.Attempt, query.Err)
+}
+
+func (o *queryObserver) ObserveBatch(_ context.Context, batch gocql.ObservedBatch) {
+	name := "BATCH"
+	if len(batch.Statements) > 0 {
+		name += " " + statementName(batch.Statements[0])
+	}
+	o.observe(name, batch.Host, batch.End.Sub(batch.Start), batch.Attempt, batch.Err)
+}
+
+func (o *queryObserver) observe(statement string, host *gocql.HostInfo, latency time.Duration, attempt int, err error) {
+	hostName := ""
+	if host != nil {
+		hostName = host.ConnectAddressAndPort()
+	}
+	if o.registry != nil {
+		o.registry.ObserveQuery(statement, hostName, latency, attempt, err != nil)
+	}
+
+	if err != nil {
+		o.logger.Debug().Err(err).Msgf("Query '%s' failed on host '%s' at attempt %d after %v", statement, hostName, attempt, latency)
+	} else if o.slowQuery > 0 && latency > o.slowQuery {
+		o.logger.Warn().Msgf("Query '%s' took %v on host '%s' at attempt %d", statement, latency, hostName, attempt)
+	}
+}
+
+// statementName collapses the whitespace of the statement and truncates it, the statements being prepared with
+// placeholders, it identifies the query without recording the bound values.
+func statementName(statement string) string {
+	name := strings.Join(strings.Fields(statement), " ")
+	if len(name) > maxStatementNameLength {
+		name = name[:maxStatementNameLength] + "..."
+	}
+	return name
+}
+
+// QueryMetrics is an in-memory MetricsRegistry, its snapshot is meant to be exposed on the admin server.
+type QueryMetrics struct {
+	mu         sync.Mutex
+	statements map[string]*StatementStats
+}
+
+type StatementStats struct {
+	Attempts uint64        `json:"attempts"`
+	Retries  uint64        `json:"retries"` // attempts after the first one
+	Errors   uint64        `json:"errors"`
+	Total    time.Duration `json:"total_latency"`
+	Max      time.Duration `json:"max_latency"`
+}
+
+func NewQueryMetrics() *QueryMetrics {
+	return &QueryMetrics{statements: make(map[string]*StatementStats)}
+}
+
+func (m *QueryMetrics) ObserveQuery(statement, _ string, latency time.Duration, attempt int, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.statements[statement]
+	if !ok {
+		stats = &StatementStats{}
+		m.statements[statement] = stats
+	}
+	stats.Attempts++
+	if attempt > 0 {
+		stats.Retries++
+	}
+	if failed {
+		stats.Errors++
+	}
+	stats.Total += latency
+	stats.Max = max(stats.Max, latency)
+}
+
+// Snapshot returns the stats by statement.
+func (m *QueryMetrics) Snapshot() map[string]StatementStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]StatementStats, len(m.statements))
+	for statement, stats := range m.statements {
+		snapshot[statement] = *stats
+	}
+	return snapshot
+}
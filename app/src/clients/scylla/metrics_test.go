@@ -0,0 +1,173 @@
+package scylla
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/rs/zerolog"
+)
+
+type observation struct {
+	statement string
+	latency   time.Duration
+	attempt   int
+	failed    bool
+}
+
+// stubRegistry records the observations it receives.
+type stubRegistry struct {
+	mu           sync.Mutex
+	observations []observation
+}
+
+func (r *stubRegistry) ObserveQuery(statement, _ string, latency time.Duration, attempt int, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observations = append(r.observations, observation{statement: statement, latency: latency, attempt: attempt, failed: failed})
+}
+
+func (r *stubRegistry) recorded() []observation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.observations)
+}
+
+func TestQueryObserverForwardsAttemptsToRegistry(t *testing.T) {
+	registry := &stubRegistry{}
+	observer := &queryObserver{registry: registry, logger: zerolog.Nop()}
+	start := time.Now()
+
+	observer.ObserveQuery(context.Background(), gocql.ObservedQuery{
+		Statement: "SELECT body\n\t  FROM messages WHERE conversation = ?",
+		Start:     start,
+		End:       start.Add(3 * time.Millisecond),
+	})
+	observer.ObserveQuery(context.Background(), gocql.ObservedQuery{
+		Statement: "SELECT body FROM messages WHERE conversation = ?",
+		Start:     start,
+		End:       start.Add(time.Second),
+		Attempt:   1,
+		Err:       errors.New("request timeout"),
+	})
+	observer.ObserveBatch(context.Background(), gocql.ObservedBatch{
+		Statements: []string{"INSERT INTO messages (conversation, seq) VALUES (?, ?)", "UPDATE conversations SET seq = ?"},
+		Start:      start,
+		End:        start.Add(time.Millisecond),
+	})
+
+	want := []observation{
+		{statement: "SELECT body FROM messages WHERE conversation = ?", latency: 3 * time.Millisecond},
+		{statement: "SELECT body FROM messages WHERE conversation = ?", latency: time.Second, attempt: 1, failed: true},
+		{statement: "BATCH INSERT INTO messages (conversation, seq) VALUES (?, ?)", latency: time.Millisecond},
+	}
+	if got := registry.recorded(); !slices.Equal(got, want) {
+		t.Fatalf("observations = %+v, want %+v", got, want)
+	}
+}
+
+func TestQueryObserverLogsFailedAndSlowAttempts(t *testing.T) {
+	tests := []struct {
+		name      string
+		slowQuery time.Duration
+		latency   time.Duration
+		err       error
+		wantLog   string
+	}{
+		{name: "failed", latency: time.Millisecond, err: errors.New("unavailable"), wantLog: "failed on host"},
+		{name: "slow", slowQuery: 10 * time.Millisecond, latency: 20 * time.Millisecond, wantLog: "took 20ms"},
+		{name: "fast", slowQuery: 10 * time.Millisecond, latency: 5 * time.Millisecond},
+		{name: "slow query logs disabled", latency: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			observer := &queryObserver{slowQuery: tt.slowQuery, logger: zerolog.New(&logs)} // without registry
+			start := time.Now()
+
+			observer.ObserveQuery(context.Background(), gocql.ObservedQuery{
+				Statement: "SELECT * FROM users", Start: start, End: start.Add(tt.latency), Err: tt.err,
+			})
+			if tt.wantLog == "" {
+				if logs.Len() != 0 {
+					t.Fatalf("logs = %s, want none", logs.String())
+				}
+			} else if !strings.Contains(logs.String(), tt.wantLog) || !strings.Contains(logs.String(), "SELECT * FROM users") {
+				t.Fatalf("logs = %s, want the statement and '%s'", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestStatementNameIsTruncated(t *testing.T) {
+	name := statementName("SELECT " + strings.Repeat("column, ", 50) + "id FROM users")
+	if len(name) != maxStatementNameLength+len("...") || !strings.HasSuffix(name, "...") {
+		t.Fatalf("statementName() = %q, want it truncated to %d characters", name, maxStatementNameLength)
+	}
+}
+
+func TestQueryMetricsAggregatesByStatement(t *testing.T) {
+	metrics := NewQueryMetrics()
+	metrics.ObserveQuery("SELECT", "10.0.0.1:9042", 2*time.Millisecond, 0, false)
+	metrics.ObserveQuery("SELECT", "10.0.0.2:9042", 5*time.Millisecond, 1, true)
+	metrics.ObserveQuery("INSERT", "10.0.0.1:9042", time.Millisecond, 0, false)
+
+	snapshot := metrics.Snapshot()
+	want := map[string]StatementStats{
+		"SELECT": {Attempts: 2, Retries: 1, Errors: 1, Total: 7 * time.Millisecond, Max: 5 * time.Millisecond},
+		"INSERT": {Attempts: 1, Total: time.Millisecond, Max: time.Millisecond},
+	}
+	if len(snapshot) != len(want) {
+		t.Fatalf("Snapshot() = %+v, want %+v", snapshot, want)
+	}
+	for statement, stats := range want {
+		if snapshot[statement] != stats {
+			t.Errorf("Snapshot()[%s] = %+v, want %+v", statement, snapshot[statement], stats)
+		}
+	}
+}
+
+func TestNewClientObservesQueriesOnlyWhenConfigured(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ClientOptions
+		want    bool
+	}{
+		{name: "default"},
+		{name: "metrics", options: ClientOptions{Metrics: NewQueryMetrics()}, want: true},
+		{name: "slow query logs", options: ClientOptions{SlowQuery: time.Second}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.TLSConfig = &tls.Config{}
+			client := NewClient(&tt.options)
+			if observed := client.config.QueryObserver != nil && client.config.BatchObserver != nil; observed != tt.want {
+				t.Fatalf("queries and batches observed = %t, want %t", observed, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueriesAreObserved(t *testing.T) {
+	registry := &stubRegistry{}
+	client := newTestClient(t, func(config *gocql.ClusterConfig) {
+		config.QueryObserver = &queryObserver{registry: registry, logger: zerolog.Nop()}
+	})
+
+	if err := client.Query(`SELECT release_version FROM system.local`).Exec(); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	observations := registry.recorded()
+	if !slices.ContainsFunc(observations, func(o observation) bool {
+		return o.statement == "SELECT release_version FROM system.local" && !o.failed && o.latency > 0
+	}) {
+		t.Fatalf("observations = %+v, want the executed query", observations)
+	}
+}
@@ -0,0 +1,109 @@
+package scylla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	ErrNotStarted      = errors.New("scylla client is not started")
+	ErrInvalidPageSize = errors.New("page size must be between 1 and 10000")
+)
+
+const maxPageSize = 10_000
+
+// PageIterator iterates the rows of a query one page at a time, so large partitions (i.e. message history) are
+// never loaded in memory at once. Each page is fetched with its own read timeout.
+type PageIterator struct {
+	client      *Client
+	ctx         context.Context
+	stmt        string
+	values      []any
+	pageSize    int
+	readTimeout time.Duration
+
+	iter       *gocql.Iter
+	cancelPage context.CancelFunc
+	nextState  []byte
+	err        error
+}
+
+// QueryPaged runs the statement and returns an iterator over its rows. A nil pageState starts from the first page,
+// while a state saved with PageIterator.PageState resumes from the page following the one it was saved on.
+func (c *Client) QueryPaged(ctx context.Context, stmt string, pageSize int, pageState []byte, values ...any) (*PageIterator, error) {
+	if c.Driver == nil {
+		return nil, ErrNotStarted
+	}
+	if pageSize < 1 || pageSize > maxPageSize {
+		return nil, fmt.Errorf("%w: given %d", ErrInvalidPageSize, pageSize)
+	}
+
+	iterator := &PageIterator{
+		client:      c,
+		ctx:         ctx,
+		stmt:        stmt,
+		values:      values,
+		pageSize:    pageSize,
+		readTimeout: c.config.ReadTimeout,
+	}
+	iterator.fetch(pageState)
+	return iterator, nil
+}
+
+// Next scans the next row into dest, fetching the next page when the current one is exhausted.
+// It returns false when there are no more rows or an error occurred, which is reported by Close.
+func (it *PageIterator) Next(dest ...any) bool {
+	for it.iter != nil {
+		if it.iter.Scan(dest...) {
+			return true
+		}
+		if err := it.closePage(); err != nil {
+			it.err = err
+			return false
+		}
+		if len(it.nextState) == 0 {
+			return false
+		}
+		it.fetch(it.nextState)
+	}
+	return false
+}
+
+// PageState returns the state resuming from the page following the current one, nil after the last page.
+func (it *PageIterator) PageState() []byte {
+	return it.nextState
+}
+
+func (it *PageIterator) Close() error {
+	if it.iter != nil {
+		if err := it.closePage(); err != nil && it.err == nil {
+			it.err = err
+		}
+	}
+	return it.err
+}
+
+func (it *PageIterator) fetch(pageState []byte) {
+	ctx, cancel := context.WithTimeout(it.ctx, it.readTimeout)
+	it.cancelPage = cancel
+	it.iter = it.client.Query(it.stmt, it.values...).
+		WithContext(ctx).
+		PageSize(it.pageSize).
+		PageState(pageState).
+		Iter()
+	it.nextState = it.iter.PageState()
+}
+
+func (it *PageIterator) closePage() error {
+	err := it.iter.Close()
+	it.cancelPage()
+	it.iter = nil
+	if err != nil {
+		return fmt.Errorf("failed to fetch page of query '%s': %w", it.stmt, err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package scylla
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/rs/zerolog"
+)
+
+// hostsEnv names the environment variable holding the comma separated hosts of the ScyllaDB node the tests run
+// against, i.e. "localhost:9042". Tests depending on ScyllaDB are skipped when it isn't set.
+const hostsEnv = "CHAT_TEST_SCYLLA_HOSTS"
+
+const testKeyspace = "chat_test"
+
+// newTestClient returns a client connected without TLS to the node at hostsEnv, in the test keyspace, the cluster
+// config being configured before connecting.
+func newTestClient(t *testing.T, configure ...func(*gocql.ClusterConfig)) *Client {
+	t.Helper()
+
+	hosts := os.Getenv(hostsEnv)
+	if hosts == "" {
+		t.Skipf("%s is not set, skipping test depending on ScyllaDB", hostsEnv)
+	}
+
+	config := gocql.NewCluster(strings.Split(hosts, ",")...)
+	config.Consistency = gocql.One
+	config.Timeout = 10 * time.Second
+	config.ReadTimeout = 10 * time.Second
+	session, err := config.CreateSession()
+	if err != nil {
+		t.Fatalf("failed to connect to ScyllaDB at '%s': %v", hosts, err)
+	}
+	err = session.Query(`CREATE KEYSPACE IF NOT EXISTS ` + testKeyspace +
+		` WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`).Exec()
+	session.Close()
+	if err != nil {
+		t.Fatalf("failed to create keyspace '%s': %v", testKeyspace, err)
+	}
+
+	config.Keyspace = testKeyspace
+	for _, fn := range configure {
+		fn(config)
+	}
+	client := &Client{logger: zerolog.Nop(), config: config, speculative: &gocql.NonSpeculativeExecution{}}
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { client.Stop(context.Background()) })
+	return client
+}
+
+// seedMessages creates a table of messages partitioned by conversation, with count messages in one partition.
+func seedMessages(t *testing.T, client *Client, count int) string {
+	t.Helper()
+
+	table := "messages_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := client.Query(`CREATE TABLE ` + table +
+		` (conversation text, seq int, body text, PRIMARY KEY (conversation, seq))`).Exec(); err != nil {
+		t.Fatalf("failed to create table '%s': %v", table, err)
+	}
+	t.Cleanup(func() { _ = client.Query(`DROP TABLE IF EXISTS ` + table).Exec() })
+
+	for seq := range count {
+		if err := client.Query(`INSERT INTO `+table+` (conversation, seq, body) VALUES (?, ?, ?)`,
+			"general", seq, "message "+strconv.Itoa(seq)).Exec(); err != nil {
+			t.Fatalf("failed to insert message %d: %v", seq, err)
+		}
+	}
+	return table
+}
+
+// readSeqs reads the sequence numbers of the rows of the iterator, up to limit rows when positive.
+func readSeqs(t *testing.T, iterator *PageIterator, limit int) []int {
+	t.Helper()
+
+	var seqs []int
+	var seq int
+	var body string
+	for (limit <= 0 || len(seqs) < limit) && iterator.Next(&seq, &body) {
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func seqRange(from, to int) []int {
+	seqs := make([]int, 0, to-from)
+	for seq := from; seq < to; seq++ {
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func TestQueryPagedIteratesAllPages(t *testing.T) {
+	client := newTestClient(t)
+	table := seedMessages(t, client, 25)
+
+	iterator, err := client.QueryPaged(context.Background(),
+		`SELECT seq, body FROM `+table+` WHERE conversation = ?`, 10, nil, "general")
+	if err != nil {
+		t.Fatalf("QueryPaged() error = %v", err)
+	}
+	seqs := readSeqs(t, iterator, 0)
+	if err := iterator.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if want := seqRange(0, 25); !slices.Equal(seqs, want) {
+		t.Fatalf("paged rows = %v, want %v", seqs, want)
+	}
+	if state := iterator.PageState(); len(state) != 0 {
+		t.Fatalf("PageState() after the last page = %v, want none", state)
+	}
+}
+
+func TestQueryPagedResumesFromSavedPageState(t *testing.T) {
+	client := newTestClient(t)
+	table := seedMessages(t, client, 25)
+	stmt := `SELECT seq, body FROM ` + table + ` WHERE conversation = ?`
+
+	first, err := client.QueryPaged(context.Background(), stmt, 10, nil, "general")
+	if err != nil {
+		t.Fatalf("QueryPaged() error = %v", err)
+	}
+	if seqs := readSeqs(t, first, 10); !slices.Equal(seqs, seqRange(0, 10)) {
+		t.Fatalf("first page rows = %v, want %v", seqs, seqRange(0, 10))
+	}
+	saved := first.PageState()
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(saved) == 0 {
+		t.Fatal("expected a page state to resume from after the first page")
+	}
+
+	resumed, err := client.QueryPaged(context.Background(), stmt, 10, saved, "general")
+	if err != nil {
+		t.Fatalf("QueryPaged() resuming error = %v", err)
+	}
+	seqs := readSeqs(t, resumed, 0)
+	if err := resumed.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if want := seqRange(10, 25); !slices.Equal(seqs, want) {
+		t.Fatalf("resumed rows = %v, want %v", seqs, want)
+	}
+}
+
+func TestQueryPagedValidatesArguments(t *testing.T) {
+	var client Client
+	if _, err := client.QueryPaged(context.Background(), "SELECT", 10, nil); !errors.Is(err, ErrNotStarted) {
+		t.Fatalf("QueryPaged() on a client which isn't started error = %v, want %v", err, ErrNotStarted)
+	}
+
+	client.Driver = &gocql.Session{} // never queried, the page size is checked first
+	for _, pageSize := range []int{0, -1, maxPageSize + 1} {
+		if _, err := client.QueryPaged(context.Background(), "SELECT", pageSize, nil); !errors.Is(err, ErrInvalidPageSize) {
+			t.Errorf("QueryPaged() with page size %d error = %v, want %v", pageSize, err, ErrInvalidPageSize)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.5
+// 	protoc        (unknown)
+// source: chat/v1/message.proto
+
+package chatv1
+
+import (
+	_ "buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// MessageType classifies the payload carried by a chat message.
+//
+// The value is persisted in ScyllaDB as a lowercase ASCII string
+// (e.g. "text", "system") with the enum prefix stripped.
+type MessageType int32
+
+const (
+	MessageType_MESSAGE_TYPE_UNSPECIFIED MessageType = 0
+	MessageType_MESSAGE_TYPE_TEXT        MessageType = 1
+	MessageType_MESSAGE_TYPE_SYSTEM      MessageType = 2
+	MessageType_MESSAGE_TYPE_POLL        MessageType = 3
+	MessageType_MESSAGE_TYPE_ATTACHMENT  MessageType = 4
+	MessageType_MESSAGE_TYPE_AUDIO       MessageType = 5
+	MessageType_MESSAGE_TYPE_VIDEO       MessageType = 6
+)
+
+// Enum value maps for MessageType.
+var (
+	MessageType_name = map[int32]string{
+		0: "MESSAGE_TYPE_UNSPECIFIED",
+		1: "MESSAGE_TYPE_TEXT",
+		2: "MESSAGE_TYPE_SYSTEM",
+		3: "MESSAGE_TYPE_POLL",
+		4: "MESSAGE_TYPE_ATTACHMENT",
+		5: "MESSAGE_TYPE_AUDIO",
+		6: "MESSAGE_TYPE_VIDEO",
+	}
+	MessageType_value = map[string]int32{
+		"MESSAGE_TYPE_UNSPECIFIED": 0,
+		"MESSAGE_TYPE_TEXT":        1,
+		"MESSAGE_TYPE_SYSTEM":      2,
+		"MESSAGE_TYPE_POLL":        3,
+		"MESSAGE_TYPE_ATTACHMENT":  4,
+		"MESSAGE_TYPE_AUDIO":       5,
+		"MESSAGE_TYPE_VIDEO":       6,
+	}
+)
+
+func (x MessageType) Enum() *MessageType {
+	p := new(MessageType)
+	*p = x
+	return p
+}
+
+func (x MessageType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (MessageType) Descriptor() protoreflect.EnumDescriptor {
+	return file_chat_v1_message_proto_enumTypes[0].Descriptor()
+}
+
+func (MessageType) Type() protoreflect.EnumType {
+	return &file_chat_v1_message_proto_enumTypes[0]
+}
+
+func (x MessageType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use MessageType.Descriptor instead.
+func (MessageType) EnumDescriptor() ([]byte, []int) {
+	return file_chat_v1_message_proto_rawDescGZIP(), []int{0}
+}
+
+// ChatMessage is a message posted by a user into a chat.
+//
+// Chat messages are published to the group inbox topic keyed by chat id,
+// so all messages of a chat are processed in order by the fanout service,
+// which stores them for history and delivers a copy to each member.
+type ChatMessage struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Identifier of the chat the message was posted into.
+	ChatId string `protobuf:"bytes,1,opt,name=chat_id,json=chatId,proto3" json:"chat_id,omitempty"`
+	// Time-based UUID (version 1) identifying the message.
+	//
+	// It is generated by the client for idempotency and also orders
+	// the messages of a chat in storage.
+	MessageId string `protobuf:"bytes,2,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	// Identifier of the user who posted the message.
+	SenderId string `protobuf:"bytes,3,opt,name=sender_id,json=senderId,proto3" json:"sender_id,omitempty"`
+	// Kind of the message payload.
+	Type MessageType `protobuf:"varint,4,opt,name=type,proto3,enum=chat.v1.MessageType" json:"type,omitempty"`
+	// Message content, whose interpretation depends on the message type.
+	Content string `protobuf:"bytes,5,opt,name=content,proto3" json:"content,omitempty"`
+	// Identifier of the message this one replies to, if any.
+	ReplyToMessageId string `protobuf:"bytes,6,opt,name=reply_to_message_id,json=replyToMessageId,proto3" json:"reply_to_message_id,omitempty"`
+	// Opaque client provided metadata (e.g. client message id, device).
+	ClientMetadata map[string]string `protobuf:"bytes,7,rep,name=client_metadata,json=clientMetadata,proto3" json:"client_metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Timestamp indicating when the message was sent by the client.
+	SentAt        *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChatMessage) Reset() {
+	*x = ChatMessage{}
+	mi := &file_chat_v1_message_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChatMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChatMessage) ProtoMessage() {}
+
+func (x *ChatMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_chat_v1_message_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChatMessage.ProtoReflect.Descriptor instead.
+func (*ChatMessage) Descriptor() ([]byte, []int) {
+	return file_chat_v1_message_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ChatMessage) GetChatId() string {
+	if x != nil {
+		return x.ChatId
+	}
+	return ""
+}
+
+func (x *ChatMessage) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *ChatMessage) GetSenderId() string {
+	if x != nil {
+		return x.SenderId
+	}
+	return ""
+}
+
+func (x *ChatMessage) GetType() MessageType {
+	if x != nil {
+		return x.Type
+	}
+	return MessageType_MESSAGE_TYPE_UNSPECIFIED
+}
+
+func (x *ChatMessage) GetContent() string {
+	if x != nil {
+		return x.Content
+	}
+	return ""
+}
+
+func (x *ChatMessage) GetReplyToMessageId() string {
+	if x != nil {
+		return x.ReplyToMessageId
+	}
+	return ""
+}
+
+func (x *ChatMessage) GetClientMetadata() map[string]string {
+	if x != nil {
+		return x.ClientMetadata
+	}
+	return nil
+}
+
+func (x *ChatMessage) GetSentAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SentAt
+	}
+	return nil
+}
+
+// InboxDelivery is a copy of a chat message delivered to a single member.
+//
+// Deliveries are published to the user inbox topic keyed by recipient id,
+// so all deliveries of a user land on the same partition.
+type InboxDelivery struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Identifier of the chat member the message is delivered to.
+	RecipientId string `protobuf:"bytes,1,opt,name=recipient_id,json=recipientId,proto3" json:"recipient_id,omitempty"`
+	// The delivered message.
+	Message       *ChatMessage `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InboxDelivery) Reset() {
+	*x = InboxDelivery{}
+	mi := &file_chat_v1_message_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InboxDelivery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InboxDelivery) ProtoMessage() {}
+
+func (x *InboxDelivery) ProtoReflect() protoreflect.Message {
+	mi := &file_chat_v1_message_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InboxDelivery.ProtoReflect.Descriptor instead.
+func (*InboxDelivery) Descriptor() ([]byte, []int) {
+	return file_chat_v1_message_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InboxDelivery) GetRecipientId() string {
+	if x != nil {
+		return x.RecipientId
+	}
+	return ""
+}
+
+func (x *InboxDelivery) GetMessage() *ChatMessage {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+var File_chat_v1_message_proto protoreflect.FileDescriptor
+
+const file_chat_v1_message_proto_rawDesc = "" +
+	"\n" +
+	"\x15chat/v1/message.proto\x12\achat.v1\x1a\x1bbuf/validate/validate.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8e\x04\n" +
+	"\vChatMessage\x12$\n" +
+	"\achat_id\x18\x01 \x01(\tB\v\xbaH\b\xc8\x01\x01r\x03\xb0\x01\x01R\x06chatId\x12*\n" +
+	"\n" +
+	"message_id\x18\x02 \x01(\tB\v\xbaH\b\xc8\x01\x01r\x03\xb0\x01\x01R\tmessageId\x12(\n" +
+	"\tsender_id\x18\x03 \x01(\tB\v\xbaH\b\xc8\x01\x01r\x03\xb0\x01\x01R\bsenderId\x124\n" +
+	"\x04type\x18\x04 \x01(\x0e2\x14.chat.v1.MessageTypeB\n" +
+	"\xbaH\a\x82\x01\x04\x10\x01 \x00R\x04type\x12%\n" +
+	"\acontent\x18\x05 \x01(\tB\v\xbaH\br\x06\x10\x01\x18\x80\x80\x04R\acontent\x12:\n" +
+	"\x13reply_to_message_id\x18\x06 \x01(\tB\v\xbaH\b\xd8\x01\x01r\x03\xb0\x01\x01R\x10replyToMessageId\x12j\n" +
+	"\x0fclient_metadata\x18\a \x03(\v2(.chat.v1.ChatMessage.ClientMetadataEntryB\x17\xbaH\x14\x9a\x01\x11\x10\x10\"\x06r\x04\x10\x01\x18@*\x05r\x03\x18\x80\x02R\x0eclientMetadata\x12;\n" +
+	"\asent_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampB\x06\xbaH\x03\xc8\x01\x01R\x06sentAt\x1aA\n" +
+	"\x13ClientMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"w\n" +
+	"\rInboxDelivery\x12.\n" +
+	"\frecipient_id\x18\x01 \x01(\tB\v\xbaH\b\xc8\x01\x01r\x03\xb0\x01\x01R\vrecipientId\x126\n" +
+	"\amessage\x18\x02 \x01(\v2\x14.chat.v1.ChatMessageB\x06\xbaH\x03\xc8\x01\x01R\amessage*\xbf\x01\n" +
+	"\vMessageType\x12\x1c\n" +
+	"\x18MESSAGE_TYPE_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11MESSAGE_TYPE_TEXT\x10\x01\x12\x17\n" +
+	"\x13MESSAGE_TYPE_SYSTEM\x10\x02\x12\x15\n" +
+	"\x11MESSAGE_TYPE_POLL\x10\x03\x12\x1b\n" +
+	"\x17MESSAGE_TYPE_ATTACHMENT\x10\x04\x12\x16\n" +
+	"\x12MESSAGE_TYPE_AUDIO\x10\x05\x12\x16\n" +
+	"\x12MESSAGE_TYPE_VIDEO\x10\x06B\x98\x01\n" +
+	"\vcom.chat.v1B\fMessageProtoP\x01Z>github.com/marinrusu1997/chat/app/src/gen/proto/chat/v1;chatv1\xa2\x02\x03CXX\xaa\x02\aChat.V1\xca\x02\aChat\\V1\xe2\x02\x13Chat\\V1\\GPBMetadata\xea\x02\bChat::V1b\x06proto3"
+
+var (
+	file_chat_v1_message_proto_rawDescOnce sync.Once
+	file_chat_v1_message_proto_rawDescData []byte
+)
+
+func file_chat_v1_message_proto_rawDescGZIP() []byte {
+	file_chat_v1_message_proto_rawDescOnce.Do(func() {
+		file_chat_v1_message_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_chat_v1_message_proto_rawDesc), len(file_chat_v1_message_proto_rawDesc)))
+	})
+	return file_chat_v1_message_proto_rawDescData
+}
+
+var file_chat_v1_message_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_chat_v1_message_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_chat_v1_message_proto_goTypes = []any{
+	(MessageType)(0),              // 0: chat.v1.MessageType
+	(*ChatMessage)(nil),           // 1: chat.v1.ChatMessage
+	(*InboxDelivery)(nil),         // 2: chat.v1.InboxDelivery
+	nil,                           // 3: chat.v1.ChatMessage.ClientMetadataEntry
+	(*timestamppb.Timestamp)(nil), // 4: google.protobuf.Timestamp
+}
+var file_chat_v1_message_proto_depIdxs = []int32{
+	0, // 0: chat.v1.ChatMessage.type:type_name -> chat.v1.MessageType
+	3, // 1: chat.v1.ChatMessage.client_metadata:type_name -> chat.v1.ChatMessage.ClientMetadataEntry
+	4, // 2: chat.v1.ChatMessage.sent_at:type_name -> google.protobuf.Timestamp
+	1, // 3: chat.v1.InboxDelivery.message:type_name -> chat.v1.ChatMessage
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_chat_v1_message_proto_init() }
+func file_chat_v1_message_proto_init() {
+	if File_chat_v1_message_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_chat_v1_message_proto_rawDesc), len(file_chat_v1_message_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_chat_v1_message_proto_goTypes,
+		DependencyIndexes: file_chat_v1_message_proto_depIdxs,
+		EnumInfos:         file_chat_v1_message_proto_enumTypes,
+		MessageInfos:      file_chat_v1_message_proto_msgTypes,
+	}.Build()
+	File_chat_v1_message_proto = out.File
+	file_chat_v1_message_proto_goTypes = nil
+	file_chat_v1_message_proto_depIdxs = nil
+}
@@ -0,0 +1,187 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.5
+// 	protoc        (unknown)
+// source: envelope/v1/envelope.proto
+
+package envelopev1
+
+import (
+	_ "buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Envelope wraps every domain message produced to Kafka.
+//
+// The envelope identifies the schema of the payload, so consumers can
+// reject messages they don't know how to decode, instead of silently
+// misreading fields added or retyped by a newer producer.
+type Envelope struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Fully qualified name of the payload message
+	// (for example, "email.v1.SendEmailRequest").
+	SchemaId string `protobuf:"bytes,1,opt,name=schema_id,json=schemaId,proto3" json:"schema_id,omitempty"`
+	// Version of the payload schema, incremented on changes which older
+	// consumers can't handle. Versions start at 1.
+	SchemaVersion uint32 `protobuf:"varint,2,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	// Encoding of the payload (for example, "application/x-protobuf").
+	ContentType string `protobuf:"bytes,3,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	// Time at which the producer wrapped the payload.
+	ProducedAt *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=produced_at,json=producedAt,proto3" json:"produced_at,omitempty"`
+	// Optional identifier of the trace the message was produced within,
+	// propagated to the consumers of the message.
+	TraceId string `protobuf:"bytes,5,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	// The encoded domain message.
+	Payload       []byte `protobuf:"bytes,6,opt,name=payload,proto3" json:"payload,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Envelope) Reset() {
+	*x = Envelope{}
+	mi := &file_envelope_v1_envelope_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Envelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Envelope) ProtoMessage() {}
+
+func (x *Envelope) ProtoReflect() protoreflect.Message {
+	mi := &file_envelope_v1_envelope_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Envelope.ProtoReflect.Descriptor instead.
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return file_envelope_v1_envelope_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Envelope) GetSchemaId() string {
+	if x != nil {
+		return x.SchemaId
+	}
+	return ""
+}
+
+func (x *Envelope) GetSchemaVersion() uint32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *Envelope) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *Envelope) GetProducedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ProducedAt
+	}
+	return nil
+}
+
+func (x *Envelope) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
+func (x *Envelope) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+var File_envelope_v1_envelope_proto protoreflect.FileDescriptor
+
+const file_envelope_v1_envelope_proto_rawDesc = "" +
+	"\n" +
+	"\x1aenvelope/v1/envelope.proto\x12\venvelope.v1\x1a\x1bbuf/validate/validate.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x98\x02\n" +
+	"\bEnvelope\x12(\n" +
+	"\tschema_id\x18\x01 \x01(\tB\v\xbaH\b\xc8\x01\x01r\x03\x18\x80\x02R\bschemaId\x12.\n" +
+	"\x0eschema_version\x18\x02 \x01(\rB\a\xbaH\x04*\x02 \x00R\rschemaVersion\x12.\n" +
+	"\fcontent_type\x18\x03 \x01(\tB\v\xbaH\b\xc8\x01\x01r\x03\x18\x80\x01R\vcontentType\x12C\n" +
+	"\vproduced_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampB\x06\xbaH\x03\xc8\x01\x01R\n" +
+	"producedAt\x12#\n" +
+	"\btrace_id\x18\x05 \x01(\tB\b\xbaH\x05r\x03\x18\x80\x01R\atraceId\x12\x18\n" +
+	"\apayload\x18\x06 \x01(\fR\apayloadB\xb5\x01\n" +
+	"\x0fcom.envelope.v1B\rEnvelopeProtoP\x01ZFgithub.com/marinrusu1997/chat/app/src/gen/proto/envelope/v1;envelopev1\xa2\x02\x03EXX\xaa\x02\vEnvelope.V1\xca\x02\vEnvelope\\V1\xe2\x02\x17Envelope\\V1\\GPBMetadata\xea\x02\fEnvelope::V1b\x06proto3"
+
+var (
+	file_envelope_v1_envelope_proto_rawDescOnce sync.Once
+	file_envelope_v1_envelope_proto_rawDescData []byte
+)
+
+func file_envelope_v1_envelope_proto_rawDescGZIP() []byte {
+	file_envelope_v1_envelope_proto_rawDescOnce.Do(func() {
+		file_envelope_v1_envelope_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_envelope_v1_envelope_proto_rawDesc), len(file_envelope_v1_envelope_proto_rawDesc)))
+	})
+	return file_envelope_v1_envelope_proto_rawDescData
+}
+
+var file_envelope_v1_envelope_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_envelope_v1_envelope_proto_goTypes = []any{
+	(*Envelope)(nil),              // 0: envelope.v1.Envelope
+	(*timestamppb.Timestamp)(nil), // 1: google.protobuf.Timestamp
+}
+var file_envelope_v1_envelope_proto_depIdxs = []int32{
+	1, // 0: envelope.v1.Envelope.produced_at:type_name -> google.protobuf.Timestamp
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_envelope_v1_envelope_proto_init() }
+func file_envelope_v1_envelope_proto_init() {
+	if File_envelope_v1_envelope_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_envelope_v1_envelope_proto_rawDesc), len(file_envelope_v1_envelope_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_envelope_v1_envelope_proto_goTypes,
+		DependencyIndexes: file_envelope_v1_envelope_proto_depIdxs,
+		MessageInfos:      file_envelope_v1_envelope_proto_msgTypes,
+	}.Build()
+	File_envelope_v1_envelope_proto = out.File
+	file_envelope_v1_envelope_proto_goTypes = nil
+	file_envelope_v1_envelope_proto_depIdxs = nil
+}
@@ -12,6 +12,8 @@ import (
 	"chat/src/clients/redis"
 	"chat/src/clients/scylla"
 	emailv1 "chat/src/gen/proto/email/v1"
+	"chat/src/platform/admin"
+	"chat/src/platform/components"
 	"chat/src/platform/config"
 	"chat/src/platform/health"
 	"chat/src/platform/lifecycle"
@@ -19,23 +21,40 @@ import (
 	"chat/src/platform/security"
 	"chat/src/platform/state"
 	emailsvc "chat/src/services/email"
+	"chat/src/services/fanout"
 	"chat/src/services/presence"
 	"context"
+	"flag"
 	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/wneessen/go-mail"
 	"go.yaml.in/yaml/v3"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //	@FIXME:	https://github.com/uber-go/guide/tree/master
 
+// smokeEmailRecipient uses the reserved .invalid TLD, so the smoke test email can't reach a real mailbox.
+const smokeEmailRecipient = "smoke-test@example.invalid"
+
+var smokeEmail = flag.Bool("smoke-email", false, "enqueue a single templated email on startup to check the email pipeline, ignored in production")
+
 func main() {
-	cfg, err := config.Load(config.LoadConfigOptions{
+	flag.Parse()
+
+	cfg, cfgProvenance, err := config.LoadWithProvenance(config.LoadConfigOptions{
 		YamlFilePaths: []string{"/etc/chat/config.yaml"},
 		EnvVarPrefix:  "CHAT_APP_",
 	})
@@ -44,6 +63,8 @@ func main() {
 	}
 
 	loggerFactory, err := logging.NewFactory(&logging.Options{
+		AppName:       cfg.Application.Name,
+		AppEnv:        cfg.Application.Environment,
 		AppInstanceID: cfg.Application.InstanceName,
 		AppVersion:    cfg.Application.Version,
 		AppCommit:     cfg.Application.Commit,
@@ -63,6 +84,12 @@ func main() {
 		logger.Fatal().Err(err).Msg("Failed to marshal config")
 	}
 	logger.Info().Msgf("Using config:\n%s", string(cfgBytes))
+	if debug := logger.Debug(); debug.Enabled() {
+		for _, key := range slices.Sorted(maps.Keys(cfgProvenance)) {
+			debug = debug.Str(key, cfgProvenance[key])
+		}
+		debug.Msg("Config values provenance")
+	}
 
 	// 4. Load TLS configs
 	tlsConfigs, err := security.LoadTLSConfigs(&security.TLSConfigSources{
@@ -153,20 +180,64 @@ func main() {
 		logger.Fatal().Err(err).Msg("Failed to create clients")
 	}
 
+	startupSummary := lifecycle.NewStartupSummary()
+
+	clientLifecycles := map[string]lifecycle.ServiceLifecycle{
+		elasticsearch.PingTargetName: clients.Elasticsearch,
+		kafka.AdminClientName:        clients.Kafka.Admin,
+		kafka.DataClientName:         clients.Kafka.Data,
+		neo4j.PingTargetName:         clients.Neo4j,
+		etcd.PingTargetName:          clients.Etcd,
+		postgresql.PingTargetName:    clients.PostgreSQL,
+		redis.PingTargetName:         clients.Redis,
+		scylla.PingTargetName:        clients.ScyllaDB,
+		nats.PingTargetName:          clients.Nats,
+		email.PingTargetName:         clients.Email,
+	}
+	clientHealthChecks := map[string]health.Pingable{
+		elasticsearch.PingTargetName: clients.Elasticsearch,
+		kafka.AdminClientName:        clients.Kafka.Admin,
+		kafka.DataClientName:         clients.Kafka.Data,
+		neo4j.PingTargetName:         clients.Neo4j,
+		etcd.PingTargetName:          clients.Etcd,
+		postgresql.PingTargetName:    clients.PostgreSQL,
+		redis.PingTargetName:         clients.Redis,
+		scylla.PingTargetName:        clients.ScyllaDB,
+		nats.PingTargetName:          clients.Nats,
+		email.PingTargetName:         clients.Email,
+	}
+	// TLS endpoints probed by the deep pings of the dependencies, for dependencies speaking TLS from the first byte
+	tlsProbes := map[string]struct {
+		address   string
+		tlsConfig string
+	}{
+		elasticsearch.PingTargetName: {urlHostPort(cfg.Elasticsearch.Addresses[0]), elasticsearch.PingTargetName},
+		etcd.PingTargetName:          {urlHostPort(cfg.Etcd.Endpoints[0]), etcd.PingTargetName},
+		kafka.AdminClientName:        {cfg.Kafka.SeedBrokers[0], kafka.PingTargetName},
+		redis.PingTargetName:         {cfg.Redis.Addresses[0], redis.PingTargetName},
+	}
+	for name, probe := range tlsProbes {
+		probed, err := health.WithTLSProbe(clientHealthChecks[name], &health.TLSProbeOptions{
+			Address: probe.address,
+			Config:  tlsConfigs.Services[probe.tlsConfig],
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msgf("Failed to create TLS probe for '%s'", name)
+		}
+		clientHealthChecks[name] = probed
+	}
+
+	if err := components.Validate(
+		components.KindClient, slices.Collect(maps.Keys(clientLifecycles)), slices.Collect(maps.Keys(clientHealthChecks)),
+	); err != nil {
+		logger.Fatal().Err(err).Msg("Client components are misconfigured")
+	}
+
 	clientsLifecycleController, err := lifecycle.NewController(&lifecycle.ControllerOptions{
-		Services: map[string]lifecycle.ServiceLifecycle{
-			elasticsearch.PingTargetName: clients.Elasticsearch,
-			kafka.AdminClientName:        clients.Kafka.Admin,
-			kafka.DataClientName:         clients.Kafka.Data,
-			neo4j.PingTargetName:         clients.Neo4j,
-			etcd.PingTargetName:          clients.Etcd,
-			postgresql.PingTargetName:    clients.PostgreSQL,
-			redis.PingTargetName:         clients.Redis,
-			scylla.PingTargetName:        clients.ScyllaDB,
-			nats.PingTargetName:          clients.Nats,
-			email.PingTargetName:         clients.Email,
-		},
-		Logger: loggerFactory.Child("lifecycle.clients"),
+		Services:     clientLifecycles,
+		Dependencies: state.ClientDependencies(),
+		OnEvent:      startupSummary.Record,
+		Logger:       loggerFactory.Child("lifecycle.clients"),
 	})
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to create clients lifecycle controller")
@@ -176,20 +247,18 @@ func main() {
 	}
 	defer clientsLifecycleController.Stop(context.Background())
 
+	// static group membership requires unique instance names, replicas sharing one would fence each other
+	instanceRegistration, err := clients.Etcd.RegisterInstance(
+		context.Background(), cfg.Kafka.GroupID, cfg.Application.InstanceName,
+	)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to register instance name")
+	}
+	defer instanceRegistration.Release(context.Background())
+
 	healthController, err := health.NewController(&health.ControllerConfig{
-		Dependencies: map[string]health.Pingable{
-			elasticsearch.PingTargetName: clients.Elasticsearch,
-			kafka.AdminClientName:        clients.Kafka.Admin,
-			kafka.DataClientName:         clients.Kafka.Data,
-			neo4j.PingTargetName:         clients.Neo4j,
-			etcd.PingTargetName:          clients.Etcd,
-			postgresql.PingTargetName:    clients.PostgreSQL,
-			redis.PingTargetName:         clients.Redis,
-			scylla.PingTargetName:        clients.ScyllaDB,
-			nats.PingTargetName:          clients.Nats,
-			email.PingTargetName:         clients.Email,
-		},
-		Logger: loggerFactory.Child("health.controller"),
+		Dependencies: clientHealthChecks,
+		Logger:       loggerFactory.Child("health.controller"),
 	})
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to create heath controller")
@@ -197,42 +266,142 @@ func main() {
 	healthController.Start()
 	defer healthController.Stop()
 
-	kafkaConsumerRouter, err := routing.NewConsumerRouter(&routing.ConsumerRouterOptions{
-		Client: clients.Kafka.Data,
-		Logger: loggerFactory.ChildPtr("kafka.consumer.router"),
-	})
+	kafkaConsumerRouter, err := routing.NewConsumerRouter(
+		state.ConsumerRouterOptions(&cfg.Kafka, clients.Kafka.Data, loggerFactory.ChildPtr("kafka.consumer.router")),
+	)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to create kafka consumer router")
 	}
 
+	adminServer, err := admin.NewServer(&admin.ServerOptions{
+		Address: cfg.Admin.Address,
+		Logger:  loggerFactory.ChildPtr(components.ServiceLogger(components.AdminServer)),
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create admin server")
+	}
+	adminServer.Handle("GET /readyz", readinessHandler(healthController))
+	adminServer.HandleJSON("/debug/router/estimator", func() any { return kafkaConsumerRouter.EstimatorSnapshot() })
+	adminServer.HandleJSON("/debug/lifecycle/durations", func() any { return startupSummary.Durations() })
+	adminServer.HandleJSON("/debug/router/stats", func() any { return kafkaConsumerRouter.Stats() })
+	adminServer.HandleJSON("/debug/email/pool", func() any { return clients.Email.Stats() })
+	adminServer.HandleJSON("/debug/kafka/metrics", func() any { return clients.Kafka.DataMetrics.Snapshot() })
+	adminServer.HandleJSON("/debug/scylla/metrics", func() any { return clients.ScyllaMetrics.Snapshot() })
+	adminServer.HandleJSONQuery("/debug/kafka/offsets", func(ctx context.Context) (any, error) {
+		return clients.Kafka.Data.DescribeGroupOffsets(ctx)
+	})
+	startupSummary.Bind(components.AdminServer, cfg.Admin.Address)
+
+	presenceService, err := presence.NewService(&presence.ServiceOptions{
+		RedisClient:       clients.Redis,
+		NatsClient:        clients.Nats,
+		SubjectPrefix:     cfg.Presence.SubjectPrefix,
+		BatchedHeartbeats: cfg.Presence.BatchedHeartbeats,
+		IdleThreshold:     cfg.Presence.IdleThreshold,
+		JetStream: presence.JetStreamOptions{
+			Enabled:      cfg.Presence.JetStream.Enabled,
+			StreamName:   cfg.Presence.JetStream.StreamName,
+			Retention:    cfg.Presence.JetStream.Retention,
+			ConsumerName: "presence-" + strings.NewReplacer(".", "-", " ", "-").Replace(cfg.Application.InstanceName),
+		},
+		Logger: loggerFactory.ChildPtr(components.ServiceLogger(components.PresenceService)),
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create presence service")
+	}
+	adminServer.HandleJSON("/debug/presence/heartbeats", func() any {
+		active := presenceService.ActiveHeartbeats()
+		return map[string]any{"count": len(active), "sessions": active}
+	})
+
+	fanoutService, err := fanout.NewService(&fanout.ServiceOptions{
+		Clients: fanout.ServiceClientsOptions{
+			Kafka:  clients.Kafka.Data,
+			Scylla: clients.ScyllaDB,
+		},
+		Topics: fanout.ServiceTopicsOptions{
+			GroupInbox:        cfg.Kafka.Topics.GroupInbox,
+			UserInbox:         cfg.Kafka.Topics.UserInbox,
+			UserNotifications: cfg.Kafka.Topics.UserNotifications,
+		},
+		Router:     kafkaConsumerRouter,
+		Membership: fanout.NewNeo4jMembershipResolver(clients.Neo4j),
+		Policy:     fanout.NewPresenceDeliveryPolicy(presenceService),
+		Logger:     loggerFactory.ChildPtr(components.ServiceLogger(components.FanoutService)),
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create fanout service")
+	}
+
 	services := state.Services{
-		Presence: presence.NewService(clients.Redis, clients.Nats, loggerFactory.ChildPtr("services.presence")),
+		Presence: presenceService,
 		Email: emailsvc.NewService(&emailsvc.ServiceOptions{
 			Clients: emailsvc.ServiceClientsOptions{
 				Email: clients.Email,
 				Kafka: clients.Kafka.Data,
+				Redis: clients.Redis,
 			},
 			EmailBuild: emailsvc.ServiceEmailBuildOptions{
-				From:         cfg.Email.From,
-				Organization: cfg.Email.Organization,
-				UserAgent:    cfg.Email.UserAgent,
-				DKIMCert:     &tlsConfigs.Global.Certificates[0],
+				From:           cfg.Email.From,
+				Organization:   cfg.Email.Organization,
+				UserAgent:      cfg.Email.UserAgent,
+				DKIMCert:       &tlsConfigs.Global.Certificates[0],
+				AllowedFrom:    cfg.Email.AllowedFrom,
+				TrustedSources: cfg.Email.TrustedSources,
+				TextEncoding:   mail.Encoding(cfg.Email.TextEncoding),
+				HTMLEncoding:   mail.Encoding(cfg.Email.HTMLEncoding),
 			},
 			KafkaDelivery: emailsvc.ServiceKafkaDeliveryOptions{
-				Topic:  cfg.Kafka.Topics.EmailDelivery,
-				Router: kafkaConsumerRouter,
+				Topic:     cfg.Kafka.Topics.EmailDelivery,
+				Router:    kafkaConsumerRouter,
+				BatchSize: cfg.Email.BatchSize,
+			},
+			Retry: emailsvc.ServiceRetryOptions{
+				Mode:        emailsvc.ParseRetryMode(cfg.Email.RetryMode),
+				Delay:       cfg.Email.RetryDelay,
+				MaxAttempts: cfg.Email.RetryMaxAttempts,
 			},
 			TemplatesLocation: cfg.Email.TemplatesLocation,
-			Logger:            loggerFactory.ChildPtr("services.email"),
+			RequiredTemplates: cfg.Email.RequiredTemplates,
+			DryRun:            cfg.Email.DryRun,
+			Logger:            loggerFactory.ChildPtr(components.ServiceLogger(components.EmailService)),
 		}),
+		Fanout: fanoutService,
+	}
+
+	serviceLifecycles := map[string]lifecycle.ServiceLifecycle{
+		components.PresenceService: services.Presence,
+		components.EmailService:    services.Email,
+		components.FanoutService:   services.Fanout,
+		components.AdminServer:     adminServer,
+	}
+	serviceHealthChecks := map[string]health.Pingable{
+		components.EmailService: health.Aggregate(
+			components.ServiceHealth(components.EmailService),
+			services.Email, clientHealthChecks[kafka.DataClientName],
+			kafkaConsumerRouter.MessageAgeCheck(cfg.Kafka.Topics.EmailDelivery),
+		),
+		components.FanoutService: health.Aggregate(
+			components.ServiceHealth(components.FanoutService),
+			clientHealthChecks[kafka.DataClientName], clientHealthChecks[neo4j.PingTargetName], clientHealthChecks[scylla.PingTargetName],
+			kafkaConsumerRouter.MessageAgeCheck(cfg.Kafka.Topics.GroupInbox),
+		),
+	}
+	if err := components.Validate(
+		components.KindService, slices.Collect(maps.Keys(serviceLifecycles)), slices.Collect(maps.Keys(serviceHealthChecks)),
+	); err != nil {
+		logger.Fatal().Err(err).Msg("Service components are misconfigured")
+	}
+	for name, check := range serviceHealthChecks {
+		if err := healthController.Register(components.ServiceHealth(name), check); err != nil {
+			logger.Fatal().Err(err).Msgf("Failed to register health check of service '%s'", name)
+		}
 	}
 
 	servicesLifecycleController, err := lifecycle.NewController(&lifecycle.ControllerOptions{
-		Services: map[string]lifecycle.ServiceLifecycle{
-			"presence": services.Presence,
-			"email":    services.Email,
-		},
-		Logger: loggerFactory.Child("lifecycle.services"),
+		Services: serviceLifecycles,
+		OnEvent:  startupSummary.Record,
+		Logger:   loggerFactory.Child("lifecycle.services"),
 	})
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to create services lifecycle controller")
@@ -242,11 +411,25 @@ func main() {
 	}
 	defer servicesLifecycleController.Stop(context.Background())
 
+	if err := routing.ValidateRouters(map[string]*routing.ConsumerRouter{
+		components.KafkaData: kafkaConsumerRouter,
+	}); err != nil {
+		logger.Fatal().Err(err).Msg("Kafka consumer routers are misconfigured")
+	}
 	if err := kafkaConsumerRouter.Start(); err != nil {
 		logger.Fatal().Err(err).Msg("Failed to start kafka consumer router")
 	}
 	defer kafkaConsumerRouter.Stop()
 
+	if cfg.Application.ReadinessTimeout > 0 {
+		readyCtx, cancelReady := context.WithTimeout(context.Background(), cfg.Application.ReadinessTimeout)
+		if err := healthController.WaitReady(readyCtx); err != nil {
+			logger.Warn().Err(err).Msg("Logging the startup summary while dependencies are not ready")
+		}
+		cancelReady()
+	}
+	startupSummary.Log(&logger, healthController)
+
 	//	@fixme	remove me
 	/*if err := routing.OrchestrateKafkaTest(
 		loggerFactory.ChildPtr("clients.kafka.example"), clients.Kafka.Admin, clients.Kafka.Data,
@@ -254,49 +437,125 @@ func main() {
 		panic(err)
 	}*/
 
-	emailRequest := emailv1.SendEmailRequest{
+	runSmokeEmail(*smokeEmail, cfg.Application.Environment, services.Email, &logger)
+
+	app := &application{router: kafkaConsumerRouter, health: healthController, logger: &logger}
+	app.waitForTermination()
+}
+
+// application holds what the process needs to react to the signals sent by the orchestrator.
+type application struct {
+	router *routing.ConsumerRouter
+	health *health.Controller
+	logger *zerolog.Logger
+}
+
+// Quiesce stops consuming new Kafka work and reports the application as draining, while health keeps being served,
+// so the replica finishes its in-flight work before it is terminated. Calling it again is a no-op.
+func (a *application) Quiesce() {
+	if a.health.Draining() {
+		return
+	}
+	a.router.Pause()
+	a.health.MarkDraining()
+	a.logger.Info().Msg("Application quiesced, waiting for termination")
+}
+
+// waitForTermination blocks until SIGINT or SIGTERM is received, quiescing the application on SIGUSR1.
+func (a *application) waitForTermination() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1)
+	defer signal.Stop(sigChan)
+
+	a.handleSignals(sigChan)
+}
+
+// handleSignals quiesces the application on each SIGUSR1 received, it returns once any other signal is received.
+func (a *application) handleSignals(signals <-chan os.Signal) {
+	for sig := range signals {
+		if sig != syscall.SIGUSR1 {
+			a.logger.Info().Msgf("Received %v, shutting down", sig)
+			return
+		}
+		a.Quiesce()
+	}
+}
+
+// readinessHandler serves /readyz, it answers 503 while the application isn't ready, with "draining" as body once
+// quiesced.
+func readinessHandler(controller *health.Controller) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if controller.Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("draining"))
+			return
+		}
+		if !controller.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// emailSender enqueues email requests, i.e. the email service.
+type emailSender interface {
+	Send(ctx context.Context, request *emailv1.SendEmailRequest) error
+}
+
+// runSmokeEmail sends the smoke test email when enabled by the --smoke-email flag, outside production.
+func runSmokeEmail(enabled bool, environment string, sender emailSender, logger *zerolog.Logger) {
+	if !enabled {
+		return
+	}
+	if environment == "production" {
+		logger.Warn().Msg("Ignoring --smoke-email, the smoke test email is never sent in production")
+		return
+	}
+	if err := sendSmokeEmail(sender); err != nil {
+		logger.Error().Err(err).Msg("Failed to enqueue smoke test email")
+		return
+	}
+	logger.Info().Msgf("Enqueued smoke test email to '%s'", smokeEmailRecipient)
+}
+
+// sendSmokeEmail enqueues a single email rendered from the "message" template to smokeEmailRecipient.
+func sendSmokeEmail(service emailSender) error {
+	request := emailv1.SendEmailRequest{
 		MessageId: uuid.New().String(),
 		CreatedAt: timestamppb.New(time.Now().UTC()),
 		Source: &emailv1.Source{
-			Service:     "auth-service",
-			Environment: "prod",
-			TraceId:     "a1b2c3",
+			Service:     "chat-app",
+			Environment: "smoke-test",
 		},
 		Email: &emailv1.Email{
 			To: []*emailv1.EmailAddress{
-				{Email: "user@example.com"},
+				{Email: smokeEmailRecipient},
 			},
-			Subject:     "Welcome to Example App",
+			Subject:     "Smoke test",
 			ContentMode: emailv1.ContentMode_CONTENT_MODE_TEMPLATE,
-			/*Raw: &emailv1.RawContent{
-				Text: "Welcome to Example App!",
-				Html: "<p>Welcome to <strong>Example App</strong>!</p>",
-			},*/
 			Template: &emailv1.TemplateContent{
 				TemplateId: "message",
-				// Locale:     proto.String("fr"),
 				Vars: map[string]string{
-					"NAME": "Alice",
+					"NAME": "Smoke Test",
 				},
 			},
 			InteractionMode: emailv1.InteractionMode_INTERACTION_MODE_AUTOMATED,
-			Importance:      emailv1.ImportanceLevel_IMPORTANCE_LEVEL_NORMAL,
+			Importance:      emailv1.ImportanceLevel_IMPORTANCE_LEVEL_LOW,
 		},
 	}
-
-	for i := 0; i < int(cfg.Email.QueueSize*2); i++ {
-		err := services.Email.Send(context.Background(), &emailRequest)
-		if err != nil {
-			logger.Error().Err(err).Msg("Failed to enqueue email")
-		}
-	}
-	//	@fixme	remove me
-
-	blockOnSignal(syscall.SIGINT, syscall.SIGTERM)
+	return service.Send(context.Background(), &request)
 }
 
-func blockOnSignal(signals ...os.Signal) {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, signals...)
-	<-sigChan
+// urlHostPort returns the host:port of an URL, defaulting the port by scheme.
+func urlHostPort(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if parsed.Port() != "" {
+		return parsed.Host
+	}
+	if parsed.Scheme == "https" {
+		return net.JoinHostPort(parsed.Hostname(), "443")
+	}
+	return net.JoinHostPort(parsed.Hostname(), "80")
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"chat/src/clients/kafka/kafkatest"
+	"chat/src/clients/kafka/routing"
+	emailv1 "chat/src/gen/proto/email/v1"
+	"chat/src/platform/health"
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// recordingSender records the email requests instead of enqueuing them.
+type recordingSender struct {
+	requests []*emailv1.SendEmailRequest
+	err      error
+}
+
+func (s *recordingSender) Send(_ context.Context, request *emailv1.SendEmailRequest) error {
+	s.requests = append(s.requests, request)
+	return s.err
+}
+
+func TestSmokeEmailFlagIsOffByDefault(t *testing.T) {
+	smokeFlag := flag.Lookup("smoke-email")
+	if smokeFlag == nil {
+		t.Fatal("--smoke-email flag isn't registered")
+	}
+	if smokeFlag.DefValue != "false" || *smokeEmail {
+		t.Fatalf("--smoke-email defaults to %s, want false", smokeFlag.DefValue)
+	}
+}
+
+func TestRunSmokeEmail(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		environment string
+		wantSent    bool
+		wantLog     string
+	}{
+		{name: "normal startup", enabled: false, environment: "development"},
+		{name: "normal startup in production", enabled: false, environment: "production"},
+		{name: "flag in production", enabled: true, environment: "production", wantLog: "never sent in production"},
+		{name: "flag outside production", enabled: true, environment: "staging", wantSent: true, wantLog: "Enqueued smoke test email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := zerolog.New(&logs)
+			sender := &recordingSender{}
+
+			runSmokeEmail(tt.enabled, tt.environment, sender, &logger)
+
+			if sent := len(sender.requests) > 0; sent != tt.wantSent {
+				t.Fatalf("sent %d smoke test emails, want sent: %t", len(sender.requests), tt.wantSent)
+			}
+			if tt.wantLog == "" && logs.Len() > 0 {
+				t.Errorf("logged %s, want nothing", logs.String())
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("logs = %s, want them to contain %q", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestSmokeEmailIsSentOnceToInvalidRecipient(t *testing.T) {
+	logger := zerolog.Nop()
+	sender := &recordingSender{}
+
+	runSmokeEmail(true, "development", sender, &logger)
+
+	if len(sender.requests) != 1 {
+		t.Fatalf("sent %d smoke test emails, want 1", len(sender.requests))
+	}
+	recipients := sender.requests[0].GetEmail().GetTo()
+	if len(recipients) != 1 || recipients[0].GetEmail() != smokeEmailRecipient {
+		t.Fatalf("smoke test email recipients = %v, want only %s", recipients, smokeEmailRecipient)
+	}
+	if !strings.HasSuffix(smokeEmailRecipient, ".invalid") {
+		t.Fatalf("smoke test recipient %s can reach a real mailbox", smokeEmailRecipient)
+	}
+}
+
+func TestSmokeEmailFailureIsLogged(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	runSmokeEmail(true, "development", &recordingSender{err: errors.New("broker unavailable")}, &logger)
+
+	if !strings.Contains(logs.String(), "broker unavailable") || !strings.Contains(logs.String(), `"level":"error"`) {
+		t.Fatalf("logs = %s, want the failure to be logged as an error", logs.String())
+	}
+}
+
+// healthyDependency always answers healthy pings.
+type healthyDependency struct{}
+
+func (healthyDependency) PingShallow(context.Context) health.PingResult {
+	return health.NewHealthyPingResult("dependency", health.PingDepthShallow)
+}
+
+func (healthyDependency) PingDeep(context.Context) health.PingResult {
+	return health.NewHealthyPingResult("dependency", health.PingDepthDeep)
+}
+
+// newTestApplication returns an application whose router consumes the "emails" topic and whose dependencies are
+// ready.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	router, err := routing.NewConsumerRouter(&routing.ConsumerRouterOptions{Client: kafkatest.NewClient(t, nil), Logger: &logger})
+	if err != nil {
+		t.Fatalf("NewConsumerRouter() error = %v", err)
+	}
+	router.OnRecordsFrom("emails", func([]*kgo.Record) error { return nil })
+
+	controller, err := health.NewController(&health.ControllerConfig{
+		Dependencies: map[string]health.Pingable{"dependency": healthyDependency{}},
+		Logger:       logger,
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	controller.Start()
+	t.Cleanup(controller.Stop)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := controller.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady() error = %v", err)
+	}
+
+	return &application{router: router, health: controller, logger: &logger}
+}
+
+func readyz(t *testing.T, app *application) (int, string) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	readinessHandler(app.health).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestQuiesceDrainThenTerminate(t *testing.T) {
+	app := newTestApplication(t)
+	if code, _ := readyz(t, app); code != http.StatusOK {
+		t.Fatalf("/readyz = %d before quiescing, want %d", code, http.StatusOK)
+	}
+
+	signals := make(chan os.Signal, 1)
+	terminated := make(chan struct{})
+	go func() {
+		defer close(terminated)
+		app.handleSignals(signals)
+	}()
+
+	// quiesce
+	signals <- syscall.SIGUSR1
+	deadline := time.Now().Add(2 * time.Second)
+	for !app.health.Draining() {
+		if time.Now().After(deadline) {
+			t.Fatal("application didn't quiesce on SIGUSR1")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// drain: consumption stops while health keeps being served
+	stats := app.router.Stats()
+	if !stats.Paused || !slices.Contains(stats.PausedTopics, "emails") {
+		t.Fatalf("router stats = %+v, want the consumption of emails to be paused", stats)
+	}
+	if code, body := readyz(t, app); code != http.StatusServiceUnavailable || body != "draining" {
+		t.Fatalf("/readyz = %d %q while draining, want %d \"draining\"", code, body, http.StatusServiceUnavailable)
+	}
+	if !app.health.Healthy() {
+		t.Fatal("Healthy() = false while draining, want the dependencies to keep being reported")
+	}
+	signals <- syscall.SIGUSR1 // quiescing again is a no-op
+	select {
+	case <-terminated:
+		t.Fatal("application terminated on SIGUSR1")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// terminate
+	signals <- syscall.SIGTERM
+	select {
+	case <-terminated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("application didn't terminate on SIGTERM")
+	}
+	if !app.health.Draining() || !app.router.Stats().Paused {
+		t.Fatal("application stopped draining before its termination")
+	}
+}
+
+func TestReadyzReportsUnreadyDependencies(t *testing.T) {
+	controller, err := health.NewController(&health.ControllerConfig{
+		Dependencies: map[string]health.Pingable{"dependency": healthyDependency{}},
+		Logger:       zerolog.Nop(),
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	app := &application{health: controller} // never checked
+
+	if code, body := readyz(t, app); code != http.StatusServiceUnavailable || body == "draining" {
+		t.Fatalf("/readyz = %d %q, want %d without draining", code, body, http.StatusServiceUnavailable)
+	}
+}
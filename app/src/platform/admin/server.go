@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"chat/src/platform/perr"
+	"chat/src/platform/validation"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/creasty/defaults"
+	"github.com/rs/zerolog"
+	"github.com/samber/oops"
+)
+
+// Server exposes read-only operational endpoints, it must not be reachable from outside the cluster.
+type Server struct {
+	mux      *http.ServeMux
+	server   *http.Server
+	listener net.Listener
+	served   chan struct{}
+	logger   *zerolog.Logger
+}
+
+type ServerOptions struct {
+	Address           string          `validate:"required,hostname_port"`
+	ReadHeaderTimeout time.Duration   `validate:"required,min=100000000,max=30000000000" default:"5s"`  // 100ms to 30s
+	WriteTimeout      time.Duration   `validate:"required,min=100000000,max=60000000000" default:"10s"` // 100ms to 60s
+	Logger            *zerolog.Logger `validate:"required"`
+}
+
+func NewServer(options *ServerOptions) (*Server, error) {
+	if err := defaults.Set(options); err != nil {
+		return nil, fmt.Errorf("failed to set config defaults: %w", err)
+	}
+	if err := validation.Instance.Struct(options); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	return &Server{
+		mux: mux,
+		server: &http.Server{
+			Addr:              options.Address,
+			Handler:           mux,
+			ReadHeaderTimeout: options.ReadHeaderTimeout,
+			WriteTimeout:      options.WriteTimeout,
+		},
+		logger: options.Logger,
+	}, nil
+}
+
+// Handle registers a handler, it must be called before Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleJSON registers a GET endpoint responding with the JSON encoding of the value returned by snapshot.
+func (s *Server) HandleJSON(path string, snapshot func() any) {
+	s.mux.HandleFunc("GET "+path, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(snapshot()); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to write admin response for '%s'", path)
+		}
+	})
+}
+
+// HandleJSONQuery registers a GET endpoint responding with the JSON encoding of the value returned by query, which
+// is bounded by the write timeout of the server. Errors are reported with the HTTP status of their perr code category.
+func (s *Server) HandleJSONQuery(path string, query func(ctx context.Context) (any, error)) {
+	s.mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.server.WriteTimeout)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/json")
+		value, err := query(ctx)
+		if err != nil {
+			code := perr.EIO
+			if oopsErr, ok := oops.AsOops(err); ok && oopsErr.Code() != "" {
+				code = oopsErr.Code()
+			}
+			w.WriteHeader(perr.CategoryOf(code).HTTPStatus())
+			value = map[string]string{"code": code, "error": err.Error()}
+		}
+		if err := json.NewEncoder(w).Encode(value); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to write admin response for '%s'", path)
+		}
+	})
+}
+
+func (s *Server) Start(_ context.Context) error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on admin address '%s': %w", s.server.Addr, err)
+	}
+	s.listener = listener
+	s.served = make(chan struct{})
+
+	go func() {
+		defer close(s.served)
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error().Err(err).Msg("Admin server stopped unexpectedly")
+		}
+	}()
+
+	s.logger.Info().Msgf("Admin server listening on '%s'", listener.Addr())
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) {
+	if s.listener == nil {
+		return
+	}
+
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to gracefully shutdown admin server")
+		_ = s.server.Close()
+	}
+	<-s.served
+	s.listener = nil
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	server, err := NewServer(&ServerOptions{Address: "127.0.0.1:9090", Logger: &logger})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	return server
+}
+
+// serveTestServer serves the endpoints of the server on a test listener, in place of its own.
+func serveTestServer(t *testing.T, server *Server) string {
+	t.Helper()
+
+	listener := httptest.NewServer(server.mux)
+	t.Cleanup(listener.Close)
+	return listener.URL
+}
+
+func TestHandleJSONServesSnapshot(t *testing.T) {
+	server := newTestServer(t)
+	calls := 0
+	server.HandleJSON("/debug/estimator", func() any {
+		calls++
+		return map[string]int{"samples": calls}
+	})
+	url := serveTestServer(t, server)
+
+	for want := 1; want <= 2; want++ {
+		response, err := http.Get(url + "/debug/estimator")
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		var body map[string]int
+		err = json.NewDecoder(response.Body).Decode(&body)
+		_ = response.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Header.Get("Content-Type") != "application/json" || body["samples"] != want {
+			t.Fatalf("GET = %v (%s), want a fresh snapshot with %d samples", body, response.Header.Get("Content-Type"), want)
+		}
+	}
+}
+
+func TestHandleJSONIsReadOnly(t *testing.T) {
+	server := newTestServer(t)
+	server.HandleJSON("/debug/estimator", func() any { return nil })
+	url := serveTestServer(t, server)
+
+	response, err := http.Post(url+"/debug/estimator", "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status = %d, want %d", response.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,119 @@
+package components
+
+import (
+	"chat/src/platform/perr"
+	"chat/src/util"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/samber/oops"
+)
+
+// Canonical names of the components managed by the lifecycle controllers. The same name identifies
+// a component in the lifecycle controller, the health controller and, prefixed by its kind, its logger.
+const (
+	Elasticsearch = "elasticsearch"
+	Etcd          = "etcd"
+	KafkaAdmin    = "kafka.admin"
+	KafkaData     = "kafka.data"
+	Email         = "email"
+	Nats          = "nats"
+	Neo4j         = "neo4j"
+	PostgreSQL    = "postgresql"
+	Redis         = "redis"
+	ScyllaDB      = "scylla"
+
+	PresenceService = "presence"
+	EmailService    = "email"
+	FanoutService   = "fanout"
+	AdminServer     = "admin"
+)
+
+type Kind uint8
+
+const (
+	KindClient Kind = iota
+	KindService
+)
+
+type component struct {
+	healthChecked bool
+}
+
+var registry = map[Kind]map[string]component{
+	KindClient: {
+		Elasticsearch: {healthChecked: true},
+		Etcd:          {healthChecked: true},
+		KafkaAdmin:    {healthChecked: true},
+		KafkaData:     {healthChecked: true},
+		Email:         {healthChecked: true},
+		Nats:          {healthChecked: true},
+		Neo4j:         {healthChecked: true},
+		PostgreSQL:    {healthChecked: true},
+		Redis:         {healthChecked: true},
+		ScyllaDB:      {healthChecked: true},
+	},
+	KindService: {
+		PresenceService: {},
+		EmailService:    {healthChecked: true},
+		FanoutService:   {healthChecked: true},
+		AdminServer:     {},
+	},
+}
+
+// ClientLogger returns the name of the logger of a client, sub-loggers append their own suffix to it.
+func ClientLogger(name string) string {
+	return "clients." + name
+}
+
+// ServiceLogger returns the name of the logger of a service.
+func ServiceLogger(name string) string {
+	return "services." + name
+}
+
+// ServiceHealth returns the name a service is health checked under, services may share their name with a client.
+func ServiceHealth(name string) string {
+	return "services." + name
+}
+
+// Validate checks the names given to the lifecycle and health controllers against the registry:
+// every name must be registered, every health checked component must be lifecycle managed and health checked,
+// while components which aren't health checked must not appear in the health controller.
+func Validate(kind Kind, lifecycleNames, healthNames []string) error {
+	errorb := oops.
+		In(util.GetFunctionName()).
+		Code(perr.ECONFIG)
+
+	registered := registry[kind]
+
+	var errs error
+	for _, name := range lifecycleNames {
+		entry, ok := registered[name]
+		if !ok {
+			errs = errors.Join(errs, fmt.Errorf("lifecycle managed component '%s' is not registered", name))
+			continue
+		}
+		if entry.healthChecked && !slices.Contains(healthNames, name) {
+			errs = errors.Join(errs, fmt.Errorf("lifecycle managed component '%s' has no health entry", name))
+		}
+	}
+	for _, name := range healthNames {
+		entry, ok := registered[name]
+		if !ok {
+			errs = errors.Join(errs, fmt.Errorf("health checked component '%s' is not registered", name))
+			continue
+		}
+		if !entry.healthChecked {
+			errs = errors.Join(errs, fmt.Errorf("component '%s' is not expected to be health checked", name))
+		}
+		if !slices.Contains(lifecycleNames, name) {
+			errs = errors.Join(errs, fmt.Errorf("health checked component '%s' is not lifecycle managed", name))
+		}
+	}
+
+	if errs != nil {
+		return errorb.Wrapf(errs, "component names mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package components
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAcceptsMatchingNames(t *testing.T) {
+	lifecycle := []string{Redis, Nats, Email}
+	health := []string{Nats, Email, Redis}
+	if err := Validate(KindClient, lifecycle, health); err != nil {
+		t.Fatalf("expected matching names to validate, got: %v", err)
+	}
+
+	if err := Validate(KindService, []string{PresenceService, EmailService, AdminServer}, []string{EmailService}); err != nil {
+		t.Fatalf("expected services which aren't health checked to be left out of the health names, got: %v", err)
+	}
+}
+
+func TestValidateDetectsNameMismatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      Kind
+		lifecycle []string
+		health    []string
+		expected  string
+	}{
+		{
+			name:      "health entry missing",
+			kind:      KindClient,
+			lifecycle: []string{Redis, Nats},
+			health:    []string{Redis},
+			expected:  "lifecycle managed component 'nats' has no health entry",
+		},
+		{
+			name:      "health name drifted",
+			kind:      KindClient,
+			lifecycle: []string{Redis},
+			health:    []string{"redis-client"},
+			expected:  "health checked component 'redis-client' is not registered",
+		},
+		{
+			name:      "lifecycle name not registered",
+			kind:      KindService,
+			lifecycle: []string{"presence-service"},
+			health:    nil,
+			expected:  "lifecycle managed component 'presence-service' is not registered",
+		},
+		{
+			name:      "health checked but not lifecycle managed",
+			kind:      KindClient,
+			lifecycle: nil,
+			health:    []string{ScyllaDB},
+			expected:  "health checked component 'scylla' is not lifecycle managed",
+		},
+		{
+			name:      "component not expected to be health checked",
+			kind:      KindService,
+			lifecycle: []string{PresenceService},
+			health:    []string{PresenceService},
+			expected:  "component 'presence' is not expected to be health checked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.kind, tt.lifecycle, tt.health)
+			if err == nil {
+				t.Fatal("expected the mismatch to be detected")
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected error to contain %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestLoggerNamesArePrefixedByKind(t *testing.T) {
+	if name := ClientLogger(KafkaData); name != "clients.kafka.data" {
+		t.Fatalf("unexpected client logger name '%s'", name)
+	}
+	if name := ServiceLogger(EmailService); name != "services.email" {
+		t.Fatalf("unexpected service logger name '%s'", name)
+	}
+	if name := ServiceHealth(EmailService); name != "services.email" || name == Email {
+		t.Fatalf("unexpected service health name '%s', want it to be distinct from the client name", name)
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"chat/src/util"
+	"time"
 )
 
 type CredentialsConfig struct {
@@ -23,10 +24,16 @@ type EtcdConfig struct {
 type PostgreSQLConfig struct {
 	CredentialsConfig `koanf:",squash"`
 	TLSPathsConfig    `koanf:",squash"`
-	Host              string            `koanf:"host" validate:"required,hostname|ip"`
-	Port              uint16            `koanf:"port" validate:"required,port"`
-	DBName            string            `koanf:"dbname" validate:"required,min=4,max=64"`
-	Options           map[string]string `koanf:"options" validate:"dive,keys,required,min=4,max=64,endkeys,required,min=1,max=64"`
+	Host              string                 `koanf:"host" validate:"required,hostname|ip"`
+	Port              uint16                 `koanf:"port" validate:"required,port"`
+	DBName            string                 `koanf:"dbname" validate:"required,min=4,max=64"`
+	Options           map[string]string      `koanf:"options" validate:"dive,keys,required,min=4,max=64,endkeys,required,min=1,max=64"`
+	Health            PostgreSQLHealthConfig `koanf:"health"`
+}
+
+type PostgreSQLHealthConfig struct {
+	ReplicationLag time.Duration `koanf:"replication_lag" validate:"required,min=1000000000,max=3600000000000" default:"10s"` // 1s to 1h
+	Connections    int           `koanf:"connections" validate:"required,min=1,max=100000" default:"200"`
 }
 
 type ScyllaDBConfig struct {
@@ -36,6 +43,23 @@ type ScyllaDBConfig struct {
 	ShardAwarePort    uint16   `koanf:"shard_aware_port" validate:"required,port"`
 	LocalDC           string   `koanf:"local_dc" validate:"omitempty,min=3,max=64,alphanum"`
 	Keyspace          string   `koanf:"keyspace" validate:"required,min=4,max=64"`
+	Compression       string   `koanf:"compression" validate:"oneof=snappy lz4 none" default:"snappy"`
+	// SlowQuery is the latency above which query attempts are logged, by default they aren't.
+	SlowQuery time.Duration `koanf:"slow_query" validate:"omitempty,min=1000000,max=60000000000"` // 1ms to 60s
+	// Retries and SpeculativeExecution are disabled by default.
+	Retries              ScyllaDBRetriesConfig     `koanf:"retries"`
+	SpeculativeExecution ScyllaDBSpeculativeConfig `koanf:"speculative_execution"`
+}
+
+type ScyllaDBRetriesConfig struct {
+	NumRetries int           `koanf:"num_retries" validate:"min=0,max=10"`
+	MinBackoff time.Duration `koanf:"min_backoff" validate:"min=1000000,max=10000000000" default:"100ms"`                  // 1ms to 10s
+	MaxBackoff time.Duration `koanf:"max_backoff" validate:"min=1000000,max=60000000000,gtefield=MinBackoff" default:"2s"` // 1ms to 60s
+}
+
+type ScyllaDBSpeculativeConfig struct {
+	Attempts int           `koanf:"attempts" validate:"min=0,max=5"`
+	Delay    time.Duration `koanf:"delay" validate:"min=1000000,max=10000000000" default:"50ms"` // 1ms to 10s, i.e. the p99 latency
 }
 
 type RedisConfig struct {
@@ -47,9 +71,16 @@ type RedisConfig struct {
 type ElasticsearchConfig struct {
 	CredentialsConfig `koanf:",squash"`
 	TLSPathsConfig    `koanf:",squash"`
-	Addresses         []string `koanf:"addresses" validate:"required,min=1,max=10,unique,dive,required,http_url|https_url"`
-	ShouldLogReq      bool     `koanf:"should_log_req"`
-	ShouldLogRes      bool     `koanf:"should_log_res"`
+	Addresses         []string                  `koanf:"addresses" validate:"required,min=1,max=10,unique,dive,required,http_url|https_url"`
+	ShouldLogReq      bool                      `koanf:"should_log_req"`
+	ShouldLogRes      bool                      `koanf:"should_log_res"`
+	Health            ElasticsearchHealthConfig `koanf:"health"`
+}
+
+type ElasticsearchHealthConfig struct {
+	PendingTasks          int           `koanf:"pending_tasks" validate:"required,min=1,max=100000" default:"100"`
+	InFlightFetches       int           `koanf:"in_flight_fetches" validate:"required,min=1,max=100000" default:"50"`
+	TaskMaxWaitingInQueue time.Duration `koanf:"task_max_waiting_in_queue" validate:"required,min=100000000,max=600000000000" default:"2s"` // 100ms to 10min
 }
 
 type Neo4jConfig struct {
@@ -60,31 +91,62 @@ type Neo4jConfig struct {
 }
 
 type NatsConfig struct {
-	CredentialsConfig `koanf:",squash"`
-	TLSPathsConfig    `koanf:",squash"`
-	Servers           []string `koanf:"servers" validate:"required,min=1,max=10,unique,dive,required,uri,startswith=nats"`
+	CredentialsConfig   `koanf:",squash"`
+	TLSPathsConfig      `koanf:",squash"`
+	Servers             []string          `koanf:"servers" validate:"required,min=1,max=10,unique,dive,required,uri,startswith=nats"`
+	AddressTranslations map[string]string `koanf:"address_translations" validate:"max=100,dive,keys,required,endkeys,required,hostname_port"`
+	AddressCollisions   string            `koanf:"address_collisions" validate:"oneof=first_wins last_wins error" default:"first_wins"` // translations mapping the same address differently
 }
 
 type EmailConfig struct {
 	CredentialsConfig `koanf:",squash"`
 	TLSPathsConfig    `koanf:",squash"`
-	SMTPHost          string `koanf:"smtp_host" validate:"required,hostname|ip"`
-	SMTPPort          uint16 `koanf:"smtp_port" validate:"required,port"`
-	FromAddress       string `koanf:"from_address" validate:"required,email"`
-	NumWorkers        uint8  `koanf:"num_workers" validate:"required,min=1,max=100"`
-	QueueSize         uint16 `koanf:"queue_size" validate:"required,min=1,max=1000"`
-	From              string `koanf:"from" validate:"required,email"`
-	Organization      string `koanf:"organization" validate:"required,min=2,max=100,printascii"`
-	UserAgent         string `koanf:"user_agent" validate:"required,min=4,max=100,printascii"`
-	TemplatesLocation string `koanf:"templates_location" validate:"required,min=4,max=256,dirpath"`
+	SMTPHost          string        `koanf:"smtp_host" validate:"required,hostname|ip"`
+	SMTPPort          uint16        `koanf:"smtp_port" validate:"required,port"`
+	FromAddress       string        `koanf:"from_address" validate:"required,email"`
+	NumWorkers        uint8         `koanf:"num_workers" validate:"required,min=1,max=100" default:"4"`
+	MaxWorkers        uint8         `koanf:"max_workers" validate:"omitempty,gtefield=NumWorkers,max=100"`                           // enables on demand workers when greater than NumWorkers
+	WorkerIdleTimeout time.Duration `koanf:"worker_idle_timeout" validate:"required,min=10000000000,max=3600000000000" default:"1m"` // 10s to 1h
+	QueueSize         uint16        `koanf:"queue_size" validate:"required,min=1,max=1000" default:"100"`
+	From              string        `koanf:"from" validate:"required,email"`
+	AllowedFrom       []string      `koanf:"allowed_from" validate:"max=100,unique,dive,required,email|fqdn"`
+	TrustedSources    []string      `koanf:"trusted_sources" validate:"max=20,unique,dive,required,min=2,max=64"`
+	Organization      string        `koanf:"organization" validate:"required,min=2,max=100,printascii"`
+	UserAgent         string        `koanf:"user_agent" validate:"required,min=4,max=100,printascii"`
+	TemplatesLocation string        `koanf:"templates_location" validate:"required,min=4,max=256,dirpath"`
+	RequiredTemplates []string      `koanf:"required_templates" validate:"max=50,unique,dive,required,min=1,max=64,excludesall=/\\"`
+	MaxMessageSize    int           `koanf:"max_message_size" validate:"required,min=1024,max=104857600" default:"26214400"` // 1KiB to 100MiB
+	TextEncoding      string        `koanf:"text_encoding" validate:"omitempty,oneof=7bit 8bit quoted-printable base64"`
+	HTMLEncoding      string        `koanf:"html_encoding" validate:"omitempty,oneof=7bit 8bit quoted-printable base64"`
+	DryRun            bool          `koanf:"dry_run"`                                                                        // render consumed email requests without sending them
+	RetryMode         string        `koanf:"retry_mode" validate:"oneof=pause queue" default:"pause"`                        // queue moves requests which can't be sent at the moment to Redis
+	RetryDelay        time.Duration `koanf:"retry_delay" validate:"required,min=1000000000,max=3600000000000" default:"30s"` // 1s to 1h
+	RetryMaxAttempts  int           `koanf:"retry_max_attempts" validate:"required,min=1,max=100" default:"10"`              // retries of a queued request before it's dead lettered
+	BatchSize         int           `koanf:"batch_size" validate:"min=0,max=100"`                                            // sends consumed emails in batches over one SMTP session when greater than 1
 }
 
 type KafkaConfig struct {
-	TLSPathsConfig `koanf:",squash"`
-	SeedBrokers    []string          `koanf:"seed_brokers" validate:"required,min=1,max=10,unique,dive,required,hostname_port"`
-	Users          KafkaUsers        `koanf:"users" validate:"required"`
-	Topics         KafkaConfigTopics `koanf:"topics" validate:"required"`
-	GroupID        string            `koanf:"group_id" validate:"required,min=4,max=64,printascii,lowercase"`
+	TLSPathsConfig      `koanf:",squash"`
+	SeedBrokers         []string          `koanf:"seed_brokers" validate:"required,min=1,max=10,unique,dive,required,hostname_port"`
+	Users               KafkaUsers        `koanf:"users" validate:"required"`
+	Topics              KafkaConfigTopics `koanf:"topics" validate:"required"`
+	GroupID             string            `koanf:"group_id" validate:"required,min=4,max=64,printascii,lowercase"`
+	ConsumeResetPolicy  string            `koanf:"consume_reset_policy" validate:"required,oneof=earliest latest" default:"earliest"`
+	EmailResetPolicy    string            `koanf:"email_reset_policy" validate:"required,oneof=earliest latest" default:"latest"` // for the email topic without committed offsets, latest doesn't resend historical emails
+	AddressTranslations map[string]string `koanf:"address_translations" validate:"max=100,dive,keys,required,endkeys,required,hostname_port"`
+	AddressCollisions   string            `koanf:"address_collisions" validate:"oneof=first_wins last_wins error" default:"first_wins"` // translations mapping the same address differently
+	MessageAgeThreshold time.Duration     `koanf:"message_age_threshold" validate:"omitempty,min=1000000000,max=86400000000000"`        // 1s to 24h, processed records older than it degrade health
+	DataLossPolicy      string            `koanf:"data_loss_policy" validate:"oneof=continue unhealthy" default:"continue"`             // unhealthy reports producer data loss to the health controller
+	Router              KafkaRouterConfig `koanf:"router"`
+}
+
+type KafkaRouterConfig struct {
+	MinHandlerTimeout  time.Duration `koanf:"min_handler_timeout" validate:"required,min=100000000,max=1000000000" default:"500ms"`                          // 100ms to 1s
+	MaxHandlerTimeout  time.Duration `koanf:"max_handler_timeout" validate:"required,min=1000000000,max=10000000000,gtfield=MinHandlerTimeout" default:"5s"` // 1s to 10s
+	HandlerConcurrency int64         `koanf:"handler_concurrency" validate:"required,min=1,max=1000" default:"100"`                                          // batches handled at once
+	MaxPollRecords     int           `koanf:"max_poll_records" validate:"required,min=1,max=100000" default:"500"`                                           // records handed to handlers per poll
+	BackpressurePause  time.Duration `koanf:"backpressure_pause" validate:"required,min=100000000,max=30000000000" default:"1s"`                             // 100ms to 30s
+	RevokeTimeout      time.Duration `koanf:"revoke_timeout" validate:"required,min=1000000000,max=50000000000" default:"10s"`                               // 1s to 50s
 }
 
 type KafkaUsers struct {
@@ -93,7 +155,27 @@ type KafkaUsers struct {
 }
 
 type KafkaConfigTopics struct {
-	EmailDelivery string `koanf:"email_delivery" validate:"required,min=4,max=64,printascii,lowercase"`
+	EmailDelivery     string `koanf:"email_delivery" validate:"required,min=4,max=64,printascii,lowercase"`
+	UserInbox         string `koanf:"user_inbox" validate:"required,min=4,max=64,printascii,lowercase"`
+	GroupInbox        string `koanf:"group_inbox" validate:"required,min=4,max=64,printascii,lowercase"`
+	UserNotifications string `koanf:"user_notifications" validate:"required,min=4,max=64,printascii,lowercase"`
+}
+
+type PresenceConfig struct {
+	SubjectPrefix     string                  `koanf:"subject_prefix" validate:"omitempty,max=64,printascii"`
+	BatchedHeartbeats bool                    `koanf:"batched_heartbeats"`
+	IdleThreshold     time.Duration           `koanf:"idle_threshold" validate:"omitempty,min=60000000000,max=86400000000000"` // 1min to 24h
+	JetStream         PresenceJetStreamConfig `koanf:"jetstream"`
+}
+
+type PresenceJetStreamConfig struct {
+	Enabled    bool          `koanf:"enabled"`
+	StreamName string        `koanf:"stream_name" validate:"required,min=1,max=64,alphanum" default:"PRESENCE"`
+	Retention  time.Duration `koanf:"retention" validate:"required,min=10000000000,max=3600000000000" default:"5m"` // 10s to 1h
+}
+
+type AdminConfig struct {
+	Address string `koanf:"address" validate:"required,hostname_port" default:"127.0.0.1:8081"`
 }
 
 type LoggingConfig struct {
@@ -104,12 +186,14 @@ type LoggingConfig struct {
 }
 
 type ApplicationConfig struct {
-	TLSPathsConfig `koanf:",squash"`
-	Name           string
-	InstanceName   string
-	Version        string
-	Commit         string
-	BuildTime      string
+	TLSPathsConfig   `koanf:",squash"`
+	Environment      string        `koanf:"environment" validate:"required,oneof=development staging production" default:"development"`
+	ReadinessTimeout time.Duration `koanf:"readiness_timeout" validate:"omitempty,min=1000000000,max=600000000000"` // 1s to 10min, 0 doesn't wait for readiness on startup
+	Name             string
+	InstanceName     string
+	Version          string
+	Commit           string
+	BuildTime        string
 }
 
 type Config struct {
@@ -123,5 +207,7 @@ type Config struct {
 	Nats          NatsConfig          `koanf:"nats" validate:"required"`
 	Email         EmailConfig         `koanf:"email" validate:"required"`
 	Kafka         KafkaConfig         `koanf:"kafka" validate:"required"`
+	Presence      PresenceConfig      `koanf:"presence"`
+	Admin         AdminConfig         `koanf:"admin"`
 	Logging       LoggingConfig       `koanf:"logging" validate:"required"`
 }
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"chat/src/platform/validation"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creasty/defaults"
+)
+
+func TestValidationErrorsNameConfigKeyPaths(t *testing.T) {
+	var cfg Config
+	if err := defaults.Set(&cfg); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	cfg.PostgreSQL.Username = "chat-user" // the password of the squashed credentials is left unset
+	cfg.PostgreSQL.Host = "postgres"
+	cfg.Kafka.SeedBrokers = []string{"kafka:9092", "not a broker"}
+
+	err := validation.AggregateKeyPaths(validation.Instance.Struct(&cfg), &cfg, "koanf")
+
+	var aggregateError *validation.AggregateError
+	if !errors.As(err, &aggregateError) {
+		t.Fatalf("AggregateKeyPaths() = %v, want an *AggregateError", err)
+	}
+	for _, want := range []string{"postgresql.password", "kafka.seed_brokers[1]"} {
+		if !slices.Contains(aggregateError.KeyPaths, want) {
+			t.Errorf("key paths %v don't contain '%s'", aggregateError.KeyPaths, want)
+		}
+		if !strings.Contains(err.Error(), "\n  - "+want+": failed '") {
+			t.Errorf("message doesn't name '%s':\n%s", want, err)
+		}
+	}
+	for _, keyPath := range aggregateError.KeyPaths {
+		if strings.ContainsAny(keyPath, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+			t.Errorf("key path '%s' names a struct field instead of a config key", keyPath)
+		}
+	}
+}
+
+func TestConsumeResetPolicyIsValidated(t *testing.T) {
+	var cfg Config
+	if err := defaults.Set(&cfg); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	if cfg.Kafka.ConsumeResetPolicy != "earliest" {
+		t.Fatalf("default consume reset policy = '%s', want 'earliest'", cfg.Kafka.ConsumeResetPolicy)
+	}
+	cfg.Kafka.ConsumeResetPolicy = "newest"
+
+	err := validation.AggregateKeyPaths(validation.Instance.Struct(&cfg), &cfg, "koanf")
+
+	var aggregateError *validation.AggregateError
+	if !errors.As(err, &aggregateError) {
+		t.Fatalf("AggregateKeyPaths() = %v, want an *AggregateError", err)
+	}
+	if !slices.Contains(aggregateError.KeyPaths, "kafka.consume_reset_policy") {
+		t.Errorf("key paths %v don't contain 'kafka.consume_reset_policy'", aggregateError.KeyPaths)
+	}
+}
+
+func TestEmailResetPolicyDefaultsToLatest(t *testing.T) {
+	var cfg Config
+	if err := defaults.Set(&cfg); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	if cfg.Kafka.EmailResetPolicy != "latest" {
+		t.Fatalf("default email reset policy = '%s', want 'latest', a fresh group must not resend historical emails", cfg.Kafka.EmailResetPolicy)
+	}
+	cfg.Kafka.EmailResetPolicy = "committed"
+
+	err := validation.AggregateKeyPaths(validation.Instance.Struct(&cfg), &cfg, "koanf")
+
+	var aggregateError *validation.AggregateError
+	if !errors.As(err, &aggregateError) {
+		t.Fatalf("AggregateKeyPaths() = %v, want an *AggregateError", err)
+	}
+	if !slices.Contains(aggregateError.KeyPaths, "kafka.email_reset_policy") {
+		t.Errorf("key paths %v don't contain 'kafka.email_reset_policy'", aggregateError.KeyPaths)
+	}
+}
+
+func TestEmailWorkerPoolDefaultsAndRejectsZeroWorkers(t *testing.T) {
+	var cfg Config
+	if err := defaults.Set(&cfg); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	if cfg.Email.NumWorkers != 4 || cfg.Email.QueueSize != 100 {
+		t.Fatalf("default email pool = %d workers and a queue of %d, want 4 and 100", cfg.Email.NumWorkers, cfg.Email.QueueSize)
+	}
+	cfg.Email.NumWorkers = 0 // set explicitly by a config file
+
+	err := validation.AggregateKeyPaths(validation.Instance.Struct(&cfg), &cfg, "koanf")
+
+	var aggregateError *validation.AggregateError
+	if !errors.As(err, &aggregateError) {
+		t.Fatalf("AggregateKeyPaths() = %v, want an *AggregateError", err)
+	}
+	if !slices.Contains(aggregateError.KeyPaths, "email.num_workers") {
+		t.Errorf("key paths %v don't contain 'email.num_workers'", aggregateError.KeyPaths)
+	}
+}
+
+func TestScyllaCompressionDefaultsToSnappyAndIsValidated(t *testing.T) {
+	var cfg Config
+	if err := defaults.Set(&cfg); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	if cfg.ScyllaDB.Compression != "snappy" {
+		t.Fatalf("default scylla compression = '%s', want 'snappy'", cfg.ScyllaDB.Compression)
+	}
+	cfg.ScyllaDB.Compression = "zstd"
+
+	err := validation.AggregateKeyPaths(validation.Instance.Struct(&cfg), &cfg, "koanf")
+
+	var aggregateError *validation.AggregateError
+	if !errors.As(err, &aggregateError) {
+		t.Fatalf("AggregateKeyPaths() = %v, want an *AggregateError", err)
+	}
+	if !slices.Contains(aggregateError.KeyPaths, "scylladb.compression") {
+		t.Errorf("key paths %v don't contain 'scylladb.compression'", aggregateError.KeyPaths)
+	}
+}
+
+func TestScyllaResiliencyIsValidated(t *testing.T) {
+	tests := []struct {
+		name        string
+		configure   func(*ScyllaDBConfig)
+		wantKeyPath string
+	}{
+		{
+			name:        "too many retries",
+			configure:   func(scylla *ScyllaDBConfig) { scylla.Retries.NumRetries = 11 },
+			wantKeyPath: "scylladb.retries.num_retries",
+		},
+		{
+			name: "max backoff below min backoff",
+			configure: func(scylla *ScyllaDBConfig) {
+				scylla.Retries.MinBackoff, scylla.Retries.MaxBackoff = time.Second, 100*time.Millisecond
+			},
+			wantKeyPath: "scylladb.retries.max_backoff",
+		},
+		{
+			name:        "too many speculative attempts",
+			configure:   func(scylla *ScyllaDBConfig) { scylla.SpeculativeExecution.Attempts = 6 },
+			wantKeyPath: "scylladb.speculative_execution.attempts",
+		},
+		{
+			name:        "speculative delay below 1ms",
+			configure:   func(scylla *ScyllaDBConfig) { scylla.SpeculativeExecution.Delay = time.Microsecond },
+			wantKeyPath: "scylladb.speculative_execution.delay",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			if err := defaults.Set(&cfg); err != nil {
+				t.Fatalf("defaults.Set() error = %v", err)
+			}
+			if cfg.ScyllaDB.Retries.NumRetries != 0 || cfg.ScyllaDB.SpeculativeExecution.Attempts != 0 {
+				t.Fatalf("default scylla resiliency = %+v, %+v, want retries and speculative execution disabled",
+					cfg.ScyllaDB.Retries, cfg.ScyllaDB.SpeculativeExecution)
+			}
+			tt.configure(&cfg.ScyllaDB)
+
+			err := validation.AggregateKeyPaths(validation.Instance.Struct(&cfg), &cfg, "koanf")
+
+			var aggregateError *validation.AggregateError
+			if !errors.As(err, &aggregateError) {
+				t.Fatalf("AggregateKeyPaths() = %v, want an *AggregateError", err)
+			}
+			if !slices.Contains(aggregateError.KeyPaths, tt.wantKeyPath) {
+				t.Errorf("key paths %v don't contain '%s'", aggregateError.KeyPaths, tt.wantKeyPath)
+			}
+		})
+	}
+}
+
+func TestKafkaRouterIsValidated(t *testing.T) {
+	var cfg Config
+	if err := defaults.Set(&cfg); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	cfg.Kafka.Router.MaxHandlerTimeout = 100 * time.Millisecond // below the min handler timeout
+	cfg.Kafka.Router.MaxPollRecords = 100_001
+
+	err := validation.AggregateKeyPaths(validation.Instance.Struct(&cfg), &cfg, "koanf")
+
+	var aggregateError *validation.AggregateError
+	if !errors.As(err, &aggregateError) {
+		t.Fatalf("AggregateKeyPaths() = %v, want an *AggregateError", err)
+	}
+	for _, want := range []string{"kafka.router.max_handler_timeout", "kafka.router.max_poll_records"} {
+		if !slices.Contains(aggregateError.KeyPaths, want) {
+			t.Errorf("key paths %v don't contain '%s'", aggregateError.KeyPaths, want)
+		}
+	}
+}
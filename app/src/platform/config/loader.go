@@ -1,7 +1,9 @@
 package config
 
 import (
+	"chat/src/platform/perr"
 	"chat/src/platform/validation"
+	"fmt"
 	"os"
 	"strings"
 
@@ -18,12 +20,18 @@ type LoadConfigOptions struct {
 	EnvVarPrefix  string
 }
 
-var koanfG = koanf.NewWithConf(koanf.Conf{
-	Delim:       ".",
-	StrictMerge: true,
-})
+// Provenance maps each config key set by a source to the last source which set it, "file:<path>" or "env:<name>".
+// Keys missing from it have their default value.
+type Provenance map[string]string
 
 func Load(options LoadConfigOptions) (*Config, error) {
+	cfg, _, err := LoadWithProvenance(options)
+	return cfg, err
+}
+
+// LoadWithProvenance loads the config like Load, also reporting where the value of each key came from, i.e. to
+// diagnose environment variables overriding the values of the files.
+func LoadWithProvenance(options LoadConfigOptions) (*Config, Provenance, error) {
 	errorBuilder := oops.
 		In("config").
 		Tags("loader")
@@ -32,42 +40,27 @@ func Load(options LoadConfigOptions) (*Config, error) {
 
 	// 1. Set defaults
 	if err := defaults.Set(&cfg); err != nil {
-		return nil, errorBuilder.Wrapf(err, "failed to set config defaults")
+		return nil, nil, errorBuilder.Wrapf(err, "failed to set config defaults")
 	}
 
 	// 2. Load config
-	for _, path := range options.YamlFilePaths {
-		if err := koanfG.Load(file.Provider(path), yaml.Parser()); err != nil {
-			return nil, errorBuilder.Wrapf(err, "failed to load config file %s", path)
-		}
-	}
-
-	err := koanfG.Load(env.Provider(".", env.Opt{
-		Prefix: options.EnvVarPrefix,
-		TransformFunc: func(k, v string) (string, any) {
-			k = strings.TrimPrefix(k, options.EnvVarPrefix)
-			k = strings.NewReplacer("__", "_", "_", ".").Replace(k)
-			k = strings.ToLower(k)
-			return k, v
-		},
-	}), nil)
+	layers, provenance, err := loadLayers(options)
 	if err != nil {
-		return nil, errorBuilder.Wrapf(err, "failed to load environment variables")
+		return nil, nil, errorBuilder.Wrap(err)
 	}
-
-	if err := koanfG.Unmarshal("", &cfg); err != nil {
-		return nil, errorBuilder.Wrapf(err, "failed to unmarshal config")
+	if err := layers.Unmarshal("", &cfg); err != nil {
+		return nil, nil, errorBuilder.Wrapf(err, "failed to unmarshal config")
 	}
 
 	// 3. Validate config
 	if err := validation.Instance.Struct(&cfg); err != nil {
-		return nil, errorBuilder.Wrapf(err, "failed to validate config")
+		return nil, nil, errorBuilder.Code(perr.ECONFIG).Wrapf(validation.AggregateKeyPaths(err, &cfg, "koanf"), "failed to validate config")
 	}
 
 	// 4. Add dynamic config
 	hostname, err := os.Hostname()
 	if err != nil {
-		return nil, errorBuilder.Wrapf(err, "failed to get hostname")
+		return nil, nil, errorBuilder.Wrapf(err, "failed to get hostname")
 	}
 	cfg.Application.Name = "chat-app"
 	cfg.Application.InstanceName = hostname
@@ -75,7 +68,47 @@ func Load(options LoadConfigOptions) (*Config, error) {
 	cfg.Application.Commit = getEnv("BUILD_COMMIT", "unknown")
 	cfg.Application.BuildTime = getEnv("BUILD_TIME", "unknown")
 
-	return &cfg, nil
+	return &cfg, provenance, nil
+}
+
+// loadLayers merges the files, in order, then the environment variables, each overriding the values of the previous
+// ones, and tracks which of them set each key.
+func loadLayers(options LoadConfigOptions) (*koanf.Koanf, Provenance, error) {
+	merged := koanf.NewWithConf(koanf.Conf{
+		Delim:       ".",
+		StrictMerge: true,
+	})
+	provenance := make(Provenance)
+
+	for _, path := range options.YamlFilePaths {
+		// each file is loaded in its own layer as well, its keys tell which values the file sets
+		layer := koanf.New(".")
+		if err := layer.Load(file.Provider(path), yaml.Parser()); err != nil {
+			return nil, nil, fmt.Errorf("failed to load config file %s: %w", path, err)
+		}
+		if err := merged.Merge(layer); err != nil {
+			return nil, nil, fmt.Errorf("failed to merge config file %s: %w", path, err)
+		}
+		for _, key := range layer.Keys() {
+			provenance[key] = "file:" + path
+		}
+	}
+
+	err := merged.Load(env.Provider(".", env.Opt{
+		Prefix: options.EnvVarPrefix,
+		TransformFunc: func(k, v string) (string, any) {
+			name := k
+			k = strings.TrimPrefix(k, options.EnvVarPrefix)
+			k = strings.NewReplacer("__", "_", "_", ".").Replace(k)
+			k = strings.ToLower(k)
+			provenance[k] = "env:" + name
+			return k, v
+		},
+	}), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load environment variables: %w", err)
+	}
+	return merged, provenance, nil
 }
 
 func getEnv(key, fallback string) string {
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const provenanceTestEnvPrefix = "CHAT_PROVENANCE_TEST_"
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file '%s': %v", name, err)
+	}
+	return path
+}
+
+func TestProvenanceReportsLastSourceOfEachKey(t *testing.T) {
+	base := writeConfigFile(t, "base.yaml", `
+kafka:
+  client_id: chat-base
+  topic: emails
+redis:
+  addresses: [redis:6379]
+`)
+	override := writeConfigFile(t, "override.yaml", `
+kafka:
+  topic: emails-v2
+`)
+	t.Setenv(provenanceTestEnvPrefix+"KAFKA_CLIENT__ID", "chat-env")
+	t.Setenv(provenanceTestEnvPrefix+"EMAIL_DRY__RUN", "true")
+
+	layers, provenance, err := loadLayers(LoadConfigOptions{
+		YamlFilePaths: []string{base, override},
+		EnvVarPrefix:  provenanceTestEnvPrefix,
+	})
+	if err != nil {
+		t.Fatalf("loadLayers() error = %v", err)
+	}
+
+	want := Provenance{
+		"kafka.client_id": "env:" + provenanceTestEnvPrefix + "KAFKA_CLIENT__ID",
+		"kafka.topic":     "file:" + override,
+		"redis.addresses": "file:" + base,
+		"email.dry_run":   "env:" + provenanceTestEnvPrefix + "EMAIL_DRY__RUN",
+	}
+	if !maps.Equal(provenance, want) {
+		t.Fatalf("provenance = %v, want %v", provenance, want)
+	}
+	if value := layers.String("kafka.client_id"); value != "chat-env" {
+		t.Errorf("kafka.client_id = %q, want the value of the environment variable", value)
+	}
+	if value := layers.String("kafka.topic"); value != "emails-v2" {
+		t.Errorf("kafka.topic = %q, want the value of the last file", value)
+	}
+}
+
+func TestLayersAreNotSharedAcrossLoads(t *testing.T) {
+	first := writeConfigFile(t, "first.yaml", "kafka:\n  client_id: chat-first\n")
+	second := writeConfigFile(t, "second.yaml", "redis:\n  addresses: [redis:6379]\n")
+
+	if _, _, err := loadLayers(LoadConfigOptions{YamlFilePaths: []string{first}, EnvVarPrefix: provenanceTestEnvPrefix}); err != nil {
+		t.Fatalf("loadLayers(first) error = %v", err)
+	}
+	layers, provenance, err := loadLayers(LoadConfigOptions{YamlFilePaths: []string{second}, EnvVarPrefix: provenanceTestEnvPrefix})
+	if err != nil {
+		t.Fatalf("loadLayers(second) error = %v", err)
+	}
+	if layers.Exists("kafka.client_id") {
+		t.Error("the values of a previous load leaked into the next one")
+	}
+	if want := (Provenance{"redis.addresses": "file:" + second}); !maps.Equal(provenance, want) {
+		t.Errorf("provenance = %v, want %v", provenance, want)
+	}
+}
+
+func TestLoadLayersReportsMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.yaml")
+
+	if _, _, err := loadLayers(LoadConfigOptions{YamlFilePaths: []string{path}, EnvVarPrefix: provenanceTestEnvPrefix}); err == nil {
+		t.Fatal("loadLayers() error = nil, want the missing file to be reported")
+	}
+}
@@ -0,0 +1,110 @@
+package crypto
+
+import (
+	"chat/src/platform/validation"
+	"chat/src/util"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+const keySize = 32 // AES-256
+
+var (
+	ErrInvalidKey          = errors.New("encryption key must be a base64 encoded 32 bytes key")
+	ErrUnknownKeyID        = errors.New("ciphertext was encrypted with an unknown key")
+	ErrCiphertextTooShort  = errors.New("ciphertext is too short")
+	ErrCiphertextForgery   = errors.New("ciphertext can't be authenticated")
+	ErrCurrentKeyIsMissing = errors.New("current key id has no key")
+)
+
+type FieldCipherOptions struct {
+	// Keys by their id, the retired ones are kept so ciphertexts encrypted with them can still be decrypted
+	Keys         map[uint8]util.Secret `validate:"required,min=1,max=255"`
+	CurrentKeyID uint8
+}
+
+// FieldCipher encrypts fields holding sensitive content (i.e. message content) before they are persisted, using
+// AES-256-GCM. Ciphertexts are prefixed with the id of the key they were encrypted with, so keys can be rotated
+// by adding a new key as the current one, while the previous ones keep decrypting what was stored before.
+//
+// Ciphertext layout: key id (1 byte) | nonce (12 bytes) | sealed plaintext and tag.
+type FieldCipher struct {
+	aeads        map[uint8]cipher.AEAD
+	currentKeyID uint8
+}
+
+func NewFieldCipher(options *FieldCipherOptions) (*FieldCipher, error) {
+	if err := validation.Instance.Struct(options); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+	if _, ok := options.Keys[options.CurrentKeyID]; !ok {
+		return nil, fmt.Errorf("%w: %d", ErrCurrentKeyIsMissing, options.CurrentKeyID)
+	}
+
+	aeads := make(map[uint8]cipher.AEAD, len(options.Keys))
+	for id, encoded := range options.Keys {
+		aead, err := newAEAD(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", id, err)
+		}
+		aeads[id] = aead
+	}
+
+	return &FieldCipher{aeads: aeads, currentKeyID: options.CurrentKeyID}, nil
+}
+
+// Encrypt seals the plaintext with the current key.
+func (c *FieldCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	aead := c.aeads[c.currentKeyID]
+
+	ciphertext := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	ciphertext[0] = c.currentKeyID
+	if _, err := rand.Read(ciphertext[1:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// the key id is authenticated too, so it can't be swapped to make the ciphertext decrypt under another key
+	return aead.Seal(ciphertext, ciphertext[1:], plaintext, ciphertext[:1]), nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt with any of the current or retired keys.
+func (c *FieldCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 1 {
+		return nil, ErrCiphertextTooShort
+	}
+	aead, ok := c.aeads[ciphertext[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKeyID, ciphertext[0])
+	}
+	if len(ciphertext) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrCiphertextTooShort
+	}
+
+	nonce, sealed := ciphertext[1:1+aead.NonceSize()], ciphertext[1+aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, ciphertext[:1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCiphertextForgery, err)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(encoded util.Secret) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil || len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+	return aead, nil
+}
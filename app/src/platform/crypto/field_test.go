@@ -0,0 +1,178 @@
+package crypto
+
+import (
+	"bytes"
+	"chat/src/util"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newTestKey(t *testing.T) util.Secret {
+	t.Helper()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return util.Secret(base64.StdEncoding.EncodeToString(key))
+}
+
+func newTestCipher(t *testing.T, currentKeyID uint8, keys map[uint8]util.Secret) *FieldCipher {
+	t.Helper()
+
+	fieldCipher, err := NewFieldCipher(&FieldCipherOptions{Keys: keys, CurrentKeyID: currentKeyID})
+	if err != nil {
+		t.Fatalf("NewFieldCipher() error = %v", err)
+	}
+	return fieldCipher
+}
+
+func TestFieldCipherRoundTrip(t *testing.T) {
+	fieldCipher := newTestCipher(t, 1, map[uint8]util.Secret{1: newTestKey(t)})
+
+	for _, plaintext := range [][]byte{[]byte("hello there"), {}, bytes.Repeat([]byte{0xff}, 4096)} {
+		ciphertext, err := fieldCipher.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		if len(plaintext) > 0 && bytes.Contains(ciphertext, plaintext) {
+			t.Fatal("ciphertext contains the plaintext")
+		}
+		if ciphertext[0] != 1 {
+			t.Errorf("ciphertext key id = %d, want %d", ciphertext[0], 1)
+		}
+
+		decrypted, err := fieldCipher.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt() error = %v", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("Decrypt() = %q, want %q", decrypted, plaintext)
+		}
+	}
+}
+
+func TestFieldCipherUsesFreshNonces(t *testing.T) {
+	fieldCipher := newTestCipher(t, 1, map[uint8]util.Secret{1: newTestKey(t)})
+
+	first, err := fieldCipher.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	second, err := fieldCipher.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if bytes.Equal(first, second) {
+		t.Fatal("encrypting the same plaintext twice produced the same ciphertext")
+	}
+}
+
+func TestFieldCipherWrongKeyFails(t *testing.T) {
+	ciphertext, err := newTestCipher(t, 1, map[uint8]util.Secret{1: newTestKey(t)}).Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// same key id, another key
+	other := newTestCipher(t, 1, map[uint8]util.Secret{1: newTestKey(t)})
+	if _, err := other.Decrypt(ciphertext); !errors.Is(err, ErrCiphertextForgery) {
+		t.Fatalf("Decrypt() error = %v, want %v", err, ErrCiphertextForgery)
+	}
+}
+
+func TestFieldCipherDetectsTampering(t *testing.T) {
+	key := newTestKey(t)
+	fieldCipher := newTestCipher(t, 1, map[uint8]util.Secret{1: key, 2: key})
+	ciphertext, err := fieldCipher.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(ciphertext []byte) []byte
+		want   error
+	}{
+		{name: "flipped bit", tamper: func(c []byte) []byte { c[len(c)-1] ^= 1; return c }, want: ErrCiphertextForgery},
+		// the key id is authenticated, so it can't be swapped even for an id with the same key
+		{name: "swapped key id", tamper: func(c []byte) []byte { c[0] = 2; return c }, want: ErrCiphertextForgery},
+		{name: "unknown key id", tamper: func(c []byte) []byte { c[0] = 9; return c }, want: ErrUnknownKeyID},
+		{name: "truncated", tamper: func(c []byte) []byte { return c[:10] }, want: ErrCiphertextTooShort},
+		{name: "empty", tamper: func([]byte) []byte { return nil }, want: ErrCiphertextTooShort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(bytes.Clone(ciphertext))
+			if _, err := fieldCipher.Decrypt(tampered); !errors.Is(err, tt.want) {
+				t.Fatalf("Decrypt() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldCipherRotation(t *testing.T) {
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+	ciphertext, err := newTestCipher(t, 1, map[uint8]util.Secret{1: oldKey}).Encrypt([]byte("before rotation"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	rotated := newTestCipher(t, 2, map[uint8]util.Secret{1: oldKey, 2: newKey})
+	decrypted, err := rotated.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() of the ciphertext of the retired key error = %v", err)
+	}
+	if string(decrypted) != "before rotation" {
+		t.Fatalf("Decrypt() = %q, want %q", decrypted, "before rotation")
+	}
+
+	fresh, err := rotated.Encrypt([]byte("after rotation"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if fresh[0] != 2 {
+		t.Fatalf("ciphertext key id = %d, want the current key %d", fresh[0], 2)
+	}
+	if _, err := newTestCipher(t, 2, map[uint8]util.Secret{2: newKey}).Decrypt(fresh); err != nil {
+		t.Fatalf("Decrypt() with the new key only error = %v", err)
+	}
+}
+
+func TestNewFieldCipherRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options FieldCipherOptions
+		want    error
+	}{
+		{
+			name:    "current key missing",
+			options: FieldCipherOptions{Keys: map[uint8]util.Secret{1: newTestKey(t)}, CurrentKeyID: 2},
+			want:    ErrCurrentKeyIsMissing,
+		},
+		{
+			name:    "not base64",
+			options: FieldCipherOptions{Keys: map[uint8]util.Secret{1: "not base64!"}, CurrentKeyID: 1},
+			want:    ErrInvalidKey,
+		},
+		{
+			name:    "short key",
+			options: FieldCipherOptions{Keys: map[uint8]util.Secret{1: util.Secret(base64.StdEncoding.EncodeToString(make([]byte, 16)))}, CurrentKeyID: 1},
+			want:    ErrInvalidKey,
+		},
+		{name: "no keys", options: FieldCipherOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFieldCipher(&tt.options)
+			if err == nil {
+				t.Fatal("NewFieldCipher() error = nil, want the options to be rejected")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("NewFieldCipher() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
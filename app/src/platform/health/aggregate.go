@@ -0,0 +1,67 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type aggregate struct {
+	name     string
+	children []Pingable
+}
+
+// Aggregate returns a Pingable reporting the worst result of its children, i.e. for a service depending on several
+// clients. The children are pinged concurrently at the same depth, the status of each of them being listed in the
+// "children" detail, while the ones which aren't healthy are recorded among the degraded reasons.
+func Aggregate(name string, children ...Pingable) Pingable {
+	return &aggregate{name: name, children: children}
+}
+
+func (a *aggregate) PingShallow(ctx context.Context) PingResult {
+	return a.ping(ctx, PingDepthShallow)
+}
+
+func (a *aggregate) PingDeep(ctx context.Context) PingResult {
+	return a.ping(ctx, PingDepthDeep)
+}
+
+func (a *aggregate) ping(ctx context.Context, depth PingDepth) PingResult {
+	result := NewHealthyPingResult(a.name, depth)
+
+	results := make([]PingResult, len(a.children))
+	var wg sync.WaitGroup
+	for idx, child := range a.children {
+		wg.Go(func() {
+			results[idx] = pingChild(ctx, child, depth)
+		})
+	}
+	wg.Wait()
+
+	statuses := make(map[string]PingStatus, len(results))
+	for _, child := range results {
+		statuses[child.Target] = child.Status
+		if !child.Healthy() {
+			result.Degrade(child.Cause, fmt.Sprintf("'%s' is %s: %s", child.Target, child.Status, child.Details))
+		}
+	}
+	result.WithDetail("children", statuses)
+
+	return result
+}
+
+// pingChild converts a panic of the child into an internal failure, the controller recovers only the panics of
+// the goroutines it starts.
+func pingChild(ctx context.Context, child Pingable, depth PingDepth) (result PingResult) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = NewHealthyPingResult(fmt.Sprintf("%T", child), depth)
+			result.SetPingOutput(PingCauseInternal, fmt.Sprintf("ping panicked: %v", recovered))
+		}
+	}()
+
+	if depth == PingDepthDeep {
+		return child.PingDeep(ctx)
+	}
+	return child.PingShallow(ctx)
+}
@@ -0,0 +1,182 @@
+package health
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resultOf returns the result of the target with the cause and the details.
+func resultOf(target string, depth PingDepth, cause PingCause, details string) PingResult {
+	result := NewHealthyPingResult(target, depth)
+	if cause != PingCauseOk {
+		result.SetPingOutput(cause, details)
+	}
+	return result
+}
+
+// depthPingable records the depth it was last pinged at.
+type depthPingable struct {
+	target string
+	pinged PingDepth
+}
+
+func (p *depthPingable) PingShallow(context.Context) PingResult {
+	p.pinged = PingDepthShallow
+	return NewHealthyPingResult(p.target, PingDepthShallow)
+}
+
+func (p *depthPingable) PingDeep(context.Context) PingResult {
+	p.pinged = PingDepthDeep
+	return NewHealthyPingResult(p.target, PingDepthDeep)
+}
+
+type panickingPingable struct{}
+
+func (p *panickingPingable) PingShallow(context.Context) PingResult {
+	panic("boom")
+}
+
+func (p *panickingPingable) PingDeep(context.Context) PingResult {
+	panic("boom")
+}
+
+func TestAggregateReportsWorstChild(t *testing.T) {
+	tests := []struct {
+		name        string
+		children    []PingResult
+		wantStatus  PingStatus
+		wantCause   PingCause
+		wantReasons []string
+	}{
+		{
+			name: "healthy children",
+			children: []PingResult{
+				resultOf("kafka", PingDepthShallow, PingCauseOk, ""),
+				resultOf("smtp", PingDepthShallow, PingCauseOk, ""),
+			},
+			wantStatus: PingStatusHealthy,
+			wantCause:  PingCauseOk,
+		},
+		{
+			name: "degraded child",
+			children: []PingResult{
+				resultOf("kafka", PingDepthShallow, PingCauseOk, ""),
+				resultOf("smtp", PingDepthShallow, PingCauseOverloaded, "queue is full"),
+			},
+			wantStatus:  PingStatusDegraded,
+			wantCause:   PingCauseOverloaded,
+			wantReasons: []string{"overloaded: 'smtp' is degraded: queue is full"},
+		},
+		{
+			name: "unhealthy child among degraded ones",
+			children: []PingResult{
+				resultOf("kafka", PingDepthShallow, PingCauseUnstable, "lagging"),
+				resultOf("scylla", PingDepthShallow, PingCauseNetwork, "connection refused"),
+				resultOf("smtp", PingDepthShallow, PingCauseOverloaded, "queue is full"),
+			},
+			wantStatus: PingStatusUnhealthy,
+			wantCause:  PingCauseNetwork,
+			wantReasons: []string{
+				"unstable: 'kafka' is degraded: lagging",
+				"network: 'scylla' is unhealthy: connection refused",
+				"overloaded: 'smtp' is degraded: queue is full",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			children := make([]Pingable, 0, len(tt.children))
+			wantStatuses := make(map[string]PingStatus, len(tt.children))
+			for _, child := range tt.children {
+				children = append(children, &fakePingable{result: child})
+				wantStatuses[child.Target] = child.Status
+			}
+
+			result := Aggregate("services.email", children...).PingShallow(context.Background())
+			if result.Target != "services.email" {
+				t.Errorf("PingShallow() target = '%s', want the name of the aggregate", result.Target)
+			}
+			if result.Status != tt.wantStatus || result.Cause != tt.wantCause {
+				t.Fatalf("PingShallow() = %s %s, want %s %s", result.Status, result.Cause, tt.wantStatus, tt.wantCause)
+			}
+			if statuses, _ := result.Data["children"].(map[string]PingStatus); !maps.Equal(statuses, wantStatuses) {
+				t.Errorf("children detail = %v, want %v", result.Data["children"], wantStatuses)
+			}
+			reasons, _ := result.Data[degradedReasonsDetail].([]string)
+			if !slices.Equal(reasons, tt.wantReasons) {
+				t.Errorf("degraded reasons = %v, want %v", reasons, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestAggregatePingsChildrenAtItsDepth(t *testing.T) {
+	kafka, neo4j := &depthPingable{target: "kafka"}, &depthPingable{target: "neo4j"}
+	aggregate := Aggregate("services.fanout", kafka, neo4j)
+
+	for _, depth := range []PingDepth{PingDepthShallow, PingDepthDeep, PingDepthShallow} {
+		var result PingResult
+		if depth == PingDepthDeep {
+			result = aggregate.PingDeep(context.Background())
+		} else {
+			result = aggregate.PingShallow(context.Background())
+		}
+		if result.Depth != depth || !result.Healthy() {
+			t.Fatalf("ping at depth %s = %s at depth %s, want a healthy result at depth %s", depth, result.Status, result.Depth, depth)
+		}
+		if kafka.pinged != depth || neo4j.pinged != depth {
+			t.Fatalf("children pinged at depths %s and %s, want %s", kafka.pinged, neo4j.pinged, depth)
+		}
+	}
+}
+
+func TestAggregateConvertsChildPanicToInternalFailure(t *testing.T) {
+	result := Aggregate("services.email", &fakePingable{result: resultOf("kafka", PingDepthDeep, PingCauseOk, "")}, &panickingPingable{}).
+		PingDeep(context.Background())
+
+	if result.Cause != PingCauseInternal || !strings.Contains(result.Details, "ping panicked: boom") {
+		t.Fatalf("PingDeep() = %s %q, want the panic to be an internal failure", result.Cause, result.Details)
+	}
+}
+
+// barrierPingable answers once all the children sharing the barrier were pinged.
+type barrierPingable struct {
+	target  string
+	barrier *sync.WaitGroup
+}
+
+func (p *barrierPingable) PingShallow(ctx context.Context) PingResult {
+	return p.PingDeep(ctx)
+}
+
+func (p *barrierPingable) PingDeep(context.Context) PingResult {
+	p.barrier.Done()
+	p.barrier.Wait()
+	return NewHealthyPingResult(p.target, PingDepthDeep)
+}
+
+func TestAggregatePingsChildrenConcurrently(t *testing.T) {
+	var barrier sync.WaitGroup
+	barrier.Add(3)
+	aggregate := Aggregate("services.fanout",
+		&barrierPingable{target: "kafka", barrier: &barrier},
+		&barrierPingable{target: "neo4j", barrier: &barrier},
+		&barrierPingable{target: "scylla", barrier: &barrier},
+	)
+
+	done := make(chan PingResult, 1)
+	go func() { done <- aggregate.PingDeep(context.Background()) }()
+	select {
+	case result := <-done:
+		if !result.Healthy() {
+			t.Fatalf("PingDeep() = %s %s, want healthy", result.Status, result.Cause)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("PingDeep() didn't return, want the children to be pinged concurrently")
+	}
+}
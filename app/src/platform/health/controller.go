@@ -6,12 +6,14 @@ import (
 	"chat/src/util"
 	"context"
 	"fmt"
+	"maps"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/creasty/defaults"
 	"github.com/go-co-op/gocron/v2"
+	"github.com/google/uuid"
 	"github.com/jellydator/ttlcache/v3"
 	"github.com/rs/zerolog"
 	"github.com/samber/oops"
@@ -20,6 +22,7 @@ import (
 const (
 	shallowToPingDelta          = 1 * time.Second
 	deepToShadowDeltaMultiplier = 2
+	staleAfterShallowIntervals  = 3 // missed checks after which results are stale
 )
 
 type CheckFrequencyConfig struct {
@@ -30,13 +33,18 @@ type CheckFrequencyConfig struct {
 }
 
 type ControllerConfig struct {
-	Dependencies   map[string]Pingable  `validate:"required,min=1,max=50,dive,keys,min=3,max=30,printascii,lowercase,endkeys,required"`
-	CheckFrequency CheckFrequencyConfig `validate:"required"`
-	Logger         zerolog.Logger       `validate:"required"`
+	Dependencies       map[string]Pingable  `validate:"required,min=1,max=50,dive,keys,min=3,max=30,printascii,lowercase,endkeys,required"`
+	CheckFrequency     CheckFrequencyConfig `validate:"required"`
+	StartupGracePeriod time.Duration        `default:"30s" validate:"min=0,max=300000000000"` // 0 to 5min, failures are reported as warming up
+	Logger             zerolog.Logger       `validate:"required"`
 }
 
 type pingingStats struct {
 	overallHealthy   atomic.Bool
+	lastJobRunTime   atomic.Int64 // unix nanos of the last run of the scheduler job, set before the pings start
+	schedulerStalled atomic.Bool
+	ready            atomic.Bool  // no dependency was unhealthy or warming up in the last round of pings
+	lastPingTime     atomic.Int64 // unix nanos of the last completed round of pings
 	lastDeepPingTime time.Time
 	shallowCount     int8
 	checkFrequency   CheckFrequencyConfig
@@ -46,7 +54,15 @@ type Controller struct {
 	dependencies map[string]Pingable
 	cache        *ttlcache.Cache[string, PingResult]
 	stats        pingingStats
+	rounds       sync.Mutex // serializes the rounds of pings, the initial one runs besides the scheduler
+	roundsMu     sync.Mutex
+	roundDone    chan struct{} // closed and replaced when a round of pings completes
+	gracePeriod  time.Duration
+	startedAt    atomic.Int64 // unix nanos
+	draining     atomic.Bool
 	scheduler    gocron.Scheduler
+	stopWatchdog context.CancelFunc
+	now          func() time.Time // #readonly, time.Now unless replaced by tests, tells the age of results
 	logger       zerolog.Logger
 }
 
@@ -61,10 +77,14 @@ func NewController(config *ControllerConfig) (*Controller, error) {
 	}
 
 	controller := &Controller{
-		dependencies: config.Dependencies,
+		dependencies: maps.Clone(config.Dependencies),
 		cache:        ttlcache.New[string, PingResult](),
 		scheduler:    scheduler,
 		stats:        pingingStats{checkFrequency: config.CheckFrequency},
+		gracePeriod:  config.StartupGracePeriod,
+		roundDone:    make(chan struct{}),
+		stopWatchdog: func() {},
+		now:          time.Now,
 		logger:       config.Logger,
 	}
 
@@ -76,7 +96,18 @@ func NewController(config *ControllerConfig) (*Controller, error) {
 				depth = PingDepthDeep
 			}
 			c.pingAndCache(depth)
-		}, controller))
+		}, controller),
+		gocron.WithEventListeners(
+			gocron.BeforeJobRuns(func(_ uuid.UUID, _ string) {
+				controller.stats.lastJobRunTime.Store(time.Now().UnixNano())
+			}),
+			gocron.AfterJobRunsWithError(func(_ uuid.UUID, _ string, err error) {
+				controller.logger.Error().Err(err).Msg("Health check job failed")
+			}),
+			gocron.AfterJobRunsWithPanic(func(_ uuid.UUID, _ string, recovered any) {
+				controller.logger.Error().Interface("recover", recovered).Msg("Health check job panicked")
+			}),
+		))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create health controller because scheduler job creation failed: %w", err)
 	}
@@ -84,13 +115,32 @@ func NewController(config *ControllerConfig) (*Controller, error) {
 	return controller, nil
 }
 
+// Start reports all dependencies as warming up and returns without waiting for the initial deep ping, so that a slow
+// dependency doesn't delay the startup of the application. The initial deep ping completes in the background.
 func (c *Controller) Start() {
-	c.pingAndCache(PingDepthDeep)
+	c.startedAt.Store(time.Now().UnixNano())
+	for name := range c.dependencies {
+		result := NewHealthyPingResult(name, PingDepthDeep)
+		result.SetPingOutput(PingCauseWarmingUp, "initial deep ping is in progress")
+		c.cache.Set(name, result, ttlcache.NoTTL)
+	}
+
+	util.Go(&c.logger, "health.initial-ping", func() {
+		c.pingAndCache(PingDepthDeep)
+	})
+	c.stats.lastJobRunTime.Store(time.Now().UnixNano())
 	c.scheduler.Start()
+
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	c.stopWatchdog = stopWatchdog
+	util.Go(&c.logger, "health.watchdog", func() {
+		c.watchScheduler(watchdogCtx)
+	})
 	c.logger.Info().Msgf("Starting watching health of %d dependencies", len(c.dependencies))
 }
 
 func (c *Controller) Stop() {
+	c.stopWatchdog()
 	err := c.scheduler.Shutdown()
 	if err != nil {
 		c.logger.Error().Err(err).Msg("failed to shutdown health controller")
@@ -98,23 +148,120 @@ func (c *Controller) Stop() {
 	c.logger.Info().Msgf("Shutting down health controller")
 }
 
+// Register adds a dependency created after the controller, i.e. a service depending on the clients checked by it.
+// Once the controller started, the dependency is reported as warming up until the next round of pings.
+func (c *Controller) Register(name string, dependency Pingable) error {
+	c.rounds.Lock()
+	defer c.rounds.Unlock()
+
+	if _, exists := c.dependencies[name]; exists {
+		return oops.
+			In(util.GetFunctionName()).
+			Code(perr.ECONFIG).
+			Errorf("dependency '%s' is already registered", name)
+	}
+	c.dependencies[name] = dependency
+
+	if c.startedAt.Load() != 0 {
+		result := NewHealthyPingResult(name, PingDepthShallow)
+		result.SetPingOutput(PingCauseWarmingUp, "dependency was registered after the controller started")
+		c.cache.Set(name, result, ttlcache.NoTTL)
+	}
+	return nil
+}
+
 func (c *Controller) GetCurrentHealth() *ttlcache.Cache[string, PingResult] {
 	return c.cache
 }
 
+// GetDependencyHealth returns the last result of the dependency, reported as stale when checks stopped running.
 func (c *Controller) GetDependencyHealth(name string) PingResult {
-	return c.cache.Get(name).Value()
+	item := c.cache.Get(name)
+	if item == nil {
+		result := NewHealthyPingResult(name, PingDepthShallow)
+		result.SetPingOutput(PingCauseUnknown, "dependency was never checked")
+		return result
+	}
+	return c.fresh(item.Value())
+}
+
+// Snapshot returns the last results of all dependencies, the ones older than the staleness window being stale.
+func (c *Controller) Snapshot() map[string]PingResult {
+	snapshot := make(map[string]PingResult, c.cache.Len())
+	c.cache.Range(func(item *ttlcache.Item[string, PingResult]) bool {
+		snapshot[item.Key()] = c.fresh(item.Value())
+		return true
+	})
+	return snapshot
 }
 
+// Healthy reports whether all dependencies were healthy in the last round of checks, unless that round is stale.
 func (c *Controller) Healthy() bool {
-	return c.stats.overallHealthy.Load()
+	last := time.Unix(0, c.stats.lastPingTime.Load())
+	return c.stats.overallHealthy.Load() && c.now().Sub(last) <= c.stats.stalenessWindow()
+}
+
+// Ready reports whether the application can accept traffic: it isn't draining, the last round of pings is fresh and
+// none of the dependencies was unhealthy or still warming up, degraded dependencies don't prevent readiness.
+func (c *Controller) Ready() bool {
+	if c.draining.Load() {
+		return false
+	}
+	last := time.Unix(0, c.stats.lastPingTime.Load())
+	return c.stats.ready.Load() && c.now().Sub(last) <= c.stats.stalenessWindow()
+}
+
+// MarkDraining makes the application not ready for good, while dependencies keep being checked, so that traffic is
+// routed away from a replica finishing its in-flight work ahead of its termination.
+func (c *Controller) MarkDraining() {
+	if c.draining.CompareAndSwap(false, true) {
+		c.logger.Info().Msg("Application is draining, reporting it as not ready")
+	}
+}
+
+func (c *Controller) Draining() bool {
+	return c.draining.Load()
+}
+
+// WaitReady blocks until Ready is true, it returns the error of the context when it's done before.
+func (c *Controller) WaitReady(ctx context.Context) error {
+	for {
+		c.roundsMu.Lock()
+		roundDone := c.roundDone
+		c.roundsMu.Unlock()
+
+		if c.Ready() {
+			return nil
+		}
+		select {
+		case <-roundDone:
+		case <-ctx.Done():
+			return fmt.Errorf("dependencies weren't ready in time: %w", ctx.Err())
+		}
+	}
+}
+
+func (c *Controller) fresh(result PingResult) PingResult {
+	if age := c.now().Sub(result.CheckedAt); age > c.stats.stalenessWindow() {
+		result.SetPingOutput(
+			PingCauseStale,
+			fmt.Sprintf("last %s ping was %v ago, status was %s: %s", result.Depth, age.Round(time.Second), result.Status, result.Details),
+		)
+	}
+	return result
 }
 
 func (c *Controller) pingAndCache(depth PingDepth) {
+	c.rounds.Lock()
+	defer c.rounds.Unlock()
+
 	ctx, cancel := context.WithTimeout(context.Background(), c.stats.checkFrequency.PingTimeout)
 	defer cancel()
+	warmingUp := c.withinGracePeriod()
 
 	c.stats.overallHealthy.CompareAndSwap(false, true)
+	var ready atomic.Bool
+	ready.Store(true)
 
 	var wg sync.WaitGroup
 	wg.Add(len(c.dependencies))
@@ -133,11 +280,17 @@ func (c *Controller) pingAndCache(depth PingDepth) {
 			}
 
 			result := ping(ctx)
+			if warmingUp && !result.Healthy() && !result.Degraded() {
+				result.SetPingOutput(PingCauseWarmingUp, fmt.Sprintf("%s within startup grace period: %s", result.Cause, result.Details))
+			}
 			c.cache.Set(name, result, ttlcache.NoTTL)
 
 			if result.Healthy() {
 				return
 			}
+			if !result.Degraded() || result.Cause == PingCauseWarmingUp {
+				ready.Store(false)
+			}
 
 			c.stats.overallHealthy.Store(false)
 			log := c.logger.Error
@@ -149,10 +302,65 @@ func (c *Controller) pingAndCache(depth PingDepth) {
 	}
 	wg.Wait()
 
+	c.stats.ready.Store(ready.Load())
 	c.stats.update(depth)
+
+	c.roundsMu.Lock()
+	close(c.roundDone)
+	c.roundDone = make(chan struct{})
+	c.roundsMu.Unlock()
+}
+
+// watchScheduler checks, every shallow interval, that the scheduler keeps running the health check job. Once the job
+// didn't run within the staleness window, the results are reported as stale anyway, the watchdog makes the cause
+// visible in the logs, as the scheduler doesn't surface why it stopped.
+func (c *Controller) watchScheduler(ctx context.Context) {
+	ticker := time.NewTicker(c.stats.checkFrequency.ShallowInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkScheduler()
+		}
+	}
+}
+
+// checkScheduler logs once when the health check job stops running within the staleness window, and once when it
+// runs again.
+func (c *Controller) checkScheduler() {
+	sinceLastRun := c.now().Sub(time.Unix(0, c.stats.lastJobRunTime.Load()))
+	if sinceLastRun > c.stats.stalenessWindow() {
+		if c.stats.schedulerStalled.CompareAndSwap(false, true) {
+			c.logger.Error().Msgf(
+				"Health check job didn't run for %v, expected every %v, health results are stale",
+				sinceLastRun.Round(time.Second), c.stats.checkFrequency.ShallowInterval,
+			)
+		}
+		return
+	}
+	if c.stats.schedulerStalled.CompareAndSwap(true, false) {
+		c.logger.Info().Msg("Health check job is running again")
+	}
+}
+
+// SchedulerStalled reports whether the watchdog found that the health check job stopped running.
+func (c *Controller) SchedulerStalled() bool {
+	return c.stats.schedulerStalled.Load()
+}
+
+func (c *Controller) withinGracePeriod() bool {
+	return time.Since(time.Unix(0, c.startedAt.Load())) < c.gracePeriod
+}
+
+func (s *pingingStats) stalenessWindow() time.Duration {
+	return staleAfterShallowIntervals*s.checkFrequency.ShallowInterval + s.checkFrequency.PingTimeout
 }
 
 func (s *pingingStats) update(depth PingDepth) {
+	s.lastPingTime.Store(time.Now().UnixNano())
 	if depth == PingDepthDeep {
 		s.lastDeepPingTime = time.Now()
 		s.shallowCount = 0
@@ -176,7 +384,7 @@ func (c *ControllerConfig) setup() error {
 	}
 
 	if err := validation.Instance.Struct(c); err != nil {
-		return errorb.Wrapf(err, "failed to validate")
+		return errorb.Wrapf(validation.Aggregate(err), "failed to validate")
 	}
 
 	if c.CheckFrequency.ShallowInterval-c.CheckFrequency.PingTimeout < shallowToPingDelta {
@@ -0,0 +1,345 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func newTestController(t *testing.T, dependencies map[string]Pingable) *Controller {
+	t.Helper()
+
+	controller, err := NewController(&ControllerConfig{
+		Dependencies:       dependencies,
+		StartupGracePeriod: 0,
+		Logger:             zerolog.Nop(),
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	return controller
+}
+
+func TestResultsDegradeOnceStale(t *testing.T) {
+	controller := newTestController(t, map[string]Pingable{
+		"redis": &fakePingable{result: NewHealthyPingResult("redis", PingDepthShallow)},
+	})
+	controller.pingAndCache(PingDepthShallow)
+	checkedAt := time.Now()
+	window := controller.stats.stalenessWindow()
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		stale   bool
+	}{
+		{name: "fresh", elapsed: 0},
+		{name: "within staleness window", elapsed: window - time.Second},
+		{name: "past staleness window", elapsed: window + time.Second, stale: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller.now = func() time.Time { return checkedAt.Add(tt.elapsed) }
+
+			if healthy := controller.Healthy(); healthy == tt.stale {
+				t.Errorf("Healthy() = %t, want %t", healthy, !tt.stale)
+			}
+			if ready := controller.Ready(); ready == tt.stale {
+				t.Errorf("Ready() = %t, want %t", ready, !tt.stale)
+			}
+
+			results := map[string]PingResult{"GetDependencyHealth": controller.GetDependencyHealth("redis")}
+			results["Snapshot"] = controller.Snapshot()["redis"]
+			for source, result := range results {
+				if !tt.stale {
+					if !result.Healthy() {
+						t.Errorf("%s() = %s (%s), want the result to be healthy", source, result.Status, result.Cause)
+					}
+					continue
+				}
+				if result.Cause != PingCauseStale || result.Status != PingStatusUnhealthy {
+					t.Errorf("%s() = %s (%s), want the result to be stale", source, result.Status, result.Cause)
+				}
+				if !strings.Contains(result.Details, "status was healthy") {
+					t.Errorf("%s() details = %q, want the last status to be reported", source, result.Details)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRoundOfPingsRefreshesStaleResults(t *testing.T) {
+	dependency := &fakePingable{result: NewHealthyPingResult("redis", PingDepthShallow)}
+	controller := newTestController(t, map[string]Pingable{"redis": dependency})
+	controller.pingAndCache(PingDepthShallow)
+
+	later := time.Now().Add(controller.stats.stalenessWindow() + time.Second)
+	controller.now = func() time.Time { return later }
+	if controller.Healthy() {
+		t.Fatal("Healthy() = true, want the stale round to be unhealthy")
+	}
+
+	// the checks run again once the scheduler recovers
+	controller.now = time.Now
+	dependency.result = NewHealthyPingResult("redis", PingDepthShallow)
+	controller.pingAndCache(PingDepthShallow)
+	if result := controller.GetDependencyHealth("redis"); !controller.Healthy() || !result.Healthy() {
+		t.Fatal("expected the results of the new round of pings to be healthy")
+	}
+}
+
+func TestNeverCheckedDependencyIsUnknown(t *testing.T) {
+	controller := newTestController(t, map[string]Pingable{
+		"redis": &fakePingable{result: NewHealthyPingResult("redis", PingDepthShallow)},
+	})
+
+	result := controller.GetDependencyHealth("nats")
+	if result.Cause != PingCauseUnknown || result.Healthy() {
+		t.Fatalf("GetDependencyHealth() = %s (%s), want the dependency to be unknown", result.Status, result.Cause)
+	}
+	if controller.Healthy() {
+		t.Fatal("Healthy() = true before any round of pings, want false")
+	}
+}
+
+// slowPingable answers deep pings with the result once released, regardless of the ping timeout.
+type slowPingable struct {
+	result  PingResult
+	release chan struct{}
+}
+
+func (p *slowPingable) PingShallow(context.Context) PingResult {
+	return p.result
+}
+
+func (p *slowPingable) PingDeep(context.Context) PingResult {
+	<-p.release
+	return p.result
+}
+
+func startTestController(t *testing.T, controller *Controller) {
+	t.Helper()
+
+	controller.Start()
+	t.Cleanup(controller.Stop)
+}
+
+// waitForCause waits for the result of the dependency to have the cause, failing the test on timeout.
+func waitForCause(t *testing.T, controller *Controller, name string, cause PingCause) PingResult {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		result := controller.GetDependencyHealth(name)
+		if result.Cause == cause {
+			return result
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GetDependencyHealth(%s) = %s (%s), want cause %s", name, result.Status, result.Cause, cause)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStartReturnsBeforeSlowInitialDeepPing(t *testing.T) {
+	slow := &slowPingable{result: NewHealthyPingResult("elasticsearch", PingDepthDeep), release: make(chan struct{})}
+	controller := newTestController(t, map[string]Pingable{"elasticsearch": slow})
+
+	start := time.Now()
+	startTestController(t, controller)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Start() took %v, want it not to wait for the initial deep ping", elapsed)
+	}
+	t.Cleanup(func() { close(slow.release) })
+
+	result := controller.GetDependencyHealth("elasticsearch")
+	if result.Cause != PingCauseWarmingUp || result.Status != PingStatusDegraded {
+		t.Fatalf("GetDependencyHealth() = %s (%s), want the dependency to be warming up", result.Status, result.Cause)
+	}
+	if controller.Ready() {
+		t.Fatal("Ready() = true while the initial deep ping is in progress, want false")
+	}
+}
+
+func TestInitialDeepPingResultReplacesWarmingUp(t *testing.T) {
+	slow := &slowPingable{result: NewHealthyPingResult("elasticsearch", PingDepthDeep), release: make(chan struct{})}
+	controller := newTestController(t, map[string]Pingable{"elasticsearch": slow})
+	startTestController(t, controller)
+
+	close(slow.release)
+	waitForCause(t, controller, "elasticsearch", PingCauseOk)
+	if !controller.Healthy() {
+		t.Fatal("Healthy() = false after the initial deep ping succeeded, want true")
+	}
+}
+
+func TestFailuresWithinStartupGracePeriodAreWarmingUp(t *testing.T) {
+	failing := NewHealthyPingResult("redis", PingDepthDeep)
+	failing.SetPingOutput(PingCauseNetwork, "connection refused")
+
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		want        PingCause
+	}{
+		{name: "within grace period", gracePeriod: time.Minute, want: PingCauseWarmingUp},
+		{name: "without grace period", gracePeriod: 0, want: PingCauseNetwork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := newTestController(t, map[string]Pingable{"redis": &fakePingable{result: failing}})
+			controller.gracePeriod = tt.gracePeriod
+			controller.startedAt.Store(time.Now().UnixNano())
+
+			controller.pingAndCache(PingDepthDeep)
+			result := controller.GetDependencyHealth("redis")
+			if result.Cause != tt.want {
+				t.Fatalf("GetDependencyHealth() cause = %s, want %s", result.Cause, tt.want)
+			}
+			if tt.want == PingCauseWarmingUp && !strings.Contains(result.Details, "connection refused") {
+				t.Errorf("details = %q, want the failure to be reported", result.Details)
+			}
+		})
+	}
+}
+
+func TestWaitReadyReturnsOnceDependencyBecomesHealthy(t *testing.T) {
+	dependency := &fakePingable{result: NewHealthyPingResult("redis", PingDepthShallow)}
+	dependency.result.SetPingOutput(PingCauseNetwork, "connection refused")
+	controller := newTestController(t, map[string]Pingable{"redis": dependency})
+	controller.pingAndCache(PingDepthShallow)
+	if controller.Ready() {
+		t.Fatal("Ready() = true while the dependency is unhealthy, want false")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	waited := make(chan error, 1)
+	go func() { waited <- controller.WaitReady(ctx) }()
+
+	controller.pingAndCache(PingDepthShallow) // still unhealthy
+	select {
+	case err := <-waited:
+		t.Fatalf("WaitReady() = %v while the dependency is unhealthy, want it to block", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	dependency.result = NewHealthyPingResult("redis", PingDepthShallow)
+	controller.pingAndCache(PingDepthShallow)
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatalf("WaitReady() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitReady() didn't return once the dependency became healthy")
+	}
+}
+
+func TestWaitReadyGivesUpWhenContextIsDone(t *testing.T) {
+	dependency := &fakePingable{result: NewHealthyPingResult("redis", PingDepthShallow)}
+	dependency.result.SetPingOutput(PingCauseNetwork, "connection refused")
+	controller := newTestController(t, map[string]Pingable{"redis": dependency})
+	controller.pingAndCache(PingDepthShallow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := controller.WaitReady(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitReady() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestReadinessOfDegradedDependencies(t *testing.T) {
+	tests := []struct {
+		name  string
+		cause PingCause
+		ready bool
+	}{
+		{name: "healthy", cause: PingCauseOk, ready: true},
+		{name: "overloaded", cause: PingCauseOverloaded, ready: true},
+		{name: "warming up", cause: PingCauseWarmingUp, ready: false},
+		{name: "unhealthy", cause: PingCauseNetwork, ready: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dependency := &fakePingable{result: NewHealthyPingResult("redis", PingDepthShallow)}
+			dependency.result.SetPingOutput(tt.cause, string(tt.cause))
+			controller := newTestController(t, map[string]Pingable{"redis": dependency})
+
+			controller.pingAndCache(PingDepthShallow)
+			if ready := controller.Ready(); ready != tt.ready {
+				t.Fatalf("Ready() = %t, want %t", ready, tt.ready)
+			}
+		})
+	}
+}
+
+func TestStalledSchedulerIsDetected(t *testing.T) {
+	controller := newTestController(t, map[string]Pingable{
+		"redis": &fakePingable{result: NewHealthyPingResult("redis", PingDepthShallow)},
+	})
+	var logs strings.Builder
+	controller.logger = zerolog.New(&logs)
+	startTestController(t, controller)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := controller.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady() error = %v", err)
+	}
+
+	controller.checkScheduler()
+	if controller.SchedulerStalled() {
+		t.Fatal("SchedulerStalled() = true right after the start, want false")
+	}
+
+	// the job didn't run since the start
+	stalledAt := time.Now().Add(controller.stats.stalenessWindow() + time.Second)
+	controller.now = func() time.Time { return stalledAt }
+	controller.checkScheduler()
+	controller.checkScheduler()
+	if !controller.SchedulerStalled() {
+		t.Fatal("SchedulerStalled() = false once the job didn't run within the staleness window, want true")
+	}
+	if controller.Healthy() {
+		t.Fatal("Healthy() = true while the scheduler is stalled, want the results to be stale")
+	}
+	if count := strings.Count(logs.String(), "Health check job didn't run"); count != 1 {
+		t.Fatalf("stalled scheduler logged %d times, want once:\n%s", count, logs.String())
+	}
+
+	controller.stats.lastJobRunTime.Store(stalledAt.UnixNano())
+	controller.checkScheduler()
+	if controller.SchedulerStalled() {
+		t.Fatal("SchedulerStalled() = true once the job ran again, want false")
+	}
+	if !strings.Contains(logs.String(), "Health check job is running again") {
+		t.Fatalf("expected the recovery of the scheduler to be logged:\n%s", logs.String())
+	}
+}
+
+func TestRegisterAddsDependencyToNextRound(t *testing.T) {
+	controller := newTestController(t, map[string]Pingable{
+		"redis": &fakePingable{result: NewHealthyPingResult("redis", PingDepthShallow)},
+	})
+	startTestController(t, controller)
+	waitForCause(t, controller, "redis", PingCauseOk)
+
+	if err := controller.Register("services.email", &fakePingable{result: NewHealthyPingResult("services.email", PingDepthShallow)}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if result := controller.GetDependencyHealth("services.email"); result.Cause != PingCauseWarmingUp {
+		t.Fatalf("GetDependencyHealth() = %s right after Register, want %s", result.Cause, PingCauseWarmingUp)
+	}
+	if err := controller.Register("redis", &fakePingable{}); err == nil {
+		t.Fatal("Register() of an already registered dependency error = nil, want it to be rejected")
+	}
+
+	controller.pingAndCache(PingDepthShallow)
+	if result := controller.GetDependencyHealth("services.email"); !result.Healthy() {
+		t.Fatalf("GetDependencyHealth() = %s (%s) after a round of pings, want healthy", result.Status, result.Cause)
+	}
+}
@@ -0,0 +1,121 @@
+package health
+
+import (
+	"chat/src/platform/validation"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/creasty/defaults"
+)
+
+type ErrorRateOptions struct {
+	Window         time.Duration `default:"1m" validate:"min=1000000000,max=3600000000000"` // 1s to 1h
+	Buckets        int           `default:"12" validate:"min=1,max=3600"`                   // granularity of the sliding window
+	MinSamples     uint64        `default:"20" validate:"min=1,max=1000000"`                // rates over fewer samples don't degrade health
+	UnstableRate   float64       `default:"0.05" validate:"gt=0,lte=1"`
+	OverloadedRate float64       `default:"0.25" validate:"gtfield=UnstableRate,lte=1"`
+}
+
+// ErrorRateTracker computes the rate of failed operations over a sliding window, so a component which keeps
+// answering pings, but fails a share of its operations (i.e. SMTP sends), is reported as degraded.
+// The window is split in buckets, the oldest one being discarded as a whole when the window slides.
+type ErrorRateTracker struct {
+	options     ErrorRateOptions
+	bucketWidth time.Duration    // #readonly
+	now         func() time.Time // #readonly, replaced by tests
+	mu          sync.Mutex
+	buckets     []rateBucket
+}
+
+type rateBucket struct {
+	index     int64 // number of bucket widths since the unix epoch
+	successes uint64
+	failures  uint64
+}
+
+func NewErrorRateTracker(options *ErrorRateOptions) (*ErrorRateTracker, error) {
+	if err := defaults.Set(options); err != nil {
+		return nil, fmt.Errorf("failed to set config defaults: %w", err)
+	}
+	if err := validation.Instance.Struct(options); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", validation.Aggregate(err))
+	}
+
+	return &ErrorRateTracker{
+		options:     *options,
+		bucketWidth: max(options.Window/time.Duration(options.Buckets), time.Millisecond),
+		now:         time.Now,
+		buckets:     make([]rateBucket, options.Buckets),
+	}, nil
+}
+
+func (t *ErrorRateTracker) Record(success bool) {
+	index := t.now().UnixNano() / int64(t.bucketWidth)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := &t.buckets[index%int64(len(t.buckets))]
+	if bucket.index != index {
+		*bucket = rateBucket{index: index}
+	}
+	if success {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+}
+
+// Rate returns the share of failed operations within the window, 0 when there were none.
+func (t *ErrorRateTracker) Rate() float64 {
+	failures, total := t.samples()
+	if total == 0 {
+		return 0
+	}
+	return float64(failures) / float64(total)
+}
+
+// Cause maps the error rate to the cause a ping should report, PingCauseOk while there are too few samples.
+func (t *ErrorRateTracker) Cause() PingCause {
+	failures, total := t.samples()
+	if total < t.options.MinSamples {
+		return PingCauseOk
+	}
+
+	switch rate := float64(failures) / float64(total); {
+	case rate >= t.options.OverloadedRate:
+		return PingCauseOverloaded
+	case rate >= t.options.UnstableRate:
+		return PingCauseUnstable
+	default:
+		return PingCauseOk
+	}
+}
+
+// Degrade sets the output of the result from the error rate of the operations, unless it already reports a worse
+// status than the error rate would.
+func (t *ErrorRateTracker) Degrade(result *PingResult, operations string) {
+	cause := t.Cause()
+	if severity(cause.ToStatus()) <= severity(result.Status) {
+		return
+	}
+	result.SetPingOutput(cause, fmt.Sprintf("%.1f%% of %s failed in the last %v", t.Rate()*100, operations, t.options.Window))
+}
+
+func (t *ErrorRateTracker) samples() (uint64, uint64) {
+	oldest := t.now().UnixNano()/int64(t.bucketWidth) - int64(len(t.buckets)) + 1
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var failures, total uint64
+	for _, bucket := range t.buckets {
+		if bucket.index < oldest {
+			continue
+		}
+		failures += bucket.failures
+		total += bucket.successes + bucket.failures
+	}
+	return failures, total
+}
@@ -0,0 +1,149 @@
+package health
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestErrorRateTracker returns a tracker over a 1m window of 12 buckets, whose clock is advanced by the test.
+func newTestErrorRateTracker(t *testing.T, minSamples uint64) (*ErrorRateTracker, *time.Time) {
+	t.Helper()
+
+	tracker, err := NewErrorRateTracker(&ErrorRateOptions{MinSamples: minSamples})
+	if err != nil {
+		t.Fatalf("NewErrorRateTracker() error = %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	tracker.now = func() time.Time { return now }
+	return tracker, &now
+}
+
+func record(tracker *ErrorRateTracker, successes, failures int) {
+	for range successes {
+		tracker.Record(true)
+	}
+	for range failures {
+		tracker.Record(false)
+	}
+}
+
+func TestErrorRateTrackerRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		successes int
+		failures  int
+		wantRate  float64
+	}{
+		{name: "no operations", wantRate: 0},
+		{name: "only successes", successes: 10, wantRate: 0},
+		{name: "only failures", failures: 10, wantRate: 1},
+		{name: "some failures", successes: 15, failures: 5, wantRate: 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker, _ := newTestErrorRateTracker(t, 1)
+			record(tracker, tt.successes, tt.failures)
+
+			if rate := tracker.Rate(); math.Abs(rate-tt.wantRate) > 1e-9 {
+				t.Fatalf("Rate() = %v, want %v", rate, tt.wantRate)
+			}
+		})
+	}
+}
+
+func TestErrorRateTrackerCauseTransitions(t *testing.T) {
+	tracker, now := newTestErrorRateTracker(t, 20)
+
+	record(tracker, 0, 10)
+	if cause := tracker.Cause(); cause != PingCauseOk {
+		t.Fatalf("Cause() = %s over too few samples, want %s", cause, PingCauseOk)
+	}
+
+	record(tracker, 30, 0) // 10 of 40 failed
+	if cause := tracker.Cause(); cause != PingCauseOverloaded {
+		t.Fatalf("Cause() = %s at 25%% errors, want %s", cause, PingCauseOverloaded)
+	}
+
+	record(tracker, 40, 0) // 10 of 80 failed
+	if cause := tracker.Cause(); cause != PingCauseUnstable {
+		t.Fatalf("Cause() = %s at 12.5%% errors, want %s", cause, PingCauseUnstable)
+	}
+
+	record(tracker, 120, 0) // 10 of 200 failed
+	if cause := tracker.Cause(); cause != PingCauseUnstable {
+		t.Fatalf("Cause() = %s at 5%% errors, want %s", cause, PingCauseUnstable)
+	}
+
+	*now = now.Add(30 * time.Second)
+	record(tracker, 200, 0) // 10 of 400 failed
+	if cause := tracker.Cause(); cause != PingCauseOk {
+		t.Fatalf("Cause() = %s at 2.5%% errors, want %s", cause, PingCauseOk)
+	}
+}
+
+func TestErrorRateTrackerForgetsOperationsOutsideOfWindow(t *testing.T) {
+	tracker, now := newTestErrorRateTracker(t, 1)
+
+	record(tracker, 0, 10)
+	*now = now.Add(30 * time.Second)
+	record(tracker, 10, 0)
+	if rate := tracker.Rate(); rate != 0.5 {
+		t.Fatalf("Rate() = %v within the window, want 0.5", rate)
+	}
+
+	// the buckets of the failures slid out of the window, the ones of the successes didn't
+	*now = now.Add(40 * time.Second)
+	if rate := tracker.Rate(); rate != 0 {
+		t.Fatalf("Rate() = %v once the failures left the window, want 0", rate)
+	}
+	if cause := tracker.Cause(); cause != PingCauseOk {
+		t.Fatalf("Cause() = %s once the failures left the window, want %s", cause, PingCauseOk)
+	}
+
+	*now = now.Add(time.Minute)
+	record(tracker, 0, 1)
+	if rate := tracker.Rate(); rate != 1 {
+		t.Fatalf("Rate() = %v after the window emptied, want 1", rate)
+	}
+}
+
+func TestErrorRateTrackerDegrade(t *testing.T) {
+	tracker, _ := newTestErrorRateTracker(t, 1)
+	record(tracker, 3, 1)
+
+	result := NewHealthyPingResult("smtp", PingDepthShallow)
+	tracker.Degrade(&result, "SMTP sends")
+	if result.Cause != PingCauseOverloaded || result.Status != PingStatusDegraded {
+		t.Fatalf("Degrade() of healthy result = %s %s, want %s %s", result.Status, result.Cause, PingStatusDegraded, PingCauseOverloaded)
+	}
+	if want := "25.0% of SMTP sends failed in the last 1m0s"; result.Details != want {
+		t.Fatalf("Degrade() details = %q, want %q", result.Details, want)
+	}
+
+	down := NewHealthyPingResult("smtp", PingDepthShallow)
+	down.SetPingOutput(PingCauseNetwork, "connection refused")
+	tracker.Degrade(&down, "SMTP sends")
+	if down.Cause != PingCauseNetwork || down.Details != "connection refused" {
+		t.Fatalf("Degrade() of worse result = %s %q, want it unchanged", down.Cause, down.Details)
+	}
+}
+
+func TestNewErrorRateTrackerRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ErrorRateOptions
+	}{
+		{name: "window too short", options: ErrorRateOptions{Window: time.Millisecond}},
+		{name: "too many buckets", options: ErrorRateOptions{Buckets: 5000}},
+		{name: "unstable rate above 1", options: ErrorRateOptions{UnstableRate: 1.5, OverloadedRate: 1}},
+		{name: "overloaded rate below unstable rate", options: ErrorRateOptions{UnstableRate: 0.5, OverloadedRate: 0.25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewErrorRateTracker(&tt.options); err == nil {
+				t.Fatal("NewErrorRateTracker() error = nil, want the options to be rejected")
+			}
+		})
+	}
+}
@@ -33,8 +33,10 @@ type PingCause string
 const (
 	PingCauseOk PingCause = "ok"
 
-	PingCauseUnstable   PingCause = "unstable"
-	PingCauseOverloaded PingCause = "overloaded"
+	PingCauseUnstable     PingCause = "unstable"
+	PingCauseOverloaded   PingCause = "overloaded"
+	PingCauseCertExpiring PingCause = "cert_expiring"
+	PingCauseWarmingUp    PingCause = "warming_up" // not checked yet, or failing within the startup grace period
 
 	PingCauseNetwork     PingCause = "network"
 	PingCauseTLS         PingCause = "tls"
@@ -44,22 +46,26 @@ const (
 	PingCauseBadState    PingCause = "bad_state"
 
 	PingCauseInternal PingCause = "internal"
+	PingCauseStale    PingCause = "stale" // the last result is too old to be trusted, i.e. the checks stopped running
 
 	PingCauseUnknown PingCause = "unknown"
 )
 
 var causeToStatus = map[PingCause]PingStatus{
-	PingCauseOk:          PingStatusHealthy,
-	PingCauseUnstable:    PingStatusDegraded,
-	PingCauseOverloaded:  PingStatusDegraded,
-	PingCauseNetwork:     PingStatusUnhealthy,
-	PingCauseTLS:         PingStatusUnhealthy,
-	PingCauseTimeout:     PingStatusUnhealthy,
-	PingCauseBadResponse: PingStatusUnhealthy,
-	PingCauseAuthFailed:  PingStatusUnhealthy,
-	PingCauseBadState:    PingStatusUnhealthy,
-	PingCauseInternal:    PingStatusUnhealthy,
-	PingCauseUnknown:     PingStatusUnhealthy,
+	PingCauseOk:           PingStatusHealthy,
+	PingCauseUnstable:     PingStatusDegraded,
+	PingCauseOverloaded:   PingStatusDegraded,
+	PingCauseCertExpiring: PingStatusDegraded,
+	PingCauseWarmingUp:    PingStatusDegraded,
+	PingCauseNetwork:      PingStatusUnhealthy,
+	PingCauseTLS:          PingStatusUnhealthy,
+	PingCauseTimeout:      PingStatusUnhealthy,
+	PingCauseBadResponse:  PingStatusUnhealthy,
+	PingCauseAuthFailed:   PingStatusUnhealthy,
+	PingCauseBadState:     PingStatusUnhealthy,
+	PingCauseInternal:     PingStatusUnhealthy,
+	PingCauseStale:        PingStatusUnhealthy,
+	PingCauseUnknown:      PingStatusUnhealthy,
 }
 
 func (c *PingCause) ToStatus() PingStatus {
@@ -144,9 +150,9 @@ func PingCauseFromRequestError(err error) PingCause {
 		return PingCauseInternal
 	}
 
-	match := matcher.MatchFirstString(strings.ToLower(err.Error()))
-	if match != nil {
-		return causeByIndex[match.Pattern()]
+	// MatchFirstString doesn't report which pattern matched, so the first of all the matches is used
+	if matches := matcher.MatchString(strings.ToLower(err.Error())); len(matches) > 0 {
+		return causeByIndex[matches[0].Pattern()]
 	}
 
 	return PingCauseUnknown
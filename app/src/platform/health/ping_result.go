@@ -6,14 +6,17 @@ import (
 	"time"
 )
 
+const degradedReasonsDetail = "degraded_reasons"
+
 type PingResult struct {
-	Target    string     `json:"target"`
-	Depth     PingDepth  `json:"depth"`
-	Status    PingStatus `json:"status"`
-	Cause     PingCause  `json:"cause"`
-	Details   string     `json:"details"`
-	Latency   string     `json:"latency"`
-	CheckedAt time.Time  `json:"checked_at"`
+	Target    string         `json:"target"`
+	Depth     PingDepth      `json:"depth"`
+	Status    PingStatus     `json:"status"`
+	Cause     PingCause      `json:"cause"`
+	Details   string         `json:"details"`
+	Data      map[string]any `json:"data,omitempty"` // values computed by the ping, i.e. replication lag, queryable by machines
+	Latency   string         `json:"latency"`
+	CheckedAt time.Time      `json:"checked_at"`
 }
 
 func NewHealthyPingResult(target string, depth PingDepth) PingResult {
@@ -32,6 +35,26 @@ func (r *PingResult) SetPingOutput(cause PingCause, details string) {
 	r.Details = details
 }
 
+// WithDetail records a value computed by the ping under the key, it returns the result so calls can be chained.
+func (r *PingResult) WithDetail(key string, value any) *PingResult {
+	if r.Data == nil {
+		r.Data = make(map[string]any)
+	}
+	r.Data[key] = value
+	return r
+}
+
+// Degrade records the reason among the "degraded_reasons" of the result and sets the output of the result to it,
+// unless the result already reports a worse status. Unlike SetPingOutput, no reason is lost when several checks fail.
+func (r *PingResult) Degrade(cause PingCause, details string) {
+	reasons, _ := r.Data[degradedReasonsDetail].([]string)
+	r.WithDetail(degradedReasonsDetail, append(reasons, string(cause)+": "+details))
+
+	if severity(cause.ToStatus()) > severity(r.Status) {
+		r.SetPingOutput(cause, details)
+	}
+}
+
 func (r *PingResult) StoreComputedLatency(acceptableLatency time.Duration) {
 	latency := time.Since(r.CheckedAt)
 	r.Latency = latency.String()
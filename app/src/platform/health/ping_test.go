@@ -0,0 +1,70 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPingCauseFromRequestError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want PingCause
+	}{
+		{err: nil, want: PingCauseOk},
+		{err: fmt.Errorf("ping: %w", context.DeadlineExceeded), want: PingCauseTimeout},
+		{err: errors.New("dial tcp 127.0.0.1:6379: connect: connection refused"), want: PingCauseNetwork},
+		{err: errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority"), want: PingCauseTLS},
+		{err: errors.New("WRONGPASS invalid password"), want: PingCauseAuthFailed},
+		{err: errors.New("ERR too many connections"), want: PingCauseOverloaded},
+		{err: errors.New("unexpected error"), want: PingCauseInternal},
+		{err: errors.New("something else"), want: PingCauseUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.err), func(t *testing.T) {
+			if got := PingCauseFromRequestError(tt.err); got != tt.want {
+				t.Fatalf("PingCauseFromRequestError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingResultDetailsAreSerialized(t *testing.T) {
+	result := NewHealthyPingResult("db", PingDepthDeep)
+	if output := result.PrettyJSON(); strings.Contains(output, `"data"`) {
+		t.Fatalf("PrettyJSON() = %s, want no data without details", output)
+	}
+
+	result.WithDetail("connections", 12).WithDetail("status", "green")
+	result.WithDetail("connections", 13)
+
+	var decoded PingResult
+	if err := json.Unmarshal([]byte(result.PrettyJSON()), &decoded); err != nil {
+		t.Fatalf("PrettyJSON() isn't valid JSON: %v", err)
+	}
+	want := map[string]any{"connections": 13.0, "status": "green"}
+	if !maps.Equal(decoded.Data, want) {
+		t.Fatalf("serialized details = %v, want %v", decoded.Data, want)
+	}
+}
+
+func TestDegradeKeepsEveryReasonAndTheWorstCause(t *testing.T) {
+	result := NewHealthyPingResult("email", PingDepthShallow)
+	result.Degrade(PingCauseOverloaded, "queue is full")
+	result.Degrade(PingCauseNetwork, "pool is down")
+	result.Degrade(PingCauseUnstable, "sends fail")
+
+	if result.Status != PingStatusUnhealthy || result.Cause != PingCauseNetwork || result.Details != "pool is down" {
+		t.Fatalf("Degrade() = %s %s %q, want the worst reason to be the output", result.Status, result.Cause, result.Details)
+	}
+	want := []string{"overloaded: queue is full", "network: pool is down", "unstable: sends fail"}
+	if reasons, _ := result.Data[degradedReasonsDetail].([]string); !slices.Equal(reasons, want) {
+		t.Fatalf("degraded reasons = %v, want %v", reasons, want)
+	}
+}
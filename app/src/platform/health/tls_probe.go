@@ -0,0 +1,143 @@
+package health
+
+import (
+	"chat/src/platform/validation"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/creasty/defaults"
+)
+
+type TLSProbeOptions struct {
+	Address       string        `validate:"required,hostname_port"`
+	Config        *tls.Config   `validate:"required"`
+	Interval      time.Duration `default:"1h" validate:"min=60000000000,max=86400000000000"`       // 1min to 24h
+	ExpiryWarning time.Duration `default:"336h" validate:"min=3600000000000,max=7776000000000000"` // 1h to 90 days
+}
+
+// TLSProbe decorates the deep ping of a dependency with a TLS handshake to its endpoint, so certificates about to
+// break the next reconnect are reported before the long-lived connections of the client are re-established.
+// The handshake is done at most once per Interval, in between the last probe result is reused.
+type TLSProbe struct {
+	inner   Pingable
+	options TLSProbeOptions
+
+	mu          sync.Mutex
+	lastResult  PingResult
+	lastProbeAt time.Time
+}
+
+func WithTLSProbe(inner Pingable, options *TLSProbeOptions) (*TLSProbe, error) {
+	if err := defaults.Set(options); err != nil {
+		return nil, fmt.Errorf("failed to set config defaults: %w", err)
+	}
+	if err := validation.Instance.Struct(options); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", validation.Aggregate(err))
+	}
+	return &TLSProbe{inner: inner, options: *options}, nil
+}
+
+func (p *TLSProbe) PingShallow(ctx context.Context) PingResult {
+	return p.inner.PingShallow(ctx)
+}
+
+func (p *TLSProbe) PingDeep(ctx context.Context) PingResult {
+	result := p.inner.PingDeep(ctx)
+	probe := p.probe(ctx, result.Target)
+
+	if severity(probe.Status) > severity(result.Status) {
+		result.SetPingOutput(probe.Cause, probe.Details)
+	}
+	return result
+}
+
+func (p *TLSProbe) probe(ctx context.Context, target string) PingResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.lastProbeAt.IsZero() && time.Since(p.lastProbeAt) < p.options.Interval {
+		return p.lastResult
+	}
+
+	p.lastResult = p.handshake(ctx, target)
+	p.lastProbeAt = time.Now()
+	return p.lastResult
+}
+
+func (p *TLSProbe) handshake(ctx context.Context, target string) PingResult {
+	result := NewHealthyPingResult(target, PingDepthDeep)
+
+	config := p.options.Config.Clone()
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(p.options.Address)
+		config.ServerName = host
+	}
+
+	dialer := tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", p.options.Address)
+	if err != nil {
+		cause := PingCauseFromRequestError(err)
+		if cause == PingCauseUnknown {
+			cause = PingCauseTLS
+		}
+		result.SetPingOutput(cause, fmt.Sprintf("TLS handshake with '%s' failed: %v", p.options.Address, err))
+		return result
+	}
+	state := conn.(*tls.Conn).ConnectionState() //nolint:forcetypeassert // tls.Dialer returns *tls.Conn
+	_ = conn.Close()
+
+	certificate, notAfter := earliestExpiry(state.PeerCertificates, config.Certificates)
+	if certificate == nil {
+		return result
+	}
+	if remaining := time.Until(notAfter); remaining < p.options.ExpiryWarning {
+		result.SetPingOutput(
+			PingCauseCertExpiring,
+			fmt.Sprintf("certificate '%s' used with '%s' expires at %v (in %v)",
+				certificate.Subject, p.options.Address, notAfter, remaining.Round(time.Minute)),
+		)
+	}
+	return result
+}
+
+// earliestExpiry returns the certificate expiring first among the chain presented by the peer and the leaves of
+// the certificates the client presents.
+func earliestExpiry(peer []*x509.Certificate, own []tls.Certificate) (*x509.Certificate, time.Time) {
+	certificates := append([]*x509.Certificate(nil), peer...)
+	for _, cert := range own {
+		leaf := cert.Leaf
+		if leaf == nil && len(cert.Certificate) > 0 {
+			leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+		}
+		if leaf != nil {
+			certificates = append(certificates, leaf)
+		}
+	}
+
+	var earliest *x509.Certificate
+	for _, cert := range certificates {
+		if earliest == nil || cert.NotAfter.Before(earliest.NotAfter) {
+			earliest = cert
+		}
+	}
+	if earliest == nil {
+		return nil, time.Time{}
+	}
+	return earliest, earliest.NotAfter
+}
+
+func severity(status PingStatus) int {
+	switch status {
+	case PingStatusHealthy:
+		return 0
+	case PingStatusDegraded:
+		return 1
+	default:
+		return 2
+	}
+}
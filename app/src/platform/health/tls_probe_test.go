@@ -0,0 +1,139 @@
+package health
+
+import (
+	"chat/src/platform/security/securitytest"
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePingable reports the result on deep pings, counting them.
+type fakePingable struct {
+	result PingResult
+	pings  int
+}
+
+func (p *fakePingable) PingShallow(context.Context) PingResult {
+	return p.result
+}
+
+func (p *fakePingable) PingDeep(context.Context) PingResult {
+	p.pings++
+	return p.result
+}
+
+// newTLSTestServer returns the address of a server completing the TLS handshakes with the config, until cleanup.
+func newTLSTestServer(t *testing.T, config *tls.Config) net.Listener {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake() //nolint:forcetypeassert // tls.Listen returns *tls.Conn
+			_ = conn.Close()
+		}
+	}()
+	return listener
+}
+
+func newTestTLSProbe(t *testing.T, inner Pingable, address string, config *tls.Config) *TLSProbe {
+	t.Helper()
+
+	probe, err := WithTLSProbe(inner, &TLSProbeOptions{Address: address, Config: config})
+	if err != nil {
+		t.Fatalf("WithTLSProbe() error = %v", err)
+	}
+	return probe
+}
+
+func TestTLSProbeDegradesOnSoonToExpireCertificate(t *testing.T) {
+	// the certificate is valid for an hour, less than the default expiry warning
+	serverConfig, clientConfig := securitytest.NewTLSConfigs(t)
+	listener := newTLSTestServer(t, serverConfig)
+	inner := &fakePingable{result: NewHealthyPingResult("redis", PingDepthDeep)}
+	probe := newTestTLSProbe(t, inner, listener.Addr().String(), clientConfig)
+
+	result := probe.PingDeep(context.Background())
+	if result.Status != PingStatusDegraded || result.Cause != PingCauseCertExpiring {
+		t.Fatalf("PingDeep() = %s/%s (%s), want degraded/cert_expiring", result.Status, result.Cause, result.Details)
+	}
+	if !strings.Contains(result.Details, "certificate 'CN=127.0.0.1'") {
+		t.Errorf("PingDeep() details = %q, want the expiring certificate to be named", result.Details)
+	}
+	if result.Target != "redis" {
+		t.Errorf("PingDeep() target = %q, want the target of the decorated ping", result.Target)
+	}
+}
+
+func TestTLSProbeReportsHandshakeFailures(t *testing.T) {
+	serverConfig, _ := securitytest.NewTLSConfigs(t)
+	_, untrustedConfig := securitytest.NewTLSConfigs(t) // trusts another certificate
+	listener := newTLSTestServer(t, serverConfig)
+	inner := &fakePingable{result: NewHealthyPingResult("redis", PingDepthDeep)}
+	probe := newTestTLSProbe(t, inner, listener.Addr().String(), untrustedConfig)
+
+	result := probe.PingDeep(context.Background())
+	if result.Status != PingStatusUnhealthy || result.Cause != PingCauseTLS {
+		t.Fatalf("PingDeep() = %s/%s (%s), want unhealthy/tls", result.Status, result.Cause, result.Details)
+	}
+}
+
+func TestTLSProbeReusesResultWithinInterval(t *testing.T) {
+	serverConfig, clientConfig := securitytest.NewTLSConfigs(t)
+	listener := newTLSTestServer(t, serverConfig)
+	inner := &fakePingable{result: NewHealthyPingResult("redis", PingDepthDeep)}
+	probe := newTestTLSProbe(t, inner, listener.Addr().String(), clientConfig)
+
+	first := probe.PingDeep(context.Background())
+	_ = listener.Close() // a new handshake would fail
+	second := probe.PingDeep(context.Background())
+
+	if second.Cause != first.Cause || second.Details != first.Details {
+		t.Fatalf("PingDeep() = %s (%s), want the probe result %s (%s) to be reused",
+			second.Cause, second.Details, first.Cause, first.Details)
+	}
+	if inner.pings != 2 {
+		t.Fatalf("decorated ping was called %d times, want 2", inner.pings)
+	}
+}
+
+func TestTLSProbeKeepsWorseResultOfDecoratedPing(t *testing.T) {
+	serverConfig, clientConfig := securitytest.NewTLSConfigs(t)
+	listener := newTLSTestServer(t, serverConfig)
+	unhealthy := NewHealthyPingResult("redis", PingDepthDeep)
+	unhealthy.SetPingOutput(PingCauseAuthFailed, "wrong password")
+	probe := newTestTLSProbe(t, &fakePingable{result: unhealthy}, listener.Addr().String(), clientConfig)
+
+	result := probe.PingDeep(context.Background())
+	if result.Cause != PingCauseAuthFailed || result.Details != "wrong password" {
+		t.Fatalf("PingDeep() = %s (%s), want the unhealthy result of the decorated ping", result.Cause, result.Details)
+	}
+}
+
+func TestWithTLSProbeValidatesOptions(t *testing.T) {
+	_, clientConfig := securitytest.NewTLSConfigs(t)
+	inner := &fakePingable{}
+
+	if _, err := WithTLSProbe(inner, &TLSProbeOptions{Address: "redis", Config: clientConfig}); err == nil {
+		t.Error("expected an address without port to be rejected")
+	}
+	if _, err := WithTLSProbe(inner, &TLSProbeOptions{Address: "redis:6379"}); err == nil {
+		t.Error("expected a missing TLS config to be rejected")
+	}
+	options := &TLSProbeOptions{Address: "redis:6379", Config: clientConfig, Interval: time.Second}
+	if _, err := WithTLSProbe(inner, options); err == nil {
+		t.Error("expected an interval below a minute to be rejected")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"chat/src/platform/validation"
 	"chat/src/util"
 	"context"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,10 +22,27 @@ type ServiceLifecycle interface {
 	Stop(ctx context.Context)
 }
 
+type EventType uint8
+
+const (
+	EventStarted EventType = iota
+	EventStartFailed
+	EventStopped
+)
+
+// Event notifies about a lifecycle transition of a service.
+type Event struct {
+	Service  string
+	Type     EventType
+	Err      error         // set for EventStartFailed
+	Duration time.Duration // time spent in Start, or in Stop for EventStopped
+}
+
 type Controller struct {
 	services map[string]ServiceLifecycle
 	layers   [][]string
 	timeouts ControllerTimeoutsOptions
+	onEvent  func(Event)
 	logger   zerolog.Logger
 }
 
@@ -37,8 +55,9 @@ type ControllerTimeoutsOptions struct {
 
 type ControllerOptions struct {
 	Services     map[string]ServiceLifecycle `validate:"required,min=1,max=50,dive,keys,min=1,max=50,printascii,lowercase,endkeys,required"`
-	Dependencies map[string][]string         `validate:"omitempty,min=1,max=50,dive,keys,min=1,max=50,printascii,lowercase,endkeys,required,dive,min=1,max=50,printascii,lowercase"`
+	Dependencies map[string][]string         `validate:"omitempty,max=50,dive,keys,min=1,max=50,printascii,lowercase,endkeys,required,dive,min=1,max=50,printascii,lowercase"`
 	Timeouts     ControllerTimeoutsOptions   `validate:"required"`
+	OnEvent      func(Event)                 // optional, called concurrently for services of the same layer
 	Logger       zerolog.Logger              `validate:"required"`
 }
 
@@ -63,12 +82,32 @@ func NewController(options *ControllerOptions) (*Controller, error) {
 		return nil, errorb.Errorf("circular dependency detected in dependencies services: %v", graph)
 	}
 
-	return &Controller{
+	controller := &Controller{
 		services: options.Services,
 		layers:   dependencysolver.LayeredTopologicalSort(graph),
 		timeouts: options.Timeouts,
+		onEvent:  options.OnEvent,
 		logger:   options.Logger,
-	}, nil
+	}
+	controller.checkStartupTimeouts(options.Dependencies)
+	controller.logger.Debug().Msgf("%d services are started in %d layers: %v", len(options.Services), len(controller.layers), controller.layers)
+	return controller, nil
+}
+
+// checkStartupTimeouts warns about services given less time to start than their slowest dependency, which usually
+// means the per service timeouts were tuned for the dependency but not for the services waiting on it.
+func (lc *Controller) checkStartupTimeouts(dependencies map[string][]string) {
+	for svcName, svcDependencies := range dependencies {
+		timeout := lc.startupTimeout(svcName)
+		for _, svcDependency := range svcDependencies {
+			if dependencyTimeout := lc.startupTimeout(svcDependency); dependencyTimeout > timeout {
+				lc.logger.Warn().Msgf(
+					"Service '%s' has startup timeout %v, shorter than startup timeout %v of its dependency '%s'",
+					svcName, timeout, dependencyTimeout, svcDependency,
+				)
+			}
+		}
+	}
 }
 
 func (lc *Controller) Start(ctx context.Context) error {
@@ -90,15 +129,22 @@ func (lc *Controller) Start(ctx context.Context) error {
 				svcCtx, cancel := context.WithTimeout(ctx, lc.startupTimeout(svcName))
 				defer cancel()
 
-				if err := svc.Start(svcCtx); err != nil {
-					lc.logger.Error().Err(err).Msgf("'%s' failed to start", svcName)
+				startedAt := time.Now()
+				err := lc.startService(svcCtx, svcName, svc)
+				duration := time.Since(startedAt)
+				if err != nil {
+					lc.logger.Error().Err(err).Dur("duration", duration).Msgf("'%s' failed to start", svcName)
+					lc.emit(Event{Service: svcName, Type: EventStartFailed, Err: err, Duration: duration})
 					failed.Store(true)
 					return
 				}
 
 				succeeded[svcIdx] = svcName
 				startedSvcs.Add(1)
-				lc.logger.Info().Msgf("Started service '%s' (%d/%d)", svcName, startedSvcs.Load(), totalSvcs)
+				lc.logger.Info().Dur("duration", duration).Msgf(
+					"Started service '%s' (%d/%d)", svcName, startedSvcs.Load(), totalSvcs,
+				)
+				lc.emit(Event{Service: svcName, Type: EventStarted, Duration: duration})
 			})
 		}
 		wg.Wait()
@@ -148,13 +194,44 @@ func (lc *Controller) rollbackLayer(ctx context.Context, layer []string) {
 			svcCtx, cancel := context.WithTimeout(ctx, lc.shutdownTimeout(svcName))
 			defer cancel()
 
-			svc.Stop(svcCtx)
-			lc.logger.Info().Msgf("Stopped service '%s'", svcName)
+			stoppedAt := time.Now()
+			lc.stopService(svcCtx, svcName, svc)
+			duration := time.Since(stoppedAt)
+			lc.logger.Info().Dur("duration", duration).Msgf("Stopped service '%s'", svcName)
+			lc.emit(Event{Service: svcName, Type: EventStopped, Duration: duration})
 		})
 	}
 	wg.Wait()
 }
 
+// startService converts a panic of Start into a startup failure, so the services already started are rolled back.
+func (lc *Controller) startService(ctx context.Context, svcName string, svc ServiceLifecycle) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			lc.logger.Error().Interface("recover", r).Bytes("stack", debug.Stack()).Msgf("'%s' panicked while starting", svcName)
+			err = errors.Errorf("service '%s' panicked while starting: %v", svcName, r)
+		}
+	}()
+	return svc.Start(ctx)
+}
+
+// stopService logs a panic of Stop and carries on, so the other services of the layer and of the following ones
+// are still stopped.
+func (lc *Controller) stopService(ctx context.Context, svcName string, svc ServiceLifecycle) {
+	defer func() {
+		if r := recover(); r != nil {
+			lc.logger.Error().Interface("recover", r).Bytes("stack", debug.Stack()).Msgf("'%s' panicked while stopping", svcName)
+		}
+	}()
+	svc.Stop(ctx)
+}
+
+func (lc *Controller) emit(event Event) {
+	if lc.onEvent != nil {
+		lc.onEvent(event)
+	}
+}
+
 func (lc *Controller) startupTimeout(service string) time.Duration {
 	if lc.timeouts.StartupPerService != nil {
 		if timeout, ok := lc.timeouts.StartupPerService[service]; ok {
@@ -183,7 +260,7 @@ func (co *ControllerOptions) setup() error {
 	}
 
 	if err := validation.Instance.Struct(co); err != nil {
-		return errorb.Wrapf(err, "failed to validate")
+		return errorb.Wrapf(validation.Aggregate(err), "failed to validate")
 	}
 
 	if co.Dependencies != nil {
@@ -0,0 +1,280 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestNewControllerWarnsAboutStartupTimeoutsShorterThanDependencies(t *testing.T) {
+	services := map[string]ServiceLifecycle{"scylla": &fakeService{}, "presence": &fakeService{}, "email": &fakeService{}}
+	dependencies := map[string][]string{"presence": {"scylla"}, "email": {"scylla"}}
+
+	tests := []struct {
+		name     string
+		timeouts ControllerTimeoutsOptions
+		warnings []string
+	}{
+		{
+			name:     "default timeouts",
+			timeouts: ControllerTimeoutsOptions{},
+		},
+		{
+			name: "dependents given more time",
+			timeouts: ControllerTimeoutsOptions{
+				Startup:           20 * time.Second,
+				StartupPerService: map[string]time.Duration{"scylla": 10 * time.Second},
+			},
+		},
+		{
+			name: "dependency given more time than the default of dependents",
+			timeouts: ControllerTimeoutsOptions{
+				StartupPerService: map[string]time.Duration{"scylla": 60 * time.Second, "email": 60 * time.Second},
+			},
+			warnings: []string{
+				"Service 'presence' has startup timeout 15s, shorter than startup timeout 1m0s of its dependency 'scylla'",
+			},
+		},
+		{
+			name: "dependent given less time than its dependency",
+			timeouts: ControllerTimeoutsOptions{
+				StartupPerService: map[string]time.Duration{"presence": time.Second, "email": 2 * time.Second},
+			},
+			warnings: []string{
+				"Service 'presence' has startup timeout 1s, shorter than startup timeout 15s of its dependency 'scylla'",
+				"Service 'email' has startup timeout 2s, shorter than startup timeout 15s of its dependency 'scylla'",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			_, err := NewController(&ControllerOptions{
+				Services:     services,
+				Dependencies: dependencies,
+				Timeouts:     tt.timeouts,
+				Logger:       zerolog.New(&logs),
+			})
+			if err != nil {
+				t.Fatalf("NewController() error = %v", err)
+			}
+
+			if got := strings.Count(logs.String(), `"level":"warn"`); got != len(tt.warnings) {
+				t.Errorf("logged %d warnings, want %d:\n%s", got, len(tt.warnings), logs.String())
+			}
+			for _, warning := range tt.warnings {
+				if !strings.Contains(logs.String(), warning) {
+					t.Errorf("expected the warning %q to be logged:\n%s", warning, logs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestLifecycleEventsCarryServiceDurations(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	services := map[string]ServiceLifecycle{
+		"scylla":   &fakeService{startDelay: delay, stopDelay: delay},
+		"presence": &fakeService{},
+	}
+	summary := NewStartupSummary()
+	var eventsMutex sync.Mutex
+	var events []Event
+	controller, err := NewController(&ControllerOptions{
+		Services:     services,
+		Dependencies: map[string][]string{"presence": {"scylla"}},
+		OnEvent: func(event Event) {
+			eventsMutex.Lock()
+			events = append(events, event)
+			eventsMutex.Unlock()
+			summary.Record(event)
+		},
+		Logger: zerolog.Nop(),
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	if err := controller.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	controller.Stop(context.Background())
+
+	for _, event := range events {
+		if event.Service == "scylla" && event.Duration < delay {
+			t.Errorf("event %d of scylla has duration %v, want at least %v", event.Type, event.Duration, delay)
+		}
+		if event.Service == "presence" && event.Duration >= delay {
+			t.Errorf("event %d of presence has duration %v, want less than %v", event.Type, event.Duration, delay)
+		}
+	}
+	if len(events) != 4 {
+		t.Fatalf("emitted events %+v, want a start and a stop per service", events)
+	}
+
+	durations := summary.Durations()
+	if scylla := durations["scylla"]; scylla.Start < delay || scylla.Stop < delay {
+		t.Errorf("durations of scylla = %+v, want start and stop of at least %v", scylla, delay)
+	}
+	if presence, ok := durations["presence"]; !ok || presence.Start >= delay {
+		t.Errorf("durations of presence = %+v, want a start shorter than %v", presence, delay)
+	}
+}
+
+func TestFailedStartDurationIsRecorded(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	summary := NewStartupSummary()
+	services := map[string]ServiceLifecycle{"scylla": &fakeService{startErr: errors.New("unreachable"), startDelay: delay}}
+
+	if err := startTestController(t, summary, services, nil); err == nil {
+		t.Fatal("Start() error = nil, want the failure of scylla")
+	}
+	if scylla := summary.Durations()["scylla"]; scylla.Start < delay {
+		t.Fatalf("durations of scylla = %+v, want a start of at least %v", scylla, delay)
+	}
+}
+
+// barrierService starts once all the services sharing the barrier are starting, failing if they don't in time.
+type barrierService struct {
+	barrier *sync.WaitGroup
+}
+
+func (s *barrierService) Start(ctx context.Context) error {
+	s.barrier.Done()
+	waited := make(chan struct{})
+	go func() {
+		s.barrier.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		return nil
+	case <-time.After(2 * time.Second):
+		return errors.New("the other services weren't started concurrently")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *barrierService) Stop(context.Context) {}
+
+func TestIndependentServicesStartConcurrently(t *testing.T) {
+	var barrier sync.WaitGroup
+	barrier.Add(4)
+	services := map[string]ServiceLifecycle{
+		"elasticsearch": &barrierService{barrier: &barrier},
+		"kafka":         &barrierService{barrier: &barrier},
+		"redis":         &barrierService{barrier: &barrier},
+		"scylla":        &barrierService{barrier: &barrier},
+	}
+
+	var logs bytes.Buffer
+	controller, err := NewController(&ControllerOptions{
+		Services:     services,
+		Dependencies: map[string][]string{}, // declared independent
+		Logger:       zerolog.New(zerolog.SyncWriter(&logs)),
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	if !strings.Contains(logs.String(), "4 services are started in 1 layers") {
+		t.Errorf("logs = %s, want the services to be started in a single layer", logs.String())
+	}
+	if err := controller.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	controller.Stop(context.Background())
+}
+
+// panickingService panics in Start or Stop as configured, recording whether it was stopped.
+type panickingService struct {
+	panicOnStart bool
+	panicOnStop  bool
+	stopped      atomic.Bool
+}
+
+func (s *panickingService) Start(context.Context) error {
+	if s.panicOnStart {
+		panic("start boom")
+	}
+	return nil
+}
+
+func (s *panickingService) Stop(context.Context) {
+	s.stopped.Store(true)
+	if s.panicOnStop {
+		panic("stop boom")
+	}
+}
+
+func TestPanickingStopDoesntPreventOthersFromStopping(t *testing.T) {
+	services := map[string]*panickingService{
+		"scylla":   {},
+		"redis":    {},
+		"presence": {panicOnStop: true},
+		"email":    {},
+		"admin":    {},
+	}
+	var logs bytes.Buffer
+	controller, err := NewController(&ControllerOptions{
+		Services: map[string]ServiceLifecycle{
+			"scylla": services["scylla"], "redis": services["redis"], "presence": services["presence"],
+			"email": services["email"], "admin": services["admin"],
+		},
+		Dependencies: map[string][]string{"presence": {"scylla", "redis"}, "email": {"scylla"}, "admin": {"presence"}},
+		Logger:       zerolog.New(zerolog.SyncWriter(&logs)),
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	if err := controller.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	controller.Stop(context.Background())
+
+	for name, service := range services {
+		if !service.stopped.Load() {
+			t.Errorf("service '%s' wasn't stopped after the panic of 'presence'", name)
+		}
+	}
+	if !strings.Contains(logs.String(), "'presence' panicked while stopping") || !strings.Contains(logs.String(), "stop boom") {
+		t.Errorf("logs = %s, want the panic of 'presence' to be logged", logs.String())
+	}
+	if !strings.Contains(logs.String(), "Stopped service 'scylla'") {
+		t.Errorf("logs = %s, want the dependencies of 'presence' to be stopped after it", logs.String())
+	}
+}
+
+func TestPanickingStartRollsBackStartedServices(t *testing.T) {
+	scylla, email := &panickingService{}, &panickingService{panicOnStart: true}
+	var events []Event
+	controller, err := NewController(&ControllerOptions{
+		Services:     map[string]ServiceLifecycle{"scylla": scylla, "email": email},
+		Dependencies: map[string][]string{"email": {"scylla"}},
+		OnEvent:      func(event Event) { events = append(events, event) }, // one service per layer
+		Logger:       zerolog.Nop(),
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+
+	err = controller.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "startup failed in layer 1") {
+		t.Fatalf("Start() error = %v, want the startup to fail in the layer of 'email'", err)
+	}
+	if !scylla.stopped.Load() {
+		t.Error("'scylla' wasn't rolled back after the panic of 'email'")
+	}
+	failed := slices.IndexFunc(events, func(event Event) bool { return event.Type == EventStartFailed })
+	if failed == -1 || events[failed].Service != "email" || !strings.Contains(events[failed].Err.Error(), "panicked while starting: start boom") {
+		t.Fatalf("events = %+v, want the panic of 'email' reported as a start failure", events)
+	}
+}
@@ -0,0 +1,104 @@
+package lifecycle
+
+import (
+	"chat/src/platform/health"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// StartupSummary collects the services started by one or more controllers, so a single event describing
+// the whole application can be logged once everything is up.
+type StartupSummary struct {
+	mutex     sync.Mutex
+	started   []string
+	failed    []string
+	bindings  map[string]string
+	durations map[string]ServiceDurations
+}
+
+// ServiceDurations are the times a service spent in its lifecycle methods, zero until the method returned.
+type ServiceDurations struct {
+	Start time.Duration `json:"start"`
+	Stop  time.Duration `json:"stop"`
+}
+
+func NewStartupSummary() *StartupSummary {
+	return &StartupSummary{
+		bindings:  make(map[string]string),
+		durations: make(map[string]ServiceDurations),
+	}
+}
+
+// Record is meant to be used as the ControllerOptions.OnEvent hook.
+func (s *StartupSummary) Record(event Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	durations := s.durations[event.Service]
+	switch event.Type {
+	case EventStarted:
+		s.started = append(s.started, event.Service)
+		durations.Start = event.Duration
+	case EventStartFailed:
+		s.failed = append(s.failed, event.Service)
+		durations.Start = event.Duration
+	case EventStopped:
+		s.started = slices.DeleteFunc(s.started, func(service string) bool { return service == event.Service })
+		durations.Stop = event.Duration
+	}
+	s.durations[event.Service] = durations
+}
+
+// Durations returns the lifecycle durations of the services recorded so far, i.e. to find the one dominating boot.
+func (s *StartupSummary) Durations() map[string]ServiceDurations {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return maps.Clone(s.durations)
+}
+
+// Bind records the address a component listens on.
+func (s *StartupSummary) Bind(component, address string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bindings[component] = address
+}
+
+// Started returns the started services in their start order.
+func (s *StartupSummary) Started() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return slices.Clone(s.started)
+}
+
+// Log emits the summary as a single event, with the health of each component at boot when it is a health
+// checked dependency.
+func (s *StartupSummary) Log(logger *zerolog.Logger, healthController *health.Controller) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current := healthController.Snapshot()
+	components := zerolog.Dict()
+	for _, service := range s.started {
+		component := zerolog.Dict().Str("state", "started").Dur("start_duration", s.durations[service].Start)
+		if result, ok := current[service]; ok {
+			component.Str("health", string(result.Status))
+		}
+		if address, ok := s.bindings[service]; ok {
+			component.Str("address", address)
+		}
+		components.Dict(service, component)
+	}
+
+	logger.Info().
+		Dict("components", components).
+		Strs("failed", s.failed).
+		Bool("healthy", healthController.Healthy()).
+		Msgf("Application started with %d components", len(s.started))
+}
@@ -0,0 +1,156 @@
+package lifecycle
+
+import (
+	"bytes"
+	"chat/src/platform/health"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type fakeService struct {
+	startErr   error
+	startDelay time.Duration
+	stopDelay  time.Duration
+}
+
+func (s *fakeService) Start(context.Context) error {
+	time.Sleep(s.startDelay)
+	return s.startErr
+}
+
+func (s *fakeService) Stop(context.Context) {
+	time.Sleep(s.stopDelay)
+}
+
+type healthyDependency struct {
+	name string
+}
+
+func (d healthyDependency) PingShallow(context.Context) health.PingResult {
+	return health.NewHealthyPingResult(d.name, health.PingDepthShallow)
+}
+
+func (d healthyDependency) PingDeep(context.Context) health.PingResult {
+	return health.NewHealthyPingResult(d.name, health.PingDepthDeep)
+}
+
+func startTestController(t *testing.T, summary *StartupSummary, services map[string]ServiceLifecycle, dependencies map[string][]string) error {
+	t.Helper()
+
+	controller, err := NewController(&ControllerOptions{
+		Services:     services,
+		Dependencies: dependencies,
+		OnEvent:      summary.Record,
+		Logger:       zerolog.Nop(),
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	return controller.Start(context.Background())
+}
+
+// newReadyHealthController returns a started health controller of the dependencies, once their initial ping is done.
+func newReadyHealthController(t *testing.T, dependencies ...string) *health.Controller {
+	t.Helper()
+
+	pingables := make(map[string]health.Pingable, len(dependencies))
+	for _, name := range dependencies {
+		pingables[name] = healthyDependency{name: name}
+	}
+	controller, err := health.NewController(&health.ControllerConfig{Dependencies: pingables, Logger: zerolog.Nop()})
+	if err != nil {
+		t.Fatalf("health.NewController() error = %v", err)
+	}
+	controller.Start()
+	t.Cleanup(controller.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := controller.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady() error = %v", err)
+	}
+	return controller
+}
+
+type loggedSummary struct {
+	Components map[string]struct {
+		State   string `json:"state"`
+		Health  string `json:"health"`
+		Address string `json:"address"`
+	} `json:"components"`
+	Failed  []string `json:"failed"`
+	Healthy bool     `json:"healthy"`
+}
+
+func logSummary(t *testing.T, summary *StartupSummary, healthController *health.Controller) loggedSummary {
+	t.Helper()
+
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+	summary.Log(&logger, healthController)
+
+	var logged loggedSummary
+	if err := json.Unmarshal(out.Bytes(), &logged); err != nil {
+		t.Fatalf("summary isn't a single JSON event: %v\n%s", err, out.String())
+	}
+	return logged
+}
+
+func TestStartupSummaryIncludesServicesOfAllControllers(t *testing.T) {
+	summary := NewStartupSummary()
+	clients := map[string]ServiceLifecycle{"redis": &fakeService{}, "nats": &fakeService{}}
+	if err := startTestController(t, summary, clients, nil); err != nil {
+		t.Fatalf("Start() of clients error = %v", err)
+	}
+	services := map[string]ServiceLifecycle{"presence": &fakeService{}, "admin": &fakeService{}}
+	if err := startTestController(t, summary, services, map[string][]string{"admin": {"presence"}}); err != nil {
+		t.Fatalf("Start() of services error = %v", err)
+	}
+	summary.Bind("admin", "0.0.0.0:9090")
+
+	if started := summary.Started(); len(started) != 4 || started[len(started)-1] != "admin" {
+		t.Fatalf("Started() = %v, want the 4 services in start order", started)
+	}
+
+	logged := logSummary(t, summary, newReadyHealthController(t, "redis", "nats"))
+	for _, name := range []string{"redis", "nats", "presence", "admin"} {
+		component, found := logged.Components[name]
+		if !found || component.State != "started" {
+			t.Errorf("summary doesn't list '%s' as started: %+v", name, logged.Components)
+		}
+	}
+	if logged.Components["redis"].Health != string(health.PingStatusHealthy) || logged.Components["presence"].Health != "" {
+		t.Errorf("summary health = %+v, want the health of checked dependencies only", logged.Components)
+	}
+	if logged.Components["admin"].Address != "0.0.0.0:9090" {
+		t.Errorf("summary address of admin = '%s', want the bound address", logged.Components["admin"].Address)
+	}
+	if len(logged.Failed) != 0 || !logged.Healthy {
+		t.Errorf("summary = failed %v, healthy %t, want no failures", logged.Failed, logged.Healthy)
+	}
+}
+
+func TestStartupSummaryReportsFailedServices(t *testing.T) {
+	summary := NewStartupSummary()
+	services := map[string]ServiceLifecycle{
+		"presence": &fakeService{},
+		"email":    &fakeService{startErr: errors.New("smtp unavailable")},
+	}
+	if err := startTestController(t, summary, services, map[string][]string{"email": {"presence"}}); err == nil {
+		t.Fatal("Start() error = nil, want the failure of email")
+	}
+
+	logged := logSummary(t, summary, newReadyHealthController(t, "redis"))
+	if !slices.Equal(logged.Failed, []string{"email"}) {
+		t.Errorf("summary failed = %v, want [email]", logged.Failed)
+	}
+	if len(logged.Components) != 0 {
+		t.Errorf("summary components = %+v, want the rolled back services to be left out", logged.Components)
+	}
+}
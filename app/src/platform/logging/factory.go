@@ -2,6 +2,7 @@ package logging
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
@@ -28,6 +29,8 @@ type regexRule struct {
 }
 
 type Options struct {
+	AppName       string
+	AppEnv        string
 	AppInstanceID string
 	AppVersion    string
 	AppCommit     string
@@ -36,6 +39,8 @@ type Options struct {
 	LiteralLevels map[string]string
 	RegexLevels   map[string]string
 	PrettyPrint   bool
+
+	output io.Writer // os.Stdout unless set by tests
 }
 
 func NewFactory(options *Options) (*LoggerFactory, error) {
@@ -48,10 +53,15 @@ func NewFactory(options *Options) (*LoggerFactory, error) {
 		return nil, errorBuilder.Wrapf(err, "error parsing rootLevel '%s'", options.RootLevel)
 	}
 
+	output := options.output
+	if output == nil {
+		output = os.Stdout
+	}
+
 	var logContext zerolog.Context
 	if options.PrettyPrint {
 		logContext = zerolog.New(zerolog.ConsoleWriter{
-			Out:           os.Stdout,
+			Out:           output,
 			TimeFormat:    time.RFC3339,
 			TimeLocation:  time.UTC,
 			PartsOrder:    []string{"time", "logger", "level", "message", "fields"},
@@ -115,7 +125,7 @@ func NewFactory(options *Options) (*LoggerFactory, error) {
 			With().
 			Timestamp()
 	} else {
-		logContext = ecszerolog.New(os.Stdout).With()
+		logContext = ecszerolog.New(output).With()
 	}
 
 	registry := &LoggerFactory{
@@ -124,6 +134,10 @@ func NewFactory(options *Options) (*LoggerFactory, error) {
 			Str("app-version", options.AppVersion).
 			Str("app-commit", options.AppCommit).
 			Str("app-build-date", options.AppBuildDate).
+			// ECS service fields, for correlation in dashboards expecting ECS
+			Str("service.name", options.AppName).
+			Str("service.version", options.AppVersion).
+			Str("service.environment", options.AppEnv).
 			Logger().
 			Level(rootLevel),
 		level: levelTable{
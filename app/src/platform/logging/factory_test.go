@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newTestOptions(output *bytes.Buffer, prettyPrint bool) *Options {
+	return &Options{
+		AppName:       "chat",
+		AppEnv:        "staging",
+		AppInstanceID: "instance-1",
+		AppVersion:    "1.2.3",
+		AppCommit:     "abc123",
+		AppBuildDate:  "2026-01-01",
+		RootLevel:     "info",
+		PrettyPrint:   prettyPrint,
+		output:        output,
+	}
+}
+
+func TestECSModeEmitsServiceFields(t *testing.T) {
+	var output bytes.Buffer
+	factory, err := NewFactory(newTestOptions(&output, false))
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+
+	logger := factory.Child("services.email")
+	logger.Info().Msg("started")
+
+	var entry map[string]any
+	if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode the log entry %q: %v", output.String(), err)
+	}
+	want := map[string]string{
+		"service.name":        "chat",
+		"service.version":     "1.2.3",
+		"service.environment": "staging",
+		"app-instance":        "instance-1",
+		"app-version":         "1.2.3",
+		"app-commit":          "abc123",
+		"app-build-date":      "2026-01-01",
+		"logger":              "services.email",
+		"message":             "started",
+	}
+	for key, value := range want {
+		if got := entry[key]; got != value {
+			t.Errorf("field %q = %v, want %q", key, got, value)
+		}
+	}
+	if _, found := entry["ecs.version"]; !found {
+		t.Errorf("expected an ECS log entry, got %q", output.String())
+	}
+}
+
+func TestPrettyModeEmitsServiceFields(t *testing.T) {
+	var output bytes.Buffer
+	factory, err := NewFactory(newTestOptions(&output, true))
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+
+	logger := factory.Child("services.email")
+	logger.Info().Msg("started")
+
+	for _, field := range []string{"service.name", "service.version", "service.environment"} {
+		if !strings.Contains(output.String(), field) {
+			t.Errorf("expected the log line to contain %q, got %q", field, output.String())
+		}
+	}
+	for _, value := range []string{"chat", "1.2.3", "staging"} {
+		if !strings.Contains(output.String(), value) {
+			t.Errorf("expected the log line to contain %q, got %q", value, output.String())
+		}
+	}
+}
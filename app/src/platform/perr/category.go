@@ -0,0 +1,147 @@
+package perr
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Category groups error codes by how a caller is expected to react to them.
+type Category uint8
+
+const (
+	CategoryServer      Category = iota // internal failure, the request might succeed later but retrying is not advised
+	CategoryRetryable                   // transient failure, the request can be retried with backoff
+	CategoryClient                      // the request is invalid, it must not be retried unchanged
+	CategoryAuth                        // the caller is not authenticated or not allowed to perform the request
+	CategoryNotFound                    // the requested resource doesn't exist
+	CategoryConflict                    // the request conflicts with the current state of the resource
+	CategoryCanceled                    // the request was canceled by the caller
+	CategoryUnsupported                 // the request is not supported by the service
+)
+
+var categories = map[string]Category{
+	EAGAIN:       CategoryRetryable,
+	EWOULDBLOCK:  CategoryRetryable,
+	EBUSY:        CategoryRetryable,
+	EINTR:        CategoryRetryable,
+	ETIMEDOUT:    CategoryRetryable,
+	ECONNREFUSED: CategoryRetryable,
+	ECONNRESET:   CategoryRetryable,
+	ECONNABORTED: CategoryRetryable,
+	EHOSTDOWN:    CategoryRetryable,
+	EHOSTUNREACH: CategoryRetryable,
+	ENETDOWN:     CategoryRetryable,
+	ENETRESET:    CategoryRetryable,
+	ENETUNREACH:  CategoryRetryable,
+	ENOTCONN:     CategoryRetryable,
+	EPIPE:        CategoryRetryable,
+	ENOBUFS:      CategoryRetryable,
+	EINPROGRESS:  CategoryRetryable,
+
+	EINVAL:       CategoryClient,
+	E2BIG:        CategoryClient,
+	EBADMSG:      CategoryClient,
+	EDOM:         CategoryClient,
+	EFBIG:        CategoryClient,
+	EILSEQ:       CategoryClient,
+	EMSGSIZE:     CategoryClient,
+	ENAMETOOLONG: CategoryClient,
+	ERANGE:       CategoryClient,
+	EOVERFLOW:    CategoryClient,
+	EDESTADDRREQ: CategoryClient,
+
+	EAUTH:  CategoryAuth,
+	EACCES: CategoryAuth,
+	EPERM:  CategoryAuth,
+
+	ENOENT: CategoryNotFound,
+	ESRCH:  CategoryNotFound,
+	ENXIO:  CategoryNotFound,
+
+	EEXIST:    CategoryConflict,
+	EALREADY:  CategoryConflict,
+	ENOTEMPTY: CategoryConflict,
+	EDEADLK:   CategoryConflict,
+
+	ECANCELED: CategoryCanceled,
+
+	ENOSYS:          CategoryUnsupported,
+	ENOTSUP:         CategoryUnsupported,
+	EOPNOTSUPP:      CategoryUnsupported,
+	EPROTONOSUPPORT: CategoryUnsupported,
+	EAFNOSUPPORT:    CategoryUnsupported,
+}
+
+// CategoryOf returns the category of an error code, unknown codes are server errors.
+func CategoryOf(code string) Category {
+	if category, ok := categories[code]; ok {
+		return category
+	}
+	return CategoryServer
+}
+
+func (c Category) String() string {
+	switch c {
+	case CategoryRetryable:
+		return "retryable"
+	case CategoryClient:
+		return "client"
+	case CategoryAuth:
+		return "auth"
+	case CategoryNotFound:
+		return "not_found"
+	case CategoryConflict:
+		return "conflict"
+	case CategoryCanceled:
+		return "canceled"
+	case CategoryUnsupported:
+		return "unsupported"
+	default:
+		return "server"
+	}
+}
+
+// HTTPStatus returns the HTTP status code a response reporting an error of this category should have.
+func (c Category) HTTPStatus() int {
+	switch c {
+	case CategoryRetryable:
+		return http.StatusServiceUnavailable
+	case CategoryClient:
+		return http.StatusBadRequest
+	case CategoryAuth:
+		return http.StatusForbidden
+	case CategoryNotFound:
+		return http.StatusNotFound
+	case CategoryConflict:
+		return http.StatusConflict
+	case CategoryCanceled:
+		return 499 // client closed request, non-standard
+	case CategoryUnsupported:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GRPCCode returns the gRPC status code a response reporting an error of this category should have.
+func (c Category) GRPCCode() codes.Code {
+	switch c {
+	case CategoryRetryable:
+		return codes.Unavailable
+	case CategoryClient:
+		return codes.InvalidArgument
+	case CategoryAuth:
+		return codes.PermissionDenied
+	case CategoryNotFound:
+		return codes.NotFound
+	case CategoryConflict:
+		return codes.AlreadyExists
+	case CategoryCanceled:
+		return codes.Canceled
+	case CategoryUnsupported:
+		return codes.Unimplemented
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,50 @@
+package perr
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestCategoryOfGroupsCodes(t *testing.T) {
+	tests := []struct {
+		code       string
+		category   Category
+		name       string
+		httpStatus int
+		grpcCode   codes.Code
+	}{
+		{code: ETIMEDOUT, category: CategoryRetryable, name: "retryable", httpStatus: http.StatusServiceUnavailable, grpcCode: codes.Unavailable},
+		{code: ECONNREFUSED, category: CategoryRetryable, name: "retryable", httpStatus: http.StatusServiceUnavailable, grpcCode: codes.Unavailable},
+		{code: EINVAL, category: CategoryClient, name: "client", httpStatus: http.StatusBadRequest, grpcCode: codes.InvalidArgument},
+		{code: EMSGSIZE, category: CategoryClient, name: "client", httpStatus: http.StatusBadRequest, grpcCode: codes.InvalidArgument},
+		{code: EAUTH, category: CategoryAuth, name: "auth", httpStatus: http.StatusForbidden, grpcCode: codes.PermissionDenied},
+		{code: EACCES, category: CategoryAuth, name: "auth", httpStatus: http.StatusForbidden, grpcCode: codes.PermissionDenied},
+		{code: ENOENT, category: CategoryNotFound, name: "not_found", httpStatus: http.StatusNotFound, grpcCode: codes.NotFound},
+		{code: EEXIST, category: CategoryConflict, name: "conflict", httpStatus: http.StatusConflict, grpcCode: codes.AlreadyExists},
+		{code: ECANCELED, category: CategoryCanceled, name: "canceled", httpStatus: 499, grpcCode: codes.Canceled},
+		{code: ENOTSUP, category: CategoryUnsupported, name: "unsupported", httpStatus: http.StatusNotImplemented, grpcCode: codes.Unimplemented},
+		{code: EIO, category: CategoryServer, name: "server", httpStatus: http.StatusInternalServerError, grpcCode: codes.Internal},
+		{code: ECONFIG, category: CategoryServer, name: "server", httpStatus: http.StatusInternalServerError, grpcCode: codes.Internal},
+		{code: "EUNKNOWN", category: CategoryServer, name: "server", httpStatus: http.StatusInternalServerError, grpcCode: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			category := CategoryOf(tt.code)
+			if category != tt.category {
+				t.Fatalf("CategoryOf(%q) = %v, want %v", tt.code, category, tt.category)
+			}
+			if got := category.String(); got != tt.name {
+				t.Errorf("String() = %q, want %q", got, tt.name)
+			}
+			if got := category.HTTPStatus(); got != tt.httpStatus {
+				t.Errorf("HTTPStatus() = %d, want %d", got, tt.httpStatus)
+			}
+			if got := category.GRPCCode(); got != tt.grpcCode {
+				t.Errorf("GRPCCode() = %v, want %v", got, tt.grpcCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package security
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type RevocationMode uint8
+
+const (
+	RevocationCheckOff RevocationMode = iota
+	// RevocationCheckOCSPStaple requires the peer to staple a good OCSP response for its leaf certificate.
+	// Only servers staple OCSP responses, so it applies to connections made to the service.
+	RevocationCheckOCSPStaple
+	// RevocationCheckCRL rejects peers whose verified chain contains a certificate listed in the CRL file.
+	RevocationCheckCRL
+)
+
+type RevocationPolicy struct {
+	Mode    RevocationMode `validate:"lte=2"`
+	CRLPath string         `validate:"required_if=Mode 2,omitempty,max=255,filepath"`
+}
+
+var (
+	ErrCertificateRevoked    = errors.New("peer certificate is revoked")
+	ErrRevocationUnavailable = errors.New("peer certificate revocation status is unavailable")
+)
+
+// revocationVerifier returns the tls.Config.VerifyConnection hook enforcing the policy, nil when the check is off.
+func revocationVerifier(policy RevocationPolicy) (func(tls.ConnectionState) error, error) {
+	switch policy.Mode {
+	case RevocationCheckOff:
+		return nil, nil //nolint:nilnil // no hook when the check is off
+	case RevocationCheckOCSPStaple:
+		return verifyOCSPStaple, nil
+	case RevocationCheckCRL:
+		crl, err := loadRevocationList(policy.CRLPath)
+		if err != nil {
+			return nil, err
+		}
+		return func(state tls.ConnectionState) error {
+			return verifyCRL(crl, state)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown revocation check mode %d", policy.Mode)
+	}
+}
+
+func verifyOCSPStaple(state tls.ConnectionState) error {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) < 2 {
+		return fmt.Errorf("%w: no verified chain with an issuer", ErrRevocationUnavailable)
+	}
+	if len(state.OCSPResponse) == 0 {
+		return fmt.Errorf("%w: peer didn't staple an OCSP response", ErrRevocationUnavailable)
+	}
+
+	leaf, issuer := state.VerifiedChains[0][0], state.VerifiedChains[0][1]
+	response, err := ocsp.ParseResponseForCert(state.OCSPResponse, leaf, issuer)
+	if err != nil {
+		return fmt.Errorf("%w: invalid stapled OCSP response: %w", ErrRevocationUnavailable, err)
+	}
+	if !response.NextUpdate.IsZero() && time.Now().After(response.NextUpdate) {
+		return fmt.Errorf("%w: stapled OCSP response expired at %v", ErrRevocationUnavailable, response.NextUpdate)
+	}
+
+	switch response.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("%w: certificate '%s' revoked at %v", ErrCertificateRevoked, leaf.Subject, response.RevokedAt)
+	default:
+		return fmt.Errorf("%w: OCSP status of certificate '%s' is unknown", ErrRevocationUnavailable, leaf.Subject)
+	}
+}
+
+func verifyCRL(crl *x509.RevocationList, state tls.ConnectionState) error {
+	if len(state.VerifiedChains) == 0 {
+		return fmt.Errorf("%w: no verified chain", ErrRevocationUnavailable)
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		return fmt.Errorf("%w: CRL expired at %v", ErrRevocationUnavailable, crl.NextUpdate)
+	}
+
+	chain := state.VerifiedChains[0]
+	for idx := 0; idx < len(chain)-1; idx++ {
+		cert, issuer := chain[idx], chain[idx+1]
+		if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+			continue
+		}
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("%w: CRL signature check failed: %w", ErrRevocationUnavailable, err)
+		}
+		if isRevoked(crl, cert.SerialNumber) {
+			return fmt.Errorf("%w: certificate '%s' is listed in the CRL", ErrCertificateRevoked, cert.Subject)
+		}
+	}
+	return nil
+}
+
+func isRevoked(crl *x509.RevocationList, serial *big.Int) bool {
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(serial) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func loadRevocationList(path string) (*x509.RevocationList, error) {
+	der, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL from path '%s': %w", path, err)
+	}
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL from path '%s': %w", path, err)
+	}
+	return crl, nil
+}
@@ -0,0 +1,227 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const testServerName = "service.test"
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	path string // of the PEM encoded certificate, to be used as truststore
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write CA certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key, path: path}
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+// issue returns a server certificate for testServerName signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64) tls.Certificate {
+	t.Helper()
+
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: testServerName},
+		DNSNames:     []string{testServerName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}
+}
+
+// respondOCSP acts as a stub OCSP responder, answering with the status of the certificate signed by the CA.
+func (ca *testCA) respondOCSP(t *testing.T, cert *x509.Certificate, status int) []byte {
+	t.Helper()
+
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+	response, err := ocsp.CreateResponse(ca.cert, ca.cert, template, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create OCSP response: %v", err)
+	}
+	return response
+}
+
+// writeCRL writes a CRL signed by the CA listing the revoked serials, returning its path.
+func (ca *testCA) writeCRL(t *testing.T, revoked ...int64) string {
+	t.Helper()
+
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create CRL: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write CRL: %v", err)
+	}
+	return path
+}
+
+// handshake connects a client using the config built for the CA truststore and policy to a server presenting the
+// certificate, returning the error of the client handshake.
+func handshake(t *testing.T, ca *testCA, policy TLSPolicy, certificate tls.Certificate) error {
+	t.Helper()
+
+	clientConfig, err := buildTLSConfig(&TLSMaterialPaths{Truststore: ca.path}, policy)
+	if err != nil {
+		t.Fatalf("buildTLSConfig() error = %v", err)
+	}
+	clientConfig.ServerName = testServerName
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	})
+	server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS13})
+	go func() {
+		_ = server.Handshake()
+		_ = server.Close()
+	}()
+
+	return tls.Client(clientConn, clientConfig).Handshake()
+}
+
+func TestOCSPStapleRevocationCheck(t *testing.T) {
+	ca := newTestCA(t)
+	policy := TLSPolicy{Revocation: RevocationPolicy{Mode: RevocationCheckOCSPStaple}}
+
+	tests := []struct {
+		name    string
+		staple  func(cert *x509.Certificate) []byte
+		wantErr error
+	}{
+		{name: "good", staple: func(cert *x509.Certificate) []byte { return ca.respondOCSP(t, cert, ocsp.Good) }},
+		{
+			name:    "revoked",
+			staple:  func(cert *x509.Certificate) []byte { return ca.respondOCSP(t, cert, ocsp.Revoked) },
+			wantErr: ErrCertificateRevoked,
+		},
+		{
+			name:    "unknown",
+			staple:  func(cert *x509.Certificate) []byte { return ca.respondOCSP(t, cert, ocsp.Unknown) },
+			wantErr: ErrRevocationUnavailable,
+		},
+		{name: "not stapled", staple: func(*x509.Certificate) []byte { return nil }, wantErr: ErrRevocationUnavailable},
+	}
+
+	for idx, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate := ca.issue(t, int64(100+idx))
+			certificate.OCSPStaple = tt.staple(certificate.Leaf)
+
+			err := handshake(t, ca, policy, certificate)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Handshake() error = %v", err)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Handshake() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCRLRevocationCheck(t *testing.T) {
+	ca := newTestCA(t)
+	policy := TLSPolicy{Revocation: RevocationPolicy{Mode: RevocationCheckCRL, CRLPath: ca.writeCRL(t, 200)}}
+
+	if err := handshake(t, ca, policy, ca.issue(t, 200)); !errors.Is(err, ErrCertificateRevoked) {
+		t.Fatalf("Handshake() error = %v, want %v", err, ErrCertificateRevoked)
+	}
+	if err := handshake(t, ca, policy, ca.issue(t, 201)); err != nil {
+		t.Fatalf("Handshake() error = %v, want the certificate not listed in the CRL to be accepted", err)
+	}
+}
+
+func TestRevocationCheckIsOffByDefault(t *testing.T) {
+	ca := newTestCA(t)
+	certificate := ca.issue(t, 300)
+	certificate.OCSPStaple = ca.respondOCSP(t, certificate.Leaf, ocsp.Revoked)
+
+	if err := handshake(t, ca, TLSPolicy{}, certificate); err != nil {
+		t.Fatalf("Handshake() error = %v, want revocation not to be checked", err)
+	}
+}
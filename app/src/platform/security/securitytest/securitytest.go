@@ -0,0 +1,67 @@
+// Package securitytest provides the certificates of the tests of the clients and servers using TLS.
+package securitytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// NewCertificate returns a self-signed ECDSA certificate valid for an hour, covering the hosts, which are either
+// IPs or DNS names. It covers 127.0.0.1 when no host is given.
+func NewCertificate(tb testing.TB, hosts ...string) *tls.Certificate {
+	tb.Helper()
+
+	if len(hosts) == 0 {
+		hosts = []string{"127.0.0.1"}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("failed to parse certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// NewTLSConfigs returns the config of a server presenting a certificate created by NewCertificate for the hosts,
+// and the config of its clients, trusting that certificate.
+func NewTLSConfigs(tb testing.TB, hosts ...string) (server, client *tls.Config) {
+	tb.Helper()
+
+	certificate := NewCertificate(tb, hosts...)
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate.Leaf)
+	server = &tls.Config{Certificates: []tls.Certificate{*certificate}, MinVersion: tls.VersionTLS12}
+	client = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	return server, client
+}
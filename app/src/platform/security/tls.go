@@ -20,6 +20,7 @@ type TLSMaterialPaths struct {
 
 type TLSPolicy struct {
 	RequireMutualTLS bool
+	Revocation       RevocationPolicy // off by default
 }
 
 type TLSServiceOptions struct {
@@ -42,14 +43,14 @@ func LoadTLSConfigs(sources *TLSConfigSources) (TLSConfigs, error) {
 		return TLSConfigs{}, fmt.Errorf("can't load tls configs, because sources setup failed: %w", err)
 	}
 
-	globalConfig, err := buildTLSConfig(&sources.Global)
+	globalConfig, err := buildTLSConfig(&sources.Global, TLSPolicy{})
 	if err != nil {
 		return TLSConfigs{}, fmt.Errorf("can't build global tls config: %w", err)
 	}
 
 	serviceConfigs := make(map[string]*tls.Config, len(sources.Services))
 	for svcName, svcOptions := range sources.Services {
-		svcConfig, err := buildTLSConfig(&svcOptions.Paths)
+		svcConfig, err := buildTLSConfig(&svcOptions.Paths, svcOptions.Policy)
 		if err != nil {
 			return TLSConfigs{}, fmt.Errorf("can't build tls config for service '%s': %w", svcName, err)
 		}
@@ -62,7 +63,7 @@ func LoadTLSConfigs(sources *TLSConfigSources) (TLSConfigs, error) {
 	}, nil
 }
 
-func buildTLSConfig(paths *TLSMaterialPaths) (*tls.Config, error) {
+func buildTLSConfig(paths *TLSMaterialPaths, policy TLSPolicy) (*tls.Config, error) {
 	// 1. Load trusted CA bundle
 	caBytes, err := os.ReadFile(paths.Truststore)
 	if err != nil {
@@ -93,50 +94,126 @@ func buildTLSConfig(paths *TLSMaterialPaths) (*tls.Config, error) {
 		certificates = append(certificates, cert)
 	}
 
-	// 3. Create TLS config
+	// 3. Set up revocation checking
+	verifyConnection, err := revocationVerifier(policy.Revocation)
+	if err != nil {
+		return nil, oops.
+			In(util.GetFunctionName()).
+			Code(perr.ECONFIG).
+			Wrapf(err, "failed to set up revocation checking")
+	}
+
+	// 4. Create TLS config
 	return &tls.Config{
-		RootCAs:       caPool,
-		Certificates:  certificates,
-		MinVersion:    tls.VersionTLS13,
-		Renegotiation: tls.RenegotiateNever,
+		RootCAs:          caPool,
+		Certificates:     certificates,
+		MinVersion:       tls.VersionTLS13,
+		Renegotiation:    tls.RenegotiateNever,
+		VerifyConnection: verifyConnection,
 	}, nil
 }
 
+// tlsMaterial is the set of TLS material paths specified by a service.
+type tlsMaterial uint8
+
+const (
+	materialTruststore tlsMaterial = 1 << iota
+	materialCertificate
+	materialKey
+
+	materialNone    tlsMaterial = 0
+	materialKeyPair             = materialCertificate | materialKey
+	materialAll                 = materialTruststore | materialKeyPair
+)
+
+func (p *TLSMaterialPaths) material() tlsMaterial {
+	var material tlsMaterial
+	if p.Truststore != "" {
+		material |= materialTruststore
+	}
+	if p.Certificate != "" {
+		material |= materialCertificate
+	}
+	if p.Key != "" {
+		material |= materialKey
+	}
+	return material
+}
+
 func (c *TLSConfigSources) setup() error {
 	errorb := oops.
 		In(util.GetFunctionName()).
 		Code(perr.ECONFIG)
 
 	if err := validation.Instance.Struct(c); err != nil {
-		return errorb.Wrapf(err, "failed to validate")
+		return errorb.Wrapf(validation.Aggregate(err), "failed to validate")
 	}
 
-	if c.Global.Truststore == "" || c.Global.Certificate == "" || c.Global.Key == "" {
+	if c.Global.material() != materialAll {
 		return errorb.New("global TLS material paths must be all specified") //nolint:wrapcheck // we are already wrapping it
 	}
 
 	for svcName, svcConfig := range c.Services {
-		if svcConfig.Policy.RequireMutualTLS {
-			if svcConfig.Paths.Truststore != "" && svcConfig.Paths.Certificate != "" && svcConfig.Paths.Key != "" {
-				continue
-			}
-			if svcConfig.Paths.Truststore == "" && svcConfig.Paths.Certificate == "" && svcConfig.Paths.Key == "" {
-				svcConfig.Paths = c.Global
-				c.Services[svcName] = svcConfig
-				continue
-			}
-			return errorb.Errorf("service '%s' requires eithr all or none of TLS material paths to be specified for mTLS", svcName)
+		paths, err := c.resolveServicePaths(svcName, svcConfig)
+		if err != nil {
+			return errorb.Wrap(err)
 		}
+		svcConfig.Paths = paths
+		c.Services[svcName] = svcConfig
+	}
 
-		if svcConfig.Paths.Certificate == "" && svcConfig.Paths.Key == "" {
-			if svcConfig.Paths.Truststore == "" {
-				svcConfig.Paths.Truststore = c.Global.Truststore
-				c.Services[svcName] = svcConfig
-			}
-			continue
+	return nil
+}
+
+// resolveServicePaths applies the TLS material state machine of a service:
+//   - mTLS with all material: used as is
+//   - mTLS with no material: inherits the global material
+//   - no mTLS with no material: inherits the global truststore
+//   - no mTLS with truststore only: used as is
+//
+// Any other combination is invalid. Service specific files must exist.
+func (c *TLSConfigSources) resolveServicePaths(svcName string, svcConfig TLSServiceOptions) (TLSMaterialPaths, error) {
+	paths := svcConfig.Paths
+	material := paths.material()
+
+	if svcConfig.Policy.RequireMutualTLS {
+		switch material {
+		case materialAll:
+			return paths, requireFiles(svcName, paths.Truststore, paths.Certificate, paths.Key)
+		case materialNone:
+			return c.Global, nil
+		case materialKeyPair:
+			return paths, fmt.Errorf("service '%s' requires mTLS, but specifies the certificate and key without a truststore", svcName)
+		default:
+			return paths, fmt.Errorf(
+				"service '%s' requires mTLS, but specifies only some of truststore, certificate and key (truststore: %t, certificate: %t, key: %t), all or none must be specified",
+				svcName, material&materialTruststore != 0, material&materialCertificate != 0, material&materialKey != 0,
+			)
 		}
-		return errorb.Errorf("service '%s' requires either no TLS material paths or only truststore when mTLS is disabled: given %+v", svcName, svcConfig)
 	}
 
+	switch material {
+	case materialNone:
+		return TLSMaterialPaths{Truststore: c.Global.Truststore}, nil
+	case materialTruststore:
+		return paths, requireFiles(svcName, paths.Truststore)
+	default:
+		return paths, fmt.Errorf(
+			"service '%s' doesn't require mTLS, but specifies a certificate or key, only a truststore may be specified (certificate: %t, key: %t)",
+			svcName, material&materialCertificate != 0, material&materialKey != 0,
+		)
+	}
+}
+
+func requireFiles(svcName string, paths ...string) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("TLS material '%s' of service '%s' is not accessible: %w", path, svcName, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("TLS material '%s' of service '%s' is a directory", path, svcName)
+		}
+	}
 	return nil
 }
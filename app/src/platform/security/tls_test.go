@@ -0,0 +1,193 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeMaterial creates a file in the directory for each of the names, returning their paths.
+func writeMaterial(t *testing.T, dir string, names ...string) []string {
+	t.Helper()
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o600); err != nil {
+			t.Fatalf("failed to write '%s': %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestResolveServicePaths(t *testing.T) {
+	dir := t.TempDir()
+	global := writeMaterial(t, dir, "global-ca.crt", "global.crt", "global.key")
+	service := writeMaterial(t, dir, "service-ca.crt", "service.crt", "service.key")
+	missing := filepath.Join(dir, "missing.crt")
+	directory := filepath.Join(dir, "directory.crt")
+	if err := os.Mkdir(directory, 0o700); err != nil {
+		t.Fatalf("failed to create '%s': %v", directory, err)
+	}
+
+	sources := &TLSConfigSources{
+		Global: TLSMaterialPaths{Truststore: global[0], Certificate: global[1], Key: global[2]},
+	}
+	serviceAll := TLSMaterialPaths{Truststore: service[0], Certificate: service[1], Key: service[2]}
+
+	tests := []struct {
+		name      string
+		mTLS      bool
+		paths     TLSMaterialPaths
+		wantPaths TLSMaterialPaths
+		wantErr   string
+	}{
+		{name: "mTLS with all material", mTLS: true, paths: serviceAll, wantPaths: serviceAll},
+		{name: "mTLS with no material inherits global material", mTLS: true, wantPaths: sources.Global},
+		{
+			name:    "mTLS with key pair only",
+			mTLS:    true,
+			paths:   TLSMaterialPaths{Certificate: service[1], Key: service[2]},
+			wantErr: "specifies the certificate and key without a truststore",
+		},
+		{
+			name:    "mTLS with truststore only",
+			mTLS:    true,
+			paths:   TLSMaterialPaths{Truststore: service[0]},
+			wantErr: "(truststore: true, certificate: false, key: false), all or none must be specified",
+		},
+		{
+			name:    "mTLS with truststore and certificate",
+			mTLS:    true,
+			paths:   TLSMaterialPaths{Truststore: service[0], Certificate: service[1]},
+			wantErr: "(truststore: true, certificate: true, key: false), all or none must be specified",
+		},
+		{
+			name:    "mTLS with truststore and key",
+			mTLS:    true,
+			paths:   TLSMaterialPaths{Truststore: service[0], Key: service[2]},
+			wantErr: "(truststore: true, certificate: false, key: true), all or none must be specified",
+		},
+		{
+			name:    "mTLS with certificate only",
+			mTLS:    true,
+			paths:   TLSMaterialPaths{Certificate: service[1]},
+			wantErr: "(truststore: false, certificate: true, key: false), all or none must be specified",
+		},
+		{
+			name:    "mTLS with key only",
+			mTLS:    true,
+			paths:   TLSMaterialPaths{Key: service[2]},
+			wantErr: "(truststore: false, certificate: false, key: true), all or none must be specified",
+		},
+		{
+			name:    "mTLS with missing truststore",
+			mTLS:    true,
+			paths:   TLSMaterialPaths{Truststore: missing, Certificate: service[1], Key: service[2]},
+			wantErr: "TLS material '" + missing + "' of service 'svc' is not accessible",
+		},
+		{name: "no mTLS with no material inherits global truststore", wantPaths: TLSMaterialPaths{Truststore: global[0]}},
+		{
+			name:      "no mTLS with truststore only",
+			paths:     TLSMaterialPaths{Truststore: service[0]},
+			wantPaths: TLSMaterialPaths{Truststore: service[0]},
+		},
+		{
+			name:    "no mTLS with missing truststore",
+			paths:   TLSMaterialPaths{Truststore: missing},
+			wantErr: "TLS material '" + missing + "' of service 'svc' is not accessible",
+		},
+		{
+			name:    "no mTLS with directory as truststore",
+			paths:   TLSMaterialPaths{Truststore: directory},
+			wantErr: "TLS material '" + directory + "' of service 'svc' is a directory",
+		},
+		{
+			name:    "no mTLS with all material",
+			paths:   serviceAll,
+			wantErr: "only a truststore may be specified (certificate: true, key: true)",
+		},
+		{
+			name:    "no mTLS with key pair only",
+			paths:   TLSMaterialPaths{Certificate: service[1], Key: service[2]},
+			wantErr: "only a truststore may be specified (certificate: true, key: true)",
+		},
+		{
+			name:    "no mTLS with truststore and certificate",
+			paths:   TLSMaterialPaths{Truststore: service[0], Certificate: service[1]},
+			wantErr: "only a truststore may be specified (certificate: true, key: false)",
+		},
+		{
+			name:    "no mTLS with truststore and key",
+			paths:   TLSMaterialPaths{Truststore: service[0], Key: service[2]},
+			wantErr: "only a truststore may be specified (certificate: false, key: true)",
+		},
+		{
+			name:    "no mTLS with certificate only",
+			paths:   TLSMaterialPaths{Certificate: service[1]},
+			wantErr: "only a truststore may be specified (certificate: true, key: false)",
+		},
+		{
+			name:    "no mTLS with key only",
+			paths:   TLSMaterialPaths{Key: service[2]},
+			wantErr: "only a truststore may be specified (certificate: false, key: true)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := TLSServiceOptions{Paths: tt.paths, Policy: TLSPolicy{RequireMutualTLS: tt.mTLS}}
+			paths, err := sources.resolveServicePaths("svc", options)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveServicePaths() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveServicePaths() error = %v", err)
+			}
+			if paths != tt.wantPaths {
+				t.Fatalf("resolveServicePaths() = %+v, want %+v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestSetupResolvesServicePaths(t *testing.T) {
+	dir := t.TempDir()
+	global := writeMaterial(t, dir, "global-ca.crt", "global.crt", "global.key")
+	sources := &TLSConfigSources{
+		Global: TLSMaterialPaths{Truststore: global[0], Certificate: global[1], Key: global[2]},
+		Services: map[string]TLSServiceOptions{
+			"kafka": {Policy: TLSPolicy{RequireMutualTLS: true}},
+			"redis": {},
+		},
+	}
+
+	if err := sources.setup(); err != nil {
+		t.Fatalf("setup() error = %v", err)
+	}
+	if got := sources.Services["kafka"].Paths; got != sources.Global {
+		t.Errorf("kafka paths = %+v, want the global ones %+v", got, sources.Global)
+	}
+	if got, want := sources.Services["redis"].Paths, (TLSMaterialPaths{Truststore: global[0]}); got != want {
+		t.Errorf("redis paths = %+v, want %+v", got, want)
+	}
+}
+
+func TestSetupRequiresAllGlobalMaterial(t *testing.T) {
+	dir := t.TempDir()
+	global := writeMaterial(t, dir, "global-ca.crt", "global.crt", "global.key")
+	sources := &TLSConfigSources{
+		Global:   TLSMaterialPaths{Truststore: global[0]},
+		Services: map[string]TLSServiceOptions{"redis": {}},
+	}
+
+	err := sources.setup()
+	if err == nil || !strings.Contains(err.Error(), "global TLS material paths must be all specified") {
+		t.Fatalf("setup() error = %v, want the global material to be required", err)
+	}
+}
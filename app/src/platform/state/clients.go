@@ -10,18 +10,22 @@ import (
 	"chat/src/clients/postgresql"
 	"chat/src/clients/redis"
 	"chat/src/clients/scylla"
+	"chat/src/platform/components"
 	"chat/src/platform/config"
 	"chat/src/platform/logging"
+	translator "chat/src/util/static-address-translator"
 	"crypto/tls"
 	"fmt"
 	"time"
 
 	"github.com/emersion/go-sasl"
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
 type KafkaClients struct {
-	Admin *kafka.Client
-	Data  *kafka.Client
+	Admin       *kafka.Client
+	Data        *kafka.Client
+	DataMetrics *kafka.RequestMetrics
 }
 
 type StorageClients struct {
@@ -31,25 +35,41 @@ type StorageClients struct {
 	PostgreSQL    *postgresql.Client
 	Redis         *redis.Client
 	ScyllaDB      *scylla.Client
+	ScyllaMetrics *scylla.QueryMetrics
 	Nats          *nats.Client
 	Email         *email.Client
 	Kafka         KafkaClients
 }
 
+// ClientDependencies returns the start dependencies between the clients created by CreateClients, which is none:
+// each client connects to its own backend on Start, so the lifecycle controller starts all of them concurrently,
+// in a single layer. Only a real dependency may be declared here, as it delays the client to a later layer.
+func ClientDependencies() map[string][]string {
+	return map[string][]string{}
+}
+
 func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, loggerFactory *logging.LoggerFactory) (*StorageClients, error) {
 	// Elasticsearch Client
-	elasticsearchClient := elasticsearch.NewClient(&elasticsearch.ClientOptions{
+	elasticsearchClient, err := elasticsearch.NewClient(&elasticsearch.ClientOptions{
 		Addresses:    config.Elasticsearch.Addresses,
 		TLSConfig:    tlsConfig[elasticsearch.PingTargetName],
 		Username:     config.Elasticsearch.Username,
 		Password:     string(config.Elasticsearch.Password),
 		ShouldLogReq: config.Elasticsearch.ShouldLogReq,
 		ShouldLogRes: config.Elasticsearch.ShouldLogRes,
+		HealthThresholds: elasticsearch.HealthThresholds{
+			PendingTasks:          config.Elasticsearch.Health.PendingTasks,
+			InFlightFetches:       config.Elasticsearch.Health.InFlightFetches,
+			TaskMaxWaitingInQueue: config.Elasticsearch.Health.TaskMaxWaitingInQueue,
+		},
 		Logger: elasticsearch.ClientLoggerOptions{
-			Client: loggerFactory.Child("client.elasticsearch"),
-			Driver: loggerFactory.Child("client.elasticsearch.driver"),
+			Client: loggerFactory.Child(components.ClientLogger(components.Elasticsearch)),
+			Driver: loggerFactory.Child(components.ClientLogger(components.Elasticsearch) + ".driver"),
 		},
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create elasticsearch client: %w", err)
+	}
 
 	// Neo4j Client
 	neo4jClient := neo4j.NewClient(&neo4j.ClientOptions{
@@ -59,9 +79,9 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 		Password:     string(config.Neo4j.Password),
 		DatabaseName: config.Neo4j.DatabaseName,
 		Logger: neo4j.ClientLoggerOptions{
-			Client:  loggerFactory.Child("client.neo4j"),
-			Driver:  loggerFactory.Child("client.neo4j.driver"),
-			Session: loggerFactory.Child("client.neo4j.session"),
+			Client:  loggerFactory.Child(components.ClientLogger(components.Neo4j)),
+			Driver:  loggerFactory.Child(components.ClientLogger(components.Neo4j) + ".driver"),
+			Session: loggerFactory.Child(components.ClientLogger(components.Neo4j) + ".session"),
 		},
 	})
 
@@ -77,7 +97,11 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 		TLSConfig:               tlsConfig[postgresql.PingTargetName],
 		ApplicationInstanceName: config.Application.InstanceName,
 		PreparedStatements:      nil,
-		Logger:                  loggerFactory.Child("client.postgresql"),
+		HealthThresholds: postgresql.HealthThresholds{
+			ReplicationLag: config.PostgreSQL.Health.ReplicationLag,
+			Connections:    config.PostgreSQL.Health.Connections,
+		},
+		Logger: loggerFactory.Child(components.ClientLogger(components.PostgreSQL)),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create postgresql client: %w", err)
@@ -90,7 +114,7 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 		Username:   config.Redis.Username,
 		Password:   string(config.Redis.Password),
 		ClientName: config.Application.InstanceName,
-		Logger:     loggerFactory.Child("client.redis"),
+		Logger:     loggerFactory.Child(components.ClientLogger(components.Redis)),
 	})
 
 	// Etcd Client
@@ -98,12 +122,13 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 		Endpoints: config.Etcd.Endpoints,
 		TLSConfig: tlsConfig[etcd.PingTargetName],
 		Logger: etcd.ClientLoggerOptions{
-			Client: loggerFactory.Child("client.etcd"),
-			Driver: loggerFactory.Child("client.etcd.driver"),
+			Client: loggerFactory.Child(components.ClientLogger(components.Etcd)),
+			Driver: loggerFactory.Child(components.ClientLogger(components.Etcd) + ".driver"),
 		},
 	})
 
 	// ScyllaDB Client
+	scyllaMetrics := scylla.NewQueryMetrics()
 	scyllaClient := scylla.NewClient(&scylla.ClientOptions{
 		Hosts:          config.ScyllaDB.Hosts,
 		ShardAwarePort: config.ScyllaDB.ShardAwarePort,
@@ -112,24 +137,46 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 		Username:       config.ScyllaDB.Username,
 		Password:       string(config.ScyllaDB.Password),
 		Keyspace:       config.ScyllaDB.Keyspace,
+		Compression:    scylla.ParseCompression(config.ScyllaDB.Compression),
+		Metrics:        scyllaMetrics,
+		SlowQuery:      config.ScyllaDB.SlowQuery,
+		Retry: scylla.RetryOptions{
+			NumRetries: config.ScyllaDB.Retries.NumRetries,
+			MinBackoff: config.ScyllaDB.Retries.MinBackoff,
+			MaxBackoff: config.ScyllaDB.Retries.MaxBackoff,
+		},
+		Speculative: scylla.SpeculativeOptions{
+			Attempts: config.ScyllaDB.SpeculativeExecution.Attempts,
+			Delay:    config.ScyllaDB.SpeculativeExecution.Delay,
+		},
 		Logger: scylla.ClientLoggerOptions{
-			Client: loggerFactory.Child("client.scylla"),
-			Driver: loggerFactory.Child("client.scylla.driver"),
+			Client: loggerFactory.Child(components.ClientLogger(components.ScyllaDB)),
+			Driver: loggerFactory.Child(components.ClientLogger(components.ScyllaDB) + ".driver"),
 		},
 	})
 
 	// Nats Client
-	natsClient := nats.NewClient(&nats.ClientOptions{
+	natsClientOptions := &nats.ClientOptions{
 		Servers:    config.Nats.Servers,
 		TLSConfig:  tlsConfig[nats.PingTargetName],
 		ClientName: config.Application.InstanceName,
 		Username:   config.Nats.Username,
 		Password:   string(config.Nats.Password),
-		Logger:     loggerFactory.Child("client.nats"),
-	})
+		Logger:     loggerFactory.Child(components.ClientLogger(components.Nats)),
+	}
+	if len(config.Nats.AddressTranslations) > 0 {
+		natsClientOptions.AddressTranslator, err = translator.NewStaticAddressTranslator(
+			config.Nats.AddressTranslations, translator.ParseCollisionPolicy(config.Nats.AddressCollisions),
+			loggerFactory.ChildPtr(components.ClientLogger(components.Nats)+".translator"),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create NATS address translator: %w", err)
+		}
+	}
+	natsClient := nats.NewClient(natsClientOptions)
 
 	// Email Client
-	emailClient := email.NewClient(&email.ClientOptions{
+	emailClient, err := email.NewClient(&email.ClientOptions{
 		WorkerPoolOptions: email.WorkerPoolOptions{
 			SMTPClientOptions: &email.SMTPClientOptions{
 				Host:              config.Email.SMTPHost,
@@ -140,16 +187,25 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 				CommandTimeout:    10 * time.Second,
 				SubmissionTimeout: 15 * time.Second,
 				SendTimeout:       60 * time.Second,
+				MaxMessageSize:    config.Email.MaxMessageSize,
+				ChunkingThreshold: 1 << 20, // 1MiB
+				ChunkSize:         1 << 20, // 1MiB
 				Logger:            nil,
 			},
-			Logger:     loggerFactory.ChildPtr("client.email"),
-			NumWorkers: config.Email.NumWorkers,
-			QueueSize:  config.Email.QueueSize,
+			Logger:      loggerFactory.ChildPtr(components.ClientLogger(components.Email)),
+			NumWorkers:  config.Email.NumWorkers,
+			QueueSize:   config.Email.QueueSize,
+			MaxWorkers:  config.Email.MaxWorkers,
+			IdleTimeout: config.Email.WorkerIdleTimeout,
 		}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create email client: %w", err)
+	}
 
 	// Kafka Clients
 	var kafkaAdminClient *kafka.Client
 	var kafkaDataClient *kafka.Client
+	kafkaDataMetrics := kafka.NewRequestMetrics()
 
 	commonKafkaGeneralConfig := kafka.GeneralConfig{
 		ClientID:       fmt.Sprintf("kgo-%s", config.Application.Name),
@@ -158,21 +214,31 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 		SeedBrokers:    config.Kafka.SeedBrokers,
 		TLSConfig:      tlsConfig[kafka.PingTargetName],
 	}
+	if len(config.Kafka.AddressTranslations) > 0 {
+		commonKafkaGeneralConfig.AddressTranslator, err = translator.NewStaticAddressTranslator(
+			config.Kafka.AddressTranslations, translator.ParseCollisionPolicy(config.Kafka.AddressCollisions),
+			loggerFactory.ChildPtr("clients.kafka.translator"),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kafka address translator: %w", err)
+		}
+	}
 
 	{
 		builder := kafka.NewConfigurationBuilder(&kafka.ConfigurationLoggers{
-			Client: loggerFactory.Child("client.kafka.admin"),
-			Driver: loggerFactory.Child("client.kafka.admin.driver"),
+			Client: loggerFactory.Child(components.ClientLogger(components.KafkaAdmin)),
+			Driver: loggerFactory.Child(components.ClientLogger(components.KafkaAdmin) + ".driver"),
 		})
 
 		builder.SetGeneralConfig(&kafka.GeneralConfig{
-			ClientID:       commonKafkaGeneralConfig.ClientID,
-			ServiceName:    commonKafkaGeneralConfig.ServiceName,
-			ServiceVersion: commonKafkaGeneralConfig.ServiceVersion,
-			SeedBrokers:    commonKafkaGeneralConfig.SeedBrokers,
-			TLSConfig:      commonKafkaGeneralConfig.TLSConfig,
-			Username:       config.Kafka.Users.Admin.Username,
-			Password:       string(config.Kafka.Users.Admin.Password),
+			ClientID:          commonKafkaGeneralConfig.ClientID,
+			ServiceName:       commonKafkaGeneralConfig.ServiceName,
+			ServiceVersion:    commonKafkaGeneralConfig.ServiceVersion,
+			SeedBrokers:       commonKafkaGeneralConfig.SeedBrokers,
+			TLSConfig:         commonKafkaGeneralConfig.TLSConfig,
+			AddressTranslator: commonKafkaGeneralConfig.AddressTranslator,
+			Username:          config.Kafka.Users.Admin.Username,
+			Password:          string(config.Kafka.Users.Admin.Password),
 		})
 
 		client, err := kafka.NewClient(builder)
@@ -183,25 +249,35 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 	}
 	{
 		builder := kafka.NewConfigurationBuilder(&kafka.ConfigurationLoggers{
-			Client: loggerFactory.Child("client.kafka.data"),
-			Driver: loggerFactory.Child("client.kafka.data.driver"),
+			Client: loggerFactory.Child(components.ClientLogger(components.KafkaData)),
+			Driver: loggerFactory.Child(components.ClientLogger(components.KafkaData) + ".driver"),
 		})
 
 		builder.SetGeneralConfig(&kafka.GeneralConfig{
-			ClientID:       commonKafkaGeneralConfig.ClientID,
-			ServiceName:    commonKafkaGeneralConfig.ServiceName,
-			ServiceVersion: commonKafkaGeneralConfig.ServiceVersion,
-			SeedBrokers:    commonKafkaGeneralConfig.SeedBrokers,
-			TLSConfig:      commonKafkaGeneralConfig.TLSConfig,
-			Username:       config.Kafka.Users.Data.Username,
-			Password:       string(config.Kafka.Users.Data.Password),
+			ClientID:          commonKafkaGeneralConfig.ClientID,
+			ServiceName:       commonKafkaGeneralConfig.ServiceName,
+			ServiceVersion:    commonKafkaGeneralConfig.ServiceVersion,
+			SeedBrokers:       commonKafkaGeneralConfig.SeedBrokers,
+			TLSConfig:         commonKafkaGeneralConfig.TLSConfig,
+			AddressTranslator: commonKafkaGeneralConfig.AddressTranslator,
+			Username:          config.Kafka.Users.Data.Username,
+			Password:          string(config.Kafka.Users.Data.Password),
+			Metrics:           kafkaDataMetrics,
+		})
+		builder.SetProducerConfig(&kafka.ProducerConfig{
+			DataLossPolicy: kafka.ParseDataLossPolicy(config.Kafka.DataLossPolicy),
+		})
+		resetOffset := kafka.ResetOffset(config.Kafka.ConsumeResetPolicy)
+		builder.SetConsumerConfig(&kafka.ConsumerConfig{
+			ConsumeResetOffset: &resetOffset,
 		})
-		builder.SetProducerConfig(&kafka.ProducerConfig{})
-		builder.SetConsumerConfig(&kafka.ConsumerConfig{})
 		builder.SetConsumerGroupConfig(&kafka.ConsumerGroupConfig{
 			GroupID:         config.Kafka.GroupID,
 			InstanceID:      config.Application.InstanceName,
 			AutoCommitMarks: true,
+			TopicResetOffsets: map[string]kgo.Offset{
+				config.Kafka.Topics.EmailDelivery: kafka.ResetOffset(config.Kafka.EmailResetPolicy),
+			},
 		})
 
 		client, err := kafka.NewClient(builder)
@@ -218,11 +294,13 @@ func CreateClients(config *config.Config, tlsConfig map[string]*tls.Config, logg
 		PostgreSQL:    postgresClient,
 		Redis:         redisClient,
 		ScyllaDB:      scyllaClient,
+		ScyllaMetrics: scyllaMetrics,
 		Nats:          natsClient,
 		Email:         emailClient,
 		Kafka: KafkaClients{
-			Admin: kafkaAdminClient,
-			Data:  kafkaDataClient,
+			Admin:       kafkaAdminClient,
+			Data:        kafkaDataClient,
+			DataMetrics: kafkaDataMetrics,
 		},
 	}, nil
 }
@@ -0,0 +1,25 @@
+package state
+
+import (
+	"chat/src/clients/kafka"
+	"chat/src/clients/kafka/routing"
+	"chat/src/platform/config"
+
+	"github.com/rs/zerolog"
+)
+
+// ConsumerRouterOptions returns the options of the router consuming with the client, tuned by the router section of
+// the Kafka config.
+func ConsumerRouterOptions(config *config.KafkaConfig, client *kafka.Client, logger *zerolog.Logger) *routing.ConsumerRouterOptions {
+	return &routing.ConsumerRouterOptions{
+		Client:              client,
+		MinHandlerTimeout:   config.Router.MinHandlerTimeout,
+		MaxHandlerTimeout:   config.Router.MaxHandlerTimeout,
+		HandlerConcurrency:  config.Router.HandlerConcurrency,
+		MaxPollRecords:      config.Router.MaxPollRecords,
+		BackpressurePause:   config.Router.BackpressurePause,
+		RevokeTimeout:       config.Router.RevokeTimeout,
+		MessageAgeThreshold: config.MessageAgeThreshold,
+		Logger:              logger,
+	}
+}
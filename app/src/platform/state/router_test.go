@@ -0,0 +1,63 @@
+package state
+
+import (
+	"chat/src/clients/kafka/routing"
+	"chat/src/platform/config"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/creasty/defaults"
+	"github.com/rs/zerolog"
+)
+
+func TestConsumerRouterOptionsCarryRouterConfig(t *testing.T) {
+	var cfg config.KafkaConfig
+	if err := defaults.Set(&cfg); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	cfg.Router = config.KafkaRouterConfig{
+		MinHandlerTimeout:  200 * time.Millisecond,
+		MaxHandlerTimeout:  8 * time.Second,
+		HandlerConcurrency: 20,
+		MaxPollRecords:     50,
+		BackpressurePause:  3 * time.Second,
+		RevokeTimeout:      20 * time.Second,
+	}
+	cfg.MessageAgeThreshold = time.Minute
+	logger := zerolog.Nop()
+
+	options := ConsumerRouterOptions(&cfg, nil, &logger)
+	want := routing.ConsumerRouterOptions{
+		MinHandlerTimeout:   200 * time.Millisecond,
+		MaxHandlerTimeout:   8 * time.Second,
+		HandlerConcurrency:  20,
+		MaxPollRecords:      50,
+		BackpressurePause:   3 * time.Second,
+		RevokeTimeout:       20 * time.Second,
+		MessageAgeThreshold: time.Minute,
+		Logger:              &logger,
+	}
+	if !reflect.DeepEqual(*options, want) {
+		t.Fatalf("ConsumerRouterOptions() = %+v, want %+v", *options, want)
+	}
+}
+
+func TestRouterConfigDefaultsMatchRouterDefaults(t *testing.T) {
+	var cfg config.KafkaConfig
+	if err := defaults.Set(&cfg); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	logger := zerolog.Nop()
+	options := ConsumerRouterOptions(&cfg, nil, &logger)
+
+	var want routing.ConsumerRouterOptions
+	if err := defaults.Set(&want); err != nil {
+		t.Fatalf("defaults.Set() error = %v", err)
+	}
+	if options.MinHandlerTimeout != want.MinHandlerTimeout || options.MaxHandlerTimeout != want.MaxHandlerTimeout ||
+		options.HandlerConcurrency != want.HandlerConcurrency || options.MaxPollRecords != want.MaxPollRecords ||
+		options.BackpressurePause != want.BackpressurePause || options.RevokeTimeout != want.RevokeTimeout {
+		t.Fatalf("ConsumerRouterOptions() of the default config = %+v, want the router defaults %+v", *options, want)
+	}
+}
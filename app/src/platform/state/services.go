@@ -2,10 +2,12 @@ package state
 
 import (
 	"chat/src/services/email"
+	"chat/src/services/fanout"
 	"chat/src/services/presence"
 )
 
 type Services struct {
 	Presence *presence.Service
 	Email    *email.Service
+	Fanout   *fanout.Service
 }
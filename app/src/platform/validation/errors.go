@@ -0,0 +1,124 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// AggregateError reports all the rules violated by a struct at once, one per line.
+type AggregateError struct {
+	Errors   validator.ValidationErrors
+	KeyPaths []string // parallel to Errors when set, the config keys of the failing fields
+}
+
+// Aggregate converts the error returned by Instance.Struct into an *AggregateError.
+// Errors other than validator.ValidationErrors are returned unchanged.
+func Aggregate(err error) error {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+	return &AggregateError{Errors: validationErrors}
+}
+
+// AggregateKeyPaths is like Aggregate, but reports the failing fields by their config key paths, built from the
+// values of the given struct tag (e.g. `koanf`) of the root struct, so `Config.Kafka.SeedBrokers` becomes
+// `kafka.seed_brokers`. Fields squashed into their parent don't contribute a path segment.
+func AggregateKeyPaths(err error, root any, tag string) error {
+	aggregated := Aggregate(err)
+
+	var aggregateError *AggregateError
+	if !errors.As(aggregated, &aggregateError) {
+		return aggregated
+	}
+
+	rootType := reflect.TypeOf(root)
+	aggregateError.KeyPaths = make([]string, len(aggregateError.Errors))
+	for idx, fieldError := range aggregateError.Errors {
+		aggregateError.KeyPaths[idx] = keyPath(rootType, fieldError.StructNamespace(), tag)
+	}
+	return aggregateError
+}
+
+func (e *AggregateError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d validation error(s):", len(e.Errors))
+	for idx, fieldError := range e.Errors {
+		sb.WriteString("\n  - ")
+		if idx < len(e.KeyPaths) {
+			sb.WriteString(e.KeyPaths[idx])
+		} else {
+			sb.WriteString(fieldError.Namespace())
+		}
+		sb.WriteString(": failed '")
+		sb.WriteString(fieldError.Tag())
+		if fieldError.Param() != "" {
+			sb.WriteString("=")
+			sb.WriteString(fieldError.Param())
+		}
+		// values are formatted with %v, so secrets are redacted by their formatter
+		fmt.Fprintf(&sb, "' rule, actual value: '%v'", fieldError.Value())
+	}
+	return sb.String()
+}
+
+func (e *AggregateError) Unwrap() error {
+	return e.Errors
+}
+
+// keyPath translates a struct namespace like `Config.PostgreSQL.CredentialsConfig.Password` into the key path
+// `postgresql.password`. Fields without the tag are keyed by their lowercase name, which is how koanf matches them.
+func keyPath(rootType reflect.Type, structNamespace, tag string) string {
+	segments := strings.Split(structNamespace, ".")[1:] // first segment is the root type name
+	keys := make([]string, 0, len(segments))
+
+	current := rootType
+	for _, segment := range segments {
+		name, index, _ := strings.Cut(segment, "[")
+		if index != "" {
+			index = "[" + index
+		}
+
+		for current != nil && current.Kind() == reflect.Pointer {
+			current = current.Elem()
+		}
+		if current == nil || current.Kind() != reflect.Struct {
+			keys = append(keys, strings.ToLower(name)+index)
+			current = nil
+			continue
+		}
+
+		field, found := current.FieldByName(name)
+		if !found {
+			keys = append(keys, strings.ToLower(name)+index)
+			current = nil
+			continue
+		}
+
+		key, options, _ := strings.Cut(field.Tag.Get(tag), ",")
+		switch {
+		case key == "" && strings.Contains(options, "squash"):
+			// squashed fields are flattened into the parent key
+		case key == "":
+			keys = append(keys, strings.ToLower(name)+index)
+		default:
+			keys = append(keys, key+index)
+		}
+
+		current = field.Type
+		if index != "" {
+			// indexed segments address elements of slices, arrays or maps
+			for current.Kind() == reflect.Pointer {
+				current = current.Elem()
+			}
+			if kind := current.Kind(); kind == reflect.Slice || kind == reflect.Array || kind == reflect.Map {
+				current = current.Elem()
+			}
+		}
+	}
+	return strings.Join(keys, ".")
+}
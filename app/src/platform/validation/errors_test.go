@@ -0,0 +1,100 @@
+package validation
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type testServerConfig struct {
+	Host    string `validate:"required"`
+	Port    int    `validate:"min=1,max=65535"`
+	Retries int    `validate:"lte=5"`
+	Mode    string `validate:"oneof=fast safe"`
+}
+
+type testConfig struct {
+	Name   string `validate:"required,min=3"`
+	Server testServerConfig
+}
+
+func TestAggregateReportsAllViolatedRules(t *testing.T) {
+	config := testConfig{
+		Name:   "ok-name",
+		Server: testServerConfig{Host: "", Port: 70000, Retries: 9, Mode: "safe"},
+	}
+
+	err := Aggregate(Instance.Struct(&config))
+
+	var aggregateError *AggregateError
+	if !errors.As(err, &aggregateError) {
+		t.Fatalf("Aggregate() = %v, want an *AggregateError", err)
+	}
+	if len(aggregateError.Errors) != 3 {
+		t.Fatalf("Aggregate() reported %d errors, want 3:\n%v", len(aggregateError.Errors), err)
+	}
+	message := err.Error()
+	for _, want := range []string{
+		"3 validation error(s):",
+		"\n  - testConfig.Server.Host: failed 'required' rule, actual value: ''",
+		"\n  - testConfig.Server.Port: failed 'max=65535' rule, actual value: '70000'",
+		"\n  - testConfig.Server.Retries: failed 'lte=5' rule, actual value: '9'",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("Aggregate() message doesn't contain %q:\n%s", want, message)
+		}
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		t.Error("Aggregate() error doesn't unwrap to validator.ValidationErrors")
+	}
+}
+
+func TestAggregateKeepsOtherErrors(t *testing.T) {
+	other := errors.New("not a validation error")
+	if err := Aggregate(other); err != other { //nolint:errorlint // the very same error is expected
+		t.Fatalf("Aggregate() = %v, want the error unchanged", err)
+	}
+	if err := Aggregate(nil); err != nil {
+		t.Fatalf("Aggregate(nil) = %v, want nil", err)
+	}
+}
+
+type testCredentials struct {
+	Password string `koanf:"password" validate:"required"`
+}
+
+type testDatabase struct {
+	testCredentials `koanf:",squash"`
+	Replicas        []testServerConfig `koanf:"replicas" validate:"dive"`
+	Timeout         int                `validate:"min=1"`
+}
+
+type testRoot struct {
+	Database *testDatabase `koanf:"database"`
+}
+
+func TestAggregateKeyPathsTranslatesStructNamespaces(t *testing.T) {
+	root := &testRoot{Database: &testDatabase{
+		Replicas: []testServerConfig{{Host: "db-1", Port: 5432, Mode: "fast"}, {Host: "db-2", Port: 0, Mode: "fast"}},
+	}}
+
+	err := AggregateKeyPaths(Instance.Struct(root), root, "koanf")
+
+	var aggregateError *AggregateError
+	if !errors.As(err, &aggregateError) {
+		t.Fatalf("AggregateKeyPaths() = %v, want an *AggregateError", err)
+	}
+	want := []string{
+		"database.password",         // squashed into its parent
+		"database.replicas[1].port", // fields without the tag are keyed by their lowercase name
+		"database.timeout",
+	}
+	if !slices.Equal(aggregateError.KeyPaths, want) {
+		t.Fatalf("AggregateKeyPaths() key paths = %v, want %v", aggregateError.KeyPaths, want)
+	}
+}
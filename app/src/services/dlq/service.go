@@ -4,8 +4,13 @@ import (
 	"chat/src/clients/redis"
 	"chat/src/platform/validation"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	redis2 "github.com/redis/go-redis/v9"
@@ -13,12 +18,22 @@ import (
 )
 
 const svcBootstrapTimeout = 5 * time.Second
+const inspectionBatchSize = 100
+const restoreTimeout = 2 * time.Second
+
+var ErrCorruptedLetter = errors.New("corrupted letter")
 
 type Letter interface {
 	Marshal() ([]byte, error)
 	Unmarshal(payload []byte) error
 }
 
+// IdentifiedLetter is implemented by letters carrying their own id, which is what deduplication compares.
+// Letters which don't implement it are identified by the SHA-256 of their payload.
+type IdentifiedLetter interface {
+	LetterID() string
+}
+
 type redisConfig struct {
 	client   *redis.Client // #readonly
 	evalShas redisEvalShas // #readonly
@@ -26,25 +41,65 @@ type redisConfig struct {
 
 type redisEvalShas struct {
 	enqueue      string // #readonly
+	enqueueDedup string // #readonly
 	enqueueMulti string // #readonly
+	restore      string // #readonly
 }
 
 type queueConfig struct {
-	name string        // #readonly
-	ttl  time.Duration // #readonly
+	name        string        // #readonly
+	ttl         time.Duration // #readonly
+	ttlMode     TTLMode       // #readonly
+	dedupWindow time.Duration // #readonly
+}
+
+// TTLMode tells when the expiration of a queue is set.
+type TTLMode uint8
+
+const (
+	// TTLModeFixed sets the expiration when the queue is created, so a queue expires QueueTTL after its first letter,
+	// even while letters keep being enqueued.
+	TTLModeFixed TTLMode = iota
+	// TTLModeSliding refreshes the expiration on every enqueue, so a queue expires QueueTTL after its last letter.
+	TTLModeSliding
+)
+
+func (m TTLMode) sliding() int {
+	if m == TTLModeSliding {
+		return 1
+	}
+	return 0
+}
+
+type counters struct {
+	enqueued         atomic.Uint64
+	deduped          atomic.Uint64
+	dequeued         atomic.Uint64
+	corruptedDropped atomic.Uint64
+}
+
+// Stats is a point-in-time snapshot of the service counters, accumulated since service creation.
+type Stats struct {
+	Enqueued         uint64
+	Deduped          uint64 // letters skipped because they were already enqueued within the dedup window
+	Dequeued         uint64
+	CorruptedDropped uint64 // letters removed because they couldn't be unmarshalled
 }
 
 type Service[T Letter] struct {
-	redis  redisConfig    // #readonly
-	queue  queueConfig    // #readonly
-	logger zerolog.Logger // #readonly
+	redis    redisConfig // #readonly
+	queue    queueConfig // #readonly
+	counters counters
+	logger   zerolog.Logger // #readonly
 }
 
 type Options struct {
-	RedisClient *redis.Client
-	QueueName   string        `validate:"required,min=3,max=30,alphanum,lowercase"`
-	QueueTTL    time.Duration `validate:"gte=1000000000,lte=600000000000"` // 1s to 10min
-	Logger      zerolog.Logger
+	RedisClient  *redis.Client
+	QueueName    string        `validate:"required,min=3,max=30,alphanum,lowercase"`
+	QueueTTL     time.Duration `validate:"gte=1000000000,lte=600000000000"` // 1s to 10min
+	QueueTTLMode TTLMode       `validate:"lte=1"`
+	DedupWindow  time.Duration `validate:"omitempty,gte=1000000000,lte=86400000000000"` // 1s to 24h, 0 appends letters unconditionally
+	Logger       zerolog.Logger
 }
 
 func NewService[T Letter](opts *Options) (*Service[T], error) {
@@ -59,17 +114,19 @@ func NewService[T Letter](opts *Options) (*Service[T], error) {
 		-- KEYS[1] = list key
 		-- ARGV[1] = value to append
 		-- ARGV[2] = expiration in seconds
+		-- ARGV[3] = 1 to refresh the expiration on every push, 0 to set it when the list is created
 	*/
 	evalShaEnqueue, err := opts.RedisClient.Driver.ScriptLoad(ctx, `
 local key     = KEYS[1]
 local value   = ARGV[1]
 local ttl     = tonumber(ARGV[2])
+local sliding = ARGV[3] == "1"
 
 local existed = redis.call("EXISTS", key)
 
 local new_len = redis.call("RPUSH", key, value)
 
-if existed == 0 then
+if existed == 0 or sliding then
     redis.call("EXPIRE", key, ttl)
 end
 
@@ -79,25 +136,70 @@ return new_len
 		return nil, fmt.Errorf("failed to init service: can't load Lua script responsible for letter enqueuing: %w", err)
 	}
 
+	/*
+		-- KEYS[1] = list key
+		-- KEYS[2] = sorted set key of recently enqueued letter ids, scored by their expiration in milliseconds
+		-- ARGV[1] = value to append
+		-- ARGV[2] = expiration in seconds
+		-- ARGV[3] = letter id
+		-- ARGV[4] = dedup window in milliseconds
+		-- ARGV[5] = 1 to refresh the expiration on every push, 0 to set it when the list is created
+	*/
+	evalShaEnqueueDedup, err := opts.RedisClient.Driver.ScriptLoad(ctx, `
+local key     = KEYS[1]
+local ids     = KEYS[2]
+local value   = ARGV[1]
+local ttl     = tonumber(ARGV[2])
+local id      = ARGV[3]
+local window  = tonumber(ARGV[4])
+local sliding = ARGV[5] == "1"
+
+local time = redis.call("TIME")
+local now  = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call("ZREMRANGEBYSCORE", ids, "-inf", now)
+if redis.call("ZSCORE", ids, id) then
+    return {redis.call("LLEN", key), 1}
+end
+
+local existed = redis.call("EXISTS", key)
+
+local new_len = redis.call("RPUSH", key, value)
+
+if existed == 0 or sliding then
+    redis.call("EXPIRE", key, ttl)
+end
+
+redis.call("ZADD", ids, now + window, id)
+redis.call("PEXPIRE", ids, window)
+
+return {new_len, 0}
+`).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to init service: can't load Lua script responsible for deduplicated letter enqueuing: %w", err)
+	}
+
 	/*
 		-- KEYS[1]  = list key
 		-- ARGV[1]  = expiration in seconds
-		-- ARGV[2..n] = values to append
+		-- ARGV[2]  = 1 to refresh the expiration on every push, 0 to set it when the list is created
+		-- ARGV[3..n] = values to append
 	*/
 	evalShaEnqueueMulti, err := opts.RedisClient.Driver.ScriptLoad(ctx, `
-local key = KEYS[1]
-local ttl = tonumber(ARGV[1])
+local key     = KEYS[1]
+local ttl     = tonumber(ARGV[1])
+local sliding = ARGV[2] == "1"
 
 local existed = redis.call("EXISTS", key)
 
 local values = {}
-for i = 2, #ARGV do
+for i = 3, #ARGV do
     values[#values+1] = ARGV[i]
 end
 
 local new_len = redis.call("RPUSH", key, unpack(values))
 
-if existed == 0 then
+if existed == 0 or sliding then
     redis.call("EXPIRE", key, ttl)
 end
 
@@ -107,50 +209,122 @@ return new_len
 		return nil, fmt.Errorf("failed to init service: can't load Lua script responsible for letter enqueuing multi: %w", err)
 	}
 
+	/*
+		-- KEYS[1] = list key
+		-- ARGV[1] = value to put back at the head
+		-- ARGV[2] = expiration in seconds
+		-- ARGV[3] = 1 to refresh the expiration on every push, 0 to set it when the list is created
+	*/
+	evalShaRestore, err := opts.RedisClient.Driver.ScriptLoad(ctx, `
+local key     = KEYS[1]
+local value   = ARGV[1]
+local ttl     = tonumber(ARGV[2])
+local sliding = ARGV[3] == "1"
+
+local existed = redis.call("EXISTS", key)
+
+local new_len = redis.call("LPUSH", key, value)
+
+if existed == 0 or sliding then
+    redis.call("EXPIRE", key, ttl)
+end
+
+return new_len
+`).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to init service: can't load Lua script responsible for letter restoring: %w", err)
+	}
+
 	return &Service[T]{
 		redis: redisConfig{
 			client: opts.RedisClient,
 			evalShas: redisEvalShas{
 				enqueue:      evalShaEnqueue,
+				enqueueDedup: evalShaEnqueueDedup,
 				enqueueMulti: evalShaEnqueueMulti,
+				restore:      evalShaRestore,
 			},
 		},
 		queue: queueConfig{
-			name: opts.QueueName,
-			ttl:  opts.QueueTTL,
+			name:        opts.QueueName,
+			ttl:         opts.QueueTTL,
+			ttlMode:     opts.QueueTTLMode,
+			dedupWindow: opts.DedupWindow,
 		},
 		logger: opts.Logger,
 	}, nil
 }
 
-func (s *Service[T]) Enqueue(ctx context.Context, recipientID string, letter T) (int64, error) {
+// Enqueue appends the letter to the queue of the recipient and returns the queue length. When a dedup window is
+// configured, a letter whose id was already enqueued for the recipient within the window is skipped, which is
+// reported by the returned flag.
+func (s *Service[T]) Enqueue(ctx context.Context, recipientID string, letter T) (int64, bool, error) {
 	payload, err := letter.Marshal()
 	if err != nil {
-		return 0, fmt.Errorf(
+		return 0, false, fmt.Errorf(
 			"failed to marshal letter intended for recipient '%s' from queue '%s': %w",
 			recipientID, s.queue.name, err,
 		)
 	}
 
+	if s.queue.dedupWindow > 0 {
+		return s.enqueueDedup(ctx, recipientID, letterID(letter, payload), payload)
+	}
+
 	queueLength, err := s.redis.client.Driver.EvalSha(
 		ctx,
 		s.redis.evalShas.enqueue,
 		[]string{s.key(recipientID)},
 		payload,
 		s.queue.ttl.Seconds(),
+		s.queue.ttlMode.sliding(),
 	).Int64()
 	if err != nil {
-		return 0, fmt.Errorf("failed to push letter into queue for recipient '%s' from queue '%s': %w",
+		return 0, false, fmt.Errorf("failed to push letter into queue for recipient '%s' from queue '%s': %w",
 			recipientID, s.queue.name, err,
 		)
 	}
+	s.counters.enqueued.Add(1)
 
-	return queueLength, nil
+	return queueLength, false, nil
+}
+
+func (s *Service[T]) enqueueDedup(ctx context.Context, recipientID, id string, payload []byte) (int64, bool, error) {
+	key := s.key(recipientID)
+
+	reply, err := s.redis.client.Driver.EvalSha(
+		ctx,
+		s.redis.evalShas.enqueueDedup,
+		[]string{key, s.idsKey(key)},
+		payload,
+		s.queue.ttl.Seconds(),
+		id,
+		s.queue.dedupWindow.Milliseconds(),
+		s.queue.ttlMode.sliding(),
+	).Int64Slice()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to push letter '%s' into queue for recipient '%s' from queue '%s': %w",
+			id, recipientID, s.queue.name, err,
+		)
+	}
+	if len(reply) != 2 {
+		return 0, false, fmt.Errorf("failed to push letter '%s' into queue for recipient '%s' from queue '%s': unexpected reply %v",
+			id, recipientID, s.queue.name, reply,
+		)
+	}
+
+	if reply[1] == 1 {
+		s.counters.deduped.Add(1)
+		return reply[0], true, nil
+	}
+	s.counters.enqueued.Add(1)
+
+	return reply[0], false, nil
 }
 
 func (s *Service[T]) EnqueueMulti(ctx context.Context, recipientID string, letters []T) (int64, error) {
-	argv := make([]any, 0, len(letters)+1)
-	argv = append(argv, s.queue.ttl.Seconds())
+	argv := make([]any, 0, len(letters)+2)
+	argv = append(argv, s.queue.ttl.Seconds(), s.queue.ttlMode.sliding())
 	for idx, letter := range letters {
 		payload, err := letter.Marshal()
 		if err != nil {
@@ -173,6 +347,7 @@ func (s *Service[T]) EnqueueMulti(ctx context.Context, recipientID string, lette
 			len(letters), recipientID, s.queue.name, err,
 		)
 	}
+	s.counters.enqueued.Add(uint64(len(letters)))
 
 	return queueLength, nil
 }
@@ -192,9 +367,11 @@ func (s *Service[T]) Dequeue(ctx context.Context, recipientID string) (T, error)
 	var letter T
 	err = letter.Unmarshal(raw)
 	if err != nil {
+		s.counters.corruptedDropped.Add(1)
 		var zero T
 		return zero, fmt.Errorf("failed to unmarshal letter for recipient '%s' from queue '%s': %w", recipientID, s.queue.name, err)
 	}
+	s.counters.dequeued.Add(1)
 
 	return letter, nil
 }
@@ -215,18 +392,173 @@ func (s *Service[T]) DequeueMulti(ctx context.Context, recipientID string, count
 		var letter T
 		err = letter.Unmarshal([]byte(raw))
 		if err != nil {
-			err := s.redis.client.Driver.Del(ctx, key).Err()
-			if err != nil {
-				s.logger.Warn().Err(err).Msgf("failed to delete corrupted DLQ '%s'", key)
+			var remaining *redis2.IntCmd
+			_, delErr := s.redis.client.Driver.TxPipelined(ctx, func(pipe redis2.Pipeliner) error {
+				remaining = pipe.LLen(ctx, key)
+				pipe.Del(ctx, key)
+				return nil
+			})
+			if delErr != nil {
+				s.logger.Warn().Err(delErr).Msgf("failed to delete corrupted DLQ '%s'", key)
+				s.counters.corruptedDropped.Add(uint64(len(rawVals)))
+			} else {
+				s.counters.corruptedDropped.Add(uint64(len(rawVals)) + uint64(remaining.Val()))
 			}
 			return nil, fmt.Errorf("dlq '%s' corrupted: %w", key, err)
 		}
 		letters = append(letters, letter)
 	}
+	s.counters.dequeued.Add(uint64(len(letters)))
+
+	return letters, nil
+}
+
+// DrainTo pops the letters of a recipient in order and hands each of them to produce, i.e. to replay them to their
+// source topic after a fix. On the first produce error the letter is put back at the head of the queue, so it and
+// the remaining ones are preserved, and the error is returned along with the number of letters drained before it.
+// Corrupted letters can't be produced, they are dropped and not counted as drained.
+func (s *Service[T]) DrainTo(ctx context.Context, recipientID string, produce func(ctx context.Context, letter T) error) (int, error) {
+	key := s.key(recipientID)
+
+	drained := 0
+	for {
+		raw, err := s.redis.client.Driver.LPop(ctx, key).Bytes()
+		if err != nil {
+			if errors.Is(err, redis2.Nil) {
+				return drained, nil
+			}
+			return drained, fmt.Errorf("failed to pop letter of recipient '%s' from queue '%s': %w", recipientID, s.queue.name, err)
+		}
+
+		var letter T
+		if err := letter.Unmarshal(raw); err != nil {
+			s.counters.corruptedDropped.Add(1)
+			s.logger.Warn().Err(err).Msgf("Dropped corrupted letter while draining DLQ '%s'", key)
+			continue
+		}
+
+		if err := produce(ctx, letter); err != nil {
+			if restoreErr := s.restore(key, raw); restoreErr != nil {
+				return drained, fmt.Errorf(
+					"failed to produce letter of recipient '%s' from queue '%s', and the letter was lost: %w",
+					recipientID, s.queue.name, errors.Join(err, restoreErr),
+				)
+			}
+			return drained, fmt.Errorf("failed to produce letter of recipient '%s' from queue '%s': %w", recipientID, s.queue.name, err)
+		}
+		s.counters.dequeued.Add(1)
+		drained++
+	}
+}
+
+// restore puts a popped letter back at the head of its queue. It doesn't use the context of the drain, which may be
+// the reason produce failed.
+func (s *Service[T]) restore(key string, raw []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+	defer cancel()
+
+	err := s.redis.client.Driver.EvalSha(ctx, s.redis.evalShas.restore, []string{key}, raw, s.queue.ttl.Seconds(), s.queue.ttlMode.sliding()).Err()
+	if err != nil {
+		return fmt.Errorf("failed to restore letter into '%s': %w", key, err)
+	}
+	return nil
+}
+
+// Len returns the number of letters queued for a recipient.
+func (s *Service[T]) Len(ctx context.Context, recipientID string) (int64, error) {
+	length, err := s.redis.client.Driver.LLen(ctx, s.key(recipientID)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get length of queue '%s' for recipient '%s': %w", s.queue.name, recipientID, err)
+	}
+	return length, nil
+}
+
+func (s *Service[T]) Stats() Stats {
+	return Stats{
+		Enqueued:         s.counters.enqueued.Load(),
+		Deduped:          s.counters.deduped.Load(),
+		Dequeued:         s.counters.dequeued.Load(),
+		CorruptedDropped: s.counters.corruptedDropped.Load(),
+	}
+}
 
+// ListRecipients returns the recipients having letters in the queue. Keys are enumerated with SCAN on every
+// master, so it doesn't block Redis, but recipients whose queues are created or expired meanwhile may be missed.
+func (s *Service[T]) ListRecipients(ctx context.Context) ([]string, error) {
+	prefix := s.key("")
+
+	var mutex sync.Mutex
+	recipients := make([]string, 0)
+
+	err := s.redis.client.Driver.ForEachMaster(ctx, func(ctx context.Context, client *redis2.Client) error {
+		iter := client.Scan(ctx, 0, prefix+"*", inspectionBatchSize).Iterator()
+		for iter.Next(ctx) {
+			mutex.Lock()
+			recipients = append(recipients, strings.TrimPrefix(iter.Val(), prefix))
+			mutex.Unlock()
+		}
+		return iter.Err() //nolint:wrapcheck // wrapped below
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan recipients of queue '%s': %w", s.queue.name, err)
+	}
+
+	return recipients, nil
+}
+
+// Export returns the letters of a recipient without removing them. Unlike dequeue, corrupted letters
+// are left in place and reported with ErrCorruptedLetter alongside the letters which could be read.
+func (s *Service[T]) Export(ctx context.Context, recipientID string) ([]T, error) {
+	key := s.key(recipientID)
+
+	letters := make([]T, 0)
+	var corrupted []error
+
+	for start := int64(0); ; start += inspectionBatchSize {
+		rawVals, err := s.redis.client.Driver.LRange(ctx, key, start, start+inspectionBatchSize-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to read letters [%d, %d) for recipient '%s' from queue '%s': %w",
+				start, start+inspectionBatchSize, recipientID, s.queue.name, err,
+			)
+		}
+
+		for idx, raw := range rawVals {
+			var letter T
+			if err := letter.Unmarshal([]byte(raw)); err != nil {
+				corrupted = append(corrupted, fmt.Errorf("letter at index %d: %w: %w", start+int64(idx), ErrCorruptedLetter, err))
+				continue
+			}
+			letters = append(letters, letter)
+		}
+
+		if len(rawVals) < inspectionBatchSize {
+			break
+		}
+	}
+
+	if len(corrupted) > 0 {
+		return letters, fmt.Errorf("dlq '%s' has %d corrupted letters: %w", key, len(corrupted), errors.Join(corrupted...))
+	}
 	return letters, nil
 }
 
 func (s *Service[T]) key(recipientID string) string {
 	return "dlq:" + s.queue.name + ":" + recipientID
 }
+
+// idsKey returns the key of the recently enqueued letter ids, hash tagged with the whole queue key,
+// so both of them map to the same cluster slot.
+func (s *Service[T]) idsKey(queueKey string) string {
+	return "{" + queueKey + "}:ids"
+}
+
+func letterID(letter Letter, payload []byte) string {
+	if identified, ok := letter.(IdentifiedLetter); ok {
+		if id := identified.LetterID(); id != "" {
+			return id
+		}
+	}
+	digest := sha256.Sum256(payload)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,663 @@
+package dlq
+
+import (
+	"chat/src/clients/redis/redistest"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	redis2 "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+type noteLetter struct {
+	ID   string
+	Text string
+}
+
+func (l *noteLetter) Marshal() ([]byte, error) {
+	return []byte(l.ID + ":" + l.Text), nil
+}
+
+func (l *noteLetter) Unmarshal(payload []byte) error {
+	id, text, found := strings.Cut(string(payload), ":")
+	if !found {
+		return errors.New("missing letter id")
+	}
+	l.ID, l.Text = id, text
+	return nil
+}
+
+func (l *noteLetter) LetterID() string {
+	return l.ID
+}
+
+func newTestService(t *testing.T, dedupWindow time.Duration) *Service[*noteLetter] {
+	t.Helper()
+
+	service, err := NewService[*noteLetter](&Options{
+		RedisClient: redistest.NewClient(t),
+		QueueName:   "notes",
+		QueueTTL:    time.Minute,
+		DedupWindow: dedupWindow,
+		Logger:      zerolog.Nop(),
+	})
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	return service
+}
+
+func TestEnqueueAppendsLettersOfRecipient(t *testing.T) {
+	service := newTestService(t, 0)
+	ctx := context.Background()
+
+	for idx, text := range []string{"first", "second"} {
+		length, deduped, err := service.Enqueue(ctx, "alice", &noteLetter{ID: text, Text: text})
+		if err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", text, err)
+		}
+		if deduped || length != int64(idx+1) {
+			t.Errorf("Enqueue(%s) = (%d, %t), want (%d, false)", text, length, deduped, idx+1)
+		}
+	}
+
+	if length, err := service.Len(ctx, "alice"); err != nil || length != 2 {
+		t.Errorf("Len(alice) = (%d, %v), want (2, nil)", length, err)
+	}
+	if length, err := service.Len(ctx, "bob"); err != nil || length != 0 {
+		t.Errorf("Len(bob) = (%d, %v), want (0, nil)", length, err)
+	}
+
+	recipients, err := service.ListRecipients(ctx)
+	if err != nil || len(recipients) != 1 || recipients[0] != "alice" {
+		t.Errorf("ListRecipients() = (%v, %v), want ([alice], nil)", recipients, err)
+	}
+}
+
+func TestEnqueueSkipsLettersWithinDedupWindow(t *testing.T) {
+	service := newTestService(t, time.Minute)
+	ctx := context.Background()
+
+	if _, deduped, err := service.Enqueue(ctx, "alice", &noteLetter{ID: "1", Text: "hello"}); err != nil || deduped {
+		t.Fatalf("Enqueue() = (%t, %v), want (false, nil)", deduped, err)
+	}
+	if length, deduped, err := service.Enqueue(ctx, "alice", &noteLetter{ID: "1", Text: "hello again"}); err != nil || !deduped || length != 1 {
+		t.Errorf("Enqueue() of same id = (%d, %t, %v), want (1, true, nil)", length, deduped, err)
+	}
+	if _, deduped, err := service.Enqueue(ctx, "bob", &noteLetter{ID: "1", Text: "hello"}); err != nil || deduped {
+		t.Errorf("Enqueue() of same id for another recipient = (%t, %v), want (false, nil)", deduped, err)
+	}
+
+	if stats := service.Stats(); stats.Enqueued != 2 || stats.Deduped != 1 {
+		t.Errorf("Stats() = %+v, want 2 enqueued and 1 deduped", stats)
+	}
+}
+
+// textLetter has no id of its own, it's deduplicated by its payload.
+type textLetter struct {
+	Text string
+}
+
+func (l *textLetter) Marshal() ([]byte, error) {
+	return []byte(l.Text), nil
+}
+
+func (l *textLetter) Unmarshal(payload []byte) error {
+	l.Text = string(payload)
+	return nil
+}
+
+func TestEnqueueDedupesDuplicatesOnlyWhenConfigured(t *testing.T) {
+	tests := []struct {
+		name        string
+		dedupWindow time.Duration
+		wantLength  int64
+	}{
+		{name: "append always by default", dedupWindow: 0, wantLength: 3},
+		{name: "dedup window", dedupWindow: time.Minute, wantLength: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestService(t, tt.dedupWindow)
+			ctx := context.Background()
+
+			var length int64
+			for range 3 {
+				var err error
+				if length, _, err = service.Enqueue(ctx, "alice", &noteLetter{ID: "1", Text: "retried"}); err != nil {
+					t.Fatalf("Enqueue() error = %v", err)
+				}
+			}
+			if length != tt.wantLength {
+				t.Fatalf("queue length = %d after 3 enqueues of the same letter, want %d", length, tt.wantLength)
+			}
+		})
+	}
+}
+
+func TestEnqueueDedupesLettersWithoutIDByPayload(t *testing.T) {
+	service, err := NewService[*textLetter](&Options{
+		RedisClient: redistest.NewClient(t),
+		QueueName:   "texts",
+		QueueTTL:    time.Minute,
+		DedupWindow: time.Minute,
+		Logger:      zerolog.Nop(),
+	})
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		text        string
+		wantDeduped bool
+	}{
+		{text: "hello"},
+		{text: "hello", wantDeduped: true},
+		{text: "hello again"},
+	} {
+		if _, deduped, err := service.Enqueue(ctx, "alice", &textLetter{Text: tt.text}); err != nil || deduped != tt.wantDeduped {
+			t.Fatalf("Enqueue(%q) = (%t, %v), want (%t, nil)", tt.text, deduped, err, tt.wantDeduped)
+		}
+	}
+	if length, err := service.Len(ctx, "alice"); err != nil || length != 2 {
+		t.Fatalf("Len() = (%d, %v), want (2, nil)", length, err)
+	}
+}
+
+func TestEnqueueAcceptsDuplicateOnceDedupWindowPassed(t *testing.T) {
+	service := newTestService(t, time.Second)
+	ctx := context.Background()
+
+	if _, deduped, err := service.Enqueue(ctx, "alice", &noteLetter{ID: "1", Text: "hello"}); err != nil || deduped {
+		t.Fatalf("Enqueue() = (%t, %v), want (false, nil)", deduped, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	length, deduped, err := service.Enqueue(ctx, "alice", &noteLetter{ID: "1", Text: "hello"})
+	if err != nil || deduped || length != 2 {
+		t.Fatalf("Enqueue() after the window = (%d, %t, %v), want (2, false, nil)", length, deduped, err)
+	}
+}
+
+func TestConcurrentDuplicateEnqueuesAreCollapsed(t *testing.T) {
+	service := newTestService(t, time.Minute)
+	ctx := context.Background()
+
+	const enqueues = 20
+	var wg sync.WaitGroup
+	for range enqueues {
+		wg.Go(func() {
+			if _, _, err := service.Enqueue(ctx, "alice", &noteLetter{ID: "storm", Text: "retried"}); err != nil {
+				t.Errorf("Enqueue() error = %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if length, err := service.Len(ctx, "alice"); err != nil || length != 1 {
+		t.Fatalf("Len() = (%d, %v), want (1, nil)", length, err)
+	}
+	if stats := service.Stats(); stats.Enqueued != 1 || stats.Deduped != enqueues-1 {
+		t.Fatalf("Stats() = %+v, want 1 enqueued and %d deduped", stats, enqueues-1)
+	}
+}
+
+// commandCounter counts the commands sent to Redis by name.
+type commandCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func countCommands(t *testing.T, service *Service[*noteLetter]) *commandCounter {
+	t.Helper()
+
+	counter := &commandCounter{counts: make(map[string]int)}
+	err := service.redis.client.Driver.ForEachMaster(context.Background(), func(_ context.Context, client *redis2.Client) error {
+		client.AddHook(counter)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to hook Redis masters: %v", err)
+	}
+	return counter
+}
+
+func (c *commandCounter) count(name string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.counts[name]
+}
+
+func (c *commandCounter) DialHook(next redis2.DialHook) redis2.DialHook {
+	return next
+}
+
+func (c *commandCounter) ProcessHook(next redis2.ProcessHook) redis2.ProcessHook {
+	return func(ctx context.Context, cmd redis2.Cmder) error {
+		c.mutex.Lock()
+		c.counts[cmd.Name()]++
+		c.mutex.Unlock()
+		return next(ctx, cmd)
+	}
+}
+
+func (c *commandCounter) ProcessPipelineHook(next redis2.ProcessPipelineHook) redis2.ProcessPipelineHook {
+	return next
+}
+
+func TestListRecipientsScansAllRecipients(t *testing.T) {
+	service := newTestService(t, 0)
+	ctx := context.Background()
+
+	// more recipients than a SCAN batch, so enumeration takes several cursors
+	want := make([]string, 0, 2*inspectionBatchSize+1)
+	for idx := range 2*inspectionBatchSize + 1 {
+		recipient := fmt.Sprintf("user-%03d", idx)
+		if _, _, err := service.Enqueue(ctx, recipient, &noteLetter{ID: "1", Text: "hello"}); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", recipient, err)
+		}
+		want = append(want, recipient)
+	}
+	if _, err := service.redis.client.Driver.Set(ctx, "dlq:other:user-000", "x", 0).Result(); err != nil {
+		t.Fatalf("failed to seed the queue of another service: %v", err)
+	}
+
+	counter := countCommands(t, service)
+	recipients, err := service.ListRecipients(ctx)
+	if err != nil {
+		t.Fatalf("ListRecipients() error = %v", err)
+	}
+	slices.Sort(recipients)
+	if !slices.Equal(recipients, want) {
+		t.Fatalf("ListRecipients() returned %d recipients, want %d recipients of the queue only", len(recipients), len(want))
+	}
+	if scans := counter.count("scan"); scans < 2 {
+		t.Errorf("ListRecipients() issued %d SCAN commands, want enumeration in several batches", scans)
+	}
+	if keys := counter.count("keys"); keys != 0 {
+		t.Errorf("ListRecipients() issued %d KEYS commands, want none", keys)
+	}
+}
+
+func TestExportReadsLettersWithoutRemovingThem(t *testing.T) {
+	service := newTestService(t, 0)
+	ctx := context.Background()
+
+	letters := make([]*noteLetter, 0, inspectionBatchSize+1)
+	for idx := range inspectionBatchSize + 1 {
+		letters = append(letters, &noteLetter{ID: fmt.Sprint(idx), Text: "hello"})
+	}
+	if _, err := service.EnqueueMulti(ctx, "alice", letters); err != nil {
+		t.Fatalf("EnqueueMulti() error = %v", err)
+	}
+
+	exported, err := service.Export(ctx, "alice")
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if len(exported) != len(letters) || exported[0].ID != "0" || exported[len(exported)-1].ID != fmt.Sprint(inspectionBatchSize) {
+		t.Fatalf("Export() returned %d letters, want the %d letters in order", len(exported), len(letters))
+	}
+	if length, err := service.Len(ctx, "alice"); err != nil || length != int64(len(letters)) {
+		t.Errorf("Len() after Export() = (%d, %v), want (%d, nil)", length, err, len(letters))
+	}
+
+	if exported, err := service.Export(ctx, "bob"); err != nil || len(exported) != 0 {
+		t.Errorf("Export(bob) = (%v, %v), want no letters", exported, err)
+	}
+}
+
+func TestExportReportsCorruptedLettersWithoutDeletingThem(t *testing.T) {
+	service := newTestService(t, 0)
+	ctx := context.Background()
+
+	if _, _, err := service.Enqueue(ctx, "alice", &noteLetter{ID: "1", Text: "hello"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := service.redis.client.Driver.LPush(ctx, service.key("alice"), "corrupted").Err(); err != nil {
+		t.Fatalf("failed to seed corrupted letter: %v", err)
+	}
+
+	exported, err := service.Export(ctx, "alice")
+	if !errors.Is(err, ErrCorruptedLetter) {
+		t.Fatalf("Export() error = %v, want ErrCorruptedLetter", err)
+	}
+	if len(exported) != 1 || exported[0].ID != "1" {
+		t.Errorf("Export() = %v, want the readable letter alongside the error", exported)
+	}
+	if length, err := service.Len(ctx, "alice"); err != nil || length != 2 {
+		t.Errorf("Len() after Export() = (%d, %v), want the corrupted letter to be kept", length, err)
+	}
+	if stats := service.Stats(); stats.CorruptedDropped != 0 {
+		t.Errorf("Stats().CorruptedDropped = %d, want nothing dropped", stats.CorruptedDropped)
+	}
+}
+
+func TestStatsTrackEnqueuedDequeuedAndCorruptedLetters(t *testing.T) {
+	service := newTestService(t, 0)
+	ctx := context.Background()
+	corrupt := func() {
+		t.Helper()
+		if err := service.redis.client.Driver.LPush(ctx, service.key("alice"), "corrupted").Err(); err != nil {
+			t.Fatalf("failed to seed corrupted letter: %v", err)
+		}
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if _, _, err := service.Enqueue(ctx, "alice", &noteLetter{ID: id, Text: "hello"}); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", id, err)
+		}
+	}
+	if _, err := service.EnqueueMulti(ctx, "alice", []*noteLetter{{ID: "d"}, {ID: "e"}}); err != nil {
+		t.Fatalf("EnqueueMulti() error = %v", err)
+	}
+	if stats := service.Stats(); stats != (Stats{Enqueued: 5}) {
+		t.Fatalf("Stats() after enqueue = %+v, want 5 enqueued", stats)
+	}
+
+	if _, err := service.Dequeue(ctx, "alice"); err != nil {
+		t.Fatalf("Dequeue() error = %v", err)
+	}
+	if letters, err := service.DequeueMulti(ctx, "alice", 2); err != nil || len(letters) != 2 {
+		t.Fatalf("DequeueMulti() = (%d letters, %v), want 2 letters", len(letters), err)
+	}
+	if stats := service.Stats(); stats != (Stats{Enqueued: 5, Dequeued: 3}) {
+		t.Fatalf("Stats() after dequeue = %+v, want 5 enqueued and 3 dequeued", stats)
+	}
+
+	// a corrupted letter is dropped alone by Dequeue
+	corrupt()
+	if _, err := service.Dequeue(ctx, "alice"); err == nil {
+		t.Fatal("Dequeue() of corrupted letter error = nil, want unmarshal error")
+	}
+	if stats := service.Stats(); stats != (Stats{Enqueued: 5, Dequeued: 3, CorruptedDropped: 1}) {
+		t.Fatalf("Stats() after corrupted Dequeue() = %+v, want 1 corrupted dropped", stats)
+	}
+
+	// DequeueMulti drops the popped letters along with the rest of the queue
+	corrupt()
+	if _, err := service.DequeueMulti(ctx, "alice", 2); err == nil {
+		t.Fatal("DequeueMulti() of corrupted letter error = nil, want corrupted queue error")
+	}
+	if stats := service.Stats(); stats != (Stats{Enqueued: 5, Dequeued: 3, CorruptedDropped: 4}) {
+		t.Fatalf("Stats() after corrupted DequeueMulti() = %+v, want 4 corrupted dropped", stats)
+	}
+	if length, err := service.Len(ctx, "alice"); err != nil || length != 0 {
+		t.Errorf("Len() = (%d, %v), want the corrupted queue to be deleted", length, err)
+	}
+}
+
+func TestLetterID(t *testing.T) {
+	const helloDigest = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" // SHA-256 of "hello"
+	tests := []struct {
+		name    string
+		letter  Letter
+		payload string
+		want    string
+	}{
+		{name: "own id", letter: &noteLetter{ID: "42"}, payload: "42:hello", want: "42"},
+		{name: "empty own id", letter: &noteLetter{}, payload: "hello", want: helloDigest},
+		{name: "no own id", letter: &textLetter{}, payload: "hello", want: helloDigest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := letterID(tt.letter, []byte(tt.payload)); got != tt.want {
+				t.Fatalf("letterID() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// enqueueNotes enqueues a letter per id for the recipient, in order.
+func enqueueNotes(t *testing.T, service *Service[*noteLetter], recipientID string, ids ...string) {
+	t.Helper()
+
+	letters := make([]*noteLetter, 0, len(ids))
+	for _, id := range ids {
+		letters = append(letters, &noteLetter{ID: id, Text: "hello"})
+	}
+	if _, err := service.EnqueueMulti(context.Background(), recipientID, letters); err != nil {
+		t.Fatalf("EnqueueMulti() error = %v", err)
+	}
+}
+
+// exportedIDs returns the ids of the letters queued for the recipient, in order.
+func exportedIDs(t *testing.T, service *Service[*noteLetter], recipientID string) []string {
+	t.Helper()
+
+	letters, err := service.Export(context.Background(), recipientID)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	ids := make([]string, 0, len(letters))
+	for _, letter := range letters {
+		ids = append(ids, letter.ID)
+	}
+	return ids
+}
+
+func TestDrainToProducesAllLettersInOrder(t *testing.T) {
+	service := newTestService(t, 0)
+	enqueueNotes(t, service, "alice", "a", "b", "c")
+	enqueueNotes(t, service, "bob", "z")
+
+	var produced []string
+	drained, err := service.DrainTo(context.Background(), "alice", func(_ context.Context, letter *noteLetter) error {
+		produced = append(produced, letter.ID)
+		return nil
+	})
+	if err != nil || drained != 3 {
+		t.Fatalf("DrainTo() = (%d, %v), want (3, nil)", drained, err)
+	}
+	if !slices.Equal(produced, []string{"a", "b", "c"}) {
+		t.Errorf("produced letters = %v, want [a b c]", produced)
+	}
+	if length, err := service.Len(context.Background(), "alice"); err != nil || length != 0 {
+		t.Errorf("Len(alice) after DrainTo() = (%d, %v), want (0, nil)", length, err)
+	}
+	if ids := exportedIDs(t, service, "bob"); !slices.Equal(ids, []string{"z"}) {
+		t.Errorf("letters of bob after DrainTo(alice) = %v, want them untouched", ids)
+	}
+	if stats := service.Stats(); stats.Dequeued != 3 {
+		t.Errorf("Stats().Dequeued = %d, want 3", stats.Dequeued)
+	}
+}
+
+func TestDrainToPreservesRemainingLettersOnProduceFailure(t *testing.T) {
+	errBrokerDown := errors.New("broker down")
+	tests := []struct {
+		name    string
+		fail    func(ctx context.Context, cancel context.CancelFunc) error
+		wantErr error
+	}{
+		{
+			name:    "produce error",
+			fail:    func(context.Context, context.CancelFunc) error { return errBrokerDown },
+			wantErr: errBrokerDown,
+		},
+		{
+			// the letter is restored even when the failure is the drain being cancelled
+			name: "cancelled drain",
+			fail: func(ctx context.Context, cancel context.CancelFunc) error {
+				cancel()
+				return ctx.Err()
+			},
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestService(t, 0)
+			enqueueNotes(t, service, "alice", "a", "b", "c", "d")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var produced []string
+			drained, err := service.DrainTo(ctx, "alice", func(ctx context.Context, letter *noteLetter) error {
+				if letter.ID == "c" {
+					return tt.fail(ctx, cancel)
+				}
+				produced = append(produced, letter.ID)
+				return nil
+			})
+			if drained != 2 || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DrainTo() = (%d, %v), want (2, %v)", drained, err, tt.wantErr)
+			}
+			if !slices.Equal(produced, []string{"a", "b"}) {
+				t.Errorf("produced letters = %v, want [a b]", produced)
+			}
+			if ids := exportedIDs(t, service, "alice"); !slices.Equal(ids, []string{"c", "d"}) {
+				t.Errorf("letters after failed DrainTo() = %v, want the failed letter back at the head: [c d]", ids)
+			}
+
+			// a later drain resumes from the letter which failed
+			produced = nil
+			drained, err = service.DrainTo(context.Background(), "alice", func(_ context.Context, letter *noteLetter) error {
+				produced = append(produced, letter.ID)
+				return nil
+			})
+			if err != nil || drained != 2 || !slices.Equal(produced, []string{"c", "d"}) {
+				t.Errorf("DrainTo() after failure = (%d, %v) producing %v, want (2, nil) producing [c d]", drained, err, produced)
+			}
+		})
+	}
+}
+
+func TestDrainToEmptyQueue(t *testing.T) {
+	service := newTestService(t, 0)
+
+	drained, err := service.DrainTo(context.Background(), "alice", func(context.Context, *noteLetter) error {
+		t.Error("produce called for an empty queue")
+		return nil
+	})
+	if err != nil || drained != 0 {
+		t.Fatalf("DrainTo() = (%d, %v), want (0, nil)", drained, err)
+	}
+}
+
+func TestDrainToDropsCorruptedLetters(t *testing.T) {
+	service := newTestService(t, 0)
+	ctx := context.Background()
+	enqueueNotes(t, service, "alice", "a", "b")
+	if err := service.redis.client.Driver.LPush(ctx, service.key("alice"), "corrupted").Err(); err != nil {
+		t.Fatalf("failed to seed corrupted letter: %v", err)
+	}
+
+	var produced []string
+	drained, err := service.DrainTo(ctx, "alice", func(_ context.Context, letter *noteLetter) error {
+		produced = append(produced, letter.ID)
+		return nil
+	})
+	if err != nil || drained != 2 || !slices.Equal(produced, []string{"a", "b"}) {
+		t.Fatalf("DrainTo() = (%d, %v) producing %v, want (2, nil) producing [a b]", drained, err, produced)
+	}
+	if stats := service.Stats(); stats.CorruptedDropped != 1 {
+		t.Errorf("Stats().CorruptedDropped = %d, want 1", stats.CorruptedDropped)
+	}
+}
+
+func TestNewServiceRejectsUnknownTTLMode(t *testing.T) {
+	_, err := NewService[*noteLetter](&Options{QueueName: "notes", QueueTTL: time.Minute, QueueTTLMode: TTLModeSliding + 1})
+	if err == nil || !strings.Contains(err.Error(), "QueueTTLMode") {
+		t.Fatalf("NewService() error = %v, want the TTL mode to be rejected", err)
+	}
+}
+
+func TestEnqueueRefreshesQueueTTLOnlyInSlidingMode(t *testing.T) {
+	const shortenedTTL = 5 * time.Second
+	enqueues := []struct {
+		name    string
+		enqueue func(ctx context.Context, service *Service[*noteLetter]) error
+	}{
+		{
+			name: "enqueue",
+			enqueue: func(ctx context.Context, service *Service[*noteLetter]) error {
+				_, _, err := service.Enqueue(ctx, "alice", &noteLetter{ID: "2"})
+				return err
+			},
+		},
+		{
+			name: "enqueue multi",
+			enqueue: func(ctx context.Context, service *Service[*noteLetter]) error {
+				_, err := service.EnqueueMulti(ctx, "alice", []*noteLetter{{ID: "2"}, {ID: "3"}})
+				return err
+			},
+		},
+		{
+			name: "restore",
+			enqueue: func(ctx context.Context, service *Service[*noteLetter]) error {
+				_, err := service.DrainTo(ctx, "alice", func(context.Context, *noteLetter) error { return errors.New("broker down") })
+				if err == nil {
+					return errors.New("DrainTo() succeeded, want the letter to be restored")
+				}
+				return nil
+			},
+		},
+	}
+	for _, mode := range []TTLMode{TTLModeFixed, TTLModeSliding} {
+		for _, dedupWindow := range []time.Duration{0, time.Minute} {
+			for _, tt := range enqueues {
+				t.Run(fmt.Sprintf("mode %d dedup %v %s", mode, dedupWindow, tt.name), func(t *testing.T) {
+					service, err := NewService[*noteLetter](&Options{
+						RedisClient:  redistest.NewClient(t),
+						QueueName:    "notes",
+						QueueTTL:     time.Minute,
+						QueueTTLMode: mode,
+						DedupWindow:  dedupWindow,
+						Logger:       zerolog.Nop(),
+					})
+					if err != nil {
+						t.Fatalf("NewService() error = %v", err)
+					}
+					ctx := context.Background()
+					key := service.key("alice")
+
+					if _, _, err := service.Enqueue(ctx, "alice", &noteLetter{ID: "1"}); err != nil {
+						t.Fatalf("Enqueue() error = %v", err)
+					}
+					if ttl := queueTTL(t, service, key); ttl <= shortenedTTL || ttl > time.Minute {
+						t.Fatalf("TTL of new queue = %v, want QueueTTL", ttl)
+					}
+					// as if most of the TTL elapsed while the queue kept receiving letters
+					if _, err := service.redis.client.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+						return pipe.PExpire(ctx, key, shortenedTTL).Err()
+					}); err != nil {
+						t.Fatalf("failed to shorten TTL: %v", err)
+					}
+
+					if err := tt.enqueue(ctx, service); err != nil {
+						t.Fatalf("enqueue error = %v", err)
+					}
+					ttl := queueTTL(t, service, key)
+					if mode == TTLModeSliding && ttl <= shortenedTTL {
+						t.Fatalf("TTL after re-enqueue = %v, want it to be refreshed to QueueTTL", ttl)
+					}
+					if mode == TTLModeFixed && ttl > shortenedTTL {
+						t.Fatalf("TTL after re-enqueue = %v, want it to be left as set at creation", ttl)
+					}
+				})
+			}
+		}
+	}
+}
+
+// queueTTL returns the remaining time to live of the queue key.
+func queueTTL(t *testing.T, service *Service[*noteLetter], key string) time.Duration {
+	t.Helper()
+
+	var ttl *redis2.DurationCmd
+	if _, err := service.redis.client.Driver.Pipelined(context.Background(), func(pipe redis2.Pipeliner) error {
+		ttl = pipe.PTTL(context.Background(), key)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to get TTL of '%s': %v", key, err)
+	}
+	return ttl.Val()
+}
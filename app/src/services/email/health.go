@@ -0,0 +1,49 @@
+package email
+
+import (
+	"chat/src/platform/components"
+	"chat/src/platform/health"
+	"context"
+	"fmt"
+)
+
+// PingTargetName is the target of the delivery checks of the service, which are aggregated with the checks of the
+// clients the service depends on under components.ServiceHealth.
+var PingTargetName = components.ServiceHealth(components.EmailService) + ".delivery"
+
+// queueSaturationThreshold is the share of the SMTP pool queue above which the service is reported as overloaded,
+// consumed records are about to be handed back to the router as backpressure.
+const queueSaturationThreshold = 0.9
+
+// PingShallow reports the health of the SMTP pool, the saturation of its queue and the recent send error rate,
+// every check which fails being recorded among the degraded reasons of the result.
+func (s *Service) PingShallow(ctx context.Context) health.PingResult {
+	result := health.NewHealthyPingResult(PingTargetName, health.PingDepthShallow)
+
+	stats := s.clients.email.Stats()
+	result.
+		WithDetail("queued_requests", stats.QueuedRequests).
+		WithDetail("queue_capacity", stats.QueueCapacity).
+		WithDetail("busy_workers", stats.BusyWorkers).
+		WithDetail("send_error_rate", stats.SendErrorRate)
+
+	if err := s.clients.email.Healthy(ctx); err != nil {
+		result.Degrade(health.PingCauseNetwork, fmt.Sprintf("SMTP pool is unhealthy: %v", err))
+	}
+	if stats.QueueCapacity > 0 && float64(stats.QueuedRequests) >= queueSaturationThreshold*float64(stats.QueueCapacity) {
+		result.Degrade(
+			health.PingCauseOverloaded,
+			fmt.Sprintf("SMTP pool queue holds %d of %d requests", stats.QueuedRequests, stats.QueueCapacity),
+		)
+	}
+	sendErrors := s.clients.email.SendErrors()
+	if cause := sendErrors.Cause(); cause != health.PingCauseOk {
+		result.Degrade(cause, fmt.Sprintf("%.1f%% of SMTP sends failed recently", sendErrors.Rate()*100))
+	}
+
+	return result
+}
+
+func (s *Service) PingDeep(ctx context.Context) health.PingResult {
+	return s.PingShallow(ctx)
+}
@@ -0,0 +1,135 @@
+package email
+
+import (
+	"chat/src/clients/email"
+	"chat/src/clients/email/emailtest"
+	"chat/src/platform/health"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// submitTestEmail hands an email to the pool without waiting for it to be sent, the send error is delivered to the
+// returned channel.
+func submitTestEmail(t *testing.T, service *Service, client *email.Client) <-chan error {
+	t.Helper()
+
+	request := newTestSendRequest()
+	if err := service.prepareRequest(request); err != nil {
+		t.Fatalf("prepareRequest() error = %v", err)
+	}
+	message, err := service.buildMessageFromProto(request)
+	if err != nil {
+		t.Fatalf("buildMessageFromProto() error = %v", err)
+	}
+	response := make(chan error, 1)
+	if err := client.TrySend(email.Request{SendOptions: email.SendEmailOptions{Email: message}, Response: response}); err != nil {
+		t.Fatalf("TrySend() error = %v", err)
+	}
+	return response
+}
+
+func pingShallow(service *Service) health.PingResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	return service.PingShallow(ctx)
+}
+
+func degradedReasons(result health.PingResult) []string {
+	reasons, _ := result.Data["degraded_reasons"].([]string)
+	return reasons
+}
+
+func TestPingShallowOfHealthyService(t *testing.T) {
+	service, client := newTestService(t, emailtest.NewServer(t, nil), 0)
+	t.Cleanup(func() { client.Stop(context.Background()) })
+
+	result := pingShallow(service)
+	if !result.Healthy() {
+		t.Fatalf("PingShallow() = %s (%s), want healthy", result.Status, result.Details)
+	}
+	if result.Target != PingTargetName {
+		t.Errorf("PingShallow() target = %s, want %s", result.Target, PingTargetName)
+	}
+	if capacity := result.Data["queue_capacity"]; capacity != 10 {
+		t.Errorf("queue_capacity detail = %v, want 10", capacity)
+	}
+}
+
+func TestPingShallowReportsSaturatedQueue(t *testing.T) {
+	release := make(chan struct{})
+	fake := emailtest.NewServer(t, nil)
+	fake.OnRcpt = func(string) error {
+		<-release
+		return nil
+	}
+	service, client := newTestService(t, fake, 0)
+	t.Cleanup(func() { client.Stop(context.Background()) })
+	t.Cleanup(func() { close(release) })
+
+	// both workers hold a send, the queue fills up behind them
+	for range 2 {
+		submitTestEmail(t, service, client)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Stats().BusyWorkers != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool stats %+v, want both workers to be busy", client.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for range 9 {
+		submitTestEmail(t, service, client)
+	}
+
+	result := pingShallow(service)
+	if result.Healthy() {
+		t.Fatal("PingShallow() = healthy, want the saturated queue to be reported")
+	}
+	want := "overloaded: SMTP pool queue holds 9 of 10 requests"
+	if reasons := degradedReasons(result); !slices.Contains(reasons, want) {
+		t.Fatalf("degraded reasons = %v, want them to contain %q", reasons, want)
+	}
+	if queued := result.Data["queued_requests"]; queued != 9 {
+		t.Errorf("queued_requests detail = %v, want 9", queued)
+	}
+}
+
+func TestPingShallowReportsDownSMTPPool(t *testing.T) {
+	service, client := newTestService(t, emailtest.NewServer(t, nil), 0)
+	client.Stop(context.Background())
+
+	result := pingShallow(service)
+	if result.Status != health.PingStatusUnhealthy || result.Cause != health.PingCauseNetwork {
+		t.Fatalf("PingShallow() = %s %s, want %s %s", result.Status, result.Cause, health.PingStatusUnhealthy, health.PingCauseNetwork)
+	}
+	if reasons := degradedReasons(result); len(reasons) != 1 || !strings.Contains(reasons[0], email.ErrWorkerPoolNotRunning.Error()) {
+		t.Fatalf("degraded reasons = %v, want the pool not to be running", reasons)
+	}
+}
+
+func TestPingShallowReportsSendErrorRate(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	fake.OnRcpt = func(string) error { return &smtp.SMTPError{Code: 451, Message: "try again later"} }
+	service, client := newTestService(t, fake, 0)
+	t.Cleanup(func() { client.Stop(context.Background()) })
+
+	// enough failed sends for the rate to be trusted
+	for range 20 {
+		if err := <-submitTestEmail(t, service, client); err == nil {
+			t.Fatal("send error = nil, want the temporary rejection")
+		}
+	}
+
+	result := pingShallow(service)
+	if result.Cause != health.PingCauseOverloaded {
+		t.Fatalf("PingShallow() = %s %s (%s), want %s", result.Status, result.Cause, result.Details, health.PingCauseOverloaded)
+	}
+	if reasons := degradedReasons(result); !slices.Contains(reasons, "overloaded: 100.0% of SMTP sends failed recently") {
+		t.Fatalf("degraded reasons = %v, want the send error rate", reasons)
+	}
+}
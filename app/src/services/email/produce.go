@@ -0,0 +1,132 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	produceRetryBufferSize  = 1_000
+	produceMaxRetries       = 5
+	produceRetryBaseBackoff = 200 * time.Millisecond
+	produceRetryMaxBackoff  = 10 * time.Second
+)
+
+var (
+	ErrProduceRetryBufferFull = errors.New("email produce retry buffer is full")
+	ErrProduceRetriesExceeded = errors.New("email produce retries exceeded")
+)
+
+// produceRetries keeps the records whose produce failed with a transient broker error, until they are produced
+// again with exponential backoff. The buffer is bounded, records which don't fit fail permanently.
+type produceRetries struct {
+	pending      atomic.Int64
+	lifecycleCtx context.Context // cancelled on Stop, pending retries are dropped
+	cancel       context.CancelFunc
+	// produceRecord produces with the Kafka client outside of tests, records it can't buffer are rejected
+	produceRecord func(ctx context.Context, record *kgo.Record, promise func(*kgo.Record, error)) error
+}
+
+const (
+	produceInFlight int32 = iota
+	produceReturned
+	produceFailedSynchronously
+)
+
+// produce produces the record asynchronously. On the first attempt, errors reported before the record is buffered
+// (i.e. client closed) are returned to the caller instead of being retried.
+func (s *Service) produce(ctx context.Context, record *kgo.Record, attempt int) error {
+	var (
+		state   atomic.Int32
+		syncErr error
+	)
+
+	err := s.produceRetries.produceRecord(ctx, record, func(record *kgo.Record, err error) {
+		if err != nil && attempt == 0 {
+			syncErr = err
+			if state.CompareAndSwap(produceInFlight, produceFailedSynchronously) {
+				return
+			}
+		}
+		if err == nil {
+			s.logger.Info().Msgf(
+				"Email record produced to Kafka topic %s partition %d at offset %d",
+				record.Topic, record.Partition, record.Offset,
+			)
+			return
+		}
+
+		if !isRetriableProduceError(err) {
+			s.failProduce(record, err)
+			return
+		}
+		if attempt >= produceMaxRetries {
+			s.failProduce(record, errors.Join(ErrProduceRetriesExceeded, err))
+			return
+		}
+		if s.produceRetries.pending.Add(1) > produceRetryBufferSize {
+			s.produceRetries.pending.Add(-1)
+			s.failProduce(record, errors.Join(ErrProduceRetryBufferFull, err))
+			return
+		}
+
+		backoff := min(produceRetryBaseBackoff<<attempt, produceRetryMaxBackoff)
+		s.logger.Warn().Err(err).Msgf(
+			"Failed to produce email record with key '%s' to Kafka, retrying in %v (attempt %d/%d)",
+			record.Key, backoff, attempt+1, produceMaxRetries,
+		)
+		time.AfterFunc(backoff, func() {
+			s.produceRetries.pending.Add(-1)
+			if s.produceRetries.lifecycleCtx.Err() != nil {
+				s.logger.Error().Msgf("Dropping email record with key '%s' pending produce retry, service is stopped", record.Key)
+				return
+			}
+			// produce a fresh record, the failed one carries state assigned by the client
+			retried := &kgo.Record{Topic: record.Topic, Key: record.Key, Value: record.Value, Headers: record.Headers}
+			_ = s.produce(s.produceRetries.lifecycleCtx, retried, attempt+1)
+		})
+	})
+	if err != nil {
+		if attempt == 0 {
+			return err
+		}
+		s.failProduce(record, err)
+		return nil
+	}
+
+	if !state.CompareAndSwap(produceInFlight, produceReturned) {
+		return syncErr
+	}
+	return nil
+}
+
+func (s *Service) failProduce(record *kgo.Record, err error) {
+	s.logger.Error().Err(err).Msgf("Failed to produce email record with key '%s' to Kafka", record.Key)
+	if s.kafkaDelivery.onProduceFailure != nil {
+		s.kafkaDelivery.onProduceFailure(string(record.Key), err)
+	}
+}
+
+func isRetriableProduceError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, kgo.ErrClientClosed) {
+		return false
+	}
+	if errors.Is(err, kgo.ErrRecordTimeout) || errors.Is(err, kgo.ErrRecordRetries) ||
+		errors.Is(err, kgo.ErrMaxBuffered) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var kafkaErr *kerr.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Retriable
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
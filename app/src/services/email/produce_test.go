@@ -0,0 +1,264 @@
+package email
+
+import (
+	"chat/src/clients/kafka"
+	"chat/src/clients/kafka/kafkatest"
+	emailv1 "chat/src/gen/proto/email/v1"
+	"chat/src/platform/security/securitytest"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeProducer fails the produce attempts with the scripted errors, in order, and succeeds once they run out.
+// Results are reported asynchronously, as the client does once a record is buffered, unless synchronous.
+type fakeProducer struct {
+	mutex       sync.Mutex
+	errs        []error
+	synchronous bool
+	attempts    []*kgo.Record
+	attempted   chan struct{}
+}
+
+func (p *fakeProducer) produce(_ context.Context, record *kgo.Record, promise func(*kgo.Record, error)) error {
+	p.mutex.Lock()
+	p.attempts = append(p.attempts, record)
+	var err error
+	if len(p.errs) > 0 {
+		err, p.errs = p.errs[0], p.errs[1:]
+	}
+	p.mutex.Unlock()
+
+	report := func() {
+		promise(record, err)
+		p.attempted <- struct{}{}
+	}
+	if p.synchronous {
+		report()
+		return nil
+	}
+	go report()
+	return nil
+}
+
+func (p *fakeProducer) attemptCount() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.attempts)
+}
+
+type produceFailure struct {
+	messageID string
+	err       error
+}
+
+// newProduceTestService returns a started service producing with the fake, which reports the failed produces.
+func newProduceTestService(t *testing.T, producer *fakeProducer) (*Service, <-chan produceFailure) {
+	t.Helper()
+
+	failures := make(chan produceFailure, 10)
+	logger := zerolog.Nop()
+	service := NewService(&ServiceOptions{
+		EmailBuild: ServiceEmailBuildOptions{From: testSender, DKIMCert: securitytest.NewCertificate(t)},
+		KafkaDelivery: ServiceKafkaDeliveryOptions{
+			Topic: testRetryTopic,
+			OnProduceFailure: func(messageID string, err error) {
+				failures <- produceFailure{messageID: messageID, err: err}
+			},
+		},
+		Logger: &logger,
+	})
+	producer.attempted = make(chan struct{}, 10)
+	service.produceRetries.produceRecord = producer.produce
+	service.produceRetries.lifecycleCtx, service.produceRetries.cancel = context.WithCancel(context.Background())
+	t.Cleanup(service.produceRetries.cancel)
+	return service, failures
+}
+
+const testSendMessageID = "0b6d5f2e-2f4a-4a53-9d3b-6b1f0c2d7e91"
+
+func newTestSendRequest() *emailv1.SendEmailRequest {
+	return &emailv1.SendEmailRequest{
+		MessageId: testSendMessageID,
+		CreatedAt: timestamppb.Now(),
+		Source:    &emailv1.Source{Service: "accounts", Environment: "test"},
+		Email: &emailv1.Email{
+			To:              []*emailv1.EmailAddress{{Email: "alice@example.com"}},
+			Subject:         "Hello",
+			ContentMode:     emailv1.ContentMode_CONTENT_MODE_RAW,
+			Raw:             &emailv1.RawContent{Text: "Hello there", Html: "<p>Hello there</p>"},
+			InteractionMode: emailv1.InteractionMode_INTERACTION_MODE_AUTOMATED,
+			Importance:      emailv1.ImportanceLevel_IMPORTANCE_LEVEL_NORMAL,
+		},
+	}
+}
+
+// waitForAttempts waits for the results of the number of produce attempts to be reported.
+func waitForAttempts(t *testing.T, producer *fakeProducer, attempts int) {
+	t.Helper()
+
+	for range attempts {
+		select {
+		case <-producer.attempted:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d produce attempts were made, want %d", producer.attemptCount(), attempts)
+		}
+	}
+}
+
+func TestSendRetriesTransientProduceErrorsUntilSuccess(t *testing.T) {
+	producer := &fakeProducer{errs: []error{kerr.LeaderNotAvailable, kgo.ErrRecordTimeout}}
+	service, failures := newProduceTestService(t, producer)
+
+	if err := service.Send(context.Background(), newTestSendRequest()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	waitForAttempts(t, producer, 3)
+
+	select {
+	case failure := <-failures:
+		t.Fatalf("produce of '%s' failed: %v, want it to succeed after retries", failure.messageID, failure.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if attempts := producer.attemptCount(); attempts != 3 {
+		t.Fatalf("%d produce attempts, want 3", attempts)
+	}
+	for idx, record := range producer.attempts[1:] {
+		if record == producer.attempts[idx] || string(record.Key) != testSendMessageID {
+			t.Fatalf("retry %d produced record %p with key '%s', want a fresh record of the same email", idx+1, record, record.Key)
+		}
+	}
+	if pending := service.produceRetries.pending.Load(); pending != 0 {
+		t.Fatalf("%d records pending retry after success, want none", pending)
+	}
+}
+
+func TestSendReportsPermanentProduceErrorWithoutRetry(t *testing.T) {
+	producer := &fakeProducer{errs: []error{kerr.MessageTooLarge}}
+	service, failures := newProduceTestService(t, producer)
+
+	if err := service.Send(context.Background(), newTestSendRequest()); err != nil {
+		t.Fatalf("Send() error = %v, want the asynchronous failure to be reported to the hook", err)
+	}
+
+	select {
+	case failure := <-failures:
+		if failure.messageID != testSendMessageID || !errors.Is(failure.err, kerr.MessageTooLarge) {
+			t.Fatalf("produce failure = %+v, want the email failing with %v", failure, kerr.MessageTooLarge)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("permanent produce failure wasn't reported")
+	}
+	time.Sleep(produceRetryBaseBackoff + 50*time.Millisecond)
+	if attempts := producer.attemptCount(); attempts != 1 {
+		t.Fatalf("%d produce attempts, want a permanent error not to be retried", attempts)
+	}
+}
+
+func TestSendFailsWhenRetryBufferIsFull(t *testing.T) {
+	producer := &fakeProducer{errs: []error{kerr.LeaderNotAvailable}}
+	service, failures := newProduceTestService(t, producer)
+	service.produceRetries.pending.Store(produceRetryBufferSize)
+
+	if err := service.Send(context.Background(), newTestSendRequest()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	select {
+	case failure := <-failures:
+		if !errors.Is(failure.err, ErrProduceRetryBufferFull) || !errors.Is(failure.err, kerr.LeaderNotAvailable) {
+			t.Fatalf("produce failure = %v, want the buffer to be full", failure.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("produce failure wasn't reported while the retry buffer is full")
+	}
+}
+
+func TestStopDropsPendingProduceRetries(t *testing.T) {
+	producer := &fakeProducer{errs: []error{kerr.LeaderNotAvailable}}
+	service, _ := newProduceTestService(t, producer)
+
+	if err := service.Send(context.Background(), newTestSendRequest()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	waitForAttempts(t, producer, 1)
+	service.Stop(context.Background())
+
+	time.Sleep(produceRetryBaseBackoff + 100*time.Millisecond)
+	if attempts := producer.attemptCount(); attempts != 1 {
+		t.Fatalf("%d produce attempts, want the retry to be dropped on Stop", attempts)
+	}
+	if pending := service.produceRetries.pending.Load(); pending != 0 {
+		t.Fatalf("%d records pending retry after Stop, want none", pending)
+	}
+}
+
+func TestSendReturnsErrorsReportedBeforeBuffering(t *testing.T) {
+	producer := &fakeProducer{errs: []error{kgo.ErrMaxBuffered}, synchronous: true}
+	service, failures := newProduceTestService(t, producer)
+
+	if err := service.Send(context.Background(), newTestSendRequest()); !errors.Is(err, kgo.ErrMaxBuffered) {
+		t.Fatalf("Send() error = %v, want %v", err, kgo.ErrMaxBuffered)
+	}
+	time.Sleep(produceRetryBaseBackoff + 50*time.Millisecond)
+	if len(failures) != 0 || producer.attemptCount() != 1 {
+		t.Fatalf("%d failures reported after %d attempts, want the error to be returned only", len(failures), producer.attemptCount())
+	}
+}
+
+func TestSendToClosedKafkaClientFails(t *testing.T) {
+	closers := map[string]func(client *kafka.Client){
+		"driver closed":  func(client *kafka.Client) { client.Driver.Close() },
+		"client stopped": func(client *kafka.Client) { client.Stop(context.Background()) },
+	}
+	for name, closeClient := range closers {
+		t.Run(name, func(t *testing.T) {
+			client := kafkatest.NewClient(t, nil)
+			logger := zerolog.Nop()
+			service := NewService(&ServiceOptions{
+				Clients:       ServiceClientsOptions{Kafka: client},
+				EmailBuild:    ServiceEmailBuildOptions{From: testSender, DKIMCert: securitytest.NewCertificate(t)},
+				KafkaDelivery: ServiceKafkaDeliveryOptions{Topic: testRetryTopic},
+				Logger:        &logger,
+			})
+			closeClient(client)
+
+			if err := service.Send(context.Background(), newTestSendRequest()); !errors.Is(err, kgo.ErrClientClosed) {
+				t.Fatalf("Send() error = %v, want %v", err, kgo.ErrClientClosed)
+			}
+			if err := service.SendSync(context.Background(), newTestSendRequest()); !errors.Is(err, kgo.ErrClientClosed) {
+				t.Fatalf("SendSync() error = %v, want %v", err, kgo.ErrClientClosed)
+			}
+		})
+	}
+}
+
+func TestSendWrapsRequestInVersionedEnvelope(t *testing.T) {
+	producer := &fakeProducer{synchronous: true}
+	service, _ := newProduceTestService(t, producer)
+
+	if err := service.Send(context.Background(), newTestSendRequest()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	waitForAttempts(t, producer, 1)
+
+	var request emailv1.SendEmailRequest
+	envelope, err := sendEmailRequestSchema.Open(producer.attempts[0].Value, &request)
+	if err != nil {
+		t.Fatalf("Open() error = %v, want the produced value to be an envelope", err)
+	}
+	if envelope.GetSchemaId() != "email.v1.SendEmailRequest" || envelope.GetSchemaVersion() != 1 {
+		t.Fatalf("envelope schema = '%s' version %d, want 'email.v1.SendEmailRequest' version 1",
+			envelope.GetSchemaId(), envelope.GetSchemaVersion())
+	}
+	if request.GetMessageId() != testSendMessageID {
+		t.Fatalf("enveloped request message id = '%s', want '%s'", request.GetMessageId(), testSendMessageID)
+	}
+}
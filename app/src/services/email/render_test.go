@@ -0,0 +1,127 @@
+package email
+
+import (
+	"bytes"
+	"chat/src/clients/email/emailtest"
+	emailv1 "chat/src/gen/proto/email/v1"
+	"chat/src/platform/security/securitytest"
+	"context"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// writeTestTemplate writes the files of the locale of the template, named by their file name, returning the
+// templates location.
+func writeTestTemplate(t *testing.T, id, locale string, files map[string]string) string {
+	t.Helper()
+
+	location := t.TempDir()
+	dir := filepath.Join(location, id, locale)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatalf("failed to create template directory: %v", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write template file '%s': %v", name, err)
+		}
+	}
+	return location
+}
+
+func TestRenderProducesMIMEMessageForTemplateRequest(t *testing.T) {
+	location := writeTestTemplate(t, "welcome", "en", map[string]string{
+		"index.txt":   "Hello {{.name}}, welcome aboard",
+		"index.html":  "<p>Hello {{.name}}, welcome aboard</p>",
+		"subject.txt": "Welcome {{.name}}",
+	})
+	logger := zerolog.Nop()
+	// without clients, so sending or producing would panic
+	service := NewService(&ServiceOptions{
+		EmailBuild:        ServiceEmailBuildOptions{From: testSender, DKIMCert: securitytest.NewCertificate(t)},
+		TemplatesLocation: location,
+		Logger:            &logger,
+	})
+
+	request := newTestSendRequest()
+	request.Email.Raw = nil
+	request.Email.ContentMode = emailv1.ContentMode_CONTENT_MODE_TEMPLATE
+	request.Email.Template = &emailv1.TemplateContent{TemplateId: "welcome", Vars: map[string]string{"name": "Alice"}}
+
+	rendered, err := service.Render(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	message, err := mail.ReadMessage(bytes.NewReader(rendered))
+	if err != nil {
+		t.Fatalf("rendered message can't be parsed: %v\n%s", err, rendered)
+	}
+	headers := map[string]string{
+		"From":    "<" + testSender + ">",
+		"To":      "<alice@example.com>",
+		"Subject": "Welcome Alice",
+	}
+	for name, want := range headers {
+		if got := message.Header.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+	if message.Header.Get("Message-ID") == "" {
+		t.Error("expected the rendered message to have a Message-ID")
+	}
+	if contentType := message.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "multipart/") {
+		t.Errorf("Content-Type = %q, want a multipart message", contentType)
+	}
+	body, err := io.ReadAll(message.Body)
+	if err != nil {
+		t.Fatalf("failed to read rendered body: %v", err)
+	}
+	for _, want := range []string{"Hello Alice, welcome aboard", "<p>Hello Alice, welcome aboard</p>"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("rendered body doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestRenderRejectsInvalidRequest(t *testing.T) {
+	logger := zerolog.Nop()
+	service := NewService(&ServiceOptions{
+		EmailBuild: ServiceEmailBuildOptions{From: testSender, DKIMCert: securitytest.NewCertificate(t)},
+		Logger:     &logger,
+	})
+	request := newTestSendRequest()
+	request.MessageId = "not a uuid"
+
+	if _, err := service.Render(context.Background(), request); err == nil {
+		t.Fatal("expected an invalid request not to be rendered")
+	}
+}
+
+func TestDryRunConsumesRecordsWithoutSending(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	service, client := newTestService(t, fake, 1)
+	t.Cleanup(func() { client.Stop(context.Background()) })
+	service.dryRun = true
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	service.logger = &logger
+
+	if err := service.handleRecords(newTestRecords(t, 3)); err != nil {
+		t.Fatalf("handleRecords() error = %v", err)
+	}
+	if received := fake.Received(); len(received) != 0 {
+		t.Fatalf("expected nothing to be sent in dry run, got %d messages", len(received))
+	}
+	if stats := client.Stats(); stats.QueuedRequests != 0 || stats.BusyWorkers != 0 {
+		t.Fatalf("pool stats = %+v, want no request submitted", stats)
+	}
+	if rendered := strings.Count(logs.String(), "Dry run rendered email"); rendered != 3 {
+		t.Fatalf("logged %d rendered emails, want 3:\n%s", rendered, logs.String())
+	}
+}
@@ -0,0 +1,349 @@
+package email
+
+import (
+	"chat/src/clients/kafka/routing"
+	"chat/src/clients/redis"
+	"chat/src/util"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis2 "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// RetryMode tells what the consumer does with email requests which can't be sent at the moment, because the SMTP
+// pool is full or the relay failed transiently.
+type RetryMode uint8
+
+const (
+	// RetryModePause applies backpressure, the partition is paused and the request is redelivered by Kafka.
+	RetryModePause RetryMode = iota
+	// RetryModeQueue moves the request to a per-partition Redis retry list and commits its offset, so a saturated
+	// pool or a failing relay delays only the failed requests and the ones sharing their key, instead of the whole
+	// partition.
+	RetryModeQueue
+)
+
+// ParseRetryMode returns the retry mode named "pause" or "queue" in config, unknown names pause.
+func ParseRetryMode(name string) RetryMode {
+	if name == "queue" {
+		return RetryModeQueue
+	}
+	return RetryModePause
+}
+
+const (
+	retryListKeyFormat     = "email:retry:{%s:%d}"
+	retryPendingKeysSuffix = ":keys"
+	retryPollInterval      = time.Second
+	retryRedisTimeout      = 2 * time.Second
+	retryLease             = time.Minute // longer than a send, a leased entry is retried after it when the replica crashes
+	defaultRetryDelay      = 30 * time.Second
+	defaultRetryAttempts   = 10
+)
+
+var ErrRetryQueueNotConfigured = errors.New("retry queue mode requires a Redis client")
+var ErrRetryAttemptsExhausted = errors.New("email request retry attempts exhausted")
+
+type retryEntry struct {
+	Key     []byte `json:"key,omitempty"`
+	Value   []byte `json:"value"`
+	Offset  int64  `json:"offset"`
+	Attempt int    `json:"attempt"`
+}
+
+type retryEvalShas struct {
+	push   string // #readonly
+	lease  string // #readonly
+	settle string // #readonly
+}
+
+// partitionOwner tells which partitions of the topic are assigned to this consumer, i.e. the router consuming it.
+type partitionOwner interface {
+	AssignedPartitions(topic string) []int32
+	OwnsPartition(topic string, partition int32) bool
+}
+
+// retryQueue keeps the email requests to be retried in Redis lists, one per topic-partition, ordered as consumed.
+// Entries are prefixed with the unix millis they become due at, the head of a list blocks the entries behind it,
+// which keeps the order of the requests of a partition. Keys with entries in the list are counted in a hash,
+// so requests consumed later with the same key are queued behind them instead of overtaking them.
+// Only the lists of the partitions assigned to this consumer are polled. A due head is leased rather than popped:
+// its due time is moved past the lease in the same script which reads it, and it's removed or rescheduled only once
+// its delivery is settled, so a crash in between delays the entry instead of losing it.
+type retryQueue struct {
+	redis       *redis.Client          // #readonly
+	owner       partitionOwner         // #readonly
+	topic       string                 // #readonly
+	delay       time.Duration          // #readonly
+	maxAttempts int                    // #readonly
+	deadLetter  routing.DeadLetterFunc // #readonly, optional
+	evalShas    retryEvalShas
+	cancel      context.CancelFunc
+	stopped     sync.WaitGroup
+	logger      *zerolog.Logger // #readonly
+}
+
+func (q *retryQueue) start(ctx context.Context, deliver func(record *kgo.Record) error) error {
+	if q.redis == nil {
+		return ErrRetryQueueNotConfigured
+	}
+	if err := q.loadScripts(ctx); err != nil {
+		return err
+	}
+
+	pollCtx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.stopped.Add(1)
+	util.Go(q.logger, "email.retry", func() {
+		defer q.stopped.Done()
+		q.poll(pollCtx, deliver)
+	})
+	return nil
+}
+
+func (q *retryQueue) loadScripts(ctx context.Context) error {
+	var err error
+	if q.evalShas.push, err = q.redis.Driver.ScriptLoad(ctx, `
+		redis.call('RPUSH', KEYS[1], ARGV[1])
+		if ARGV[2] ~= '' then
+			redis.call('HINCRBY', KEYS[2], ARGV[2], 1)
+		end
+		return 1
+	`).Result(); err != nil {
+		return fmt.Errorf("can't load Lua script responsible for retry push: %w", err)
+	}
+	// leases the head when it's due, by replacing its due time with the end of the lease, and returns it
+	if q.evalShas.lease, err = q.redis.Driver.ScriptLoad(ctx, `
+		local head = redis.call('LINDEX', KEYS[1], 0)
+		if not head then
+			return false
+		end
+		local separator = string.find(head, '|', 1, true)
+		if not separator then
+			return head
+		end
+		local dueAt = tonumber(string.sub(head, 1, separator - 1))
+		if not dueAt then
+			return head
+		end
+		if dueAt > tonumber(ARGV[1]) then
+			return false
+		end
+		local leased = ARGV[2] .. string.sub(head, separator)
+		redis.call('LSET', KEYS[1], 0, leased)
+		return leased
+	`).Result(); err != nil {
+		return fmt.Errorf("can't load Lua script responsible for retry lease: %w", err)
+	}
+	// settles the leased head, unless the lease expired and the head changed meanwhile: an empty replacement removes
+	// it and releases its key, another one reschedules it in place
+	if q.evalShas.settle, err = q.redis.Driver.ScriptLoad(ctx, `
+		if redis.call('LINDEX', KEYS[1], 0) ~= ARGV[1] then
+			return 0
+		end
+		if ARGV[2] ~= '' then
+			redis.call('LSET', KEYS[1], 0, ARGV[2])
+			return 1
+		end
+		redis.call('LPOP', KEYS[1])
+		if ARGV[3] ~= '' and redis.call('HINCRBY', KEYS[2], ARGV[3], -1) <= 0 then
+			redis.call('HDEL', KEYS[2], ARGV[3])
+		end
+		return 1
+	`).Result(); err != nil {
+		return fmt.Errorf("can't load Lua script responsible for retry settle: %w", err)
+	}
+	return nil
+}
+
+func (q *retryQueue) stop() {
+	if q.cancel != nil {
+		q.cancel()
+		q.stopped.Wait()
+	}
+}
+
+// enqueueIfPending queues the record behind the pending retries of its key, if there are any.
+func (q *retryQueue) enqueueIfPending(record *kgo.Record) (bool, error) {
+	if len(record.Key) == 0 {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), retryRedisTimeout)
+	defer cancel()
+
+	pending, err := q.redis.Driver.HExists(ctx, q.listKey(record.Partition)+retryPendingKeysSuffix, string(record.Key)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check pending retries of key '%s': %w", record.Key, err)
+	}
+	if !pending {
+		return false, nil
+	}
+	return true, q.enqueue(record, 0)
+}
+
+// enqueue appends the record to the retry list of its partition, due after the delay.
+func (q *retryQueue) enqueue(record *kgo.Record, delay time.Duration) error {
+	entry := &retryEntry{Key: record.Key, Value: record.Value, Offset: record.Offset}
+	payload, err := encodeRetryEntry(entry, time.Now().Add(delay))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), retryRedisTimeout)
+	defer cancel()
+
+	listKey := q.listKey(record.Partition)
+	err = q.redis.Driver.EvalSha(
+		ctx, q.evalShas.push, []string{listKey, listKey + retryPendingKeysSuffix}, payload, string(entry.Key),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("failed to push record at offset %d into retry list '%s': %w", entry.Offset, listKey, err)
+	}
+	return nil
+}
+
+func (q *retryQueue) poll(ctx context.Context, deliver func(record *kgo.Record) error) {
+	ticker := time.NewTicker(retryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		q.drainAssigned(ctx, deliver)
+	}
+}
+
+// drainAssigned drains the retry lists of the partitions assigned to this consumer, the other ones being drained
+// by their own consumers.
+func (q *retryQueue) drainAssigned(ctx context.Context, deliver func(record *kgo.Record) error) {
+	for _, partition := range q.owner.AssignedPartitions(q.topic) {
+		q.drain(ctx, partition, deliver)
+	}
+}
+
+// drain delivers the due entries at the head of the partition retry list, stopping at the first one not due yet
+// or which can't be delivered, so the entries behind it keep waiting in order. It stops as well once the partition
+// is revoked, its new owner draining the list from then on.
+func (q *retryQueue) drain(ctx context.Context, partition int32, deliver func(record *kgo.Record) error) {
+	listKey := q.listKey(partition)
+	for ctx.Err() == nil && q.owner.OwnsPartition(q.topic, partition) {
+		now := time.Now()
+		leased, err := q.redis.Driver.EvalSha(
+			ctx, q.evalShas.lease, []string{listKey}, now.UnixMilli(), strconv.FormatInt(now.Add(retryLease).UnixMilli(), 10),
+		).Text()
+		if errors.Is(err, redis2.Nil) {
+			return
+		}
+		if err != nil {
+			q.logger.Warn().Err(err).Msgf("Failed to lease due entry of retry list '%s'", listKey)
+			return
+		}
+
+		entry, err := decodeRetryEntry(leased)
+		if err != nil {
+			q.logger.Error().Err(err).Msgf("Dropping corrupted entry of retry list '%s'", listKey)
+			if !q.settle(ctx, listKey, leased, "", nil) {
+				return
+			}
+			continue
+		}
+
+		record := &kgo.Record{Topic: q.topic, Partition: partition, Offset: entry.Offset, Key: entry.Key, Value: entry.Value}
+		if err := deliver(record); err != nil {
+			q.retry(ctx, listKey, leased, record, entry, err)
+			return
+		}
+		if !q.settle(ctx, listKey, leased, "", entry.Key) {
+			return
+		}
+	}
+}
+
+// retry reschedules the failed entry in place, keeping its place in front of the entries behind it, or dead
+// letters it once it failed maxAttempts times.
+func (q *retryQueue) retry(ctx context.Context, listKey, leased string, record *kgo.Record, entry *retryEntry, cause error) {
+	entry.Attempt++
+	if entry.Attempt >= q.maxAttempts {
+		cause = fmt.Errorf("%w after %d attempts: %w", ErrRetryAttemptsExhausted, entry.Attempt, cause)
+		if q.deadLetter == nil {
+			q.logger.Error().Err(cause).Msgf(
+				"Dropping email request at offset %d of retry list '%s', there is no dead letter", entry.Offset, listKey,
+			)
+			q.settle(ctx, listKey, leased, "", entry.Key)
+			return
+		}
+		err := q.deadLetter([]*kgo.Record{record}, cause)
+		if err == nil {
+			q.logger.Warn().Err(cause).Msgf("Dead lettered email request at offset %d of retry list '%s'", entry.Offset, listKey)
+			q.settle(ctx, listKey, leased, "", entry.Key)
+			return
+		}
+		q.logger.Error().Err(err).Msgf(
+			"Failed to dead letter email request at offset %d of retry list '%s', retrying it", entry.Offset, listKey,
+		)
+	}
+
+	delay := util.ExponentialBackoff(entry.Attempt, q.delay, 32*q.delay)
+	payload, err := encodeRetryEntry(entry, time.Now().Add(delay))
+	if err != nil {
+		q.logger.Error().Err(err).Msgf(
+			"Failed to reschedule email request at offset %d of retry list '%s', it's retried after its lease",
+			entry.Offset, listKey,
+		)
+		return
+	}
+	q.settle(ctx, listKey, leased, payload, entry.Key)
+}
+
+// settle removes the leased head, releasing its key, or replaces it with the rescheduled entry. It returns false
+// when Redis failed or the head changed meanwhile, in which case the list is drained again on the next poll.
+func (q *retryQueue) settle(ctx context.Context, listKey, leased, rescheduled string, key []byte) bool {
+	settledCount, err := q.redis.Driver.EvalSha(
+		ctx, q.evalShas.settle, []string{listKey, listKey + retryPendingKeysSuffix}, leased, rescheduled, string(key),
+	).Int64()
+	if err != nil {
+		q.logger.Warn().Err(err).Msgf("Failed to settle leased entry of retry list '%s', it's retried after its lease", listKey)
+		return false
+	}
+	if settledCount == 0 {
+		q.logger.Warn().Msgf("Leased entry of retry list '%s' changed before being settled, its lease expired", listKey)
+		return false
+	}
+	return true
+}
+
+func (q *retryQueue) listKey(partition int32) string {
+	return fmt.Sprintf(retryListKeyFormat, q.topic, partition)
+}
+
+func encodeRetryEntry(entry *retryEntry, dueAt time.Time) (string, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode retry entry of record at offset %d: %w", entry.Offset, err)
+	}
+	return strconv.FormatInt(dueAt.UnixMilli(), 10) + "|" + string(payload), nil
+}
+
+func decodeRetryEntry(raw string) (*retryEntry, error) {
+	_, payload, found := strings.Cut(raw, "|")
+	if !found {
+		return nil, fmt.Errorf("retry entry '%s' has no due time", raw)
+	}
+	var entry retryEntry
+	if err := json.Unmarshal([]byte(payload), &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode retry entry: %w", err)
+	}
+	return &entry, nil
+}
@@ -0,0 +1,314 @@
+package email
+
+import (
+	"chat/src/clients/redis/redistest"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const testRetryTopic = "email-delivery"
+
+type fakeOwner struct {
+	mu         sync.Mutex
+	partitions []int32
+}
+
+func (o *fakeOwner) AssignedPartitions(string) []int32 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.partitions)
+}
+
+func (o *fakeOwner) OwnsPartition(_ string, partition int32) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Contains(o.partitions, partition)
+}
+
+func (o *fakeOwner) revoke(partition int32) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.partitions = slices.DeleteFunc(o.partitions, func(p int32) bool { return p == partition })
+}
+
+// recordingDeliver delivers the records whose offsets aren't failing, recording the offsets of all the attempts.
+type recordingDeliver struct {
+	failing   map[int64]bool
+	delivered []int64
+}
+
+func (d *recordingDeliver) deliver(record *kgo.Record) error {
+	d.delivered = append(d.delivered, record.Offset)
+	if d.failing[record.Offset] {
+		return errors.New("relay failed transiently")
+	}
+	return nil
+}
+
+func newTestRetryQueue(t *testing.T, owner partitionOwner) *retryQueue {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	queue := &retryQueue{
+		redis:       redistest.NewClient(t),
+		owner:       owner,
+		topic:       testRetryTopic,
+		delay:       time.Minute,
+		maxAttempts: 3,
+		logger:      &logger,
+	}
+	if err := queue.loadScripts(context.Background()); err != nil {
+		t.Fatalf("failed to load retry scripts: %v", err)
+	}
+	return queue
+}
+
+func enqueueTestRecord(t *testing.T, queue *retryQueue, partition int32, offset int64, key string, delay time.Duration) {
+	t.Helper()
+
+	record := &kgo.Record{Topic: testRetryTopic, Partition: partition, Offset: offset, Key: []byte(key), Value: []byte("request")}
+	if err := queue.enqueue(record, delay); err != nil {
+		t.Fatalf("failed to enqueue record at offset %d: %v", offset, err)
+	}
+}
+
+func retryListEntries(t *testing.T, queue *retryQueue, partition int32) []*retryEntry {
+	t.Helper()
+
+	raw, err := queue.redis.Driver.LRange(context.Background(), queue.listKey(partition), 0, -1).Result()
+	if err != nil {
+		t.Fatalf("failed to read retry list: %v", err)
+	}
+	entries := make([]*retryEntry, 0, len(raw))
+	for _, value := range raw {
+		entry, err := decodeRetryEntry(value)
+		if err != nil {
+			t.Fatalf("retry list holds corrupted entry '%s': %v", value, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestEncodeRetryEntryPrefixesDueTime(t *testing.T) {
+	dueAt := time.UnixMilli(1_700_000_000_123)
+	raw, err := encodeRetryEntry(&retryEntry{Key: []byte("key"), Value: []byte("value"), Offset: 7, Attempt: 2}, dueAt)
+	if err != nil {
+		t.Fatalf("encodeRetryEntry failed: %v", err)
+	}
+	if !strings.HasPrefix(raw, "1700000000123|") {
+		t.Fatalf("encoded entry '%s' isn't prefixed with its due time", raw)
+	}
+
+	entry, err := decodeRetryEntry(raw)
+	if err != nil {
+		t.Fatalf("decodeRetryEntry failed: %v", err)
+	}
+	if string(entry.Key) != "key" || string(entry.Value) != "value" || entry.Offset != 7 || entry.Attempt != 2 {
+		t.Fatalf("decoded entry %+v differs from the encoded one", entry)
+	}
+}
+
+func TestDecodeRetryEntryWithoutDueTimeFails(t *testing.T) {
+	if _, err := decodeRetryEntry(`{"offset":1}`); err == nil {
+		t.Fatal("decodeRetryEntry accepted an entry without due time")
+	}
+}
+
+func TestDrainSkipsPartitionsNotOwned(t *testing.T) {
+	// the queue has no Redis client, any access to a list of a partition which isn't owned would panic
+	logger := zerolog.Nop()
+	queue := &retryQueue{owner: &fakeOwner{}, topic: testRetryTopic, logger: &logger}
+	deliver := &recordingDeliver{}
+
+	queue.drainAssigned(context.Background(), deliver.deliver)
+	queue.drain(context.Background(), 0, deliver.deliver)
+
+	if len(deliver.delivered) != 0 {
+		t.Fatalf("records %v were delivered from partitions which aren't owned", deliver.delivered)
+	}
+}
+
+func TestDrainDeliversDueEntriesInOrder(t *testing.T) {
+	queue := newTestRetryQueue(t, &fakeOwner{partitions: []int32{0}})
+	for offset := int64(1); offset <= 3; offset++ {
+		enqueueTestRecord(t, queue, 0, offset, "", 0)
+	}
+
+	deliver := &recordingDeliver{}
+	queue.drainAssigned(context.Background(), deliver.deliver)
+
+	if !slices.Equal(deliver.delivered, []int64{1, 2, 3}) {
+		t.Fatalf("delivered offsets %v, want [1 2 3]", deliver.delivered)
+	}
+	if entries := retryListEntries(t, queue, 0); len(entries) != 0 {
+		t.Fatalf("retry list holds %d entries after being drained", len(entries))
+	}
+}
+
+func TestDrainStopsAtEntryNotDue(t *testing.T) {
+	queue := newTestRetryQueue(t, &fakeOwner{partitions: []int32{0}})
+	enqueueTestRecord(t, queue, 0, 1, "", 0)
+	enqueueTestRecord(t, queue, 0, 2, "", time.Hour)
+	enqueueTestRecord(t, queue, 0, 3, "", 0)
+
+	deliver := &recordingDeliver{}
+	queue.drainAssigned(context.Background(), deliver.deliver)
+
+	if !slices.Equal(deliver.delivered, []int64{1}) {
+		t.Fatalf("delivered offsets %v, want [1], the entry behind the one not due must keep waiting", deliver.delivered)
+	}
+	entries := retryListEntries(t, queue, 0)
+	if len(entries) != 2 || entries[0].Offset != 2 || entries[1].Offset != 3 {
+		t.Fatalf("retry list holds %+v, want the entries at offsets 2 and 3 in order", entries)
+	}
+}
+
+func TestDrainReschedulesFailedHeadInFrontOfTheOthers(t *testing.T) {
+	queue := newTestRetryQueue(t, &fakeOwner{partitions: []int32{0}})
+	enqueueTestRecord(t, queue, 0, 1, "user", 0)
+	enqueueTestRecord(t, queue, 0, 2, "user", 0)
+
+	deliver := &recordingDeliver{failing: map[int64]bool{1: true}}
+	queue.drainAssigned(context.Background(), deliver.deliver)
+	queue.drainAssigned(context.Background(), deliver.deliver)
+
+	if !slices.Equal(deliver.delivered, []int64{1}) {
+		t.Fatalf("delivered offsets %v, want only one attempt of offset 1 until it's due again", deliver.delivered)
+	}
+	entries := retryListEntries(t, queue, 0)
+	if len(entries) != 2 || entries[0].Offset != 1 || entries[0].Attempt != 1 || entries[1].Offset != 2 {
+		t.Fatalf("retry list holds %+v, want the failed entry at its head with one attempt", entries)
+	}
+
+	head, err := queue.redis.Driver.LRange(context.Background(), queue.listKey(0), 0, 0).Result()
+	if err != nil || len(head) != 1 {
+		t.Fatalf("failed to read retry list head: %v", err)
+	}
+	raw := head[0]
+	rawDueAt, _, _ := strings.Cut(raw, "|")
+	dueAt, err := strconv.ParseInt(rawDueAt, 10, 64)
+	if err != nil || dueAt < time.Now().Add(queue.delay/2).UnixMilli() {
+		t.Fatalf("rescheduled entry '%s' isn't due after the retry delay", raw)
+	}
+	queued, err := queue.enqueueIfPending(&kgo.Record{Partition: 0, Offset: 3, Key: []byte("user")})
+	if err != nil || !queued {
+		t.Fatalf("record of the key with a rescheduled entry wasn't queued behind it: queued %v, err %v", queued, err)
+	}
+}
+
+func TestDrainDeadLettersEntryAfterMaxAttempts(t *testing.T) {
+	queue := newTestRetryQueue(t, &fakeOwner{partitions: []int32{0}})
+	queue.maxAttempts = 1
+	var deadLettered []*kgo.Record
+	var deadLetterCause error
+	queue.deadLetter = func(records []*kgo.Record, cause error) error {
+		deadLettered, deadLetterCause = append(deadLettered, records...), cause
+		return nil
+	}
+	enqueueTestRecord(t, queue, 0, 1, "user", 0)
+	enqueueTestRecord(t, queue, 0, 2, "other", 0)
+
+	deliver := &recordingDeliver{failing: map[int64]bool{1: true}}
+	queue.drainAssigned(context.Background(), deliver.deliver)
+
+	if len(deadLettered) != 1 || deadLettered[0].Offset != 1 {
+		t.Fatalf("dead lettered records %v, want the one at offset 1", deadLettered)
+	}
+	if !errors.Is(deadLetterCause, ErrRetryAttemptsExhausted) {
+		t.Fatalf("dead letter cause %v isn't ErrRetryAttemptsExhausted", deadLetterCause)
+	}
+	entries := retryListEntries(t, queue, 0)
+	if len(entries) != 1 || entries[0].Offset != 2 {
+		t.Fatalf("retry list holds %+v, want only the entry at offset 2", entries)
+	}
+	queued, err := queue.enqueueIfPending(&kgo.Record{Partition: 0, Offset: 3, Key: []byte("user")})
+	if err != nil || queued {
+		t.Fatalf("key of the dead lettered entry is still pending: queued %v, err %v", queued, err)
+	}
+}
+
+func TestDrainKeepsEntryWhenDeadLetterFails(t *testing.T) {
+	queue := newTestRetryQueue(t, &fakeOwner{partitions: []int32{0}})
+	queue.maxAttempts = 1
+	queue.deadLetter = func([]*kgo.Record, error) error { return errors.New("dead letter topic unavailable") }
+	enqueueTestRecord(t, queue, 0, 1, "", 0)
+
+	deliver := &recordingDeliver{failing: map[int64]bool{1: true}}
+	queue.drainAssigned(context.Background(), deliver.deliver)
+
+	entries := retryListEntries(t, queue, 0)
+	if len(entries) != 1 || entries[0].Offset != 1 || entries[0].Attempt != 1 {
+		t.Fatalf("retry list holds %+v, want the entry rescheduled when it can't be dead lettered", entries)
+	}
+}
+
+func TestEnqueueIfPendingQueuesBehindPendingKey(t *testing.T) {
+	queue := newTestRetryQueue(t, &fakeOwner{partitions: []int32{0}})
+	enqueueTestRecord(t, queue, 0, 1, "user", time.Hour)
+
+	queued, err := queue.enqueueIfPending(&kgo.Record{Partition: 0, Offset: 2, Key: []byte("user")})
+	if err != nil || !queued {
+		t.Fatalf("record of a pending key wasn't queued: queued %v, err %v", queued, err)
+	}
+	queued, err = queue.enqueueIfPending(&kgo.Record{Partition: 0, Offset: 3, Key: []byte("other")})
+	if err != nil || queued {
+		t.Fatalf("record of a key without pending retries was queued: queued %v, err %v", queued, err)
+	}
+	queued, err = queue.enqueueIfPending(&kgo.Record{Partition: 1, Offset: 4, Key: []byte("user")})
+	if err != nil || queued {
+		t.Fatalf("record of another partition was queued: queued %v, err %v", queued, err)
+	}
+
+	entries := retryListEntries(t, queue, 0)
+	if len(entries) != 2 || entries[0].Offset != 1 || entries[1].Offset != 2 {
+		t.Fatalf("retry list holds %+v, want the record at offset 2 behind the one at offset 1", entries)
+	}
+}
+
+func TestDrainAssignedDrainsOnlyOwnedPartitions(t *testing.T) {
+	owner := &fakeOwner{partitions: []int32{1}}
+	queue := newTestRetryQueue(t, owner)
+	enqueueTestRecord(t, queue, 0, 1, "", 0)
+	enqueueTestRecord(t, queue, 1, 2, "", 0)
+
+	deliver := &recordingDeliver{}
+	queue.drainAssigned(context.Background(), deliver.deliver)
+
+	if !slices.Equal(deliver.delivered, []int64{2}) {
+		t.Fatalf("delivered offsets %v, want only offset 2 of the owned partition", deliver.delivered)
+	}
+	if entries := retryListEntries(t, queue, 0); len(entries) != 1 {
+		t.Fatalf("retry list of the partition which isn't owned holds %d entries, want 1", len(entries))
+	}
+}
+
+func TestDrainStopsOncePartitionIsRevoked(t *testing.T) {
+	owner := &fakeOwner{partitions: []int32{0}}
+	queue := newTestRetryQueue(t, owner)
+	enqueueTestRecord(t, queue, 0, 1, "", 0)
+	enqueueTestRecord(t, queue, 0, 2, "", 0)
+
+	var delivered []int64
+	queue.drainAssigned(context.Background(), func(record *kgo.Record) error {
+		delivered = append(delivered, record.Offset)
+		owner.revoke(0)
+		return nil
+	})
+
+	if !slices.Equal(delivered, []int64{1}) {
+		t.Fatalf("delivered offsets %v, want the drain to stop after the partition was revoked", delivered)
+	}
+	if entries := retryListEntries(t, queue, 0); len(entries) != 1 || entries[0].Offset != 2 {
+		t.Fatalf("retry list holds %+v, want the entry at offset 2 left to the new owner", entries)
+	}
+}
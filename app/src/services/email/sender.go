@@ -0,0 +1,63 @@
+package email
+
+import (
+	emailv1 "chat/src/gen/proto/email/v1"
+	"chat/src/util"
+	"fmt"
+	"strings"
+)
+
+// senderPolicy restricts the From addresses of email requests, so producers sharing the pipeline can't spoof
+// the sender. The default From is always allowed, requests of trusted services may send from any address.
+type senderPolicy struct {
+	addresses      map[string]struct{} // #readonly
+	domains        map[string]struct{} // #readonly
+	trustedSources map[string]struct{} // #readonly
+}
+
+func newSenderPolicy(defaultFrom string, allowedFrom, trustedSources []string) senderPolicy {
+	policy := senderPolicy{
+		addresses:      map[string]struct{}{senderKey(defaultFrom): {}},
+		domains:        make(map[string]struct{}),
+		trustedSources: make(map[string]struct{}, len(trustedSources)),
+	}
+	for _, allowed := range allowedFrom {
+		if strings.Contains(allowed, "@") {
+			policy.addresses[senderKey(allowed)] = struct{}{}
+		} else if domain, err := util.NormalizeEmailDomain(allowed); err == nil {
+			policy.domains[domain] = struct{}{}
+		}
+	}
+	for _, source := range trustedSources {
+		policy.trustedSources[source] = struct{}{}
+	}
+	return policy
+}
+
+func (p senderPolicy) check(request *emailv1.SendEmailRequest) error {
+	if _, trusted := p.trustedSources[request.GetSource().GetService()]; trusted {
+		return nil
+	}
+
+	from := senderKey(request.GetEmail().GetFrom().GetEmail())
+	if _, allowed := p.addresses[from]; allowed {
+		return nil
+	}
+	if at := strings.LastIndexByte(from, '@'); at >= 0 {
+		if _, allowed := p.domains[from[at+1:]]; allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"%w: service '%s' is not allowed to send from '%s'", ErrInvalidEmailRequest, request.GetSource().GetService(), from,
+	)
+}
+
+// senderKey is the form of an address the policy compares, allowed addresses are matched case-insensitively.
+// Addresses which can't be normalized are only lowercased, so they still match themselves.
+func senderKey(address string) string {
+	if normalized, err := util.NormalizeEmail(address); err == nil {
+		address = normalized
+	}
+	return strings.ToLower(address)
+}
@@ -0,0 +1,114 @@
+package email
+
+import (
+	"chat/src/clients/email/emailtest"
+	emailv1 "chat/src/gen/proto/email/v1"
+	"chat/src/platform/security/securitytest"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func newSenderTestRequest(service, from string) *emailv1.SendEmailRequest {
+	request := newTestSendRequest()
+	request.Source.Service = service
+	if from != "" {
+		request.Email.From = &emailv1.EmailAddress{Email: from}
+	}
+	return request
+}
+
+func TestSenderPolicyCheck(t *testing.T) {
+	policy := newSenderPolicy(testSender, []string{"alerts@example.org", "Notifications.Example.NET", "Bücher.example"}, []string{"billing"})
+
+	tests := []struct {
+		name    string
+		service string
+		from    string
+		allowed bool
+	}{
+		{name: "default from", service: "accounts", from: testSender, allowed: true},
+		{name: "default from in another case", service: "accounts", from: "No-Reply@Example.com", allowed: true},
+		{name: "allowed address", service: "accounts", from: "alerts@example.org", allowed: true},
+		{name: "another address of the domain of an allowed address", service: "accounts", from: "ceo@example.org"},
+		{name: "address of allowed domain", service: "accounts", from: "weekly@notifications.example.net", allowed: true},
+		{name: "subdomain of allowed domain", service: "accounts", from: "weekly@eu.notifications.example.net"},
+		{name: "address of allowed IDN domain", service: "accounts", from: "news@xn--bcher-kva.example", allowed: true},
+		{name: "unicode address of allowed IDN domain", service: "accounts", from: "news@BÜCHER.example", allowed: true},
+		{name: "allowed address in another case", service: "accounts", from: "Alerts@Example.ORG", allowed: true},
+		{name: "unknown address", service: "accounts", from: "ceo@evil.example"},
+		{name: "trusted source", service: "billing", from: "ceo@evil.example", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.check(newSenderTestRequest(tt.service, tt.from))
+			if tt.allowed && err != nil {
+				t.Fatalf("check() error = %v, want the sender to be allowed", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrInvalidEmailRequest) {
+				t.Fatalf("check() error = %v, want %v", err, ErrInvalidEmailRequest)
+			}
+		})
+	}
+}
+
+func newSenderTestService(t *testing.T) *Service {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	// without clients, the requests are only prepared
+	return NewService(&ServiceOptions{
+		EmailBuild: ServiceEmailBuildOptions{
+			From:        testSender,
+			DKIMCert:    securitytest.NewCertificate(t),
+			AllowedFrom: []string{"example.org"},
+		},
+		Logger: &logger,
+	})
+}
+
+func TestPrepareRequestFillsDefaultFrom(t *testing.T) {
+	service := newSenderTestService(t)
+	request := newSenderTestRequest("accounts", "")
+
+	if err := service.prepareRequest(request); err != nil {
+		t.Fatalf("prepareRequest() error = %v", err)
+	}
+	if from := request.GetEmail().GetFrom().GetEmail(); from != testSender {
+		t.Fatalf("From = %q, want the default %q", from, testSender)
+	}
+}
+
+func TestPrepareRequestChecksFrom(t *testing.T) {
+	service := newSenderTestService(t)
+
+	if err := service.prepareRequest(newSenderTestRequest("accounts", "news@example.org")); err != nil {
+		t.Fatalf("prepareRequest() error = %v, want the allowed domain to be accepted", err)
+	}
+	if err := service.prepareRequest(newSenderTestRequest("accounts", "ceo@evil.example")); !errors.Is(err, ErrInvalidEmailRequest) {
+		t.Fatalf("prepareRequest() error = %v, want %v", err, ErrInvalidEmailRequest)
+	}
+}
+
+func TestDeliverDropsRecordsWithDisallowedFrom(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	service, _ := newTestService(t, fake, 0)
+
+	spoofed := newSenderTestRequest("accounts", "ceo@evil.example")
+	spoofed.MessageId = "spoofed"
+	payload, err := sendEmailRequestSchema.Seal(spoofed, "")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	records := append(newTestRecords(t, 1), &kgo.Record{Topic: testRetryTopic, Offset: 1, Key: []byte("spoofed"), Value: payload})
+
+	if err := service.handleRecords(records); err != nil {
+		t.Fatalf("handleRecords() error = %v", err)
+	}
+	if sent := messageIDs(t, fake.Received()); !slices.Equal(sent, []string{"message-0"}) {
+		t.Fatalf("emails sent = %v, want only the one with an allowed From", sent)
+	}
+}
@@ -1,23 +1,27 @@
 package email
 
 import (
+	"bytes"
 	"chat/src/clients/email"
 	"chat/src/clients/kafka"
 	"chat/src/clients/kafka/routing"
+	"chat/src/clients/redis"
 	emailv1 "chat/src/gen/proto/email/v1"
+	"cmp"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/textproto"
+	"slices"
 	"strings"
+	"time"
 
 	"buf.build/go/protovalidate"
 	"github.com/emersion/go-smtp"
 	"github.com/rs/zerolog"
 	"github.com/twmb/franz-go/pkg/kgo"
 	"github.com/wneessen/go-mail"
-	"google.golang.org/protobuf/proto"
 )
 
 // @FIXME use schema registry for Proto messages
@@ -26,6 +30,10 @@ import (
 
 var ErrInvalidEmailRequest = errors.New("invalid email request")
 
+// sendEmailRequestSchema is the schema of the email requests produced to the delivery topic, its version must be
+// incremented on changes consumers running the previous one can't handle.
+var sendEmailRequestSchema = kafka.SchemaOf(&emailv1.SendEmailRequest{}, 1)
+
 type clients struct {
 	email *email.Client
 	kafka *kafka.Client
@@ -36,48 +44,78 @@ type emailMsgBuildOpts struct {
 	organization string
 	userAgent    string
 	dkimCert     *tls.Certificate
+	senders      senderPolicy
+	textEncoding mail.Encoding // empty lets go-mail pick it
+	htmlEncoding mail.Encoding // empty lets go-mail pick it
 }
 
 type kafkaDeliveryOpts struct {
-	topic  string
-	router *routing.ConsumerRouter
+	topic            string
+	router           *routing.ConsumerRouter
+	onProduceFailure func(messageID string, err error)
+	deadLetter       routing.DeadLetterFunc
+	batchSize        int // #readonly
 }
 
 type Service struct {
-	clients          clients
-	emailMsgBuild    emailMsgBuildOpts
-	kafkaDelivery    kafkaDeliveryOpts
-	templatesManager *templateManager
-	logger           *zerolog.Logger
+	clients           clients
+	emailMsgBuild     emailMsgBuildOpts
+	kafkaDelivery     kafkaDeliveryOpts
+	produceRetries    produceRetries
+	templatesManager  *templateManager
+	requiredTemplates []templateID
+	retries           *retryQueue // nil unless RetryModeQueue
+	dryRun            bool        // #readonly
+	logger            *zerolog.Logger
 }
 
 type ServiceEmailBuildOptions struct {
-	From         string
-	Organization string
-	UserAgent    string
-	DKIMCert     *tls.Certificate
+	From           string
+	Organization   string
+	UserAgent      string
+	DKIMCert       *tls.Certificate
+	AllowedFrom    []string      // addresses or domains requests may send from, besides From
+	TrustedSources []string      // services whose requests may send from any address
+	TextEncoding   mail.Encoding // optional transfer encoding of text parts, 8bit requires server 8BITMIME support
+	HTMLEncoding   mail.Encoding // optional transfer encoding of html parts, 8bit requires server 8BITMIME support
 }
 
 type ServiceKafkaDeliveryOptions struct {
-	Topic  string
-	Router *routing.ConsumerRouter
+	Topic            string
+	Router           *routing.ConsumerRouter
+	OnProduceFailure func(messageID string, err error) // optional, called when an email request can't be produced, even after retries
+	DeadLetter       routing.DeadLetterFunc            // optional, receives the records which can't be decoded, i.e. of unknown schema versions
+	// BatchSize enables the batched mode when greater than 1: the emails of consumed records are handed to the SMTP
+	// pool in batches of up to BatchSize, each sent over the session of a single worker. By default, each email
+	// is handed to the pool on its own.
+	BatchSize int
 }
 
 type ServiceClientsOptions struct {
 	Email *email.Client
 	Kafka *kafka.Client
+	Redis *redis.Client // required by RetryModeQueue
+}
+
+type ServiceRetryOptions struct {
+	Mode        RetryMode
+	Delay       time.Duration // delay of the first retry of a queued request, doubled on each of the next ones
+	MaxAttempts int           // retries of a queued request before it's handed to the dead letter, or dropped without one
 }
 
 type ServiceOptions struct {
 	Clients           ServiceClientsOptions
 	EmailBuild        ServiceEmailBuildOptions
 	KafkaDelivery     ServiceKafkaDeliveryOptions
+	Retry             ServiceRetryOptions
 	TemplatesLocation string
+	RequiredTemplates []string // loaded on Start, which fails when any of them is missing or broken
+	DryRun            bool     // consumed email requests are rendered and logged, but never submitted to SMTP
 	Logger            *zerolog.Logger
 }
 
 func NewService(options *ServiceOptions) *Service {
-	return &Service{
+	service := &Service{
 		clients: clients{
 			email: options.Clients.Email,
 			kafka: options.Clients.Kafka,
@@ -87,70 +125,431 @@ func NewService(options *ServiceOptions) *Service {
 			organization: options.EmailBuild.Organization,
 			userAgent:    options.EmailBuild.UserAgent,
 			dkimCert:     options.EmailBuild.DKIMCert,
+			textEncoding: options.EmailBuild.TextEncoding,
+			htmlEncoding: options.EmailBuild.HTMLEncoding,
+			senders: newSenderPolicy(
+				options.EmailBuild.From, options.EmailBuild.AllowedFrom, options.EmailBuild.TrustedSources,
+			),
 		},
 		kafkaDelivery: kafkaDeliveryOpts{
-			topic:  options.KafkaDelivery.Topic,
-			router: options.KafkaDelivery.Router,
+			topic:            options.KafkaDelivery.Topic,
+			router:           options.KafkaDelivery.Router,
+			onProduceFailure: options.KafkaDelivery.OnProduceFailure,
+			deadLetter:       options.KafkaDelivery.DeadLetter,
+			batchSize:        options.KafkaDelivery.BatchSize,
+		},
+		produceRetries: produceRetries{
+			lifecycleCtx: context.Background(),
+			cancel:       func() {},
 		},
 		templatesManager: newTemplateManager(&templateManagerOptions{
 			Location: options.TemplatesLocation,
 		}),
+		dryRun: options.DryRun,
 		logger: options.Logger,
 	}
+	service.produceRetries.produceRecord = func(ctx context.Context, record *kgo.Record, promise func(*kgo.Record, error)) error {
+		driver, err := service.kafkaDriver()
+		if err != nil {
+			return err
+		}
+		driver.Produce(ctx, record, promise)
+		return nil
+	}
+	for _, id := range options.RequiredTemplates {
+		service.requiredTemplates = append(service.requiredTemplates, templateID(id))
+	}
+	if options.Retry.Mode == RetryModeQueue {
+		service.retries = &retryQueue{
+			redis:       options.Clients.Redis,
+			owner:       options.KafkaDelivery.Router,
+			topic:       options.KafkaDelivery.Topic,
+			delay:       cmp.Or(options.Retry.Delay, defaultRetryDelay),
+			maxAttempts: cmp.Or(options.Retry.MaxAttempts, defaultRetryAttempts),
+			deadLetter:  options.KafkaDelivery.DeadLetter,
+			logger:      options.Logger,
+		}
+	}
+	return service
 }
 
-func (s *Service) Start(_ context.Context) error {
-	s.kafkaDelivery.router.OnRecordsFrom(s.kafkaDelivery.topic, func(records []*kgo.Record) {
-		for _, record := range records {
-			var request emailv1.SendEmailRequest
-			if err := proto.Unmarshal(record.Value, &request); err != nil {
-				s.logger.Error().Err(err).Msgf(
-					"Failed to unmarshal email request from Kafka record received from topic '%s' partition '%d' at offset '%d'",
-					record.Topic, record.Partition, record.Offset,
-				)
+func (s *Service) Start(ctx context.Context) error {
+	if err := s.templatesManager.verify(s.requiredTemplates); err != nil {
+		return fmt.Errorf("failed to verify email templates: %w", err)
+	}
+
+	s.produceRetries.lifecycleCtx, s.produceRetries.cancel = context.WithCancel(context.Background())
+
+	if s.retries != nil {
+		if err := s.retries.start(ctx, s.deliver); err != nil {
+			return fmt.Errorf("failed to start email retry queue: %w", err)
+		}
+	}
+
+	s.kafkaDelivery.router.OnRecordsFrom(s.kafkaDelivery.topic, s.handleRecords)
+	return nil
+}
+
+// handleRecords delivers the email requests of the consumed records. It returns once each of them was sent,
+// queued for retry or failed for good, so the records are marked only after their emails left the pool, and
+// the router waiting for in-flight handlers on shutdown and revoke is enough for none of them to be lost.
+func (s *Service) handleRecords(records []*kgo.Record) error {
+	if s.kafkaDelivery.batchSize > 1 {
+		return s.deliverBatched(records)
+	}
+
+	for idx, record := range records {
+		if s.retries != nil {
+			// records of a key with pending retries are queued behind them, so they are sent in order
+			queued, err := s.retries.enqueueIfPending(record)
+			if err != nil {
+				return routing.NewBackpressureError(records[idx:], err)
+			}
+			if queued {
 				continue
 			}
+		}
 
-			message, err := s.buildMessageFromProto(&request)
-			if err != nil {
-				s.logger.Error().Err(err).Msgf(
-					"Failed to build email message from proto for Kafka record received from topic '%s' partition '%d' at offset '%d'",
-					record.Topic, record.Partition, record.Offset,
-				)
+		err := s.deliver(record)
+		if err == nil {
+			continue
+		}
+		if s.retries != nil {
+			if err = s.retries.enqueue(record, s.retries.delay); err == nil {
 				continue
 			}
+		}
+		// SMTP pool is saturated or the relay failed transiently, let the router pause the partition and
+		// redeliver the rest later
+		return routing.NewBackpressureError(records[idx:], err)
+	}
+	return nil
+}
+
+// deliver submits the email request of the record to the SMTP pool and waits for it to be sent. Only
+// email.ErrQueueFull and the send failures a retry may fix (see email.IsRetriable) are returned, the other ones
+// can't be fixed by a redelivery and are logged.
+func (s *Service) deliver(record *kgo.Record) error {
+	options, ok := s.prepareDelivery(record)
+	if !ok {
+		return nil
+	}
+
+	response := make(chan error, 1)
+	err := s.clients.email.TrySend(email.Request{
+		SendOptions: options,
+		Response:    response,
+	})
+	if errors.Is(err, email.ErrQueueFull) {
+		return err
+	}
+	if err == nil {
+		err = <-response
+	}
+	if err != nil && email.IsRetriable(err) {
+		return err
+	}
+	if err != nil {
+		s.logSendFailure(record, err)
+	}
+	return nil
+}
 
-			err = s.clients.email.Send(email.Request{
-				SendOptions: email.SendEmailOptions{
-					Email: message,
-					SendOptions: &smtp.MailOptions{
-						Return:     smtp.DSNReturnHeaders,
-						EnvelopeID: request.GetMessageId(),
-					},
-					ReceiveOptions: &smtp.RcptOptions{
-						Notify:                []smtp.DSNNotify{smtp.DSNNotifyFailure},
-						OriginalRecipientType: smtp.DSNAddressTypeRFC822,
-					},
-				},
-				Response: make(chan error, 1),
-			})
+// relayBatch holds the emails of consumed records sharing a relay, see email.Relay, until there are enough of them
+// to be handed to the SMTP pool as one batch.
+type relayBatch struct {
+	records []*kgo.Record
+	emails  []email.SendEmailOptions
+}
+
+// deliverBatched is the batched counterpart of the per record delivery loop: the emails are batched by relay, so
+// each batch is sent to a single destination over one SMTP session. Records of a key with pending retries are still
+// queued behind them, and a batch the SMTP pool can't accept is queued for retry or applies backpressure.
+func (s *Service) deliverBatched(records []*kgo.Record) error {
+	batches := make(map[string]*relayBatch)
+	relays := make([]string, 0, 1) // in the order they first appear, batches are sent in that order
+
+	pending := func() []*kgo.Record {
+		var records []*kgo.Record
+		for _, relay := range relays {
+			records = append(records, batches[relay].records...)
+		}
+		return records
+	}
+	// flush sends the pending batches, the ones left after a backpressure are unprocessed as well
+	flush := func() *routing.BackpressureError {
+		for idx, relay := range relays {
+			batch := batches[relay]
+			if backpressure := s.sendBatch(batch.records, batch.emails); backpressure != nil {
+				batch.records, batch.emails = nil, nil
+				for _, unsent := range relays[idx+1:] {
+					backpressure.Unprocessed = append(backpressure.Unprocessed, batches[unsent].records...)
+				}
+				return backpressure
+			}
+			batch.records, batch.emails = batch.records[:0], batch.emails[:0]
+		}
+		return nil
+	}
+
+	for idx, record := range records {
+		if s.retries != nil {
+			queued, err := s.retries.enqueueIfPending(record)
 			if err != nil {
-				s.logger.Error().Err(err).Msgf(
-					"Failed to send email for Kafka record received from topic '%s' partition '%d' at offset '%d'",
-					record.Topic, record.Partition, record.Offset,
-				)
+				if backpressure := flush(); backpressure != nil {
+					return rewindTo(backpressure.Cause, backpressure.Unprocessed, records[idx:])
+				}
+				return routing.NewBackpressureError(records[idx:], err)
+			}
+			if queued {
 				continue
 			}
 		}
-	})
+
+		options, ok := s.prepareDelivery(record)
+		if !ok {
+			continue
+		}
+		relay := email.Relay(options)
+		batch, ok := batches[relay]
+		if !ok {
+			batch = &relayBatch{
+				records: make([]*kgo.Record, 0, s.kafkaDelivery.batchSize),
+				emails:  make([]email.SendEmailOptions, 0, s.kafkaDelivery.batchSize),
+			}
+			batches[relay] = batch
+			relays = append(relays, relay)
+		}
+		batch.records = append(batch.records, record)
+		batch.emails = append(batch.emails, options)
+		if len(batch.records) < s.kafkaDelivery.batchSize {
+			continue
+		}
+
+		backpressure := s.sendBatch(batch.records, batch.emails)
+		batch.records, batch.emails = batch.records[:0], batch.emails[:0]
+		if backpressure != nil {
+			return rewindTo(backpressure.Cause, backpressure.Unprocessed, pending(), records[idx+1:])
+		}
+	}
+
+	if backpressure := flush(); backpressure != nil {
+		return rewindTo(backpressure.Cause, backpressure.Unprocessed)
+	}
 	return nil
 }
 
+// rewindTo returns the backpressure of the unprocessed records sorted by offset, the router rewinding the partition
+// to the first of them. Batches of other relays sent meanwhile are redelivered as well.
+func rewindTo(cause error, unprocessed ...[]*kgo.Record) *routing.BackpressureError {
+	records := slices.Concat(unprocessed...)
+	slices.SortFunc(records, func(a, b *kgo.Record) int { return cmp.Compare(a.Offset, b.Offset) })
+	return routing.NewBackpressureError(records, cause)
+}
+
+// sendBatch hands the emails to the SMTP pool and logs the ones which failed. When the pool is saturated, the
+// records are queued for retry, and the ones which can't be are returned as unprocessed. The same goes for the
+// emails which failed transiently, from the first one which can't be queued on.
+func (s *Service) sendBatch(records []*kgo.Record, emails []email.SendEmailOptions) *routing.BackpressureError {
+	if len(emails) == 0 {
+		return nil
+	}
+
+	response, err := s.clients.email.TrySendBatch(emails)
+	if errors.Is(err, email.ErrQueueFull) {
+		for idx, record := range records {
+			if s.retries == nil {
+				return routing.NewBackpressureError(slices.Clone(records[idx:]), err)
+			}
+			if err := s.retries.enqueue(record, s.retries.delay); err != nil {
+				return routing.NewBackpressureError(slices.Clone(records[idx:]), err)
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		for _, record := range records {
+			s.logSendFailure(record, err)
+		}
+		return nil
+	}
+
+	for idx, err := range <-response {
+		if err == nil {
+			continue
+		}
+		if !email.IsRetriable(err) {
+			s.logSendFailure(records[idx], err)
+			continue
+		}
+		if s.retries == nil {
+			return routing.NewBackpressureError(slices.Clone(records[idx:]), err)
+		}
+		if err := s.retries.enqueue(records[idx], s.retries.delay); err != nil {
+			return routing.NewBackpressureError(slices.Clone(records[idx:]), err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) logSendFailure(record *kgo.Record, err error) {
+	s.logger.Error().Err(err).Msgf(
+		"Failed to send email for Kafka record received from topic '%s' partition '%d' at offset '%d'",
+		record.Topic, record.Partition, record.Offset,
+	)
+}
+
+// prepareDelivery builds the SMTP send options of the email request of the record. It returns false when the
+// record must be skipped, failures being logged, or when it was handled by the dry run.
+func (s *Service) prepareDelivery(record *kgo.Record) (email.SendEmailOptions, bool) {
+	var request emailv1.SendEmailRequest
+	if _, err := sendEmailRequestSchema.Open(record.Value, &request); err != nil {
+		s.reject(record, err)
+		return email.SendEmailOptions{}, false
+	}
+
+	// records may be produced by other clients than Send, so the sender is checked again
+	if err := s.emailMsgBuild.senders.check(&request); err != nil {
+		s.logger.Error().Err(err).Msgf(
+			"Rejected email request from Kafka record received from topic '%s' partition '%d' at offset '%d'",
+			record.Topic, record.Partition, record.Offset,
+		)
+		return email.SendEmailOptions{}, false
+	}
+
+	message, err := s.buildMessageFromProto(&request)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf(
+			"Failed to build email message from proto for Kafka record received from topic '%s' partition '%d' at offset '%d'",
+			record.Topic, record.Partition, record.Offset,
+		)
+		return email.SendEmailOptions{}, false
+	}
+
+	if s.dryRun {
+		s.logDryRun(&request, message)
+		return email.SendEmailOptions{}, false
+	}
+
+	return email.SendEmailOptions{
+		Email: message,
+		SendOptions: &smtp.MailOptions{
+			Return:     smtp.DSNReturnHeaders,
+			EnvelopeID: request.GetMessageId(),
+		},
+		ReceiveOptions: &smtp.RcptOptions{
+			Notify:                []smtp.DSNNotify{smtp.DSNNotifyFailure},
+			OriginalRecipientType: smtp.DSNAddressTypeRFC822,
+		},
+	}, true
+}
+
+// reject hands a record whose email request can't be decoded to the dead letter, it is logged when there is none
+// or when the dead letter fails, as a redelivery wouldn't decode it either.
+func (s *Service) reject(record *kgo.Record, err error) {
+	if s.kafkaDelivery.deadLetter != nil {
+		deadLetterErr := s.kafkaDelivery.deadLetter([]*kgo.Record{record}, err)
+		if deadLetterErr == nil {
+			s.logger.Warn().Err(err).Msgf(
+				"Dead lettered email request from Kafka record received from topic '%s' partition '%d' at offset '%d'",
+				record.Topic, record.Partition, record.Offset,
+			)
+			return
+		}
+		err = errors.Join(err, fmt.Errorf("dead lettering failed: %w", deadLetterErr))
+	}
+	s.logger.Error().Err(err).Msgf(
+		"Failed to decode email request from Kafka record received from topic '%s' partition '%d' at offset '%d'",
+		record.Topic, record.Partition, record.Offset,
+	)
+}
+
 func (s *Service) Stop(_ context.Context) {
 	s.logger.Debug().Msg("Shutting down email service")
+	s.produceRetries.cancel()
+	if s.retries != nil {
+		s.retries.stop()
+	}
+	if pending := s.produceRetries.pending.Load(); pending > 0 {
+		s.logger.Warn().Msgf("Email service stopped with %d email records pending produce retry", pending)
+	}
 }
 
+// Send produces the email request asynchronously, transient broker errors are retried in background. Errors
+// reported before the record is buffered by the Kafka client (i.e. client closed) are returned.
 func (s *Service) Send(ctx context.Context, request *emailv1.SendEmailRequest) error {
+	record, err := s.buildRecord(request)
+	if err != nil {
+		return err
+	}
+
+	if err := s.produce(ctx, record, 0); err != nil {
+		return fmt.Errorf("email service can't send email because Kafka rejected the record: %w", err)
+	}
+	return nil
+}
+
+// SendSync is like Send, but blocks until the email request is acknowledged by Kafka. Failed produces are
+// returned to the caller and not retried.
+func (s *Service) SendSync(ctx context.Context, request *emailv1.SendEmailRequest) error {
+	record, err := s.buildRecord(request)
+	if err != nil {
+		return err
+	}
+
+	driver, err := s.kafkaDriver()
+	if err != nil {
+		return fmt.Errorf("email service can't send email because Kafka rejected the record: %w", err)
+	}
+	if err := driver.ProduceSync(ctx, record).FirstErr(); err != nil {
+		return fmt.Errorf("email service can't send email because produce to Kafka failed: %w", err)
+	}
+	return nil
+}
+
+// kafkaDriver returns the driver of the Kafka client, unless the client is stopped. The driver fails the records
+// produced once closed only after buffering them, so closing is checked before producing.
+func (s *Service) kafkaDriver() (*kgo.Client, error) {
+	driver := s.clients.kafka.Driver
+	if driver == nil || driver.Context().Err() != nil {
+		return nil, kgo.ErrClientClosed
+	}
+	return driver, nil
+}
+
+// Render runs the whole message build path of an email request, i.e. validation, template rendering, headers and
+// signing, and returns the MIME message which would be submitted to SMTP, without sending it.
+func (s *Service) Render(_ context.Context, request *emailv1.SendEmailRequest) ([]byte, error) {
+	if err := s.prepareRequest(request); err != nil {
+		return nil, err
+	}
+
+	message, err := s.buildMessageFromProto(request)
+	if err != nil {
+		return nil, fmt.Errorf("email service can't render email because message build failed: %w", err)
+	}
+	return renderMessage(message)
+}
+
+func (s *Service) logDryRun(request *emailv1.SendEmailRequest, message *mail.Msg) {
+	rendered, err := renderMessage(message)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Dry run failed to render email '%s'", request.GetMessageId())
+		return
+	}
+	s.logger.Info().Msgf("Dry run rendered email '%s' (%d bytes) instead of sending it:\n%s",
+		request.GetMessageId(), len(rendered), rendered)
+}
+
+func renderMessage(message *mail.Msg) ([]byte, error) {
+	var buffer bytes.Buffer
+	if _, err := message.WriteTo(&buffer); err != nil {
+		return nil, fmt.Errorf("failed to write email message: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (s *Service) prepareRequest(request *emailv1.SendEmailRequest) error {
 	if request.GetEmail().GetFrom() == nil {
 		request.GetEmail().From = &emailv1.EmailAddress{
 			Email: s.emailMsgBuild.from,
@@ -160,27 +559,24 @@ func (s *Service) Send(ctx context.Context, request *emailv1.SendEmailRequest) e
 	if err := protovalidate.Validate(request); err != nil {
 		return fmt.Errorf("email service can't send email because of the validation error: %w", err)
 	}
+	return s.emailMsgBuild.senders.check(request)
+}
+
+func (s *Service) buildRecord(request *emailv1.SendEmailRequest) (*kgo.Record, error) {
+	if err := s.prepareRequest(request); err != nil {
+		return nil, err
+	}
 
-	payload, err := proto.Marshal(request)
+	payload, err := sendEmailRequestSchema.Seal(request, "")
 	if err != nil {
-		return fmt.Errorf("email service can't send email because of the marshaling error: %w", err)
+		return nil, fmt.Errorf("email service can't send email because of the marshaling error: %w", err)
 	}
 
-	s.clients.kafka.Driver.Produce(ctx, &kgo.Record{
+	return &kgo.Record{
 		Topic: s.kafkaDelivery.topic,
 		Key:   []byte(request.GetMessageId()),
 		Value: payload,
-	}, func(record *kgo.Record, err error) {
-		if err != nil {
-			s.logger.Error().Err(err).Msg("Failed to produce email record to Kafka")
-			return
-		}
-		s.logger.Info().Msgf(
-			"Email record produced to Kafka topic %s partition %d at offset %d",
-			record.Topic, record.Partition, record.Offset,
-		)
-	})
-	return nil
+	}, nil
 }
 
 func (s *Service) buildMessageFromProto(request *emailv1.SendEmailRequest) (*mail.Msg, error) {
@@ -299,11 +695,11 @@ func (s *Service) buildMessageFromProto(request *emailv1.SendEmailRequest) (*mai
 
 	switch emailFromRequest.GetContentMode() {
 	case emailv1.ContentMode_CONTENT_MODE_RAW:
-		if emailFromRequest.GetRaw().GetText() != "" {
-			message.AddAlternativeString(mail.TypeTextPlain, emailFromRequest.GetRaw().GetText())
+		if text := emailFromRequest.GetRaw().GetText(); text != "" {
+			message.AddAlternativeString(mail.TypeTextPlain, text, partEncoding(s.emailMsgBuild.textEncoding, &text)...)
 		}
-		if emailFromRequest.GetRaw().GetHtml() != "" {
-			message.AddAlternativeString(mail.TypeTextHTML, emailFromRequest.GetRaw().GetHtml())
+		if html := emailFromRequest.GetRaw().GetHtml(); html != "" {
+			message.AddAlternativeString(mail.TypeTextHTML, html, partEncoding(s.emailMsgBuild.htmlEncoding, &html)...)
 		}
 	case emailv1.ContentMode_CONTENT_MODE_TEMPLATE:
 		templates, err := s.templatesManager.Get(
@@ -319,11 +715,27 @@ func (s *Service) buildMessageFromProto(request *emailv1.SendEmailRequest) (*mai
 			)
 		}
 
+		subject, rendered, err := templates.renderSubject(emailFromRequest.GetTemplate().GetVars())
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to render subject for template ID '%s' and locale '%s': %w",
+				emailFromRequest.GetTemplate().GetTemplateId(),
+				emailFromRequest.GetTemplate().GetLocale(),
+				err,
+			)
+		}
+		if rendered && subject != "" {
+			message.Subject(subject)
+		}
+
 		templateAdded := false
 
 		if templates.text != nil {
 			err := message.AddAlternativeTextTemplate(templates.text, emailFromRequest.GetTemplate().GetVars(),
-				mail.WithPartContentDescription(emailFromRequest.GetTemplate().GetTemplateId()),
+				append(
+					partEncoding(s.emailMsgBuild.textEncoding, nil),
+					mail.WithPartContentDescription(emailFromRequest.GetTemplate().GetTemplateId()),
+				)...,
 			)
 			if err != nil {
 				return nil, fmt.Errorf(
@@ -339,7 +751,10 @@ func (s *Service) buildMessageFromProto(request *emailv1.SendEmailRequest) (*mai
 
 		if templates.html != nil {
 			err := message.AddAlternativeHTMLTemplate(templates.html, emailFromRequest.GetTemplate().GetVars(),
-				mail.WithPartContentDescription(emailFromRequest.GetTemplate().GetTemplateId()),
+				append(
+					partEncoding(s.emailMsgBuild.htmlEncoding, nil),
+					mail.WithPartContentDescription(emailFromRequest.GetTemplate().GetTemplateId()),
+				)...,
 			)
 			if err != nil {
 				return nil, fmt.Errorf(
@@ -401,6 +816,27 @@ func (s *Service) buildMessageFromProto(request *emailv1.SendEmailRequest) (*mai
 	return message, nil
 }
 
+// partEncoding returns the options selecting the configured transfer encoding of a part. Content which isn't 7bit
+// clean, or not known upfront (i.e. rendered from a template), is encoded quoted-printable instead of 7bit.
+func partEncoding(encoding mail.Encoding, content *string) []mail.PartOption {
+	if encoding == "" {
+		return nil
+	}
+	if encoding == mail.EncodingUSASCII && (content == nil || !isASCII(*content)) {
+		encoding = mail.EncodingQP
+	}
+	return []mail.PartOption{mail.WithPartEncoding(encoding)}
+}
+
+func isASCII(s string) bool {
+	for idx := 0; idx < len(s); idx++ {
+		if s[idx] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
 func mapHeader(s string) (mail.Header, bool) {
 	switch s {
 	case "Content-Description":
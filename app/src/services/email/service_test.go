@@ -0,0 +1,212 @@
+package email
+
+import (
+	"bytes"
+	"chat/src/clients/email"
+	"chat/src/clients/email/emailtest"
+	"chat/src/clients/kafka"
+	emailv1 "chat/src/gen/proto/email/v1"
+	"chat/src/platform/security/securitytest"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const testSender = "no-reply@example.com"
+
+// newTestService returns a service delivering to the fake server through a started SMTP pool, the pool being
+// returned as well so tests can stop it.
+func newTestService(t *testing.T, fake *emailtest.Server, batchSize int) (*Service, *email.Client) {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	client, err := email.NewClient(&email.ClientOptions{WorkerPoolOptions: email.WorkerPoolOptions{
+		SMTPClientOptions: &email.SMTPClientOptions{
+			Host:              fake.Host,
+			Port:              fake.Port,
+			TLSConfig:         fake.ClientTLS.Clone(),
+			Auth:              sasl.NewPlainClient("", "user", "password"),
+			ReconnectTimeout:  time.Second,
+			CommandTimeout:    time.Second,
+			SubmissionTimeout: time.Second,
+			SendTimeout:       2 * time.Second,
+			Logger:            &logger,
+		},
+		Logger:     &logger,
+		NumWorkers: 2,
+		QueueSize:  10,
+	}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	service := NewService(&ServiceOptions{
+		Clients:       ServiceClientsOptions{Email: client},
+		EmailBuild:    ServiceEmailBuildOptions{From: testSender, DKIMCert: securitytest.NewCertificate(t)},
+		KafkaDelivery: ServiceKafkaDeliveryOptions{Topic: testRetryTopic, BatchSize: batchSize},
+		Logger:        &logger,
+	})
+	return service, client
+}
+
+func newTestRecords(t *testing.T, count int) []*kgo.Record {
+	t.Helper()
+
+	recipients := make([]string, 0, count)
+	for idx := range count {
+		recipients = append(recipients, fmt.Sprintf("user-%d@example.com", idx))
+	}
+	return newTestRecordsTo(t, recipients...)
+}
+
+// newTestRecordsTo returns a record per recipient, whose message ID is "message-<index of the recipient>".
+func newTestRecordsTo(t *testing.T, recipients ...string) []*kgo.Record {
+	t.Helper()
+
+	records := make([]*kgo.Record, 0, len(recipients))
+	for idx, recipient := range recipients {
+		request := &emailv1.SendEmailRequest{
+			MessageId: fmt.Sprintf("message-%d", idx),
+			CreatedAt: timestamppb.Now(),
+			Email: &emailv1.Email{
+				From:        &emailv1.EmailAddress{Email: testSender},
+				To:          []*emailv1.EmailAddress{{Email: recipient}},
+				Subject:     "Hello",
+				ContentMode: emailv1.ContentMode_CONTENT_MODE_RAW,
+				Raw:         &emailv1.RawContent{Text: "Hello there"},
+			},
+		}
+		payload, err := sendEmailRequestSchema.Seal(request, "")
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		records = append(records, &kgo.Record{
+			Topic: testRetryTopic, Offset: int64(idx), Key: []byte(request.GetMessageId()), Value: payload,
+		})
+	}
+	return records
+}
+
+// messageIDs returns the sorted Message-ID headers of the messages.
+func messageIDs(t *testing.T, messages []emailtest.Message) []string {
+	t.Helper()
+
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		parsed, err := mail.ReadMessage(bytes.NewReader(message.Data))
+		if err != nil {
+			t.Fatalf("received message can't be parsed: %v", err)
+		}
+		ids = append(ids, strings.Trim(parsed.Header.Get("Message-ID"), "<>"))
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestHandleRecordsReturnsOnceEmailsAreSent(t *testing.T) {
+	for _, batchSize := range []int{0, 3} {
+		t.Run(fmt.Sprintf("batch size %d", batchSize), func(t *testing.T) {
+			fake := emailtest.NewServer(t, nil)
+			service, client := newTestService(t, fake, batchSize)
+			records := newTestRecords(t, 5)
+
+			if err := service.handleRecords(records); err != nil {
+				t.Fatalf("handleRecords() error = %v", err)
+			}
+			// the router marks the records once the handler returned, the pool stopping right after must lose nothing
+			sentBeforeStop := messageIDs(t, fake.Received())
+			client.Stop(context.Background())
+
+			want := []string{"message-0", "message-1", "message-2", "message-3", "message-4"}
+			if !slices.Equal(sentBeforeStop, want) {
+				t.Fatalf("emails sent when the handler returned = %v, want %v", sentBeforeStop, want)
+			}
+			if sent := messageIDs(t, fake.Received()); !slices.Equal(sent, want) {
+				t.Fatalf("emails sent after the pool stopped = %v, want %v", sent, want)
+			}
+		})
+	}
+}
+
+func TestDeliverBatchedGroupsEmailsByRelay(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	service, _ := newTestService(t, fake, 2)
+	records := newTestRecordsTo(t, "alice@one.com", "bob@two.com", "carol@one.com", "dave@three.com", "erin@two.com")
+
+	if err := service.handleRecords(records); err != nil {
+		t.Fatalf("handleRecords() error = %v", err)
+	}
+
+	var received []string
+	for _, message := range fake.Received() {
+		received = append(received, message.Recipients...)
+	}
+	// the batch of one.com is full first, the other ones are flushed in the order their relays appeared
+	want := []string{"alice@one.com", "carol@one.com", "bob@two.com", "erin@two.com", "dave@three.com"}
+	if !slices.Equal(received, want) {
+		t.Fatalf("received recipients = %v, want %v", received, want)
+	}
+}
+
+func TestRewindToSortsUnprocessedRecordsByOffset(t *testing.T) {
+	records := []*kgo.Record{{Offset: 4}, {Offset: 1}, {Offset: 3}, {Offset: 2}}
+
+	backpressure := rewindTo(email.ErrQueueFull, records[:2], records[2:])
+
+	var offsets []int64
+	for _, record := range backpressure.Unprocessed {
+		offsets = append(offsets, record.Offset)
+	}
+	if !slices.Equal(offsets, []int64{1, 2, 3, 4}) || backpressure.Cause != email.ErrQueueFull {
+		t.Fatalf("rewindTo() = %v caused by %v, want offsets [1 2 3 4] caused by ErrQueueFull", offsets, backpressure.Cause)
+	}
+}
+
+func TestHandleRecordsDeadLettersUnreadableEnvelopes(t *testing.T) {
+	fake := emailtest.NewServer(t, nil)
+	service, _ := newTestService(t, fake, 0)
+	var deadLettered []error
+	service.kafkaDelivery.deadLetter = func(records []*kgo.Record, cause error) error {
+		for range records {
+			deadLettered = append(deadLettered, cause)
+		}
+		return nil
+	}
+
+	newer := newTestRecordsTo(t, "bob@example.com")[0]
+	newer.Offset = 1
+	newerSchema := kafka.Schema{ID: sendEmailRequestSchema.ID, Version: sendEmailRequestSchema.Version + 1}
+	value, err := newerSchema.Seal(&emailv1.SendEmailRequest{MessageId: "newer"}, "")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	newer.Value = value
+	bare := &kgo.Record{Topic: testRetryTopic, Offset: 2, Key: []byte("bare"), Value: []byte("not an envelope")}
+	records := append(newTestRecords(t, 1), newer, bare)
+
+	if err := service.handleRecords(records); err != nil {
+		t.Fatalf("handleRecords() error = %v", err)
+	}
+	if sent := messageIDs(t, fake.Received()); !slices.Equal(sent, []string{"message-0"}) {
+		t.Fatalf("emails sent = %v, want only the readable one", sent)
+	}
+	if len(deadLettered) != 2 ||
+		!errors.Is(deadLettered[0], kafka.ErrUnknownSchemaVersion) || !errors.Is(deadLettered[1], kafka.ErrMalformedEnvelope) {
+		t.Fatalf("dead lettered causes = %v, want an unknown schema version then a malformed envelope", deadLettered)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	ht "html/template"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	tt "text/template"
 
@@ -22,8 +23,22 @@ type templateID string
 type templateLocale string
 
 type templates struct {
-	text *tt.Template
-	html *ht.Template
+	text    *tt.Template
+	html    *ht.Template
+	subject *tt.Template // optional, the literal subject of the request is used without it
+}
+
+// renderSubject renders the subject template, if the locale has one, as a single line usable as a header.
+func (t templates) renderSubject(vars map[string]string) (string, bool, error) {
+	if t.subject == nil {
+		return "", false, nil
+	}
+
+	var subject strings.Builder
+	if err := t.subject.Execute(&subject, vars); err != nil {
+		return "", false, fmt.Errorf("failed to render subject template: %w", err)
+	}
+	return strings.Join(strings.Fields(subject.String()), " "), true, nil
 }
 
 type templatesCache struct {
@@ -50,6 +65,25 @@ func newTemplateManager(options *templateManagerOptions) *templateManager {
 	}
 }
 
+// verify checks that the templates location is a directory and loads the required templates, so a broken
+// deployment fails at startup instead of on the first templated request.
+func (tm *templateManager) verify(required []templateID) error {
+	info, err := os.Stat(tm.location)
+	if err != nil {
+		return fmt.Errorf("failed to stat templates location %q: %w", tm.location, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("templates location %q is not a directory", tm.location)
+	}
+
+	for _, id := range required {
+		if _, err := tm.Get(id, defaultLocale); err != nil {
+			return fmt.Errorf("required template %q is unusable: %w", id, err)
+		}
+	}
+	return nil
+}
+
 func (tm *templateManager) Get(id templateID, locale templateLocale) (templates, error) {
 	if locale == "" {
 		locale = defaultLocale
@@ -160,14 +194,26 @@ func (tm *templateManager) loadTemplates(id templateID) (map[templateLocale]temp
 			htmlTpl = tpl
 		}
 
+		// Load subject template (subject.txt)
+		var subjectTpl *tt.Template
+		subjectPath := filepath.Join(localePath, "subject.txt")
+		if fileExists(subjectPath) {
+			tpl, err := tt.ParseFiles(subjectPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse subject template %q (%s): %w", id, locale, err)
+			}
+			subjectTpl = tpl
+		}
+
 		// Skip locales that contain neither text nor HTML
 		if textTpl == nil && htmlTpl == nil {
 			return nil, fmt.Errorf("failed to find text and/or html template %q (%s): %w", id, locale, errTemplateNotFound)
 		}
 
 		result[locale] = templates{
-			text: textTpl,
-			html: htmlTpl,
+			text:    textTpl,
+			html:    htmlTpl,
+			subject: subjectTpl,
 		}
 	}
 
@@ -0,0 +1,166 @@
+package email
+
+import (
+	"bytes"
+	"chat/src/clients/kafka/kafkatest"
+	"chat/src/clients/kafka/routing"
+	emailv1 "chat/src/gen/proto/email/v1"
+	"chat/src/platform/security/securitytest"
+	"context"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRenderTemplatedSubjectAcrossLocales(t *testing.T) {
+	location := writeTestTemplate(t, "welcome", "en", map[string]string{
+		"index.txt":   "Hello {{.name}}",
+		"subject.txt": "Welcome {{.name}}\n",
+	})
+	locales := map[string]map[string]string{
+		"fr": {"index.txt": "Bonjour {{.name}}", "subject.txt": "Bienvenue {{.name}} à bord"},
+		"de": {"index.txt": "Hallo {{.name}}"}, // without subject template
+		"es": {"index.txt": "Hola {{.name}}", "subject.txt": "Bienvenido\n{{.name}}\r\nBcc: eve@example.com"},
+	}
+	for locale, files := range locales {
+		dir := filepath.Join(location, "welcome", locale)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatalf("failed to create template directory: %v", err)
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+				t.Fatalf("failed to write template file '%s': %v", name, err)
+			}
+		}
+	}
+
+	logger := zerolog.Nop()
+	service := NewService(&ServiceOptions{
+		EmailBuild:        ServiceEmailBuildOptions{From: testSender, DKIMCert: securitytest.NewCertificate(t)},
+		TemplatesLocation: location,
+		Logger:            &logger,
+	})
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "en", want: "Welcome Alice"},
+		{locale: "fr", want: "Bienvenue Alice à bord"},
+		{locale: "de", want: "Hello"}, // the literal subject of the request
+		{locale: "es", want: "Bienvenido Alice Bcc: eve@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			request := newTestSendRequest()
+			request.Email.Raw = nil
+			request.Email.ContentMode = emailv1.ContentMode_CONTENT_MODE_TEMPLATE
+			request.Email.Template = &emailv1.TemplateContent{
+				TemplateId: "welcome", Locale: &tt.locale, Vars: map[string]string{"name": "Alice"},
+			}
+
+			rendered, err := service.Render(context.Background(), request)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			message, err := mail.ReadMessage(bytes.NewReader(rendered))
+			if err != nil {
+				t.Fatalf("rendered message can't be parsed: %v\n%s", err, rendered)
+			}
+			subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+			if err != nil {
+				t.Fatalf("failed to decode subject: %v", err)
+			}
+			if subject != tt.want {
+				t.Errorf("Subject = %q, want %q", subject, tt.want)
+			}
+			if message.Header.Get("Bcc") != "" {
+				t.Error("expected the rendered subject not to inject headers")
+			}
+		})
+	}
+}
+
+func TestStartVerifiesTemplates(t *testing.T) {
+	valid := map[string]string{"index.txt": "Hello {{.name}}", "subject.txt": "Welcome {{.name}}"}
+	tests := []struct {
+		name     string
+		location func(t *testing.T) string
+		required []string
+		wantErr  bool
+	}{
+		{
+			name:     "missing location",
+			location: func(t *testing.T) string { return filepath.Join(t.TempDir(), "missing") },
+			wantErr:  true,
+		},
+		{
+			name: "location isn't a directory",
+			location: func(t *testing.T) string {
+				path := filepath.Join(t.TempDir(), "templates")
+				if err := os.WriteFile(path, nil, 0o600); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+				return path
+			},
+			wantErr: true,
+		},
+		{
+			name:     "missing required template",
+			location: func(t *testing.T) string { return writeTestTemplate(t, "welcome", "en", valid) },
+			required: []string{"welcome", "password-reset"},
+			wantErr:  true,
+		},
+		{
+			name: "broken required template",
+			location: func(t *testing.T) string {
+				return writeTestTemplate(t, "welcome", "en", map[string]string{"index.txt": "Hello {{.name"})
+			},
+			required: []string{"welcome"},
+			wantErr:  true,
+		},
+		{
+			name:     "required template without default locale",
+			location: func(t *testing.T) string { return writeTestTemplate(t, "welcome", "fr", valid) },
+			required: []string{"welcome"},
+			wantErr:  true,
+		},
+		{
+			name:     "required templates",
+			location: func(t *testing.T) string { return writeTestTemplate(t, "welcome", "en", valid) },
+			required: []string{"welcome"},
+		},
+		{
+			name:     "no required templates",
+			location: func(t *testing.T) string { return t.TempDir() },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			router, err := routing.NewConsumerRouter(&routing.ConsumerRouterOptions{Client: kafkatest.NewClient(t, nil), Logger: &logger})
+			if err != nil {
+				t.Fatalf("NewConsumerRouter() error = %v", err)
+			}
+			service := NewService(&ServiceOptions{
+				EmailBuild:        ServiceEmailBuildOptions{From: testSender, DKIMCert: securitytest.NewCertificate(t)},
+				KafkaDelivery:     ServiceKafkaDeliveryOptions{Topic: testRetryTopic, Router: router},
+				TemplatesLocation: tt.location(t),
+				RequiredTemplates: tt.required,
+				Logger:            &logger,
+			})
+
+			err = service.Start(context.Background())
+			if err == nil {
+				service.Stop(context.Background())
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start() error = %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package fanout
+
+import (
+	"chat/src/clients/neo4j"
+	"context"
+	"fmt"
+
+	neo4j2 "github.com/neo4j/neo4j-go-driver/v6/neo4j"
+)
+
+const cypherChatMembers = "MATCH (u:User)-[:MEMBER_OF]->(:Chat {id: $chatId}) RETURN u.id AS id"
+
+// MembershipResolver resolves the ids of the users who are members of a chat.
+type MembershipResolver interface {
+	ChatMembers(ctx context.Context, chatID string) ([]string, error)
+}
+
+type neo4jMembershipResolver struct {
+	client *neo4j.Client
+}
+
+// NewNeo4jMembershipResolver resolves membership from the (:User)-[:MEMBER_OF]->(:Chat) relationships of the graph.
+func NewNeo4jMembershipResolver(client *neo4j.Client) MembershipResolver {
+	return &neo4jMembershipResolver{client: client}
+}
+
+func (r *neo4jMembershipResolver) ChatMembers(ctx context.Context, chatID string) ([]string, error) {
+	session := r.client.NewSession(ctx, neo4j2.AccessModeRead)
+	defer func() {
+		_ = session.Close(ctx)
+	}()
+
+	members, err := session.ExecuteRead(ctx, func(tx neo4j2.ManagedTransaction) (any, error) {
+		result, err := tx.Run(ctx, cypherChatMembers, map[string]any{"chatId": chatID})
+		if err != nil {
+			return nil, err
+		}
+		records, err := result.Collect(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, 0, len(records))
+		for _, record := range records {
+			id, _, err := neo4j2.GetRecordValue[string](record, "id")
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query members of chat '%s': %w", chatID, err)
+	}
+
+	return members.([]string), nil
+}
@@ -0,0 +1,66 @@
+package fanout
+
+import (
+	chatv1 "chat/src/gen/proto/chat/v1"
+	"chat/src/services/presence"
+)
+
+// Route tells how a chat member is notified about a new message.
+type Route uint8
+
+const (
+	// RouteStored delivers the message only to the member inbox, to be read when the member connects.
+	RouteStored Route = iota
+	// RouteRealtime additionally delivers the message to the live notifications of the member.
+	RouteRealtime
+)
+
+// DeliveryPolicy decides the route of each chat member, the returned routes being parallel to members.
+type DeliveryPolicy interface {
+	Routes(message *chatv1.ChatMessage, members []string) []Route
+}
+
+// PresenceResolver is the subset of the presence service used by the presence aware policy.
+type PresenceResolver interface {
+	StatusMulti(userIDs []string) map[string]presence.Status
+}
+
+type realtimeDeliveryPolicy struct{}
+
+// NewRealtimeDeliveryPolicy routes every member to realtime delivery.
+func NewRealtimeDeliveryPolicy() DeliveryPolicy {
+	return realtimeDeliveryPolicy{}
+}
+
+func (realtimeDeliveryPolicy) Routes(_ *chatv1.ChatMessage, members []string) []Route {
+	routes := make([]Route, len(members))
+	for idx := range routes {
+		routes[idx] = RouteRealtime
+	}
+	return routes
+}
+
+type presenceDeliveryPolicy struct {
+	presence PresenceResolver
+}
+
+// NewPresenceDeliveryPolicy routes online members to realtime delivery and offline ones to the stored path.
+// Members whose status is unknown are treated as maybe online, so a presence outage doesn't drop notifications.
+func NewPresenceDeliveryPolicy(presence PresenceResolver) DeliveryPolicy {
+	return &presenceDeliveryPolicy{presence: presence}
+}
+
+func (p *presenceDeliveryPolicy) Routes(_ *chatv1.ChatMessage, members []string) []Route {
+	statuses := p.presence.StatusMulti(members)
+
+	routes := make([]Route, len(members))
+	for idx, member := range members {
+		status, known := statuses[member]
+		if !known || status != presence.StatusOffline {
+			routes[idx] = RouteRealtime
+		} else {
+			routes[idx] = RouteStored
+		}
+	}
+	return routes
+}
@@ -0,0 +1,69 @@
+package fanout
+
+import (
+	"chat/src/services/presence"
+	"slices"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+// fakePresence resolves the statuses of the users it knows about, the others being cache misses.
+type fakePresence map[string]presence.Status
+
+func (p fakePresence) StatusMulti(userIDs []string) map[string]presence.Status {
+	statuses := make(map[string]presence.Status, len(userIDs))
+	for _, userID := range userIDs {
+		if status, known := p[userID]; known {
+			statuses[userID] = status
+		}
+	}
+	return statuses
+}
+
+func TestPresenceDeliveryPolicyRoutesMembersByStatus(t *testing.T) {
+	policy := NewPresenceDeliveryPolicy(fakePresence{
+		"alice": presence.StatusOnline,
+		"bob":   presence.StatusOffline,
+		"carol": presence.StatusAway,
+		"dave":  presence.StatusUnknown,
+	})
+
+	members := []string{"alice", "bob", "carol", "dave", "erin"}
+	want := []Route{RouteRealtime, RouteStored, RouteRealtime, RouteRealtime, RouteRealtime}
+	if routes := policy.Routes(newTestMessage(gocql.TimeUUID().String()), members); !slices.Equal(routes, want) {
+		t.Fatalf("Routes() = %v, want %v, only offline members being routed to the stored path", routes, want)
+	}
+}
+
+func TestFanoutWithPresencePolicyNotifiesOnlyMaybeOnlineMembers(t *testing.T) {
+	chatID := gocql.TimeUUID().String()
+	recorder := &fakeSinks{}
+	service := newTestService(&fakeMembership{members: map[string][]string{chatID: {"alice", "bob", "carol"}}}, recorder)
+	service.policy = NewPresenceDeliveryPolicy(fakePresence{"alice": presence.StatusOffline, "bob": presence.StatusOnline})
+
+	if err := service.fanout(newTestMessage(chatID)); err != nil {
+		t.Fatalf("fanout() error = %v", err)
+	}
+
+	if recorder.histories != 1 {
+		t.Errorf("history writes = %d, want the message to be stored regardless of presence", recorder.histories)
+	}
+	want := []delivery{
+		{topic: "user-inbox", recipient: "alice"},
+		{topic: "user-inbox", recipient: "bob"},
+		{topic: "user-notifications", recipient: "bob"},
+		{topic: "user-inbox", recipient: "carol"},
+		{topic: "user-notifications", recipient: "carol"}, // cache miss, maybe online
+	}
+	if got := deliveries(t, recorder.produced); !slices.Equal(got, want) {
+		t.Fatalf("deliveries = %+v, want %+v", got, want)
+	}
+}
+
+func TestRealtimeDeliveryPolicyRoutesEveryMember(t *testing.T) {
+	routes := NewRealtimeDeliveryPolicy().Routes(newTestMessage(gocql.TimeUUID().String()), []string{"alice", "bob"})
+	if !slices.Equal(routes, []Route{RouteRealtime, RouteRealtime}) {
+		t.Fatalf("Routes() = %v, want every member in realtime", routes)
+	}
+}
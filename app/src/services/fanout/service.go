@@ -0,0 +1,281 @@
+package fanout
+
+import (
+	"chat/src/clients/kafka"
+	"chat/src/clients/kafka/routing"
+	"chat/src/clients/scylla"
+	"chat/src/clients/scylla/gen"
+	chatv1 "chat/src/gen/proto/chat/v1"
+	"chat/src/platform/validation"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"buf.build/go/protovalidate"
+	"github.com/creasty/defaults"
+	"github.com/gocql/gocql"
+	"github.com/rs/zerolog"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/proto"
+)
+
+// @FIXME deliveries of a message are not produced transactionally, a redelivered message may reach some members twice
+
+var ErrInvalidChatMessage = errors.New("invalid chat message")
+
+type clients struct {
+	kafka  *kafka.Client
+	scylla *scylla.Client
+}
+
+type topics struct {
+	groupInbox        string // #readonly
+	userInbox         string // #readonly
+	userNotifications string // #readonly
+}
+
+// sinks write the fanout of a message, to the Scylla and Kafka clients outside of tests.
+type sinks struct {
+	execHistory func(ctx context.Context, statement string, values ...any) error
+	produce     func(ctx context.Context, records ...*kgo.Record) error
+}
+
+type Service struct {
+	clients    clients
+	sinks      sinks
+	topics     topics
+	router     *routing.ConsumerRouter
+	membership MembershipResolver
+	policy     DeliveryPolicy
+	timeouts   ServiceTimeoutsOptions
+	logger     *zerolog.Logger
+}
+
+type ServiceClientsOptions struct {
+	Kafka  *kafka.Client  `validate:"required"`
+	Scylla *scylla.Client `validate:"required"`
+}
+
+type ServiceTopicsOptions struct {
+	GroupInbox        string `validate:"required"`
+	UserInbox         string `validate:"required,nefield=GroupInbox"`
+	UserNotifications string `validate:"required,nefield=GroupInbox,nefield=UserInbox"`
+}
+
+type ServiceTimeoutsOptions struct {
+	Membership time.Duration `validate:"required,min=10000000,max=5000000000" default:"1s"`   // 10ms to 5s
+	History    time.Duration `validate:"required,min=10000000,max=5000000000" default:"1s"`   // 10ms to 5s
+	Produce    time.Duration `validate:"required,min=100000000,max=30000000000" default:"5s"` // 100ms to 30s
+}
+
+type ServiceOptions struct {
+	Clients    ServiceClientsOptions `default:"-"` // skipped by defaults, which would read the started drivers
+	Topics     ServiceTopicsOptions
+	Router     *routing.ConsumerRouter `validate:"required" default:"-"`
+	Membership MembershipResolver      `validate:"required"`
+	Policy     DeliveryPolicy          // decides which members are notified in realtime, defaults to all of them
+	Timeouts   ServiceTimeoutsOptions
+	Logger     *zerolog.Logger `validate:"required"`
+}
+
+func NewService(options *ServiceOptions) (*Service, error) {
+	if err := defaults.Set(options); err != nil {
+		return nil, fmt.Errorf("failed to set config defaults: %w", err)
+	}
+	if err := validation.Instance.Struct(options); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	if options.Policy == nil {
+		options.Policy = NewRealtimeDeliveryPolicy()
+	}
+
+	service := &Service{
+		clients: clients{
+			kafka:  options.Clients.Kafka,
+			scylla: options.Clients.Scylla,
+		},
+		topics: topics{
+			groupInbox:        options.Topics.GroupInbox,
+			userInbox:         options.Topics.UserInbox,
+			userNotifications: options.Topics.UserNotifications,
+		},
+		router:     options.Router,
+		membership: options.Membership,
+		policy:     options.Policy,
+		timeouts:   options.Timeouts,
+		logger:     options.Logger,
+	}
+	service.sinks = sinks{
+		execHistory: func(ctx context.Context, statement string, values ...any) error {
+			return service.clients.scylla.Query(statement, values...).WithContext(ctx).Exec()
+		},
+		produce: func(ctx context.Context, records ...*kgo.Record) error {
+			return service.clients.kafka.Driver.ProduceSync(ctx, records...).FirstErr()
+		},
+	}
+	return service, nil
+}
+
+func (s *Service) Start(_ context.Context) error {
+	s.router.OnRecordsFrom(s.topics.groupInbox, s.handleRecords)
+	return nil
+}
+
+func (s *Service) handleRecords(records []*kgo.Record) error {
+	for idx, record := range records {
+		var message chatv1.ChatMessage
+		if err := proto.Unmarshal(record.Value, &message); err != nil {
+			s.logger.Error().Err(err).Msgf(
+				"Failed to unmarshal chat message from Kafka record received from topic '%s' partition '%d' at offset '%d'",
+				record.Topic, record.Partition, record.Offset,
+			)
+			continue
+		}
+		if err := protovalidate.Validate(&message); err != nil {
+			s.logger.Error().Err(err).Msgf(
+				"Invalid chat message in Kafka record received from topic '%s' partition '%d' at offset '%d'",
+				record.Topic, record.Partition, record.Offset,
+			)
+			continue
+		}
+
+		err := s.fanout(&message)
+		if errors.Is(err, ErrInvalidChatMessage) {
+			s.logger.Error().Err(err).Msgf(
+				"Failed to fan out chat message from Kafka record received from topic '%s' partition '%d' at offset '%d'",
+				record.Topic, record.Partition, record.Offset,
+			)
+			continue
+		}
+		if err != nil {
+			// storage or broker is unavailable, the rest of the batch is redelivered after the partition resumes
+			return routing.NewBackpressureError(records[idx:], err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) Stop(_ context.Context) {
+	s.logger.Debug().Msg("Shutting down fanout service")
+}
+
+// fanout stores the message for history and delivers a copy to the inbox of each chat member,
+// while members routed to realtime delivery by the policy also receive a live notification.
+// Storing the message is idempotent, its row being keyed by the message id, but deliveries are produced at least
+// once: a message fanned out again after a failure may reach some of the members twice, see the @FIXME above.
+func (s *Service) fanout(message *chatv1.ChatMessage) error {
+	if err := s.storeHistory(message); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.Membership)
+	members, err := s.membership.ChatMembers(ctx, message.GetChatId())
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to resolve members of chat '%s': %w", message.GetChatId(), err)
+	}
+
+	return s.deliver(message, members)
+}
+
+func (s *Service) storeHistory(message *chatv1.ChatMessage) error {
+	chatID, err := gocql.ParseUUID(message.GetChatId())
+	if err != nil {
+		return fmt.Errorf("failed to parse chat id '%s': %w", message.GetChatId(), ErrInvalidChatMessage)
+	}
+	messageID, err := gocql.ParseUUID(message.GetMessageId())
+	if err != nil || messageID.Version() != 1 {
+		return fmt.Errorf("message id '%s' must be a time based uuid: %w", message.GetMessageId(), ErrInvalidChatMessage)
+	}
+	senderID, err := gocql.ParseUUID(message.GetSenderId())
+	if err != nil {
+		return fmt.Errorf("failed to parse sender id '%s': %w", message.GetSenderId(), ErrInvalidChatMessage)
+	}
+
+	// only set columns are inserted, so no tombstones are written for the absent ones
+	columns := []string{"chat_id", "day_bucket", "message_id", "sender_id", "message_type", "content"}
+	values := []any{
+		chatID,
+		messageID.Time().UTC().Truncate(24 * time.Hour),
+		messageID,
+		senderID,
+		messageTypeColumnValue(message.GetType()),
+		message.GetContent(),
+	}
+	if len(message.GetClientMetadata()) > 0 {
+		columns = append(columns, "client_metadata")
+		values = append(values, message.GetClientMetadata())
+	}
+	if message.GetReplyToMessageId() != "" {
+		replyToID, err := gocql.ParseUUID(message.GetReplyToMessageId())
+		if err != nil {
+			return fmt.Errorf("failed to parse reply to message id '%s': %w", message.GetReplyToMessageId(), ErrInvalidChatMessage)
+		}
+		columns = append(columns, "reply_to_message_id")
+		values = append(values, replyToID)
+	}
+
+	statement, _ := qb.Insert(gen.MessagesByChat.Name()).Columns(columns...).ToCql()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.History)
+	defer cancel()
+	if err := s.sinks.execHistory(ctx, statement, values...); err != nil {
+		return fmt.Errorf("failed to store message '%s' of chat '%s': %w", message.GetMessageId(), message.GetChatId(), err)
+	}
+	return nil
+}
+
+func (s *Service) deliver(message *chatv1.ChatMessage, members []string) error {
+	if len(members) == 0 {
+		s.logger.Warn().Msgf("Chat '%s' has no members, message '%s' is not delivered", message.GetChatId(), message.GetMessageId())
+		return nil
+	}
+
+	routes := s.policy.Routes(message, members)
+
+	records := make([]*kgo.Record, 0, 2*len(members))
+	realtime := 0
+	for idx, member := range members {
+		payload, err := proto.Marshal(&chatv1.InboxDelivery{
+			RecipientId: member,
+			Message:     message,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal delivery of message '%s' to '%s': %w", message.GetMessageId(), member, err)
+		}
+		records = append(records, &kgo.Record{
+			Topic: s.topics.userInbox,
+			Key:   []byte(member),
+			Value: payload,
+		})
+		if routes[idx] == RouteRealtime {
+			records = append(records, &kgo.Record{
+				Topic: s.topics.userNotifications,
+				Key:   []byte(member),
+				Value: payload,
+			})
+			realtime++
+		}
+	}
+	s.logger.Debug().Msgf(
+		"Delivering message '%s' of chat '%s' to %d members, %d of them in realtime",
+		message.GetMessageId(), message.GetChatId(), len(members), realtime,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.Produce)
+	defer cancel()
+	if err := s.sinks.produce(ctx, records...); err != nil {
+		return fmt.Errorf("failed to deliver message '%s' to %d members of chat '%s': %w",
+			message.GetMessageId(), len(members), message.GetChatId(), err)
+	}
+	return nil
+}
+
+// messageTypeColumnValue converts the message type to its storage representation, e.g. MESSAGE_TYPE_TEXT to 'text'.
+func messageTypeColumnValue(messageType chatv1.MessageType) string {
+	return strings.ToLower(strings.TrimPrefix(messageType.String(), "MESSAGE_TYPE_"))
+}
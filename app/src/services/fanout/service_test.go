@@ -0,0 +1,208 @@
+package fanout
+
+import (
+	"chat/src/clients/kafka/routing"
+	chatv1 "chat/src/gen/proto/chat/v1"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/rs/zerolog"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+type fakeMembership struct {
+	members map[string][]string
+	err     error
+}
+
+func (m *fakeMembership) ChatMembers(_ context.Context, chatID string) ([]string, error) {
+	return m.members[chatID], m.err
+}
+
+// firstMemberRealtime routes only the first member to realtime delivery.
+type firstMemberRealtime struct{}
+
+func (firstMemberRealtime) Routes(_ *chatv1.ChatMessage, members []string) []Route {
+	routes := make([]Route, len(members))
+	routes[0] = RouteRealtime
+	return routes
+}
+
+// fakeSinks records the history writes and the produced records, failing them with the configured errors.
+type fakeSinks struct {
+	histories  int
+	produced   []*kgo.Record
+	historyErr error
+	produceErr error
+}
+
+func newTestService(membership MembershipResolver, recorder *fakeSinks) *Service {
+	logger := zerolog.Nop()
+	return &Service{
+		sinks: sinks{
+			execHistory: func(context.Context, string, ...any) error {
+				recorder.histories++
+				return recorder.historyErr
+			},
+			produce: func(_ context.Context, records ...*kgo.Record) error {
+				if recorder.produceErr != nil {
+					return recorder.produceErr
+				}
+				recorder.produced = append(recorder.produced, records...)
+				return nil
+			},
+		},
+		topics:     topics{groupInbox: "group-inbox", userInbox: "user-inbox", userNotifications: "user-notifications"},
+		membership: membership,
+		policy:     firstMemberRealtime{},
+		timeouts:   ServiceTimeoutsOptions{Membership: time.Second, History: time.Second, Produce: time.Second},
+		logger:     &logger,
+	}
+}
+
+func newTestMessage(chatID string) *chatv1.ChatMessage {
+	return &chatv1.ChatMessage{
+		ChatId:    chatID,
+		MessageId: gocql.TimeUUID().String(),
+		SenderId:  gocql.TimeUUID().String(),
+		Type:      chatv1.MessageType_MESSAGE_TYPE_TEXT,
+		Content:   "hello",
+		SentAt:    timestamppb.Now(),
+	}
+}
+
+func newTestRecord(t *testing.T, message *chatv1.ChatMessage, offset int64) *kgo.Record {
+	t.Helper()
+
+	payload, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatalf("failed to marshal chat message: %v", err)
+	}
+	return &kgo.Record{Topic: "group-inbox", Offset: offset, Value: payload}
+}
+
+type delivery struct {
+	topic     string
+	recipient string
+}
+
+func deliveries(t *testing.T, records []*kgo.Record) []delivery {
+	t.Helper()
+
+	produced := make([]delivery, 0, len(records))
+	for _, record := range records {
+		var inbox chatv1.InboxDelivery
+		if err := proto.Unmarshal(record.Value, &inbox); err != nil {
+			t.Fatalf("produced record isn't an inbox delivery: %v", err)
+		}
+		if string(record.Key) != inbox.GetRecipientId() {
+			t.Fatalf("delivery to '%s' is keyed by '%s', want the recipient id", inbox.GetRecipientId(), record.Key)
+		}
+		produced = append(produced, delivery{topic: record.Topic, recipient: inbox.GetRecipientId()})
+	}
+	return produced
+}
+
+func TestFanoutDeliversToInboxOfEachMember(t *testing.T) {
+	chatID := gocql.TimeUUID().String()
+	recorder := &fakeSinks{}
+	service := newTestService(&fakeMembership{members: map[string][]string{chatID: {"alice", "bob"}}}, recorder)
+
+	if err := service.fanout(newTestMessage(chatID)); err != nil {
+		t.Fatalf("fanout() error = %v", err)
+	}
+
+	if recorder.histories != 1 {
+		t.Errorf("history writes = %d, want 1", recorder.histories)
+	}
+	want := []delivery{
+		{topic: "user-inbox", recipient: "alice"},
+		{topic: "user-notifications", recipient: "alice"},
+		{topic: "user-inbox", recipient: "bob"},
+	}
+	if got := deliveries(t, recorder.produced); !slices.Equal(got, want) {
+		t.Fatalf("deliveries = %+v, want %+v", got, want)
+	}
+}
+
+func TestFanoutWithoutMembersProducesNothing(t *testing.T) {
+	recorder := &fakeSinks{}
+	service := newTestService(&fakeMembership{}, recorder)
+
+	if err := service.fanout(newTestMessage(gocql.TimeUUID().String())); err != nil {
+		t.Fatalf("fanout() error = %v", err)
+	}
+	if len(recorder.produced) != 0 {
+		t.Fatalf("%d records produced for a chat without members", len(recorder.produced))
+	}
+}
+
+func TestFanoutStopsWhenHistoryOrMembershipFails(t *testing.T) {
+	chatID := gocql.TimeUUID().String()
+	members := map[string][]string{chatID: {"alice"}}
+	tests := []struct {
+		name       string
+		membership *fakeMembership
+		recorder   *fakeSinks
+	}{
+		{name: "history", membership: &fakeMembership{members: members}, recorder: &fakeSinks{historyErr: errUnavailable}},
+		{name: "membership", membership: &fakeMembership{err: errUnavailable}, recorder: &fakeSinks{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTestService(tt.membership, tt.recorder).fanout(newTestMessage(chatID))
+			if !errors.Is(err, errUnavailable) {
+				t.Fatalf("fanout() error = %v, want %v", err, errUnavailable)
+			}
+			if len(tt.recorder.produced) != 0 {
+				t.Fatalf("%d records produced after the %s failure", len(tt.recorder.produced), tt.name)
+			}
+		})
+	}
+}
+
+func TestFanoutRejectsMessageIDNotTimeBased(t *testing.T) {
+	recorder := &fakeSinks{}
+	service := newTestService(&fakeMembership{}, recorder)
+	message := newTestMessage(gocql.TimeUUID().String())
+	randomID, _ := gocql.RandomUUID()
+	message.MessageId = randomID.String()
+
+	if err := service.fanout(message); !errors.Is(err, ErrInvalidChatMessage) {
+		t.Fatalf("fanout() error = %v, want %v", err, ErrInvalidChatMessage)
+	}
+	if recorder.histories != 0 {
+		t.Fatal("message with random id was stored")
+	}
+}
+
+func TestHandleRecordsAppliesBackpressureWhenProduceFails(t *testing.T) {
+	chatID := gocql.TimeUUID().String()
+	recorder := &fakeSinks{produceErr: errUnavailable}
+	service := newTestService(&fakeMembership{members: map[string][]string{chatID: {"alice"}}}, recorder)
+	invalid := newTestMessage(chatID)
+	invalid.Content = ""
+	records := []*kgo.Record{
+		newTestRecord(t, invalid, 1), // skipped, redelivering it wouldn't fix it
+		newTestRecord(t, newTestMessage(chatID), 2),
+		newTestRecord(t, newTestMessage(chatID), 3),
+	}
+
+	err := service.handleRecords(records)
+
+	var backpressure *routing.BackpressureError
+	if !errors.As(err, &backpressure) || !errors.Is(err, errUnavailable) {
+		t.Fatalf("handleRecords() error = %v, want backpressure caused by %v", err, errUnavailable)
+	}
+	if len(backpressure.Unprocessed) != 2 || backpressure.Unprocessed[0].Offset != 2 {
+		t.Fatalf("unprocessed records start at offset %d, want the 2 records from offset 2", backpressure.Unprocessed[0].Offset)
+	}
+}
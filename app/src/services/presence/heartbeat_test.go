@@ -0,0 +1,279 @@
+package presence
+
+import (
+	"chat/src/clients/redis"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	redis2 "github.com/redis/go-redis/v9"
+)
+
+// expirePipeliner records the EXPIREs queued on the pipeline, by key.
+type expirePipeliner struct {
+	redis2.Pipeliner
+	expires map[string]time.Duration
+}
+
+func (p expirePipeliner) Expire(ctx context.Context, key string, expiration time.Duration) *redis2.BoolCmd {
+	p.expires[key] = expiration
+	return redis2.NewBoolCmd(ctx, "expire", key, expiration)
+}
+
+// expireDriver records the EXPIREs of each pipeline.
+type expireDriver struct {
+	redis.Driver
+	mutex     sync.Mutex
+	pipelines []map[string]time.Duration
+}
+
+func (d *expireDriver) Pipelined(_ context.Context, fn func(redis2.Pipeliner) error) ([]redis2.Cmder, error) {
+	pipe := expirePipeliner{expires: make(map[string]time.Duration)}
+	err := fn(pipe)
+
+	d.mutex.Lock()
+	d.pipelines = append(d.pipelines, pipe.expires)
+	d.mutex.Unlock()
+	return nil, err
+}
+
+func startBatchedHeartbeats(service *Service, users, sessionsPerUser int) {
+	for user := range users {
+		for session := range sessionsPerUser {
+			service.heartbeats.start("user-"+strconv.Itoa(user), "s"+strconv.Itoa(session), service.runHeartbeat)
+		}
+	}
+}
+
+func TestBatchedHeartbeatRefreshesAllSessions(t *testing.T) {
+	driver := &expireDriver{}
+	service, _ := newTestService(t, driver, func(options *ServiceOptions) { options.BatchedHeartbeats = true })
+	startBatchedHeartbeats(service, 600, 2)
+	service.heartbeats.stop("user-0", "s1")
+
+	service.heartbeatBatches(context.Background(), service.heartbeats.snapshot())
+
+	if len(driver.pipelines) != 3 {
+		t.Fatalf("heartbeat issued %d pipelines, want %d for %d sessions", len(driver.pipelines), 3, 1199)
+	}
+	expires := make(map[string]time.Duration)
+	for idx, pipeline := range driver.pipelines {
+		sessions := 0
+		for key, ttl := range pipeline {
+			expires[key] = ttl
+			if ttl == sessionTTL {
+				sessions++
+			}
+		}
+		if idx < 2 && sessions != heartbeatBatchSize {
+			t.Errorf("pipeline %d refreshed %d sessions, want %d", idx, sessions, heartbeatBatchSize)
+		}
+	}
+	for user := range 600 {
+		userID := "user-" + strconv.Itoa(user)
+		if ttl := expires[fmt.Sprintf(sessionListKeyFormat, userID)]; ttl != sessionListTTL {
+			t.Fatalf("session list of %s expires in %v, want %v", userID, ttl, sessionListTTL)
+		}
+		for session := range 2 {
+			key := fmt.Sprintf(sessionKeyFormat, userID, "s"+strconv.Itoa(session))
+			ttl, refreshed := expires[key]
+			if user == 0 && session == 1 {
+				if refreshed {
+					t.Fatalf("stopped session %s was refreshed", key)
+				}
+				continue
+			}
+			if ttl != sessionTTL {
+				t.Fatalf("session %s expires in %v, want %v", key, ttl, sessionTTL)
+			}
+		}
+	}
+}
+
+func TestBatchedHeartbeatRunsNoGoroutinePerSession(t *testing.T) {
+	service, _ := newTestService(t, &expireDriver{}, func(options *ServiceOptions) { options.BatchedHeartbeats = true })
+	startBatchedHeartbeats(service, 10, 1)
+
+	if running := len(service.heartbeats.cancelations); running != 0 {
+		t.Fatalf("%d per-session heartbeats are running in batched mode, want none", running)
+	}
+	if tracked := len(service.heartbeats.snapshot()); tracked != 10 {
+		t.Fatalf("%d sessions are tracked, want %d", tracked, 10)
+	}
+	service.heartbeats.stopAll()
+	if tracked := len(service.heartbeats.snapshot()); tracked != 0 {
+		t.Fatalf("%d sessions are tracked after stopAll(), want none", tracked)
+	}
+}
+
+func TestBatchedHeartbeatRestoresSessionTTLs(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.BatchedHeartbeats = true })
+	ctx := context.Background()
+
+	for _, userID := range []string{"alice", "bob"} {
+		if _, err := service.CreateSession(ctx, userID, "s1", newTestSession(1)); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", userID, err)
+		}
+	}
+	// the TTLs are about to run out, as if no heartbeat ran for a while
+	keys := []string{
+		fmt.Sprintf(sessionKeyFormat, "alice", "s1"), fmt.Sprintf(sessionListKeyFormat, "alice"),
+		fmt.Sprintf(sessionKeyFormat, "bob", "s1"), fmt.Sprintf(sessionListKeyFormat, "bob"),
+	}
+	if _, err := service.redis.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Expire(ctx, key, 5*time.Second)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to shorten the TTLs: %v", err)
+	}
+
+	service.heartbeatBatches(ctx, service.heartbeats.snapshot())
+
+	var ttls []*redis2.DurationCmd
+	if _, err := service.redis.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		for _, key := range keys {
+			ttls = append(ttls, pipe.TTL(ctx, key))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to read the TTLs: %v", err)
+	}
+	for idx, ttl := range ttls {
+		want := sessionTTL
+		if idx%2 == 1 {
+			want = sessionListTTL
+		}
+		if got := ttl.Val(); got < want-2*time.Second || got > want {
+			t.Errorf("TTL of %s = %v, want %v as the per-session heartbeat sets", keys[idx], got, want)
+		}
+	}
+}
+
+// BenchmarkHeartbeat compares refreshing the sessions of a replica one pipeline per session to the batched mode.
+func BenchmarkHeartbeat(b *testing.B) {
+	const sessions = 2000
+
+	service := newRedisTestService(b, func(options *ServiceOptions) { options.BatchedHeartbeats = true })
+	startBatchedHeartbeats(service, sessions, 1)
+	snapshot := service.heartbeats.snapshot()
+	ctx := context.Background()
+
+	b.Run("per session", func(b *testing.B) {
+		for b.Loop() {
+			for _, session := range snapshot {
+				if err := service.heartbeat(ctx, session.userID, session.sessionID); err != nil {
+					b.Fatalf("heartbeat() error = %v", err)
+				}
+			}
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		for b.Loop() {
+			service.heartbeatBatches(ctx, snapshot)
+		}
+	})
+}
+
+func TestActiveHeartbeatsMatchCreatedAndStoppedSessions(t *testing.T) {
+	for _, batched := range []bool{false, true} {
+		t.Run("batched="+strconv.FormatBool(batched), func(t *testing.T) {
+			service, _ := newTestService(t, &expireDriver{}, func(options *ServiceOptions) { options.BatchedHeartbeats = batched })
+			stopped := make(chan string, 3)
+			heartbeater := func(ctx context.Context, userID, sessionID string) {
+				<-ctx.Done()
+				stopped <- userID + ":" + sessionID
+			}
+
+			service.heartbeats.start("bob", "s1", heartbeater)
+			service.heartbeats.start("alice", "s2", heartbeater)
+			service.heartbeats.start("alice", "s1", heartbeater)
+			service.heartbeats.start("alice", "s1", heartbeater) // already running
+			if got, want := service.ActiveHeartbeats(), []string{"alice:s1", "alice:s2", "bob:s1"}; !slices.Equal(got, want) {
+				t.Fatalf("ActiveHeartbeats() = %v, want %v", got, want)
+			}
+
+			service.heartbeats.stop("alice", "s2")
+			if got, want := service.ActiveHeartbeats(), []string{"alice:s1", "bob:s1"}; !slices.Equal(got, want) {
+				t.Fatalf("ActiveHeartbeats() = %v once alice:s2 stopped, want %v", got, want)
+			}
+			if !batched {
+				if key := <-stopped; key != "alice:s2" {
+					t.Fatalf("stopped heartbeat %s, want alice:s2", key)
+				}
+			}
+
+			service.heartbeats.stopAll()
+			if got := service.ActiveHeartbeats(); len(got) != 0 {
+				t.Fatalf("ActiveHeartbeats() = %v once all stopped, want none", got)
+			}
+		})
+	}
+}
+
+func TestCancelHeartbeat(t *testing.T) {
+	for _, batched := range []bool{false, true} {
+		t.Run("batched="+strconv.FormatBool(batched), func(t *testing.T) {
+			service, _ := newTestService(t, &expireDriver{}, func(options *ServiceOptions) { options.BatchedHeartbeats = batched })
+			canceled := make(chan struct{})
+			service.heartbeats.start("alice", "s1", func(ctx context.Context, _, _ string) {
+				<-ctx.Done()
+				close(canceled)
+			})
+			service.heartbeats.start("alice", "s2", func(ctx context.Context, _, _ string) { <-ctx.Done() })
+
+			if !service.CancelHeartbeat("alice", "s1") {
+				t.Fatal("CancelHeartbeat(alice, s1) = false, want the running heartbeat to be canceled")
+			}
+			if service.CancelHeartbeat("alice", "s1") {
+				t.Fatal("CancelHeartbeat(alice, s1) = true once canceled, want false")
+			}
+			if service.CancelHeartbeat("bob", "s1") {
+				t.Fatal("CancelHeartbeat(bob, s1) = true for an unknown session, want false")
+			}
+			if got, want := service.ActiveHeartbeats(), []string{"alice:s2"}; !slices.Equal(got, want) {
+				t.Fatalf("ActiveHeartbeats() = %v, want %v", got, want)
+			}
+			if !batched {
+				select {
+				case <-canceled:
+				case <-time.After(2 * time.Second):
+					t.Fatal("the heartbeat goroutine of the canceled session didn't stop")
+				}
+			}
+		})
+	}
+}
+
+func TestActiveHeartbeatsFollowSessionLifecycle(t *testing.T) {
+	service := newRedisTestService(t)
+	ctx := context.Background()
+
+	for _, sessionID := range []string{"s1", "s2"} {
+		if _, err := service.CreateSession(ctx, "alice", sessionID, newTestSession(0)); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", sessionID, err)
+		}
+	}
+	if err := service.DeleteSession(ctx, "alice", "s1"); err != nil {
+		t.Fatalf("DeleteSession(s1) error = %v", err)
+	}
+	if got, want := service.ActiveHeartbeats(), []string{"alice:s2"}; !slices.Equal(got, want) {
+		t.Fatalf("ActiveHeartbeats() = %v, want %v", got, want)
+	}
+
+	// a canceled heartbeat leaves the session to expire
+	if !service.CancelHeartbeat("alice", "s2") {
+		t.Fatal("CancelHeartbeat(alice, s2) = false, want true")
+	}
+	if session, err := service.GetSession(ctx, "alice", "s2"); err != nil || session == nil {
+		t.Fatalf("GetSession(s2) = (%v, %v), want the session to be kept", session, err)
+	}
+	if got := service.ActiveHeartbeats(); len(got) != 0 {
+		t.Fatalf("ActiveHeartbeats() = %v, want none", got)
+	}
+}
@@ -0,0 +1,202 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+)
+
+const idleSweepInterval = 30 * time.Second
+
+// activityTracker keeps the last activity of the sessions hosted by the replica, so the idle sweeper only goes to
+// Redis for the users having a session which became idle since the previous sweep.
+type activityTracker struct {
+	mutex         sync.Mutex
+	idleThreshold time.Duration // #readonly, 0 when auto-away is disabled
+	lastActivity  map[heartbeatSession]trackedActivity
+}
+
+type trackedActivity struct {
+	at   time.Time
+	idle bool // already reported by the sweeper, until the session is touched again
+}
+
+// touchSessionScript refreshes the last activity of the session and clears the away marker of the user, it doesn't
+// recreate the session hash when the session expired or was evicted meanwhile.
+//
+//	-- KEYS[1] = session key
+//	-- KEYS[2] = away key
+//	-- ARGV[1] = last activity unix millis
+//	-- ARGV[2] = session expiration in seconds
+//	-- returns {touched (1 or 0), away marker cleared (1 or 0)}
+const touchSessionScript = `
+if redis.call("EXPIRE", KEYS[1], tonumber(ARGV[2])) == 0 then
+    return {0, 0}
+end
+redis.call("HSET", KEYS[1], "last_activity", ARGV[1])
+return {1, redis.call("DEL", KEYS[2])}
+`
+
+// markAwayScript sets the away marker of the user when all of its sessions, hosted by any replica, are idle.
+// Sessions without a last activity are considered active.
+//
+//	-- KEYS[1] = session list key
+//	-- KEYS[2] = away key
+//	-- ARGV[1] = session key prefix, session id is appended to it
+//	-- ARGV[2] = idle cutoff unix millis, sessions active after it aren't idle
+//	-- ARGV[3] = away marker expiration in seconds
+//	-- returns 1 when the marker was set, 0 otherwise
+const markAwayScript = `
+local sessions = redis.call("SMEMBERS", KEYS[1])
+if #sessions == 0 then
+    return 0
+end
+local cutoff = tonumber(ARGV[2])
+for _, id in ipairs(sessions) do
+    local last_activity = redis.call("HGET", ARGV[1] .. id, "last_activity")
+    if last_activity and (tonumber(last_activity) or 0) > cutoff then
+        return 0
+    end
+    if not last_activity and redis.call("EXISTS", ARGV[1] .. id) == 1 then
+        return 0
+    end
+end
+if redis.call("SET", KEYS[2], ARGV[2], "NX", "EX", tonumber(ARGV[3])) then
+    return 1
+end
+return 0
+`
+
+func (s *Service) loadActivityScripts(ctx context.Context) error {
+	touchSha, err := s.redis.Driver.ScriptLoad(ctx, touchSessionScript).Result()
+	if err != nil {
+		return fmt.Errorf("can't load Lua script responsible for session touch: %w", err)
+	}
+	markAwaySha, err := s.redis.Driver.ScriptLoad(ctx, markAwayScript).Result()
+	if err != nil {
+		return fmt.Errorf("can't load Lua script responsible for marking users away: %w", err)
+	}
+	s.evalShas.touchSession = touchSha
+	s.evalShas.markAway = markAwaySha
+	return nil
+}
+
+// Touch records activity on a session hosted by this replica, it must be called on user actions (i.e. sending a
+// message), not on heartbeats. A user reported as away is reported online again.
+func (s *Service) Touch(ctx context.Context, userID, sessionID string) error {
+	now := time.Now()
+	if !s.activity.touch(userID, sessionID, now) {
+		return fmt.Errorf("touch session '%s' of user '%s' failed: %w", sessionID, userID, ErrUnknownSession)
+	}
+
+	result, err := s.redis.Driver.EvalSha(
+		ctx,
+		s.evalShas.touchSession,
+		[]string{fmt.Sprintf(sessionKeyFormat, userID, sessionID), fmt.Sprintf(awayKeyFormat, userID)},
+		now.UnixMilli(),
+		sessionTTL.Seconds(),
+	).Int64Slice()
+	if err != nil {
+		return fmt.Errorf("touch session '%s' of user '%s' failed: %w", sessionID, userID, err)
+	}
+	if result[0] == 0 {
+		s.activity.untrack(userID, sessionID)
+		return fmt.Errorf("touch session '%s' of user '%s' failed, session expired: %w", sessionID, userID, ErrUnknownSession)
+	}
+	if result[1] == 1 {
+		s.statusCache.Set(userID, StatusOnline, ttlcache.DefaultTTL)
+		s.publishPresenceUpdate(userID, sessionID, StatusOnline)
+	}
+	return nil
+}
+
+func (s *Service) runIdleSweeper(ctx context.Context) {
+	ticker := time.NewTicker(idleSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.sweepIdleSessions(ctx, time.Now())
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Service) sweepIdleSessions(ctx context.Context, now time.Time) {
+	cutoff := now.Add(-s.activity.idleThreshold)
+	for _, session := range s.activity.becameIdle(cutoff) {
+		marked, err := s.redis.Driver.EvalSha(
+			ctx,
+			s.evalShas.markAway,
+			[]string{fmt.Sprintf(sessionListKeyFormat, session.userID), fmt.Sprintf(awayKeyFormat, session.userID)},
+			fmt.Sprintf(sessionKeyFormat, session.userID, ""),
+			cutoff.UnixMilli(),
+			lastSeenTTL.Seconds(),
+		).Int64()
+		if err != nil {
+			s.logger.Warn().Err(err).Msgf("failed to check whether user '%s' is away", session.userID)
+			continue
+		}
+		if marked == 1 {
+			s.statusCache.Set(session.userID, StatusAway, ttlcache.DefaultTTL)
+			s.publishPresenceUpdate(session.userID, session.sessionID, StatusAway)
+		}
+	}
+}
+
+func (a *activityTracker) track(userID, sessionID string, at time.Time) {
+	a.mutex.Lock()
+	a.lastActivity[heartbeatSession{userID: userID, sessionID: sessionID}] = trackedActivity{at: at}
+	a.mutex.Unlock()
+}
+
+func (a *activityTracker) touch(userID, sessionID string, at time.Time) bool {
+	session := heartbeatSession{userID: userID, sessionID: sessionID}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, tracked := a.lastActivity[session]; !tracked {
+		return false
+	}
+	a.lastActivity[session] = trackedActivity{at: at}
+	return true
+}
+
+func (a *activityTracker) untrack(userID, sessionID string) {
+	a.mutex.Lock()
+	delete(a.lastActivity, heartbeatSession{userID: userID, sessionID: sessionID})
+	a.mutex.Unlock()
+}
+
+func (a *activityTracker) untrackAll() {
+	a.mutex.Lock()
+	a.lastActivity = make(map[heartbeatSession]trackedActivity)
+	a.mutex.Unlock()
+}
+
+// becameIdle returns the sessions without activity after the cutoff which weren't reported by a previous sweep,
+// one per user, and marks them as reported.
+func (a *activityTracker) becameIdle(cutoff time.Time) []heartbeatSession {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	users := make(map[string]struct{})
+	idle := make([]heartbeatSession, 0)
+	for session, activity := range a.lastActivity {
+		if activity.idle || activity.at.After(cutoff) {
+			continue
+		}
+		a.lastActivity[session] = trackedActivity{at: activity.at, idle: true}
+		if _, seen := users[session.userID]; !seen {
+			users[session.userID] = struct{}{}
+			idle = append(idle, session)
+		}
+	}
+	return idle
+}
@@ -0,0 +1,184 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	redis2 "github.com/redis/go-redis/v9"
+)
+
+func newTestActivityTracker() *activityTracker {
+	return &activityTracker{idleThreshold: time.Minute, lastActivity: make(map[heartbeatSession]trackedActivity)}
+}
+
+func idleUsers(sessions []heartbeatSession) []string {
+	users := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		users = append(users, session.userID)
+	}
+	slices.Sort(users)
+	return users
+}
+
+func TestActivityTrackerReportsIdleUsersOnce(t *testing.T) {
+	tracker := newTestActivityTracker()
+	start := time.Unix(1_700_000_000, 0)
+	tracker.track("alice", "s1", start)
+	tracker.track("alice", "s2", start)
+	tracker.track("bob", "s1", start)
+	tracker.track("carol", "s1", start.Add(2*time.Minute))
+
+	if got := idleUsers(tracker.becameIdle(start.Add(time.Minute))); !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Fatalf("becameIdle() = %v, want one session of alice and bob", got)
+	}
+	if got := tracker.becameIdle(start.Add(time.Minute)); len(got) != 0 {
+		t.Fatalf("becameIdle() = %v, want the idle sessions to be reported once", idleUsers(got))
+	}
+
+	if !tracker.touch("bob", "s1", start.Add(90*time.Second)) {
+		t.Fatal("touch() = false, want the tracked session to be touched")
+	}
+	if got := tracker.becameIdle(start.Add(time.Minute)); len(got) != 0 {
+		t.Fatalf("becameIdle() = %v, want the touched session to be active", idleUsers(got))
+	}
+	if got := idleUsers(tracker.becameIdle(start.Add(3 * time.Minute))); !slices.Equal(got, []string{"bob", "carol"}) {
+		t.Fatalf("becameIdle() = %v, want the touched session to be reported again once idle", got)
+	}
+}
+
+func TestActivityTrackerForgetsUntrackedSessions(t *testing.T) {
+	tracker := newTestActivityTracker()
+	start := time.Unix(1_700_000_000, 0)
+	tracker.track("alice", "s1", start)
+	tracker.track("bob", "s1", start)
+
+	tracker.untrack("alice", "s1")
+	if tracker.touch("alice", "s1", start) {
+		t.Fatal("touch() = true, want an untracked session not to be touched")
+	}
+	if got := idleUsers(tracker.becameIdle(start.Add(time.Minute))); !slices.Equal(got, []string{"bob"}) {
+		t.Fatalf("becameIdle() = %v, want only the tracked session", got)
+	}
+
+	tracker.untrackAll()
+	if tracker.touch("bob", "s1", start) {
+		t.Fatal("touch() = true, want no session to be tracked once all are untracked")
+	}
+}
+
+func TestTouchOfSessionHostedElsewhereFails(t *testing.T) {
+	service, _ := newTestService(t, nil) // Redis isn't reached
+
+	if err := service.Touch(context.Background(), "alice", "s1"); !errors.Is(err, ErrUnknownSession) {
+		t.Fatalf("Touch() error = %v, want ErrUnknownSession", err)
+	}
+}
+
+func TestStatusOfAwayMarker(t *testing.T) {
+	tests := []struct {
+		name        string
+		hasSessions bool
+		away        bool
+		want        Status
+	}{
+		{name: "no sessions", want: StatusOffline},
+		{name: "marker outliving the sessions", away: true, want: StatusOffline},
+		{name: "active sessions", hasSessions: true, want: StatusOnline},
+		{name: "idle sessions", hasSessions: true, away: true, want: StatusAway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusOf(tt.hasSessions, tt.away); got != tt.want {
+				t.Fatalf("statusOf(%t, %t) = %v, want %v", tt.hasSessions, tt.away, got, tt.want)
+			}
+		})
+	}
+}
+
+// reloadedStatus returns the status of the user as loaded from Redis, ignoring the cached one.
+func reloadedStatus(t *testing.T, service *Service, userID string) Status {
+	t.Helper()
+
+	service.statusCache.Delete(userID)
+	status, err := service.Status(userID)
+	if err != nil {
+		t.Fatalf("Status(%s) error = %v", userID, err)
+	}
+	return status
+}
+
+func TestIdleUserIsAwayUntilTouched(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.IdleThreshold = time.Minute })
+	ctx := context.Background()
+	for _, sessionID := range []string{"s1", "s2"} {
+		if _, err := service.CreateSession(ctx, "alice", sessionID, newTestSession(time.Now().UnixMilli())); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", sessionID, err)
+		}
+	}
+
+	service.sweepIdleSessions(ctx, time.Now().Add(2*time.Minute))
+	if status, _ := service.Status("alice"); status != StatusAway {
+		t.Fatalf("cached Status() = %v, want away once all sessions are idle", status)
+	}
+	if status := reloadedStatus(t, service, "alice"); status != StatusAway {
+		t.Fatalf("Status() = %v, want away once all sessions are idle", status)
+	}
+
+	if err := service.Touch(ctx, "alice", "s2"); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	if status, _ := service.Status("alice"); status != StatusOnline {
+		t.Fatalf("cached Status() = %v, want online once touched", status)
+	}
+	if status := reloadedStatus(t, service, "alice"); status != StatusOnline {
+		t.Fatalf("Status() = %v, want online once touched", status)
+	}
+}
+
+func TestUserWithActiveSessionElsewhereIsNotAway(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.IdleThreshold = time.Minute })
+	ctx := context.Background()
+	for _, sessionID := range []string{"s1", "s2"} {
+		if _, err := service.CreateSession(ctx, "alice", sessionID, newTestSession(time.Now().UnixMilli())); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", sessionID, err)
+		}
+	}
+	now := time.Now().Add(2 * time.Minute)
+	// s2 is touched on another replica, this one only tracks the activity of its own touches
+	activeAt := strconv.FormatInt(now.UnixMilli(), 10)
+	if _, err := service.redis.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		return pipe.HSet(ctx, fmt.Sprintf(sessionKeyFormat, "alice", "s2"), "last_activity", activeAt).Err()
+	}); err != nil {
+		t.Fatalf("HSet() error = %v", err)
+	}
+
+	service.sweepIdleSessions(ctx, now)
+	if status := reloadedStatus(t, service, "alice"); status != StatusOnline {
+		t.Fatalf("Status() = %v, want online while a session is active", status)
+	}
+}
+
+func TestTouchOfExpiredSessionUntracksIt(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.IdleThreshold = time.Minute })
+	ctx := context.Background()
+	if _, err := service.CreateSession(ctx, "alice", "s1", newTestSession(time.Now().UnixMilli())); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if _, err := service.redis.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		return pipe.Del(ctx, fmt.Sprintf(sessionKeyFormat, "alice", "s1")).Err()
+	}); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+
+	if err := service.Touch(ctx, "alice", "s1"); !errors.Is(err, ErrUnknownSession) {
+		t.Fatalf("Touch() error = %v, want ErrUnknownSession once the session expired", err)
+	}
+	if service.activity.touch("alice", "s1", time.Now()) {
+		t.Fatal("expired session is still tracked")
+	}
+}
@@ -0,0 +1,162 @@
+package presence
+
+import (
+	"chat/src/util"
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	publishRetryCapacity  = 1_000 // pending updates, the oldest one is dropped when full
+	publishRetryBaseDelay = 100 * time.Millisecond
+	publishRetryMaxDelay  = 10 * time.Second
+)
+
+// publishRetries buffers the presence updates which failed to be published, so a transient NATS failure doesn't
+// lose a transition. Only the latest update of a user is kept, an older one being superseded by it, which keeps
+// updates of the same user in order. Updates are re-published with backoff and right after a NATS reconnect.
+type publishRetries struct {
+	mutex   sync.Mutex
+	pending []pendingPublish // oldest first
+	pushed  chan struct{}
+	rewind  chan struct{} // NATS reconnected, pending updates are retried without waiting for the backoff
+	dropped atomic.Uint64
+	// publish publishes with NATS outside of tests
+	publish func(message []byte) error
+}
+
+type pendingPublish struct {
+	userID  string
+	message []byte
+}
+
+// push buffers the update of the user, replacing the pending one of the same user. It never blocks.
+func (r *publishRetries) push(userID string, message []byte) {
+	r.mutex.Lock()
+	r.pending = slices.DeleteFunc(r.pending, func(p pendingPublish) bool { return p.userID == userID })
+	if len(r.pending) >= publishRetryCapacity {
+		r.pending = slices.Delete(r.pending, 0, 1)
+		r.dropped.Add(1)
+	}
+	r.pending = append(r.pending, pendingPublish{userID: userID, message: message})
+	r.mutex.Unlock()
+
+	notify(r.pushed)
+}
+
+// supersede discards the pending update of the user, a newer one was published meanwhile.
+func (r *publishRetries) supersede(userID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.pending) > 0 {
+		r.pending = slices.DeleteFunc(r.pending, func(p pendingPublish) bool { return p.userID == userID })
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// take removes the oldest pending update.
+func (r *publishRetries) take() (pendingPublish, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.pending) == 0 {
+		return pendingPublish{}, false
+	}
+	pending := r.pending[0]
+	r.pending = slices.Delete(r.pending, 0, 1)
+	return pending, true
+}
+
+// restore puts back an update which failed again, unless a newer update of the user was pushed meanwhile.
+func (r *publishRetries) restore(pending pendingPublish) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if slices.ContainsFunc(r.pending, func(p pendingPublish) bool { return p.userID == pending.userID }) {
+		return
+	}
+	if len(r.pending) >= publishRetryCapacity {
+		r.dropped.Add(1)
+		return
+	}
+	r.pending = slices.Insert(r.pending, 0, pending)
+}
+
+func (r *publishRetries) size() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.pending)
+}
+
+func (s *Service) publish(message []byte) error {
+	if s.jetStream.driver != nil {
+		_, err := s.jetStream.driver.PublishAsync(s.subject, message)
+		return err
+	}
+	return s.nats.Driver.Publish(s.subject, message)
+}
+
+// runPublishRetries re-publishes the pending updates once one is pushed, backing off while publishing keeps failing.
+// A NATS reconnect cuts the backoff short.
+func (s *Service) runPublishRetries(ctx context.Context) {
+	attempt := 0
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-s.publishRetries.pushed:
+			if retry != nil {
+				continue // already backing off
+			}
+		case <-s.publishRetries.rewind:
+			attempt = 0
+		case <-retry:
+		case <-ctx.Done():
+			if pending := s.publishRetries.size(); pending > 0 {
+				s.logger.Warn().Msgf("presence service stopped with %d presence updates pending publish retry", pending)
+			}
+			return
+		}
+
+		if s.flushPublishRetries() {
+			attempt, retry = 0, nil
+			continue
+		}
+		retry = time.After(util.ExponentialBackoff(attempt, publishRetryBaseDelay, publishRetryMaxDelay))
+		attempt++
+	}
+}
+
+// flushPublishRetries publishes the pending updates oldest first, stopping at the first failure. It returns false
+// when updates are left pending.
+func (s *Service) flushPublishRetries() bool {
+	flushed := 0
+	for {
+		pending, ok := s.publishRetries.take()
+		if !ok {
+			break
+		}
+		if err := s.publishRetries.publish(pending.message); err != nil {
+			s.publishRetries.restore(pending)
+			s.logger.Debug().Err(err).Msgf("presence update retry failed, %d updates pending", s.publishRetries.size())
+			return false
+		}
+		flushed++
+	}
+	if flushed > 0 {
+		s.logger.Info().Msgf("%d presence updates were published on retry", flushed)
+	}
+	return true
+}
+
+// PublishRetryStats returns the number of presence updates pending publish retry and the number of the ones
+// dropped because the retry buffer was full.
+func (s *Service) PublishRetryStats() (pending int, dropped uint64) {
+	return s.publishRetries.size(), s.publishRetries.dropped.Load()
+}
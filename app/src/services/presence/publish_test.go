@@ -0,0 +1,156 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePublisher fails the publishes while down, recording the published messages and the attempts.
+type fakePublisher struct {
+	mutex     sync.Mutex
+	down      bool
+	attempts  int
+	published []string
+	changed   chan struct{}
+}
+
+func newFakePublisher(service *Service) *fakePublisher {
+	publisher := &fakePublisher{down: true, changed: make(chan struct{}, 1)}
+	service.publishRetries.publish = publisher.publish
+	return publisher
+}
+
+func (p *fakePublisher) publish(message []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	defer notify(p.changed)
+
+	p.attempts++
+	if p.down {
+		return errors.New("nats: connection closed")
+	}
+	p.published = append(p.published, string(message))
+	return nil
+}
+
+func (p *fakePublisher) setDown(down bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.down = down
+}
+
+func (p *fakePublisher) state() (attempts int, published []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.attempts, slices.Clone(p.published)
+}
+
+// waitFor waits for the publisher to reach the condition, failing the test on timeout.
+func (p *fakePublisher) waitFor(t *testing.T, timeout time.Duration, condition func(attempts int, published []string) bool) {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		if attempts, published := p.state(); condition(attempts, published) {
+			return
+		}
+		select {
+		case <-p.changed:
+		case <-deadline:
+			attempts, published := p.state()
+			t.Fatalf("publisher made %d attempts publishing %v, condition not reached", attempts, published)
+		}
+	}
+}
+
+// startPublishRetries runs the publish retries of the service until cleanup.
+func startPublishRetries(t *testing.T, service *Service) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.runPublishRetries(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestFailedPublishesAreResentOnReconnect(t *testing.T) {
+	service, _ := newTestService(t, nil) // Redis isn't reached
+	publisher := newFakePublisher(service)
+	startPublishRetries(t, service)
+
+	service.publishPresenceUpdate("alice", "s1", StatusOnline)
+	service.publishPresenceUpdate("bob", "s2", StatusOnline)
+	service.publishPresenceUpdate("alice", "s1", StatusOffline) // supersedes the pending update of alice
+	if pending, _ := service.PublishRetryStats(); pending != 2 {
+		t.Fatalf("PublishRetryStats() pending = %d, want the latest update of each user", pending)
+	}
+
+	// retries keep failing while NATS is down, backing off further each time
+	publisher.waitFor(t, 2*time.Second, func(attempts int, _ []string) bool { return attempts >= 6 })
+
+	publisher.setDown(false)
+	notify(service.publishRetries.rewind) // as the reconnect hook registered on Start does
+	publisher.waitFor(t, 200*time.Millisecond, func(_ int, published []string) bool { return len(published) == 2 })
+
+	_, published := publisher.state()
+	want := []string{fmt.Sprintf("bob,%d", StatusOnline), fmt.Sprintf("alice,%d", StatusOffline)}
+	if !slices.Equal(published, want) {
+		t.Fatalf("published on reconnect %v, want the pending updates oldest first %v", published, want)
+	}
+	if pending, dropped := service.PublishRetryStats(); pending != 0 || dropped != 0 {
+		t.Fatalf("PublishRetryStats() = (%d, %d), want nothing pending nor dropped", pending, dropped)
+	}
+}
+
+func TestPublishedUpdateSupersedesPendingOne(t *testing.T) {
+	service, _ := newTestService(t, nil) // Redis isn't reached
+	publisher := newFakePublisher(service)
+
+	service.publishPresenceUpdate("alice", "s1", StatusOnline)
+	publisher.setDown(false)
+	service.publishPresenceUpdate("alice", "s1", StatusOffline)
+	if pending, _ := service.PublishRetryStats(); pending != 0 {
+		t.Fatalf("PublishRetryStats() pending = %d, want the stale update to be discarded", pending)
+	}
+
+	startPublishRetries(t, service)
+	notify(service.publishRetries.rewind)
+	time.Sleep(20 * time.Millisecond)
+	if _, published := publisher.state(); !slices.Equal(published, []string{fmt.Sprintf("alice,%d", StatusOffline)}) {
+		t.Fatalf("published %v, want only the latest update", published)
+	}
+}
+
+func TestPublishRetriesDropOldestWhenFull(t *testing.T) {
+	var retries publishRetries
+	retries.pushed = make(chan struct{}, 1)
+
+	for idx := range publishRetryCapacity + 2 {
+		retries.push(fmt.Sprintf("user-%d", idx), []byte("update"))
+	}
+	if size, dropped := retries.size(), retries.dropped.Load(); size != publishRetryCapacity || dropped != 2 {
+		t.Fatalf("size() = %d with %d dropped, want %d with 2 dropped", size, dropped, publishRetryCapacity)
+	}
+	oldest, _ := retries.take()
+	if oldest.userID != "user-2" {
+		t.Fatalf("take() = update of '%s', want the oldest updates to be dropped first", oldest.userID)
+	}
+
+	// an update failing again isn't restored over newer ones when the buffer filled meanwhile
+	retries.push("user-new", []byte("update"))
+	retries.restore(oldest)
+	if size, dropped := retries.size(), retries.dropped.Load(); size != publishRetryCapacity || dropped != 3 {
+		t.Fatalf("size() = %d with %d dropped after restore, want %d with 3 dropped", size, dropped, publishRetryCapacity)
+	}
+}
@@ -3,16 +3,24 @@ package presence
 import (
 	"chat/src/clients/nats"
 	"chat/src/clients/redis"
+	"chat/src/platform/validation"
+	"chat/src/util"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/creasty/defaults"
 	"github.com/jellydator/ttlcache/v3"
 	nats2 "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	redis2 "github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 )
@@ -21,6 +29,7 @@ const (
 	sessionKeyFormat     = "presence:user:{%s}:session:%s"
 	sessionListKeyFormat = "presence:user:{%s}:sessions"
 	lastSeenKeyFormat    = "presence:user:{%s}:last_seen"
+	awayKeyFormat        = "presence:user:{%s}:away"
 )
 const (
 	sessionTTL     = 60 * time.Second
@@ -28,12 +37,16 @@ const (
 	lastSeenTTL    = 24 * time.Hour
 )
 const (
-	heartbeatInterval = 30 * time.Second
+	heartbeatInterval       = 30 * time.Second
+	heartbeatBatchSize      = 500              // sessions refreshed per pipeline round-trip in batched mode
+	heartbeatBatchRunBudget = 20 * time.Second // a round of batches must end before the next tick
 )
 const (
 	presenceStatusCacheTTL           = 5 * time.Second
 	presenceStatusCacheCapacity      = 10_000
 	presenceStatusCacheLoaderTimeout = 100 * time.Millisecond
+	presenceUnknownStatusTTL         = 1 * time.Second // short, so the status is loaded again once Redis recovers
+	presenceReconcileSpread          = 1 * time.Second // cached statuses expire within it after a NATS reconnect
 	lastSeenCacheTTL                 = 1 * time.Minute
 	lastSeenCacheCapacity            = 5_000
 	lastSeenCacheLoaderTimeout       = 100 * time.Millisecond
@@ -57,9 +70,17 @@ type Status uint8
 const (
 	StatusOffline Status = iota
 	StatusOnline
+	StatusUnknown // presence backend timed out, callers should show presence as temporarily unavailable, not offline
+	StatusAway    // all sessions of the user are idle for longer than the idle threshold
 )
 
-var ErrCacheMiss = errors.New("cache miss")
+var (
+	ErrCacheMiss       = errors.New("cache miss")
+	ErrTooManySessions = errors.New("too many sessions")
+	ErrSessionExists   = errors.New("session already exists")
+	ErrUnavailable     = errors.New("presence temporarily unavailable")
+	ErrUnknownSession  = errors.New("session is not hosted by this replica")
+)
 
 type Session struct {
 	ReplicaHost string
@@ -72,117 +93,203 @@ type Session struct {
 type heartbeats struct {
 	mutex        sync.Mutex
 	cancelations map[string]context.CancelFunc // key = userID:sessionID
+	batched      bool                          // #readonly, sessions are refreshed by a single ticker instead of one goroutine each
+	sessions     map[string]heartbeatSession   // key = userID:sessionID, used in batched mode
 	logger       *zerolog.Logger
 }
 
+type heartbeatSession struct {
+	userID    string
+	sessionID string
+}
+
 type Service struct {
 	redis            *redis.Client
 	statusCache      *ttlcache.Cache[string, Status]
 	lastSeenCache    *ttlcache.Cache[string, int64]
 	heartbeats       heartbeats
+	activity         activityTracker
 	nats             *nats.Client
 	natsSubscription *nats2.Subscription
+	unregisterHooks  []func() // of the NATS reconnect hooks registered on Start, called on Stop
+	jetStream        jetStreamEvents
+	sessionLimits    sessionLimits
+	evalShas         redisEvalShas
+	publishRetries   publishRetries
+	subject          string          // #readonly, presence updates subject, prefixed by the namespace of the deployment
+	lifecycleCtx     context.Context // cancelled on Stop, bounds the Redis calls of cache loaders
+	cancelLifecycle  context.CancelFunc
 	logger           *zerolog.Logger
 }
 
-func NewService(redisClient *redis.Client, natsClient *nats.Client, clientLogger *zerolog.Logger) *Service {
-	return &Service{
-		redis:  redisClient,
-		logger: clientLogger,
-		statusCache: ttlcache.New[string, Status](
-			ttlcache.WithCapacity[string, Status](presenceStatusCacheCapacity),
-			ttlcache.WithTTL[string, Status](presenceStatusCacheTTL),
-			ttlcache.WithLoader[string, Status](ttlcache.LoaderFunc[string, Status](
-				func(cache *ttlcache.Cache[string, Status], userID string) *ttlcache.Item[string, Status] {
-					sessionListKey := fmt.Sprintf(sessionListKeyFormat, userID)
-
-					ctx, cancel := context.WithTimeout(context.Background(), presenceStatusCacheLoaderTimeout)
-					defer cancel()
-					exists, err := redisClient.Driver.Exists(ctx, sessionListKey).Result()
-					if err != nil {
-						clientLogger.Err(err).Msgf("redis presence status check for user '%s' failed", userID)
-						return nil
-					}
-
-					var presence = StatusOffline
-					if exists == 1 {
-						presence = StatusOnline
-					}
-					item := cache.Set(userID, presence, ttlcache.DefaultTTL)
-					return item
-				},
-			)),
-			ttlcache.WithDisableTouchOnHit[string, Status](),
-		),
-		lastSeenCache: ttlcache.New[string, int64](
-			ttlcache.WithCapacity[string, int64](lastSeenCacheCapacity),
-			ttlcache.WithTTL[string, int64](lastSeenCacheTTL),
-			ttlcache.WithLoader[string, int64](ttlcache.LoaderFunc[string, int64](
-				func(cache *ttlcache.Cache[string, int64], userID string) *ttlcache.Item[string, int64] {
-					lastSeenKey := fmt.Sprintf(lastSeenKeyFormat, userID)
-
-					ctx, cancel := context.WithTimeout(context.Background(), lastSeenCacheLoaderTimeout)
-					defer cancel()
-					val, err := redisClient.Driver.Get(ctx, lastSeenKey).Result()
-					if err != nil {
-						if errors.Is(err, redis2.Nil) {
-							// key does not exist → offline for > TTL or never connected
-							item := cache.Set(userID, 0, ttlcache.DefaultTTL)
-							return item
-						}
-						clientLogger.Err(err).Msgf("redis last seen read for user '%s' failed", userID)
-						return nil
-					}
-
-					ts, err := strconv.ParseInt(val, 10, 64)
-					if err != nil {
-						clientLogger.Err(err).Msgf("redis contains invalid last seen value for user '%s': %s", userID, val)
-						return nil
-					}
-
-					item := cache.Set(userID, ts, ttlcache.DefaultTTL)
-					return item
-				},
-			)),
-		),
+type sessionLimits struct {
+	maxPerUser  int  // #readonly
+	evictOldest bool // #readonly
+}
+
+type jetStreamEvents struct {
+	options        JetStreamOptions // #readonly
+	driver         jetstream.JetStream
+	consumeContext jetstream.ConsumeContext
+}
+
+type redisEvalShas struct {
+	createSession string
+	touchSession  string
+	markAway      string
+}
+
+type ServiceOptions struct {
+	RedisClient        *redis.Client `validate:"required" default:"-"` // clients are skipped by defaults, which would read started drivers
+	NatsClient         *nats.Client  `validate:"required" default:"-"`
+	MaxSessionsPerUser int           `validate:"required,min=1,max=100" default:"10"`
+	EvictOldestSession bool          // when cap is reached, evict the oldest session instead of rejecting the new one
+	BatchedHeartbeats  bool          // refresh all sessions from a single ticker with chunked pipelines, for replicas holding many sessions
+	IdleThreshold      time.Duration `validate:"omitempty,min=60000000000,max=86400000000000"` // 1min to 24h, users idle for longer are away, 0 disables it
+	SubjectPrefix      string        `validate:"omitempty,max=64,printascii"`                  // e.g. "chat.prod", isolates deployments sharing a NATS cluster
+	JetStream          JetStreamOptions
+	Logger             *zerolog.Logger `validate:"required"`
+}
+
+// JetStreamOptions enables durable presence events. When disabled, updates are published with core NATS
+// and subscribers which were down miss them.
+type JetStreamOptions struct {
+	Enabled      bool
+	StreamName   string        `validate:"required_if=Enabled true,omitempty,min=1,max=64,excludesall=.*> /\\" default:"PRESENCE"`
+	Retention    time.Duration `validate:"required_if=Enabled true,omitempty,min=10000000000,max=3600000000000" default:"5m"` // 10s to 1h
+	ConsumerName string        `validate:"required_if=Enabled true,omitempty,min=1,max=64,excludesall=.*> /\\"`               // must be unique per replica
+}
+
+func NewService(options *ServiceOptions) (*Service, error) {
+	if err := defaults.Set(options); err != nil {
+		return nil, fmt.Errorf("failed to set config defaults: %w", err)
+	}
+	if err := validation.Instance.Struct(options); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+	subject, err := presenceSubject(options.SubjectPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	service := &Service{
+		redis:   options.RedisClient,
+		subject: subject,
+		logger:  options.Logger,
 		heartbeats: heartbeats{
 			cancelations: make(map[string]context.CancelFunc),
-			logger:       clientLogger,
+			batched:      options.BatchedHeartbeats,
+			sessions:     make(map[string]heartbeatSession),
+			logger:       options.Logger,
+		},
+		activity: activityTracker{
+			idleThreshold: options.IdleThreshold,
+			lastActivity:  make(map[heartbeatSession]trackedActivity),
+		},
+		nats: options.NatsClient,
+		jetStream: jetStreamEvents{
+			options: options.JetStream,
+		},
+		sessionLimits: sessionLimits{
+			maxPerUser:  options.MaxSessionsPerUser,
+			evictOldest: options.EvictOldestSession,
+		},
+		publishRetries: publishRetries{
+			pushed: make(chan struct{}, 1),
+			rewind: make(chan struct{}, 1),
 		},
-		nats: natsClient,
+		lifecycleCtx:    context.Background(),
+		cancelLifecycle: func() {},
 	}
+	service.publishRetries.publish = service.publish
+
+	// concurrent misses of the same user are collapsed into a single Redis call, failed loads aren't cached
+	service.statusCache = ttlcache.New[string, Status](
+		ttlcache.WithCapacity[string, Status](presenceStatusCacheCapacity),
+		ttlcache.WithTTL[string, Status](presenceStatusCacheTTL),
+		ttlcache.WithLoader[string, Status](ttlcache.NewSuppressedLoader[string, Status](
+			ttlcache.LoaderFunc[string, Status](service.loadStatus), nil,
+		)),
+		ttlcache.WithDisableTouchOnHit[string, Status](),
+	)
+	service.lastSeenCache = ttlcache.New[string, int64](
+		ttlcache.WithCapacity[string, int64](lastSeenCacheCapacity),
+		ttlcache.WithTTL[string, int64](lastSeenCacheTTL),
+		ttlcache.WithLoader[string, int64](ttlcache.NewSuppressedLoader[string, int64](
+			ttlcache.LoaderFunc[string, int64](service.loadLastSeen), nil,
+		)),
+	)
+
+	return service, nil
 }
 
-func (s *Service) Start(_ context.Context) error {
-	go s.statusCache.Start()
-	go s.lastSeenCache.Start()
-
-	subscription, err := s.nats.Driver.Subscribe(natsSubjectUserPresenceUpdates, func(msg *nats2.Msg) {
-		payload := string(msg.Data) // "USER_ID,STATE"
+// presenceSubject prefixes the presence updates subject, the prefix must be made of valid NATS subject tokens.
+func presenceSubject(prefix string) (string, error) {
+	prefix = strings.TrimSuffix(prefix, ".")
+	if prefix == "" {
+		return natsSubjectUserPresenceUpdates, nil
+	}
 
-		parts := strings.Split(payload, ",")
-		if len(parts) != 2 {
-			s.logger.Error().Msgf("invalid NATS presence message: %s", payload)
-			return
+	for token := range strings.SplitSeq(prefix, ".") {
+		if token == "" || strings.ContainsAny(token, "*> \t\r\n") {
+			return "", fmt.Errorf("subject prefix '%s' has invalid token '%s'", prefix, token)
 		}
+	}
+	return prefix + "." + natsSubjectUserPresenceUpdates, nil
+}
 
-		userID := parts[0]
-		var status Status
-		if statusValue, err := strconv.ParseUint(parts[1], 10, 8); err == nil {
-			status = Status(statusValue)
-		} else {
-			s.logger.Error().Msgf("invalid NATS presence message '%s', status must be an uint8 field, given '%s'", payload, parts[1])
-			return
+func (s *Service) Start(ctx context.Context) error {
+	if err := s.loadScripts(ctx); err != nil {
+		return err
+	}
+
+	s.lifecycleCtx, s.cancelLifecycle = context.WithCancel(context.Background())
+
+	go s.statusCache.Start()
+	go s.lastSeenCache.Start()
+
+	if s.heartbeats.batched {
+		lifecycleCtx := s.lifecycleCtx
+		util.Go(s.logger, "presence.heartbeats", func() {
+			s.runBatchedHeartbeats(lifecycleCtx)
+		})
+	}
+	if s.activity.idleThreshold > 0 {
+		lifecycleCtx := s.lifecycleCtx
+		util.Go(s.logger, "presence.idle-sweeper", func() {
+			s.runIdleSweeper(lifecycleCtx)
+		})
+	}
+	retriesCtx := s.lifecycleCtx
+	util.Go(s.logger, "presence.publish-retries", func() {
+		s.runPublishRetries(retriesCtx)
+	})
+	// the updates which failed to be published while disconnected are resent at once
+	s.unregisterHooks = append(s.unregisterHooks, s.nats.OnReconnect(func() { notify(s.publishRetries.rewind) }))
+
+	if s.jetStream.options.Enabled {
+		if err := s.subscribeJetStream(ctx); err != nil {
+			s.unregisterReconnectHooks()
+			s.cancelLifecycle()
+			s.statusCache.Stop()
+			s.lastSeenCache.Stop()
+			return err
 		}
+		return nil
+	}
 
-		s.statusCache.Set(userID, status, ttlcache.DefaultTTL)
-		s.logger.Debug().Msgf("NATS presence update received for user '%s': %s", userID, status.String())
+	subscription, err := s.nats.Driver.Subscribe(s.subject, func(msg *nats2.Msg) {
+		s.handlePresenceUpdate(msg.Data)
 	})
 	if err != nil {
+		s.unregisterReconnectHooks()
+		s.cancelLifecycle()
 		s.statusCache.Stop()
 		s.lastSeenCache.Stop()
-		return fmt.Errorf("failed to subscribe for NATS '%s' subject: %w", natsSubjectUserPresenceUpdates, err)
+		return fmt.Errorf("failed to subscribe for NATS '%s' subject: %w", s.subject, err)
 	}
+	// core NATS doesn't replay the updates published while disconnected, so the cached statuses might be stale
+	s.unregisterHooks = append(s.unregisterHooks, s.nats.OnReconnect(s.reconcileStatusCache))
 	subscription.SetClosedHandler(func(subj string) {
 		s.logger.Info().Msgf("NATS subscription to subject '%s' closed", subj)
 	})
@@ -191,44 +298,267 @@ func (s *Service) Start(_ context.Context) error {
 	return nil
 }
 
-func (s *Service) Stop(_ context.Context) {
-	err := s.natsSubscription.Unsubscribe()
+func (s *Service) loadScripts(ctx context.Context) error {
+	/*
+		-- KEYS[1] = session list key
+		-- KEYS[2] = session key
+		-- KEYS[3] = last seen key
+		-- KEYS[4] = away key
+		-- ARGV[1] = session id
+		-- ARGV[2] = max sessions per user
+		-- ARGV[3] = evict oldest sessions when at cap ("1" or "0")
+		-- ARGV[4] = session expiration in seconds
+		-- ARGV[5] = session list expiration in seconds
+		-- ARGV[6] = session key prefix, session id is appended to it
+		-- ARGV[7..n] = session hash field/value pairs
+		-- returns {created (1, 0 when rejected or 2 when the session exists), became online (1 or 0), evicted session ids...}
+	*/
+	evalShaCreateSession, err := s.redis.Driver.ScriptLoad(ctx, `
+local list_key           = KEYS[1]
+local session_key        = KEYS[2]
+local last_seen_key      = KEYS[3]
+local away_key           = KEYS[4]
+local session_id         = ARGV[1]
+local max_sessions       = tonumber(ARGV[2])
+local evict_oldest       = ARGV[3] == "1"
+local session_ttl        = tonumber(ARGV[4])
+local list_ttl           = tonumber(ARGV[5])
+local session_key_prefix = ARGV[6]
+
+-- an existing session is not overwritten, its expiration is only refreshed
+if redis.call("EXISTS", session_key) == 1 then
+    redis.call("EXPIRE", session_key, session_ttl)
+    redis.call("SADD", list_key, session_id)
+    redis.call("EXPIRE", list_key, list_ttl)
+    return {2, 0}
+end
+
+-- a listed session whose key expired is re-created without being counted against the cap
+local listed = redis.call("SISMEMBER", list_key, session_id) == 1
+local active = {}
+for _, id in ipairs(redis.call("SMEMBERS", list_key)) do
+    if id ~= session_id then
+        local started_at = redis.call("HGET", session_key_prefix .. id, "started_at")
+        if started_at then
+            active[#active+1] = {id = id, started_at = tonumber(started_at) or 0}
+        else
+            -- session expired, but the list still references it
+            redis.call("SREM", list_key, id)
+        end
+    end
+end
+
+local result = {1, #active == 0 and 1 or 0}
+
+if not listed and #active >= max_sessions then
+    if not evict_oldest then
+        return {0, 0}
+    end
+    table.sort(active, function(a, b) return a.started_at < b.started_at end)
+    for i = 1, #active - max_sessions + 1 do
+        redis.call("DEL", session_key_prefix .. active[i].id)
+        redis.call("SREM", list_key, active[i].id)
+        result[#result+1] = active[i].id
+    end
+end
+
+local fields = {}
+for i = 7, #ARGV do
+    fields[#fields+1] = ARGV[i]
+end
+redis.call("HSET", session_key, unpack(fields))
+redis.call("EXPIRE", session_key, session_ttl)
+
+redis.call("SADD", list_key, session_id)
+redis.call("EXPIRE", list_key, list_ttl)
+
+redis.call("DEL", last_seen_key)
+redis.call("DEL", away_key)
+
+return result
+`).Result()
+	if err != nil {
+		return fmt.Errorf("can't load Lua script responsible for session creation: %w", err)
+	}
+	s.evalShas.createSession = evalShaCreateSession
+	return s.loadActivityScripts(ctx)
+}
+
+func (s *Service) subscribeJetStream(ctx context.Context) error {
+	js, err := jetstream.New(s.nats.Driver, jetstream.WithPublishAsyncErrHandler(func(_ jetstream.JetStream, msg *nats2.Msg, err error) {
+		s.logger.Err(err).Msgf("failed to publish presence update '%s' to JetStream", string(msg.Data))
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      s.jetStream.options.StreamName,
+		Subjects:  []string{s.subject},
+		Retention: jetstream.LimitsPolicy,
+		Discard:   jetstream.DiscardOld,
+		MaxAge:    s.jetStream.options.Retention,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create or update JetStream stream '%s': %w", s.jetStream.options.StreamName, err)
+	}
+
+	// Durable consumer keeps its position while this replica is down, so on restart or reconnect it
+	// catches up on the transitions it missed, as long as they are still retained by the stream.
+	consumer, err := js.CreateOrUpdateConsumer(ctx, s.jetStream.options.StreamName, jetstream.ConsumerConfig{
+		Durable:           s.jetStream.options.ConsumerName,
+		DeliverPolicy:     jetstream.DeliverNewPolicy,
+		AckPolicy:         jetstream.AckExplicitPolicy,
+		FilterSubject:     s.subject,
+		InactiveThreshold: s.jetStream.options.Retention,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create or update JetStream durable consumer '%s': %w", s.jetStream.options.ConsumerName, err)
+	}
+
+	consumeContext, err := consumer.Consume(func(msg jetstream.Msg) {
+		s.handlePresenceUpdate(msg.Data())
+		if err := msg.Ack(); err != nil {
+			s.logger.Warn().Err(err).Msgf("failed to ack JetStream presence update: %s", string(msg.Data()))
+		}
+	}, jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		s.logger.Warn().Err(err).Msgf("JetStream presence consumer '%s' error", s.jetStream.options.ConsumerName)
+	}))
 	if err != nil {
-		s.logger.Err(err).Msgf("failed to unsubscribe from NATS subject '%s'", s.natsSubscription.Subject)
+		return fmt.Errorf("failed to consume from JetStream durable consumer '%s': %w", s.jetStream.options.ConsumerName, err)
 	}
+
+	s.jetStream.driver = js
+	s.jetStream.consumeContext = consumeContext
+	return nil
+}
+
+func (s *Service) handlePresenceUpdate(data []byte) {
+	payload := string(data) // "USER_ID,STATE"
+
+	parts := strings.Split(payload, ",")
+	if len(parts) != 2 {
+		s.logger.Error().Msgf("invalid NATS presence message: %s", payload)
+		return
+	}
+
+	userID := parts[0]
+	var status Status
+	if statusValue, err := strconv.ParseUint(parts[1], 10, 8); err == nil && Status(statusValue) <= StatusAway &&
+		Status(statusValue) != StatusUnknown {
+		status = Status(statusValue)
+	} else {
+		s.logger.Error().Msgf("invalid NATS presence message '%s', status must be an uint8 field, given '%s'", payload, parts[1])
+		return
+	}
+
+	s.statusCache.Set(userID, status, ttlcache.DefaultTTL)
+	s.logger.Debug().Msgf("NATS presence update received for user '%s': %s", userID, status.String())
+}
+
+func (s *Service) Stop(_ context.Context) {
+	if s.jetStream.consumeContext != nil {
+		s.jetStream.consumeContext.Stop()
+	}
+	if s.natsSubscription != nil {
+		err := s.natsSubscription.Unsubscribe()
+		if err != nil {
+			s.logger.Err(err).Msgf("failed to unsubscribe from NATS subject '%s'", s.natsSubscription.Subject)
+		}
+	}
+	s.unregisterReconnectHooks()
 	s.heartbeats.stopAll()
+	s.activity.untrackAll()
+	s.cancelLifecycle()
 	s.statusCache.Stop()
 	s.lastSeenCache.Stop()
 }
 
-func (s *Service) CreateSession(ctx context.Context, userID, sessionID string, session Session) error {
+func (s *Service) unregisterReconnectHooks() {
+	for _, unregister := range s.unregisterHooks {
+		unregister()
+	}
+	s.unregisterHooks = nil
+}
+
+// reconcileStatusCache lazily invalidates the cached statuses, each of them expires at a random point within
+// presenceReconcileSpread, so that the following reads reload them from Redis without all hitting it at once.
+func (s *Service) reconcileStatusCache() {
+	keys := s.statusCache.Keys()
+	for _, userID := range keys {
+		item := s.statusCache.Get(userID, ttlcache.WithLoader[string, Status](nil))
+		if item == nil || time.Until(item.ExpiresAt()) <= presenceReconcileSpread {
+			continue
+		}
+		s.statusCache.Set(userID, item.Value(), time.Millisecond+rand.N(presenceReconcileSpread))
+	}
+	s.logger.Info().Msgf("NATS reconnected, %d cached presence statuses will be reloaded from Redis", len(keys))
+}
+
+// CreateSessionResult reports the effects of a session creation.
+type CreateSessionResult struct {
+	// BecameOnline is true when the user had no other live session, i.e. the creation is an offline to online
+	// transition rather than another device joining. It's decided by the creation script, so it's race free.
+	BecameOnline bool
+	Evicted      []string // ids of the sessions evicted to make room for the created one
+}
+
+func (s *Service) CreateSession(ctx context.Context, userID, sessionID string, session Session) (CreateSessionResult, error) {
 	sessionKey := fmt.Sprintf(sessionKeyFormat, userID, sessionID)
 	sessionListKey := fmt.Sprintf(sessionListKeyFormat, userID)
 	lastSeenKey := fmt.Sprintf(lastSeenKeyFormat, userID)
-	fields := map[string]any{
-		"replica_host": session.ReplicaHost,
-		"device_id":    session.DeviceID,
-		"platform":     strconv.FormatUint(uint64(session.Platform), 10),
-		"ip":           session.IP,
-		"started_at":   strconv.FormatInt(session.StartedAt, 10),
-	}
-
-	// We do not protect against existing sessions with the same ID.
-	// It's the caller's responsibility to ensure uniqueness.
-	// Worst case, an existing session gets overwritten.
-	_, err := s.redis.Driver.TxPipelined(ctx, func(pipe redis2.Pipeliner) error {
-		pipe.HSet(ctx, sessionKey, fields)
-		pipe.Expire(ctx, sessionKey, sessionTTL)
-
-		pipe.SAdd(ctx, sessionListKey, sessionID)
-		pipe.Expire(ctx, sessionListKey, sessionListTTL)
-
-		pipe.Del(ctx, lastSeenKey)
+	awayKey := fmt.Sprintf(awayKeyFormat, userID)
+	evictOldest := "0"
+	if s.sessionLimits.evictOldest {
+		evictOldest = "1"
+	}
 
-		return nil
-	})
+	// Creation is idempotent: when the session exists (i.e. a client retry reached another replica), it's not
+	// overwritten, only its expiration is refreshed, and neither the heartbeat is started nor online is published.
+	// Session cap check and insertion are done by the same Lua script, so concurrent creations can't overshoot it.
+	result, err := s.redis.Driver.EvalSha(
+		ctx,
+		s.evalShas.createSession,
+		[]string{sessionListKey, sessionKey, lastSeenKey, awayKey},
+		sessionID,
+		s.sessionLimits.maxPerUser,
+		evictOldest,
+		sessionTTL.Seconds(),
+		sessionListTTL.Seconds(),
+		fmt.Sprintf(sessionKeyFormat, userID, ""),
+		"replica_host", session.ReplicaHost,
+		"device_id", session.DeviceID,
+		"platform", strconv.FormatUint(uint64(session.Platform), 10),
+		"ip", session.IP,
+		"started_at", strconv.FormatInt(session.StartedAt, 10),
+		"last_activity", strconv.FormatInt(time.Now().UnixMilli(), 10),
+	).Slice()
 	if err != nil {
-		return fmt.Errorf("create session with id '%s' for user '%s' failed: %w", sessionID, userID, err)
+		return CreateSessionResult{}, fmt.Errorf("create session with id '%s' for user '%s' failed: %w", sessionID, userID, err)
+	}
+	switch created, _ := result[0].(int64); created {
+	case 0:
+		return CreateSessionResult{}, fmt.Errorf(
+			"create session with id '%s' for user '%s' rejected, limit of %d sessions reached: %w",
+			sessionID, userID, s.sessionLimits.maxPerUser, ErrTooManySessions,
+		)
+	case 2:
+		return CreateSessionResult{}, fmt.Errorf(
+			"create session with id '%s' for user '%s' skipped: %w", sessionID, userID, ErrSessionExists,
+		)
+	}
+
+	becameOnline, _ := result[1].(int64)
+	creation := CreateSessionResult{BecameOnline: becameOnline == 1}
+	for _, evicted := range result[2:] {
+		evictedSessionID, _ := evicted.(string)
+		creation.Evicted = append(creation.Evicted, evictedSessionID)
+		s.heartbeats.stopIfRunning(userID, evictedSessionID)
+		s.activity.untrack(userID, evictedSessionID)
+		s.logger.Info().Msgf(
+			"session '%s' of user '%s' evicted to make room for session '%s'", evictedSessionID, userID, sessionID,
+		)
 	}
 
 	// Update caches
@@ -237,11 +567,29 @@ func (s *Service) CreateSession(ctx context.Context, userID, sessionID string, s
 
 	// Start heartbeat to keep session alive.
 	s.heartbeats.start(userID, sessionID, s.runHeartbeat)
+	s.activity.track(userID, sessionID, time.Now())
 
 	// Publish changes
 	s.publishPresenceUpdate(userID, sessionID, StatusOnline)
 
-	return nil
+	return creation, nil
+}
+
+// ActiveHeartbeats returns the userID:sessionID keys of the sessions this replica keeps alive. Sessions listed for
+// long without activity usually were created without a matching delete, i.e. a leaked heartbeat.
+func (s *Service) ActiveHeartbeats() []string {
+	return s.heartbeats.keys()
+}
+
+// CancelHeartbeat stops keeping the session alive, without deleting it, so it expires unless it's created again.
+// It reports whether the session had a heartbeat.
+func (s *Service) CancelHeartbeat(userID, sessionID string) bool {
+	if !s.heartbeats.stopIfRunning(userID, sessionID) {
+		return false
+	}
+	s.activity.untrack(userID, sessionID)
+	s.logger.Info().Msgf("heartbeat of session '%s' of user '%s' canceled", sessionID, userID)
+	return true
 }
 
 func (s *Service) DeleteSession(ctx context.Context, userID, sessionID string) error {
@@ -253,43 +601,42 @@ func (s *Service) DeleteSession(ctx context.Context, userID, sessionID string) e
 
 	// We stop heartbeat, so regardless of whether deletion succeeds or not, it won't be kept alive.
 	s.heartbeats.stop(userID, sessionID)
+	s.activity.untrack(userID, sessionID)
 
 	// Do deletion in a transaction to ensure consistency.
-	for {
-		err := s.redis.Driver.Watch(ctx, func(tx *redis2.Tx) error {
-			sessionCountBefore, err := tx.SCard(ctx, sessionListKey).Result()
-			if err != nil {
-				return fmt.Errorf("failed to SCARD %s: %w", sessionListKey, err)
-			}
-
-			_, err = tx.TxPipelined(ctx, func(pipe redis2.Pipeliner) error {
-				pipe.Del(ctx, sessionKey)
-				pipe.SRem(ctx, sessionListKey, sessionID)
-				if sessionCountBefore == 1 {
-					pipe.Set(ctx, lastSeenKey, lastSeenValue, lastSeenTTL)
-
-					s.statusCache.Set(userID, StatusOffline, ttlcache.DefaultTTL)
-					s.lastSeenCache.Set(userID, lastSeenTime, ttlcache.DefaultTTL)
+	wentOffline := false
+	err := s.redis.WithTxWatch(ctx, []string{sessionListKey}, func(tx *redis2.Tx) error {
+		sessionCountBefore, err := tx.SCard(ctx, sessionListKey).Result()
+		if err != nil {
+			return fmt.Errorf("failed to SCARD %s: %w", sessionListKey, err)
+		}
 
-					s.publishPresenceUpdate(userID, sessionID, StatusOffline)
-				}
-				return nil
-			})
-			if err != nil {
-				return fmt.Errorf("failed to transactionally remove session '%s' of user '%s': %w", sessionID, userID, err)
+		_, err = tx.TxPipelined(ctx, func(pipe redis2.Pipeliner) error {
+			pipe.Del(ctx, sessionKey)
+			pipe.SRem(ctx, sessionListKey, sessionID)
+			if sessionCountBefore == 1 {
+				pipe.Set(ctx, lastSeenKey, lastSeenValue, lastSeenTTL)
 			}
 			return nil
-		}, sessionListKey)
-
-		if errors.Is(err, redis2.TxFailedErr) {
-			continue
-		}
-
+		})
 		if err != nil {
-			return fmt.Errorf("delete session '%s' for user '%s' failed: %w", sessionID, userID, err)
+			return fmt.Errorf("failed to transactionally remove session '%s' of user '%s': %w", sessionID, userID, err)
 		}
+		wentOffline = sessionCountBefore == 1
 		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete session '%s' for user '%s' failed: %w", sessionID, userID, err)
 	}
+
+	// side effects are applied once the transaction committed, a retried attempt must not apply them twice
+	if wentOffline {
+		s.statusCache.Set(userID, StatusOffline, ttlcache.DefaultTTL)
+		s.lastSeenCache.Set(userID, lastSeenTime, ttlcache.DefaultTTL)
+
+		s.publishPresenceUpdate(userID, sessionID, StatusOffline)
+	}
+	return nil
 }
 
 func (s *Service) Status(userID string) (Status, error) {
@@ -297,9 +644,66 @@ func (s *Service) Status(userID string) (Status, error) {
 	if item == nil {
 		return StatusOffline, fmt.Errorf("presence cache miss for user '%s': %w", userID, ErrCacheMiss)
 	}
+	if item.Value() == StatusUnknown {
+		return StatusUnknown, fmt.Errorf("presence of user '%s' couldn't be loaded: %w", userID, ErrUnavailable)
+	}
 	return item.Value(), nil
 }
 
+// StatusMulti returns the statuses of multiple users, loading the ones which aren't cached with a single pipeline.
+// Users whose status couldn't be loaded are missing from the result and must be treated as a cache miss, unless
+// Redis timed out, in which case they are reported as StatusUnknown.
+func (s *Service) StatusMulti(userIDs []string) map[string]Status {
+	statuses := make(map[string]Status, len(userIDs))
+	misses := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		// skip the per key loader, misses are loaded below
+		if item := s.statusCache.Get(userID, ttlcache.WithLoader[string, Status](nil)); item != nil {
+			statuses[userID] = item.Value()
+		} else {
+			misses = append(misses, userID)
+		}
+	}
+	if len(misses) == 0 {
+		return statuses
+	}
+
+	ctx, cancel := context.WithTimeout(s.lifecycleCtx, presenceStatusCacheLoaderTimeout)
+	defer cancel()
+	commands := make([]*redis2.IntCmd, len(misses))
+	awayCommands := make([]*redis2.IntCmd, len(misses))
+	_, err := s.redis.WithPipeline(ctx, func(pipe redis2.Pipeliner) error {
+		for idx, userID := range misses {
+			commands[idx] = pipe.Exists(ctx, fmt.Sprintf(sessionListKeyFormat, userID))
+			awayCommands[idx] = pipe.Exists(ctx, fmt.Sprintf(awayKeyFormat, userID))
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Err(err).Msgf("redis presence status check for %d users failed", len(misses))
+	}
+
+	for idx, userID := range misses {
+		if commands[idx] == nil {
+			continue // the pipeline wasn't executed
+		}
+		exists, err := commands[idx].Result()
+		if isTimeout(err) {
+			s.statusCache.Set(userID, StatusUnknown, presenceUnknownStatusTTL)
+			statuses[userID] = StatusUnknown
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		away, _ := awayCommands[idx].Result()
+		status := statusOf(exists == 1, away == 1)
+		s.statusCache.Set(userID, status, ttlcache.DefaultTTL)
+		statuses[userID] = status
+	}
+	return statuses
+}
+
 func (s *Service) LastSeen(userID string) (int64, error) {
 	item := s.lastSeenCache.Get(userID)
 	if item == nil {
@@ -334,7 +738,10 @@ func (s *Service) GetSession(ctx context.Context, userID, sessionID string) (*Se
 	if len(data) == 0 {
 		return nil, nil //nolint:nilnil // indicate non-existence with (nil, nil)
 	}
+	return s.parseSession(userID, sessionID, data), nil
+}
 
+func (s *Service) parseSession(userID, sessionID string, data map[string]string) *Session {
 	sess := &Session{
 		ReplicaHost: data["replica_host"],
 		DeviceID:    data["device_id"],
@@ -361,18 +768,83 @@ func (s *Service) GetSession(ctx context.Context, userID, sessionID string) (*Se
 		s.logger.Warn().Msgf("session '%s' for user '%s' doesn't have 'started_at' field", sessionID, userID)
 	}
 
-	return sess, nil
+	return sess
+}
+
+func (s *Service) loadStatus(cache *ttlcache.Cache[string, Status], userID string) *ttlcache.Item[string, Status] {
+	sessionListKey := fmt.Sprintf(sessionListKeyFormat, userID)
+	awayKey := fmt.Sprintf(awayKeyFormat, userID)
+
+	ctx, cancel := context.WithTimeout(s.lifecycleCtx, presenceStatusCacheLoaderTimeout)
+	defer cancel()
+	var existsCmd, awayCmd *redis2.IntCmd
+	_, err := s.redis.WithPipeline(ctx, func(pipe redis2.Pipeliner) error {
+		existsCmd = pipe.Exists(ctx, sessionListKey)
+		awayCmd = pipe.Exists(ctx, awayKey)
+		return nil
+	})
+	if isTimeout(err) {
+		s.logger.Warn().Err(err).Msgf("redis presence status check for user '%s' timed out", userID)
+		return cache.Set(userID, StatusUnknown, presenceUnknownStatusTTL)
+	}
+	if err != nil {
+		s.logger.Err(err).Msgf("redis presence status check for user '%s' failed", userID)
+		return nil
+	}
+
+	presence := statusOf(existsCmd.Val() == 1, awayCmd.Val() == 1)
+	item := cache.Set(userID, presence, ttlcache.DefaultTTL)
+	return item
+}
+
+// statusOf resolves the status from the existence of the session list and of the away marker of the user, the
+// marker might outlive the sessions, so it's only considered when the user has sessions.
+func statusOf(hasSessions, away bool) Status {
+	switch {
+	case !hasSessions:
+		return StatusOffline
+	case away:
+		return StatusAway
+	default:
+		return StatusOnline
+	}
+}
+
+func (s *Service) loadLastSeen(cache *ttlcache.Cache[string, int64], userID string) *ttlcache.Item[string, int64] {
+	lastSeenKey := fmt.Sprintf(lastSeenKeyFormat, userID)
+
+	ctx, cancel := context.WithTimeout(s.lifecycleCtx, lastSeenCacheLoaderTimeout)
+	defer cancel()
+	val, err := s.redis.Driver.Get(ctx, lastSeenKey).Result()
+	if err != nil {
+		if errors.Is(err, redis2.Nil) {
+			// key does not exist → offline for > TTL or never connected
+			item := cache.Set(userID, 0, ttlcache.DefaultTTL)
+			return item
+		}
+		s.logger.Err(err).Msgf("redis last seen read for user '%s' failed", userID)
+		return nil
+	}
+
+	ts, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		s.logger.Err(err).Msgf("redis contains invalid last seen value for user '%s': %s", userID, val)
+		return nil
+	}
+
+	item := cache.Set(userID, ts, ttlcache.DefaultTTL)
+	return item
 }
 
 func (s *Service) heartbeat(ctx context.Context, userID, sessionID string) error {
 	sessionKey := fmt.Sprintf(sessionKeyFormat, userID, sessionID)
 	sessionListKey := fmt.Sprintf(sessionListKeyFormat, userID)
 
-	pipe := s.redis.Driver.Pipeline()
-	pipe.Expire(ctx, sessionKey, sessionTTL)
-	pipe.Expire(ctx, sessionListKey, sessionListTTL)
-
-	_, err := pipe.Exec(ctx)
+	_, err := s.redis.WithPipeline(ctx, func(pipe redis2.Pipeliner) error {
+		pipe.Expire(ctx, sessionKey, sessionTTL)
+		pipe.Expire(ctx, sessionListKey, sessionListTTL)
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("heartbeat for session with id '%s' for user '%s' failed: %w", sessionID, userID, err)
 	}
@@ -398,27 +870,82 @@ func (s *Service) runHeartbeat(ctx context.Context, userID, sessionID string) {
 	}
 }
 
+// runBatchedHeartbeats refreshes the TTLs of all sessions of the replica every heartbeat interval, issuing the
+// EXPIREs in chunks of heartbeatBatchSize sessions per round-trip. TTLs are the same as in per-session mode.
+func (s *Service) runBatchedHeartbeats(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			runCtx, cancel := context.WithTimeout(ctx, heartbeatBatchRunBudget)
+			s.heartbeatBatches(runCtx, s.heartbeats.snapshot())
+			cancel()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Service) heartbeatBatches(ctx context.Context, sessions []heartbeatSession) {
+	failed := 0
+	for batch := range slices.Chunk(sessions, heartbeatBatchSize) {
+		_, err := s.redis.WithPipeline(ctx, func(pipe redis2.Pipeliner) error {
+			for _, session := range batch {
+				pipe.Expire(ctx, fmt.Sprintf(sessionKeyFormat, session.userID, session.sessionID), sessionTTL)
+				pipe.Expire(ctx, fmt.Sprintf(sessionListKeyFormat, session.userID), sessionListTTL)
+			}
+			return nil
+		})
+		if err != nil {
+			failed += len(batch)
+			s.logger.Warn().Err(err).Msgf("batched heartbeat of %d sessions failed", len(batch))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if failed > 0 {
+		s.logger.Warn().Msgf("%d of %d sessions weren't refreshed by the batched heartbeat", failed, len(sessions))
+	}
+}
+
 func (s *Service) publishPresenceUpdate(userID, sessionID string, status Status) {
-	msg := userID + "," + strconv.FormatUint(uint64(status), 10)
-	err := s.nats.Driver.Publish(natsSubjectUserPresenceUpdates, []byte(msg))
-	if err != nil {
-		s.logger.Err(err).Msgf(
-			"failed to publish presence update '%s' for session '%s' of user '%s'",
+	msg := []byte(userID + "," + strconv.FormatUint(uint64(status), 10))
+	if err := s.publishRetries.publish(msg); err != nil {
+		s.logger.Warn().Err(err).Msgf(
+			"failed to publish presence update '%s' for session '%s' of user '%s', it will be retried",
 			status.String(), sessionID, userID,
 		)
+		s.publishRetries.push(userID, msg)
+		return
 	}
+	s.publishRetries.supersede(userID)
 }
 
 func (h *heartbeats) start(userID, sessionID string, heartbeater func(ctx context.Context, userID, sessionID string)) {
 	heartbeatKey := userID + ":" + sessionID
 
 	h.mutex.Lock()
+	if h.batched {
+		if _, exists := h.sessions[heartbeatKey]; !exists {
+			h.sessions[heartbeatKey] = heartbeatSession{userID: userID, sessionID: sessionID}
+		} else {
+			h.logger.Warn().Msgf("heartbeat for session '%s' of user '%s' already exists", sessionID, userID)
+		}
+		h.mutex.Unlock()
+		return
+	}
 	_, exists := h.cancelations[heartbeatKey]
 	if !exists {
 		hbCtx, cancel := context.WithCancel(context.Background())
 		h.cancelations[heartbeatKey] = cancel
 
-		go heartbeater(hbCtx, userID, sessionID)
+		util.Go(h.logger, "presence.heartbeat", func() {
+			heartbeater(hbCtx, userID, sessionID)
+		})
 	} else {
 		h.logger.Warn().Msgf(
 			"heartbeat for session '%s' of user '%s' already exists",
@@ -435,28 +962,81 @@ func (h *heartbeats) stop(userID, sessionID string) {
 	if cancel, ok := h.cancelations[heartbeatKey]; ok {
 		cancel()
 		delete(h.cancelations, heartbeatKey)
+	} else if _, ok := h.sessions[heartbeatKey]; ok {
+		delete(h.sessions, heartbeatKey)
 	} else {
 		h.logger.Warn().Msgf("no heartbeat found for session '%s' of user '%s'", sessionID, userID)
 	}
 	h.mutex.Unlock()
 }
 
+// stopIfRunning stops the heartbeat of the session, if any, and reports whether it was running.
+func (h *heartbeats) stopIfRunning(userID, sessionID string) bool {
+	heartbeatKey := userID + ":" + sessionID
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	cancel, running := h.cancelations[heartbeatKey]
+	if running {
+		cancel()
+		delete(h.cancelations, heartbeatKey)
+	}
+	if _, batched := h.sessions[heartbeatKey]; batched {
+		delete(h.sessions, heartbeatKey)
+		running = true
+	}
+	return running
+}
+
 func (h *heartbeats) stopAll() {
 	h.mutex.Lock()
 	for _, cancel := range h.cancelations {
 		cancel()
 	}
 	h.cancelations = make(map[string]context.CancelFunc)
+	h.sessions = make(map[string]heartbeatSession)
 	h.mutex.Unlock()
 }
 
+// keys returns the userID:sessionID keys of the running heartbeats, sorted.
+func (h *heartbeats) keys() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keys := slices.Collect(maps.Keys(h.cancelations))
+	keys = slices.AppendSeq(keys, maps.Keys(h.sessions))
+	slices.Sort(keys)
+	return keys
+}
+
+func (h *heartbeats) snapshot() []heartbeatSession {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return slices.Collect(maps.Values(h.sessions))
+}
+
 func (s Status) String() string {
 	switch s {
 	case StatusOffline:
 		return "offline"
 	case StatusOnline:
 		return "online"
+	case StatusAway:
+		return "away"
 	default:
 		return "unknown"
 	}
 }
+
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,920 @@
+package presence
+
+import (
+	"chat/src/clients/nats"
+	"chat/src/clients/nats/natstest"
+	"chat/src/clients/redis"
+	"chat/src/clients/redis/redistest"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	nats2 "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	redis2 "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// hangingDriver is a Redis node which never answers, its commands return once their context is done.
+type hangingDriver struct {
+	redis.Driver
+	called chan struct{}
+	errs   chan error // context error each command returned with
+}
+
+func newHangingDriver() *hangingDriver {
+	return &hangingDriver{called: make(chan struct{}, 16), errs: make(chan error, 16)}
+}
+
+func (d *hangingDriver) hang(ctx context.Context) error {
+	d.called <- struct{}{}
+	<-ctx.Done()
+	d.errs <- ctx.Err()
+	return ctx.Err()
+}
+
+// Pipelined queues the commands as the cluster client does, their error is the one of the context as well.
+func (d *hangingDriver) Pipelined(ctx context.Context, fn func(redis2.Pipeliner) error) ([]redis2.Cmder, error) {
+	node := redis2.NewClient(&redis2.Options{Addr: "127.0.0.1:1"})
+	defer func() { _ = node.Close() }()
+	pipe := node.Pipeline()
+	if err := fn(pipe); err != nil {
+		return nil, err
+	}
+	err := d.hang(ctx)
+	commands, _ := pipe.Exec(ctx) // the context is done, so commands fail with its error without being sent
+	return commands, err
+}
+
+func (d *hangingDriver) Get(ctx context.Context, key string) *redis2.StringCmd {
+	cmd := redis2.NewStringCmd(ctx, "get", key)
+	cmd.SetErr(d.hang(ctx))
+	return cmd
+}
+
+// newTestService returns a service whose caches and lifecycle context are started as by Start, without
+// subscribing to NATS. It's stopped at the end of the test, unless stopped by the returned function before.
+// NATS isn't connected, so presence updates fail to be published and are buffered for retry.
+func newTestService(t testing.TB, driver redis.Driver, configure ...func(*ServiceOptions)) (*Service, func()) {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	options := &ServiceOptions{
+		RedisClient: &redis.Client{Driver: driver},
+		NatsClient:  &nats.Client{},
+		Logger:      &logger,
+	}
+	for _, fn := range configure {
+		fn(options)
+	}
+	service, err := NewService(options)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+
+	service.lifecycleCtx, service.cancelLifecycle = context.WithCancel(context.Background())
+	go service.statusCache.Start()
+	go service.lastSeenCache.Start()
+	var once sync.Once
+	stop := func() { once.Do(func() { service.Stop(context.Background()) }) }
+	t.Cleanup(stop)
+	return service, stop
+}
+
+// newRedisTestService returns a test service backed by the node of redistest, with its scripts loaded.
+func newRedisTestService(t testing.TB, configure ...func(*ServiceOptions)) *Service {
+	t.Helper()
+
+	service, _ := newTestService(t, redistest.NewClient(t).Driver, configure...)
+	if err := service.loadScripts(context.Background()); err != nil {
+		t.Fatalf("loadScripts() error = %v", err)
+	}
+	return service
+}
+
+func newTestSession(startedAt int64) Session {
+	return Session{ReplicaHost: "replica-1", DeviceID: "device", Platform: PlatformWeb, IP: "10.0.0.1", StartedAt: startedAt}
+}
+
+func TestCreateSessionUnderCap(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.MaxSessionsPerUser = 2 })
+	ctx := context.Background()
+
+	for idx, sessionID := range []string{"s1", "s2"} {
+		result, err := service.CreateSession(ctx, "alice", sessionID, newTestSession(int64(idx)))
+		if err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", sessionID, err)
+		}
+		if len(result.Evicted) != 0 {
+			t.Fatalf("CreateSession(%s) evicted %v under cap", sessionID, result.Evicted)
+		}
+	}
+
+	sessions, err := service.ListSessions(ctx, "alice")
+	slices.Sort(sessions)
+	if err != nil || !slices.Equal(sessions, []string{"s1", "s2"}) {
+		t.Fatalf("ListSessions() = (%v, %v), want ([s1 s2], nil)", sessions, err)
+	}
+}
+
+func TestCreateSessionAtCapRejects(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.MaxSessionsPerUser = 2 })
+	ctx := context.Background()
+
+	for idx, sessionID := range []string{"s1", "s2"} {
+		if _, err := service.CreateSession(ctx, "alice", sessionID, newTestSession(int64(idx))); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", sessionID, err)
+		}
+	}
+	if _, err := service.CreateSession(ctx, "alice", "s3", newTestSession(2)); !errors.Is(err, ErrTooManySessions) {
+		t.Fatalf("expected CreateSession at cap to fail with ErrTooManySessions, got: %v", err)
+	}
+
+	if session, err := service.GetSession(ctx, "alice", "s3"); err != nil || session != nil {
+		t.Fatalf("expected rejected session not to be stored, got (%v, %v)", session, err)
+	}
+	if heartbeats := service.ActiveHeartbeats(); !slices.Equal(heartbeats, []string{"alice:s1", "alice:s2"}) {
+		t.Fatalf("expected no heartbeat for the rejected session, got %v", heartbeats)
+	}
+	// the cap is per user
+	if _, err := service.CreateSession(ctx, "bob", "s1", newTestSession(0)); err != nil {
+		t.Fatalf("CreateSession(bob) error = %v", err)
+	}
+}
+
+func TestCreateSessionAtCapEvictsOldest(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) {
+		options.MaxSessionsPerUser = 2
+		options.EvictOldestSession = true
+	})
+	ctx := context.Background()
+
+	// started out of creation order, so the oldest one is s2
+	for sessionID, startedAt := range map[string]int64{"s1": 20, "s2": 10} {
+		if _, err := service.CreateSession(ctx, "alice", sessionID, newTestSession(startedAt)); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", sessionID, err)
+		}
+	}
+	result, err := service.CreateSession(ctx, "alice", "s3", newTestSession(30))
+	if err != nil {
+		t.Fatalf("CreateSession(s3) error = %v", err)
+	}
+	if !slices.Equal(result.Evicted, []string{"s2"}) {
+		t.Fatalf("expected the oldest session to be evicted, got %v", result.Evicted)
+	}
+
+	sessions, err := service.ListSessions(ctx, "alice")
+	slices.Sort(sessions)
+	if err != nil || !slices.Equal(sessions, []string{"s1", "s3"}) {
+		t.Fatalf("ListSessions() = (%v, %v), want ([s1 s3], nil)", sessions, err)
+	}
+	if session, err := service.GetSession(ctx, "alice", "s2"); err != nil || session != nil {
+		t.Fatalf("expected evicted session to be deleted, got (%v, %v)", session, err)
+	}
+	if heartbeats := service.ActiveHeartbeats(); !slices.Equal(heartbeats, []string{"alice:s1", "alice:s3"}) {
+		t.Fatalf("expected the heartbeat of the evicted session to be stopped, got %v", heartbeats)
+	}
+}
+
+func TestCreateSessionCapHoldsUnderConcurrentCreations(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.MaxSessionsPerUser = 3 })
+	ctx := context.Background()
+
+	const creations = 20
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for idx := range creations {
+		wg.Go(func() {
+			_, err := service.CreateSession(ctx, "alice", "s"+strconv.Itoa(idx), newTestSession(int64(idx)))
+			switch {
+			case err == nil:
+				created.Add(1)
+			case !errors.Is(err, ErrTooManySessions):
+				t.Errorf("CreateSession(s%d) error = %v", idx, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if created.Load() != 3 {
+		t.Fatalf("expected exactly 3 of %d concurrent creations to succeed, %d did", creations, created.Load())
+	}
+	if sessions, err := service.ListSessions(ctx, "alice"); err != nil || len(sessions) != 3 {
+		t.Fatalf("ListSessions() = (%v, %v), want 3 sessions", sessions, err)
+	}
+}
+
+func TestCreateSessionRacingAcrossReplicasCreatesItOnce(t *testing.T) {
+	driver := redistest.NewClient(t).Driver
+	replicas := make([]*Service, 2)
+	for idx := range replicas {
+		replicas[idx], _ = newTestService(t, driver)
+		if err := replicas[idx].loadScripts(context.Background()); err != nil {
+			t.Fatalf("loadScripts() error = %v", err)
+		}
+	}
+	ctx := context.Background()
+
+	// a client retry reaching both replicas at once
+	errs := make([]error, len(replicas))
+	var wg sync.WaitGroup
+	for idx, replica := range replicas {
+		wg.Go(func() {
+			session := newTestSession(int64(idx))
+			session.ReplicaHost = "replica-" + strconv.Itoa(idx)
+			_, errs[idx] = replica.CreateSession(ctx, "alice", "s1", session)
+		})
+	}
+	wg.Wait()
+
+	creator := slices.IndexFunc(errs, func(err error) bool { return err == nil })
+	if creator < 0 {
+		t.Fatalf("expected one of the racing creations to succeed, got errors %v", errs)
+	}
+	other := 1 - creator
+	if !errors.Is(errs[other], ErrSessionExists) {
+		t.Fatalf("expected the other racing creation to fail with ErrSessionExists, got: %v", errs[other])
+	}
+	if heartbeats := replicas[creator].ActiveHeartbeats(); !slices.Equal(heartbeats, []string{"alice:s1"}) {
+		t.Fatalf("expected the creating replica to heartbeat the session, got %v", heartbeats)
+	}
+	if heartbeats := replicas[other].ActiveHeartbeats(); len(heartbeats) != 0 {
+		t.Fatalf("expected the other replica not to heartbeat the session, got %v", heartbeats)
+	}
+
+	session, err := replicas[other].GetSession(ctx, "alice", "s1")
+	if err != nil || session == nil {
+		t.Fatalf("GetSession() = (%v, %v), want the session", session, err)
+	}
+	if want := "replica-" + strconv.Itoa(creator); session.ReplicaHost != want {
+		t.Fatalf("expected the session not to be overwritten, got replica host '%s', want '%s'", session.ReplicaHost, want)
+	}
+	if sessions, err := replicas[other].ListSessions(ctx, "alice"); err != nil || !slices.Equal(sessions, []string{"s1"}) {
+		t.Fatalf("ListSessions() = (%v, %v), want ([s1], nil)", sessions, err)
+	}
+}
+
+func TestCreateSessionRetryRefreshesExpiration(t *testing.T) {
+	service := newRedisTestService(t)
+	ctx := context.Background()
+
+	if _, err := service.CreateSession(ctx, "alice", "s1", newTestSession(0)); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	sessionKey := fmt.Sprintf(sessionKeyFormat, "alice", "s1")
+	if _, err := service.redis.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		return pipe.Expire(ctx, sessionKey, 5*time.Second).Err()
+	}); err != nil {
+		t.Fatalf("Expire() error = %v", err)
+	}
+
+	if _, err := service.CreateSession(ctx, "alice", "s1", newTestSession(1)); !errors.Is(err, ErrSessionExists) {
+		t.Fatalf("expected the retried creation to fail with ErrSessionExists, got: %v", err)
+	}
+	var ttl *redis2.DurationCmd
+	if _, err := service.redis.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		ttl = pipe.TTL(ctx, sessionKey)
+		return nil
+	}); err != nil {
+		t.Fatalf("TTL() error = %v", err)
+	}
+	if ttl.Val() <= 5*time.Second {
+		t.Fatalf("expected the retried creation to refresh the session expiration, got TTL %v", ttl.Val())
+	}
+	if heartbeats := service.ActiveHeartbeats(); !slices.Equal(heartbeats, []string{"alice:s1"}) {
+		t.Fatalf("expected a single heartbeat of the session, got %v", heartbeats)
+	}
+}
+
+func TestCreateSessionReportsBecameOnlineOnlyForFirstSession(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.MaxSessionsPerUser = 2 })
+	ctx := context.Background()
+
+	steps := []struct {
+		name             string
+		sessionID        string
+		remove           string // session deleted before the creation
+		expire           string // session whose key expires before the creation, it stays listed
+		wantBecameOnline bool
+		wantErr          error
+	}{
+		{name: "first session", sessionID: "s1", wantBecameOnline: true},
+		{name: "another device", sessionID: "s2"},
+		{name: "existing session", sessionID: "s2", wantErr: ErrSessionExists},
+		{name: "session over cap", sessionID: "s3", wantErr: ErrTooManySessions},
+		{name: "one session left", sessionID: "s3", remove: "s1"},
+		{name: "all sessions deleted", sessionID: "s4", remove: "s2", expire: "s3", wantBecameOnline: true},
+	}
+	for _, step := range steps {
+		if step.remove != "" {
+			if err := service.DeleteSession(ctx, "alice", step.remove); err != nil {
+				t.Fatalf("%s: DeleteSession(%s) error = %v", step.name, step.remove, err)
+			}
+		}
+		if step.expire != "" {
+			if _, err := service.redis.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+				return pipe.Del(ctx, fmt.Sprintf(sessionKeyFormat, "alice", step.expire)).Err()
+			}); err != nil {
+				t.Fatalf("%s: Del() error = %v", step.name, err)
+			}
+		}
+
+		result, err := service.CreateSession(ctx, "alice", step.sessionID, newTestSession(0))
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: CreateSession(%s) error = %v, want %v", step.name, step.sessionID, err, step.wantErr)
+		}
+		if result.BecameOnline != step.wantBecameOnline {
+			t.Fatalf("%s: CreateSession(%s) BecameOnline = %t, want %t", step.name, step.sessionID, result.BecameOnline, step.wantBecameOnline)
+		}
+	}
+}
+
+func TestCreateSessionBringsUserOnlineOnceUnderConcurrentCreations(t *testing.T) {
+	service := newRedisTestService(t, func(options *ServiceOptions) { options.MaxSessionsPerUser = 20 })
+	ctx := context.Background()
+
+	const creations = 20
+	var becameOnline atomic.Int32
+	var wg sync.WaitGroup
+	for idx := range creations {
+		wg.Go(func() {
+			result, err := service.CreateSession(ctx, "alice", "s"+strconv.Itoa(idx), newTestSession(int64(idx)))
+			if err != nil {
+				t.Errorf("CreateSession(s%d) error = %v", idx, err)
+			}
+			if result.BecameOnline {
+				becameOnline.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	if becameOnline.Load() != 1 {
+		t.Fatalf("expected exactly one of %d concurrent creations to bring the user online, %d did", creations, becameOnline.Load())
+	}
+}
+
+func TestLoadersAbortInFlightCallsOnStop(t *testing.T) {
+	loads := map[string]func(service *Service) error{
+		"status": func(service *Service) error {
+			_, err := service.Status("alice")
+			return err
+		},
+		"last seen": func(service *Service) error {
+			_, err := service.LastSeen("alice")
+			return err
+		},
+		"status multi": func(service *Service) error {
+			if statuses := service.StatusMulti([]string{"alice", "bob"}); len(statuses) != 0 {
+				return errors.New("statuses were loaded")
+			}
+			return ErrCacheMiss
+		},
+	}
+
+	for name, load := range loads {
+		t.Run(name, func(t *testing.T) {
+			driver := newHangingDriver()
+			service, stop := newTestService(t, driver)
+
+			loaded := make(chan error, 1)
+			go func() { loaded <- load(service) }()
+
+			select {
+			case <-driver.called:
+			case <-time.After(time.Second):
+				t.Fatal("loader didn't call Redis")
+			}
+			stop()
+
+			select {
+			case err := <-driver.errs:
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected in-flight Redis call to be canceled by Stop, it ended with: %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("in-flight Redis call wasn't aborted by Stop")
+			}
+			if err := <-loaded; !errors.Is(err, ErrCacheMiss) {
+				t.Fatalf("expected an aborted load to be a cache miss, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadersKeepPerLoadTimeout(t *testing.T) {
+	driver := newHangingDriver()
+	service, _ := newTestService(t, driver)
+
+	start := time.Now()
+	status, err := service.Status("alice")
+	if !errors.Is(err, ErrUnavailable) || status != StatusUnknown {
+		t.Fatalf("expected a timed out load to report the status as unknown, got (%s, %v)", status, err)
+	}
+	if elapsed := time.Since(start); elapsed < presenceStatusCacheLoaderTimeout || elapsed > time.Second {
+		t.Fatalf("expected the load to time out after %v, it took %v", presenceStatusCacheLoaderTimeout, elapsed)
+	}
+	if err := <-driver.errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the Redis call to hit the per-load deadline, it ended with: %v", err)
+	}
+}
+
+// failingDriver is a Redis node whose pipelines fail with err.
+type failingDriver struct {
+	redis.Driver
+	err error
+}
+
+func (d *failingDriver) Pipelined(context.Context, func(redis2.Pipeliner) error) ([]redis2.Cmder, error) {
+	return nil, d.err
+}
+
+func TestStatusTimeoutIsCachedBriefly(t *testing.T) {
+	driver := newHangingDriver()
+	service, _ := newTestService(t, driver)
+
+	if status, err := service.Status("alice"); !errors.Is(err, ErrUnavailable) || status != StatusUnknown {
+		t.Fatalf("Status() = (%s, %v), want (%s, %v)", status, err, StatusUnknown, ErrUnavailable)
+	}
+	<-driver.called
+
+	item := service.statusCache.Get("alice", ttlcache.WithLoader[string, Status](nil))
+	if item == nil || item.Value() != StatusUnknown || item.TTL() != presenceUnknownStatusTTL {
+		t.Fatalf("cached item = %v, want %s cached for %v", item, StatusUnknown, presenceUnknownStatusTTL)
+	}
+	// served from the cache until it expires, without waiting for Redis again
+	if status, err := service.Status("alice"); !errors.Is(err, ErrUnavailable) || status != StatusUnknown {
+		t.Fatalf("Status() = (%s, %v), want (%s, %v)", status, err, StatusUnknown, ErrUnavailable)
+	}
+	select {
+	case <-driver.called:
+		t.Fatal("expected the unknown status to be served from the cache")
+	default:
+	}
+}
+
+func TestStatusFailureIsCacheMiss(t *testing.T) {
+	service, _ := newTestService(t, &failingDriver{err: errors.New("dial tcp: connection refused")})
+
+	if _, err := service.Status("alice"); !errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Status() error = %v, want a cache miss", err)
+	}
+	if item := service.statusCache.Get("alice", ttlcache.WithLoader[string, Status](nil)); item != nil {
+		t.Fatalf("expected nothing to be cached on failure, got %v", item.Value())
+	}
+	if statuses := service.StatusMulti([]string{"alice", "bob"}); len(statuses) != 0 {
+		t.Fatalf("StatusMulti() = %v, want the users to be missing", statuses)
+	}
+}
+
+func TestStatusMultiReportsUnknownOnTimeout(t *testing.T) {
+	driver := newHangingDriver()
+	service, _ := newTestService(t, driver)
+	service.statusCache.Set("carol", StatusOnline, ttlcache.DefaultTTL)
+
+	statuses := service.StatusMulti([]string{"alice", "bob", "carol"})
+	want := map[string]Status{"alice": StatusUnknown, "bob": StatusUnknown, "carol": StatusOnline}
+	if !maps.Equal(statuses, want) {
+		t.Fatalf("StatusMulti() = %v, want %v", statuses, want)
+	}
+	if status, err := service.Status("bob"); !errors.Is(err, ErrUnavailable) || status != StatusUnknown {
+		t.Fatalf("Status() = (%s, %v), want the unknown status to be cached", status, err)
+	}
+}
+
+func TestJetStreamSubscriberRecoversMissedUpdates(t *testing.T) {
+	natsClient := natstest.NewClient(t)
+	ctx := context.Background()
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	streamName := "PRESENCE_" + suffix
+	withJetStream := func(consumerName string) func(*ServiceOptions) {
+		return func(options *ServiceOptions) {
+			options.NatsClient = natsClient
+			options.SubjectPrefix = "test." + suffix
+			options.JetStream = JetStreamOptions{
+				Enabled: true, StreamName: streamName, Retention: time.Minute, ConsumerName: consumerName,
+			}
+		}
+	}
+	t.Cleanup(func() {
+		if js, err := jetstream.New(natsClient.Driver); err == nil {
+			_ = js.DeleteStream(context.Background(), streamName)
+		}
+	})
+
+	publisher, _ := newTestService(t, nil, withJetStream("publisher"))
+	if err := publisher.subscribeJetStream(ctx); err != nil {
+		t.Fatalf("subscribeJetStream(publisher) error = %v", err)
+	}
+	subscriber, stopSubscriber := newTestService(t, nil, withJetStream("subscriber"))
+	if err := subscriber.subscribeJetStream(ctx); err != nil {
+		t.Fatalf("subscribeJetStream(subscriber) error = %v", err)
+	}
+	stopSubscriber() // the replica goes down, its durable consumer is kept by the server
+
+	publisher.publishPresenceUpdate("alice", "s1", StatusOnline)
+	publisher.publishPresenceUpdate("bob", "s1", StatusOnline)
+	publisher.publishPresenceUpdate("bob", "s1", StatusOffline)
+	select {
+	case <-publisher.jetStream.driver.PublishAsyncComplete():
+	case <-time.After(5 * time.Second):
+		t.Fatal("presence updates weren't acknowledged by JetStream")
+	}
+
+	restarted, _ := newTestService(t, nil, withJetStream("subscriber"))
+	if err := restarted.subscribeJetStream(ctx); err != nil {
+		t.Fatalf("subscribeJetStream(restarted subscriber) error = %v", err)
+	}
+	waitForCachedStatus(t, restarted, "alice", StatusOnline)
+	waitForCachedStatus(t, restarted, "bob", StatusOffline)
+}
+
+// waitForCachedStatus waits for the status of the user to be cached, without loading it from Redis.
+func waitForCachedStatus(t *testing.T, service *Service, userID string, status Status) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		item := service.statusCache.Get(userID, ttlcache.WithLoader[string, Status](nil))
+		if item != nil && item.Value() == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status of user '%s' wasn't updated to %s, cached item: %v", userID, status, item)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPresenceSubjectIsPrefixed(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{prefix: "", want: "user.presence.updates"},
+		{prefix: "chat.prod", want: "chat.prod.user.presence.updates"},
+		{prefix: "chat.staging.", want: "chat.staging.user.presence.updates"},
+		{prefix: "chat..prod", wantErr: true},
+		{prefix: ".chat", wantErr: true},
+		{prefix: "chat.*", wantErr: true},
+		{prefix: "chat.>", wantErr: true},
+		{prefix: "chat prod", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			subject, err := presenceSubject(tt.prefix)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("presenceSubject(%q) = %q, want an error", tt.prefix, subject)
+				}
+				return
+			}
+			if err != nil || subject != tt.want {
+				t.Fatalf("presenceSubject(%q) = (%q, %v), want (%q, nil)", tt.prefix, subject, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewServiceRejectsInvalidSubjectPrefix(t *testing.T) {
+	logger := zerolog.Nop()
+	_, err := NewService(&ServiceOptions{
+		RedisClient:   &redis.Client{},
+		NatsClient:    &nats.Client{},
+		SubjectPrefix: "chat.*",
+		Logger:        &logger,
+	})
+	if err == nil || !strings.Contains(err.Error(), "subject prefix 'chat.*' has invalid token '*'") {
+		t.Fatalf("expected a subject prefix with a wildcard to be rejected, got: %v", err)
+	}
+}
+
+func TestServicesWithDifferentSubjectPrefixesDontReceiveEachOthersUpdates(t *testing.T) {
+	natsClient := natstest.NewClient(t)
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	withPrefix := func(prefix string) func(*ServiceOptions) {
+		return func(options *ServiceOptions) {
+			options.NatsClient = natsClient
+			options.SubjectPrefix = prefix + "." + suffix
+		}
+	}
+	// subscribes to the updates subject as Start does, the test client can't register reconnect hooks
+	subscribe := func(service *Service) {
+		subscription, err := natsClient.Driver.Subscribe(service.subject, func(msg *nats2.Msg) {
+			service.handlePresenceUpdate(msg.Data)
+		})
+		if err != nil {
+			t.Fatalf("Subscribe(%s) error = %v", service.subject, err)
+		}
+		t.Cleanup(func() { _ = subscription.Unsubscribe() })
+	}
+
+	staging, _ := newTestService(t, nil, withPrefix("staging"))
+	stagingReplica, _ := newTestService(t, nil, withPrefix("staging"))
+	prod, _ := newTestService(t, nil, withPrefix("prod"))
+	for _, service := range []*Service{staging, stagingReplica, prod} {
+		subscribe(service)
+	}
+	if err := natsClient.Driver.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	staging.publishPresenceUpdate("alice", "s1", StatusOnline)
+	prod.publishPresenceUpdate("bob", "s1", StatusOnline)
+
+	waitForCachedStatus(t, stagingReplica, "alice", StatusOnline)
+	waitForCachedStatus(t, prod, "bob", StatusOnline)
+	if item := prod.statusCache.Get("alice", ttlcache.WithLoader[string, Status](nil)); item != nil {
+		t.Fatalf("expected the prod service not to receive the staging update, cached %v", item.Value())
+	}
+	if item := stagingReplica.statusCache.Get("bob", ttlcache.WithLoader[string, Status](nil)); item != nil {
+		t.Fatalf("expected the staging service not to receive the prod update, cached %v", item.Value())
+	}
+}
+
+func TestStatusMultiReturnsCachedStatusesWithoutRedis(t *testing.T) {
+	driver := newHangingDriver()
+	service, _ := newTestService(t, driver)
+	service.statusCache.Set("alice", StatusOnline, ttlcache.DefaultTTL)
+	service.statusCache.Set("bob", StatusOffline, ttlcache.DefaultTTL)
+
+	statuses := service.StatusMulti([]string{"alice", "bob"})
+	if len(statuses) != 2 || statuses["alice"] != StatusOnline || statuses["bob"] != StatusOffline {
+		t.Fatalf("StatusMulti() = %v, want the cached statuses", statuses)
+	}
+	select {
+	case <-driver.called:
+		t.Fatal("StatusMulti() called Redis while all statuses were cached")
+	default:
+	}
+}
+
+func TestStatusMultiLoadsMixedOnlineAndOfflineUsers(t *testing.T) {
+	service := newRedisTestService(t)
+	ctx := context.Background()
+
+	if _, err := service.CreateSession(ctx, "alice", "s1", newTestSession(0)); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	service.statusCache.Delete("alice") // loaded from Redis below
+	service.statusCache.Set("carol", StatusAway, ttlcache.DefaultTTL)
+
+	statuses := service.StatusMulti([]string{"alice", "bob", "carol"})
+	want := map[string]Status{"alice": StatusOnline, "bob": StatusOffline, "carol": StatusAway}
+	if len(statuses) != len(want) {
+		t.Fatalf("StatusMulti() = %v, want %v", statuses, want)
+	}
+	for userID, status := range want {
+		if statuses[userID] != status {
+			t.Errorf("StatusMulti()[%s] = %s, want %s", userID, statuses[userID], status)
+		}
+	}
+	if item := service.statusCache.Get("bob", ttlcache.WithLoader[string, Status](nil)); item == nil || item.Value() != StatusOffline {
+		t.Errorf("expected the loaded status of bob to be cached, got %v", item)
+	}
+}
+
+func TestPanickingHeartbeatIsLoggedAndDoesNotCrash(t *testing.T) {
+	lines := make(chan string, 10)
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: chanWriter(lines), NoColor: true})
+	beats := &heartbeats{cancelations: make(map[string]context.CancelFunc), logger: &logger}
+
+	beats.start("alice", "session-1", func(context.Context, string, string) { panic("heartbeat failed") })
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, "panic recovered in goroutine 'presence.heartbeat'") {
+			t.Fatalf("unexpected log line: %s", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the panic of the heartbeat to be logged")
+	}
+
+	// the other heartbeats keep running
+	beat := make(chan string, 1)
+	beats.start("bob", "session-2", func(_ context.Context, userID, _ string) { beat <- userID })
+	select {
+	case userID := <-beat:
+		if userID != "bob" {
+			t.Fatalf("heartbeat of user %q, want %q", userID, "bob")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the heartbeat to run after the panic of another one")
+	}
+	beats.stop("alice", "session-1")
+	beats.stop("bob", "session-2")
+}
+
+// chanWriter hands each log line written by the logger to a channel.
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+// countingDriver is a Redis node holding the loads of presence until released. Only alice has sessions, users were
+// last seen at lastSeen, unless err is set, in which case the loads fail with it.
+type countingDriver struct {
+	redis.Driver
+	err       error
+	lastSeen  int64
+	called    chan struct{}
+	release   chan struct{}
+	pipelines atomic.Int32
+	gets      atomic.Int32
+}
+
+func newCountingDriver() *countingDriver {
+	return &countingDriver{lastSeen: 42, called: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+// existsPipeliner answers EXISTS as if only the keys were stored.
+type existsPipeliner struct {
+	redis2.Pipeliner
+	keys []string
+}
+
+func (p existsPipeliner) Exists(ctx context.Context, keys ...string) *redis2.IntCmd {
+	cmd := redis2.NewIntCmd(ctx, "exists", keys)
+	for _, key := range keys {
+		if slices.Contains(p.keys, key) {
+			cmd.SetVal(cmd.Val() + 1)
+		}
+	}
+	return cmd
+}
+
+func (d *countingDriver) Pipelined(ctx context.Context, fn func(redis2.Pipeliner) error) ([]redis2.Cmder, error) {
+	d.pipelines.Add(1)
+	d.called <- struct{}{}
+	<-d.release
+	if d.err != nil {
+		return nil, d.err
+	}
+	return nil, fn(existsPipeliner{keys: []string{fmt.Sprintf(sessionListKeyFormat, "alice")}})
+}
+
+func (d *countingDriver) Get(ctx context.Context, key string) *redis2.StringCmd {
+	d.gets.Add(1)
+	d.called <- struct{}{}
+	<-d.release
+	cmd := redis2.NewStringCmd(ctx, "get", key)
+	if d.err != nil {
+		cmd.SetErr(d.err)
+	} else {
+		cmd.SetVal(strconv.FormatInt(d.lastSeen, 10))
+	}
+	return cmd
+}
+
+// loadConcurrently calls load from many goroutines at once, releasing the driver once they all had the chance to
+// miss the cache.
+func loadConcurrently(t *testing.T, driver *countingDriver, load func()) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(load)
+	}
+	select {
+	case <-driver.called:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Redis to be called")
+	}
+	time.Sleep(50 * time.Millisecond) // the other loads are waiting on the first one by now
+	close(driver.release)
+	wg.Wait()
+}
+
+func TestConcurrentStatusMissesCallRedisOnce(t *testing.T) {
+	driver := newCountingDriver()
+	service, _ := newTestService(t, driver)
+
+	var unexpected atomic.Int32
+	loadConcurrently(t, driver, func() {
+		if status, err := service.Status("alice"); err != nil || status != StatusOnline {
+			unexpected.Add(1)
+		}
+	})
+	if got := unexpected.Load(); got != 0 {
+		t.Fatalf("%d Status() calls didn't report the user as online", got)
+	}
+	if got := driver.pipelines.Load(); got != 1 {
+		t.Fatalf("Redis called %d times, want once", got)
+	}
+}
+
+func TestConcurrentLastSeenMissesCallRedisOnce(t *testing.T) {
+	driver := newCountingDriver()
+	service, _ := newTestService(t, driver)
+
+	var unexpected atomic.Int32
+	loadConcurrently(t, driver, func() {
+		if lastSeen, err := service.LastSeen("alice"); err != nil || lastSeen != driver.lastSeen {
+			unexpected.Add(1)
+		}
+	})
+	if got := unexpected.Load(); got != 0 {
+		t.Fatalf("%d LastSeen() calls didn't report the last seen time", got)
+	}
+	if got := driver.gets.Load(); got != 1 {
+		t.Fatalf("Redis called %d times, want once", got)
+	}
+}
+
+func TestConcurrentFailedLoadsAreNotCached(t *testing.T) {
+	driver := newCountingDriver()
+	driver.err = errors.New("dial tcp: connection refused")
+	service, _ := newTestService(t, driver)
+
+	var misses atomic.Int32
+	loadConcurrently(t, driver, func() {
+		if _, err := service.Status("alice"); errors.Is(err, ErrCacheMiss) {
+			misses.Add(1)
+		}
+	})
+	if got := misses.Load(); got != 20 {
+		t.Fatalf("%d Status() calls reported a cache miss, want all of them", got)
+	}
+
+	// the next miss calls Redis again, the failure wasn't cached
+	if _, err := service.Status("alice"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Status() error = %v, want a cache miss", err)
+	}
+	if got := driver.pipelines.Load(); got != 2 {
+		t.Fatalf("Redis called %d times, want the failed load to be retried by the next miss", got)
+	}
+}
+
+func TestReconcileStatusCacheRevalidatesAgainstRedis(t *testing.T) {
+	driver := newCountingDriver()
+	close(driver.release)
+	service, _ := newTestService(t, driver)
+	// bob went offline while NATS was disconnected, the update was missed
+	service.statusCache.Set("alice", StatusOnline, ttlcache.DefaultTTL)
+	service.statusCache.Set("bob", StatusOnline, ttlcache.DefaultTTL)
+
+	start := time.Now()
+	service.reconcileStatusCache()
+	for _, userID := range []string{"alice", "bob"} {
+		item := service.statusCache.Get(userID, ttlcache.WithLoader[string, Status](nil))
+		if item == nil {
+			continue // already expired
+		}
+		if expiresIn := item.ExpiresAt().Sub(start); expiresIn > presenceReconcileSpread+time.Millisecond {
+			t.Fatalf("status of %s expires in %v, want it within %v", userID, expiresIn, presenceReconcileSpread)
+		}
+	}
+	if got := driver.pipelines.Load(); got != 0 {
+		t.Fatalf("Redis called %d times by the reconciliation, want the statuses to be reloaded lazily", got)
+	}
+
+	time.Sleep(presenceReconcileSpread + 10*time.Millisecond)
+	if status, err := service.Status("bob"); err != nil || status != StatusOffline {
+		t.Fatalf("Status(bob) = (%s, %v), want the stale status to be reloaded as offline", status, err)
+	}
+	if status, err := service.Status("alice"); err != nil || status != StatusOnline {
+		t.Fatalf("Status(alice) = (%s, %v), want %s", status, err, StatusOnline)
+	}
+	if got := driver.pipelines.Load(); got != 2 {
+		t.Fatalf("Redis called %d times, want each status to be reloaded once", got)
+	}
+}
+
+func TestReconcileStatusCacheSpreadsExpirations(t *testing.T) {
+	service, _ := newTestService(t, newCountingDriver())
+	for idx := range 100 {
+		service.statusCache.Set("user-"+strconv.Itoa(idx), StatusOnline, ttlcache.DefaultTTL)
+	}
+	// expiring sooner than the spread anyway, so it's left as is
+	service.statusCache.Set("carol", StatusAway, 500*time.Millisecond)
+	carolExpiresAt := service.statusCache.Get("carol", ttlcache.WithLoader[string, Status](nil)).ExpiresAt()
+
+	service.reconcileStatusCache()
+
+	expirations := make(map[time.Time]struct{})
+	for idx := range 100 {
+		if item := service.statusCache.Get("user-"+strconv.Itoa(idx), ttlcache.WithLoader[string, Status](nil)); item != nil {
+			expirations[item.ExpiresAt()] = struct{}{}
+		}
+	}
+	if len(expirations) < 50 {
+		t.Fatalf("statuses expire at %d distinct times, want them spread to avoid reloading all at once", len(expirations))
+	}
+	item := service.statusCache.Get("carol", ttlcache.WithLoader[string, Status](nil))
+	if item == nil || !item.ExpiresAt().Equal(carolExpiresAt) {
+		t.Fatal("expected the status expiring within the spread to be left as is")
+	}
+}
@@ -0,0 +1,85 @@
+package presence
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	redis2 "github.com/redis/go-redis/v9"
+)
+
+// PresenceSnapshot is the full presence state of a user, read from Redis rather than from the caches, i.e. for
+// clients which reconnect and need to rebuild their view of the user at once.
+type PresenceSnapshot struct {
+	UserID       string
+	Status       Status
+	Sessions     []SessionSummary // live sessions, oldest first, empty when offline
+	SessionCount int
+	LastSeen     int64 // unix millis of the last session end, 0 while online or when unknown
+}
+
+type SessionSummary struct {
+	ID string
+	Session
+}
+
+// Snapshot reads the presence state of the user in two round-trips: the session list, last seen and away marker
+// first, then the hashes of the listed sessions. Listed sessions whose hash expired meanwhile are left out.
+func (s *Service) Snapshot(ctx context.Context, userID string) (PresenceSnapshot, error) {
+	snapshot := PresenceSnapshot{UserID: userID, Status: StatusOffline, Sessions: make([]SessionSummary, 0)}
+
+	var sessionsCmd *redis2.StringSliceCmd
+	var lastSeenCmd *redis2.StringCmd
+	var awayCmd *redis2.IntCmd
+	_, err := s.redis.WithPipeline(ctx, func(pipe redis2.Pipeliner) error {
+		sessionsCmd = pipe.SMembers(ctx, fmt.Sprintf(sessionListKeyFormat, userID))
+		lastSeenCmd = pipe.Get(ctx, fmt.Sprintf(lastSeenKeyFormat, userID))
+		awayCmd = pipe.Exists(ctx, fmt.Sprintf(awayKeyFormat, userID))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis2.Nil) {
+		return snapshot, fmt.Errorf("read presence of user '%s' failed: %w", userID, err)
+	}
+
+	if value, err := lastSeenCmd.Result(); err == nil {
+		if lastSeen, err := strconv.ParseInt(value, 10, 64); err == nil {
+			snapshot.LastSeen = lastSeen
+		} else {
+			s.logger.Warn().Msgf("redis contains invalid last seen value for user '%s': %s", userID, value)
+		}
+	}
+
+	sessionIDs := sessionsCmd.Val()
+	if len(sessionIDs) > 0 {
+		sessionCmds := make([]*redis2.MapStringStringCmd, len(sessionIDs))
+		_, err = s.redis.WithPipeline(ctx, func(pipe redis2.Pipeliner) error {
+			for idx, sessionID := range sessionIDs {
+				sessionCmds[idx] = pipe.HGetAll(ctx, fmt.Sprintf(sessionKeyFormat, userID, sessionID))
+			}
+			return nil
+		})
+		if err != nil {
+			return snapshot, fmt.Errorf("read sessions of user '%s' failed: %w", userID, err)
+		}
+
+		for idx, sessionID := range sessionIDs {
+			if data := sessionCmds[idx].Val(); len(data) > 0 {
+				snapshot.Sessions = append(snapshot.Sessions, SessionSummary{
+					ID:      sessionID,
+					Session: *s.parseSession(userID, sessionID, data),
+				})
+			}
+		}
+		slices.SortFunc(snapshot.Sessions, func(a, b SessionSummary) int { return cmp.Compare(a.StartedAt, b.StartedAt) })
+	}
+
+	snapshot.SessionCount = len(snapshot.Sessions)
+	snapshot.Status = statusOf(snapshot.SessionCount > 0, awayCmd.Val() == 1)
+	if snapshot.Status != StatusOffline {
+		snapshot.LastSeen = 0
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,138 @@
+package presence
+
+import (
+	"chat/src/clients/redis"
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	redis2 "github.com/redis/go-redis/v9"
+)
+
+// pipelineCounter counts the pipelines run on the driver, i.e. the round-trips of a read.
+type pipelineCounter struct {
+	redis.Driver
+	pipelines atomic.Int64
+}
+
+func (d *pipelineCounter) Pipelined(ctx context.Context, fn func(redis2.Pipeliner) error) ([]redis2.Cmder, error) {
+	d.pipelines.Add(1)
+	return d.Driver.Pipelined(ctx, fn)
+}
+
+// countPipelines makes the service count the pipelines it runs from now on.
+func countPipelines(service *Service) *pipelineCounter {
+	counter := &pipelineCounter{Driver: service.redis.Driver}
+	service.redis.Driver = counter
+	return counter
+}
+
+func sessionIDsOf(snapshot PresenceSnapshot) []string {
+	ids := make([]string, 0, len(snapshot.Sessions))
+	for _, session := range snapshot.Sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+func TestSnapshotOfMultiSessionUser(t *testing.T) {
+	service := newRedisTestService(t)
+	ctx := context.Background()
+	for _, session := range []struct {
+		id        string
+		startedAt int64
+	}{{id: "s1", startedAt: 30}, {id: "s2", startedAt: 10}, {id: "s3", startedAt: 20}} {
+		if _, err := service.CreateSession(ctx, "alice", session.id, newTestSession(session.startedAt)); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", session.id, err)
+		}
+	}
+	counter := countPipelines(service)
+
+	snapshot, err := service.Snapshot(ctx, "alice")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if snapshot.UserID != "alice" || snapshot.Status != StatusOnline || snapshot.SessionCount != 3 || snapshot.LastSeen != 0 {
+		t.Fatalf("Snapshot() = %+v, want alice online with 3 sessions and no last seen", snapshot)
+	}
+	if ids := sessionIDsOf(snapshot); !slices.Equal(ids, []string{"s2", "s3", "s1"}) {
+		t.Errorf("Snapshot() sessions = %v, want them oldest first: [s2 s3 s1]", ids)
+	}
+	if session := snapshot.Sessions[0]; session.ReplicaHost != "replica-1" || session.Platform != PlatformWeb || session.StartedAt != 10 {
+		t.Errorf("Snapshot() session s2 = %+v, want the fields it was created with", session.Session)
+	}
+	if pipelines := counter.pipelines.Load(); pipelines != 2 {
+		t.Errorf("Snapshot() ran %d pipelines, want 2 round-trips", pipelines)
+	}
+}
+
+func TestSnapshotOfAwayUserLeavesOutExpiredSessions(t *testing.T) {
+	service := newRedisTestService(t)
+	ctx := context.Background()
+	for _, sessionID := range []string{"s1", "s2"} {
+		if _, err := service.CreateSession(ctx, "alice", sessionID, newTestSession(0)); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", sessionID, err)
+		}
+	}
+	// the hash of s2 expired while it's still listed, and the sessions went idle
+	if _, err := service.redis.Driver.Pipelined(ctx, func(pipe redis2.Pipeliner) error {
+		pipe.Del(ctx, fmt.Sprintf(sessionKeyFormat, "alice", "s2"))
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to expire session: %v", err)
+	}
+	if err := service.redis.Driver.Set(ctx, fmt.Sprintf(awayKeyFormat, "alice"), "1", time.Minute).Err(); err != nil {
+		t.Fatalf("failed to mark away: %v", err)
+	}
+
+	snapshot, err := service.Snapshot(ctx, "alice")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if snapshot.Status != StatusAway || snapshot.SessionCount != 1 || !slices.Equal(sessionIDsOf(snapshot), []string{"s1"}) {
+		t.Fatalf("Snapshot() = %+v, want alice away with the live session s1 only", snapshot)
+	}
+}
+
+func TestSnapshotOfOfflineUser(t *testing.T) {
+	service := newRedisTestService(t)
+	ctx := context.Background()
+	if _, err := service.CreateSession(ctx, "alice", "s1", newTestSession(0)); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	before := time.Now().UnixMilli()
+	if err := service.DeleteSession(ctx, "alice", "s1"); err != nil {
+		t.Fatalf("DeleteSession() error = %v", err)
+	}
+	counter := countPipelines(service)
+
+	tests := []struct {
+		name         string
+		userID       string
+		wantLastSeen bool
+	}{
+		{name: "after last session ended", userID: "alice", wantLastSeen: true},
+		{name: "never seen", userID: "bob", wantLastSeen: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter.pipelines.Store(0)
+			snapshot, err := service.Snapshot(ctx, tt.userID)
+			if err != nil {
+				t.Fatalf("Snapshot() error = %v", err)
+			}
+			if snapshot.Status != StatusOffline || snapshot.SessionCount != 0 || snapshot.Sessions == nil || len(snapshot.Sessions) != 0 {
+				t.Fatalf("Snapshot() = %+v, want the user offline with an empty list of sessions", snapshot)
+			}
+			if tt.wantLastSeen != (snapshot.LastSeen >= before) {
+				t.Errorf("Snapshot() last seen = %d, want it set: %t", snapshot.LastSeen, tt.wantLastSeen)
+			}
+			if pipelines := counter.pipelines.Load(); pipelines != 1 {
+				t.Errorf("Snapshot() ran %d pipelines, want a single round-trip without sessions", pipelines)
+			}
+		})
+	}
+}
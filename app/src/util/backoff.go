@@ -0,0 +1,18 @@
+package util
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ExponentialBackoff returns the delay before the given retry attempt: base doubled for each attempt, capped to
+// maxDelay, with +/- 20% jitter so retrying clients don't synchronize.
+func ExponentialBackoff(attempt int, base, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if attempt < 63 && base<<attempt > 0 {
+		delay = min(base<<attempt, maxDelay)
+	}
+
+	jitter := time.Duration(rand.Float64() * float64(delay.Nanoseconds()) * 0.4) //nolint:gosec // 40% range
+	return delay - (delay / 5) + jitter                                          // Apply -20% offset and add jitter up to +20%
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoffDoublesUpToCap(t *testing.T) {
+	const (
+		base     = 100 * time.Millisecond
+		maxDelay = 5 * time.Second
+	)
+	tests := []struct {
+		attempt int
+		delay   time.Duration // before jitter
+	}{
+		{attempt: 0, delay: 100 * time.Millisecond},
+		{attempt: 1, delay: 200 * time.Millisecond},
+		{attempt: 3, delay: 800 * time.Millisecond},
+		{attempt: 6, delay: maxDelay},
+		{attempt: 40, delay: maxDelay},
+		{attempt: 63, delay: maxDelay},
+		{attempt: 1000, delay: maxDelay},
+	}
+
+	for _, tt := range tests {
+		for range 100 {
+			got := ExponentialBackoff(tt.attempt, base, maxDelay)
+			if low, high := tt.delay*4/5, tt.delay*6/5; got < low || got > high {
+				t.Fatalf("ExponentialBackoff(%d) = %v, want within [%v, %v]", tt.attempt, got, low, high)
+			}
+		}
+	}
+}
+
+func TestExponentialBackoffIsJittered(t *testing.T) {
+	seen := make(map[time.Duration]struct{})
+	for range 100 {
+		seen[ExponentialBackoff(10, 100*time.Millisecond, 5*time.Second)] = struct{}{}
+	}
+	if len(seen) < 50 {
+		t.Fatalf("ExponentialBackoff() returned %d distinct delays out of 100, want them jittered", len(seen))
+	}
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// InvalidEmailError reports an address NormalizeEmail can't normalize, it matches ErrInvalidEmail with errors.Is.
+type InvalidEmailError struct {
+	Address string
+	Reason  string
+}
+
+func (e *InvalidEmailError) Error() string {
+	return fmt.Sprintf("invalid email address '%s': %s", e.Address, e.Reason)
+}
+
+func (e *InvalidEmailError) Is(target error) bool {
+	return target == ErrInvalidEmail
+}
+
+// NormalizeEmail returns the canonical form of an address, so the same mailbox always compares equal, i.e. in
+// allowlists: spaces are trimmed, a display name is dropped, and the domain is lowercased and IDNA encoded.
+// The local part is kept as is, its case may be significant to the receiving server.
+func NormalizeEmail(addr string) (string, error) {
+	parsed, err := netmail.ParseAddress(strings.TrimSpace(addr))
+	if err != nil {
+		return "", &InvalidEmailError{Address: addr, Reason: err.Error()}
+	}
+
+	at := strings.LastIndexByte(parsed.Address, '@')
+	if at <= 0 || at == len(parsed.Address)-1 {
+		return "", &InvalidEmailError{Address: addr, Reason: "missing local part or domain"}
+	}
+
+	domain, err := NormalizeEmailDomain(parsed.Address[at+1:])
+	if err != nil {
+		return "", &InvalidEmailError{Address: addr, Reason: err.Error()}
+	}
+	return parsed.Address[:at] + "@" + domain, nil
+}
+
+// NormalizeEmailDomain lowercases and IDNA encodes the domain of an address, as NormalizeEmail does.
+func NormalizeEmailDomain(domain string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(strings.ToLower(strings.TrimSpace(domain)))
+	if err != nil {
+		return "", fmt.Errorf("failed to IDNA encode domain '%s': %w", domain, err)
+	}
+	return ascii, nil
+}
+
+// StripPlusAddressing removes the "+tag" suffix of the local part of a normalized address, for the features
+// treating user+tag@domain as user@domain, i.e. suppression lists.
+func StripPlusAddressing(normalized string) string {
+	at := strings.LastIndexByte(normalized, '@')
+	if at < 0 {
+		return normalized
+	}
+	if plus := strings.IndexByte(normalized[:at], '+'); plus > 0 {
+		return normalized[:plus] + normalized[at:]
+	}
+	return normalized
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{name: "normalized", address: "alice@example.com", want: "alice@example.com"},
+		{name: "mixed case domain", address: "alice@Example.COM", want: "alice@example.com"},
+		{name: "mixed case local part is kept", address: "Alice.Smith@example.com", want: "Alice.Smith@example.com"},
+		{name: "surrounding spaces", address: "  alice@example.com\t", want: "alice@example.com"},
+		{name: "display name", address: "Alice <alice@Example.com>", want: "alice@example.com"},
+		{name: "plus addressing is kept", address: "alice+news@example.com", want: "alice+news@example.com"},
+		{name: "IDN domain", address: "josé@Bücher.example", want: "josé@xn--bcher-kva.example"},
+		{name: "IDNA encoded domain", address: "bob@xn--bcher-kva.example", want: "bob@xn--bcher-kva.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeEmail(tt.address)
+			if err != nil {
+				t.Fatalf("NormalizeEmail(%q) error = %v", tt.address, err)
+			}
+			if got != tt.want {
+				t.Fatalf("NormalizeEmail(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeEmailRejectsInvalidAddresses(t *testing.T) {
+	for _, address := range []string{
+		"",
+		"alice",
+		"alice@",
+		"@example.com",
+		"alice@@example.com",
+		"alice@exa mple.com",
+		"alice@-example.com",
+		"Alice <alice@example.com",
+	} {
+		t.Run(address, func(t *testing.T) {
+			_, err := NormalizeEmail(address)
+			if !errors.Is(err, ErrInvalidEmail) {
+				t.Fatalf("NormalizeEmail(%q) error = %v, want %v", address, err, ErrInvalidEmail)
+			}
+			var invalid *InvalidEmailError
+			if !errors.As(err, &invalid) || invalid.Address != address {
+				t.Fatalf("NormalizeEmail(%q) error = %v, want an InvalidEmailError of the address", address, err)
+			}
+		})
+	}
+}
+
+func TestStripPlusAddressing(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: "alice+news@example.com", want: "alice@example.com"},
+		{address: "alice+news+weekly@example.com", want: "alice@example.com"},
+		{address: "alice@example.com", want: "alice@example.com"},
+		{address: "+news@example.com", want: "+news@example.com"}, // nothing would be left of the local part
+		{address: "alice@plus+domain.example", want: "alice@plus+domain.example"},
+		{address: "not an address", want: "not an address"},
+	}
+	for _, tt := range tests {
+		if got := StripPlusAddressing(tt.address); got != tt.want {
+			t.Errorf("StripPlusAddressing(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"runtime/debug"
+
+	"github.com/rs/zerolog"
+)
+
+// Go runs fn in a goroutine which recovers its panics, logging them with the stack trace, so a panicking
+// background task doesn't crash the process. Deferred calls of fn still run before the panic is recovered.
+func Go(logger *zerolog.Logger, name string, fn func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error().
+					Interface("recover", r).
+					Bytes("stack", debug.Stack()).
+					Msgf("panic recovered in goroutine '%s'", name)
+			}
+		}()
+		fn()
+	}()
+}
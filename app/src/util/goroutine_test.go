@@ -0,0 +1,78 @@
+package util
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// lineWriter hands each log line written by the logger to a channel.
+type lineWriter chan []byte
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestGoRecoversAndLogsPanic(t *testing.T) {
+	lines := make(lineWriter, 1)
+	logger := zerolog.New(lines)
+	deferred := make(chan struct{})
+
+	Go(&logger, "test.task", func() {
+		defer close(deferred)
+		panic("boom")
+	})
+
+	var line []byte
+	select {
+	case line = <-lines:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the panic to be logged")
+	}
+	select {
+	case <-deferred:
+	default:
+		t.Fatal("expected the deferred calls of the task to run before the panic is logged")
+	}
+
+	var entry struct {
+		Level   string `json:"level"`
+		Message string `json:"message"`
+		Recover string `json:"recover"`
+		Stack   string `json:"stack"`
+	}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, line: %s", err, line)
+	}
+	if entry.Level != zerolog.LevelErrorValue {
+		t.Errorf("level = %q, want %q", entry.Level, zerolog.LevelErrorValue)
+	}
+	if entry.Message != "panic recovered in goroutine 'test.task'" {
+		t.Errorf("message = %q", entry.Message)
+	}
+	if entry.Recover != "boom" {
+		t.Errorf("recover = %q, want %q", entry.Recover, "boom")
+	}
+	if !strings.Contains(entry.Stack, "TestGoRecoversAndLogsPanic") {
+		t.Errorf("expected the stack to contain the panicking function, got:\n%s", entry.Stack)
+	}
+}
+
+func TestGoDoesNotLogWithoutPanic(t *testing.T) {
+	lines := make(lineWriter, 1)
+	logger := zerolog.New(lines)
+	done := make(chan struct{})
+
+	Go(&logger, "test.task", func() { close(done) })
+
+	<-done
+	select {
+	case line := <-lines:
+		t.Fatalf("expected nothing to be logged, got: %s", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
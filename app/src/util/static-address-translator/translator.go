@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -21,8 +24,36 @@ const (
 	internalAddressTypeCIDR
 )
 const optionalPort uint16 = 0
+const missLogInterval = time.Minute
 
-var ErrPortOutOfRange = errors.New("port out of range")
+var (
+	ErrPortOutOfRange      = errors.New("port out of range")
+	ErrTranslationConflict = errors.New("conflicting address translations")
+)
+
+// CollisionPolicy tells which mapping is kept when internal addresses of several mappings resolve to the same
+// lookup key (i.e. "10.0.0.1:9092" and "[10.0.0.1]:9092") and map it to different external addresses. Mappings are
+// processed in the order of their internal addresses, so the resolution doesn't depend on map iteration.
+type CollisionPolicy uint8
+
+const (
+	CollisionPolicyFirstWins CollisionPolicy = iota
+	CollisionPolicyLastWins
+	CollisionPolicyError // the translator isn't created
+)
+
+// ParseCollisionPolicy returns the policy named "first_wins", "last_wins" or "error" in config, unknown names
+// keep the first mapping.
+func ParseCollisionPolicy(name string) CollisionPolicy {
+	switch name {
+	case "last_wins":
+		return CollisionPolicyLastWins
+	case "error":
+		return CollisionPolicyError
+	default:
+		return CollisionPolicyFirstWins
+	}
+}
 
 type parsedInternalAddress struct {
 	addressType internalAddressType
@@ -48,28 +79,42 @@ func parsePort(portStr string) (uint16, error) {
 	return uint16(port), nil
 }
 
+// parseInternalAddress parses an IP, hostname or CIDR, optionally followed by a port. IPv6 addresses and CIDRs
+// followed by a port must be bracketed (i.e. "[2001:db8::1]:9042"), as their colons are otherwise ambiguous.
 func parseInternalAddress(address string) (parsedInternalAddress, error) {
-	port := optionalPort
-	main := address
-
-	// Split optional port
-	if strings.Contains(address, ":") {
-		parts := strings.Split(address, ":")
-		main = parts[0]
-		p, err := parsePort(parts[1])
-		if err != nil {
-			return parsedInternalAddress{}, fmt.Errorf("invalid port in internal address %s: %w", address, err)
-		}
-		port = p
+	// addresses without port, IPv6 ones being full of colons
+	if parsed, ok := classifyInternalAddress(strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"), optionalPort); ok {
+		return parsed, nil
+	}
+	if !strings.Contains(address, ":") {
+		return parsedInternalAddress{addressType: internalAddressTypeHost, main: address, port: optionalPort}, nil
 	}
 
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return parsedInternalAddress{}, fmt.Errorf("invalid internal address %s: %w", address, err)
+	}
+	port, err := parsePort(portStr)
+	if err != nil {
+		return parsedInternalAddress{}, fmt.Errorf("invalid port in internal address %s: %w", address, err)
+	}
+
+	if parsed, ok := classifyInternalAddress(host, port); ok {
+		return parsed, nil
+	}
+	return parsedInternalAddress{addressType: internalAddressTypeHost, main: host, port: port}, nil
+}
+
+// classifyInternalAddress recognizes CIDRs and IPs, the latter being normalized to the form net.IP.String
+// produces, which is the one Translate looks them up by.
+func classifyInternalAddress(main string, port uint16) (parsedInternalAddress, bool) {
 	if _, _, err := net.ParseCIDR(main); err == nil {
-		return parsedInternalAddress{addressType: internalAddressTypeCIDR, main: main, port: port}, nil
+		return parsedInternalAddress{addressType: internalAddressTypeCIDR, main: main, port: port}, true
 	}
 	if ip := net.ParseIP(main); ip != nil {
-		return parsedInternalAddress{addressType: internalAddressTypeIP, main: main, port: port}, nil
+		return parsedInternalAddress{addressType: internalAddressTypeIP, main: ip.String(), port: port}, true
 	}
-	return parsedInternalAddress{addressType: internalAddressTypeHost, main: main, port: port}, nil
+	return parsedInternalAddress{}, false
 }
 
 func parseExternalAddress(address string, logger *zerolog.Logger) (parsedExternalAddress, error) {
@@ -111,35 +156,115 @@ func (e *cidrRangerHostEntry) Network() net.IPNet {
 	return e.network
 }
 
-type StaticAddressTranslator struct {
+// translationTable holds the mappings of a set of translations, it is never modified once built.
+type translationTable struct {
 	hostMapping map[string]addressMapping
 	cidrRanger  cidranger.Ranger
+}
+
+// StaticAddressTranslator translates internal addresses to external ones from a set of static translations.
+// The translations can be replaced with Update, each translation using the set in place when it started.
+type StaticAddressTranslator struct {
+	table       atomic.Pointer[translationTable]
+	policy      CollisionPolicy // #readonly
+	misses      atomic.Uint64
+	lastMissLog atomic.Int64 // unix nanos
 	logger      zerolog.Logger
 }
 
-func NewStaticAddressTranslator(translations map[string]string, logger *zerolog.Logger) *StaticAddressTranslator {
+type pendingMapping struct {
+	internal string // address as given in the translations
+	external string // address as given in the translations
+	mapping  addressMapping
+}
+
+func NewStaticAddressTranslator(
+	translations map[string]string, policy CollisionPolicy, logger *zerolog.Logger,
+) (*StaticAddressTranslator, error) {
 	translator := &StaticAddressTranslator{
+		policy: policy,
+		logger: *logger,
+	}
+	if err := translator.Update(translations); err != nil {
+		return nil, err
+	}
+	return translator, nil
+}
+
+// Update replaces the translations, i.e. after the NAT mappings changed on scale-out. The new set is built aside and
+// swapped in at once, so concurrent translations see either the previous or the new one. When the translations
+// conflict under CollisionPolicyError, the previous set is kept and the conflicts are returned.
+func (s *StaticAddressTranslator) Update(translations map[string]string) error {
+	table, err := s.buildTable(translations)
+	if err != nil {
+		return err
+	}
+	s.table.Store(table)
+	return nil
+}
+
+func (s *StaticAddressTranslator) buildTable(translations map[string]string) (*translationTable, error) {
+	table := &translationTable{
 		hostMapping: make(map[string]addressMapping),
 		cidrRanger:  cidranger.NewPCTrieRanger(),
-		logger:      *logger,
 	}
 
-	seenCIDRs := make(map[string]bool)
+	hosts := make(map[string]pendingMapping)
+	cidrs := make(map[string]pendingMapping)
+	networks := make(map[string]net.IPNet)
+
+	var conflicts []error
+	keep := func(kind, key string, pending map[string]pendingMapping, candidate pendingMapping) bool {
+		current, exists := pending[key]
+		if !exists {
+			return true
+		}
+		if current.mapping.external.ip.Equal(candidate.mapping.external.ip) &&
+			current.mapping.external.port == candidate.mapping.external.port {
+			s.logger.Debug().Msgf(
+				"Skipping duplicate %s '%s' associated with mapping: '%s' -> '%s'", kind, key, candidate.internal, candidate.external,
+			)
+			return false
+		}
+
+		conflict := fmt.Errorf(
+			"%w: %s '%s' is mapped by '%s' -> '%s' and '%s' -> '%s'",
+			ErrTranslationConflict, kind, key, current.internal, current.external, candidate.internal, candidate.external,
+		)
+		switch s.policy {
+		case CollisionPolicyLastWins:
+			s.logger.Warn().Msgf("%v, keeping the last one", conflict)
+			return true
+		case CollisionPolicyError:
+			conflicts = append(conflicts, conflict)
+			return false
+		default:
+			s.logger.Warn().Msgf("%v, keeping the first one", conflict)
+			return false
+		}
+	}
+
+	for _, internal := range slices.Sorted(maps.Keys(translations)) {
+		external := translations[internal]
 
-	for internal, external := range translations {
 		internalAddress, err := parseInternalAddress(internal)
 		if err != nil {
-			translator.logger.Warn().Err(err).Msgf("Failed to parse internal translation address '%s'", internal)
+			s.logger.Warn().Err(err).Msgf("Failed to parse internal translation address '%s'", internal)
 			continue
 		}
-		externalAddress, err := parseExternalAddress(external, &translator.logger)
+		externalAddress, err := parseExternalAddress(external, &s.logger)
 		if err != nil {
-			translator.logger.Warn().Err(err).Msgf(
+			s.logger.Warn().Err(err).Msgf(
 				"Failed to parse external translation address '%s' associated with internal address '%s'",
 				external, internal,
 			)
 			continue
 		}
+		externalEndpoint := networkEndpoint{
+			host: externalAddress.ip.String(),
+			ip:   externalAddress.ip,
+			port: externalAddress.port,
+		}
 
 		switch internalAddress.addressType {
 		case internalAddressTypeIP, internalAddressTypeHost:
@@ -148,67 +273,67 @@ func NewStaticAddressTranslator(translations map[string]string, logger *zerolog.
 				ip = net.ParseIP(internalAddress.main)
 			}
 
-			lookupKey := fmt.Sprintf("%s:%d", internalAddress.main, internalAddress.port)
-			if _, exists := translator.hostMapping[lookupKey]; exists {
-				translator.logger.Debug().Msgf("Skipping duplicate lookup key '%s' associated with mapping: '%s' -> '%s'", lookupKey, internal, external)
-				continue
-			}
-
-			translator.hostMapping[lookupKey] = addressMapping{
+			candidate := pendingMapping{internal: internal, external: external, mapping: addressMapping{
 				internal: networkEndpoint{
 					host: internalAddress.main,
 					ip:   ip,
 					port: internalAddress.port,
 				},
-				external: networkEndpoint{
-					host: externalAddress.ip.String(),
-					ip:   externalAddress.ip,
-					port: externalAddress.port,
-				},
+				external: externalEndpoint,
+			}}
+			lookupKey := fmt.Sprintf("%s:%d", internalAddress.main, internalAddress.port)
+			if keep("lookup key", lookupKey, hosts, candidate) {
+				hosts[lookupKey] = candidate
 			}
 
 		case internalAddressTypeCIDR:
-			if _, seen := seenCIDRs[internalAddress.main]; seen {
-				translator.logger.Debug().Msgf("Skipping duplicate CIDR entry '%s' associated with mapping: '%s' -> '%s'", internalAddress.main, internal, external)
-				continue
-			}
-			seenCIDRs[internalAddress.main] = true
-
 			ip, network, err := net.ParseCIDR(internalAddress.main)
 			if err != nil {
-				translator.logger.Warn().Err(err).Msgf("Failed to parse CIDR '%s' for internal address '%s'", internalAddress.main, internal)
+				s.logger.Warn().Err(err).Msgf("Failed to parse CIDR '%s' for internal address '%s'", internalAddress.main, internal)
 				continue
 			}
 
-			err = translator.cidrRanger.Insert(&cidrRangerHostEntry{
-				network: *network,
-				addressMapping: addressMapping{
-					internal: networkEndpoint{
-						host: internalAddress.main,
-						ip:   ip,
-						port: internalAddress.port,
-					},
-					external: networkEndpoint{
-						host: externalAddress.ip.String(),
-						ip:   externalAddress.ip,
-						port: externalAddress.port,
-					},
+			candidate := pendingMapping{internal: internal, external: external, mapping: addressMapping{
+				internal: networkEndpoint{
+					host: internalAddress.main,
+					ip:   ip,
+					port: internalAddress.port,
 				},
-			})
-			if err != nil {
-				translator.logger.Warn().Err(err).Msgf("Failed to insert CIDR entry for CIDR '%s'", internalAddress.main)
-				continue
+				external: externalEndpoint,
+			}}
+			if keep("CIDR", network.String(), cidrs, candidate) {
+				cidrs[network.String()] = candidate
+				networks[network.String()] = *network
 			}
 
 		default:
-			translator.logger.Warn().Msgf("Unknown internal address type for address '%s'", internal)
+			s.logger.Warn().Msgf("Unknown internal address type for address '%s'", internal)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, errors.Join(conflicts...)
+	}
+
+	for lookupKey, pending := range hosts {
+		table.hostMapping[lookupKey] = pending.mapping
+	}
+	for key, pending := range cidrs {
+		err := table.cidrRanger.Insert(&cidrRangerHostEntry{
+			network:        networks[key],
+			addressMapping: pending.mapping,
+		})
+		if err != nil {
+			s.logger.Warn().Err(err).Msgf("Failed to insert CIDR entry for CIDR '%s'", pending.mapping.internal.host)
 		}
 	}
 
-	return translator
+	return table, nil
 }
 
 func (s *StaticAddressTranslator) Translate(originalIP net.IP, originalPort uint16) (translatedIP net.IP, translatedPort uint16) {
+	table := s.table.Load()
+
 	// Attempt to find a direct match
 	hostnames := []string{originalIP.String()}
 
@@ -226,14 +351,14 @@ func (s *StaticAddressTranslator) Translate(originalIP net.IP, originalPort uint
 	for _, hostname := range hostnames {
 		for _, lookupPort := range lookupPorts {
 			lookupKey := fmt.Sprintf("%s:%d", hostname, lookupPort)
-			if translation, ok := s.hostMapping[lookupKey]; ok {
+			if translation, ok := table.hostMapping[lookupKey]; ok {
 				return translation.external.ip, translation.external.port
 			}
 		}
 	}
 
 	// Attempt to find a CIDR match
-	entries, err := s.cidrRanger.ContainingNetworks(originalIP)
+	entries, err := table.cidrRanger.ContainingNetworks(originalIP)
 	if err == nil {
 		for _, entry := range entries {
 			translation, ok := entry.(*cidrRangerHostEntry)
@@ -251,5 +376,55 @@ func (s *StaticAddressTranslator) Translate(originalIP net.IP, originalPort uint
 	}
 
 	// No translation found, return original
+	s.recordMiss(net.JoinHostPort(originalIP.String(), strconv.FormatUint(uint64(originalPort), 10)))
 	return originalIP, originalPort
 }
+
+// Misses returns the number of addresses returned untranslated because no mapping matched them, i.e. brokers
+// advertising addresses missing from the translations.
+func (s *StaticAddressTranslator) Misses() uint64 {
+	return s.misses.Load()
+}
+
+// recordMiss counts the untranslated address, logging it at most once per missLogInterval.
+func (s *StaticAddressTranslator) recordMiss(address string) {
+	misses := s.misses.Add(1)
+
+	now := time.Now().UnixNano()
+	last := s.lastMissLog.Load()
+	if now-last < int64(missLogInterval) || !s.lastMissLog.CompareAndSwap(last, now) {
+		return
+	}
+	s.logger.Debug().Msgf("No translation matched address '%s', %d addresses went untranslated so far", address, misses)
+}
+
+// TranslateHostPort translates a "host:port" address, as used by clients which dial by address (i.e. Kafka, NATS).
+// Hostnames are looked up in the static mappings as they are, while IPs go through Translate.
+func (s *StaticAddressTranslator) TranslateHostPort(address string) string {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		s.logger.Warn().Err(err).Msgf("failed to split address '%s', it won't be translated", address)
+		return address
+	}
+	port, err := parsePort(portStr)
+	if err != nil {
+		s.logger.Warn().Err(err).Msgf("failed to parse port of address '%s', it won't be translated", address)
+		return address
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		translatedIP, translatedPort := s.Translate(ip, port)
+		return net.JoinHostPort(translatedIP.String(), strconv.FormatUint(uint64(translatedPort), 10))
+	}
+
+	table := s.table.Load()
+	for _, lookupPort := range []uint16{port, optionalPort} {
+		lookupKey := fmt.Sprintf("%s:%d", host, lookupPort)
+		if translation, ok := table.hostMapping[lookupKey]; ok {
+			return net.JoinHostPort(translation.external.ip.String(), strconv.FormatUint(uint64(translation.external.port), 10))
+		}
+	}
+
+	s.recordMiss(address)
+	return address
+}
@@ -0,0 +1,360 @@
+package translator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParseInternalAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    parsedInternalAddress
+	}{
+		{
+			name:    "IPv4 host with port",
+			address: "10.0.0.1:9092",
+			want:    parsedInternalAddress{addressType: internalAddressTypeIP, main: "10.0.0.1", port: 9092},
+		},
+		{
+			name:    "IPv4 host without port",
+			address: "10.0.0.1",
+			want:    parsedInternalAddress{addressType: internalAddressTypeIP, main: "10.0.0.1", port: optionalPort},
+		},
+		{
+			name:    "IPv6 host with port",
+			address: "[2001:db8::1]:9042",
+			want:    parsedInternalAddress{addressType: internalAddressTypeIP, main: "2001:db8::1", port: 9042},
+		},
+		{
+			name:    "IPv6 host without port",
+			address: "2001:db8::1",
+			want:    parsedInternalAddress{addressType: internalAddressTypeIP, main: "2001:db8::1", port: optionalPort},
+		},
+		{
+			name:    "bracketed IPv6 host without port",
+			address: "[2001:db8::1]",
+			want:    parsedInternalAddress{addressType: internalAddressTypeIP, main: "2001:db8::1", port: optionalPort},
+		},
+		{
+			name:    "IPv6 host normalized",
+			address: "[2001:0db8:0000::0001]:9042",
+			want:    parsedInternalAddress{addressType: internalAddressTypeIP, main: "2001:db8::1", port: 9042},
+		},
+		{
+			name:    "IPv6 CIDR without port",
+			address: "2001:db8::/32",
+			want:    parsedInternalAddress{addressType: internalAddressTypeCIDR, main: "2001:db8::/32", port: optionalPort},
+		},
+		{
+			name:    "IPv6 CIDR with port",
+			address: "[2001:db8::/32]:9042",
+			want:    parsedInternalAddress{addressType: internalAddressTypeCIDR, main: "2001:db8::/32", port: 9042},
+		},
+		{
+			name:    "IPv4 CIDR with port",
+			address: "10.0.0.0/8:9092",
+			want:    parsedInternalAddress{addressType: internalAddressTypeCIDR, main: "10.0.0.0/8", port: 9092},
+		},
+		{
+			name:    "hostname with port",
+			address: "broker-1.internal:9092",
+			want:    parsedInternalAddress{addressType: internalAddressTypeHost, main: "broker-1.internal", port: 9092},
+		},
+		{
+			name:    "hostname without port",
+			address: "broker-1.internal",
+			want:    parsedInternalAddress{addressType: internalAddressTypeHost, main: "broker-1.internal", port: optionalPort},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInternalAddress(tt.address)
+			if err != nil {
+				t.Fatalf("parseInternalAddress(%s) error = %v", tt.address, err)
+			}
+			if got != tt.want {
+				t.Fatalf("parseInternalAddress(%s) = %+v, want %+v", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInternalAddressRejectsInvalidPorts(t *testing.T) {
+	for _, address := range []string{"[2001:db8::1]:0", "[2001:db8::1]:65536", "broker:http", "[2001:db8::/32]:x"} {
+		if _, err := parseInternalAddress(address); err == nil {
+			t.Errorf("parseInternalAddress(%s) error = nil, want invalid port", address)
+		}
+	}
+	if _, err := parseInternalAddress("[2001:db8::1]:0"); !errors.Is(err, ErrPortOutOfRange) {
+		t.Errorf("parseInternalAddress() error = %v, want %v", err, ErrPortOutOfRange)
+	}
+}
+
+func TestTranslateIPv6Mappings(t *testing.T) {
+	logger := zerolog.Nop()
+	translator, err := NewStaticAddressTranslator(map[string]string{
+		"[2001:db8::1]:9042":     "203.0.113.1:19042",
+		"2001:db8::2":            "203.0.113.2:19042",
+		"[2001:db8:1::/48]:9042": "[2001:db8:ffff::1]:29042",
+		"2001:db8:2::/48":        "203.0.113.3:39042",
+	}, CollisionPolicyError, &logger)
+	if err != nil {
+		t.Fatalf("NewStaticAddressTranslator() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		port     uint16
+		wantIP   string
+		wantPort uint16
+	}{
+		{name: "host with port", ip: "2001:db8::1", port: 9042, wantIP: "203.0.113.1", wantPort: 19042},
+		{name: "host with other port", ip: "2001:db8::1", port: 9043, wantIP: "2001:db8::1", wantPort: 9043},
+		{name: "host without port", ip: "2001:db8::2", port: 7000, wantIP: "203.0.113.2", wantPort: 19042},
+		{name: "CIDR with port", ip: "2001:db8:1::5", port: 9042, wantIP: "2001:db8:ffff::1", wantPort: 29042},
+		{name: "CIDR with other port", ip: "2001:db8:1::5", port: 9043, wantIP: "2001:db8:1::5", wantPort: 9043},
+		{name: "CIDR without port", ip: "2001:db8:2::5", port: 7000, wantIP: "203.0.113.3", wantPort: 39042},
+		{name: "unmapped", ip: "2001:db8:3::5", port: 9042, wantIP: "2001:db8:3::5", wantPort: 9042},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, port := translator.Translate(net.ParseIP(tt.ip), tt.port)
+			if !ip.Equal(net.ParseIP(tt.wantIP)) || port != tt.wantPort {
+				t.Fatalf("Translate(%s, %d) = (%s, %d), want (%s, %d)", tt.ip, tt.port, ip, port, tt.wantIP, tt.wantPort)
+			}
+		})
+	}
+
+	if got := translator.TranslateHostPort("[2001:db8:1::5]:9042"); got != "[2001:db8:ffff::1]:29042" {
+		t.Errorf("TranslateHostPort() = %s, want [2001:db8:ffff::1]:29042", got)
+	}
+}
+
+func TestParseCollisionPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		want CollisionPolicy
+	}{
+		{name: "first_wins", want: CollisionPolicyFirstWins},
+		{name: "last_wins", want: CollisionPolicyLastWins},
+		{name: "error", want: CollisionPolicyError},
+		{name: "", want: CollisionPolicyFirstWins},
+		{name: "unknown", want: CollisionPolicyFirstWins},
+	}
+	for _, tt := range tests {
+		if got := ParseCollisionPolicy(tt.name); got != tt.want {
+			t.Errorf("ParseCollisionPolicy(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCollidingTranslationsAreResolvedByPolicy(t *testing.T) {
+	// both pairs of internal addresses resolve to the same key, the first one in sorted order being "10.0.0.1:9092"
+	// and "10.0.0.0/8" respectively
+	colliding := map[string]string{
+		"10.0.0.1:9092":   "203.0.113.1:19092",
+		"[10.0.0.1]:9092": "203.0.113.2:19092",
+		"10.0.0.0/8":      "203.0.113.3:19092",
+		"10.1.2.3/8":      "203.0.113.4:19092",
+	}
+	tests := []struct {
+		name     string
+		policy   CollisionPolicy
+		wantHost string
+		wantCIDR string
+		wantErr  bool
+	}{
+		{name: "first wins", policy: CollisionPolicyFirstWins, wantHost: "203.0.113.1", wantCIDR: "203.0.113.3"},
+		{name: "last wins", policy: CollisionPolicyLastWins, wantHost: "203.0.113.2", wantCIDR: "203.0.113.4"},
+		{name: "error", policy: CollisionPolicyError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			// map iteration differs between runs, the resolution must not
+			for range 20 {
+				translator, err := NewStaticAddressTranslator(colliding, tt.policy, &logger)
+				if tt.wantErr {
+					if !errors.Is(err, ErrTranslationConflict) {
+						t.Fatalf("NewStaticAddressTranslator() error = %v, want %v", err, ErrTranslationConflict)
+					}
+					for _, key := range []string{"lookup key '10.0.0.1:9092'", "CIDR '10.0.0.0/8'"} {
+						if !strings.Contains(err.Error(), key) {
+							t.Fatalf("NewStaticAddressTranslator() error = %v, want the conflict on %s to be reported", err, key)
+						}
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("NewStaticAddressTranslator() error = %v", err)
+				}
+
+				// the table is read directly, Translate looking up the hostnames of the IPs on each call
+				table := translator.table.Load()
+				if ip := table.hostMapping["10.0.0.1:9092"].external.ip; !ip.Equal(net.ParseIP(tt.wantHost)) {
+					t.Fatalf("translation of 10.0.0.1:9092 = %s, want %s", ip, tt.wantHost)
+				}
+				entries, err := table.cidrRanger.ContainingNetworks(net.ParseIP("10.9.9.9"))
+				if err != nil || len(entries) != 1 {
+					t.Fatalf("CIDR translations of 10.9.9.9 = (%v, %v), want a single one", entries, err)
+				}
+				if ip := entries[0].(*cidrRangerHostEntry).external.ip; !ip.Equal(net.ParseIP(tt.wantCIDR)) {
+					t.Fatalf("translation of 10.9.9.9 = %s, want %s", ip, tt.wantCIDR)
+				}
+			}
+		})
+	}
+}
+
+func TestIdenticalDuplicateTranslationsDontConflict(t *testing.T) {
+	logger := zerolog.Nop()
+	translator, err := NewStaticAddressTranslator(map[string]string{
+		"10.0.0.1:9092":   "203.0.113.1:19092",
+		"[10.0.0.1]:9092": "203.0.113.1:19092",
+	}, CollisionPolicyError, &logger)
+	if err != nil {
+		t.Fatalf("NewStaticAddressTranslator() error = %v, want duplicates mapping to the same address to be skipped", err)
+	}
+	if ip, port := translator.Translate(net.ParseIP("10.0.0.1"), 9092); !ip.Equal(net.ParseIP("203.0.113.1")) || port != 19092 {
+		t.Fatalf("Translate(10.0.0.1, 9092) = (%s, %d), want (203.0.113.1, 19092)", ip, port)
+	}
+}
+
+func TestUpdateKeepsPreviousTranslationsOnConflict(t *testing.T) {
+	logger := zerolog.Nop()
+	translator, err := NewStaticAddressTranslator(map[string]string{"10.0.0.1:9092": "203.0.113.1:19092"}, CollisionPolicyError, &logger)
+	if err != nil {
+		t.Fatalf("NewStaticAddressTranslator() error = %v", err)
+	}
+
+	err = translator.Update(map[string]string{
+		"10.0.0.1:9092":   "203.0.113.2:19092",
+		"[10.0.0.1]:9092": "203.0.113.3:19092",
+	})
+	if !errors.Is(err, ErrTranslationConflict) {
+		t.Fatalf("Update() error = %v, want %v", err, ErrTranslationConflict)
+	}
+	if ip, _ := translator.Translate(net.ParseIP("10.0.0.1"), 9092); !ip.Equal(net.ParseIP("203.0.113.1")) {
+		t.Fatalf("Translate(10.0.0.1) after failed Update() = %s, want the previous translation 203.0.113.1", ip)
+	}
+}
+
+func TestMissesCountUntranslatedAddresses(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs).Level(zerolog.DebugLevel)
+	translator, err := NewStaticAddressTranslator(map[string]string{
+		"192.0.2.1:9092":         "203.0.113.1:19092",
+		"broker-1.internal:9092": "203.0.113.2:19092",
+	}, CollisionPolicyFirstWins, &logger)
+	if err != nil {
+		t.Fatalf("NewStaticAddressTranslator() error = %v", err)
+	}
+
+	translator.Translate(net.ParseIP("192.0.2.1"), 9092)
+	translator.TranslateHostPort("broker-1.internal:9092")
+	if misses := translator.Misses(); misses != 0 {
+		t.Fatalf("Misses() after mapped translations = %d, want 0", misses)
+	}
+
+	translator.Translate(net.ParseIP("192.0.2.2"), 9092)
+	translator.Translate(net.ParseIP("192.0.2.1"), 9093)
+	translator.TranslateHostPort("broker-2.internal:9092")
+	if misses := translator.Misses(); misses != 3 {
+		t.Fatalf("Misses() after unmapped translations = %d, want 3", misses)
+	}
+	// misses are logged at most once per interval
+	if lines := strings.Count(logs.String(), "No translation matched address"); lines != 1 {
+		t.Fatalf("logged %d misses, want a single debounced log:\n%s", lines, logs.String())
+	}
+	if !strings.Contains(logs.String(), "192.0.2.2:9092") {
+		t.Fatalf("logged misses = %s, want the first unmatched address to be logged", logs.String())
+	}
+
+	// as if the interval passed since the last log
+	translator.lastMissLog.Store(time.Now().Add(-missLogInterval).UnixNano())
+	translator.Translate(net.ParseIP("192.0.2.3"), 9092)
+	if lines := strings.Count(logs.String(), "No translation matched address"); lines != 2 {
+		t.Fatalf("logged %d misses once the interval passed, want 2:\n%s", lines, logs.String())
+	}
+	if !strings.Contains(logs.String(), "4 addresses went untranslated so far") {
+		t.Fatalf("logged misses = %s, want the count of misses to be logged", logs.String())
+	}
+}
+
+func TestUpdateConcurrentlyWithTranslations(t *testing.T) {
+	sets := []map[string]string{
+		{"broker-1.internal:9092": "203.0.113.1:19092", "192.0.2.0/24": "203.0.113.11:19092"},
+		{"broker-1.internal:9092": "198.51.100.1:29092", "192.0.2.0/24": "198.51.100.11:29092"},
+	}
+	logger := zerolog.Nop()
+	translator, err := NewStaticAddressTranslator(sets[0], CollisionPolicyError, &logger)
+	if err != nil {
+		t.Fatalf("NewStaticAddressTranslator() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var translations atomic.Int64
+	stop := make(chan struct{})
+	errs := make(chan error, 8)
+	for range 4 {
+		wg.Go(func() {
+			for idx := 0; ; idx++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// every translation uses one of the sets as a whole, neither a missing nor a partial one
+				if got := translator.TranslateHostPort("broker-1.internal:9092"); got != "203.0.113.1:19092" && got != "198.51.100.1:29092" {
+					errs <- fmt.Errorf("TranslateHostPort() = %s, want the translation of one of the sets", got)
+					return
+				}
+				translations.Add(1)
+
+				// IPs are translated less often, Translate looking up their hostnames first
+				if idx%50 != 0 {
+					continue
+				}
+				ip, port := translator.Translate(net.ParseIP("192.0.2.7"), 9092)
+				if !(ip.Equal(net.ParseIP("203.0.113.11")) && port == 19092) && !(ip.Equal(net.ParseIP("198.51.100.11")) && port == 29092) {
+					errs <- fmt.Errorf("Translate() = (%s, %d), want the translation of one of the sets", ip, port)
+					return
+				}
+			}
+		})
+	}
+	// the sets keep being swapped until the translations overlapped enough updates
+	for idx := 0; idx < 200 || translations.Load() < 1000; idx++ {
+		if err := translator.Update(sets[(idx+1)%len(sets)]); err != nil {
+			t.Errorf("Update() error = %v", err)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if err := translator.Update(sets[0]); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got := translator.TranslateHostPort("broker-1.internal:9092"); got != "203.0.113.1:19092" {
+		t.Fatalf("TranslateHostPort() after the updates = %s, want the translation of the last set", got)
+	}
+	if misses := translator.Misses(); misses != 0 {
+		t.Fatalf("Misses() = %d, want no translation to miss while updating", misses)
+	}
+}